@@ -0,0 +1,22 @@
+//go:build embedfrontend
+
+// Package client exposes the built frontend so it can be embedded into the server binary.
+// Build the frontend first (bun run build), then build the server with -tags embedfrontend.
+package client
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist
+var dist embed.FS
+
+// Dist returns the embedded frontend build, or false when it was not embedded
+func Dist() (fs.FS, bool) {
+	sub, err := fs.Sub(dist, "dist")
+	if err != nil {
+		return nil, false
+	}
+	return sub, true
+}
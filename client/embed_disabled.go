@@ -0,0 +1,10 @@
+//go:build !embedfrontend
+
+package client
+
+import "io/fs"
+
+// Dist returns the embedded frontend build, or false when it was not embedded
+func Dist() (fs.FS, bool) {
+	return nil, false
+}
@@ -13,6 +13,7 @@ api.interceptors.request.use((config) => {
   if (apiKey) {
     config.headers['X-API-Key'] = apiKey;
   }
+  config.headers['Accept-Language'] = navigator.language;
   return config;
 });
 
@@ -1,18 +1,19 @@
 import api from './client';
-import type { File, FilesResponse } from '../types';
+import type { File, FileKind, FilesResponse, FolderColor, FolderMeta, MediaUrlsResponse } from '../types';
 
 export interface GetFilesParams {
   page?: number;
   pageSize?: number;
   folder?: string;
-  sortBy?: 'name' | 'size' | 'created_at' | 'updated_at';
+  sortBy?: 'name' | 'size' | 'kind' | 'created_at' | 'updated_at';
   sortOrder?: 'asc' | 'desc';
+  kind?: FileKind;
 }
 
 export const getFiles = async (params: GetFilesParams = {}): Promise<FilesResponse> => {
-  const { page = 1, pageSize = 20, folder = '', sortBy = 'created_at', sortOrder = 'desc' } = params;
+  const { page = 1, pageSize = 20, folder = '', sortBy = 'created_at', sortOrder = 'desc', kind } = params;
   const response = await api.get('/files', {
-    params: { page, page_size: pageSize, folder, sort_by: sortBy, sort_order: sortOrder },
+    params: { page, page_size: pageSize, folder, sort_by: sortBy, sort_order: sortOrder, kind },
   });
   return response.data;
 };
@@ -22,9 +23,28 @@ export const getFile = async (id: number): Promise<File> => {
   return response.data;
 };
 
+// Signed URLs for <img> and <video> tags, which keep working when uploads are private
+export const getMediaUrls = async (ids: number[]): Promise<MediaUrlsResponse> => {
+  const response = await api.post('/files/media-urls', { ids });
+  return response.data;
+};
+
 export const getFolders = async (): Promise<string[]> => {
   const response = await api.get('/folders');
-  return response.data.folders || [];
+  return response.data.items || [];
+};
+
+export const getFolderMeta = async (): Promise<FolderMeta[]> => {
+  const response = await api.get('/folders');
+  return response.data.meta || [];
+};
+
+export const updateFolderMeta = async (
+  path: string,
+  changes: { starred?: boolean; color?: FolderColor | ''; description?: string }
+): Promise<FolderMeta> => {
+  const response = await api.put('/folders/meta', { path, ...changes });
+  return response.data.folder;
 };
 
 export const uploadFile = async (file: globalThis.File, folderPath?: string): Promise<{ message: string; file: File }> => {
@@ -80,8 +100,8 @@ export const renameFolder = async (path: string, newName: string): Promise<{ mes
   return response.data;
 };
 
-export const deleteFolder = async (path: string): Promise<{ message: string }> => {
-  const response = await api.delete('/folders', { data: { path } });
+export const deleteFolder = async (path: string, confirmToken?: string): Promise<{ message: string }> => {
+  const response = await api.delete('/folders', { params: { path, confirm_token: confirmToken } });
   return response.data;
 };
 
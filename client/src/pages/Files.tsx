@@ -1,12 +1,12 @@
 import { useState, useEffect, useMemo } from 'react';
-import type { File as FileType, FolderNode, Pagination } from '../types';
+import type { File as FileType, FileKind, FolderNode, Pagination } from '../types';
 import type { GetFilesParams } from '../api/files';
-import { getFiles, getFolders, deleteFile, downloadFile, renameFile, renameFolder, deleteFolder } from '../api/files';
+import { getFiles, getFolders, getMediaUrls, deleteFile, downloadFile, renameFile, renameFolder, deleteFolder } from '../api/files';
 import UploadModal from '../components/UploadModal';
 import RenameModal from '../components/RenameModal';
 import FileEditor from '../components/FileEditor';
 import {
-  FileIcon, Image, FileText, Archive, Trash2, Download, ChevronRight, ChevronLeft,
+  FileIcon, Image, FileText, FileCode, Film, Music, Archive, Trash2, Download, ChevronRight, ChevronLeft,
   Loader2, Eye, Upload, CheckSquare, Square, X, Folder, FolderOpen, 
   ChevronDown, ChevronUp, Edit3, MoreVertical, FileEdit, Link, Copy
 } from 'lucide-react';
@@ -29,11 +29,16 @@ function formatDate(date: string): string {
   });
 }
 
-function getFileIcon(mimeType: string) {
-  if (mimeType.startsWith('image/')) return Image;
-  if (mimeType.includes('pdf') || mimeType.includes('document') || mimeType.includes('text')) return FileText;
-  if (mimeType.includes('zip') || mimeType.includes('archive')) return Archive;
-  return FileIcon;
+function getFileIcon(kind: FileKind) {
+  switch (kind) {
+    case 'image': return Image;
+    case 'video': return Film;
+    case 'audio': return Music;
+    case 'document': return FileText;
+    case 'archive': return Archive;
+    case 'code': return FileCode;
+    default: return FileIcon;
+  }
 }
 
 function isTextFile(file: FileType): boolean {
@@ -189,6 +194,7 @@ type SortOrder = 'asc' | 'desc';
 
 export default function Files() {
   const [files, setFiles] = useState<FileType[]>([]);
+  const [mediaUrls, setMediaUrls] = useState<Record<number, string>>({});
   const [folders, setFolders] = useState<string[]>([]);
   const [pagination, setPagination] = useState<Pagination | null>(null);
   const [loading, setLoading] = useState(true);
@@ -238,7 +244,7 @@ export default function Files() {
         sortOrder,
         ...params,
       });
-      setFiles(data.files || []);
+      setFiles(data.items || []);
       setPagination(data.pagination);
       setSelectedIds(new Set());
     } catch (error) {
@@ -265,6 +271,25 @@ export default function Files() {
     fetchFiles({ page: 1 });
   }, [currentFolder, sortBy, sortOrder]);
 
+  // Images are shown through signed URLs, renewed before they expire
+  useEffect(() => {
+    const ids = files.filter(f => f.kind === 'image').map(f => f.id);
+    if (ids.length === 0) return;
+    let timer: ReturnType<typeof setTimeout>;
+    const refresh = async () => {
+      try {
+        const data = await getMediaUrls(ids);
+        setMediaUrls(data.urls);
+        const renewIn = new Date(data.expires_at).getTime() - Date.now() - 60_000;
+        timer = setTimeout(refresh, Math.max(renewIn, 30_000));
+      } catch (error) {
+        console.error('Failed to fetch media URLs:', error);
+      }
+    };
+    refresh();
+    return () => clearTimeout(timer);
+  }, [files]);
+
   const toggleSelect = (id: number) => {
     const newSelected = new Set(selectedIds);
     if (newSelected.has(id)) {
@@ -623,8 +648,8 @@ export default function Files() {
                     </thead>
                     <tbody>
                       {files.map(file => {
-                        const Icon = getFileIcon(file.mime_type);
-                        const isImage = file.mime_type.startsWith('image/');
+                        const Icon = getFileIcon(file.kind);
+                        const isImage = file.kind === 'image';
                         const isEditable = isTextFile(file);
                         const isSelected = selectedIds.has(file.id);
                         
@@ -651,7 +676,7 @@ export default function Files() {
                               <div className="flex items-center gap-3">
                                 {isImage ? (
                                   <img
-                                    src={file.url}
+                                    src={mediaUrls[file.id] ?? file.url}
                                     alt={file.original_name}
                                     className="w-8 h-8 object-cover rounded"
                                   />
@@ -787,7 +812,7 @@ export default function Files() {
         >
           <div className="max-w-4xl max-h-full">
             <img
-              src={previewFile.url}
+              src={mediaUrls[previewFile.id] ?? previewFile.url}
               alt={previewFile.original_name}
               className="max-w-full max-h-[80vh] object-contain rounded-lg"
             />
@@ -41,6 +41,8 @@ function normalizeNumberInput(value: string): string {
 
 export default function Settings() {
   const { user } = useAuth();
+  // Keys are only returned when issued, so show the one this browser signs in with
+  const apiKey = localStorage.getItem('api_key') || '';
   const [showKey, setShowKey] = useState(false);
   const [copied, setCopied] = useState(false);
   const [regenerating, setRegenerating] = useState(false);
@@ -87,8 +89,8 @@ export default function Settings() {
   }, []);
 
   const copyApiKey = () => {
-    if (user?.api_key) {
-      navigator.clipboard.writeText(user.api_key);
+    if (apiKey) {
+      navigator.clipboard.writeText(apiKey);
       setCopied(true);
       setTimeout(() => setCopied(false), 2000);
     }
@@ -98,7 +100,9 @@ export default function Settings() {
     setRegenerating(true);
     try {
       const { user: updatedUser } = await regenerateApiKey();
-      localStorage.setItem('api_key', updatedUser.api_key);
+      if (updatedUser.api_key) {
+        localStorage.setItem('api_key', updatedUser.api_key);
+      }
       window.location.reload();
     } catch (error) {
       console.error('Failed to regenerate API key:', error);
@@ -378,7 +382,7 @@ export default function Settings() {
         <div className="flex items-center gap-2 mb-4">
           <input
             type={showKey ? 'text' : 'password'}
-            value={user.api_key}
+            value={apiKey}
             readOnly
             className="flex-1 px-4 py-2 bg-gray-700 border border-gray-600 rounded-lg text-white font-mono text-sm"
           />
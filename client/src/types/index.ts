@@ -2,7 +2,8 @@ export interface User {
   id: number;
   username: string;
   email: string;
-  api_key: string;
+  // Only set in responses that issue a new key
+  api_key?: string;
   max_files: number;
   max_file_size: number;
   max_storage: number;
@@ -24,6 +25,8 @@ export interface UserSettings {
   max_storage: number;
 }
 
+export type FileKind = 'image' | 'video' | 'audio' | 'document' | 'archive' | 'code' | 'other';
+
 export interface File {
   id: number;
   user_id: number;
@@ -33,8 +36,10 @@ export interface File {
   folder_path: string;
   file_size: number;
   mime_type: string;
+  kind: FileKind;
   url: string;
   created_at: string;
+  updated_at: string;
 }
 
 export interface Pagination {
@@ -44,9 +49,29 @@ export interface Pagination {
   total_pages: number;
 }
 
-export interface FilesResponse {
-  files: File[];
+// Envelope of every list endpoint
+export interface ListResponse<T> {
+  items: T[];
   pagination: Pagination;
+  filters: Record<string, string>;
+  sort?: { by: string; order: string };
+}
+
+export type FilesResponse = ListResponse<File>;
+
+export interface MediaUrlsResponse {
+  urls: Record<number, string>;
+  expires_at: string;
+}
+
+export type FolderColor = 'red' | 'orange' | 'yellow' | 'green' | 'blue' | 'purple' | 'pink' | 'gray';
+
+export interface FolderMeta {
+  path: string;
+  starred: boolean;
+  color?: FolderColor;
+  description?: string;
+  updated_at: string;
 }
 
 export interface FolderNode {
@@ -1,15 +1,27 @@
 package main
 
 import (
-	"fmt"
+	"context"
 	"log"
 	"os"
 	"path/filepath"
+	"storage-service/client"
 	"storage-service/internal/config"
+	"storage-service/internal/coord"
+	"storage-service/internal/detect"
+	"storage-service/internal/events"
 	"storage-service/internal/handler"
+	"storage-service/internal/mail"
 	"storage-service/internal/middleware"
+	"storage-service/internal/notify"
+	"storage-service/internal/ratelimit"
 	"storage-service/internal/repository"
+	"storage-service/internal/scan"
+	"storage-service/internal/server"
 	"storage-service/internal/service"
+	"storage-service/internal/storage"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -30,31 +42,376 @@ func main() {
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
 	fileRepo := repository.NewFileRepository(db)
+	blobRepo := repository.NewBlobRepository(db)
+	variantRepo := repository.NewVariantRepository(db)
+	jobRepo := repository.NewJobRepository(db)
+	proxyCacheRepo := repository.NewProxyCacheRepository(db)
+	folderRuleRepo := repository.NewFolderRuleRepository(db)
+	folderRepo := repository.NewFolderRepository(db)
+	exifRepo := repository.NewImageMetadataRepository(db)
+	versionRepo := repository.NewVersionRepository(db)
+	tagRepo := repository.NewTagRepository(db)
+	shareRepo := repository.NewShareRepository(db)
+	uploadLinkRepo := repository.NewUploadLinkRepository(db)
+	passwordResetRepo := repository.NewPasswordResetRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	settingRepo := repository.NewSettingRepository(db)
+	apiKeyRepo := repository.NewAPIKeyRepository(db)
+
+	detector, err := detect.NewDetector(cfg.MimeDetector)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if _, err := detect.ParsePolicy(cfg.MimeMismatchPolicy); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if _, err := service.ParseSizeLimits(cfg.SizeLimits); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	minFreeSpace, err := service.ParseMinFreeSpace(cfg.MinFreeSpace)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	variantSizes, err := service.ParseVariantSizes(cfg.ThumbnailSizes)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	plans, err := service.ParsePlans(cfg.Plans, cfg.DefaultPlan)
+	if err != nil {
+		log.Fatalf("Invalid configuration: PLANS: %v", err)
+	}
+	billingPrices, err := service.ParseBillingPrices(cfg.StripePrices, plans, cfg.DefaultPlan)
+	if err != nil {
+		log.Fatalf("Invalid configuration: STRIPE_PRICES: %v", err)
+	}
+	if cfg.StripeSecretKey != "" && cfg.StripeWebhookSecret == "" {
+		log.Fatalf("Invalid configuration: STRIPE_WEBHOOK_SECRET is required with STRIPE_SECRET_KEY")
+	}
+	if cfg.BillingGracePeriod < 0 {
+		log.Fatalf("Invalid configuration: BILLING_GRACE_PERIOD must not be negative, got %s", cfg.BillingGracePeriod)
+	}
+	if cfg.BillingUsageInterval <= 0 {
+		log.Fatalf("Invalid configuration: BILLING_USAGE_INTERVAL must be a positive duration, got %s", cfg.BillingUsageInterval)
+	}
+	imageProfiles, err := service.ParseImageProfiles(cfg.ImageProfiles)
+	if err != nil {
+		log.Fatalf("Invalid configuration: IMAGE_PROFILES: %v", err)
+	}
+	if err := service.CheckColorMode(cfg.ImageColorMode); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if err := service.CheckAnimatedGIFMode(cfg.AnimatedGIFs); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	imageProxyMaxSize, err := service.ParseByteSize(cfg.ImageProxyMaxSize)
+	if err != nil {
+		log.Fatalf("Invalid configuration: IMAGE_PROXY_MAX_SIZE: %v", err)
+	}
+	if cfg.PasswordResetTTL <= 0 {
+		log.Fatalf("Invalid configuration: PASSWORD_RESET_TTL must be a positive duration, got %s", cfg.PasswordResetTTL)
+	}
+	if cfg.AccessTokenTTL <= 0 {
+		log.Fatalf("Invalid configuration: ACCESS_TOKEN_TTL must be a positive duration, got %s", cfg.AccessTokenTTL)
+	}
+	if cfg.SessionTTL <= 0 {
+		log.Fatalf("Invalid configuration: SESSION_TTL must be a positive duration, got %s", cfg.SessionTTL)
+	}
+	if cfg.ImpersonationTTL <= 0 {
+		log.Fatalf("Invalid configuration: IMPERSONATION_TTL must be a positive duration, got %s", cfg.ImpersonationTTL)
+	}
+	if cfg.DirectUploadTTL <= 0 {
+		log.Fatalf("Invalid configuration: DIRECT_UPLOAD_TTL must be a positive duration, got %s", cfg.DirectUploadTTL)
+	}
+	scratchTTL, err := service.ParseExpireAfter(cfg.ScratchTTL)
+	if err != nil {
+		log.Fatalf("Invalid configuration: SCRATCH_TTL: %v", err)
+	}
+	scratchMaxTTL, err := service.ParseExpireAfter(cfg.ScratchMaxTTL)
+	if err != nil || scratchMaxTTL < scratchTTL {
+		log.Fatalf("Invalid configuration: SCRATCH_MAX_TTL must be a duration of at least SCRATCH_TTL, got %q", cfg.ScratchMaxTTL)
+	}
+	scratchQuota, err := service.ParseByteSize(cfg.ScratchQuota)
+	if err != nil {
+		log.Fatalf("Invalid configuration: SCRATCH_QUOTA: %v", err)
+	}
+	if cfg.TempFileMaxAge <= 0 {
+		log.Fatalf("Invalid configuration: TEMP_FILE_MAX_AGE must be a positive duration, got %s", cfg.TempFileMaxAge)
+	}
+	tempStore := service.NewTempStore(cfg)
+	if err := tempStore.Check(cfg.UploadPath); err != nil {
+		log.Fatalf("Invalid configuration: TEMP_UPLOAD_PATH: %v", err)
+	}
+	if cfg.SlowRequestThreshold < 0 {
+		log.Fatalf("Invalid configuration: SLOW_REQUEST_THRESHOLD must not be negative, got %s", cfg.SlowRequestThreshold)
+	}
+	if cfg.RequestTimeout < 0 {
+		log.Fatalf("Invalid configuration: REQUEST_TIMEOUT must not be negative, got %s", cfg.RequestTimeout)
+	}
+	if cfg.TransferTimeout < 0 {
+		log.Fatalf("Invalid configuration: TRANSFER_TIMEOUT must not be negative, got %s", cfg.TransferTimeout)
+	}
+	if cfg.StorageRetryDelay <= 0 {
+		log.Fatalf("Invalid configuration: STORAGE_RETRY_DELAY must be a positive duration, got %s", cfg.StorageRetryDelay)
+	}
+	if cfg.StorageBreakerCooldown <= 0 {
+		log.Fatalf("Invalid configuration: STORAGE_BREAKER_COOLDOWN must be a positive duration, got %s", cfg.StorageBreakerCooldown)
+	}
+	// S3 secret keys derive from APP_SECRET, so a generated one would change
+	// them on every restart and differ between instances
+	if cfg.S3Listen != "" && cfg.AppSecretGenerated {
+		log.Fatalf("Invalid configuration: APP_SECRET is required with S3_LISTEN")
+	}
+	derivedCacheMaxSize, err := service.ParseDerivedCacheMaxSize(cfg.DerivedCacheMaxSize)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.VariantCacheTTL < 0 {
+		log.Fatalf("Invalid configuration: VARIANT_CACHE_TTL must not be negative, got %s", cfg.VariantCacheTTL)
+	}
+	compression, compressionMinSize, err := service.ParseCompression(cfg.Compression, cfg.CompressionMinSize)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	var uploadRateLimit int64
+	if cfg.RateLimitUploadBytes != "" && cfg.RateLimitUploadBytes != "0" {
+		if uploadRateLimit, err = service.ParseByteSize(cfg.RateLimitUploadBytes); err != nil {
+			log.Fatalf("Invalid configuration: RATE_LIMIT_UPLOAD_BYTES: %v", err)
+		}
+	}
+	var rateLimiter *middleware.RateLimiter
+	if cfg.RateLimitRequests > 0 || uploadRateLimit > 0 {
+		limiter, err := ratelimit.New(cfg.RateLimitBackend, cfg.RedisURL)
+		if err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		rateLimiter = middleware.NewRateLimiter(limiter, cfg.RateLimitRequests, uploadRateLimit)
+	}
+	var anonymousMaxSize int64
+	if cfg.AnonymousUploads {
+		if cfg.AnonymousUploadUserID == 0 || cfg.CaptchaSecret == "" {
+			log.Fatalf("Invalid configuration: ANONYMOUS_UPLOADS needs ANONYMOUS_UPLOAD_USER_ID and CAPTCHA_SECRET")
+		}
+		if anonymousMaxSize, err = service.ParseByteSize(cfg.AnonymousMaxSize); err != nil {
+			log.Fatalf("Invalid configuration: ANONYMOUS_MAX_SIZE: %v", err)
+		}
+		if _, err := service.ParseExpireAfter(cfg.AnonymousShareExpiry); err != nil {
+			log.Fatalf("Invalid configuration: ANONYMOUS_SHARE_EXPIRY: %v", err)
+		}
+	}
+	if cfg.PreviousUploadPath != "" && filepath.Clean(cfg.PreviousUploadPath) == filepath.Clean(cfg.UploadPath) {
+		log.Fatalf("Invalid configuration: PREVIOUS_UPLOAD_PATH must differ from UPLOAD_PATH")
+	}
+	if cfg.ImportRoot != "" {
+		if info, err := os.Stat(cfg.ImportRoot); err != nil || !info.IsDir() {
+			log.Fatalf("Invalid configuration: IMPORT_ROOT must be an existing directory, got %q", cfg.ImportRoot)
+		}
+	}
+	if cfg.ComplianceExportPath != "" {
+		if info, err := os.Stat(cfg.ComplianceExportPath); err != nil || !info.IsDir() {
+			log.Fatalf("Invalid configuration: COMPLIANCE_EXPORT_PATH must be an existing directory, got %q", cfg.ComplianceExportPath)
+		}
+	}
+	if cfg.BlobGCInterval < 0 {
+		log.Fatalf("Invalid configuration: BLOB_GC_INTERVAL must not be negative, got %s", cfg.BlobGCInterval)
+	}
+	if cfg.BlobGCGrace <= 0 {
+		log.Fatalf("Invalid configuration: BLOB_GC_GRACE must be a positive duration, got %s", cfg.BlobGCGrace)
+	}
+	if cfg.ClamdTimeout <= 0 {
+		log.Fatalf("Invalid configuration: CLAMD_TIMEOUT must be a positive duration, got %s", cfg.ClamdTimeout)
+	}
+	scanner, err := scan.NewScanner(cfg.ClamdAddress, cfg.ClamdTimeout)
+	if err != nil {
+		log.Fatalf("Invalid configuration: CLAMD_ADDRESS: %v", err)
+	}
+	eventPublishers, err := notify.NewPublishers(cfg.EventSinks)
+	if err != nil {
+		log.Fatalf("Invalid configuration: EVENT_SINKS: %v", err)
+	}
+	eventTypes, err := service.ParseEventTypes(cfg.EventTypes)
+	if err != nil {
+		log.Fatalf("Invalid configuration: EVENT_TYPES: %v", err)
+	}
+	if cfg.ReplicaVerifyInterval <= 0 {
+		log.Fatalf("Invalid configuration: REPLICA_VERIFY_INTERVAL must be a positive duration, got %s", cfg.ReplicaVerifyInterval)
+	}
+	if cfg.MediaURLTTL <= 0 {
+		log.Fatalf("Invalid configuration: MEDIA_URL_TTL must be a positive duration, got %s", cfg.MediaURLTTL)
+	}
+	if cfg.SignedURLTTL <= 0 || cfg.SignedURLTTL > service.MaxSignedURLTTL {
+		log.Fatalf("Invalid configuration: SIGNED_URL_TTL must be a positive duration of at most %s, got %s", service.MaxSignedURLTTL, cfg.SignedURLTTL)
+	}
+	alertRules, err := service.ParseAlertRules(cfg.AlertRules)
+	if err != nil {
+		log.Fatalf("Invalid configuration: ALERT_RULES: %v", err)
+	}
+	if cfg.AlertInterval <= 0 {
+		log.Fatalf("Invalid configuration: ALERT_INTERVAL must be a positive duration, got %s", cfg.AlertInterval)
+	}
+
+	backend, err := storage.New(cfg.StorageBackend, cfg.UploadPath, storage.S3Config{
+		Bucket:          cfg.S3Bucket,
+		Region:          cfg.S3Region,
+		Endpoint:        cfg.S3Endpoint,
+		AccessKeyID:     cfg.S3AccessKeyID,
+		SecretAccessKey: cfg.S3SecretAccessKey,
+		Prefix:          cfg.S3Prefix,
+		PathStyle:       cfg.S3PathStyle,
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// These work on the files in UPLOAD_PATH, which is only a cache with s3
+	if cfg.StorageBackend == "s3" && (cfg.ReplicaPath != "" || cfg.PreviousUploadPath != "" || cfg.DedupeHardLinks) {
+		log.Fatalf("Invalid configuration: REPLICA_PATH, PREVIOUS_UPLOAD_PATH and DEDUPE_HARDLINKS need STORAGE_BACKEND=local")
+	}
+
+	coordinator, err := coord.New(cfg.CoordinationBackend, db)
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// Periodic tasks run on one instance at a time
+	scheduler := coord.NewScheduler(coordinator)
+	scheduler.Every("prune-shared-state", 10*time.Minute, coordinator.Store.DeleteExpired)
 
 	// Initialize services
-	userService := service.NewUserService(userRepo, fileRepo)
-	fileService := service.NewFileService(fileRepo, userService, cfg.UploadPath, cfg.StorageURL)
-	imageService := service.NewImageService(fileRepo, userService, cfg.UploadPath, cfg.StorageURL)
+	bus := events.NewBus()
+	uploadTracker := service.NewUploadTracker(coordinator.Store)
+	diskGuard := service.NewDiskGuard(cfg.UploadPath, minFreeSpace)
+	blobs := service.NewBlobStore(backend, cfg)
+	imageWorkers := service.NewImageWorkers(cfg.ImageWorkers)
+	auditService := service.NewAuditService(auditRepo)
+	settingsService := service.NewSettingsService(settingRepo, auditService, plans, cfg)
+	if err := settingsService.Load(context.Background()); err != nil {
+		log.Fatalf("Failed to load settings: %v", err)
+	}
+	variantService := service.NewVariantService(variantRepo, blobs, imageWorkers, bus, settingsService, variantSizes, cfg)
+	apiKeyService := service.NewAPIKeyService(apiKeyRepo, userRepo, cfg)
+	userService := service.NewUserService(userRepo, fileRepo, apiKeyService, settingsService)
+	mailer := mail.NewMailer(mail.Config{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.MailFrom,
+	})
+	sessionService := service.NewSessionService(sessionRepo, userRepo, auditService, cfg)
+	credentialService := service.NewCredentialService(userRepo, passwordResetRepo, sessionService, apiKeyService, mailer, cfg)
+	jobService := service.NewJobService(jobRepo, coordinator.Locker)
+	streamService := service.NewStreamService(fileRepo, variantRepo, variantService, jobService, diskGuard, blobs, bus, cfg)
+	fileService := service.NewFileService(fileRepo, blobRepo, folderRepo, versionRepo, tagRepo, userService, uploadTracker, detector, diskGuard, blobs, variantService, bus, settingsService, imageProfiles, service.NewCompressor(compression, compressionMinSize), cfg)
+	imageService := service.NewImageService(fileRepo, exifRepo, userService, uploadTracker, diskGuard, blobs, variantService, imageWorkers, bus, settingsService, imageProfiles, cfg)
+	directUploadService := service.NewDirectUploadService(fileRepo, imageService, jobService, coordinator.Store, bus, cfg)
+	folderRuleService := service.NewFolderRuleService(folderRuleRepo, fileRepo, fileService, imageService, jobService, bus)
+	service.NewEventDeliveryService(eventPublishers, fileService, jobService, bus, eventTypes, cfg)
+	shareService := service.NewShareService(shareRepo, fileService, variantService, bus, cfg)
+	uploadLinkService := service.NewUploadLinkService(uploadLinkRepo, fileService, mailer, cfg)
+	scratchService := service.NewScratchService(fileRepo, fileService, scratchTTL, scratchMaxTTL, scratchQuota, cfg)
+	imageProxyService := service.NewImageProxyService(proxyCacheRepo, diskGuard, imageWorkers, settingsService, imageProxyMaxSize, cfg)
+	derivedCache := service.NewDerivedCache(variantRepo, proxyCacheRepo, blobs, derivedCacheMaxSize, cfg)
+	adminService := service.NewAdminService(userRepo, fileRepo, diskGuard, derivedCache)
+	service.RegisterVariantJobs(jobService, fileRepo, variantService)
+	service.NewDeduplicator(fileRepo, bus, cfg)
+	backfillService := service.NewBackfillService(fileRepo, jobService, detector)
+	replicationService := service.NewReplicationService(fileRepo, jobService, bus, cfg)
+	migrationService := service.NewStorageMigrationService(fileRepo, variantRepo, versionRepo, jobService, bus, cfg)
+	scanService := service.NewScanService(fileRepo, jobService, blobs, bus, scanner, cfg)
+	importService := service.NewImportService(fileRepo, fileService, userService, jobService, bus, cfg)
+	complianceService := service.NewComplianceExportService(fileRepo, fileService, userService, jobService, cfg)
+	folderDeleteService := service.NewFolderDeleteService(fileRepo, folderRepo, fileService, jobService, cfg)
+	billingService := service.NewBillingService(userRepo, fileRepo, userService, billingPrices, cfg)
+	anonymousService := service.NewAnonymousUploadService(fileRepo, fileService, scanService, shareService, userService, coordinator.Store, anonymousMaxSize, cfg)
+	if err := anonymousService.Check(context.Background()); err != nil {
+		log.Fatalf("Invalid configuration: ANONYMOUS_UPLOAD_USER_ID: %v", err)
+	}
+	adminFileService := service.NewAdminFileService(fileRepo, fileService, scanService, auditService)
+	reportService := service.NewReportService(fileRepo)
+	editSessionService := service.NewEditSessionService(fileService, coordinator.Store, coordinator.Locker)
+	capabilitiesService := service.NewCapabilitiesService(fileService, scanService, streamService, cfg.S3Listen != "")
+	if err := replicationService.Check(); err != nil {
+		log.Fatalf("Invalid configuration: REPLICA_PATH: %v", err)
+	}
+	scheduler.Every("run-jobs", 5*time.Second, jobService.RunPending)
+	scheduler.Every("prune-derived-assets", 10*time.Minute, derivedCache.Cleanup)
+	scheduler.Every("expire-folder-files", time.Hour, folderRuleService.ExpireFiles)
+	scheduler.Every("purge-trash", time.Hour, fileService.PurgeTrash)
+	scheduler.Every("expire-scratch-files", 10*time.Minute, scratchService.ExpireFiles)
+	scheduler.Every("prune-password-resets", time.Hour, credentialService.PruneResets)
+	scheduler.Every("prune-sessions", time.Hour, sessionService.PruneSessions)
+	scheduler.Every("clean-temp-files", time.Hour, tempStore.Cleanup)
+	if cfg.BlobGCInterval > 0 {
+		scheduler.Every("collect-blobs", cfg.BlobGCInterval, service.NewBlobCollector(fileRepo, variantRepo, versionRepo, cfg).Sweep)
+	}
+	if replicationService.Enabled() {
+		scheduler.Every("verify-replica", cfg.ReplicaVerifyInterval, replicationService.Verify)
+		scheduler.Every("clean-replica-temp-files", time.Hour, replicationService.Cleanup)
+	}
+	if billingService.Enabled() {
+		scheduler.Every("expire-billing-grace", time.Hour, billingService.ExpireGrace)
+	}
+	if billingService.OverageEnabled() {
+		scheduler.Every("report-overage", cfg.BillingUsageInterval, billingService.ReportOverage)
+	}
+	if alertService := service.NewAlertService(jobRepo, diskGuard, coordinator.Store, mailer, alertRules, cfg); alertService.Enabled() {
+		scheduler.Every("evaluate-alerts", cfg.AlertInterval, alertService.Evaluate)
+	}
 
 	// Initialize middleware
-	authMiddleware := middleware.NewAuthMiddleware(userRepo)
+	authMiddleware := middleware.NewAuthMiddleware(userRepo, sessionService, apiKeyService, rateLimiter)
 
 	// Initialize handlers
 	userHandler := handler.NewUserHandler(userService)
-	fileHandler := handler.NewFileHandler(fileService)
-	imageHandler := handler.NewImageHandler(imageService)
+	apiKeyHandler := handler.NewAPIKeyHandler(apiKeyService)
+	credentialHandler := handler.NewCredentialHandler(credentialService, sessionService, auditService)
+	sessionHandler := handler.NewSessionHandler(sessionService)
+	fileHandler := handler.NewFileHandler(fileService, uploadTracker, scanService, userService, folderDeleteService, editSessionService)
+	imageHandler := handler.NewImageHandler(imageService, uploadTracker, imageProxyService, directUploadService, userService)
+	uploadHandler := handler.NewUploadHandler(uploadTracker)
+	streamHandler := handler.NewStreamHandler(streamService)
+	folderRuleHandler := handler.NewFolderRuleHandler(folderRuleService)
+	shareHandler := handler.NewShareHandler(shareService)
+	uploadLinkHandler := handler.NewUploadLinkHandler(uploadLinkService)
+	trashHandler := handler.NewTrashHandler(fileService, userService)
+	scratchHandler := handler.NewScratchHandler(scratchService, uploadTracker)
+	jobHandler := handler.NewJobHandler(jobService)
+	adminHandler := handler.NewAdminHandler(adminService, userService, jobService, backfillService, streamService, sessionService, replicationService, migrationService, scanService, importService, complianceService)
+	auditHandler := handler.NewAuditHandler(auditService)
+	adminFileHandler := handler.NewAdminFileHandler(adminFileService)
+	reportHandler := handler.NewReportHandler(reportService)
+	settingsHandler := handler.NewSettingsHandler(settingsService)
+	billingHandler := handler.NewBillingHandler(billingService)
+	anonymousHandler := handler.NewAnonymousHandler(anonymousService)
+	capabilitiesHandler := handler.NewCapabilitiesHandler(capabilitiesService)
+	s3Handler := handler.NewS3Handler(service.NewS3Service(fileService, fileRepo), fileService)
+	davHandler := handler.NewDAVHandler(service.NewDAVService(fileService, fileRepo, folderRepo, folderDeleteService), fileService)
+
+	// Setup router; the access log replaces gin's default logger
+	router := gin.New()
+	router.Use(gin.Recovery(), middleware.AccessLog(cfg.AccessLog, cfg.SlowRequestThreshold))
+	if err := middleware.TrustProxies(router, cfg.TrustedProxies, cfg.TrustedPlatform); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
-	// Setup router
-	router := gin.Default()
+	router.Use(middleware.Locale())
+
+	// Uploads, downloads and streams get the longer transfer deadline
+	router.Use(middleware.Deadline(cfg.RequestTimeout, cfg.TransferTimeout,
+		"/api/upload", "/api/upload-image", "/api/scratch", "/api/images/direct/:token", "/api/anonymous/upload", "/api/files/:id/content", "/api/uploads/:id/progress",
+		"/api/download/:id", "/api/files/:id/signed", "/api/files/:id/versions/:version/download", "/api/files/export", "/api/folders/manifest", "/api/files/:id/stream", "/api/files/:id/stream/:name", "/api/folders/download",
+		"/s/:token/download", "/s/:token/preview", "/u/:token", "/uploads/*filepath", "/blob/:sha256", "/dav", "/dav/*path"))
 
 	// CORS middleware
 	router.Use(func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-API-Key")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, X-API-Key, X-Upload-ID, X-On-Behalf-Of, X-Edit-Token, Accept-Language")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Storage-Used, X-Storage-Limit, Retry-After")
 
-		if c.Request.Method == "OPTIONS" {
+		// WebDAV clients discover the endpoint with OPTIONS
+		if c.Request.Method == "OPTIONS" && !strings.HasPrefix(c.FullPath(), "/dav") {
 			c.AbortWithStatus(204)
 			return
 		}
@@ -79,36 +436,73 @@ func main() {
 	api := router.Group("/api")
 	{
 		userHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		apiKeyHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		credentialHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		sessionHandler.RegisterRoutes(api, authMiddleware.Authenticate())
 		fileHandler.RegisterRoutes(api, authMiddleware.Authenticate())
 		imageHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		uploadHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		streamHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		folderRuleHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		shareHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		uploadLinkHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		trashHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		scratchHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		jobHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		billingHandler.RegisterRoutes(api, authMiddleware.Authenticate())
+		adminHandler.RegisterRoutes(api, authMiddleware.Authenticate(), authMiddleware.RequireAdmin())
+		auditHandler.RegisterRoutes(api, authMiddleware.Authenticate(), authMiddleware.RequireAdmin())
+		adminFileHandler.RegisterRoutes(api, authMiddleware.Authenticate(), authMiddleware.RequireAdmin())
+		reportHandler.RegisterRoutes(api, authMiddleware.Authenticate(), authMiddleware.RequireAdmin())
+		settingsHandler.RegisterRoutes(api, authMiddleware.Authenticate(), authMiddleware.RequireAdmin())
+		anonymousHandler.RegisterRoutes(api, authMiddleware.Authenticate(), authMiddleware.RequireAdmin())
+		capabilitiesHandler.RegisterRoutes(api)
 	}
 
+	// Public share and upload links
+	shareHandler.RegisterPublicRoutes(router)
+	uploadLinkHandler.RegisterPublicRoutes(router)
+	fileHandler.RegisterPublicRoutes(router)
+
+	// WebDAV clients send the API key as the password of Basic auth
+	davHandler.RegisterRoutes(router.Group("/dav"), authMiddleware.AuthenticateBasic("storage-service"))
+
 	// Serve static files (uploaded files)
-	router.Static("/uploads", cfg.UploadPath)
+	uploads := handler.ServeUploads(cfg.UploadPath, cfg.PreviousUploadPath, replicationService.Replica(), blobs, fileService.Media())
+	router.GET("/uploads/*filepath", uploads)
+	router.HEAD("/uploads/*filepath", uploads)
 
 	// Serve frontend app
-	clientDist := "./client/dist"
-	if _, err := os.Stat(clientDist); err == nil {
-		router.GET("/app", func(c *gin.Context) {
-			c.File(filepath.Join(clientDist, "index.html"))
-		})
-		router.GET("/app/*path", func(c *gin.Context) {
-			path := c.Param("path")
-			filePath := filepath.Join(clientDist, path)
-			// Check if file exists (for assets)
-			if info, err := os.Stat(filePath); err == nil && !info.IsDir() {
-				c.File(filePath)
-			} else {
-				// SPA fallback - serve index.html for all other routes
-				c.File(filepath.Join(clientDist, "index.html"))
-			}
-		})
-	}
+	frontend, _ := client.Dist()
+	handler.NewFrontendHandler(cfg.FrontendPath, frontend).RegisterRoutes(router)
 
 	// Start server
-	addr := fmt.Sprintf(":%s", cfg.ServerPort)
-	log.Printf("Starting server on %s", addr)
-	if err := router.Run(addr); err != nil {
+	srv := server.New()
+	if err := srv.Add("api", router, cfg.Listen); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+
+	// The S3 gateway answers at the root of a listener of its own, every
+	// request may be a transfer
+	if cfg.S3Listen != "" {
+		s3Router := gin.New()
+		s3Router.RedirectTrailingSlash = false
+		s3Router.Use(gin.Recovery(), middleware.AccessLog(cfg.AccessLog, cfg.SlowRequestThreshold))
+		if err := middleware.TrustProxies(s3Router, cfg.TrustedProxies, cfg.TrustedPlatform); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+		s3Router.Use(middleware.Deadline(cfg.TransferTimeout, cfg.TransferTimeout))
+		s3Router.NoRoute(handler.S3NotImplemented)
+		s3Handler.RegisterRoutes(s3Router.Group(""), authMiddleware.AuthenticateS3(handler.S3Error))
+		if err := srv.Add("s3", s3Router, cfg.S3Listen); err != nil {
+			log.Fatalf("Failed to start server: %v", err)
+		}
+	}
+
+	scheduler.Start()
+	err = srv.Run()
+	scheduler.Stop()
+	if err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 }
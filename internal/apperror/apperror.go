@@ -0,0 +1,62 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"storage-service/internal/i18n"
+)
+
+// Error is a user-facing error with a stable machine-readable code.
+// Message is the English text; translations are looked up by Code.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+	Args    []interface{}
+}
+
+func New(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+func (e *Error) Error() string {
+	if len(e.Args) > 0 {
+		return fmt.Sprintf(e.Message, e.Args...)
+	}
+	return e.Message
+}
+
+// Is matches errors by code so errors.Is works with copies made by WithArgs
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// WithArgs returns a copy of the error with format arguments for the message
+func (e *Error) WithArgs(args ...interface{}) *Error {
+	clone := *e
+	clone.Args = args
+	return &clone
+}
+
+// Render builds the JSON error body for err in the given language. Errors
+// without a code are returned as-is with the fallback status.
+func Render(lang string, status int, err error) (int, map[string]interface{}) {
+	var appErr *Error
+	if errors.As(err, &appErr) {
+		return appErr.Status, map[string]interface{}{
+			"error": i18n.Translate(lang, appErr.Code, appErr.Error(), appErr.Args...),
+			"code":  appErr.Code,
+		}
+	}
+
+	code := "bad_request"
+	if status >= http.StatusInternalServerError {
+		code = "internal_error"
+	}
+	return status, map[string]interface{}{
+		"error": err.Error(),
+		"code":  code,
+	}
+}
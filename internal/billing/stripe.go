@@ -0,0 +1,219 @@
+// Package billing talks to Stripe for plan subscriptions and usage reporting
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const apiURL = "https://api.stripe.com"
+
+// signatureTolerance bounds the age of a webhook signature, against replays
+const signatureTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned for webhooks not signed with the webhook secret
+var ErrInvalidSignature = errors.New("invalid Stripe webhook signature")
+
+// CheckoutParams describes a subscription checkout for one price
+type CheckoutParams struct {
+	// CustomerID reuses an existing customer; otherwise Stripe creates one
+	// for CustomerEmail
+	CustomerID    string
+	CustomerEmail string
+	PriceID       string
+	SuccessURL    string
+	CancelURL     string
+	// ClientReferenceID and Metadata come back in the checkout.session.completed event
+	ClientReferenceID string
+	Metadata          map[string]string
+}
+
+// CheckoutSession is a hosted payment page the user is sent to
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Event is a webhook notification; Data.Object depends on Type
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Data struct {
+		Object json.RawMessage `json:"object"`
+	} `json:"data"`
+}
+
+// Client calls the Stripe API with a secret key
+type Client struct {
+	secretKey     string
+	webhookSecret string
+	baseURL       string
+	http          *http.Client
+}
+
+// NewClient returns a Stripe client, or nil when secretKey is empty
+func NewClient(secretKey, webhookSecret string) *Client {
+	if secretKey == "" {
+		return nil
+	}
+	return &Client{
+		secretKey:     secretKey,
+		webhookSecret: webhookSecret,
+		baseURL:       apiURL,
+		http:          &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// CreateCheckoutSession starts a subscription checkout
+func (c *Client) CreateCheckoutSession(ctx context.Context, params CheckoutParams) (*CheckoutSession, error) {
+	form := url.Values{
+		"mode":                    {"subscription"},
+		"line_items[0][price]":    {params.PriceID},
+		"line_items[0][quantity]": {"1"},
+		"success_url":             {params.SuccessURL},
+		"cancel_url":              {params.CancelURL},
+		"client_reference_id":     {params.ClientReferenceID},
+	}
+	if params.CustomerID != "" {
+		form.Set("customer", params.CustomerID)
+	} else if params.CustomerEmail != "" {
+		form.Set("customer_email", params.CustomerEmail)
+	}
+	for k, v := range params.Metadata {
+		form.Set("metadata["+k+"]", v)
+		form.Set("subscription_data[metadata]["+k+"]", v)
+	}
+
+	var session CheckoutSession
+	if err := c.post(ctx, "/v1/checkout/sessions", form, "", &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CreatePortalSession returns the URL of the hosted page where a customer
+// changes plan, updates the payment method or cancels
+func (c *Client) CreatePortalSession(ctx context.Context, customerID, returnURL string) (string, error) {
+	form := url.Values{
+		"customer":   {customerID},
+		"return_url": {returnURL},
+	}
+	var session struct {
+		URL string `json:"url"`
+	}
+	if err := c.post(ctx, "/v1/billing_portal/sessions", form, "", &session); err != nil {
+		return "", err
+	}
+	return session.URL, nil
+}
+
+// ReportUsage sends a billing meter event for a customer. identifier makes
+// the report idempotent, so a retried report is only counted once.
+func (c *Client) ReportUsage(ctx context.Context, meterEvent, customerID string, value int64, at time.Time, identifier string) error {
+	form := url.Values{
+		"event_name":                  {meterEvent},
+		"payload[stripe_customer_id]": {customerID},
+		"payload[value]":              {strconv.FormatInt(value, 10)},
+		"timestamp":                   {strconv.FormatInt(at.Unix(), 10)},
+		"identifier":                  {identifier},
+	}
+	return c.post(ctx, "/v1/billing/meter_events", form, identifier, nil)
+}
+
+func (c *Client) post(ctx context.Context, path string, form url.Values, idempotencyKey string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.secretKey, "")
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read stripe response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error.Message != "" {
+			return fmt.Errorf("stripe responded with %s: %s", resp.Status, apiErr.Error.Message)
+		}
+		return fmt.Errorf("stripe responded with %s", resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// ParseWebhook verifies the Stripe-Signature header of a webhook request
+// and decodes its event
+func (c *Client) ParseWebhook(payload []byte, signature string) (*Event, error) {
+	if err := verifySignature(payload, signature, c.webhookSecret, time.Now()); err != nil {
+		return nil, err
+	}
+	var event Event
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return nil, fmt.Errorf("invalid webhook payload: %w", err)
+	}
+	return &event, nil
+}
+
+// verifySignature checks a header like "t=1712345678,v1=5257a8...": one of
+// the v1 signatures must be the HMAC-SHA256 of "t.payload"
+func verifySignature(payload []byte, header, secret string, now time.Time) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(seconds, 0)); age > signatureTolerance || age < -signatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(payload)
+	expected := mac.Sum(nil)
+	for _, signature := range signatures {
+		if sig, err := hex.DecodeString(signature); err == nil && hmac.Equal(sig, expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
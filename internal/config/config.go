@@ -1,8 +1,13 @@
 package config
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -14,11 +19,286 @@ type Config struct {
 	DBDatabase   string
 	DBUsername   string
 	DBPassword   string
+	DBReplicaDSN string // Optional read replica for listings and counts
 	ServerPort   string
+	Listen       string // Comma-separated API listen addresses, e.g. ":8080,unix:/run/storage.sock"
 	UploadPath   string
 	MaxFileSize  int64
 	StorageURL   string
 	FrontendPath string
+	AppSecret    string
+	// AppSecretGenerated is set when APP_SECRET is unset and AppSecret was
+	// made up at startup, so secrets derived from it change on restart
+	AppSecretGenerated bool
+
+	// Folder operations affecting more files than this require a confirm token
+	FolderConfirmThreshold int64
+
+	// How many stored files a folder delete job removes per second, so
+	// deleting a large folder doesn't saturate the disk; 0 is unthrottled
+	FolderDeleteRate int
+
+	// Limits of folder paths: nesting depth, length in characters (0 for no
+	// limit) and comma separated names no folder may have
+	FolderMaxDepth      int
+	FolderMaxPathLength int
+	ReservedFolderNames string
+
+	// Content type detection: detector name and policy when declared type,
+	// extension and content disagree (reject, warn, trust-content)
+	MimeDetector       string
+	MimeMismatchPolicy string
+
+	// Check every extension of a filename (invoice.pdf.exe, shell.php.jpg) and
+	// optionally collapse inner dots of stored names
+	StrictFilenameExtensions bool
+	NormalizeFilenames       bool
+	FlagSuspiciousNames      bool
+
+	// Per content family or MIME type size limits, e.g. "image=20MB,video=2GB"
+	SizeLimits string
+
+	// Reject uploads when free space on UPLOAD_PATH drops below this, e.g. "1GB"
+	MinFreeSpace string
+
+	// Where locks, upload sessions and counters live: "local" for a single
+	// instance, "postgres" to share them between replicas
+	CoordinationBackend string
+
+	// Per API key (or user, for sessions) limits of RATE_LIMIT_REQUESTS
+	// requests a minute and RATE_LIMIT_UPLOAD_BYTES uploaded an hour, e.g.
+	// "5GB"; 0 turns a limit off. RATE_LIMIT_BACKEND "redis" shares the
+	// buckets between instances through REDIS_URL.
+	RateLimitRequests    int64
+	RateLimitUploadBytes string
+	RateLimitBackend     string
+	RedisURL             string
+
+	// Listen addresses of the S3-compatible gateway, e.g. ":9000"; empty
+	// turns it off
+	S3Listen string
+
+	// Image variants generated on upload and by the regeneration job, e.g. "thumb=256,medium=1024"
+	ThumbnailSizes string
+
+	// Named processing profiles selectable on image upload, e.g.
+	// "avatar:512x512,crop,webp;document-scan:grayscale,jpeg,q70"
+	ImageProfiles string
+
+	// What happens to the ICC profile of images that are re-encoded:
+	// "preserve" embeds it in the result, "srgb" converts the pixels to sRGB
+	ImageColorMode string
+
+	// Images with more than MAX_IMAGE_PIXELS pixels (width times height) are
+	// rejected from their header, before decoding could exhaust memory; 0
+	// disables the check
+	MaxImagePixels int
+
+	// What happens to animated GIFs on /api/upload-image: "preserve" resizes
+	// them frame by frame, "original" stores them without optimization
+	AnimatedGIFs string
+
+	// Remove the location from the EXIF data that re-encoded JPEGs keep
+	ImageStripGPS bool
+
+	// How many images are decoded and re-encoded at once; further uploads
+	// wait for a free worker. 0 uses the number of CPUs
+	ImageWorkers int
+
+	// GET /api/image-proxy: cache location and lifetime, maximum remote image
+	// size and an optional comma-separated host allowlist
+	ImageProxyCachePath    string
+	ImageProxyCacheTTL     time.Duration
+	ImageProxyMaxSize      string
+	ImageProxyAllowedHosts string
+
+	// Variants and proxied images are not counted against user quotas. Least
+	// recently used ones are evicted above this total, e.g. "5GB", and variants
+	// unused for VARIANT_CACHE_TTL are removed; empty disables either limit
+	DerivedCacheMaxSize string
+	VariantCacheTTL     time.Duration
+
+	// Videos are transcoded to HLS when FFMPEG_PATH is set. Stream URLs are
+	// signed and stay valid for STREAM_TOKEN_TTL.
+	FFmpegPath         string
+	HLSSegmentDuration int
+	StreamTokenTTL     time.Duration
+
+	// Outgoing email for password resets and security notices. Without
+	// SMTP_HOST emails are written to the log.
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+
+	// Password reset links point to PASSWORD_RESET_URL?token=... and are
+	// valid for PASSWORD_RESET_TTL
+	PasswordResetURL string
+	PasswordResetTTL time.Duration
+
+	// Login sessions: lifetime of access tokens and of an unused session
+	AccessTokenTTL time.Duration
+	SessionTTL     time.Duration
+
+	// Lifetime of the session an admin gets when impersonating a user
+	ImpersonationTTL time.Duration
+
+	// Log every request, and requests slower than SLOW_REQUEST_THRESHOLD at
+	// WARN regardless ("0" disables slow-request detection)
+	AccessLog            bool
+	SlowRequestThreshold time.Duration
+
+	// Proxies allowed to report the client IP in X-Forwarded-For/X-Real-IP,
+	// e.g. "10.0.0.0/8,172.16.0.1"; empty trusts none. TRUSTED_PLATFORM
+	// ("cloudflare", "google-app-engine", "fly") trusts that platform's header.
+	TrustedProxies  string
+	TrustedPlatform string
+
+	// Uploads are written here and renamed into UPLOAD_PATH when complete; it
+	// must be on the same volume. Empty uses UPLOAD_PATH/.tmp. Leftovers older
+	// than TEMP_FILE_MAX_AGE are removed.
+	TempUploadPath string
+	TempFileMaxAge time.Duration
+
+	// Text-like uploads of at least COMPRESSION_MIN_SIZE are stored compressed
+	// with COMPRESSION ("gzip" or "zstd") and decompressed when served; empty
+	// stores files as uploaded
+	Compression        string
+	CompressionMinSize string
+
+	// Every stored file is mirrored to REPLICA_PATH, a second volume or network
+	// mount, in the background; reads fall back to it when the primary copy is
+	// unavailable. Mirrors are verified every REPLICA_VERIFY_INTERVAL.
+	ReplicaPath           string
+	ReplicaVerifyInterval time.Duration
+
+	// Files still under PREVIOUS_UPLOAD_PATH are served from there until the
+	// storage migration job has moved them into UPLOAD_PATH
+	PreviousUploadPath string
+
+	// Every BLOB_GC_INTERVAL, stored files no record references and older
+	// than BLOB_GC_GRACE are removed from UPLOAD_PATH; empty disables the sweep
+	BlobGCInterval time.Duration
+	BlobGCGrace    time.Duration
+
+	// New uploads are scanned by the ClamAV daemon at CLAMD_ADDRESS
+	// ("host:port" or "unix:///path/to/clamd.sock"); empty disables scanning
+	ClamdAddress string
+	ClamdTimeout time.Duration
+
+	// File events are published to EVENT_SINKS, a comma separated list of
+	// "sns:<topic ARN>", "sqs:<queue URL>" and "pubsub:projects/<p>/topics/<t>".
+	// EVENT_TYPES limits the events sent; messages are signed with
+	// EVENT_SIGNING_SECRET when it is set.
+	EventSinks         string
+	EventTypes         string
+	EventSigningSecret string
+
+	// Upload URLs for direct image uploads stay valid for DIRECT_UPLOAD_TTL
+	DirectUploadTTL time.Duration
+
+	// Requests are cancelled after REQUEST_TIMEOUT, uploads and downloads
+	// after TRANSFER_TIMEOUT; "0" disables either deadline
+	RequestTimeout  time.Duration
+	TransferTimeout time.Duration
+
+	// Failed storage operations that are safe to repeat are retried
+	// STORAGE_RETRIES times, starting STORAGE_RETRY_DELAY apart. After
+	// STORAGE_BREAKER_THRESHOLD consecutive failures ("0" disables the
+	// breaker) storage requests fail fast for STORAGE_BREAKER_COOLDOWN.
+	StorageRetries          int
+	StorageRetryDelay       time.Duration
+	StorageBreakerThreshold int
+	StorageBreakerCooldown  time.Duration
+
+	// Alert rules evaluated every ALERT_INTERVAL, e.g.
+	// "error_rate>5%,disk_free<10%,queue_depth>500"; alerts are POSTed to
+	// ALERT_WEBHOOK_URL and emailed to the comma-separated ALERT_EMAILS
+	AlertRules      string
+	AlertInterval   time.Duration
+	AlertWebhookURL string
+	AlertEmails     string
+
+	// With PRIVATE_UPLOADS, /uploads only serves URLs signed by
+	// POST /api/files/media-urls, valid for at least MEDIA_URL_TTL
+	PrivateUploads bool
+	MediaURLTTL    time.Duration
+
+	// Download URLs from GET /api/files/:id/signed-url stay valid for
+	// SIGNED_URL_TTL unless the client asks for a shorter time
+	SignedURLTTL time.Duration
+
+	// Deleted files are kept in the trash for TRASH_RETENTION_DAYS and then
+	// purged; 0 disables the trash, so deletes are permanent
+	TrashRetentionDays int
+
+	// Replaced content is kept as up to MAX_FILE_VERSIONS earlier versions
+	// per file; 0 disables versioning, so new content replaces the old
+	MaxFileVersions int
+
+	// Scratch uploads (POST /api/scratch) expire after SCRATCH_TTL unless the
+	// upload asks for another lifetime of at most SCRATCH_MAX_TTL. They count
+	// against SCRATCH_QUOTA per user instead of the storage limits.
+	ScratchTTL    string
+	ScratchMaxTTL string
+	ScratchQuota  string
+
+	// Admins can import directory trees below IMPORT_ROOT into user
+	// accounts; empty disables imports
+	ImportRoot string
+
+	// Admins can export compliance snapshots of a user's files to
+	// COMPLIANCE_EXPORT_PATH, ideally a write-once volume; empty disables them
+	ComplianceExportPath string
+
+	// With DEDUPE_HARDLINKS, a stored file whose content is already stored
+	// is replaced by a hard link to the existing copy
+	DedupeHardLinks bool
+
+	// Plan tiers bounding the limits users may set themselves, e.g.
+	// "free:files=1000,file_size=10MB,storage=1GB;pro:files=100000,file_size=2GB,storage=100GB",
+	// and the plan new users start on
+	Plans       string
+	DefaultPlan string
+
+	// Stripe billing, disabled without STRIPE_SECRET_KEY. STRIPE_PRICES maps
+	// plans to prices, e.g. "pro=price_123"; users keep their plan for
+	// BILLING_GRACE_PERIOD after a failed payment. With STRIPE_OVERAGE_METER,
+	// storage above max_storage is reported every BILLING_USAGE_INTERVAL.
+	StripeSecretKey      string
+	StripeWebhookSecret  string
+	StripePrices         string
+	StripeOverageMeter   string
+	BillingSuccessURL    string
+	BillingCancelURL     string
+	BillingGracePeriod   time.Duration
+	BillingUsageInterval time.Duration
+
+	// ANONYMOUS_UPLOADS accepts uploads without an account into the account
+	// ANONYMOUS_UPLOAD_USER_ID, held for moderation. They are limited in size,
+	// type and per client IP and must pass the captcha verified with
+	// CAPTCHA_SECRET at CAPTCHA_VERIFY_URL (Turnstile, hCaptcha or reCAPTCHA).
+	AnonymousUploads        bool
+	AnonymousUploadUserID   uint
+	AnonymousMaxSize        string
+	AnonymousAllowedTypes   string
+	AnonymousUploadsPerHour int64
+	AnonymousShareExpiry    string
+	CaptchaSecret           string
+	CaptchaVerifyURL        string
+
+	// Where stored files live: "local" keeps them in UPLOAD_PATH, "s3" in
+	// S3_BUCKET, with UPLOAD_PATH as a local cache. S3_ENDPOINT selects an
+	// S3-compatible service; S3_PATH_STYLE addresses its buckets by path.
+	StorageBackend    string
+	S3Bucket          string
+	S3Region          string
+	S3Endpoint        string
+	S3AccessKeyID     string
+	S3SecretAccessKey string
+	S3Prefix          string
+	S3PathStyle       bool
 }
 
 func Load() (*Config, error) {
@@ -26,20 +306,237 @@ func Load() (*Config, error) {
 	_ = godotenv.Load()
 
 	maxFileSize, _ := strconv.ParseInt(getEnv("MAX_FILE_SIZE", "10485760"), 10, 64) // Default 10MB
+	serverPort := getEnv("SERVER_PORT", "8080")
+	folderConfirmThreshold, _ := strconv.ParseInt(getEnv("FOLDER_CONFIRM_THRESHOLD", "100"), 10, 64)
+	folderDeleteRate, err := strconv.Atoi(getEnv("FOLDER_DELETE_RATE", "100"))
+	if err != nil || folderDeleteRate < 0 {
+		folderDeleteRate = 100
+	}
+	folderMaxDepth, err := strconv.Atoi(getEnv("FOLDER_MAX_DEPTH", "32"))
+	if err != nil || folderMaxDepth < 0 {
+		folderMaxDepth = 32
+	}
+	folderMaxPathLength, err := strconv.Atoi(getEnv("FOLDER_MAX_PATH_LENGTH", "1024"))
+	if err != nil || folderMaxPathLength < 0 {
+		folderMaxPathLength = 1024
+	}
+	hlsSegmentDuration, _ := strconv.Atoi(getEnv("HLS_SEGMENT_DURATION", "6"))
+	if hlsSegmentDuration <= 0 {
+		hlsSegmentDuration = 6
+	}
+	storageRetries, _ := strconv.Atoi(getEnv("STORAGE_RETRIES", "2"))
+	storageBreakerThreshold, _ := strconv.Atoi(getEnv("STORAGE_BREAKER_THRESHOLD", "5"))
+	anonymousUploadUserID, _ := strconv.ParseUint(getEnv("ANONYMOUS_UPLOAD_USER_ID", "0"), 10, 32)
+	anonymousUploadsPerHour, _ := strconv.ParseInt(getEnv("ANONYMOUS_UPLOADS_PER_HOUR", "10"), 10, 64)
+	rateLimitRequests, err := strconv.ParseInt(getEnv("RATE_LIMIT_REQUESTS", "0"), 10, 64)
+	if err != nil || rateLimitRequests < 0 {
+		rateLimitRequests = 0
+	}
+	trashRetentionDays, err := strconv.Atoi(getEnv("TRASH_RETENTION_DAYS", "30"))
+	if err != nil || trashRetentionDays < 0 {
+		trashRetentionDays = 30
+	}
+	maxFileVersions, err := strconv.Atoi(getEnv("MAX_FILE_VERSIONS", "20"))
+	if err != nil || maxFileVersions < 0 {
+		maxFileVersions = 20
+	}
+	maxImagePixels, err := strconv.Atoi(getEnv("MAX_IMAGE_PIXELS", "100000000"))
+	if err != nil || maxImagePixels < 0 {
+		maxImagePixels = 100000000
+	}
+	imageWorkers, err := strconv.Atoi(getEnv("IMAGE_WORKERS", "0"))
+	if err != nil || imageWorkers < 0 {
+		imageWorkers = 0
+	}
 
-	return &Config{
+	appSecret := getEnv("APP_SECRET", "")
+	appSecretGenerated := appSecret == ""
+	if appSecretGenerated {
+		// Tokens signed with a random secret become invalid after a restart
+		appSecret = randomSecret()
+	}
+
+	var errs []error
+	duration := func(key, defaultValue string) time.Duration {
+		d, err := getEnvDuration(key, defaultValue)
+		errs = append(errs, err)
+		return d
+	}
+
+	cfg := &Config{
 		DBDriver:     getEnv("DB_DRIVER", "postgres"),
 		DBHost:       getEnv("DB_HOST", "localhost"),
 		DBPort:       getEnv("DB_PORT", "5432"),
 		DBDatabase:   getEnv("DB_DATABASE", "storage_db"),
 		DBUsername:   getEnv("DB_USERNAME", "postgres"),
 		DBPassword:   getEnv("DB_PASSWORD", ""),
-		ServerPort:   getEnv("SERVER_PORT", "8080"),
+		DBReplicaDSN: getEnv("DB_REPLICA_DSN", ""),
+		ServerPort:   serverPort,
+		Listen:       getEnv("LISTEN", ":"+serverPort),
 		UploadPath:   getEnv("UPLOAD_PATH", "./uploads"),
 		MaxFileSize:  maxFileSize,
 		StorageURL:   getEnv("STORAGE_URL", "http://localhost:8080"),
 		FrontendPath: getEnv("FRONTEND_PATH", "./client/dist"),
-	}, nil
+		AppSecret:    appSecret,
+
+		AppSecretGenerated: appSecretGenerated,
+
+		FolderConfirmThreshold: folderConfirmThreshold,
+		FolderDeleteRate:       folderDeleteRate,
+		FolderMaxDepth:         folderMaxDepth,
+		FolderMaxPathLength:    folderMaxPathLength,
+		ReservedFolderNames:    getEnv("RESERVED_FOLDER_NAMES", "trash"),
+
+		MimeDetector:       getEnv("MIME_DETECTOR", "mimetype"),
+		MimeMismatchPolicy: getEnv("MIME_MISMATCH_POLICY", "warn"),
+
+		StrictFilenameExtensions: getEnvBool("STRICT_FILENAME_EXTENSIONS", true),
+		NormalizeFilenames:       getEnvBool("NORMALIZE_FILENAMES", false),
+		FlagSuspiciousNames:      getEnvBool("FLAG_SUSPICIOUS_NAMES", false),
+
+		SizeLimits: getEnv("SIZE_LIMITS", ""),
+
+		MinFreeSpace: getEnv("MIN_FREE_SPACE", "1GB"),
+
+		CoordinationBackend: getEnv("COORDINATION_BACKEND", "local"),
+
+		RateLimitRequests:    rateLimitRequests,
+		RateLimitUploadBytes: getEnv("RATE_LIMIT_UPLOAD_BYTES", "0"),
+		RateLimitBackend:     getEnv("RATE_LIMIT_BACKEND", "local"),
+		RedisURL:             getEnv("REDIS_URL", ""),
+
+		S3Listen: getEnv("S3_LISTEN", ""),
+
+		ThumbnailSizes: getEnv("THUMBNAIL_SIZES", "thumb=256"),
+
+		ImageProfiles: getEnv("IMAGE_PROFILES", ""),
+
+		ImageColorMode: getEnv("IMAGE_COLOR_MODE", "preserve"),
+		MaxImagePixels: maxImagePixels,
+		AnimatedGIFs:   getEnv("ANIMATED_GIFS", "preserve"),
+		ImageStripGPS:  getEnvBool("IMAGE_STRIP_GPS", true),
+		ImageWorkers:   imageWorkers,
+
+		ImageProxyCachePath:    getEnv("IMAGE_PROXY_CACHE_PATH", "./cache/image-proxy"),
+		ImageProxyCacheTTL:     duration("IMAGE_PROXY_CACHE_TTL", "24h"),
+		ImageProxyMaxSize:      getEnv("IMAGE_PROXY_MAX_SIZE", "10MB"),
+		ImageProxyAllowedHosts: getEnv("IMAGE_PROXY_ALLOWED_HOSTS", ""),
+
+		DerivedCacheMaxSize: getEnv("DERIVED_CACHE_MAX_SIZE", ""),
+		VariantCacheTTL:     duration("VARIANT_CACHE_TTL", ""),
+
+		FFmpegPath:         getEnv("FFMPEG_PATH", ""),
+		HLSSegmentDuration: hlsSegmentDuration,
+		StreamTokenTTL:     duration("STREAM_TOKEN_TTL", "6h"),
+
+		SMTPHost:     getEnv("SMTP_HOST", ""),
+		SMTPPort:     getEnv("SMTP_PORT", "587"),
+		SMTPUsername: getEnv("SMTP_USERNAME", ""),
+		SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+		MailFrom:     getEnv("MAIL_FROM", "storage@localhost"),
+
+		PasswordResetURL: getEnv("PASSWORD_RESET_URL", ""),
+		PasswordResetTTL: duration("PASSWORD_RESET_TTL", "1h"),
+
+		AccessTokenTTL: duration("ACCESS_TOKEN_TTL", "15m"),
+		SessionTTL:     duration("SESSION_TTL", "720h"),
+
+		ImpersonationTTL: duration("IMPERSONATION_TTL", "1h"),
+
+		AccessLog:            getEnvBool("ACCESS_LOG", true),
+		SlowRequestThreshold: duration("SLOW_REQUEST_THRESHOLD", "5s"),
+
+		TrustedProxies:  getEnv("TRUSTED_PROXIES", ""),
+		TrustedPlatform: getEnv("TRUSTED_PLATFORM", ""),
+
+		TempUploadPath: getEnv("TEMP_UPLOAD_PATH", ""),
+		TempFileMaxAge: duration("TEMP_FILE_MAX_AGE", "24h"),
+
+		Compression:        getEnv("COMPRESSION", ""),
+		CompressionMinSize: getEnv("COMPRESSION_MIN_SIZE", "4KB"),
+
+		ReplicaPath:           getEnv("REPLICA_PATH", ""),
+		ReplicaVerifyInterval: duration("REPLICA_VERIFY_INTERVAL", "24h"),
+
+		PreviousUploadPath: getEnv("PREVIOUS_UPLOAD_PATH", ""),
+
+		BlobGCInterval: duration("BLOB_GC_INTERVAL", ""),
+		BlobGCGrace:    duration("BLOB_GC_GRACE", "24h"),
+
+		ClamdAddress: getEnv("CLAMD_ADDRESS", ""),
+		ClamdTimeout: duration("CLAMD_TIMEOUT", "2m"),
+
+		EventSinks:         getEnv("EVENT_SINKS", ""),
+		EventTypes:         getEnv("EVENT_TYPES", ""),
+		EventSigningSecret: getEnv("EVENT_SIGNING_SECRET", ""),
+
+		DirectUploadTTL: duration("DIRECT_UPLOAD_TTL", "15m"),
+
+		RequestTimeout:  duration("REQUEST_TIMEOUT", "30s"),
+		TransferTimeout: duration("TRANSFER_TIMEOUT", "1h"),
+
+		StorageRetries:          storageRetries,
+		StorageRetryDelay:       duration("STORAGE_RETRY_DELAY", "100ms"),
+		StorageBreakerThreshold: storageBreakerThreshold,
+		StorageBreakerCooldown:  duration("STORAGE_BREAKER_COOLDOWN", "30s"),
+
+		AlertRules:      getEnv("ALERT_RULES", ""),
+		AlertInterval:   duration("ALERT_INTERVAL", "1m"),
+		AlertWebhookURL: getEnv("ALERT_WEBHOOK_URL", ""),
+		AlertEmails:     getEnv("ALERT_EMAILS", ""),
+
+		PrivateUploads: getEnvBool("PRIVATE_UPLOADS", false),
+		MediaURLTTL:    duration("MEDIA_URL_TTL", "15m"),
+
+		SignedURLTTL: duration("SIGNED_URL_TTL", "1h"),
+
+		TrashRetentionDays: trashRetentionDays,
+		MaxFileVersions:    maxFileVersions,
+
+		ScratchTTL:    getEnv("SCRATCH_TTL", "24h"),
+		ScratchMaxTTL: getEnv("SCRATCH_MAX_TTL", "7d"),
+		ScratchQuota:  getEnv("SCRATCH_QUOTA", "5GB"),
+
+		ImportRoot: getEnv("IMPORT_ROOT", ""),
+
+		ComplianceExportPath: getEnv("COMPLIANCE_EXPORT_PATH", ""),
+
+		DedupeHardLinks: getEnvBool("DEDUPE_HARDLINKS", false),
+
+		Plans:       getEnv("PLANS", "free:files=1000,file_size=10MB,storage=1GB"),
+		DefaultPlan: getEnv("DEFAULT_PLAN", "free"),
+
+		StripeSecretKey:      getEnv("STRIPE_SECRET_KEY", ""),
+		StripeWebhookSecret:  getEnv("STRIPE_WEBHOOK_SECRET", ""),
+		StripePrices:         getEnv("STRIPE_PRICES", ""),
+		StripeOverageMeter:   getEnv("STRIPE_OVERAGE_METER", ""),
+		BillingSuccessURL:    getEnv("BILLING_SUCCESS_URL", ""),
+		BillingCancelURL:     getEnv("BILLING_CANCEL_URL", ""),
+		BillingGracePeriod:   duration("BILLING_GRACE_PERIOD", "168h"),
+		BillingUsageInterval: duration("BILLING_USAGE_INTERVAL", "24h"),
+
+		AnonymousUploads:        getEnvBool("ANONYMOUS_UPLOADS", false),
+		AnonymousUploadUserID:   uint(anonymousUploadUserID),
+		AnonymousMaxSize:        getEnv("ANONYMOUS_MAX_SIZE", "10MB"),
+		AnonymousAllowedTypes:   getEnv("ANONYMOUS_ALLOWED_TYPES", "image/jpeg,image/png,image/gif,application/pdf,text/plain"),
+		AnonymousUploadsPerHour: anonymousUploadsPerHour,
+		AnonymousShareExpiry:    getEnv("ANONYMOUS_SHARE_EXPIRY", "7d"),
+		CaptchaSecret:           getEnv("CAPTCHA_SECRET", ""),
+		CaptchaVerifyURL:        getEnv("CAPTCHA_VERIFY_URL", "https://challenges.cloudflare.com/turnstile/v0/siteverify"),
+
+		StorageBackend:    getEnv("STORAGE_BACKEND", "local"),
+		S3Bucket:          getEnv("S3_BUCKET", ""),
+		S3Region:          getEnv("S3_REGION", "us-east-1"),
+		S3Endpoint:        getEnv("S3_ENDPOINT", ""),
+		S3AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
+		S3SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+		S3Prefix:          getEnv("S3_PREFIX", ""),
+		S3PathStyle:       getEnvBool("S3_PATH_STYLE", false),
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return cfg, nil
 }
 
 func getEnv(key, defaultValue string) string {
@@ -48,3 +545,32 @@ func getEnv(key, defaultValue string) string {
 	}
 	return defaultValue
 }
+
+func getEnvBool(key string, defaultValue bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, strconv.FormatBool(defaultValue)))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// getEnvDuration parses a duration such as "15m"; an empty one is 0
+func getEnvDuration(key, defaultValue string) (time.Duration, error) {
+	value := getEnv(key, defaultValue)
+	if value == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a duration, got %q", key, value)
+	}
+	return d, nil
+}
+
+func randomSecret() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
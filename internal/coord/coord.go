@@ -0,0 +1,52 @@
+// Package coord provides locks and shared state so several instances of the
+// service can run behind a load balancer
+package coord
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Locker grants named locks that are exclusive across all instances
+type Locker interface {
+	// TryLock acquires the lock without waiting. ok is false when another
+	// holder has it; release must be called once the work is done.
+	TryLock(ctx context.Context, name string) (release func(), ok bool, err error)
+}
+
+// Store keeps short-lived values and counters shared by all instances
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Incr increments a counter that resets after window has passed since
+	// its first increment and returns the new value
+	Incr(ctx context.Context, key string, window time.Duration) (int64, error)
+	// Claim sets key only if it is absent or expired and reports whether it did
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// DeleteExpired removes expired entries
+	DeleteExpired(ctx context.Context) error
+}
+
+// Coordinator bundles the lock and state implementations of one backend
+type Coordinator struct {
+	Backend string
+	Locker  Locker
+	Store   Store
+}
+
+// New returns the coordinator for a backend: "local" keeps everything in
+// process memory (single instance), "postgres" shares it through the database
+func New(backend string, db *gorm.DB) (*Coordinator, error) {
+	switch backend {
+	case "", "local":
+		return &Coordinator{Backend: "local", Locker: NewLocalLocker(), Store: NewLocalStore()}, nil
+	case "postgres":
+		return &Coordinator{Backend: "postgres", Locker: NewPostgresLocker(db), Store: NewPostgresStore(db)}, nil
+	default:
+		return nil, fmt.Errorf("unknown coordination backend %q", backend)
+	}
+}
@@ -0,0 +1,130 @@
+package coord
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LocalLocker is a Locker for a single instance
+type LocalLocker struct {
+	mu    sync.Mutex
+	locks map[string]bool
+}
+
+func NewLocalLocker() *LocalLocker {
+	return &LocalLocker{locks: make(map[string]bool)}
+}
+
+func (l *LocalLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.locks[name] {
+		return nil, false, nil
+	}
+	l.locks[name] = true
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			delete(l.locks, name)
+			l.mu.Unlock()
+		})
+	}, true, nil
+}
+
+type localEntry struct {
+	value     []byte
+	counter   int64
+	expiresAt time.Time
+}
+
+// LocalStore is a Store for a single instance
+type LocalStore struct {
+	mu      sync.Mutex
+	entries map[string]*localEntry
+}
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{entries: make(map[string]*localEntry)}
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.liveLocked(key, time.Now())
+	if e == nil {
+		return nil, false, nil
+	}
+	return append([]byte(nil), e.value...), true, nil
+}
+
+func (s *LocalStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &localEntry{value: append([]byte(nil), value...), expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *LocalStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e := s.liveLocked(key, now)
+	if e == nil {
+		e = &localEntry{expiresAt: now.Add(window)}
+		s.entries[key] = e
+	}
+	e.counter++
+	return e.counter, nil
+}
+
+func (s *LocalStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.liveLocked(key, now) != nil {
+		return false, nil
+	}
+	s.entries[key] = &localEntry{expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+func (s *LocalStore) DeleteExpired(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for key, e := range s.entries {
+		if !now.Before(e.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+	return nil
+}
+
+func (s *LocalStore) liveLocked(key string, now time.Time) *localEntry {
+	e, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if !now.Before(e.expiresAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
@@ -0,0 +1,121 @@
+package coord
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"storage-service/internal/model"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PostgresLocker uses session-level advisory locks. Each held lock pins one
+// pooled connection until it is released.
+type PostgresLocker struct {
+	db *gorm.DB
+}
+
+func NewPostgresLocker(db *gorm.DB) *PostgresLocker {
+	return &PostgresLocker{db: db}
+}
+
+func (l *PostgresLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	sqlDB, err := l.db.DB()
+	if err != nil {
+		return nil, false, err
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	key := lockKey(name)
+	var ok bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", key).Scan(&ok); err != nil {
+		conn.Close()
+		return nil, false, err
+	}
+	if !ok {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", key)
+			conn.Close()
+		})
+	}, true, nil
+}
+
+// lockKey maps a lock name to the 64-bit key space of advisory locks
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// PostgresStore keeps shared state in the shared_states table
+type PostgresStore struct {
+	db *gorm.DB
+}
+
+func NewPostgresStore(db *gorm.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+func (s *PostgresStore) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	var entry model.SharedState
+	err := s.db.WithContext(ctx).Where("key = ? AND expires_at > ?", key, time.Now()).First(&entry).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return entry.Value, true, nil
+}
+
+func (s *PostgresStore) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	entry := model.SharedState{Key: key, Value: value, ExpiresAt: time.Now().Add(ttl)}
+	return s.db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "expires_at"}),
+	}).Create(&entry).Error
+}
+
+func (s *PostgresStore) Delete(ctx context.Context, key string) error {
+	return s.db.WithContext(ctx).Where("key = ?", key).Delete(&model.SharedState{}).Error
+}
+
+func (s *PostgresStore) Incr(ctx context.Context, key string, window time.Duration) (int64, error) {
+	now := time.Now()
+	var counter int64
+	err := s.db.WithContext(ctx).Raw(`
+		INSERT INTO shared_states (key, counter, expires_at) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET
+			counter = CASE WHEN shared_states.expires_at <= ? THEN 1 ELSE shared_states.counter + 1 END,
+			expires_at = CASE WHEN shared_states.expires_at <= ? THEN EXCLUDED.expires_at ELSE shared_states.expires_at END
+		RETURNING counter`, key, now.Add(window), now, now).Scan(&counter).Error
+	return counter, err
+}
+
+func (s *PostgresStore) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	result := s.db.WithContext(ctx).Exec(`
+		INSERT INTO shared_states (key, counter, expires_at) VALUES (?, 0, ?)
+		ON CONFLICT (key) DO UPDATE SET value = NULL, counter = 0, expires_at = EXCLUDED.expires_at
+		WHERE shared_states.expires_at <= ?`, key, now.Add(ttl), now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+func (s *PostgresStore) DeleteExpired(ctx context.Context) error {
+	return s.db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&model.SharedState{}).Error
+}
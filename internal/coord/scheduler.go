@@ -0,0 +1,87 @@
+package coord
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+type task struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// Scheduler runs periodic tasks so that each one runs at most once per
+// interval across all instances sharing the same Store and Locker
+type Scheduler struct {
+	locker Locker
+	store  Store
+	tasks  []task
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(c *Coordinator) *Scheduler {
+	return &Scheduler{locker: c.Locker, store: c.Store}
+}
+
+// Every registers a task. Tasks must be registered before Start.
+func (s *Scheduler) Every(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.tasks = append(s.tasks, task{name: name, interval: interval, run: run})
+}
+
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, t := range s.tasks {
+		s.wg.Add(1)
+		go func(t task) {
+			defer s.wg.Done()
+			ticker := time.NewTicker(t.interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+					s.runOnce(ctx, t)
+				}
+			}
+		}(t)
+	}
+}
+
+// Stop cancels running tasks and waits for them to return
+func (s *Scheduler) Stop() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, t task) {
+	// The claim limits a task to one run per interval, the lock keeps a slow
+	// run from overlapping with the next one
+	claimed, err := s.store.Claim(ctx, "schedule:"+t.name, t.interval)
+	if err != nil || !claimed {
+		if err != nil {
+			log.Printf("[WARN] Scheduler failed to claim %s: %v", t.name, err)
+		}
+		return
+	}
+
+	release, ok, err := s.locker.TryLock(ctx, "schedule:"+t.name)
+	if err != nil || !ok {
+		if err != nil {
+			log.Printf("[WARN] Scheduler failed to lock %s: %v", t.name, err)
+		}
+		return
+	}
+	defer release()
+
+	if err := t.run(ctx); err != nil {
+		log.Printf("Scheduled task %s failed: %v", t.name, err)
+	}
+}
@@ -0,0 +1,178 @@
+package detect
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/h2non/filetype"
+)
+
+// Generic types returned when content alone cannot identify a format
+const (
+	OctetStream = "application/octet-stream"
+	PlainText   = "text/plain"
+)
+
+// Detector identifies a MIME type from the first bytes of a file
+type Detector interface {
+	Name() string
+	Detect(head []byte) string
+}
+
+// NewDetector returns a detector by name: "mimetype" (default), "http" or "filetype"
+func NewDetector(name string) (Detector, error) {
+	switch name {
+	case "", "mimetype":
+		return mimetypeDetector{}, nil
+	case "http":
+		return httpDetector{}, nil
+	case "filetype":
+		return filetypeDetector{}, nil
+	default:
+		return nil, fmt.Errorf("unknown MIME detector %q", name)
+	}
+}
+
+type mimetypeDetector struct{}
+
+func (mimetypeDetector) Name() string { return "mimetype" }
+
+func (mimetypeDetector) Detect(head []byte) string {
+	return Normalize(mimetype.Detect(head).String())
+}
+
+type httpDetector struct{}
+
+func (httpDetector) Name() string { return "http" }
+
+func (httpDetector) Detect(head []byte) string {
+	return Normalize(http.DetectContentType(head))
+}
+
+type filetypeDetector struct{}
+
+func (filetypeDetector) Name() string { return "filetype" }
+
+func (filetypeDetector) Detect(head []byte) string {
+	kind, err := filetype.Match(head)
+	if err != nil || kind == filetype.Unknown {
+		return OctetStream
+	}
+	return Normalize(kind.MIME.Value)
+}
+
+// aliases maps non-canonical MIME types to the name used for comparisons
+var aliases = map[string]string{
+	"image/jpg":                    "image/jpeg",
+	"image/pjpeg":                  "image/jpeg",
+	"image/x-png":                  "image/png",
+	"application/x-zip-compressed": "application/zip",
+	"application/x-pdf":            "application/pdf",
+	"audio/mp3":                    "audio/mpeg",
+	"text/x-markdown":              "text/markdown",
+	"application/x-yaml":           "text/yaml",
+	"application/x-msdownload":     "application/vnd.microsoft.portable-executable",
+}
+
+// Normalize lowercases a MIME type, strips parameters and resolves aliases
+func Normalize(mimeType string) string {
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+	if canonical, ok := aliases[mimeType]; ok {
+		return canonical
+	}
+	return mimeType
+}
+
+// extensionTypes covers common extensions so results do not depend on the
+// host's mime.types file
+var extensionTypes = map[string]string{
+	".txt": "text/plain", ".log": "text/plain", ".ini": "text/plain", ".conf": "text/plain",
+	".md": "text/markdown", ".csv": "text/csv", ".json": "application/json",
+	".xml": "text/xml", ".yaml": "text/yaml", ".yml": "text/yaml",
+	".html": "text/html", ".htm": "text/html", ".css": "text/css",
+	".jpg": "image/jpeg", ".jpeg": "image/jpeg", ".png": "image/png", ".gif": "image/gif",
+	".webp": "image/webp", ".bmp": "image/bmp", ".tif": "image/tiff", ".tiff": "image/tiff",
+	".svg": "image/svg+xml", ".heic": "image/heic", ".avif": "image/avif",
+	".pdf": "application/pdf", ".zip": "application/zip", ".gz": "application/gzip",
+	".tar": "application/x-tar", ".7z": "application/x-7z-compressed", ".rar": "application/x-rar-compressed",
+	".doc": "application/msword", ".xls": "application/vnd.ms-excel", ".ppt": "application/vnd.ms-powerpoint",
+	".docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	".xlsx": "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+	".pptx": "application/vnd.openxmlformats-officedocument.presentationml.presentation",
+
+	".mp3": "audio/mpeg", ".wav": "audio/wav", ".ogg": "audio/ogg", ".flac": "audio/flac",
+	".mp4": "video/mp4", ".mov": "video/quicktime", ".webm": "video/webm", ".mkv": "video/x-matroska",
+	".avi": "video/x-msvideo",
+}
+
+// ExtensionType returns the MIME type implied by a filename's extension, or "" if unknown
+func ExtensionType(filename string) string {
+	ext := strings.ToLower(filepath.Ext(filename))
+	if ext == "" {
+		return ""
+	}
+	if t, ok := extensionTypes[ext]; ok {
+		return t
+	}
+	return Normalize(mime.TypeByExtension(ext))
+}
+
+// Conclusive reports whether a detected type identifies a specific format
+func Conclusive(detected string) bool {
+	return detected != "" && detected != OctetStream && detected != PlainText
+}
+
+// Compatible reports whether a claimed type (from the client or the extension)
+// agrees with the detected content type
+func Compatible(claimed, detected string) bool {
+	claimed, detected = Normalize(claimed), Normalize(detected)
+	if claimed == "" || claimed == OctetStream || detected == "" || claimed == detected {
+		return true
+	}
+
+	switch detected {
+	case OctetStream:
+		// Unknown binary content cannot contradict a claim
+		return true
+	case PlainText:
+		// Magic bytes cannot tell text formats apart
+		return isTextual(claimed)
+	}
+
+	if m := mimetype.Lookup(detected); m != nil {
+		if m.Is(claimed) {
+			return true
+		}
+		// Content is a specialization of the claim, e.g. HTML claimed as text/plain
+		for p := m.Parent(); p != nil; p = p.Parent() {
+			if parent := Normalize(p.String()); parent == claimed && parent != OctetStream {
+				return true
+			}
+		}
+	}
+	if m := mimetype.Lookup(claimed); m != nil {
+		// Claim is a specialization of the content, e.g. .docx only detected as zip
+		for p := m.Parent(); p != nil; p = p.Parent() {
+			if Normalize(p.String()) == detected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isTextual(mimeType string) bool {
+	if strings.HasPrefix(mimeType, "text/") {
+		return true
+	}
+	switch mimeType {
+	case "application/json", "application/xml", "application/javascript", "image/svg+xml":
+		return true
+	}
+	return strings.HasSuffix(mimeType, "+json") || strings.HasSuffix(mimeType, "+xml")
+}
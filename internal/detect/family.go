@@ -0,0 +1,63 @@
+package detect
+
+import "strings"
+
+// Content families used to group MIME types for limits and listings
+const (
+	FamilyImage    = "image"
+	FamilyVideo    = "video"
+	FamilyAudio    = "audio"
+	FamilyDocument = "document"
+	FamilyArchive  = "archive"
+	FamilyOther    = "other"
+)
+
+// Families lists every content family in display order
+var Families = []string{FamilyImage, FamilyVideo, FamilyAudio, FamilyDocument, FamilyArchive, FamilyOther}
+
+var documentTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/msword":            true,
+	"application/rtf":               true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"application/vnd.openxmlformats-officedocument.wordprocessingml.document":   true,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":         true,
+	"application/vnd.openxmlformats-officedocument.presentationml.presentation": true,
+	"application/vnd.oasis.opendocument.text":                                   true,
+	"application/vnd.oasis.opendocument.spreadsheet":                            true,
+	"application/vnd.oasis.opendocument.presentation":                           true,
+	"application/epub+zip": true,
+	"application/json":     true,
+	"application/xml":      true,
+}
+
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-tar":            true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+}
+
+// Family returns the content family of a MIME type
+func Family(mimeType string) string {
+	mimeType = Normalize(mimeType)
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return FamilyImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return FamilyVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return FamilyAudio
+	case strings.HasPrefix(mimeType, "text/"), documentTypes[mimeType]:
+		return FamilyDocument
+	case archiveTypes[mimeType]:
+		return FamilyArchive
+	default:
+		return FamilyOther
+	}
+}
@@ -0,0 +1,62 @@
+package detect
+
+import "fmt"
+
+// Policy decides what happens when the declared type, extension and content disagree
+type Policy string
+
+const (
+	// PolicyReject refuses the upload
+	PolicyReject Policy = "reject"
+	// PolicyWarn accepts the upload, logs the mismatch and flags the file
+	PolicyWarn Policy = "warn"
+	// PolicyTrustContent accepts the upload and stores the detected type
+	PolicyTrustContent Policy = "trust-content"
+)
+
+func ParsePolicy(value string) (Policy, error) {
+	switch Policy(value) {
+	case PolicyReject, PolicyWarn, PolicyTrustContent:
+		return Policy(value), nil
+	case "":
+		return PolicyWarn, nil
+	default:
+		return "", fmt.Errorf("unknown MIME mismatch policy %q", value)
+	}
+}
+
+// Result holds the three views of a file's type
+type Result struct {
+	Declared  string
+	Extension string
+	Detected  string
+}
+
+// Inspect detects the content type of head and compares it with the declared
+// Content-Type and the filename extension
+func Inspect(d Detector, filename, declared string, head []byte) Result {
+	return Result{
+		Declared:  Normalize(declared),
+		Extension: ExtensionType(filename),
+		Detected:  d.Detect(head),
+	}
+}
+
+// Mismatch reports whether the declared type or the extension contradicts the content
+func (r Result) Mismatch() bool {
+	return !Compatible(r.Declared, r.Detected) || !Compatible(r.Extension, r.Detected)
+}
+
+// Effective returns the MIME type to store for the file under the given policy
+func (r Result) Effective(policy Policy) string {
+	if policy == PolicyTrustContent && Conclusive(r.Detected) {
+		return r.Detected
+	}
+	if r.Declared != "" && r.Declared != OctetStream {
+		return r.Declared
+	}
+	if r.Detected != "" {
+		return r.Detected
+	}
+	return OctetStream
+}
@@ -0,0 +1,19 @@
+// Package diskspace reports capacity of the filesystem holding a path
+package diskspace
+
+import "errors"
+
+// ErrUnsupported is returned on platforms without a statfs equivalent
+var ErrUnsupported = errors.New("disk usage is not supported on this platform")
+
+// Usage is the capacity of a filesystem in bytes
+type Usage struct {
+	Total uint64 `json:"total"`
+	Free  uint64 `json:"free"`
+	Used  uint64 `json:"used"`
+}
+
+// Stat returns the usage of the filesystem containing path
+func Stat(path string) (Usage, error) {
+	return stat(path)
+}
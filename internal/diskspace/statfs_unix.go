@@ -0,0 +1,18 @@
+//go:build unix
+
+package diskspace
+
+import "golang.org/x/sys/unix"
+
+func stat(path string) (Usage, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return Usage{}, err
+	}
+
+	blockSize := uint64(fs.Bsize)
+	total := fs.Blocks * blockSize
+	// Bavail excludes blocks reserved for root, which the service cannot use
+	free := uint64(fs.Bavail) * blockSize
+	return Usage{Total: total, Free: free, Used: total - uint64(fs.Bfree)*blockSize}, nil
+}
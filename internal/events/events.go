@@ -0,0 +1,118 @@
+package events
+
+import (
+	"log"
+	"storage-service/internal/model"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	FileCreated = "file.created"
+	FileUpdated = "file.updated"
+	FileDeleted = "file.deleted"
+	// FileReplaced is published after a re-upload or a version restore gave
+	// a file other content, which is scanned and processed like an upload
+	FileReplaced = "file.replaced"
+	// FileTransferred is published after an admin gave a file to another user
+	FileTransferred = "file.transferred"
+	// ProcessingCompleted is published after background processing, such as
+	// transcoding or processing a direct upload, built a file's derived assets
+	ProcessingCompleted = "processing.completed"
+)
+
+// Types lists every event type
+var Types = []string{FileCreated, FileUpdated, FileDeleted, FileReplaced, FileTransferred, ProcessingCompleted}
+
+// Event describes something that happened to a user's files
+type Event struct {
+	Type   string      `json:"type"`
+	UserID uint        `json:"user_id"`
+	File   *model.File `json:"file,omitempty"`
+	Time   time.Time   `json:"time"`
+
+	// PreviousPath is where the content was stored before an update moved it
+	PreviousPath string `json:"-"`
+	// PreviousUserID owned a transferred file before
+	PreviousUserID uint `json:"-"`
+}
+
+// Handler reacts to an event. Handlers run synchronously in the publishing
+// request, so slow work belongs in a background job. The change is already
+// stored when an event is published, so handlers don't use the request's
+// context: a client disconnecting must not stop them halfway.
+type Handler func(event Event)
+
+// Bus delivers events to the handlers subscribed to their type
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[string][]Handler
+}
+
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[string][]Handler)}
+}
+
+func (b *Bus) Subscribe(eventType string, handler Handler) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handlers[eventType] = append(b.handlers[eventType], handler)
+}
+
+// Publish calls every handler subscribed to the event type. A panicking
+// handler is logged and does not affect the others or the publisher.
+func (b *Bus) Publish(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	b.mu.RLock()
+	handlers := b.handlers[event.Type]
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					log.Printf("[WARN] Event handler for %s panicked: %v", event.Type, r)
+				}
+			}()
+			handler(event)
+		}()
+	}
+}
+
+// NewFileCreated builds the event published after a file is stored
+func NewFileCreated(file *model.File) Event {
+	return Event{Type: FileCreated, UserID: file.UserID, File: file}
+}
+
+// NewFileUpdated builds the event published after the content of a file is
+// replaced; previousPath is its old location on disk
+func NewFileUpdated(file *model.File, previousPath string) Event {
+	return Event{Type: FileUpdated, UserID: file.UserID, File: file, PreviousPath: previousPath}
+}
+
+// NewFileReplaced builds the event published after a file got content from a
+// re-upload or an earlier version; previousPath is where the replaced content
+// is kept
+func NewFileReplaced(file *model.File, previousPath string) Event {
+	return Event{Type: FileReplaced, UserID: file.UserID, File: file, PreviousPath: previousPath}
+}
+
+// NewFileDeleted builds the event published after a file is removed
+func NewFileDeleted(file *model.File) Event {
+	return Event{Type: FileDeleted, UserID: file.UserID, File: file}
+}
+
+// NewFileTransferred builds the event published after a file changed owner
+func NewFileTransferred(file *model.File, previousUserID uint) Event {
+	return Event{Type: FileTransferred, UserID: file.UserID, File: file, PreviousUserID: previousUserID}
+}
+
+// NewProcessingCompleted builds the event published after the derived assets
+// of a file are ready; file.Variants holds them with their URLs
+func NewProcessingCompleted(file *model.File) Event {
+	return Event{Type: ProcessingCompleted, UserID: file.UserID, File: file}
+}
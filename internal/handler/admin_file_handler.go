@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminFileHandler struct {
+	adminFileService *service.AdminFileService
+}
+
+func NewAdminFileHandler(adminFileService *service.AdminFileService) *AdminFileHandler {
+	return &AdminFileHandler{adminFileService: adminFileService}
+}
+
+// SearchFiles lists files of all users, filtered by ?user_id=, ?q= (name),
+// ?mime= (type prefix), ?min_size=, ?max_size=, ?created_after=,
+// ?created_before= (RFC 3339), ?scan_status=, ?source= and
+// ?name_warning=true (misleading names)
+func (h *AdminFileHandler) SearchFiles(c *gin.Context) {
+	query, err := parseAdminFileQuery(c)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	q := parseListQuery(c, "user_id", "q", "mime", "min_size", "max_size", "created_after", "created_before", "scan_status", "source", "name_warning").sorted(c, "created_at", "desc")
+
+	files, total, err := h.adminFileService.Search(c.Request.Context(), query, q.page, q.pageSize, q.sort.By, q.sort.Order)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFiles)
+		return
+	}
+
+	c.JSON(http.StatusOK, newListResponse(q, files, total))
+}
+
+func parseAdminFileQuery(c *gin.Context) (service.AdminFileQuery, error) {
+	query := service.AdminFileQuery{
+		Name:       c.Query("q"),
+		MimePrefix: c.Query("mime"),
+		ScanStatus: c.Query("scan_status"),
+		Source:     c.Query("source"),
+	}
+	query.NameWarnings = c.Query("name_warning") == "true"
+	if query.Source != "" && !model.ValidUploadSource(query.Source) {
+		return query, service.ErrInvalidUploadSource
+	}
+
+	if value := c.Query("user_id"); value != "" {
+		userID, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return query, errInvalidUserID
+		}
+		query.UserID = uint(userID)
+	}
+
+	for param, target := range map[string]*int64{"min_size": &query.MinSize, "max_size": &query.MaxSize} {
+		if value := c.Query(param); value != "" {
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return query, errInvalidFileFilter.WithArgs(param)
+			}
+			*target = size
+		}
+	}
+
+	for param, target := range map[string]*time.Time{"created_after": &query.CreatedAfter, "created_before": &query.CreatedBefore} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return query, errInvalidFileFilter.WithArgs(param)
+			}
+			*target = t
+		}
+	}
+
+	return query, nil
+}
+
+type BulkFileActionRequest struct {
+	// Action is delete, quarantine, release or transfer
+	Action string `json:"action" binding:"required"`
+	IDs    []uint `json:"ids" binding:"required"`
+	// TargetUserID receives the files of a transfer
+	TargetUserID uint `json:"target_user_id"`
+	// Reason is recorded in the owners' audit logs
+	Reason string `json:"reason"`
+}
+
+// BulkFileAction applies an action to files of any user and reports the
+// outcome per file
+func (h *AdminFileHandler) BulkFileAction(c *gin.Context) {
+	var req BulkFileActionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	result, err := h.adminFileService.Bulk(c.Request.Context(), req.Action, req.IDs, req.TargetUserID, req.Reason, c.GetUint("user_id"), c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+type ReleaseWriteOnceRequest struct {
+	Path string `json:"path" binding:"required"`
+	// Reason is recorded in the owner's audit log
+	Reason string `json:"reason"`
+}
+
+// ReleaseWriteOnce lets the files of a user's write-once folder be changed
+// and deleted again
+func (h *AdminFileHandler) ReleaseWriteOnce(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+
+	var req ReleaseWriteOnceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	folder, err := h.adminFileService.ReleaseWriteOnce(c.Request.Context(), uint(userID), req.Path, req.Reason, c.GetUint("user_id"), c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "write_once_released", "Write-once folder released"),
+		"folder":  folder,
+	})
+}
+
+func (h *AdminFileHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc) {
+	admin := router.Group("/admin")
+	admin.Use(authMiddleware, adminMiddleware)
+	{
+		admin.GET("/files", h.SearchFiles)
+		admin.POST("/files/bulk", h.BulkFileAction)
+		admin.POST("/users/:id/folders/release-write-once", h.ReleaseWriteOnce)
+	}
+}
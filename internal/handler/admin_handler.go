@@ -0,0 +1,403 @@
+package handler
+
+import (
+	"expvar"
+	"net/http"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AdminHandler struct {
+	adminService       *service.AdminService
+	userService        *service.UserService
+	jobService         *service.JobService
+	backfillService    *service.BackfillService
+	streamService      *service.StreamService
+	sessionService     *service.SessionService
+	replicationService *service.ReplicationService
+	migrationService   *service.StorageMigrationService
+	scanService        *service.ScanService
+	importService      *service.ImportService
+	complianceService  *service.ComplianceExportService
+}
+
+func NewAdminHandler(adminService *service.AdminService, userService *service.UserService, jobService *service.JobService, backfillService *service.BackfillService, streamService *service.StreamService, sessionService *service.SessionService, replicationService *service.ReplicationService, migrationService *service.StorageMigrationService, scanService *service.ScanService, importService *service.ImportService, complianceService *service.ComplianceExportService) *AdminHandler {
+	return &AdminHandler{adminService: adminService, userService: userService, jobService: jobService, backfillService: backfillService, streamService: streamService, sessionService: sessionService, replicationService: replicationService, migrationService: migrationService, scanService: scanService, importService: importService, complianceService: complianceService}
+}
+
+func (h *AdminHandler) GetStats(c *gin.Context) {
+	stats, err := h.adminService.GetStats(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errAdminStats)
+		return
+	}
+
+	c.JSON(http.StatusOK, stats)
+}
+
+// RegenerateVariants queues a job rebuilding thumbnails of existing images,
+// optionally limited to some users or MIME types
+func (h *AdminHandler) RegenerateVariants(c *gin.Context) {
+	var req service.RegenerateVariantsParams
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	job, err := h.jobService.Enqueue(c.Request.Context(), service.JobRegenerateVariants, req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// TranscodeVideos queues a job converting existing videos to HLS,
+// optionally limited to some files or users
+func (h *AdminHandler) TranscodeVideos(c *gin.Context) {
+	var req service.TranscodeVideosParams
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	job, err := h.streamService.StartTranscode(c.Request.Context(), req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// VerifyReplica queues a job comparing every file with its mirror and
+// copying the ones that are missing or differ
+func (h *AdminHandler) VerifyReplica(c *gin.Context) {
+	job, err := h.replicationService.StartVerify(c.Request.Context(), c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// MigrateStorage queues a job moving every file still stored below
+// PREVIOUS_UPLOAD_PATH into UPLOAD_PATH
+func (h *AdminHandler) MigrateStorage(c *gin.Context) {
+	var req service.StorageMigrationParams
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	job, err := h.migrationService.Start(c.Request.Context(), req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// RescanFiles queues a job scanning files again, e.g. after the virus
+// signatures were updated. An empty body rescans every file.
+func (h *AdminHandler) RescanFiles(c *gin.Context) {
+	var req service.ScanFilesParams
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	job, err := h.scanService.StartRescan(c.Request.Context(), req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ImportDirectory queues a job importing a directory below IMPORT_ROOT into
+// a user's account
+func (h *AdminHandler) ImportDirectory(c *gin.Context) {
+	var req service.ImportParams
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.importService.Start(c.Request.Context(), req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// ExportCompliance queues a job writing a snapshot of a user's files and
+// their metadata below COMPLIANCE_EXPORT_PATH
+func (h *AdminHandler) ExportCompliance(c *gin.Context) {
+	var req service.ComplianceExportParams
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.complianceService.Start(c.Request.Context(), req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+// GetBackfillFields lists the fields that can be backfilled and how many files lack them
+func (h *AdminHandler) GetBackfillFields(c *gin.Context) {
+	fields, err := h.backfillService.Fields(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errBackfillFields)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"fields": fields})
+}
+
+// StartBackfill queues a job computing one field for legacy files
+func (h *AdminHandler) StartBackfill(c *gin.Context) {
+	var req service.BackfillParams
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	job, err := h.backfillService.Start(c.Request.Context(), req, c.GetUint("user_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, job)
+}
+
+func (h *AdminHandler) ListJobs(c *gin.Context) {
+	q := parseListQuery(c, "type", "status")
+
+	jobs, total, err := h.jobService.ListJobs(c.Request.Context(), c.Query("type"), c.Query("status"), q.page, q.pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchJobs)
+		return
+	}
+
+	c.JSON(http.StatusOK, newListResponse(q, jobs, total))
+}
+
+func (h *AdminHandler) GetJob(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidJobID)
+		return
+	}
+
+	job, err := h.jobService.GetJob(c.Request.Context(), uint(jobID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func (h *AdminHandler) CancelJob(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidJobID)
+		return
+	}
+
+	job, err := h.jobService.CancelJob(c.Request.Context(), uint(jobID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "job_cancelled", "Job cancelled"),
+		"job":     job,
+	})
+}
+
+type ImpersonateRequest struct {
+	// Reason is recorded in the audit log and shown to the user
+	Reason string `json:"reason" binding:"required"`
+}
+
+// Impersonate returns a short-lived access token acting as the user
+func (h *AdminHandler) Impersonate(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+
+	var req ImpersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tokens, err := h.sessionService.Impersonate(c.Request.Context(), c.GetUint("user_id"), uint(userID), req.Reason, c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, tokens)
+}
+
+type ImageTypesRequest struct {
+	// MimeTypes replaces the optional types enabled for the user; empty
+	// restores the defaults
+	MimeTypes []string `json:"mime_types"`
+}
+
+// SetImageTypes enables optional image types, like TIFF, for a user
+func (h *AdminHandler) SetImageTypes(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+
+	var req ImageTypesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	mimeTypes, err := h.adminService.SetUserImageTypes(c.Request.Context(), uint(userID), req.MimeTypes)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          localize(c, "image_types_updated", "Image types updated"),
+		"image_mime_types": mimeTypes,
+	})
+}
+
+// GetPlans lists the plans users can be assigned to
+func (h *AdminHandler) GetPlans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"plans": h.userService.Plans()})
+}
+
+type PlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// SetPlan moves a user to another plan, resetting their limits to its ceilings
+func (h *AdminHandler) SetPlan(c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidUserID)
+		return
+	}
+
+	var req PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	settings, err := h.userService.SetPlan(c.Request.Context(), uint(userID), req.Plan)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  localize(c, "plan_updated", "Plan updated"),
+		"settings": settings,
+	})
+}
+
+// PauseJob stops a job after its current batch, keeping its progress
+func (h *AdminHandler) PauseJob(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidJobID)
+		return
+	}
+
+	job, err := h.jobService.PauseJob(c.Request.Context(), uint(jobID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "job_paused", "Job paused"),
+		"job":     job,
+	})
+}
+
+// ResumeJob continues a paused job from where it stopped
+func (h *AdminHandler) ResumeJob(c *gin.Context) {
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidJobID)
+		return
+	}
+
+	job, err := h.jobService.ResumeJob(c.Request.Context(), uint(jobID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "job_resumed", "Job resumed"),
+		"job":     job,
+	})
+}
+
+func (h *AdminHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc) {
+	admin := router.Group("/admin")
+	admin.Use(authMiddleware, adminMiddleware)
+	{
+		admin.GET("/stats", h.GetStats)
+		admin.GET("/metrics", gin.WrapH(expvar.Handler()))
+		admin.POST("/jobs/regenerate-variants", h.RegenerateVariants)
+		admin.POST("/jobs/transcode-videos", h.TranscodeVideos)
+		admin.POST("/jobs/verify-replica", h.VerifyReplica)
+		admin.POST("/jobs/migrate-storage", h.MigrateStorage)
+		admin.POST("/jobs/rescan", h.RescanFiles)
+		admin.POST("/jobs/import", h.ImportDirectory)
+		admin.POST("/jobs/compliance-export", h.ExportCompliance)
+		admin.GET("/backfill", h.GetBackfillFields)
+		admin.POST("/jobs/backfill", h.StartBackfill)
+		admin.GET("/jobs", h.ListJobs)
+		admin.GET("/jobs/:id", h.GetJob)
+		admin.POST("/jobs/:id/cancel", h.CancelJob)
+		admin.POST("/jobs/:id/pause", h.PauseJob)
+		admin.POST("/jobs/:id/resume", h.ResumeJob)
+		admin.POST("/users/:id/impersonate", h.Impersonate)
+		admin.PUT("/users/:id/image-types", h.SetImageTypes)
+		admin.GET("/plans", h.GetPlans)
+		admin.PUT("/users/:id/plan", h.SetPlan)
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead is the room left for form fields and boundaries when
+// limiting the body of an anonymous upload
+const multipartOverhead = 64 << 10
+
+type AnonymousHandler struct {
+	anonymousService *service.AnonymousUploadService
+}
+
+func NewAnonymousHandler(anonymousService *service.AnonymousUploadService) *AnonymousHandler {
+	return &AnonymousHandler{anonymousService: anonymousService}
+}
+
+// Upload accepts a file without an account into the moderation queue
+func (h *AnonymousHandler) Upload(c *gin.Context) {
+	if !h.anonymousService.Enabled() {
+		respondError(c, http.StatusNotFound, service.ErrAnonymousUploadsDisabled)
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.anonymousService.MaxSize()+multipartOverhead)
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errFileRequired)
+		return
+	}
+
+	upload, err := h.anonymousService.Upload(c.Request.Context(), file, c.PostForm("captcha_token"), uploadClient(c))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": localize(c, "upload_received", "File received, it will be available once it has been reviewed"),
+		"upload":  upload,
+	})
+}
+
+// GetQueue lists anonymous uploads awaiting moderation, oldest first
+func (h *AnonymousHandler) GetQueue(c *gin.Context) {
+	q := parseListQuery(c)
+	files, total, err := h.anonymousService.Queue(c.Request.Context(), q.page, q.pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFiles)
+		return
+	}
+
+	c.JSON(http.StatusOK, newListResponse(q, files, total))
+}
+
+// Approve makes an anonymous upload available through its share link
+func (h *AnonymousHandler) Approve(c *gin.Context) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	file, err := h.anonymousService.Approve(c.Request.Context(), uint(fileID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "upload_approved", "Upload approved"),
+		"file":    file,
+	})
+}
+
+// Reject deletes an anonymous upload
+func (h *AnonymousHandler) Reject(c *gin.Context) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	if err := h.anonymousService.Reject(c.Request.Context(), uint(fileID)); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "upload_rejected", "Upload rejected and deleted")})
+}
+
+func (h *AnonymousHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc) {
+	// Anonymous uploads are authorized by their captcha
+	router.POST("/anonymous/upload", h.Upload)
+
+	admin := router.Group("/admin")
+	admin.Use(authMiddleware, adminMiddleware)
+	{
+		admin.GET("/moderation", h.GetQueue)
+		admin.POST("/moderation/:id/approve", h.Approve)
+		admin.POST("/moderation/:id/reject", h.Reject)
+	}
+}
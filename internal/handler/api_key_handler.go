@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type APIKeyHandler struct {
+	keyService *service.APIKeyService
+}
+
+func NewAPIKeyHandler(keyService *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{keyService: keyService}
+}
+
+func (h *APIKeyHandler) ListKeys(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	keys, err := h.keyService.ListKeys(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchAPIKeys)
+		return
+	}
+
+	c.JSON(http.StatusOK, newFullListResponse(keys, nil))
+}
+
+// CreateKey creates a key; its secret is only part of this response
+func (h *APIKeyHandler) CreateKey(c *gin.Context) {
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+	user := c.MustGet("user").(*model.User)
+
+	var req service.APIKeyInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	key, err := h.keyService.CreateKey(c.Request.Context(), user, req, grantedScopes(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": localize(c, "api_key_created", "API key created successfully"),
+		"api_key": key,
+	})
+}
+
+// UpdateKey changes the settings of a key; its secret stays the same
+func (h *APIKeyHandler) UpdateKey(c *gin.Context) {
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidAPIKeyID)
+		return
+	}
+
+	var req service.APIKeyInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	key, err := h.keyService.UpdateKey(c.Request.Context(), uint(keyID), c.MustGet("user").(*model.User), req, grantedScopes(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "api_key_updated", "API key updated successfully"),
+		"api_key": key,
+	})
+}
+
+// RotateKey gives a key a new secret, returned in this response only
+func (h *APIKeyHandler) RotateKey(c *gin.Context) {
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidAPIKeyID)
+		return
+	}
+
+	key, err := h.keyService.RotateKey(c.Request.Context(), uint(keyID), c.GetUint("user_id"), grantedScopes(c))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "api_key_regenerated", "API key regenerated successfully"),
+		"api_key": key,
+	})
+}
+
+func (h *APIKeyHandler) RevokeKey(c *gin.Context) {
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+	keyID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidAPIKeyID)
+		return
+	}
+
+	if err := h.keyService.RevokeKey(c.Request.Context(), uint(keyID), c.GetUint("user_id")); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "api_key_revoked", "API key revoked")})
+}
+
+// RegenerateAPIKey rotates the key the request is made with and returns it
+// in user.api_key. Requests with a session get a new key with every scope.
+func (h *APIKeyHandler) RegenerateAPIKey(c *gin.Context) {
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+	user := c.MustGet("user").(*model.User)
+
+	if keyID := c.GetUint("api_key_id"); keyID != 0 {
+		key, err := h.keyService.RotateKey(c.Request.Context(), keyID, user.ID, nil)
+		if err != nil {
+			respondError(c, http.StatusInternalServerError, errRegenerateKey)
+			return
+		}
+		user.APIKey = key.Key
+	} else if err := h.keyService.IssueKey(c.Request.Context(), user, false); err != nil {
+		respondError(c, http.StatusInternalServerError, errRegenerateKey)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "api_key_regenerated", "API key regenerated successfully"),
+		"user":    user,
+	})
+}
+
+func (h *APIKeyHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/users/me/api-keys", requireScope(model.ScopeRead), h.ListKeys)
+		protected.POST("/users/me/api-keys", requireScope(model.ScopeUpload), h.CreateKey)
+		protected.PUT("/users/me/api-keys/:id", requireScope(model.ScopeUpload), h.UpdateKey)
+		protected.POST("/users/me/api-keys/:id/rotate", requireScope(model.ScopeUpload), h.RotateKey)
+		protected.DELETE("/users/me/api-keys/:id", requireScope(model.ScopeDelete), h.RevokeKey)
+		protected.POST("/users/regenerate-key", h.RegenerateAPIKey)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	auditService *service.AuditService
+}
+
+func NewAuditHandler(auditService *service.AuditService) *AuditHandler {
+	return &AuditHandler{auditService: auditService}
+}
+
+// GetActivity returns the user's activity feed: logins, credential changes
+// and impersonations by admins
+func (h *AuditHandler) GetActivity(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	h.listEvents(c, parseListQuery(c), userID.(uint))
+}
+
+// ListAuditEvents returns the audit log, optionally of one ?user_id=
+func (h *AuditHandler) ListAuditEvents(c *gin.Context) {
+	var userID uint64
+	if value := c.Query("user_id"); value != "" {
+		var err error
+		userID, err = strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, errInvalidUserID)
+			return
+		}
+	}
+
+	h.listEvents(c, parseListQuery(c, "user_id"), uint(userID))
+}
+
+func (h *AuditHandler) listEvents(c *gin.Context, q *listQuery, userID uint) {
+	events, total, err := h.auditService.ListEvents(c.Request.Context(), userID, q.page, q.pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchActivity)
+		return
+	}
+
+	c.JSON(http.StatusOK, newListResponse(q, events, total))
+}
+
+func (h *AuditHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/users/me/activity", requireScope(model.ScopeRead), h.GetActivity)
+	}
+
+	admin := router.Group("/admin")
+	admin.Use(authMiddleware, adminMiddleware)
+	{
+		admin.GET("/audit", h.ListAuditEvents)
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"storage-service/internal/billing"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxWebhookSize bounds the Stripe webhook payloads read
+const maxWebhookSize = 1 << 20
+
+type BillingHandler struct {
+	billingService *service.BillingService
+}
+
+func NewBillingHandler(billingService *service.BillingService) *BillingHandler {
+	return &BillingHandler{billingService: billingService}
+}
+
+// GetBilling returns the plan and subscription of the user
+func (h *BillingHandler) GetBilling(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	status, err := h.billingService.Status(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errBillingStatus)
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+type CheckoutRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// Checkout starts subscribing the user to a plan
+func (h *BillingHandler) Checkout(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req CheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	session, err := h.billingService.Checkout(c.Request.Context(), userID.(uint), req.Plan)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"checkout_url": session.URL})
+}
+
+// Portal returns the page where the user manages their subscription
+func (h *BillingHandler) Portal(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	url, err := h.billingService.Portal(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusBadGateway, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"portal_url": url})
+}
+
+// Webhook applies Stripe events. Failures answer with an error so Stripe
+// delivers the event again.
+func (h *BillingHandler) Webhook(c *gin.Context) {
+	payload, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookSize))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	err = h.billingService.HandleWebhook(c.Request.Context(), payload, c.GetHeader("Stripe-Signature"))
+	if errors.Is(err, billing.ErrInvalidSignature) {
+		respondError(c, http.StatusBadRequest, errInvalidSignature)
+		return
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to handle Stripe webhook: %v", err)
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"received": true})
+}
+
+func (h *BillingHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/billing", requireScope(model.ScopeRead), h.GetBilling)
+		protected.POST("/billing/checkout", requireScope(model.ScopeUpload), h.Checkout)
+		protected.POST("/billing/portal", requireScope(model.ScopeUpload), h.Portal)
+	}
+
+	// Webhooks are authenticated by their Stripe signature
+	router.POST("/billing/webhook", h.Webhook)
+}
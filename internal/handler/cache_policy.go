@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Cache-Control values by how the served content can change
+const (
+	// cacheImmutable is for URLs of stored files, which are named by UUID and
+	// stored under a new name when their content changes
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// cacheRevalidate lets clients keep content that can change in place but
+	// makes them check Last-Modified or the ETag before reusing it
+	cacheRevalidate = "no-cache"
+	// cachePrivateRevalidate is cacheRevalidate for authenticated responses
+	cachePrivateRevalidate = "private, no-cache"
+)
+
+// uploadCacheControl returns the caching policy of a file below /uploads
+func uploadCacheControl(name string) string {
+	if service.MutableUpload(path.Base(name)) {
+		return cacheRevalidate
+	}
+	return cacheImmutable
+}
+
+// cacheOnSuccess drops Cache-Control from error responses, so a file that is
+// missing, e.g. not replicated yet, isn't cached as missing for a year
+type cacheOnSuccess struct {
+	gin.ResponseWriter
+}
+
+func (w cacheOnSuccess) WriteHeader(status int) {
+	if status >= http.StatusBadRequest {
+		w.Header().Del("Cache-Control")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// setFileETag sets a strong ETag from the checksum of a file, when it is
+// known, so conditional requests are answered with 304
+func setFileETag(c *gin.Context, file *model.File) {
+	if file.SHA256 != "" {
+		c.Header("ETag", `"`+file.SHA256+`"`)
+	}
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, comparing
+// weakly as RFC 9110 requires for If-None-Match
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondJSONWithETag writes obj as JSON with a weak ETag of the body, and
+// answers 304 without a body when If-None-Match still matches it, so clients
+// polling a listing only download it when it changed
+func respondJSONWithETag(c *gin.Context, obj any) {
+	body, err := json.Marshal(obj)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", cachePrivateRevalidate)
+	if match := c.GetHeader("If-None-Match"); match != "" && etagMatches(match, etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	c.Data(http.StatusOK, "application/json; charset=utf-8", body)
+}
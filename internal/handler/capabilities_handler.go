@@ -0,0 +1,25 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CapabilitiesHandler struct {
+	capabilitiesService *service.CapabilitiesService
+}
+
+func NewCapabilitiesHandler(capabilitiesService *service.CapabilitiesService) *CapabilitiesHandler {
+	return &CapabilitiesHandler{capabilitiesService: capabilitiesService}
+}
+
+func (h *CapabilitiesHandler) GetCapabilities(c *gin.Context) {
+	c.JSON(http.StatusOK, h.capabilitiesService.Get())
+}
+
+func (h *CapabilitiesHandler) RegisterRoutes(router *gin.RouterGroup) {
+	// Public, so clients can adapt before signing in
+	router.GET("/capabilities", h.GetCapabilities)
+}
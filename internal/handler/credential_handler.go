@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CredentialHandler struct {
+	credentialService *service.CredentialService
+	sessionService    *service.SessionService
+	auditService      *service.AuditService
+}
+
+func NewCredentialHandler(credentialService *service.CredentialService, sessionService *service.SessionService, auditService *service.AuditService) *CredentialHandler {
+	return &CredentialHandler{credentialService: credentialService, sessionService: sessionService, auditService: auditService}
+}
+
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type ChangeEmailRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	Email           string `json:"email" binding:"required,email"`
+}
+
+// Login exchanges an email and password for the user and the tokens of a
+// new session
+func (h *CredentialHandler) Login(c *gin.Context) {
+	var req LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.credentialService.Login(c.Request.Context(), req.Email, req.Password)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	tokens, err := h.sessionService.Create(c.Request.Context(), user, c.Request.UserAgent(), c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.auditService.Record(c.Request.Context(), user.ID, 0, model.AuditLogin, c.ClientIP(), map[string]interface{}{
+		"session_id": tokens.SessionID,
+		"device":     c.Request.UserAgent(),
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "logged_in", "Logged in successfully"),
+		"user":    user,
+		"tokens":  tokens,
+	})
+}
+
+func (h *CredentialHandler) ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.credentialService.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+		log.Printf("[WARN] Failed to send password reset: %v", err)
+		respondError(c, http.StatusInternalServerError, errPasswordReset)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "password_reset_sent", "If the email is registered, a password reset link has been sent"),
+	})
+}
+
+func (h *CredentialHandler) ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.credentialService.ResetPassword(c.Request.Context(), req.Token, req.Password)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.auditService.Record(c.Request.Context(), user.ID, 0, model.AuditPasswordReset, c.ClientIP(), nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "password_reset", "Password reset successfully"),
+		"user":    user,
+	})
+}
+
+func (h *CredentialHandler) ChangePassword(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+	// Service accounts acting for a user must not take over the account
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	user, err := h.credentialService.ChangePassword(c.Request.Context(), userID.(uint), req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.auditService.Record(c.Request.Context(), user.ID, 0, model.AuditPasswordChanged, c.ClientIP(), nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "password_changed", "Password changed successfully"),
+		"user":    user,
+	})
+}
+
+func (h *CredentialHandler) ChangeEmail(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+	// Service accounts acting for a user must not take over the account
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+
+	var req ChangeEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	oldEmail := c.MustGet("user").(*model.User).Email
+	user, err := h.credentialService.ChangeEmail(c.Request.Context(), userID.(uint), req.CurrentPassword, req.Email)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.auditService.Record(c.Request.Context(), user.ID, 0, model.AuditEmailChanged, c.ClientIP(), map[string]interface{}{
+		"old_email": oldEmail,
+		"new_email": user.Email,
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "email_changed", "Email changed successfully"),
+		"user":    user,
+	})
+}
+
+func (h *CredentialHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	router.POST("/users/login", h.Login)
+	router.POST("/users/forgot-password", h.ForgotPassword)
+	router.POST("/users/reset-password", h.ResetPassword)
+
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.PUT("/users/me/password", requireScope(model.ScopeUpload), h.ChangePassword)
+		protected.PUT("/users/me/email", requireScope(model.ScopeUpload), h.ChangeEmail)
+	}
+}
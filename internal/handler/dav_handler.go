@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"storage-service/internal/apperror"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/webdav"
+)
+
+// davScopes are the scopes the WebDAV methods need
+var davScopes = map[string]string{
+	http.MethodOptions: model.ScopeRead,
+	http.MethodGet:     model.ScopeRead,
+	http.MethodHead:    model.ScopeRead,
+	"PROPFIND":         model.ScopeRead,
+	http.MethodPut:     model.ScopeUpload,
+	"MKCOL":            model.ScopeUpload,
+	"COPY":             model.ScopeUpload,
+	"MOVE":             model.ScopeUpload,
+	"PROPPATCH":        model.ScopeUpload,
+	"LOCK":             model.ScopeUpload,
+	"UNLOCK":           model.ScopeUpload,
+	http.MethodDelete:  model.ScopeDelete,
+}
+
+// errDAVReadOnly answers writes to a file opened for reading, and reads of
+// a file being written
+var errDAVReadOnly = errors.New("file is not open for this operation")
+
+// DAVHandler serves the WebDAV endpoint, so users can mount their storage
+// as a network drive. Locks are kept in memory for each user and only hold
+// on the instance that granted them.
+type DAVHandler struct {
+	davService  *service.DAVService
+	fileService *service.FileService
+	prefix      string
+
+	mu    sync.Mutex
+	locks map[uint]webdav.LockSystem
+}
+
+func NewDAVHandler(davService *service.DAVService, fileService *service.FileService) *DAVHandler {
+	return &DAVHandler{davService: davService, fileService: fileService, locks: make(map[uint]webdav.LockSystem)}
+}
+
+func (h *DAVHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	h.prefix = router.BasePath()
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	for method, scope := range davScopes {
+		protected.Handle(method, "", requireScope(scope), h.Serve)
+		protected.Handle(method, "/*path", requireScope(scope), h.Serve)
+	}
+}
+
+// Serve answers a WebDAV request on the files of the user
+func (h *DAVHandler) Serve(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	if c.Request.Method == http.MethodPut {
+		// Finder streams writes and only announces their length
+		size := c.Request.ContentLength
+		if expected, err := strconv.ParseInt(c.GetHeader("X-Expected-Entity-Length"), 10, 64); err == nil && size <= 0 {
+			size = expected
+		}
+		if size > 0 {
+			if err := h.davService.CheckUploadAllowed(c.Request.Context(), userID.(uint), size); err != nil {
+				status, body := apperror.Render(c.GetString("lang"), http.StatusBadRequest, err)
+				if errors.Is(err, service.ErrStorageLimitExceeded) {
+					status = http.StatusInsufficientStorage
+				}
+				c.JSON(status, body)
+				return
+			}
+		}
+	}
+
+	source := uploadClient(c)
+	source.Source, source.Label = model.SourceWebDAV, c.GetString("api_key_account")
+	c.Header("Cache-Control", cachePrivateRevalidate)
+	dav := &webdav.Handler{
+		Prefix:     h.prefix,
+		FileSystem: &davFS{dav: h.davService, files: h.fileService, userID: userID.(uint), source: source},
+		LockSystem: h.lockSystem(userID.(uint)),
+	}
+	dav.ServeHTTP(c.Writer, c.Request)
+}
+
+func (h *DAVHandler) lockSystem(userID uint) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	locks, ok := h.locks[userID]
+	if !ok {
+		locks = webdav.NewMemLS()
+		h.locks[userID] = locks
+	}
+	return locks
+}
+
+// davFS is the webdav.FileSystem of the files of a user
+type davFS struct {
+	dav    *service.DAVService
+	files  *service.FileService
+	userID uint
+	source model.UploadSource
+}
+
+func (fs *davFS) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	return davError("mkdir", name, fs.dav.Mkdir(ctx, fs.userID, name))
+}
+
+// OpenFile opens a file or folder for reading, or starts writing a file
+// with any of the write flags; what is written is stored once it is closed
+func (fs *davFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR) != 0 {
+		if err := fs.dav.CheckPut(ctx, fs.userID, name); err != nil {
+			return nil, davError("open", name, err)
+		}
+		return fs.upload(ctx, name), nil
+	}
+
+	entry, err := fs.dav.Stat(ctx, fs.userID, name)
+	if err != nil {
+		return nil, davError("open", name, err)
+	}
+	if entry.Folder != nil {
+		return &davFolder{ctx: ctx, fs: fs, entry: entry}, nil
+	}
+
+	file := entry.File
+	if err := service.CheckDownload(file); err != nil {
+		return nil, davError("open", name, err)
+	}
+	filePath, err := fs.files.Locate(ctx, file)
+	if err != nil {
+		return nil, davError("open", name, err)
+	}
+	var content io.ReadSeekCloser
+	if file.Compression == "" {
+		f, err := os.Open(filePath)
+		if err != nil {
+			return nil, davError("open", name, err)
+		}
+		content = f
+	} else {
+		content = &storedContent{path: filePath, compression: file.Compression, size: file.FileSize}
+	}
+	return &davFile{ReadSeekCloser: content, info: newDAVInfo(entry)}, nil
+}
+
+func (fs *davFS) RemoveAll(ctx context.Context, name string) error {
+	return davError("remove", name, fs.dav.Remove(ctx, fs.userID, name))
+}
+
+func (fs *davFS) Rename(ctx context.Context, oldName, newName string) error {
+	return davError("rename", oldName, fs.dav.Rename(ctx, fs.userID, oldName, newName))
+}
+
+func (fs *davFS) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	entry, err := fs.dav.Stat(ctx, fs.userID, name)
+	if err != nil {
+		return nil, davError("stat", name, err)
+	}
+	return newDAVInfo(entry), nil
+}
+
+// upload streams what is written to the file at name into an upload
+func (fs *davFS) upload(ctx context.Context, name string) *davUpload {
+	pr, pw := io.Pipe()
+	u := &davUpload{name: name, pw: pw, done: make(chan error, 1), started: time.Now()}
+	go func() {
+		_, err := fs.dav.Put(ctx, fs.userID, name, pr, fs.source)
+		// Fails the writes still coming when the upload stopped early
+		pr.CloseWithError(err)
+		u.done <- err
+	}()
+	return u
+}
+
+// davError turns the errors of a service into the errors WebDAV answers
+// with the right status, and logs those that aren't caused by the request
+func davError(op, name string, err error) error {
+	var appErr *apperror.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, service.ErrFileNotFound), errors.Is(err, service.ErrFolderNotFound):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, service.ErrFolderExists):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	case !errors.As(err, &appErr) && !errors.Is(err, context.Canceled):
+		log.Printf("[WARN] WebDAV %s of %q failed: %v", op, name, err)
+	}
+	return err
+}
+
+// davInfo describes a resource. Files report their type and ETag, so
+// listings don't read their content.
+type davInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+	dir     bool
+	// file is nil for folders and files being written
+	file *model.File
+}
+
+func newDAVInfo(entry *service.DAVEntry) *davInfo {
+	if entry.Folder != nil {
+		return &davInfo{name: entry.Name, modTime: entry.Folder.UpdatedAt, dir: true}
+	}
+	return &davInfo{name: entry.Name, size: entry.File.FileSize, modTime: entry.File.UpdatedAt, file: entry.File}
+}
+
+func (i *davInfo) Name() string       { return i.name }
+func (i *davInfo) Size() int64        { return i.size }
+func (i *davInfo) ModTime() time.Time { return i.modTime }
+func (i *davInfo) IsDir() bool        { return i.dir }
+func (i *davInfo) Sys() any           { return nil }
+
+func (i *davInfo) Mode() os.FileMode {
+	if i.dir {
+		return os.ModeDir | 0755
+	}
+	return 0644
+}
+
+// ContentType implements webdav.ContentTyper
+func (i *davInfo) ContentType(ctx context.Context) (string, error) {
+	if i.file == nil || i.file.MimeType == "" {
+		return "", webdav.ErrNotImplemented
+	}
+	return i.file.MimeType, nil
+}
+
+// ETag implements webdav.ETager, with the ETag of the S3 gateway
+func (i *davInfo) ETag(ctx context.Context) (string, error) {
+	if i.file == nil {
+		return "", webdav.ErrNotImplemented
+	}
+	return s3ETag(i.file), nil
+}
+
+// davFolder is a folder opened for its listing
+type davFolder struct {
+	ctx   context.Context
+	fs    *davFS
+	entry *service.DAVEntry
+
+	// members are listed on the first Readdir, which returns them from pos
+	members []os.FileInfo
+	pos     int
+	listed  bool
+}
+
+func (f *davFolder) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.listed {
+		entries, err := f.fs.dav.List(f.ctx, f.fs.userID, f.entry.Folder.Path)
+		if err != nil {
+			return nil, davError("list", f.entry.Folder.Path, err)
+		}
+		for i := range entries {
+			f.members = append(f.members, newDAVInfo(&entries[i]))
+		}
+		f.listed = true
+	}
+
+	rest := f.members[f.pos:]
+	if count <= 0 {
+		f.pos = len(f.members)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	rest = rest[:min(count, len(rest))]
+	f.pos += len(rest)
+	return rest, nil
+}
+
+func (f *davFolder) Stat() (os.FileInfo, error)                   { return newDAVInfo(f.entry), nil }
+func (f *davFolder) Read(p []byte) (int, error)                   { return 0, errDAVReadOnly }
+func (f *davFolder) Write(p []byte) (int, error)                  { return 0, errDAVReadOnly }
+func (f *davFolder) Seek(offset int64, whence int) (int64, error) { return 0, errDAVReadOnly }
+func (f *davFolder) Close() error                                 { return nil }
+
+// davFile is a file opened for reading
+type davFile struct {
+	io.ReadSeekCloser
+	info *davInfo
+}
+
+func (f *davFile) Stat() (os.FileInfo, error)               { return f.info, nil }
+func (f *davFile) Readdir(count int) ([]os.FileInfo, error) { return nil, errDAVReadOnly }
+func (f *davFile) Write(p []byte) (int, error)              { return 0, errDAVReadOnly }
+
+// davUpload is a file being written, stored when it is closed
+type davUpload struct {
+	name    string
+	pw      *io.PipeWriter
+	done    chan error
+	written int64
+	started time.Time
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	n, err := u.pw.Write(p)
+	u.written += int64(n)
+	return n, err
+}
+
+// Close ends the content and waits until it is stored
+func (u *davUpload) Close() error {
+	u.pw.Close()
+	return davError("put", u.name, <-u.done)
+}
+
+func (u *davUpload) Stat() (os.FileInfo, error) {
+	return &davInfo{name: path.Base(u.name), size: u.written, modTime: u.started}, nil
+}
+
+func (u *davUpload) Read(p []byte) (int, error)                   { return 0, errDAVReadOnly }
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) { return 0, errDAVReadOnly }
+func (u *davUpload) Readdir(count int) ([]os.FileInfo, error)     { return nil, errDAVReadOnly }
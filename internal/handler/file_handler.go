@@ -1,135 +1,298 @@
 package handler
 
 import (
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/model"
 	"storage-service/internal/service"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// exportFlushRows is how many rows of an export are written between flushes
+const exportFlushRows = 500
+
 type FileHandler struct {
-	fileService *service.FileService
+	fileService   *service.FileService
+	uploads       *service.UploadTracker
+	scanService   *service.ScanService
+	userService   *service.UserService
+	folderDeletes *service.FolderDeleteService
+	editSessions  *service.EditSessionService
 }
 
-func NewFileHandler(fileService *service.FileService) *FileHandler {
-	return &FileHandler{fileService: fileService}
+func NewFileHandler(fileService *service.FileService, uploads *service.UploadTracker, scanService *service.ScanService, userService *service.UserService, folderDeletes *service.FolderDeleteService, editSessions *service.EditSessionService) *FileHandler {
+	return &FileHandler{fileService: fileService, uploads: uploads, scanService: scanService, userService: userService, folderDeletes: folderDeletes, editSessions: editSessions}
 }
 
 func (h *FileHandler) UploadFile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	file, err := c.FormFile("file")
+	uploadID, err := trackUpload(c, h.uploads, userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "File is required"})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	folderPath := c.PostForm("folder_path")
+	file, err := c.FormFile("file")
+	if err != nil {
+		h.uploads.Fail(uploadID, errFileRequired)
+		respondError(c, http.StatusBadRequest, errFileRequired)
+		return
+	}
 
-	uploadedFile, err := h.fileService.UploadFileWithFolder(userID.(uint), file, folderPath)
+	uploadedFile, err := h.fileService.UploadFileWithOptions(c.Request.Context(), userID.(uint), file, service.UploadOptions{
+		FolderPath: uploadFolder(c),
+		UploadID:   uploadID,
+		Replace:    true,
+		Source:     uploadSource(c),
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
+	setStorageHeaders(c, h.userService, userID.(uint))
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "File uploaded successfully",
+		"message": localize(c, "file_uploaded", "File uploaded successfully"),
 		"file":    uploadedFile,
 	})
 }
 
+func (h *FileHandler) GetUploadPolicy(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	policy, err := h.fileService.GetUploadPolicy(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errUploadPolicy)
+		return
+	}
+
+	c.JSON(http.StatusOK, policy)
+}
+
 func (h *FileHandler) GetFiles(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	q := parseListQuery(c, "folder", "recursive", "type", "kind", "sha256", "source", "tag", "q").sorted(c, "created_at", "desc")
 	folderPath := c.DefaultQuery("folder", "")
-	sortBy := c.DefaultQuery("sort_by", "created_at")
-	sortOrder := c.DefaultQuery("sort_order", "desc")
+	recursive := c.Query("recursive") == "true"
 
-	if page < 1 {
-		page = 1
+	filter, err := service.ParseListFilter(c.Query("type"), c.Query("kind"), c.Query("sha256"), c.Query("source"), c.Query("tag"))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
 	}
-	if pageSize < 1 || pageSize > 100 {
-		pageSize = 20
+	filter.Name = c.Query("q")
+
+	var files []model.File
+	var total int64
+	if recursive {
+		files, total, err = h.fileService.GetUserFilesRecursive(c.Request.Context(), userID.(uint), folderPath, filter, q.page, q.pageSize, q.sort.By, q.sort.Order)
+	} else {
+		files, total, err = h.fileService.GetUserFilesByFolder(c.Request.Context(), userID.(uint), folderPath, filter, q.page, q.pageSize, q.sort.By, q.sort.Order)
+	}
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFiles)
+		return
+	}
+
+	respondJSONWithETag(c, newListResponse(q, files, total))
+}
+
+// ExportFiles streams a listing as newline-delimited JSON, one file per
+// line, as rows are read from the database. It takes the filters and sort
+// of GetFiles but no pagination, so backup tools can enumerate an account
+// in a single request.
+func (h *FileHandler) ExportFiles(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
 	}
 
-	files, total, err := h.fileService.GetUserFilesByFolder(userID.(uint), folderPath, page, pageSize, sortBy, sortOrder)
+	filter, err := service.ParseListFilter(c.Query("type"), c.Query("kind"), c.Query("sha256"), c.Query("source"), c.Query("tag"))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch files"})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
+	filter.Name = c.Query("q")
 
-	c.JSON(http.StatusOK, gin.H{
-		"files": files,
-		"pagination": gin.H{
-			"page":        page,
-			"page_size":   pageSize,
-			"total":       total,
-			"total_pages": (total + int64(pageSize) - 1) / int64(pageSize),
-		},
-	})
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	encoder := json.NewEncoder(c.Writer)
+	rows := 0
+	err = h.fileService.ExportUserFiles(c.Request.Context(), userID.(uint), c.Query("folder"), c.Query("recursive") == "true",
+		filter, c.DefaultQuery("sort_by", "created_at"), c.DefaultQuery("sort_order", "desc"), func(file *model.File) error {
+			if err := encoder.Encode(file); err != nil {
+				return err
+			}
+			if rows++; rows%exportFlushRows == 0 {
+				c.Writer.Flush()
+			}
+			return nil
+		})
+	if err != nil {
+		// The status was already sent, so a last line tells clients the
+		// export is incomplete
+		log.Printf("[WARN] File export of user %d failed after %d rows: %v", userID.(uint), rows, err)
+		_, body := apperror.Render(c.GetString("lang"), http.StatusInternalServerError, errExportFailed)
+		encoder.Encode(body)
+	}
+	c.Writer.Flush()
 }
 
 func (h *FileHandler) GetFile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	file, err := h.fileService.GetFile(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusNotFound, service.ErrFileNotFound)
+		return
+	}
+
+	respondJSONWithETag(c, file)
+}
+
+type BatchGetRequest struct {
+	IDs []uint `json:"ids" binding:"required"`
+}
+
+func (h *FileHandler) BatchGetFiles(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req BatchGetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errIDsRequired)
+		return
+	}
+
+	files, itemErrors, err := h.fileService.GetFilesByIDs(c.Request.Context(), userID.(uint), req.IDs)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"files":  files,
+		"errors": itemErrors,
+	})
+}
+
+// BatchOperation deletes, moves, copies or tags the files listed by ID in
+// one transaction, reporting the IDs it skipped and why
+func (h *FileHandler) BatchOperation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req service.BatchOperation
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	// The route needs the upload scope; deleting needs the delete scope too
+	if req.Operation == service.BatchDelete && !hasScope(c, model.ScopeDelete) {
+		respondError(c, http.StatusForbidden, service.ErrInsufficientScope.WithArgs(model.ScopeDelete))
 		return
 	}
 
-	file, err := h.fileService.GetFile(uint(fileID))
+	result, err := h.fileService.Batch(c.Request.Context(), userID.(uint), req)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetMediaURLs exchanges the caller's credentials for short-lived signed URLs
+// of files, which the SPA puts in <img> and <video> tags
+func (h *FileHandler) GetMediaURLs(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	// Check if file belongs to user
-	if file.UserID != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	var req BatchGetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errIDsRequired)
 		return
 	}
 
-	c.JSON(http.StatusOK, file)
+	urls, err := h.fileService.GetMediaURLs(c.Request.Context(), userID.(uint), req.IDs)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, urls)
 }
 
 func (h *FileHandler) DownloadFile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
-	file, err := h.fileService.GetFile(uint(fileID))
+	file, err := h.fileService.GetFile(c.Request.Context(), uint(fileID), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "File not found"})
+		respondError(c, http.StatusNotFound, service.ErrFileNotFound)
 		return
 	}
 
-	// Check if file belongs to user
-	if file.UserID != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	if err := service.CheckDownload(file); err != nil {
+		respondError(c, http.StatusForbidden, err)
+		return
+	}
+
+	filePath, err := h.fileService.Locate(c.Request.Context(), file)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, err)
 		return
 	}
 
@@ -137,44 +300,301 @@ func (h *FileHandler) DownloadFile(c *gin.Context) {
 	c.Header("Content-Transfer-Encoding", "binary")
 	c.Header("Content-Disposition", "attachment; filename="+file.OriginalName)
 	c.Header("Content-Type", file.MimeType)
-	c.File(file.FilePath)
+	// The content of a file ID can change, e.g. when a text file is edited
+	c.Header("Cache-Control", cachePrivateRevalidate)
+	setFileETag(c, file)
+	serveFile(c, filePath, file.Compression, file.FileSize)
+}
+
+// ServeBlob serves the content with a SHA-256 checksum at /blob/:sha256.
+// The URL can only ever serve that content, so it is cached for good.
+func (h *FileHandler) ServeBlob(c *gin.Context) {
+	file, err := h.fileService.FindBlob(c.Request.Context(), c.Param("sha256"))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	filePath, err := h.fileService.Locate(c.Request.Context(), file)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	c.Header("Content-Type", file.MimeType)
+	c.Header("Cache-Control", cacheImmutable)
+	c.Writer = cacheOnSuccess{c.Writer}
+	setFileETag(c, file)
+	serveFile(c, filePath, file.Compression, file.FileSize)
+}
+
+// GetSignedURL returns a download URL of a file that works without
+// credentials until it expires; expires_in, e.g. "10m", shortens or extends it
+func (h *FileHandler) GetSignedURL(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	var expiresIn time.Duration
+	if value := c.Query("expires_in"); value != "" {
+		if expiresIn, err = time.ParseDuration(value); err != nil || expiresIn <= 0 {
+			respondError(c, http.StatusBadRequest, service.ErrInvalidExpiresIn.WithArgs(service.MaxSignedURLTTL.String()))
+			return
+		}
+	}
+
+	signed, err := h.fileService.GetSignedURL(c.Request.Context(), uint(fileID), userID.(uint), expiresIn)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, signed)
+}
+
+// SignedDownload serves a file as an attachment to holders of a valid signed
+// URL, without credentials
+func (h *FileHandler) SignedDownload(c *gin.Context) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	file, err := h.fileService.OpenSignedURL(c.Request.Context(), uint(fileID), c.Query("token"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	filePath, err := h.fileService.Locate(c.Request.Context(), file)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(file.OriginalName))
+	c.Header("Content-Type", file.MimeType)
+	// The link may be shared further, so only the holder's browser caches it
+	c.Header("Cache-Control", cachePrivateRevalidate)
+	setFileETag(c, file)
+	serveFile(c, filePath, file.Compression, file.FileSize)
 }
 
 func (h *FileHandler) DeleteFile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
-	if err := h.fileService.DeleteFile(uint(fileID), userID.(uint)); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	if err := h.fileService.DeleteFile(c.Request.Context(), uint(fileID), userID.(uint)); err != nil {
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "File deleted successfully"})
+	setStorageHeaders(c, h.userService, userID.(uint))
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "file_deleted", "File deleted successfully")})
 }
 
 func (h *FileHandler) GetFolders(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	folders, err := h.fileService.GetFolders(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFolders)
+		return
+	}
+
+	meta, err := h.fileService.GetFolderMeta(c.Request.Context(), userID.(uint), folders)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFolders)
+		return
+	}
+
+	c.JSON(http.StatusOK, struct {
+		ListResponse[string]
+		Meta []model.Folder `json:"meta"`
+	}{newFullListResponse(folders, nil), meta})
+}
+
+type CreateFolderRequest struct {
+	Path string `json:"path" binding:"required"`
+}
+
+// CreateFolder creates an empty folder, with its parents
+func (h *FileHandler) CreateFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req CreateFolderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errFolderPathRequired)
+		return
+	}
+
+	folder, err := h.fileService.CreateFolder(c.Request.Context(), userID.(uint), req.Path)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": localize(c, "folder_created", "Folder created successfully"), "folder": folder})
+}
+
+// GetFolder returns a folder by ID with its file count, size and subfolders
+func (h *FileHandler) GetFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	folderID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFolderID)
+		return
+	}
+
+	folder, err := h.fileService.GetFolder(c.Request.Context(), userID.(uint), uint(folderID))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, folder)
+}
+
+// GetFolderTree returns every folder of the caller as a tree
+func (h *FileHandler) GetFolderTree(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	tree, err := h.fileService.GetFolderTree(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFolders)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"folders": tree})
+}
+
+// DownloadFolder streams the folder in ?path= with its subfolders as a ZIP
+// archive, the whole account when path is empty
+func (h *FileHandler) DownloadFolder(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	archive, err := h.fileService.OpenFolderArchive(c.Request.Context(), userID.(uint), c.Query("path"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(archive.Name))
+	c.Header("Content-Type", "application/zip")
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+	if err := archive.WriteTo(c.Request.Context(), c.Writer); err != nil {
+		// The status was already sent; the archive lacks its central
+		// directory, so clients see it is incomplete
+		log.Printf("[WARN] Folder download of user %d failed: %v", userID.(uint), err)
+	}
+}
+
+// GetManifest streams one JSON line per file in the folder in ?path= and
+// its subfolders, the whole account when path is empty, for sync clients.
+// See FileService.StreamManifest for the order.
+func (h *FileHandler) GetManifest(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	// Headers go out with the first entry, so a missing folder still gets
+	// its error status
+	var encoder *json.Encoder
+	start := func() {
+		if encoder == nil {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Header("Cache-Control", "no-store")
+			c.Status(http.StatusOK)
+			encoder = json.NewEncoder(c.Writer)
+		}
+	}
+	rows := 0
+	err := h.fileService.StreamManifest(c.Request.Context(), userID.(uint), c.Query("path"), func(entry *service.ManifestEntry) error {
+		start()
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		if rows++; rows%exportFlushRows == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil && encoder == nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	start()
+	if err != nil {
+		log.Printf("[WARN] Manifest of user %d failed after %d rows: %v", userID.(uint), rows, err)
+		_, body := apperror.Render(c.GetString("lang"), http.StatusInternalServerError, errManifestFailed)
+		encoder.Encode(body)
+	}
+	c.Writer.Flush()
+}
+
+// UpdateFolderMeta stars, color-labels or describes a folder
+func (h *FileHandler) UpdateFolderMeta(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req service.FolderMetaInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errFolderPathRequired)
 		return
 	}
 
-	folders, err := h.fileService.GetFolders(userID.(uint))
+	folder, err := h.fileService.UpdateFolderMeta(c.Request.Context(), userID.(uint), req)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch folders"})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"folders": folders})
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "folder_updated", "Folder updated successfully"), "folder": folder})
 }
 
 type RenameFileRequest struct {
@@ -184,102 +604,216 @@ type RenameFileRequest struct {
 func (h *FileHandler) RenameFile(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
 	var req RenameFileRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Name is required"})
+		respondError(c, http.StatusBadRequest, errNameRequired)
+		return
+	}
+
+	file, err := h.fileService.RenameFile(c.Request.Context(), uint(fileID), userID.(uint), req.Name)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "file_renamed", "File renamed successfully"), "file": file})
+}
+
+type PinFileRequest struct {
+	Pinned *bool `json:"pinned" binding:"required"`
+}
+
+// PinFile exempts a file from lifecycle actions, or lifts the exemption
+func (h *FileHandler) PinFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	var req PinFileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, errPinnedRequired)
+		return
+	}
+
+	file, err := h.fileService.PinFile(c.Request.Context(), uint(fileID), userID.(uint), *req.Pinned)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	message := localize(c, "file_pinned", "File pinned")
+	if !file.Pinned {
+		message = localize(c, "file_unpinned", "File unpinned")
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "file": file})
+}
+
+// RelocateFile moves a file's stored file to a path mirroring its folder and
+// name
+func (h *FileHandler) RelocateFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
-	file, err := h.fileService.RenameFile(uint(fileID), userID.(uint), req.Name)
+	file, err := h.fileService.RelocateFile(c.Request.Context(), uint(fileID), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "File renamed successfully", "file": file})
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "file_relocated", "File relocated"), "file": file})
 }
 
 type RenameFolderRequest struct {
-	Path    string `json:"path" binding:"required"`
-	NewName string `json:"new_name" binding:"required"`
+	Path         string `json:"path" form:"path"`
+	NewName      string `json:"new_name" form:"new_name"`
+	DryRun       bool   `json:"dry_run" form:"dry_run"`
+	ConfirmToken string `json:"confirm_token" form:"confirm_token"`
 }
 
 func (h *FileHandler) RenameFolder(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	var req RenameFolderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Path and new_name are required"})
+	if err := bindFolderRequest(c, &req); err != nil || req.Path == "" || req.NewName == "" {
+		respondError(c, http.StatusBadRequest, errFolderNameRequired)
+		return
+	}
+
+	if req.DryRun {
+		summary, err := h.fileService.PreviewFolderRename(c.Request.Context(), userID.(uint), req.Path, req.NewName)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dry_run": true, "summary": summary})
 		return
 	}
 
-	if err := h.fileService.RenameFolder(userID.(uint), req.Path, req.NewName); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	summary, err := h.fileService.RenameFolder(c.Request.Context(), userID.(uint), req.Path, req.NewName, req.ConfirmToken)
+	if errors.Is(err, service.ErrConfirmationRequired) {
+		respondErrorWith(c, http.StatusConflict, err, gin.H{"summary": summary})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Folder renamed successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "folder_renamed", "Folder renamed successfully"), "summary": summary})
 }
 
 type DeleteFolderRequest struct {
-	Path string `json:"path" binding:"required"`
+	Path         string `json:"path" form:"path"`
+	DryRun       bool   `json:"dry_run" form:"dry_run"`
+	ConfirmToken string `json:"confirm_token" form:"confirm_token"`
 }
 
 func (h *FileHandler) DeleteFolder(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	var req DeleteFolderRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Path is required"})
+	if err := bindFolderRequest(c, &req); err != nil || req.Path == "" {
+		respondError(c, http.StatusBadRequest, errFolderPathRequired)
+		return
+	}
+
+	if req.DryRun {
+		summary, err := h.fileService.PreviewFolderOperation(c.Request.Context(), userID.(uint), "delete", req.Path)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"dry_run": true, "summary": summary})
 		return
 	}
 
-	if err := h.fileService.DeleteFolder(userID.(uint), req.Path); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	summary, job, err := h.folderDeletes.Start(c.Request.Context(), userID.(uint), req.Path, req.ConfirmToken)
+	if errors.Is(err, service.ErrConfirmationRequired) {
+		respondErrorWith(c, http.StatusConflict, err, gin.H{"summary": summary})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Folder deleted successfully"})
+	// Progress is reported by GET /api/jobs/:id
+	c.JSON(http.StatusAccepted, gin.H{"message": localize(c, "folder_deleting", "Folder deletion started"), "summary": summary, "job": job})
+}
+
+// bindFolderRequest reads folder operation parameters from the query string,
+// falling back to a JSON body for clients that still send one
+func bindFolderRequest(c *gin.Context, req interface{}) error {
+	if err := c.ShouldBindQuery(req); err != nil {
+		return err
+	}
+	if c.Request.ContentLength > 0 {
+		return c.ShouldBindJSON(req)
+	}
+	return nil
 }
 
 func (h *FileHandler) GetFileContent(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
-	content, err := h.fileService.GetFileContent(uint(fileID), userID.(uint))
+	content, err := h.fileService.GetFileContent(c.Request.Context(), uint(fileID), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	// Tells the reader who else is editing the file, if anyone
+	session, err := h.editSessions.Get(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"content": content})
+	c.JSON(http.StatusOK, gin.H{"content": content, "edit_session": session})
 }
 
 type UpdateContentRequest struct {
@@ -289,45 +823,305 @@ type UpdateContentRequest struct {
 func (h *FileHandler) UpdateFileContent(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid file ID"})
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
 	var req UpdateContentRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	file, holder, err := h.editSessions.UpdateContent(c.Request.Context(), uint(fileID), userID.(uint), c.GetHeader("X-Edit-Token"), req.Content)
+	if errors.Is(err, service.ErrFileLocked) {
+		respondErrorWith(c, http.StatusConflict, err, gin.H{"edit_session": holder})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "file_updated", "File updated successfully"), "file": file})
+}
+
+type EditSessionRequest struct {
+	// TTLSeconds is how long the session lasts without a heartbeat,
+	// service.DefaultEditSessionTTL when omitted
+	TTLSeconds int `json:"ttl_seconds"`
+	// Holder names the editor to others, the username when omitted
+	Holder string `json:"holder" binding:"max=100"`
+}
+
+// StartEditSession locks a text file for the caller for ttl_seconds. The
+// token of the session is sent as X-Edit-Token with heartbeats, the release
+// and the saved content.
+func (h *FileHandler) StartEditSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	var req EditSessionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+	holder := strings.TrimSpace(req.Holder)
+	if holder == "" {
+		holder = c.MustGet("user").(*model.User).Username
+	}
+
+	session, err := h.editSessions.Acquire(c.Request.Context(), uint(fileID), userID.(uint), holder, time.Duration(req.TTLSeconds)*time.Second)
+	if errors.Is(err, service.ErrFileLocked) {
+		respondErrorWith(c, http.StatusConflict, err, gin.H{"edit_session": session})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"edit_session": session})
+}
+
+// HeartbeatEditSession extends the edit session in X-Edit-Token by
+// ttl_seconds from now
+func (h *FileHandler) HeartbeatEditSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	var req EditSessionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	session, err := h.editSessions.Heartbeat(c.Request.Context(), uint(fileID), userID.(uint), c.GetHeader("X-Edit-Token"), time.Duration(req.TTLSeconds)*time.Second)
+	if errors.Is(err, service.ErrFileLocked) {
+		respondErrorWith(c, http.StatusConflict, err, gin.H{"edit_session": session})
+		return
+	}
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"edit_session": session})
+}
+
+// EndEditSession releases the edit session in X-Edit-Token
+func (h *FileHandler) EndEditSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	if err := h.editSessions.Release(c.Request.Context(), uint(fileID), userID.(uint), c.GetHeader("X-Edit-Token")); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "edit_session_ended", "Edit session ended")})
+}
+
+// parseVersionParams reads the file ID and version number of a version route
+func parseVersionParams(c *gin.Context) (uint, int, bool) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return 0, 0, false
+	}
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		respondError(c, http.StatusBadRequest, errInvalidVersion)
+		return 0, 0, false
+	}
+	return uint(fileID), version, true
+}
+
+// ListVersions returns the earlier versions of a file next to the file itself
+func (h *FileHandler) ListVersions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
 		return
 	}
 
-	file, err := h.fileService.UpdateFileContent(uint(fileID), userID.(uint), req.Content)
+	file, versions, err := h.fileService.ListVersions(c.Request.Context(), uint(fileID), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusInternalServerError, err)
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "File updated successfully", "file": file})
+	c.JSON(http.StatusOK, struct {
+		ListResponse[model.FileVersion]
+		File *model.File `json:"file"`
+	}{newFullListResponse(versions, nil), file})
+}
+
+func (h *FileHandler) DownloadVersion(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, version, ok := parseVersionParams(c)
+	if !ok {
+		return
+	}
+
+	file, err := h.fileService.OpenVersion(c.Request.Context(), fileID, userID.(uint), version)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	filePath, err := h.fileService.Locate(c.Request.Context(), file)
+	if err != nil {
+		respondError(c, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(file.OriginalName))
+	c.Header("Content-Type", file.MimeType)
+	// Versions never change, but they are removed once enough newer ones exist
+	c.Header("Cache-Control", cachePrivateRevalidate)
+	setFileETag(c, file)
+	serveFile(c, filePath, file.Compression, file.FileSize)
+}
+
+// RestoreVersion makes an earlier version the current content of a file
+func (h *FileHandler) RestoreVersion(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, version, ok := parseVersionParams(c)
+	if !ok {
+		return
+	}
+
+	file, err := h.fileService.RestoreVersion(c.Request.Context(), fileID, userID.(uint), version)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	setStorageHeaders(c, h.userService, userID.(uint))
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "version_restored", "Version restored"), "file": file})
+}
+
+// RescanFile queues a new virus scan of a file
+func (h *FileHandler) RescanFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	file, err := h.scanService.Rescan(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": localize(c, "rescan_queued", "File queued for scanning"), "file": file})
 }
 
 func (h *FileHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	router.GET("/files/:id/signed", h.SignedDownload)
+
 	protected := router.Group("")
 	protected.Use(authMiddleware)
 	{
-		protected.POST("/upload", h.UploadFile)
-		protected.GET("/files", h.GetFiles)
-		protected.GET("/files/:id", h.GetFile)
-		protected.PUT("/files/:id/rename", h.RenameFile)
-		protected.GET("/files/:id/content", h.GetFileContent)
-		protected.PUT("/files/:id/content", h.UpdateFileContent)
-		protected.GET("/folders", h.GetFolders)
-		protected.PUT("/folders/rename", h.RenameFolder)
-		protected.DELETE("/folders", h.DeleteFolder)
-		protected.GET("/download/:id", h.DownloadFile)
-		protected.DELETE("/files/:id", h.DeleteFile)
+		protected.POST("/upload", requireUpload(), h.UploadFile)
+		protected.GET("/upload-policy", requireScope(model.ScopeRead), h.GetUploadPolicy)
+		protected.GET("/files", requireScope(model.ScopeRead), h.GetFiles)
+		protected.GET("/files/export", requireScope(model.ScopeRead), h.ExportFiles)
+		protected.POST("/files/batch-get", requireScope(model.ScopeRead), h.BatchGetFiles)
+		protected.POST("/files/batch", requireScope(model.ScopeUpload), h.BatchOperation)
+		protected.POST("/files/media-urls", requireScope(model.ScopeRead), h.GetMediaURLs)
+		protected.GET("/files/:id", requireScope(model.ScopeRead), h.GetFile)
+		protected.PUT("/files/:id/rename", requireScope(model.ScopeUpload), h.RenameFile)
+		protected.PUT("/files/:id/pin", requireScope(model.ScopeUpload), h.PinFile)
+		protected.POST("/files/:id/relocate", requireScope(model.ScopeUpload), h.RelocateFile)
+		protected.GET("/files/:id/content", requireScope(model.ScopeRead), h.GetFileContent)
+		protected.PUT("/files/:id/content", requireScope(model.ScopeUpload), h.UpdateFileContent)
+		protected.POST("/files/:id/edit-session", requireScope(model.ScopeUpload), h.StartEditSession)
+		protected.PUT("/files/:id/edit-session", requireScope(model.ScopeUpload), h.HeartbeatEditSession)
+		protected.DELETE("/files/:id/edit-session", requireScope(model.ScopeUpload), h.EndEditSession)
+		protected.GET("/files/:id/versions", requireScope(model.ScopeRead), h.ListVersions)
+		protected.GET("/files/:id/versions/:version/download", requireScope(model.ScopeRead), h.DownloadVersion)
+		protected.POST("/files/:id/versions/:version/restore", requireScope(model.ScopeUpload), h.RestoreVersion)
+		protected.POST("/files/:id/rescan", requireScope(model.ScopeUpload), h.RescanFile)
+		protected.GET("/files/:id/signed-url", requireScope(model.ScopeRead), h.GetSignedURL)
+		protected.GET("/folders", requireScope(model.ScopeRead), h.GetFolders)
+		protected.POST("/folders", requireUpload(), h.CreateFolder)
+		protected.GET("/folders/tree", requireScope(model.ScopeRead), h.GetFolderTree)
+		protected.GET("/folders/:id", requireScope(model.ScopeRead), h.GetFolder)
+		protected.GET("/folders/download", requireScope(model.ScopeRead), h.DownloadFolder)
+		protected.GET("/folders/manifest", requireScope(model.ScopeRead), h.GetManifest)
+		protected.PUT("/folders/rename", requireScope(model.ScopeUpload), h.RenameFolder)
+		protected.PUT("/folders/meta", requireScope(model.ScopeUpload), h.UpdateFolderMeta)
+		protected.DELETE("/folders", requireScope(model.ScopeDelete), h.DeleteFolder)
+		protected.GET("/download/:id", requireScope(model.ScopeRead), h.DownloadFile)
+		protected.DELETE("/files/:id", requireScope(model.ScopeDelete), h.DeleteFile)
 	}
 }
+
+// RegisterPublicRoutes serves content-addressed URLs, which need no
+// credentials like /uploads
+func (h *FileHandler) RegisterPublicRoutes(router *gin.Engine) {
+	router.GET("/blob/:sha256", h.ServeBlob)
+	router.HEAD("/blob/:sha256", h.ServeBlob)
+}
@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type FolderRuleHandler struct {
+	ruleService *service.FolderRuleService
+}
+
+func NewFolderRuleHandler(ruleService *service.FolderRuleService) *FolderRuleHandler {
+	return &FolderRuleHandler{ruleService: ruleService}
+}
+
+// ListRules returns the user's folder rules, or only those of ?folder_path=
+func (h *FolderRuleHandler) ListRules(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	q := parseListQuery(c, "folder_path")
+	var folderPath *string
+	if folder, ok := q.filters["folder_path"]; ok {
+		folderPath = &folder
+	}
+
+	rules, err := h.ruleService.ListRules(c.Request.Context(), userID.(uint), folderPath)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchFolderRules)
+		return
+	}
+
+	c.JSON(http.StatusOK, newFullListResponse(rules, q.filters))
+}
+
+func (h *FolderRuleHandler) CreateRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req service.FolderRuleInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	rule, err := h.ruleService.CreateRule(c.Request.Context(), userID.(uint), req)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+func (h *FolderRuleHandler) UpdateRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidRuleID)
+		return
+	}
+
+	var req service.FolderRuleInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	rule, err := h.ruleService.UpdateRule(c.Request.Context(), uint(ruleID), userID.(uint), req)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+func (h *FolderRuleHandler) DeleteRule(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidRuleID)
+		return
+	}
+
+	if err := h.ruleService.DeleteRule(c.Request.Context(), uint(ruleID), userID.(uint)); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "folder_rule_deleted", "Folder rule deleted successfully")})
+}
+
+func (h *FolderRuleHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/folder-rules", requireScope(model.ScopeRead), h.ListRules)
+		protected.POST("/folder-rules", requireScope(model.ScopeUpload), h.CreateRule)
+		protected.PUT("/folder-rules/:id", requireScope(model.ScopeUpload), h.UpdateRule)
+		protected.DELETE("/folder-rules/:id", requireScope(model.ScopeDelete), h.DeleteRule)
+	}
+}
@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// FrontendHandler serves the single-page app from disk when the build directory
+// exists, falling back to the frontend embedded in the binary
+type FrontendHandler struct {
+	files fs.FS
+}
+
+func NewFrontendHandler(diskPath string, embedded fs.FS) *FrontendHandler {
+	if info, err := os.Stat(diskPath); err == nil && info.IsDir() {
+		log.Printf("Serving frontend from %s", diskPath)
+		return &FrontendHandler{files: os.DirFS(diskPath)}
+	}
+	if embedded != nil {
+		log.Printf("Serving embedded frontend")
+	}
+	return &FrontendHandler{files: embedded}
+}
+
+func (h *FrontendHandler) serve(c *gin.Context) {
+	path := strings.TrimPrefix(c.Param("path"), "/")
+	// Check if file exists (for assets)
+	if path != "" {
+		if info, err := fs.Stat(h.files, path); err == nil && !info.IsDir() {
+			c.FileFromFS(path, http.FS(h.files))
+			return
+		}
+	}
+
+	// SPA fallback - serve index.html for all other routes
+	index, err := fs.ReadFile(h.files, "index.html")
+	if err != nil {
+		c.Status(http.StatusNotFound)
+		return
+	}
+	c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+}
+
+func (h *FrontendHandler) RegisterRoutes(router *gin.Engine) {
+	if h.files == nil {
+		return
+	}
+	router.GET("/app", h.serve)
+	router.GET("/app/*path", h.serve)
+}
@@ -2,69 +2,114 @@ package handler
 
 import (
 	"net/http"
+	"storage-service/internal/model"
 	"storage-service/internal/service"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
 type ImageHandler struct {
-	imageService *service.ImageService
+	imageService  *service.ImageService
+	uploads       *service.UploadTracker
+	proxy         *service.ImageProxyService
+	directUploads *service.DirectUploadService
+	userService   *service.UserService
 }
 
-func NewImageHandler(imageService *service.ImageService) *ImageHandler {
-	return &ImageHandler{imageService: imageService}
+func NewImageHandler(imageService *service.ImageService, uploads *service.UploadTracker, proxy *service.ImageProxyService, directUploads *service.DirectUploadService, userService *service.UserService) *ImageHandler {
+	return &ImageHandler{imageService: imageService, uploads: uploads, proxy: proxy, directUploads: directUploads, userService: userService}
 }
 
 func (h *ImageHandler) UploadImage(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	file, err := c.FormFile("image")
+	uploadID, err := trackUpload(c, h.uploads, userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Image is required"})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	folderPath := c.PostForm("folder_path")
+	file, err := c.FormFile("image")
+	if err != nil {
+		h.uploads.Fail(uploadID, errImageRequired)
+		respondError(c, http.StatusBadRequest, errImageRequired)
+		return
+	}
 
-	uploadedFile, err := h.imageService.UploadImageWithFolder(userID.(uint), file, folderPath)
+	uploadedFile, err := h.imageService.UploadImageWithOptions(c.Request.Context(), userID.(uint), file, service.UploadOptions{
+		FolderPath: uploadFolder(c),
+		UploadID:   uploadID,
+		Profile:    c.PostForm("profile"),
+		Source:     uploadSource(c),
+	})
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
+	setStorageHeaders(c, h.userService, userID.(uint))
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "Image uploaded and optimized successfully",
+		"message": localize(c, "image_uploaded", "Image uploaded and optimized successfully"),
 		"file":    uploadedFile,
 	})
 }
 
-func (h *ImageHandler) GetImageInfo(c *gin.Context) {
+// ListImages lists the user's images in a folder with their thumbnails,
+// filtered by ?camera= and ?taken_after=, ?taken_before= (RFC 3339)
+func (h *ImageHandler) ListImages(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	q := parseListQuery(c, "folder", "recursive", "camera", "taken_after", "taken_before").sorted(c, "created_at", "desc")
+	folderPath := c.DefaultQuery("folder", "")
+	recursive := c.Query("recursive") == "true"
+
+	exif := service.ExifFilter{Camera: c.Query("camera")}
+	for param, target := range map[string]*time.Time{"taken_after": &exif.TakenAfter, "taken_before": &exif.TakenBefore} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				respondError(c, http.StatusBadRequest, errInvalidFileFilter.WithArgs(param))
+				return
+			}
+			*target = t
+		}
+	}
+
+	images, total, err := h.imageService.ListImages(c.Request.Context(), userID.(uint), folderPath, recursive, exif, q.page, q.pageSize, q.sort.By, q.sort.Order)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid image ID"})
+		respondError(c, http.StatusInternalServerError, errFetchFiles)
 		return
 	}
 
-	file, info, err := h.imageService.GetImageInfo(uint(fileID))
+	c.JSON(http.StatusOK, newListResponse(q, images, total))
+}
+
+func (h *ImageHandler) GetImageInfo(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Image not found"})
+		respondError(c, http.StatusBadRequest, errInvalidImageID)
 		return
 	}
 
-	// Check if file belongs to user
-	if file.UserID != userID.(uint) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Access denied"})
+	file, info, err := h.imageService.GetImageInfo(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusNotFound, service.ErrImageNotFound)
 		return
 	}
 
@@ -79,11 +124,157 @@ func (h *ImageHandler) GetImageInfo(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
+// ProxyImage fetches a remote image, optimizes it to the requested width and
+// serves it from the requesting user's cache
+func (h *ImageHandler) ProxyImage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	rawURL := c.Query("url")
+	if rawURL == "" {
+		respondError(c, http.StatusBadRequest, errProxyURLRequired)
+		return
+	}
+
+	width := 0
+	if w := c.Query("w"); w != "" {
+		parsed, err := strconv.Atoi(w)
+		if err != nil {
+			respondError(c, http.StatusBadRequest, service.ErrInvalidProxyWidth.WithArgs(service.ProxyMaxWidth))
+			return
+		}
+		width = parsed
+	}
+
+	image, err := h.proxy.Fetch(c.Request.Context(), userID.(uint), rawURL, width)
+	if err != nil {
+		respondError(c, http.StatusBadGateway, err)
+		return
+	}
+
+	cacheStatus := "MISS"
+	if image.Cached {
+		cacheStatus = "HIT"
+	}
+	c.Header("X-Cache", cacheStatus)
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("Content-Type", image.MimeType)
+	c.File(image.FilePath)
+}
+
+type CreateDirectUploadRequest struct {
+	Filename    string `json:"filename" binding:"required"`
+	Size        int64  `json:"size" binding:"required"`
+	ContentType string `json:"content_type" binding:"required"`
+	FolderPath  string `json:"folder_path"`
+	Profile     string `json:"profile"`
+}
+
+// CreateDirectUpload returns a short-lived URL the client uploads the
+// original image to, bypassing synchronous processing
+func (h *ImageHandler) CreateDirectUpload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req CreateDirectUploadRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+	if req.FolderPath == "" {
+		req.FolderPath = c.GetString("default_folder")
+	}
+
+	upload, err := h.directUploads.Create(c.Request.Context(), userID.(uint), service.DirectUploadRequest{
+		Filename:    req.Filename,
+		Size:        req.Size,
+		ContentType: req.ContentType,
+		FolderPath:  req.FolderPath,
+		Profile:     req.Profile,
+		Label:       uploadSource(c).Label,
+	})
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, upload)
+}
+
+// ReceiveDirectUpload stores the raw request body sent to an upload URL. The
+// token in the URL authorizes the request.
+func (h *ImageHandler) ReceiveDirectUpload(c *gin.Context) {
+	file, err := h.directUploads.Receive(c.Request.Context(), c.Param("token"), c.Request.Body, uploadClient(c))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	setStorageHeaders(c, h.userService, file.UserID)
+	c.JSON(http.StatusCreated, gin.H{
+		"message": localize(c, "file_uploaded", "File uploaded successfully"),
+		"file":    file,
+	})
+}
+
+type ProcessImageRequest struct {
+	// Profile overrides the profile given when the upload URL was created
+	Profile string `json:"profile"`
+}
+
+// ProcessImage queues optimization of a directly uploaded image
+func (h *ImageHandler) ProcessImage(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidImageID)
+		return
+	}
+
+	var req ProcessImageRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	file, job, err := h.directUploads.Process(c.Request.Context(), uint(fileID), userID.(uint), req.Profile)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": localize(c, "processing_queued", "Image queued for processing"),
+		"file":    file,
+		"job":     job,
+	})
+}
+
 func (h *ImageHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
 	protected := router.Group("")
 	protected.Use(authMiddleware)
 	{
-		protected.POST("/upload-image", h.UploadImage)
-		protected.GET("/images/:id", h.GetImageInfo)
+		protected.POST("/upload-image", requireUpload(), h.UploadImage)
+		protected.POST("/images/uploads", requireUpload(), h.CreateDirectUpload)
+		protected.POST("/images/:id/process", requireScope(model.ScopeUpload), h.ProcessImage)
+		protected.GET("/images", requireScope(model.ScopeRead), h.ListImages)
+		protected.GET("/images/:id", requireScope(model.ScopeRead), h.GetImageInfo)
+		protected.GET("/image-proxy", requireScope(model.ScopeRead), h.ProxyImage)
 	}
+
+	// Upload URLs carry their own authorization
+	router.PUT("/images/direct/:token", h.ReceiveDirectUpload)
 }
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// JobHandler lets users follow the background jobs started by them or on
+// their behalf, such as folder deletes and transcodes of their uploads, and
+// cancel some of them
+type JobHandler struct {
+	jobService *service.JobService
+}
+
+func NewJobHandler(jobService *service.JobService) *JobHandler {
+	return &JobHandler{jobService: jobService}
+}
+
+// ListJobs lists the caller's jobs, newest first, filtered by ?type= and
+// ?status=
+func (h *JobHandler) ListJobs(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	q := parseListQuery(c, "type", "status")
+	jobs, total, err := h.jobService.ListUserJobs(c.Request.Context(), userID.(uint), c.Query("type"), c.Query("status"), q.page, q.pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchJobs)
+		return
+	}
+
+	c.JSON(http.StatusOK, newListResponse(q, jobs, total))
+}
+
+func (h *JobHandler) GetJob(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidJobID)
+		return
+	}
+
+	job, err := h.jobService.GetUserJob(c.Request.Context(), uint(jobID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+// CancelJob stops a folder delete after its current batch; what it did
+// already stays done
+func (h *JobHandler) CancelJob(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	jobID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidJobID)
+		return
+	}
+
+	job, err := h.jobService.CancelUserJob(c.Request.Context(), uint(jobID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "job_cancelled", "Job cancelled"),
+		"job":     job,
+	})
+}
+
+func (h *JobHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/jobs", requireScope(model.ScopeRead), h.ListJobs)
+		protected.GET("/jobs/:id", requireScope(model.ScopeRead), h.GetJob)
+		protected.POST("/jobs/:id/cancel", requireScope(model.ScopeUpload), h.CancelJob)
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Pagination describes the page of a list response. Lists that are not
+// paginated are returned as a single page.
+type Pagination struct {
+	Page       int   `json:"page"`
+	PageSize   int   `json:"page_size"`
+	Total      int64 `json:"total"`
+	TotalPages int64 `json:"total_pages"`
+}
+
+// ListSort is the order a list was requested in
+type ListSort struct {
+	By    string `json:"by"`
+	Order string `json:"order"`
+}
+
+// ListResponse is the envelope of every list endpoint: the items, the page
+// and the filters and sort that were applied, so clients can page through
+// any list the same way. Endpoints returning more than the list embed it.
+type ListResponse[T any] struct {
+	Items      []T               `json:"items"`
+	Pagination Pagination        `json:"pagination"`
+	Filters    map[string]string `json:"filters"`
+	Sort       *ListSort         `json:"sort,omitempty"`
+}
+
+// listQuery holds the page, sort and filters of a list request
+type listQuery struct {
+	page, pageSize int
+	sort           *ListSort
+	filters        map[string]string
+}
+
+// parseListQuery reads ?page= and ?page_size= (at most 100, 20 by default)
+// and records which of the given filter parameters the request set
+func parseListQuery(c *gin.Context, filters ...string) *listQuery {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	pageSize, _ := strconv.Atoi(c.DefaultQuery("page_size", "20"))
+	if page < 1 {
+		page = 1
+	}
+	if pageSize < 1 || pageSize > 100 {
+		pageSize = 20
+	}
+
+	q := &listQuery{page: page, pageSize: pageSize, filters: map[string]string{}}
+	for _, param := range filters {
+		if value, ok := c.GetQuery(param); ok {
+			q.filters[param] = value
+		}
+	}
+	return q
+}
+
+// sorted reads ?sort_by= and ?sort_order=, falling back to the defaults
+func (q *listQuery) sorted(c *gin.Context, sortBy, sortOrder string) *listQuery {
+	q.sort = &ListSort{By: c.DefaultQuery("sort_by", sortBy), Order: c.DefaultQuery("sort_order", sortOrder)}
+	return q
+}
+
+// newListResponse wraps one page of items out of total
+func newListResponse[T any](q *listQuery, items []T, total int64) ListResponse[T] {
+	if items == nil {
+		items = []T{}
+	}
+	return ListResponse[T]{
+		Items: items,
+		Pagination: Pagination{
+			Page:       q.page,
+			PageSize:   q.pageSize,
+			Total:      total,
+			TotalPages: (total + int64(q.pageSize) - 1) / int64(q.pageSize),
+		},
+		Filters: q.filters,
+		Sort:    q.sort,
+	}
+}
+
+// newFullListResponse wraps a list that is returned whole
+func newFullListResponse[T any](items []T, filters map[string]string) ListResponse[T] {
+	if items == nil {
+		items = []T{}
+	}
+	if filters == nil {
+		filters = map[string]string{}
+	}
+	total := int64(len(items))
+	return ListResponse[T]{
+		Items:      items,
+		Pagination: Pagination{Page: 1, PageSize: len(items), Total: total, TotalPages: min(total, 1)},
+		Filters:    filters,
+	}
+}
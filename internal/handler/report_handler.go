@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/csv"
+	"net/http"
+	"storage-service/internal/service"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultReportLimit  = 50
+	maxReportLimit      = 1000
+	defaultReportWindow = "30d"
+)
+
+type ReportHandler struct {
+	reportService *service.ReportService
+}
+
+func NewReportHandler(reportService *service.ReportService) *ReportHandler {
+	return &ReportHandler{reportService: reportService}
+}
+
+// LargestFiles lists the biggest files of all users, ?limit= of them
+func (h *ReportHandler) LargestFiles(c *gin.Context) {
+	limit := reportLimit(c)
+	files, err := h.reportService.LargestFiles(c.Request.Context(), limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errStorageReport)
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := make([][]string, 0, len(files))
+		for _, file := range files {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(file.ID), 10), strconv.FormatUint(uint64(file.UserID), 10),
+				file.FolderPath, file.OriginalName, file.MimeType,
+				strconv.FormatInt(file.FileSize, 10), file.CreatedAt.UTC().Format(time.RFC3339),
+			})
+		}
+		respondCSV(c, "largest-files", []string{"id", "user_id", "folder_path", "name", "mime_type", "file_size", "created_at"}, rows)
+		return
+	}
+	c.JSON(http.StatusOK, newFullListResponse(files, map[string]string{"limit": strconv.Itoa(limit)}))
+}
+
+// FastestGrowingUsers lists the users who added the most bytes in the
+// ?window= (default 30d), ?limit= of them
+func (h *ReportHandler) FastestGrowingUsers(c *gin.Context) {
+	window, ok := reportWindow(c)
+	if !ok {
+		return
+	}
+	limit := reportLimit(c)
+	users, err := h.reportService.FastestGrowingUsers(c.Request.Context(), window, limit)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errStorageReport)
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := make([][]string, 0, len(users))
+		for _, user := range users {
+			rows = append(rows, []string{
+				strconv.FormatUint(uint64(user.UserID), 10), user.Username,
+				strconv.FormatInt(user.Files, 10), strconv.FormatInt(user.Bytes, 10),
+			})
+		}
+		respondCSV(c, "user-growth", []string{"user_id", "username", "files", "bytes"}, rows)
+		return
+	}
+	c.JSON(http.StatusOK, newFullListResponse(users, map[string]string{
+		"window": c.DefaultQuery("window", defaultReportWindow),
+		"limit":  strconv.Itoa(limit),
+	}))
+}
+
+// IngestByDay lists the files and bytes uploaded on each day of the
+// ?window= (default 30d)
+func (h *ReportHandler) IngestByDay(c *gin.Context) {
+	window, ok := reportWindow(c)
+	if !ok {
+		return
+	}
+	days, err := h.reportService.IngestByDay(c.Request.Context(), window)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errStorageReport)
+		return
+	}
+
+	if c.Query("format") == "csv" {
+		rows := make([][]string, 0, len(days))
+		for _, day := range days {
+			rows = append(rows, []string{day.Day, strconv.FormatInt(day.Files, 10), strconv.FormatInt(day.Bytes, 10)})
+		}
+		respondCSV(c, "ingest", []string{"day", "files", "bytes"}, rows)
+		return
+	}
+	c.JSON(http.StatusOK, newFullListResponse(days, map[string]string{"window": c.DefaultQuery("window", defaultReportWindow)}))
+}
+
+// reportLimit reads ?limit=, keeping it within 1 and maxReportLimit
+func reportLimit(c *gin.Context) int {
+	limit, err := strconv.Atoi(c.Query("limit"))
+	if err != nil || limit < 1 {
+		return defaultReportLimit
+	}
+	return min(limit, maxReportLimit)
+}
+
+// reportWindow reads ?window=, answering the request when it is invalid
+func reportWindow(c *gin.Context) (time.Duration, bool) {
+	window, err := service.ParseReportWindow(c.DefaultQuery("window", defaultReportWindow))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return 0, false
+	}
+	return window, true
+}
+
+// respondCSV sends a report as a CSV download named name.csv
+func respondCSV(c *gin.Context, name string, header []string, rows [][]string) {
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(name+".csv"))
+	c.Header("Cache-Control", "no-store")
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write(header)
+	writer.WriteAll(rows)
+}
+
+func (h *ReportHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc) {
+	admin := router.Group("/admin")
+	admin.Use(authMiddleware, adminMiddleware)
+	{
+		admin.GET("/reports/largest-files", h.LargestFiles)
+		admin.GET("/reports/user-growth", h.FastestGrowingUsers)
+		admin.GET("/reports/ingest", h.IngestByDay)
+	}
+}
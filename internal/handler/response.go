@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors raised by handlers before reaching a service
+var (
+	errUnauthorized       = apperror.New(http.StatusUnauthorized, "unauthorized", "Unauthorized")
+	errFileRequired       = apperror.New(http.StatusBadRequest, "file_required", "File is required")
+	errImageRequired      = apperror.New(http.StatusBadRequest, "image_required", "Image is required")
+	errInvalidFileID      = apperror.New(http.StatusBadRequest, "invalid_file_id", "Invalid file ID")
+	errInvalidImageID     = apperror.New(http.StatusBadRequest, "invalid_image_id", "Invalid image ID")
+	errProxyURLRequired   = apperror.New(http.StatusBadRequest, "proxy_url_required", "url is required")
+	errNameRequired       = apperror.New(http.StatusBadRequest, "name_required", "Name is required")
+	errFolderNameRequired = apperror.New(http.StatusBadRequest, "folder_name_required", "Path and new_name are required")
+	errFolderPathRequired = apperror.New(http.StatusBadRequest, "folder_path_required", "Path is required")
+	errInvalidFolderID    = apperror.New(http.StatusBadRequest, "invalid_folder_id", "Invalid folder ID")
+	errIDsRequired        = apperror.New(http.StatusBadRequest, "ids_required", "ids is required")
+	errPinnedRequired     = apperror.New(http.StatusBadRequest, "pinned_required", "pinned is required")
+	errInvalidVersion     = apperror.New(http.StatusBadRequest, "invalid_version", "Invalid version")
+	errFetchFiles         = apperror.New(http.StatusInternalServerError, "fetch_files_failed", "Failed to fetch files")
+	errFetchFolders       = apperror.New(http.StatusInternalServerError, "fetch_folders_failed", "Failed to fetch folders")
+	errUploadPolicy       = apperror.New(http.StatusInternalServerError, "upload_policy_failed", "Failed to get upload policy")
+	errRegenerateKey      = apperror.New(http.StatusInternalServerError, "regenerate_key_failed", "Failed to regenerate API key")
+	errUserStats          = apperror.New(http.StatusInternalServerError, "user_stats_failed", "Failed to get user stats")
+	errUserSettings       = apperror.New(http.StatusInternalServerError, "user_settings_failed", "Failed to get user settings")
+	errUpdateSettings     = apperror.New(http.StatusInternalServerError, "update_settings_failed", "Failed to update settings")
+	errPasswordReset      = apperror.New(http.StatusInternalServerError, "password_reset_failed", "Failed to send password reset email")
+	errActorNotAllowed    = apperror.New(http.StatusForbidden, "credentials_on_behalf", "Credentials cannot be changed on behalf of another user")
+	errOnBehalfNotAllowed = apperror.New(http.StatusForbidden, "route_on_behalf", "Service accounts can only read and upload files on behalf of another user")
+	errInvalidSessionID   = apperror.New(http.StatusBadRequest, "invalid_session_id", "Invalid session ID")
+	errSessionRequired    = apperror.New(http.StatusBadRequest, "session_required", "This request is not authenticated with a session")
+	errFetchSessions      = apperror.New(http.StatusInternalServerError, "fetch_sessions_failed", "Failed to fetch sessions")
+	errFetchActivity      = apperror.New(http.StatusInternalServerError, "fetch_activity_failed", "Failed to fetch activity")
+	errInvalidUserID      = apperror.New(http.StatusBadRequest, "invalid_user_id", "Invalid user ID")
+	errInvalidAPIKeyID    = apperror.New(http.StatusBadRequest, "invalid_api_key_id", "Invalid API key ID")
+	errFetchAPIKeys       = apperror.New(http.StatusInternalServerError, "fetch_api_keys_failed", "Failed to fetch API keys")
+	errUploadSession      = apperror.New(http.StatusInternalServerError, "create_upload_session_failed", "Failed to create upload session")
+	errAdminStats         = apperror.New(http.StatusInternalServerError, "admin_stats_failed", "Failed to get admin stats")
+	errStorageReport      = apperror.New(http.StatusInternalServerError, "storage_report_failed", "Failed to build storage report")
+	errInvalidJobID       = apperror.New(http.StatusBadRequest, "invalid_job_id", "Invalid job ID")
+	errFetchJobs          = apperror.New(http.StatusInternalServerError, "fetch_jobs_failed", "Failed to fetch jobs")
+	errBackfillFields     = apperror.New(http.StatusInternalServerError, "backfill_failed", "Failed to get backfill status")
+	errInvalidShareID     = apperror.New(http.StatusBadRequest, "invalid_share_id", "Invalid share ID")
+	errInvalidLinkID      = apperror.New(http.StatusBadRequest, "invalid_upload_link_id", "Invalid upload link ID")
+	errInvalidRuleID      = apperror.New(http.StatusBadRequest, "invalid_rule_id", "Invalid rule ID")
+	errFetchFolderRules   = apperror.New(http.StatusInternalServerError, "fetch_folder_rules_failed", "Failed to fetch folder rules")
+	errInvalidFileFilter  = apperror.New(http.StatusBadRequest, "invalid_file_filter", "Invalid file filter: %s")
+	errRequestTimeout     = apperror.New(http.StatusGatewayTimeout, "request_timeout", "The request took too long and was cancelled")
+	errInvalidSignature   = apperror.New(http.StatusBadRequest, "invalid_webhook_signature", "Invalid webhook signature")
+	errBillingStatus      = apperror.New(http.StatusInternalServerError, "billing_status_failed", "Failed to get billing status")
+	errExportFailed       = apperror.New(http.StatusInternalServerError, "export_failed", "Export failed, the listing is incomplete")
+	errManifestFailed     = apperror.New(http.StatusInternalServerError, "manifest_failed", "Manifest failed, the listing is incomplete")
+)
+
+// respondError writes a localized error body. Errors without a code use the
+// given status. Failures caused by the request deadline are reported as such.
+func respondError(c *gin.Context, status int, err error) {
+	lang := c.GetString("lang")
+	status, body := apperror.Render(lang, status, err)
+	if status >= http.StatusInternalServerError && errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+		status, body = apperror.Render(lang, status, errRequestTimeout)
+	}
+	c.JSON(status, body)
+}
+
+// respondErrorWith writes a localized error body with additional fields
+func respondErrorWith(c *gin.Context, status int, err error, extra gin.H) {
+	status, body := apperror.Render(c.GetString("lang"), status, err)
+	for k, v := range extra {
+		body[k] = v
+	}
+	c.JSON(status, body)
+}
+
+// localize translates a success message for the request language
+func localize(c *gin.Context, key, fallback string) string {
+	return i18n.Translate(c.GetString("lang"), key, fallback)
+}
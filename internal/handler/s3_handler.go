@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"storage-service/internal/apperror"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"storage-service/internal/sigv4"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// s3Namespace is the XML namespace of S3 responses
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// s3TimeFormat is how S3 writes timestamps in listings
+const s3TimeFormat = "2006-01-02T15:04:05.000Z"
+
+// s3Unsupported are the subresources of buckets and objects the gateway
+// doesn't implement, e.g. multipart uploads and ACLs
+var s3Unsupported = []string{
+	"acl", "attributes", "cors", "delete", "encryption", "legal-hold", "lifecycle", "logging", "notification",
+	"object-lock", "partNumber", "policy", "replication", "restore", "retention", "select", "tagging",
+	"torrent", "uploadId", "uploads", "versioning", "versions", "website",
+}
+
+// S3Handler serves the S3-compatible gateway, with path-style addressing:
+// /<bucket>/<key>
+type S3Handler struct {
+	s3Service   *service.S3Service
+	fileService *service.FileService
+}
+
+func NewS3Handler(s3Service *service.S3Service, fileService *service.FileService) *S3Handler {
+	return &S3Handler{s3Service: s3Service, fileService: fileService}
+}
+
+func (h *S3Handler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/", requireS3Scope(model.ScopeRead), h.ListBuckets)
+		protected.HEAD("/:bucket", requireS3Scope(model.ScopeRead), h.HeadBucket)
+		protected.GET("/:bucket", requireS3Scope(model.ScopeRead), h.GetBucket)
+		protected.PUT("/:bucket", requireS3Scope(model.ScopeUpload), h.CreateBucket)
+		protected.HEAD("/:bucket/*key", requireS3Scope(model.ScopeRead), h.GetObject)
+		protected.GET("/:bucket/*key", requireS3Scope(model.ScopeRead), h.GetObject)
+		protected.PUT("/:bucket/*key", requireS3Scope(model.ScopeUpload), h.PutObject)
+		protected.DELETE("/:bucket/*key", requireS3Scope(model.ScopeDelete), h.DeleteObject)
+	}
+}
+
+// S3NotImplemented answers requests for operations the gateway doesn't
+// support
+func S3NotImplemented(c *gin.Context) {
+	S3Error(c, http.StatusNotImplemented, "NotImplemented", "This operation is not supported by the S3 gateway")
+}
+
+// S3Error writes an error the way S3 does, as XML
+func S3Error(c *gin.Context, status int, code, message string) {
+	if c.Request.Method == http.MethodHead {
+		c.Status(status)
+		return
+	}
+	c.XML(status, s3ErrorBody{Code: code, Message: message, Resource: c.Request.URL.Path})
+}
+
+type s3ErrorBody struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+// requireS3Scope is requireScope with an S3 error
+func requireS3Scope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !hasScope(c, scope) {
+			S3Error(c, http.StatusForbidden, "AccessDenied", service.ErrInsufficientScope.WithArgs(scope).Error())
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// respondS3Error answers err with the S3 error closest to it
+func respondS3Error(c *gin.Context, err error) {
+	var sigErr *sigv4.Error
+	if errors.As(err, &sigErr) {
+		S3Error(c, sigErr.Status, sigErr.Code, sigErr.Message)
+		return
+	}
+
+	switch {
+	case errors.Is(err, service.ErrFileNotFound):
+		S3Error(c, http.StatusNotFound, "NoSuchKey", "The specified key does not exist")
+		return
+	case errors.Is(err, service.ErrBucketNotFound):
+		S3Error(c, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	case errors.Is(err, service.ErrFolderExists):
+		S3Error(c, http.StatusConflict, "BucketAlreadyOwnedByYou", "The bucket already exists")
+		return
+	case errors.Is(err, service.ErrInvalidObjectKey), errors.Is(err, service.ErrInvalidFolderPath):
+		S3Error(c, http.StatusBadRequest, "InvalidArgument", err.Error())
+		return
+	}
+
+	var appErr *apperror.Error
+	if !errors.As(err, &appErr) {
+		respondS3InternalError(c, err)
+		return
+	}
+	switch status := appErr.Status; {
+	case status == http.StatusForbidden:
+		S3Error(c, status, "AccessDenied", err.Error())
+	case status == http.StatusRequestEntityTooLarge:
+		S3Error(c, http.StatusBadRequest, "EntityTooLarge", err.Error())
+	case status == http.StatusTooManyRequests:
+		S3Error(c, http.StatusServiceUnavailable, "SlowDown", err.Error())
+	case status == http.StatusGatewayTimeout:
+		S3Error(c, http.StatusBadRequest, "RequestTimeout", err.Error())
+	case status == http.StatusServiceUnavailable || status == http.StatusInsufficientStorage:
+		S3Error(c, http.StatusServiceUnavailable, "ServiceUnavailable", err.Error())
+	case status >= http.StatusInternalServerError:
+		respondS3InternalError(c, err)
+	default:
+		S3Error(c, http.StatusBadRequest, "InvalidRequest", err.Error())
+	}
+}
+
+func respondS3InternalError(c *gin.Context, err error) {
+	log.Printf("[WARN] S3 request %s %s failed: %v", c.Request.Method, c.Request.URL.Path, err)
+	S3Error(c, http.StatusInternalServerError, "InternalError", "We encountered an internal error, please try again")
+}
+
+// unsupportedSubresource answers requests for subresources the gateway
+// doesn't implement, reporting whether it did
+func unsupportedSubresource(c *gin.Context) bool {
+	query := c.Request.URL.Query()
+	for _, name := range s3Unsupported {
+		if query.Has(name) {
+			S3NotImplemented(c)
+			return true
+		}
+	}
+	return false
+}
+
+// objectKey returns the key of an object route, "" for the bucket itself,
+// e.g. /photos/
+func objectKey(c *gin.Context) string {
+	return strings.TrimPrefix(c.Param("key"), "/")
+}
+
+type s3BucketList struct {
+	XMLName xml.Name   `xml:"ListAllMyBucketsResult"`
+	Xmlns   string     `xml:"xmlns,attr"`
+	Owner   s3Owner    `xml:"Owner"`
+	Buckets []s3Bucket `xml:"Buckets>Bucket"`
+}
+
+type s3Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type s3Bucket struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// ListBuckets lists the top-level folders of the user as buckets
+func (h *S3Handler) ListBuckets(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	folders, err := h.s3Service.Buckets(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondS3Error(c, err)
+		return
+	}
+
+	list := s3BucketList{Xmlns: s3Namespace, Buckets: []s3Bucket{}}
+	list.Owner.ID = strconv.FormatUint(uint64(userID.(uint)), 10)
+	list.Owner.DisplayName = c.GetString("api_key_account")
+	for _, folder := range folders {
+		list.Buckets = append(list.Buckets, s3Bucket{Name: folder.Path, CreationDate: folder.CreatedAt.UTC().Format(s3TimeFormat)})
+	}
+	c.XML(http.StatusOK, list)
+}
+
+// HeadBucket checks that a bucket exists
+func (h *S3Handler) HeadBucket(c *gin.Context) {
+	userID, _ := c.Get("user_id")
+	if err := h.s3Service.CheckBucket(c.Request.Context(), userID.(uint), c.Param("bucket")); err != nil {
+		respondS3Error(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+// CreateBucket creates a top-level folder
+func (h *S3Handler) CreateBucket(c *gin.Context) {
+	if unsupportedSubresource(c) {
+		return
+	}
+	userID, _ := c.Get("user_id")
+	if err := h.s3Service.CreateBucket(c.Request.Context(), userID.(uint), c.Param("bucket")); err != nil {
+		respondS3Error(c, err)
+		return
+	}
+	c.Header("Location", "/"+c.Param("bucket"))
+	c.Status(http.StatusOK)
+}
+
+type s3Location struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+}
+
+// GetBucket lists the objects of a bucket, or answers ?location with the
+// default region
+func (h *S3Handler) GetBucket(c *gin.Context) {
+	if unsupportedSubresource(c) {
+		return
+	}
+	userID, _ := c.Get("user_id")
+	bucket := c.Param("bucket")
+	if _, ok := c.GetQuery("location"); ok {
+		if err := h.s3Service.CheckBucket(c.Request.Context(), userID.(uint), bucket); err != nil {
+			respondS3Error(c, err)
+			return
+		}
+		c.XML(http.StatusOK, s3Location{Xmlns: s3Namespace})
+		return
+	}
+	h.listObjects(c, userID.(uint), bucket)
+}
+
+type s3ObjectList struct {
+	XMLName               xml.Name         `xml:"ListBucketResult"`
+	Xmlns                 string           `xml:"xmlns,attr"`
+	Name                  string           `xml:"Name"`
+	Prefix                string           `xml:"Prefix"`
+	Delimiter             string           `xml:"Delimiter,omitempty"`
+	EncodingType          string           `xml:"EncodingType,omitempty"`
+	MaxKeys               int              `xml:"MaxKeys"`
+	KeyCount              *int             `xml:"KeyCount,omitempty"`
+	IsTruncated           bool             `xml:"IsTruncated"`
+	Marker                *string          `xml:"Marker,omitempty"`
+	NextMarker            string           `xml:"NextMarker,omitempty"`
+	ContinuationToken     string           `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string           `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string           `xml:"StartAfter,omitempty"`
+	Contents              []s3ObjectEntry  `xml:"Contents"`
+	CommonPrefixes        []s3CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type s3ObjectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type s3CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listObjects answers ListObjectsV2, or ListObjects without list-type=2
+func (h *S3Handler) listObjects(c *gin.Context, userID uint, bucket string) {
+	v2 := c.Query("list-type") == "2"
+	query := service.S3ListQuery{Prefix: c.Query("prefix"), Delimiter: c.Query("delimiter"), MaxKeys: service.MaxS3Keys}
+	if value := c.Query("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			S3Error(c, http.StatusBadRequest, "InvalidArgument", "max-keys must be a number of at least 0")
+			return
+		}
+		query.MaxKeys = min(maxKeys, service.MaxS3Keys)
+	}
+	encode := func(s string) string { return s }
+	encodingType := c.Query("encoding-type")
+	switch encodingType {
+	case "":
+	case "url":
+		encode = url.QueryEscape
+	default:
+		S3Error(c, http.StatusBadRequest, "InvalidArgument", "encoding-type must be url")
+		return
+	}
+
+	token := c.Query("continuation-token")
+	if v2 {
+		query.After = c.Query("start-after")
+		if token != "" {
+			after, err := base64.RawURLEncoding.DecodeString(token)
+			if err != nil {
+				S3Error(c, http.StatusBadRequest, "InvalidArgument", "The continuation token provided is incorrect")
+				return
+			}
+			query.After = string(after)
+		}
+	} else {
+		query.After = c.Query("marker")
+	}
+
+	list, err := h.s3Service.ListObjects(c.Request.Context(), userID, bucket, query)
+	if err != nil {
+		respondS3Error(c, err)
+		return
+	}
+
+	result := s3ObjectList{
+		Xmlns:          s3Namespace,
+		Name:           bucket,
+		Prefix:         encode(query.Prefix),
+		Delimiter:      encode(query.Delimiter),
+		EncodingType:   encodingType,
+		MaxKeys:        query.MaxKeys,
+		IsTruncated:    list.Truncated,
+		Contents:       make([]s3ObjectEntry, 0, len(list.Objects)),
+		CommonPrefixes: make([]s3CommonPrefix, 0, len(list.CommonPrefixes)),
+	}
+	for _, object := range list.Objects {
+		result.Contents = append(result.Contents, s3ObjectEntry{
+			Key:          encode(object.Key),
+			LastModified: object.File.UpdatedAt.UTC().Format(s3TimeFormat),
+			ETag:         s3ETag(object.File),
+			Size:         object.File.FileSize,
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, prefix := range list.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, s3CommonPrefix{Prefix: encode(prefix)})
+	}
+
+	if v2 {
+		keyCount := len(list.Objects) + len(list.CommonPrefixes)
+		result.KeyCount = &keyCount
+		result.ContinuationToken = token
+		result.StartAfter = encode(c.Query("start-after"))
+		if list.Truncated {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(list.Next))
+		}
+	} else {
+		marker := encode(query.After)
+		result.Marker = &marker
+		if list.Truncated {
+			result.NextMarker = encode(list.Next)
+		}
+	}
+	c.XML(http.StatusOK, result)
+}
+
+// GetObject serves the content of an object; HEAD requests get its headers
+func (h *S3Handler) GetObject(c *gin.Context) {
+	key := objectKey(c)
+	if key == "" {
+		if c.Request.Method == http.MethodHead {
+			h.HeadBucket(c)
+		} else {
+			h.GetBucket(c)
+		}
+		return
+	}
+	if unsupportedSubresource(c) {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	file, err := h.s3Service.GetObject(c.Request.Context(), userID.(uint), c.Param("bucket"), key)
+	if err != nil {
+		respondS3Error(c, err)
+		return
+	}
+	if err := service.CheckDownload(file); err != nil {
+		respondS3Error(c, err)
+		return
+	}
+	filePath, err := h.fileService.Locate(c.Request.Context(), file)
+	if err != nil {
+		S3Error(c, http.StatusServiceUnavailable, "ServiceUnavailable", err.Error())
+		return
+	}
+
+	// S3 clients expect the object as it was uploaded, not encoded for
+	// transfer
+	c.Request.Header.Del("Accept-Encoding")
+	c.Header("Content-Type", file.MimeType)
+	c.Header("ETag", s3ETag(file))
+	c.Header("Last-Modified", file.UpdatedAt.UTC().Format(http.TimeFormat))
+	c.Header("Cache-Control", cachePrivateRevalidate)
+	serveFile(c, filePath, file.Compression, file.FileSize)
+}
+
+// PutObject uploads the body as the file of a key, replacing the file
+// already there
+func (h *S3Handler) PutObject(c *gin.Context) {
+	key := objectKey(c)
+	if key == "" {
+		h.CreateBucket(c)
+		return
+	}
+	if unsupportedSubresource(c) {
+		return
+	}
+	if c.GetHeader("X-Amz-Copy-Source") != "" {
+		S3NotImplemented(c)
+		return
+	}
+
+	contentType := c.ContentType()
+	if contentType == "binary/octet-stream" {
+		// What SDKs send when the caller didn't set a type; let detection
+		// decide instead
+		contentType = ""
+	}
+	source := uploadClient(c)
+	source.Source, source.Label = model.SourceS3, c.GetString("api_key_account")
+
+	userID, _ := c.Get("user_id")
+	file, err := h.s3Service.PutObject(c.Request.Context(), userID.(uint), c.Param("bucket"), key, contentType, c.Request.ContentLength, c.Request.Body, source)
+	if err != nil {
+		respondS3Error(c, err)
+		return
+	}
+	c.Header("ETag", s3ETag(file))
+	c.Status(http.StatusOK)
+}
+
+// DeleteObject moves the file of a key to the trash
+func (h *S3Handler) DeleteObject(c *gin.Context) {
+	key := objectKey(c)
+	if key == "" {
+		// Buckets are folders, deleted through the API
+		S3NotImplemented(c)
+		return
+	}
+	if unsupportedSubresource(c) {
+		return
+	}
+
+	userID, _ := c.Get("user_id")
+	if err := h.s3Service.DeleteObject(c.Request.Context(), userID.(uint), c.Param("bucket"), key); err != nil {
+		respondS3Error(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// s3ETag returns the ETag of an object: its MD5 like S3, or the start of
+// its SHA-256 for files stored before MD5s were recorded
+func s3ETag(file *model.File) string {
+	switch {
+	case file.MD5 != "":
+		return `"` + file.MD5 + `"`
+	case len(file.SHA256) >= 32:
+		return `"` + file.SHA256[:32] + `"`
+	default:
+		return `"` + strconv.FormatUint(uint64(file.ID), 16) + "-" + strconv.FormatInt(file.UpdatedAt.Unix(), 16) + `"`
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ScratchHandler struct {
+	scratchService *service.ScratchService
+	uploads        *service.UploadTracker
+}
+
+func NewScratchHandler(scratchService *service.ScratchService, uploads *service.UploadTracker) *ScratchHandler {
+	return &ScratchHandler{scratchService: scratchService, uploads: uploads}
+}
+
+func (h *ScratchHandler) Upload(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	uploadID, err := trackUpload(c, h.uploads, userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		h.uploads.Fail(uploadID, errFileRequired)
+		respondError(c, http.StatusBadRequest, errFileRequired)
+		return
+	}
+
+	uploaded, err := h.scratchService.Upload(c.Request.Context(), userID.(uint), file, c.PostForm("ttl"), uploadID, uploadSource(c))
+	if err != nil {
+		h.uploads.Fail(uploadID, err)
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": localize(c, "file_uploaded", "File uploaded successfully"),
+		"file":    uploaded,
+	})
+}
+
+func (h *ScratchHandler) List(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	q := parseListQuery(c)
+	files, total, err := h.scratchService.List(c.Request.Context(), userID.(uint), q.page, q.pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	usage, err := h.scratchService.Usage(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, struct {
+		ListResponse[model.File]
+		Usage *service.ScratchUsage `json:"usage"`
+	}{newListResponse(q, files, total), usage})
+}
+
+func (h *ScratchHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/scratch", requireScope(model.ScopeRead), h.List)
+		protected.POST("/scratch", requireUpload(), h.Upload)
+	}
+}
@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SessionHandler struct {
+	sessionService *service.SessionService
+}
+
+func NewSessionHandler(sessionService *service.SessionService) *SessionHandler {
+	return &SessionHandler{sessionService: sessionService}
+}
+
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// Refresh exchanges a refresh token for new tokens
+func (h *SessionHandler) Refresh(c *gin.Context) {
+	var req RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	tokens, err := h.sessionService.Refresh(c.Request.Context(), req.RefreshToken, c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Logout ends the session the request is authenticated with
+func (h *SessionHandler) Logout(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	sessionID := c.GetUint("session_id")
+	if sessionID == 0 {
+		respondError(c, http.StatusBadRequest, errSessionRequired)
+		return
+	}
+
+	if err := h.sessionService.Revoke(c.Request.Context(), userID.(uint), sessionID); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "logged_out", "Logged out successfully")})
+}
+
+func (h *SessionHandler) ListSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	sessions, err := h.sessionService.ListSessions(c.Request.Context(), userID.(uint), c.GetUint("session_id"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errFetchSessions)
+		return
+	}
+
+	c.JSON(http.StatusOK, newFullListResponse(sessions, nil))
+}
+
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidSessionID)
+		return
+	}
+
+	if err := h.sessionService.Revoke(c.Request.Context(), userID.(uint), uint(sessionID)); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "session_revoked", "Session revoked")})
+}
+
+// RevokeAllSessions ends every session of the user, including the current one
+func (h *SessionHandler) RevokeAllSessions(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+	if c.GetUint("actor_id") != 0 {
+		respondError(c, http.StatusForbidden, errActorNotAllowed)
+		return
+	}
+
+	revoked, err := h.sessionService.RevokeAll(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": localize(c, "sessions_revoked", "All sessions revoked"),
+		"revoked": revoked,
+	})
+}
+
+func (h *SessionHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	router.POST("/users/refresh", h.Refresh)
+
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.POST("/users/logout", h.Logout)
+		protected.GET("/users/me/sessions", requireScope(model.ScopeRead), h.ListSessions)
+		protected.DELETE("/users/me/sessions", requireScope(model.ScopeDelete), h.RevokeAllSessions)
+		protected.DELETE("/users/me/sessions/:id", requireScope(model.ScopeDelete), h.RevokeSession)
+	}
+}
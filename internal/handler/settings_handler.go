@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SettingsHandler struct {
+	settingsService *service.SettingsService
+}
+
+func NewSettingsHandler(settingsService *service.SettingsService) *SettingsHandler {
+	return &SettingsHandler{settingsService: settingsService}
+}
+
+// GetSettings lists the runtime settings with their current value and the
+// environment variable they default to
+func (h *SettingsHandler) GetSettings(c *gin.Context) {
+	settings, err := h.settingsService.List(c.Request.Context())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"settings": settings})
+}
+
+type UpdateRuntimeSettingsRequest struct {
+	// Settings maps keys to new values; null resets a setting to its
+	// environment variable
+	Settings map[string]*string `json:"settings" binding:"required"`
+}
+
+// UpdateSettings changes runtime settings, all of them or none when one is
+// invalid
+func (h *SettingsHandler) UpdateSettings(c *gin.Context) {
+	var req UpdateRuntimeSettingsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	settings, err := h.settingsService.Update(c.Request.Context(), req.Settings, c.GetUint("user_id"), c.ClientIP())
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  localize(c, "settings_updated", "Settings updated successfully"),
+		"settings": settings,
+	})
+}
+
+func (h *SettingsHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware, adminMiddleware gin.HandlerFunc) {
+	admin := router.Group("/admin")
+	admin.Use(authMiddleware, adminMiddleware)
+	{
+		admin.GET("/settings", h.GetSettings)
+		admin.PUT("/settings", h.UpdateSettings)
+	}
+}
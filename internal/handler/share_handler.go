@@ -0,0 +1,221 @@
+package handler
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ShareHandler struct {
+	shareService *service.ShareService
+}
+
+func NewShareHandler(shareService *service.ShareService) *ShareHandler {
+	return &ShareHandler{shareService: shareService}
+}
+
+type CreateShareRequest struct {
+	// ExpiresIn is empty for links that never expire, e.g. "7d" or "12h"
+	ExpiresIn string `json:"expires_in"`
+}
+
+func (h *ShareHandler) CreateShare(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	var req CreateShareRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	share, err := h.shareService.CreateShare(c.Request.Context(), uint(fileID), userID.(uint), req.ExpiresIn)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, share)
+}
+
+func (h *ShareHandler) ListShares(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	shares, err := h.shareService.ListShares(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newFullListResponse(shares, nil))
+}
+
+func (h *ShareHandler) DeleteShare(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	shareID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidShareID)
+		return
+	}
+
+	if err := h.shareService.DeleteShare(c.Request.Context(), uint(shareID), userID.(uint)); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "share_deleted", "Share link revoked")})
+}
+
+// LandingPage renders the HTML page of a share with its preview and Open
+// Graph tags, so the link unfurls in chat apps
+func (h *ShareHandler) LandingPage(c *gin.Context) {
+	share, file, err := h.shareService.Resolve(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		h.renderPage(c, http.StatusInternalServerError, sharePageData{}, err)
+		return
+	}
+	// Blocked files, e.g. awaiting moderation, don't show their name either
+	if err := service.CheckDownload(file); err != nil {
+		h.renderPage(c, http.StatusForbidden, sharePageData{}, err)
+		return
+	}
+
+	data := sharePageData{
+		Title:         file.OriginalName,
+		Description:   shareDescription(file),
+		URL:           share.URL,
+		DownloadURL:   share.URL + "/download",
+		DownloadLabel: localize(c, "download", "Download"),
+	}
+	if _, _, ok := h.shareService.Preview(c.Request.Context(), file); ok {
+		data.PreviewURL = share.URL + "/preview"
+	}
+	h.renderPage(c, http.StatusOK, data, nil)
+}
+
+// Download serves the shared file as an attachment
+func (h *ShareHandler) Download(c *gin.Context) {
+	share, file, err := h.shareService.Resolve(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		h.renderPage(c, http.StatusInternalServerError, sharePageData{}, err)
+		return
+	}
+	if err := service.CheckDownload(file); err != nil {
+		h.renderPage(c, http.StatusForbidden, sharePageData{}, err)
+		return
+	}
+
+	filePath, err := h.shareService.Locate(c.Request.Context(), file)
+	if err != nil {
+		h.renderPage(c, http.StatusServiceUnavailable, sharePageData{}, err)
+		return
+	}
+
+	h.shareService.RecordDownload(c.Request.Context(), share)
+	c.Header("Content-Disposition", "attachment; filename="+strconv.Quote(file.OriginalName))
+	c.Header("Content-Type", file.MimeType)
+	// Shares can be revoked and the shared content edited
+	c.Header("Cache-Control", cacheRevalidate)
+	setFileETag(c, file)
+	serveFile(c, filePath, file.Compression, file.FileSize)
+}
+
+// Preview serves the thumbnail used by the landing page and link unfurls
+func (h *ShareHandler) Preview(c *gin.Context) {
+	_, file, err := h.shareService.Resolve(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if err := service.CheckDownload(file); err != nil {
+		respondError(c, http.StatusForbidden, err)
+		return
+	}
+
+	filePath, mimeType, ok := h.shareService.Preview(c.Request.Context(), file)
+	if !ok {
+		respondError(c, http.StatusNotFound, service.ErrFileNotFound)
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Header("Content-Type", mimeType)
+	c.File(filePath)
+}
+
+func (h *ShareHandler) renderPage(c *gin.Context, status int, data sharePageData, err error) {
+	data.Lang = c.GetString("lang")
+	if data.Lang == "" {
+		data.Lang = "en"
+	}
+	if err != nil {
+		var body map[string]interface{}
+		status, body = apperror.Render(data.Lang, status, err)
+		data.Error = fmt.Sprint(body["error"])
+		data.Title = data.Error
+	}
+
+	var buf bytes.Buffer
+	if err := sharePage.Execute(&buf, data); err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(status, "text/html; charset=utf-8", buf.Bytes())
+}
+
+func shareDescription(file *model.File) string {
+	description := humanSize(file.FileSize) + " · " + file.MimeType
+	if file.Width > 0 && file.Height > 0 {
+		description += fmt.Sprintf(" · %d×%d", file.Width, file.Height)
+	}
+	return description
+}
+
+func (h *ShareHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.POST("/files/:id/shares", requireScope(model.ScopeUpload), h.CreateShare)
+		protected.GET("/files/:id/shares", requireScope(model.ScopeRead), h.ListShares)
+		protected.DELETE("/shares/:id", requireScope(model.ScopeDelete), h.DeleteShare)
+	}
+}
+
+// RegisterPublicRoutes serves share links at /s/:token for anonymous visitors
+func (h *ShareHandler) RegisterPublicRoutes(router *gin.Engine) {
+	router.GET("/s/:token", h.LandingPage)
+	router.GET("/s/:token/download", h.Download)
+	router.GET("/s/:token/preview", h.Preview)
+}
@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"fmt"
+	"html/template"
+)
+
+// sharePageData fills the share landing page
+type sharePageData struct {
+	Lang          string
+	Title         string
+	Description   string
+	URL           string
+	PreviewURL    string
+	DownloadURL   string
+	DownloadLabel string
+	Error         string
+}
+
+// sharePage is a minimal, dependency-free landing page. The Open Graph and
+// Twitter tags let chat apps and social networks unfurl the link.
+var sharePage = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html lang="{{.Lang}}">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>{{.Title}}</title>
+{{- if not .Error}}
+<meta property="og:type" content="website">
+<meta property="og:title" content="{{.Title}}">
+<meta property="og:description" content="{{.Description}}">
+<meta property="og:url" content="{{.URL}}">
+{{- if .PreviewURL}}
+<meta property="og:image" content="{{.PreviewURL}}">
+<meta name="twitter:card" content="summary_large_image">
+<meta name="twitter:image" content="{{.PreviewURL}}">
+{{- else}}
+<meta name="twitter:card" content="summary">
+{{- end}}
+<meta name="twitter:title" content="{{.Title}}">
+<meta name="twitter:description" content="{{.Description}}">
+{{- end}}
+<style>
+body{margin:0;min-height:100vh;display:flex;align-items:center;justify-content:center;background:#f4f5f7;font-family:system-ui,-apple-system,"Segoe UI",Roboto,sans-serif;color:#1f2328}
+main{background:#fff;border-radius:12px;box-shadow:0 2px 12px rgba(0,0,0,.08);padding:32px;max-width:480px;width:calc(100% - 64px);text-align:center}
+img{max-width:100%;max-height:320px;border-radius:8px;margin-bottom:20px}
+h1{font-size:1.25rem;margin:0 0 8px;word-break:break-all}
+p{color:#656d76;margin:0 0 24px}
+a.button{display:inline-block;background:#0969da;color:#fff;text-decoration:none;padding:10px 24px;border-radius:6px;font-weight:600}
+</style>
+</head>
+<body>
+<main>
+{{- if .Error}}
+<h1>{{.Error}}</h1>
+{{- else}}
+{{- if .PreviewURL}}
+<img src="{{.PreviewURL}}" alt="{{.Title}}">
+{{- end}}
+<h1>{{.Title}}</h1>
+<p>{{.Description}}</p>
+<a class="button" href="{{.DownloadURL}}">{{.DownloadLabel}}</a>
+{{- end}}
+</main>
+</body>
+</html>
+`))
+
+// humanSize formats a byte count for people, e.g. "1.4 MB"
+func humanSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"log"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setStorageHeaders reports a user's storage usage in bytes in the
+// X-Storage-Used and X-Storage-Limit headers, so clients can update quota
+// bars after an upload or delete without fetching their stats. When the
+// usage can't be read the headers are left out.
+func setStorageHeaders(c *gin.Context, users *service.UserService, userID uint) {
+	usage, err := users.GetStorageUsage(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("[WARN] Failed to get storage usage of user %d: %v", userID, err)
+		return
+	}
+	c.Header("X-Storage-Used", strconv.FormatInt(usage.Used, 10))
+	c.Header("X-Storage-Limit", strconv.FormatInt(usage.Limit, 10))
+}
@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"storage-service/internal/service"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serveFile sends a file as uploaded, size bytes long or -1 when unknown. A
+// compressed file is sent as is with Content-Encoding when the client
+// accepts its algorithm, and decompressed otherwise; the caller sets
+// Content-Type and an ETag. Conditional requests are answered with 304 and
+// Range requests with the bytes asked for, so downloads can be resumed and
+// videos scrubbed.
+func serveFile(c *gin.Context, filePath, compression string, size int64) {
+	if compression == "" {
+		c.File(filePath)
+		return
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		respondError(c, http.StatusNotFound, service.ErrFileNotFound)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		respondError(c, http.StatusNotFound, service.ErrFileNotFound)
+		return
+	}
+
+	c.Header("Vary", "Accept-Encoding")
+	if !acceptsEncoding(c.GetHeader("Accept-Encoding"), compression) {
+		content := &storedContent{path: filePath, compression: compression, size: size}
+		defer content.Close()
+		http.ServeContent(c.Writer, c.Request, "", info.ModTime(), content)
+		return
+	}
+
+	if etag := c.Writer.Header().Get("ETag"); etag != "" {
+		// The encoded representation needs an ETag of its own
+		c.Header("ETag", strings.TrimSuffix(etag, `"`)+"-"+compression+`"`)
+	}
+	// Ranges of the encoded representation are ranges of the stored bytes
+	c.Header("Content-Encoding", compression)
+	http.ServeContent(c.Writer, c.Request, "", info.ModTime(), f)
+}
+
+// storedContent reads a compressed file decompressed and seeks in it
+// without decompressing it all: seeking ahead skips the bytes in between
+// and seeking back starts over. The size of the content is counted by
+// decompressing it when unknown.
+type storedContent struct {
+	path, compression string
+	size              int64
+
+	r io.ReadCloser
+	// offset is the position of r, pos the one reads continue from
+	offset, pos int64
+}
+
+func (s *storedContent) Read(p []byte) (int, error) {
+	if s.r == nil || s.pos < s.offset {
+		if err := s.reopen(); err != nil {
+			return 0, err
+		}
+	}
+	if s.pos > s.offset {
+		n, err := io.CopyN(io.Discard, s.r, s.pos-s.offset)
+		s.offset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+	n, err := s.r.Read(p)
+	s.offset += int64(n)
+	s.pos = s.offset
+	return n, err
+}
+
+func (s *storedContent) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += s.pos
+	case io.SeekEnd:
+		if s.size < 0 {
+			if err := s.count(); err != nil {
+				return 0, err
+			}
+		}
+		offset += s.size
+	}
+	if offset < 0 {
+		return 0, errors.New("seek before start of content")
+	}
+	s.pos = offset
+	return offset, nil
+}
+
+func (s *storedContent) Close() error {
+	if s.r == nil {
+		return nil
+	}
+	return s.r.Close()
+}
+
+func (s *storedContent) reopen() error {
+	s.Close()
+	r, err := service.OpenStored(s.path, s.compression)
+	if err != nil {
+		s.r = nil
+		return err
+	}
+	s.r, s.offset = r, 0
+	return nil
+}
+
+func (s *storedContent) count() error {
+	if err := s.reopen(); err != nil {
+		return err
+	}
+	n, err := io.Copy(io.Discard, s.r)
+	s.offset += n
+	if err != nil {
+		return err
+	}
+	s.size = s.offset
+	return nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header lists encoding
+// without q=0
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(q), 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
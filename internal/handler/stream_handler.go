@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StreamHandler struct {
+	streamService *service.StreamService
+}
+
+func NewStreamHandler(streamService *service.StreamService) *StreamHandler {
+	return &StreamHandler{streamService: streamService}
+}
+
+// GetStreamURL returns a signed playlist URL for a transcoded video
+func (h *StreamHandler) GetStreamURL(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	stream, err := h.streamService.GetStreamURL(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, stream)
+}
+
+// Stream serves the master playlist, or with a name the media playlist or a
+// segment. Players cannot send API keys, so access is granted by the token.
+func (h *StreamHandler) Stream(c *gin.Context) {
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	content, filePath, mimeType, err := h.streamService.OpenStream(c.Request.Context(), uint(fileID), c.Query("token"), c.Param("name"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Header("Cache-Control", "private, max-age=3600")
+	if content != nil {
+		c.Data(http.StatusOK, mimeType, content)
+		return
+	}
+	c.Header("Content-Type", mimeType)
+	c.File(filePath)
+}
+
+func (h *StreamHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	router.GET("/files/:id/stream", h.Stream)
+	router.GET("/files/:id/stream/:name", h.Stream)
+
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/files/:id/stream-url", requireScope(model.ScopeRead), h.GetStreamURL)
+	}
+}
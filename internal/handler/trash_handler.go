@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type TrashHandler struct {
+	fileService *service.FileService
+	userService *service.UserService
+}
+
+func NewTrashHandler(fileService *service.FileService, userService *service.UserService) *TrashHandler {
+	return &TrashHandler{fileService: fileService, userService: userService}
+}
+
+func (h *TrashHandler) ListTrash(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	q := parseListQuery(c)
+	files, total, err := h.fileService.ListTrash(c.Request.Context(), userID.(uint), q.page, q.pageSize)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newListResponse(q, files, total))
+}
+
+func (h *TrashHandler) RestoreFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	file, err := h.fileService.RestoreFile(c.Request.Context(), uint(fileID), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	setStorageHeaders(c, h.userService, userID.(uint))
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "file_restored", "File restored"), "file": file})
+}
+
+func (h *TrashHandler) PurgeFile(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	fileID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidFileID)
+		return
+	}
+
+	if err := h.fileService.PurgeFile(c.Request.Context(), uint(fileID), userID.(uint)); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "file_purged", "File deleted permanently")})
+}
+
+func (h *TrashHandler) EmptyTrash(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	purged, err := h.fileService.EmptyTrash(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "trash_emptied", "Trash emptied"), "purged": purged})
+}
+
+func (h *TrashHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/trash", requireScope(model.ScopeRead), h.ListTrash)
+		protected.POST("/trash/:id/restore", requireScope(model.ScopeUpload), h.RestoreFile)
+		protected.DELETE("/trash/:id", requireScope(model.ScopeDelete), h.PurgeFile)
+		protected.DELETE("/trash", requireScope(model.ScopeDelete), h.EmptyTrash)
+	}
+}
@@ -0,0 +1,158 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const progressPollInterval = 500 * time.Millisecond
+
+type UploadHandler struct {
+	uploads *service.UploadTracker
+}
+
+func NewUploadHandler(uploads *service.UploadTracker) *UploadHandler {
+	return &UploadHandler{uploads: uploads}
+}
+
+type CreateUploadSessionRequest struct {
+	Size int64 `json:"size"`
+}
+
+func (h *UploadHandler) CreateSession(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req CreateUploadSessionRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			respondError(c, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	progress, err := h.uploads.Create(userID.(uint), req.Size)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, errUploadSession)
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"upload_id":    progress.ID,
+		"progress_url": "/api/uploads/" + progress.ID + "/progress",
+		"progress":     progress,
+	})
+}
+
+// GetProgress returns the upload state as JSON, or as a server-sent event
+// stream when the client accepts text/event-stream
+func (h *UploadHandler) GetProgress(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	progress, err := h.uploads.Get(c.Param("id"), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusNotFound, err)
+		return
+	}
+
+	if c.GetHeader("Accept") != "text/event-stream" {
+		c.JSON(http.StatusOK, progress)
+		return
+	}
+
+	ticker := time.NewTicker(progressPollInterval)
+	defer ticker.Stop()
+
+	last := progress
+	c.SSEvent("progress", progress)
+	c.Stream(func(w io.Writer) bool {
+		if last.Finished() {
+			return false
+		}
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case <-ticker.C:
+		}
+
+		current, err := h.uploads.Get(last.ID, userID.(uint))
+		if err != nil {
+			return false
+		}
+		if current.UpdatedAt != last.UpdatedAt {
+			c.SSEvent("progress", current)
+			last = current
+		}
+		return true
+	})
+}
+
+// trackUpload attaches the request body to the upload session named by the
+// X-Upload-ID header or upload_id query parameter, if any
+func trackUpload(c *gin.Context, uploads *service.UploadTracker, userID uint) (string, error) {
+	uploadID := c.GetHeader("X-Upload-ID")
+	if uploadID == "" {
+		uploadID = c.Query("upload_id")
+	}
+	if uploadID == "" {
+		return "", nil
+	}
+
+	body, err := uploads.TrackReader(uploadID, userID, c.Request.Body, c.Request.ContentLength)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = body
+	return uploadID, nil
+}
+
+// uploadFolder returns the folder_path form field or query parameter, and
+// otherwise the default folder of the API key the request authenticated with
+func uploadFolder(c *gin.Context) string {
+	if folder, ok := c.GetPostForm("folder_path"); ok {
+		return folder
+	}
+	if folder, ok := c.GetQuery("folder_path"); ok {
+		return folder
+	}
+	return c.GetString("default_folder")
+}
+
+// uploadClient returns the client of an upload request; the service
+// receiving the upload tells how it arrived
+func uploadClient(c *gin.Context) model.UploadSource {
+	return model.UploadSource{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+// uploadSource describes an authenticated upload: from the web UI when the
+// request carries a session, otherwise with the API key of the account
+// named in the label
+func uploadSource(c *gin.Context) model.UploadSource {
+	source := uploadClient(c)
+	if _, ok := c.Get("session_id"); ok {
+		source.Source = model.SourceWeb
+	} else {
+		source.Source, source.Label = model.SourceAPI, c.GetString("api_key_account")
+	}
+	return source
+}
+
+func (h *UploadHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.POST("/uploads/sessions", requireUpload(), h.CreateSession)
+		protected.GET("/uploads/:id/progress", requireScope(model.ScopeRead), h.GetProgress)
+	}
+}
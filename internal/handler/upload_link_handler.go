@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"net/http"
+	"storage-service/internal/model"
+	"storage-service/internal/service"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type UploadLinkHandler struct {
+	linkService *service.UploadLinkService
+}
+
+func NewUploadLinkHandler(linkService *service.UploadLinkService) *UploadLinkHandler {
+	return &UploadLinkHandler{linkService: linkService}
+}
+
+func (h *UploadLinkHandler) ListLinks(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	links, err := h.linkService.ListLinks(c.Request.Context(), userID.(uint))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, newFullListResponse(links, nil))
+}
+
+func (h *UploadLinkHandler) CreateLink(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	var req service.UploadLinkInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	link, err := h.linkService.CreateLink(c.Request.Context(), userID.(uint), req)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, link)
+}
+
+func (h *UploadLinkHandler) UpdateLink(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	linkID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidLinkID)
+		return
+	}
+
+	var req service.UploadLinkInput
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	link, err := h.linkService.UpdateLink(c.Request.Context(), uint(linkID), userID.(uint), req)
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, link)
+}
+
+func (h *UploadLinkHandler) DeleteLink(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
+		return
+	}
+
+	linkID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errInvalidLinkID)
+		return
+	}
+
+	if err := h.linkService.DeleteLink(c.Request.Context(), uint(linkID), userID.(uint)); err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": localize(c, "upload_link_deleted", "Upload link revoked")})
+}
+
+// GetLink tells a guest what a link accepts. The owner's folder and
+// notification address stay private.
+func (h *UploadLinkHandler) GetLink(c *gin.Context) {
+	link, err := h.linkService.Resolve(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"name":               link.Name,
+		"allowed_extensions": link.AllowedExtensions,
+		"max_files":          link.MaxFiles,
+		"max_total_size":     link.MaxTotalSize,
+		"file_count":         link.FileCount,
+		"total_size":         link.TotalSize,
+		"expires_at":         link.ExpiresAt,
+	})
+}
+
+// Upload accepts a file from a guest into the folder of a link
+func (h *UploadLinkHandler) Upload(c *gin.Context) {
+	link, err := h.linkService.Resolve(c.Request.Context(), c.Param("token"))
+	if err != nil {
+		respondError(c, http.StatusInternalServerError, err)
+		return
+	}
+	if remaining := service.RemainingSize(link); remaining > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, remaining+multipartOverhead)
+	}
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		respondError(c, http.StatusBadRequest, errFileRequired)
+		return
+	}
+
+	uploaded, err := h.linkService.Upload(c.Request.Context(), link.Token, file, uploadClient(c))
+	if err != nil {
+		respondError(c, http.StatusBadRequest, err)
+		return
+	}
+
+	// Guests only learn what they sent was stored
+	c.JSON(http.StatusCreated, gin.H{
+		"message": localize(c, "file_uploaded", "File uploaded successfully"),
+		"file": gin.H{
+			"original_name": uploaded.OriginalName,
+			"file_size":     uploaded.FileSize,
+			"mime_type":     uploaded.MimeType,
+		},
+	})
+}
+
+func (h *UploadLinkHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin.HandlerFunc) {
+	protected := router.Group("")
+	protected.Use(authMiddleware)
+	{
+		protected.GET("/upload-links", requireScope(model.ScopeRead), h.ListLinks)
+		protected.POST("/upload-links", requireScope(model.ScopeUpload), h.CreateLink)
+		protected.PUT("/upload-links/:id", requireScope(model.ScopeUpload), h.UpdateLink)
+		protected.DELETE("/upload-links/:id", requireScope(model.ScopeDelete), h.DeleteLink)
+	}
+}
+
+// RegisterPublicRoutes serves upload links at /u/:token for guests
+func (h *UploadLinkHandler) RegisterPublicRoutes(router *gin.Engine) {
+	router.GET("/u/:token", h.GetLink)
+	router.POST("/u/:token", h.Upload)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"storage-service/internal/service"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// UploadsFS serves the upload directory without directory listings and
+// without dot files, which hides the temp directory of in-flight uploads
+func UploadsFS(root string) http.FileSystem {
+	return noDotFiles{gin.Dir(root, false)}
+}
+
+// ServeUploads serves /uploads/*filepath from UploadsFS. Files stored
+// compressed are found under their original name. Files not in root are
+// served from previousRoot during a storage migration, and from the replica
+// when they can't be read. Private uploads require a URL signed by media.
+// With a remote storage backend files missing in root are fetched from it
+// first. Stored files are cached as immutable unless they can be edited in place.
+func ServeUploads(root, previousRoot string, replica *service.Replica, blobs *service.BlobStore, media *service.MediaSigner) gin.HandlerFunc {
+	roots := []string{root}
+	fsys := UploadsFS(root)
+	if previousRoot != "" {
+		roots = append(roots, previousRoot)
+		fsys = failoverFS{primary: fsys, secondary: UploadsFS(previousRoot)}
+	}
+	if dir := replica.Dir(); dir != "" {
+		fsys = failoverFS{primary: fsys, secondary: UploadsFS(dir)}
+	}
+	fileServer := http.StripPrefix("/uploads", http.FileServer(fsys))
+	return func(c *gin.Context) {
+		name := path.Clean("/" + c.Param("filepath"))
+		if media.Private() {
+			// Not found rather than forbidden, so paths can't be probed
+			expiresAt, ok := media.Verify(name, c.Query("expires"), c.Query("signature"))
+			if !ok {
+				respondError(c, http.StatusNotFound, service.ErrFileNotFound)
+				return
+			}
+			if service.MutableUpload(path.Base(name)) {
+				c.Header("Cache-Control", cachePrivateRevalidate)
+			} else {
+				c.Header("Cache-Control", fmt.Sprintf("private, max-age=%d, immutable", int(time.Until(expiresAt).Seconds())))
+			}
+		} else {
+			c.Header("Cache-Control", uploadCacheControl(name))
+		}
+		c.Writer = cacheOnSuccess{c.Writer}
+		if err := blobs.FetchUpload(c.Request.Context(), filepath.Join(root, filepath.FromSlash(name))); err != nil {
+			log.Printf("[WARN] %v", err)
+		}
+		if algorithm, ok := storedCompression(fsys, name); ok {
+			if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+				c.Header("Content-Type", contentType)
+			}
+			serveFile(c, locateUpload(roots, replica, name+service.CompressionSuffix(algorithm)), algorithm, -1)
+			return
+		}
+		fileServer.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// storedCompression reports the algorithm of a file that only exists
+// compressed
+func storedCompression(fsys http.FileSystem, name string) (string, bool) {
+	if f, err := fsys.Open(name); err == nil || !os.IsNotExist(err) {
+		if f != nil {
+			f.Close()
+		}
+		return "", false
+	}
+	for _, algorithm := range service.CompressionAlgorithms {
+		if f, err := fsys.Open(name + service.CompressionSuffix(algorithm)); err == nil {
+			f.Close()
+			return algorithm, true
+		}
+	}
+	return "", false
+}
+
+// locateUpload returns the path on disk of a file below /uploads
+func locateUpload(roots []string, replica *service.Replica, name string) string {
+	for _, root := range roots {
+		filePath := filepath.Join(root, filepath.FromSlash(name))
+		if _, err := os.Stat(filePath); err == nil {
+			return filePath
+		}
+	}
+	return replica.Locate(filepath.Join(roots[0], filepath.FromSlash(name)))
+}
+
+// failoverFS opens files from secondary when primary fails
+type failoverFS struct {
+	primary   http.FileSystem
+	secondary http.FileSystem
+}
+
+func (fs failoverFS) Open(name string) (http.File, error) {
+	f, err := fs.primary.Open(name)
+	if err == nil {
+		return f, nil
+	}
+	if f, secondaryErr := fs.secondary.Open(name); secondaryErr == nil {
+		return f, nil
+	}
+	return nil, err
+}
+
+type noDotFiles struct {
+	http.FileSystem
+}
+
+func (fs noDotFiles) Open(name string) (http.File, error) {
+	for _, part := range strings.Split(name, "/") {
+		if strings.HasPrefix(part, ".") {
+			return nil, os.ErrNotExist
+		}
+	}
+	return fs.FileSystem.Open(name)
+}
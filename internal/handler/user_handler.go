@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"errors"
 	"net/http"
+	"storage-service/internal/model"
 	"storage-service/internal/service"
 
 	"github.com/gin-gonic/gin"
@@ -23,18 +25,18 @@ type RegisterRequest struct {
 func (h *UserHandler) Register(c *gin.Context) {
 	var req RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	user, err := h.userService.Register(req.Username, req.Email)
+	user, err := h.userService.Register(c.Request.Context(), req.Username, req.Email)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User registered successfully",
+		"message": localize(c, "user_registered", "User registered successfully"),
 		"user":    user,
 	})
 }
@@ -42,48 +44,29 @@ func (h *UserHandler) Register(c *gin.Context) {
 func (h *UserHandler) GetMe(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	user, err := h.userService.GetUserByID(userID.(uint))
+	user, err := h.userService.GetUserByID(c.Request.Context(), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		respondError(c, http.StatusNotFound, service.ErrUserNotFound)
 		return
 	}
 
 	c.JSON(http.StatusOK, user)
 }
 
-func (h *UserHandler) RegenerateAPIKey(c *gin.Context) {
-	userID, exists := c.Get("user_id")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
-	}
-
-	user, err := h.userService.RegenerateAPIKey(userID.(uint))
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to regenerate API key"})
-		return
-	}
-
-	c.JSON(http.StatusOK, gin.H{
-		"message": "API key regenerated successfully",
-		"user":    user,
-	})
-}
-
 func (h *UserHandler) GetStats(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	stats, err := h.userService.GetUserStats(userID.(uint))
+	stats, err := h.userService.GetUserStats(c.Request.Context(), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user stats"})
+		respondError(c, http.StatusInternalServerError, errUserStats)
 		return
 	}
 
@@ -93,13 +76,13 @@ func (h *UserHandler) GetStats(c *gin.Context) {
 func (h *UserHandler) GetSettings(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
-	settings, err := h.userService.GetUserSettings(userID.(uint))
+	settings, err := h.userService.GetUserSettings(c.Request.Context(), userID.(uint))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get user settings"})
+		respondError(c, http.StatusInternalServerError, errUserSettings)
 		return
 	}
 
@@ -115,28 +98,32 @@ type UpdateSettingsRequest struct {
 func (h *UserHandler) UpdateSettings(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		respondError(c, http.StatusUnauthorized, errUnauthorized)
 		return
 	}
 
 	var req UpdateSettingsRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		respondError(c, http.StatusBadRequest, err)
 		return
 	}
 
-	settings, err := h.userService.UpdateUserSettings(userID.(uint), &service.UserSettings{
+	settings, err := h.userService.UpdateUserSettings(c.Request.Context(), userID.(uint), &service.UserSettings{
 		MaxFiles:    req.MaxFiles,
 		MaxFileSize: req.MaxFileSize,
 		MaxStorage:  req.MaxStorage,
 	})
+	if errors.Is(err, service.ErrPlanLimitExceeded) {
+		respondError(c, http.StatusForbidden, err)
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		respondError(c, http.StatusInternalServerError, errUpdateSettings)
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message":  "Settings updated successfully",
+		"message":  localize(c, "settings_updated", "Settings updated successfully"),
 		"settings": settings,
 	})
 }
@@ -149,10 +136,9 @@ func (h *UserHandler) RegisterRoutes(router *gin.RouterGroup, authMiddleware gin
 	protected := router.Group("")
 	protected.Use(authMiddleware)
 	{
-		protected.GET("/users/me", h.GetMe)
-		protected.GET("/users/stats", h.GetStats)
-		protected.GET("/users/settings", h.GetSettings)
-		protected.PUT("/users/settings", h.UpdateSettings)
-		protected.POST("/users/regenerate-key", h.RegenerateAPIKey)
+		protected.GET("/users/me", requireScope(model.ScopeRead), h.GetMe)
+		protected.GET("/users/stats", requireScope(model.ScopeRead), h.GetStats)
+		protected.GET("/users/settings", requireScope(model.ScopeRead), h.GetSettings)
+		protected.PUT("/users/settings", requireScope(model.ScopeUpload), h.UpdateSettings)
 	}
 }
@@ -0,0 +1,68 @@
+package i18n
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultLanguage is used when the client does not ask for a supported language
+const DefaultLanguage = "en"
+
+// catalogs holds translations by language and message key. English text lives
+// next to the code that produces it and is used as the fallback.
+var catalogs = map[string]map[string]string{
+	"en": {},
+	"vi": vi,
+}
+
+// Supported reports whether a language has a catalog
+func Supported(lang string) bool {
+	_, ok := catalogs[lang]
+	return ok
+}
+
+// Translate returns the message for key in lang, or fallback when there is no
+// translation. Args are applied to the translated format string.
+func Translate(lang, key, fallback string, args ...interface{}) string {
+	if msg, ok := catalogs[lang][key]; ok {
+		if len(args) > 0 {
+			return fmt.Sprintf(msg, args...)
+		}
+		return msg
+	}
+	return fallback
+}
+
+// Negotiate picks the best supported language from an Accept-Language header
+func Negotiate(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		base, _, _ := strings.Cut(strings.ToLower(tag), "-")
+		candidates = append(candidates, candidate{lang: base, q: q})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	for _, c := range candidates {
+		if c.q > 0 && Supported(c.lang) {
+			return c.lang
+		}
+	}
+	return DefaultLanguage
+}
@@ -0,0 +1,288 @@
+package i18n
+
+// vi contains Vietnamese translations keyed by error code or message key
+var vi = map[string]string{
+	// Authentication
+	"unauthorized":     "Chưa xác thực",
+	"api_key_required": "Yêu cầu API key",
+	"invalid_api_key":  "API key không hợp lệ",
+
+	"service_account_required": "Chỉ tài khoản dịch vụ mới có thể thao tác thay người dùng khác",
+	"on_behalf_of_not_allowed": "Tài khoản dịch vụ không được phép thao tác thay người dùng này",
+	"route_on_behalf":          "Tài khoản dịch vụ chỉ có thể đọc và tải tệp lên thay người dùng khác",
+
+	"rate_limited":        "Quá nhiều yêu cầu, vui lòng thử lại sau %d giây",
+	"upload_rate_limited": "Đã vượt giới hạn dung lượng tải lên, vui lòng thử lại sau %d giây",
+
+	// Request validation
+	"bad_request":          "Yêu cầu không hợp lệ",
+	"internal_error":       "Lỗi máy chủ nội bộ",
+	"file_required":        "Vui lòng chọn tệp",
+	"image_required":       "Vui lòng chọn ảnh",
+	"invalid_file_id":      "ID tệp không hợp lệ",
+	"invalid_image_id":     "ID ảnh không hợp lệ",
+	"name_required":        "Vui lòng nhập tên",
+	"folder_name_required": "Vui lòng nhập đường dẫn và tên mới",
+	"folder_path_required": "Vui lòng nhập đường dẫn thư mục",
+	"ids_required":         "Vui lòng cung cấp danh sách ID",
+	"pinned_required":      "Vui lòng cung cấp pinned",
+	"invalid_version":      "Phiên bản không hợp lệ",
+	"too_many_ids":         "Quá nhiều ID, tối đa %d",
+	"request_timeout":      "Yêu cầu mất quá nhiều thời gian và đã bị hủy",
+
+	// Files
+	"file_not_found":               "Không tìm thấy tệp",
+	"image_not_found":              "Không tìm thấy ảnh",
+	"file_not_in_trash":            "Tệp không có trong thùng rác",
+	"version_not_found":            "Không tìm thấy phiên bản của tệp",
+	"file_type_not_allowed":        "Loại tệp không được phép vì lý do bảo mật",
+	"content_type_not_allowed":     "Nội dung tệp không được phép vì lý do bảo mật",
+	"dangerous_content":            "Tệp chứa nội dung có thể gây nguy hiểm",
+	"mime_mismatch":                "Nội dung tệp (%s) không khớp với loại hoặc phần mở rộng đã khai báo",
+	"invalid_filename":             "Tên tệp không hợp lệ",
+	"extension_change_not_allowed": "Không thể thay đổi phần mở rộng của tệp",
+	"extension_add_not_allowed":    "Không thể thêm phần mở rộng cho tệp không có phần mở rộng",
+	"file_not_editable":            "Tệp không thể chỉnh sửa",
+	"file_too_large_to_edit":       "Tệp quá lớn để chỉnh sửa",
+	"invalid_file_type":            "type phải là image, video, audio hoặc text",
+	"invalid_kind":                 "kind phải là image, video, audio, document, archive, code hoặc other",
+	"invalid_sha256":               "sha256 phải gồm 64 ký tự thập lục phân",
+	"invalid_upload_source":        "source phải là web, api, upload_link, anonymous, direct_upload, import, s3 hoặc webdav",
+	"unknown_batch_operation":      "operation phải là delete, move, copy hoặc tag",
+	"target_folder_required":       "Cần folder_path để di chuyển hoặc sao chép tệp",
+	"tags_required":                "Cần add_tags hoặc remove_tags",
+	"invalid_tag":                  "Thẻ phải dài từ 1 đến %d ký tự, không chứa dấu phẩy hoặc dấu gạch chéo",
+	"unknown_file_type":            "Không xác định được loại tệp",
+	"image_type_not_allowed":       "Loại tệp không được phép, chỉ chấp nhận ảnh (JPEG, PNG, GIF)",
+	"unknown_image_profile":        "Hồ sơ xử lý ảnh không xác định %q",
+	"image_too_many_pixels":        "Ảnh có kích thước %dx%d điểm ảnh, chỉ cho phép tối đa %d điểm ảnh",
+	"animation_too_many_pixels":    "Ảnh động có %d khung hình kích thước %dx%d điểm ảnh, chỉ cho phép tối đa %d điểm ảnh tổng cộng",
+	"proxy_url_required":           "Thiếu tham số url",
+	"invalid_proxy_url":            "url phải là URL http hoặc https đầy đủ",
+	"invalid_proxy_width":          "w phải nằm trong khoảng từ 0 đến %d",
+	"proxy_host_not_allowed":       "Không được phép tải ảnh từ máy chủ này",
+	"proxy_fetch_failed":           "Không thể tải ảnh từ xa: %s",
+	"proxy_image_too_large":        "Ảnh từ xa lớn hơn %s",
+	"invalid_upload_size":          "size phải là số byte dương",
+	"upload_url_not_found":         "URL tải lên không hợp lệ, đã hết hạn hoặc đã được sử dụng",
+	"upload_too_large":             "Tệp tải lên lớn hơn %d byte đã khai báo",
+	"image_not_processable":        "Ảnh đã được xử lý hoặc đang được xử lý",
+	"image_type_not_optional":      "Không thể bật %s cho tải ảnh lên, hãy dùng %s",
+	"fetch_files_failed":           "Không thể tải danh sách tệp",
+	"export_failed":                "Xuất danh sách thất bại, danh sách chưa đầy đủ",
+	"manifest_failed":              "Tạo manifest thất bại, danh sách chưa đầy đủ",
+	"upload_policy_failed":         "Không thể tải chính sách tải lên",
+	"upload_session_not_found":     "Không tìm thấy phiên tải lên",
+	"create_upload_session_failed": "Không thể tạo phiên tải lên",
+
+	// Folders
+	"invalid_folder_path":   "Đường dẫn thư mục không hợp lệ",
+	"invalid_folder_name":   "Đường dẫn hoặc tên thư mục không hợp lệ",
+	"folder_not_found":      "Không tìm thấy thư mục",
+	"folder_exists":         "Thư mục đã tồn tại",
+	"invalid_folder_color":  "color phải là red, orange, yellow, green, blue, purple, pink, gray hoặc để trống",
+	"description_too_long":  "Mô tả tối đa %d ký tự",
+	"root_folder":           "Không thể xóa thư mục gốc",
+	"confirmation_required": "Thao tác này ảnh hưởng đến nhiều tệp và cần được xác nhận",
+	"folder_too_deep":       "Đường dẫn thư mục chỉ được lồng tối đa %d thư mục",
+	"folder_path_too_long":  "Đường dẫn thư mục tối đa %d ký tự",
+	"reserved_folder_name":  "%q là tên thư mục dành riêng",
+	"fetch_folders_failed":  "Không thể tải danh sách thư mục",
+
+	// Shares
+	"share_not_found":      "Không tìm thấy liên kết chia sẻ",
+	"share_expired":        "Liên kết chia sẻ đã hết hạn",
+	"invalid_share_expiry": "expires_in %q không hợp lệ, hãy dùng thời lượng như 7d hoặc 12h",
+	"invalid_share_id":     "ID liên kết chia sẻ không hợp lệ",
+	"share_deleted":        "Đã thu hồi liên kết chia sẻ",
+	"download":             "Tải xuống",
+
+	// Upload links
+	"upload_link_not_found":     "Không tìm thấy liên kết tải lên",
+	"upload_link_expired":       "Liên kết tải lên đã hết hạn",
+	"upload_link_full":          "Liên kết này chỉ nhận tối đa %d tệp",
+	"upload_link_size_exceeded": "Tổng dung lượng tệp tải lên qua liên kết này không được vượt quá %s",
+	"extension_not_allowed":     "Loại tệp không được phép, liên kết này chỉ nhận %s",
+	"invalid_upload_link_limit": "max_files và max_total_size không được là số âm",
+	"invalid_extension":         "Phần mở rộng %q không hợp lệ",
+	"invalid_upload_link_id":    "ID liên kết tải lên không hợp lệ",
+
+	// Folder rules
+	"folder_rule_not_found":     "Không tìm thấy quy tắc thư mục",
+	"folder_rule_no_action":     "Quy tắc thư mục cần có profile, webhook_url hoặc expire_after",
+	"invalid_webhook_url":       "webhook_url phải là URL http hoặc https đầy đủ",
+	"invalid_expire_after":      "expire_after %q không hợp lệ, hãy dùng thời lượng như 7d hoặc 12h",
+	"invalid_rule_id":           "ID quy tắc không hợp lệ",
+	"fetch_folder_rules_failed": "Không thể tải danh sách quy tắc thư mục",
+
+	// Users and quotas
+	"email_registered":       "Email đã được đăng ký",
+	"user_not_found":         "Không tìm thấy người dùng",
+	"file_size_limit":        "Kích thước tệp vượt quá giới hạn của bạn",
+	"file_count_limit":       "Đã đạt số lượng tệp tối đa",
+	"storage_limit_exceeded": "Vượt quá dung lượng lưu trữ",
+	"type_size_limit":        "Tệp %s không được lớn hơn %s",
+	"insufficient_storage":   "Máy chủ sắp hết dung lượng lưu trữ, vui lòng thử lại sau",
+	"storage_unavailable":    "Kho lưu trữ tạm thời không khả dụng, vui lòng thử lại sau",
+	"scratch_quota_exceeded": "Tệp tạm chỉ được chiếm tối đa %s, hãy xóa bớt hoặc đợi chúng hết hạn",
+	"invalid_scratch_ttl":    "ttl %q không hợp lệ, hãy dùng thời lượng tối đa %s như 24h",
+	"unknown_plan":           "Gói %q không tồn tại",
+	"plan_limit_exceeded":    "%s tối đa là %s với gói %s, hãy liên hệ quản trị viên để đổi gói",
+	"regenerate_key_failed":  "Không thể tạo lại API key",
+	"user_stats_failed":      "Không thể tải thống kê người dùng",
+	"user_settings_failed":   "Không thể tải cài đặt người dùng",
+	"update_settings_failed": "Không thể cập nhật cài đặt",
+
+	// Billing
+	"billing_disabled":          "Chưa cấu hình thanh toán",
+	"plan_not_billable":         "Không thể đăng ký gói %q",
+	"subscription_exists":       "Bạn đã có gói đăng ký, hãy thay đổi trong cổng thanh toán",
+	"no_subscription":           "Bạn chưa có gói đăng ký nào để quản lý",
+	"billing_status_failed":     "Không thể tải thông tin thanh toán",
+	"invalid_webhook_signature": "Chữ ký webhook không hợp lệ",
+
+	// Anonymous uploads
+	"anonymous_uploads_disabled": "Chưa bật tải lên ẩn danh",
+	"anonymous_upload_too_large": "Tệp tải lên ẩn danh không được lớn hơn %s",
+	"anonymous_type_not_allowed": "Loại tệp không được phép tải lên ẩn danh, hãy dùng %s",
+	"anonymous_rate_limited":     "Bạn đã tải lên quá nhiều, vui lòng thử lại sau",
+	"captcha_failed":             "Xác minh captcha thất bại",
+	"not_in_moderation":          "Tệp không nằm trong hàng chờ kiểm duyệt",
+
+	// Credentials
+	"invalid_credentials":   "Email hoặc mật khẩu không đúng",
+	"wrong_password":        "Mật khẩu hiện tại không đúng",
+	"password_not_set":      "Hãy đặt mật khẩu trước khi đổi email",
+	"invalid_password":      "Mật khẩu phải dài từ %d đến %d ký tự",
+	"invalid_reset_token":   "Liên kết đặt lại mật khẩu không hợp lệ hoặc đã hết hạn",
+	"password_reset_failed": "Không thể gửi email đặt lại mật khẩu",
+	"credentials_on_behalf": "Không thể đổi thông tin đăng nhập thay cho người dùng khác",
+
+	// Sessions
+	"invalid_access_token":  "Mã truy cập không hợp lệ hoặc đã hết hạn",
+	"invalid_refresh_token": "Mã làm mới không hợp lệ hoặc đã hết hạn",
+	"session_not_found":     "Không tìm thấy phiên đăng nhập",
+	"invalid_session_id":    "ID phiên đăng nhập không hợp lệ",
+	"session_required":      "Yêu cầu này không dùng phiên đăng nhập",
+	"fetch_sessions_failed": "Không thể tải danh sách phiên đăng nhập",
+	"cannot_impersonate":    "Không thể mạo danh quản trị viên hoặc chính tài khoản của bạn",
+	"fetch_activity_failed": "Không thể tải lịch sử hoạt động",
+	"invalid_user_id":       "ID người dùng không hợp lệ",
+
+	// API keys
+	"api_key_expired":         "API key đã hết hạn",
+	"api_key_not_found":       "Không tìm thấy API key",
+	"too_many_api_keys":       "Mỗi người dùng chỉ được giữ tối đa %d API key",
+	"invalid_scope":           "Phạm vi không hợp lệ %q, hãy dùng read, upload, delete hoặc admin",
+	"admin_scope_not_allowed": "Chỉ quản trị viên mới được giữ API key có phạm vi admin",
+	"scope_not_granted":       "API key dùng cho yêu cầu này không có phạm vi %s nên không thể cấp phạm vi đó",
+	"insufficient_scope":      "API key này không có phạm vi %s",
+	"invalid_api_key_expiry":  "expires_in không hợp lệ %q, hãy dùng khoảng thời gian như 90d hoặc 12h",
+	"invalid_api_key_id":      "ID API key không hợp lệ",
+	"fetch_api_keys_failed":   "Không thể tải danh sách API key",
+
+	// Admin
+	"admin_required":      "Yêu cầu quyền quản trị",
+	"admin_stats_failed":  "Không thể tải thống kê hệ thống",
+	"invalid_job_id":      "ID công việc không hợp lệ",
+	"invalid_folder_id":   "ID thư mục không hợp lệ",
+	"job_not_found":       "Không tìm thấy công việc",
+	"job_not_active":      "Công việc đã kết thúc",
+	"job_not_cancellable": "Chỉ quản trị viên mới có thể hủy công việc %s",
+	"job_not_paused":      "Công việc không bị tạm dừng",
+	"unknown_job_type":    "Loại công việc không xác định %q",
+	"fetch_jobs_failed":   "Không thể tải danh sách công việc",
+	"job_cancelled":       "Đã hủy công việc",
+	"job_paused":          "Đã tạm dừng công việc",
+	"job_resumed":         "Đã tiếp tục công việc",
+	"unknown_backfill":    "Trường bổ sung dữ liệu không xác định %q",
+	"backfill_failed":     "Không thể tải thông tin bổ sung dữ liệu",
+
+	"unknown_bulk_action":  "Thao tác không xác định %q, hãy dùng delete, quarantine, release hoặc transfer",
+	"target_user_required": "Vui lòng cung cấp target_user_id để chuyển tệp",
+	"invalid_file_filter":  "Bộ lọc tệp không hợp lệ: %s",
+
+	"unknown_setting": "Cài đặt không xác định %q",
+	"invalid_setting": "Giá trị không hợp lệ cho %s: %s",
+
+	"import_disabled":       "Chưa cấu hình IMPORT_ROOT",
+	"invalid_import_source": "source_dir phải là một thư mục nằm trong IMPORT_ROOT",
+	"unknown_import_mode":   "Chế độ nhập không xác định %q, hãy dùng copy, move hoặc link",
+
+	"compliance_export_disabled": "Chưa cấu hình COMPLIANCE_EXPORT_PATH",
+	"compliance_export_exists":   "Một bản chụp của người dùng này vừa được bắt đầu, vui lòng thử lại sau một giây",
+
+	"bucket_not_found":   "Không tìm thấy bucket",
+	"invalid_object_key": "Khóa đối tượng không hợp lệ %q",
+
+	"invalid_report_window": "window không hợp lệ %q, hãy dùng khoảng thời gian không quá 366 ngày như 30d",
+	"storage_report_failed": "Không thể tạo báo cáo dung lượng",
+
+	// Streaming
+	"stream_not_available": "Tệp này chưa có bản phát trực tuyến",
+	"invalid_stream_token": "Mã phát trực tuyến không hợp lệ hoặc đã hết hạn",
+	"invalid_signed_url":   "Liên kết tải xuống không hợp lệ hoặc đã hết hạn",
+	"invalid_expires_in":   "expires_in phải là một khoảng thời gian dương không quá %s",
+	"transcoding_disabled": "Chưa cấu hình chuyển mã video",
+	"replication_disabled": "Chưa cấu hình sao chép dự phòng",
+	"migration_disabled":   "Chưa cấu hình PREVIOUS_UPLOAD_PATH",
+	"scanning_disabled":    "Chưa cấu hình quét virus",
+	"file_infected":        "Tệp bị nhiễm virus và đã được cách ly",
+	"file_quarantined":     "Tệp đã bị quản trị viên cách ly",
+	"file_not_quarantined": "Tệp không bị cách ly",
+
+	"cannot_relocate":       "Không thể di chuyển tệp trên đĩa khi tệp đang bị cách ly hoặc nội dung đang thay đổi",
+	"relocate_target_taken": "Đã có tệp khác được lưu với tên %q trong thư mục này",
+
+	"file_locked":              "Tệp đang được %s chỉnh sửa",
+	"edit_session_expired":     "Phiên chỉnh sửa đã kết thúc, hãy bắt đầu phiên mới",
+	"edit_session_busy":        "Phiên chỉnh sửa đang được thay đổi, vui lòng thử lại",
+	"invalid_edit_session_ttl": "ttl_seconds phải nằm trong khoảng từ 1 đến %d",
+
+	"write_once_folder":     "Thư mục %q chỉ cho phép ghi một lần, không thể thay đổi, di chuyển hoặc xóa các tệp trong đó",
+	"write_once_admin_only": "Chỉ quản trị viên mới có thể gỡ chế độ ghi một lần của thư mục",
+	"not_write_once":        "Thư mục không ở chế độ ghi một lần",
+
+	// Success messages
+	"file_uploaded":       "Tải tệp lên thành công",
+	"image_uploaded":      "Tải ảnh lên và tối ưu thành công",
+	"file_deleted":        "Xóa tệp thành công",
+	"file_renamed":        "Đổi tên tệp thành công",
+	"file_updated":        "Cập nhật tệp thành công",
+	"folder_created":      "Tạo thư mục thành công",
+	"folder_renamed":      "Đổi tên thư mục thành công",
+	"folder_deleting":     "Đã bắt đầu xóa thư mục",
+	"folder_rule_deleted": "Xóa quy tắc thư mục thành công",
+	"user_registered":     "Đăng ký người dùng thành công",
+	"api_key_regenerated": "Tạo lại API key thành công",
+	"api_key_created":     "Tạo API key thành công",
+	"api_key_revoked":     "Đã thu hồi API key",
+	"api_key_updated":     "Cập nhật API key thành công",
+	"image_types_updated": "Cập nhật loại ảnh được phép thành công",
+	"settings_updated":    "Cập nhật cài đặt thành công",
+	"edit_session_ended":  "Đã kết thúc phiên chỉnh sửa",
+	"write_once_released": "Đã gỡ chế độ ghi một lần của thư mục",
+	"logged_in":           "Đăng nhập thành công",
+	"password_reset_sent": "Nếu email đã được đăng ký, một liên kết đặt lại mật khẩu đã được gửi",
+	"password_reset":      "Đặt lại mật khẩu thành công",
+	"password_changed":    "Đổi mật khẩu thành công",
+	"email_changed":       "Đổi email thành công",
+	"logged_out":          "Đăng xuất thành công",
+	"session_revoked":     "Đã thu hồi phiên đăng nhập",
+	"sessions_revoked":    "Đã thu hồi tất cả phiên đăng nhập",
+	"rescan_queued":       "Đã xếp tệp vào hàng đợi quét virus",
+	"folder_updated":      "Cập nhật thư mục thành công",
+	"processing_queued":   "Đã xếp ảnh vào hàng đợi xử lý",
+	"plan_updated":        "Cập nhật gói thành công",
+	"upload_received":     "Đã nhận tệp, tệp sẽ khả dụng sau khi được kiểm duyệt",
+	"upload_approved":     "Đã duyệt tệp",
+	"upload_rejected":     "Đã từ chối và xóa tệp",
+	"file_pinned":         "Đã ghim tệp",
+	"file_unpinned":       "Đã bỏ ghim tệp",
+	"file_relocated":      "Đã di chuyển tệp trên đĩa",
+	"file_restored":       "Đã khôi phục tệp",
+	"file_purged":         "Đã xóa vĩnh viễn tệp",
+	"trash_emptied":       "Đã dọn sạch thùng rác",
+	"version_restored":    "Đã khôi phục phiên bản của tệp",
+	"upload_link_deleted": "Đã thu hồi liên kết tải lên",
+}
@@ -0,0 +1,71 @@
+package mail
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Mailer sends plain-text emails to users
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// Config selects and configures the mailer. An empty Host logs messages
+// instead of sending them, which is enough for development.
+type Config struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+func NewMailer(cfg Config) Mailer {
+	if cfg.Host == "" {
+		return logMailer{}
+	}
+	return &smtpMailer{cfg: cfg}
+}
+
+type logMailer struct{}
+
+func (logMailer) Send(to, subject, body string) error {
+	log.Printf("Email to %s (SMTP_HOST not set): %s\n%s", to, subject, body)
+	return nil
+}
+
+type smtpMailer struct {
+	cfg Config
+}
+
+func (m *smtpMailer) Send(to, subject, body string) error {
+	if strings.ContainsAny(to, "\r\n") || strings.ContainsAny(subject, "\r\n") {
+		return fmt.Errorf("invalid email header")
+	}
+
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+
+	msg := strings.Join([]string{
+		"From: " + m.cfg.From,
+		"To: " + to,
+		"Subject: " + subject,
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"",
+		body,
+	}, "\r\n")
+
+	addr := net.JoinHostPort(m.cfg.Host, m.cfg.Port)
+	if err := smtp.SendMail(addr, auth, m.cfg.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("failed to send email: %w", err)
+	}
+	return nil
+}
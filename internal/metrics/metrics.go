@@ -0,0 +1,24 @@
+// Package metrics holds process-wide counters published through expvar
+package metrics
+
+import (
+	"expvar"
+)
+
+var (
+	// Requests counts handled requests by route, e.g. "POST /api/upload"
+	Requests = expvar.NewMap("http_requests")
+	// SlowRequests counts requests slower than SLOW_REQUEST_THRESHOLD by route
+	SlowRequests = expvar.NewMap("http_slow_requests")
+	// RequestErrors counts requests answered with a 5xx status by route
+	RequestErrors = expvar.NewMap("http_request_errors")
+	// BlobGC counts runs of the blob sweep, removed_files and reclaimed_bytes,
+	// and shared_kept, blobs kept on delete because other files use them
+	BlobGC = expvar.NewMap("blob_gc")
+	// Storage counts retries, failures, breaker_trips and fast_failed
+	// operations of the storage volume, and reports breaker_state
+	Storage = expvar.NewMap("storage_backend")
+	// Dedupe counts uploads hard-linked to identical stored content and the
+	// saved_bytes
+	Dedupe = expvar.NewMap("dedupe")
+)
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"fmt"
+	"log"
+	"storage-service/internal/metrics"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AccessLog logs requests with their status, user, response size and
+// duration. Requests slower than slowThreshold are logged at WARN even when
+// logAll is off, and counted in metrics.SlowRequests; zero disables
+// slow-request detection.
+func AccessLog(logAll bool, slowThreshold time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		duration := time.Since(start)
+
+		// Count by route pattern so IDs and tokens don't create new keys
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		route = c.Request.Method + " " + route
+		metrics.Requests.Add(route, 1)
+		if c.Writer.Status() >= 500 {
+			metrics.RequestErrors.Add(route, 1)
+		}
+
+		slow := slowThreshold > 0 && duration >= slowThreshold
+		if !slow && !logAll {
+			return
+		}
+
+		line := fmt.Sprintf("%s %s %d user=%d bytes=%d duration=%s ip=%s",
+			c.Request.Method, c.Request.URL.Path, c.Writer.Status(), c.GetUint("user_id"),
+			max(c.Writer.Size(), 0), duration.Round(time.Microsecond), c.ClientIP())
+		if slow {
+			metrics.SlowRequests.Add(route, 1)
+			log.Printf("[WARN] Slow request: %s", line)
+			return
+		}
+		log.Print(line)
+	}
+}
@@ -1,38 +1,184 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
 	"net/http"
+	"slices"
+	"storage-service/internal/apperror"
+	"storage-service/internal/model"
 	"storage-service/internal/repository"
+	"storage-service/internal/service"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+var (
+	errAPIKeyRequired = apperror.New(http.StatusUnauthorized, "api_key_required", "API key is required")
+
+	errServiceAccountRequired = apperror.New(http.StatusForbidden, "service_account_required", "Only service accounts can act on behalf of other users")
+	errOnBehalfOfNotAllowed   = apperror.New(http.StatusForbidden, "on_behalf_of_not_allowed", "Service account is not allowed to act on behalf of this user")
+	errAdminRequired          = apperror.New(http.StatusForbidden, "admin_required", "Admin privileges required")
+)
+
 type AuthMiddleware struct {
 	userRepo *repository.UserRepository
+	sessions *service.SessionService
+	keys     *service.APIKeyService
+	limits   *RateLimiter
 }
 
-func NewAuthMiddleware(userRepo *repository.UserRepository) *AuthMiddleware {
-	return &AuthMiddleware{userRepo: userRepo}
+// NewAuthMiddleware returns the middleware; limits may be nil when rate
+// limiting is off
+func NewAuthMiddleware(userRepo *repository.UserRepository, sessions *service.SessionService, keys *service.APIKeyService, limits *RateLimiter) *AuthMiddleware {
+	return &AuthMiddleware{userRepo: userRepo, sessions: sessions, keys: keys, limits: limits}
 }
 
+// Authenticate accepts an API key in X-API-Key or a session access token in
+// "Authorization: Bearer <token>". Requests with a key carry its scopes in
+// api_key_scopes; sessions hold every scope. Rate limits apply per key, or
+// per user for sessions.
 func (m *AuthMiddleware) Authenticate() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		apiKey := c.GetHeader("X-API-Key")
-		if apiKey == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "API key is required"})
-			c.Abort()
+		var user *model.User
+		var limitKey string
+		if token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+			session, sessionUser, err := m.sessions.Authenticate(c.Request.Context(), token, c.ClientIP())
+			if err != nil {
+				c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusUnauthorized, err))
+				return
+			}
+			c.Set("session_id", session.ID)
+			if session.ImpersonatorID != nil {
+				// The admin stays recorded as the actor, like a service account
+				c.Set("actor_id", *session.ImpersonatorID)
+			}
+			user = sessionUser
+			limitKey = "user:" + strconv.FormatUint(uint64(sessionUser.ID), 10)
+		} else {
+			apiKey := c.GetHeader("X-API-Key")
+			if apiKey == "" {
+				c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusUnauthorized, errAPIKeyRequired))
+				return
+			}
+
+			var err error
+			user, limitKey, err = m.authenticateKey(c, apiKey)
+			if err != nil {
+				c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusUnauthorized, err))
+				return
+			}
+		}
+
+		if onBehalfOf := c.GetHeader("X-On-Behalf-Of"); onBehalfOf != "" {
+			target, err := m.resolveOnBehalfOf(c.Request.Context(), user, onBehalfOf)
+			if err != nil {
+				c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusForbidden, err))
+				return
+			}
+			// The service account stays recorded as the actor, and may only
+			// read and upload, see handler.requireScope
+			c.Set("actor_id", user.ID)
+			c.Set("on_behalf_of", true)
+			user = target
+		}
+
+		c.Set("user_id", user.ID)
+		c.Set("user", user)
+		if m.limits != nil {
+			m.limits.limit(c, limitKey, rejectJSON)
 			return
 		}
+		c.Next()
+	}
+}
 
-		user, err := m.userRepo.FindByAPIKey(apiKey)
+// AuthenticateBasic accepts an API key as the password of HTTP Basic
+// authentication, which is what WebDAV clients send; the username is
+// ignored. It sets the same context as Authenticate, and failures ask for
+// credentials so clients prompt for them.
+func (m *AuthMiddleware) AuthenticateBasic(realm string) gin.HandlerFunc {
+	challenge := fmt.Sprintf("Basic realm=%q, charset=\"UTF-8\"", realm)
+	return func(c *gin.Context) {
+		_, apiKey, ok := c.Request.BasicAuth()
+		if !ok || apiKey == "" {
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusUnauthorized, errAPIKeyRequired))
+			return
+		}
+		user, limitKey, err := m.authenticateKey(c, apiKey)
 		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid API key"})
-			c.Abort()
+			c.Header("WWW-Authenticate", challenge)
+			c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusUnauthorized, err))
 			return
 		}
 
 		c.Set("user_id", user.ID)
 		c.Set("user", user)
+		if m.limits != nil {
+			m.limits.limit(c, limitKey, rejectJSON)
+			return
+		}
 		c.Next()
 	}
 }
+
+// authenticateKey checks an API key and sets its context, returning its
+// user and the key its rate limits count against
+func (m *AuthMiddleware) authenticateKey(c *gin.Context, apiKey string) (*model.User, string, error) {
+	key, user, err := m.keys.Authenticate(c.Request.Context(), apiKey, c.ClientIP())
+	if err != nil {
+		return nil, "", err
+	}
+	c.Set("api_key_id", key.ID)
+	c.Set("api_key_scopes", key.ScopeList())
+	// The key's default folder applies even when acting for another user
+	c.Set("default_folder", key.FolderPath)
+	// Uploads record the account of the key, see model.UploadSource
+	c.Set("api_key_account", user.Username)
+	// Acting for another user still counts against the key
+	return user, "api_key:" + strconv.FormatUint(uint64(key.ID), 10), nil
+}
+
+// RequireAdmin allows only admins through; it must run after Authenticate.
+// The acting identity is checked, so a service account cannot gain admin
+// rights by acting on behalf of an admin. API keys also need the admin scope.
+func (m *AuthMiddleware) RequireAdmin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := c.Get("user")
+		if !ok || !user.(*model.User).IsAdmin || c.GetUint("actor_id") != 0 {
+			c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusForbidden, errAdminRequired))
+			return
+		}
+		if scopes, ok := c.Get("api_key_scopes"); ok && !slices.Contains(scopes.([]string), model.ScopeAdmin) {
+			c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusForbidden, service.ErrInsufficientScope.WithArgs(model.ScopeAdmin)))
+			return
+		}
+		c.Next()
+	}
+}
+
+// resolveOnBehalfOf returns the user a service account wants to act for,
+// checking the account's allowlist
+func (m *AuthMiddleware) resolveOnBehalfOf(ctx context.Context, account *model.User, targetID string) (*model.User, error) {
+	if !account.IsServiceAccount {
+		return nil, errServiceAccountRequired
+	}
+
+	id, err := strconv.ParseUint(targetID, 10, 32)
+	if err != nil {
+		return nil, errOnBehalfOfNotAllowed
+	}
+
+	allowed, err := m.userRepo.HasServiceAccountGrant(ctx, account.ID, uint(id))
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errOnBehalfOfNotAllowed
+	}
+
+	return m.userRepo.FindByID(ctx, uint(id))
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deadline cancels the request context after timeout, or after
+// transferTimeout for the given route patterns, which move file contents and
+// may legitimately take long. Services and queries started by the request
+// give up once it expires. Zero disables the respective deadline.
+func Deadline(timeout, transferTimeout time.Duration, transferRoutes ...string) gin.HandlerFunc {
+	transfers := make(map[string]bool, len(transferRoutes))
+	for _, route := range transferRoutes {
+		transfers[route] = true
+	}
+
+	return func(c *gin.Context) {
+		limit := timeout
+		if transfers[c.FullPath()] {
+			limit = transferTimeout
+		}
+		if limit <= 0 {
+			c.Next()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), limit)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
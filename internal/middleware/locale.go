@@ -0,0 +1,17 @@
+package middleware
+
+import (
+	"storage-service/internal/i18n"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Locale selects the response language from the Accept-Language header
+func Locale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		lang := i18n.Negotiate(c.GetHeader("Accept-Language"))
+		c.Set("lang", lang)
+		c.Header("Content-Language", lang)
+		c.Next()
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// trustedPlatforms maps TRUSTED_PLATFORM names to the header carrying the
+// client IP on that platform
+var trustedPlatforms = map[string]string{
+	"cloudflare":        gin.PlatformCloudflare,
+	"google-app-engine": gin.PlatformGoogleAppEngine,
+	"fly":               gin.PlatformFlyIO,
+}
+
+// TrustProxies sets which peers may report the client IP. X-Forwarded-For and
+// X-Real-IP are honored only from the comma-separated proxy IPs or CIDRs in
+// spec; an empty spec trusts no proxy and uses the peer address. platform
+// names a hosting platform whose client IP header is always trusted.
+func TrustProxies(engine *gin.Engine, spec, platform string) error {
+	var proxies []string
+	for _, entry := range strings.Split(spec, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			proxies = append(proxies, entry)
+		}
+	}
+	if err := engine.SetTrustedProxies(proxies); err != nil {
+		return fmt.Errorf("invalid trusted proxy: %w", err)
+	}
+	engine.RemoteIPHeaders = []string{"X-Forwarded-For", "X-Real-IP"}
+
+	if platform != "" {
+		header, ok := trustedPlatforms[strings.ToLower(platform)]
+		if !ok {
+			return fmt.Errorf("unknown trusted platform %q, use cloudflare, google-app-engine or fly", platform)
+		}
+		engine.TrustedPlatform = header
+	}
+	return nil
+}
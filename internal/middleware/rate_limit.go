@@ -0,0 +1,126 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/ratelimit"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateLimitWarnInterval keeps an unreachable backend from flooding the log
+const rateLimitWarnInterval = time.Minute
+
+var (
+	errRateLimited       = apperror.New(http.StatusTooManyRequests, "rate_limited", "Too many requests, retry in %d seconds")
+	errUploadRateLimited = apperror.New(http.StatusTooManyRequests, "upload_rate_limited", "Upload limit exceeded, retry in %d seconds")
+)
+
+// RateLimiter limits the requests a minute and the bytes uploaded an hour of
+// every API key, or user for sessions
+type RateLimiter struct {
+	limiter  ratelimit.Limiter
+	requests ratelimit.Rate
+	uploads  ratelimit.Rate
+	lastWarn atomic.Int64
+}
+
+// NewRateLimiter returns a RateLimiter; a limit of 0 is not enforced
+func NewRateLimiter(limiter ratelimit.Limiter, requestsPerMinute, uploadBytesPerHour int64) *RateLimiter {
+	return &RateLimiter{
+		limiter:  limiter,
+		requests: ratelimit.Rate{Limit: float64(requestsPerMinute), Per: time.Minute},
+		uploads:  ratelimit.Rate{Limit: float64(uploadBytesPerHour), Per: time.Hour},
+	}
+}
+
+// limit runs the rest of the chain unless the caller is over a limit, in
+// which case it sets Retry-After and answers with reject. Uploads of known
+// length need that many bytes left in the bucket; uploads of unknown length
+// are let through while the byte bucket is not in debt and charged once
+// read. When the backend fails requests go through unlimited.
+func (r *RateLimiter) limit(c *gin.Context, key string, reject func(*gin.Context, *apperror.Error)) {
+	ctx := c.Request.Context()
+	if r.requests.Limit > 0 {
+		ok, wait, err := r.limiter.Take(ctx, "ratelimit:requests:"+key, 1, r.requests)
+		if err != nil {
+			r.warn(err)
+		} else if !ok {
+			r.refuse(c, reject, errRateLimited, wait)
+			return
+		}
+	}
+
+	body := c.Request.Body
+	if r.uploads.Limit <= 0 || body == nil || body == http.NoBody || c.Request.ContentLength == 0 {
+		c.Next()
+		return
+	}
+
+	// A known length is charged up front, and refused unless the bucket
+	// holds it, so a large upload cannot start with the last few bytes
+	cost := max(c.Request.ContentLength, 0)
+	ok, wait, err := r.limiter.Take(ctx, "ratelimit:uploads:"+key, float64(cost), r.uploads)
+	if err != nil {
+		r.warn(err)
+		c.Next()
+		return
+	}
+	if !ok {
+		r.refuse(c, reject, errUploadRateLimited, wait)
+		return
+	}
+	if cost > 0 {
+		c.Next()
+		return
+	}
+
+	counter := &countingReader{ReadCloser: body}
+	c.Request.Body = counter
+	c.Next()
+	if counter.n > 0 {
+		// The request context may be over by now
+		if err := r.limiter.Spend(context.WithoutCancel(ctx), "ratelimit:uploads:"+key, float64(counter.n), r.uploads); err != nil {
+			r.warn(err)
+		}
+	}
+}
+
+func (r *RateLimiter) refuse(c *gin.Context, reject func(*gin.Context, *apperror.Error), err *apperror.Error, wait time.Duration) {
+	seconds := int64(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.FormatInt(seconds, 10))
+	reject(c, err.WithArgs(seconds))
+}
+
+// rejectJSON answers a request over its limit with 429
+func rejectJSON(c *gin.Context, err *apperror.Error) {
+	c.AbortWithStatusJSON(apperror.Render(c.GetString("lang"), http.StatusTooManyRequests, err))
+}
+
+func (r *RateLimiter) warn(err error) {
+	now := time.Now().Unix()
+	last := r.lastWarn.Load()
+	if now-last < int64(rateLimitWarnInterval.Seconds()) || !r.lastWarn.CompareAndSwap(last, now) {
+		return
+	}
+	log.Printf("[WARN] Rate limiter failed, requests are not limited: %v", err)
+}
+
+// countingReader counts the bytes read from a request body
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
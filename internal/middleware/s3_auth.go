@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/service"
+	"storage-service/internal/sigv4"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// S3ErrorFunc answers a request in the error format of S3
+type S3ErrorFunc func(c *gin.Context, status int, code, message string)
+
+// AuthenticateS3 checks the SigV4 signature of S3 gateway requests, made
+// with the S3 credentials of an API key, and sets the same context as
+// Authenticate. Rate limits of the key apply; errors are answered with fail,
+// in the format S3 clients understand.
+func (m *AuthMiddleware) AuthenticateS3(fail S3ErrorFunc) gin.HandlerFunc {
+	abort := func(c *gin.Context, status int, code, message string) {
+		fail(c, status, code, message)
+		c.Abort()
+	}
+
+	return func(c *gin.Context) {
+		auth, err := sigv4.Parse(c.Request)
+		if err != nil {
+			abortSigV4(c, abort, err)
+			return
+		}
+		key, secret, err := m.keys.S3Credentials(c.Request.Context(), auth.AccessKeyID)
+		if errors.Is(err, service.ErrInvalidAPIKey) {
+			abort(c, http.StatusForbidden, "InvalidAccessKeyId", "The access key ID does not exist")
+			return
+		}
+		if err != nil {
+			abortSigV4(c, abort, err)
+			return
+		}
+		if err := auth.Verify(c.Request, secret); err != nil {
+			abortSigV4(c, abort, err)
+			return
+		}
+		user, err := m.keys.Use(c.Request.Context(), key, c.ClientIP())
+		if err != nil {
+			if errors.Is(err, service.ErrAPIKeyExpired) || errors.Is(err, service.ErrInvalidAPIKey) {
+				abort(c, http.StatusForbidden, "AccessDenied", err.Error())
+				return
+			}
+			abortSigV4(c, abort, err)
+			return
+		}
+
+		c.Set("api_key_id", key.ID)
+		c.Set("api_key_scopes", key.ScopeList())
+		c.Set("api_key_account", user.Username)
+		c.Set("user_id", user.ID)
+		c.Set("user", user)
+		if m.limits != nil {
+			m.limits.limit(c, "api_key:"+strconv.FormatUint(uint64(key.ID), 10), func(c *gin.Context, err *apperror.Error) {
+				// S3 clients back off and retry on SlowDown
+				abort(c, http.StatusServiceUnavailable, "SlowDown", err.Error())
+			})
+			return
+		}
+		c.Next()
+	}
+}
+
+func abortSigV4(c *gin.Context, abort S3ErrorFunc, err error) {
+	var sigErr *sigv4.Error
+	if errors.As(err, &sigErr) {
+		abort(c, sigErr.Status, sigErr.Code, sigErr.Message)
+		return
+	}
+	log.Printf("[WARN] S3 authentication failed: %v", err)
+	abort(c, http.StatusInternalServerError, "InternalError", "We encountered an internal error, please try again")
+}
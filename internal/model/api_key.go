@@ -0,0 +1,69 @@
+package model
+
+import (
+	"strings"
+	"time"
+)
+
+// Scopes of API keys
+const (
+	// ScopeRead allows reading files, folders, shares and the account
+	ScopeRead = "read"
+	// ScopeUpload allows uploading and changing files, folders, shares,
+	// links, rules and settings
+	ScopeUpload = "upload"
+	// ScopeDelete allows DELETE requests: files, folders, the trash, shares,
+	// links, rules, sessions and keys
+	ScopeDelete = "delete"
+	// ScopeAdmin allows the admin endpoints, for keys of admins
+	ScopeAdmin = "admin"
+)
+
+// APIKeyScopes lists every scope
+var APIKeyScopes = []string{ScopeRead, ScopeUpload, ScopeDelete, ScopeAdmin}
+
+// APIKey authenticates requests of a user in X-API-Key. A user may hold
+// several keys, each limited to its scopes; only the SHA-256 of a key is
+// stored, so the key itself is only shown when it is created.
+type APIKey struct {
+	ID      uint   `json:"id" gorm:"primaryKey"`
+	UserID  uint   `json:"user_id" gorm:"not null;index"`
+	Label   string `json:"label" gorm:"size:100;not null;default:''"`
+	KeyHash string `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	// Prefix is the start of the key, to tell keys apart in listings
+	Prefix string `json:"prefix" gorm:"size:8;not null;default:''"`
+	// Scopes is comma separated, e.g. "read,upload"
+	Scopes    string     `json:"scopes" gorm:"not null"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// FolderPath receives uploads made with the key that don't pass
+	// folder_path, for integrations that can't set form fields
+	FolderPath string `json:"folder_path" gorm:"default:''"`
+
+	// Updated at most once a minute
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	LastUsedIP string     `json:"last_used_ip,omitempty" gorm:"size:64"`
+
+	CreatedAt time.Time `json:"created_at"`
+
+	// Key is only set in the response that created the key, along with
+	// the credentials of the S3 gateway when it is on
+	Key               string `json:"key,omitempty" gorm:"-"`
+	S3AccessKeyID     string `json:"s3_access_key_id,omitempty" gorm:"-"`
+	S3SecretAccessKey string `json:"s3_secret_access_key,omitempty" gorm:"-"`
+}
+
+// ScopeList returns the scopes of the key
+func (k *APIKey) ScopeList() []string {
+	scopes := []string{}
+	for _, scope := range strings.Split(k.Scopes, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// Expired reports whether the key may no longer be used
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
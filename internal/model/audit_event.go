@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+)
+
+// Audit actions
+const (
+	AuditLogin                = "login"
+	AuditPasswordChanged      = "password_changed"
+	AuditPasswordReset        = "password_reset"
+	AuditEmailChanged         = "email_changed"
+	AuditImpersonationStarted = "impersonation_started"
+	AuditFileDeleted          = "file_deleted"
+	AuditFileQuarantined      = "file_quarantined"
+	AuditFileReleased         = "file_released"
+	AuditFileTransferred      = "file_transferred"
+	AuditWriteOnceReleased    = "write_once_released"
+	AuditSettingChanged       = "setting_changed"
+)
+
+// AuditEvent records a security-relevant action on a user's account, or an
+// admin action on one of their files. Events
+// are listed to the user as their activity feed and to admins.
+type AuditEvent struct {
+	ID     uint `json:"id" gorm:"primaryKey"`
+	UserID uint `json:"user_id" gorm:"not null;index"`
+	// ActorID is set when someone else acted on the account, e.g. an admin
+	ActorID   *uint     `json:"actor_id,omitempty" gorm:"index"`
+	Action    string    `json:"action" gorm:"not null;size:64;index"`
+	Details   string    `json:"details,omitempty" gorm:"type:text"` // JSON encoded details
+	IP        string    `json:"ip" gorm:"size:64"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"time"
+)
+
+// Blob is content a user stored, kept once however many of their files have
+// it: an upload whose SHA-256 matches a blob of its owner points at the
+// stored file of the blob instead of keeping its own. RefCount counts the
+// files pointing at it, in the trash too; the blob goes with the last one.
+type Blob struct {
+	ID          uint      `json:"-" gorm:"primaryKey"`
+	UserID      uint      `json:"-" gorm:"not null;uniqueIndex:idx_blobs_user_sha256"`
+	SHA256      string    `json:"sha256" gorm:"size:64;not null;uniqueIndex:idx_blobs_user_sha256"`
+	FilePath    string    `json:"-" gorm:"not null"`
+	Compression string    `json:"-"`
+	StoredSize  int64     `json:"-"`
+	RefCount    int64     `json:"ref_count" gorm:"not null;default:0"`
+	CreatedAt   time.Time `json:"created_at"`
+}
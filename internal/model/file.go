@@ -2,8 +2,80 @@ package model
 
 import (
 	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Virus scan statuses of a file
+const (
+	ScanPending   = "pending"
+	ScanClean     = "clean"
+	ScanInfected  = "infected"
+	ScanUnscanned = "unscanned"
+	// ScanQuarantined marks files an admin quarantined; scans leave them alone
+	ScanQuarantined = "quarantined"
+)
+
+// Deferred processing states of images uploaded directly; images uploaded
+// through the API are processed before they are stored and have none
+const (
+	ProcessingUnprocessed = "unprocessed"
+	ProcessingPending     = "pending"
+	ProcessingDone        = "processed"
+	ProcessingFailed      = "failed"
 )
 
+// Coarse file kinds derived from the MIME type and extension at upload
+const (
+	KindImage    = "image"
+	KindVideo    = "video"
+	KindAudio    = "audio"
+	KindDocument = "document"
+	KindArchive  = "archive"
+	KindCode     = "code"
+	KindOther    = "other"
+)
+
+// Kinds lists every file kind
+var Kinds = []string{KindImage, KindVideo, KindAudio, KindDocument, KindArchive, KindCode, KindOther}
+
+// Ways a file arrives, see UploadSource
+const (
+	SourceWeb          = "web"           // Web UI, signed in with a session
+	SourceAPI          = "api"           // API key
+	SourceUploadLink   = "upload_link"   // Public upload link
+	SourceAnonymous    = "anonymous"     // Anonymous upload awaiting moderation
+	SourceDirectUpload = "direct_upload" // Upload URL issued by ImageHandler.CreateDirectUpload
+	SourceImport       = "import"        // Directory import by an admin
+	SourceS3           = "s3"            // S3-compatible gateway
+	SourceWebDAV       = "webdav"        // WebDAV
+)
+
+// UploadSources lists every upload source
+var UploadSources = []string{SourceWeb, SourceAPI, SourceUploadLink, SourceAnonymous, SourceDirectUpload, SourceImport, SourceS3, SourceWebDAV}
+
+// UploadSource records how a file arrived and from which client, to audit
+// automated integrations. Files stored before it was recorded have none.
+type UploadSource struct {
+	Source string `json:"source,omitempty" gorm:"index"` // See SourceWeb
+	// Label names the API key's account, which differs from the owner for
+	// service accounts acting on their behalf, or the upload link
+	Label     string `json:"label,omitempty"`
+	IP        string `json:"ip,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+}
+
+// ValidUploadSource reports whether source is one of UploadSources
+func ValidUploadSource(source string) bool {
+	for _, s := range UploadSources {
+		if s == source {
+			return true
+		}
+	}
+	return false
+}
+
 type File struct {
 	ID           uint      `json:"id" gorm:"primaryKey"`
 	UserID       uint      `json:"user_id" gorm:"not null;index"`
@@ -11,8 +83,120 @@ type File struct {
 	OriginalName string    `json:"original_name" gorm:"not null"`
 	FilePath     string    `json:"file_path" gorm:"not null"`
 	FolderPath   string    `json:"folder_path" gorm:"default:''"` // Virtual folder path for organization
-	FileSize     int64     `json:"file_size" gorm:"not null"`
+	FileSize     int64     `json:"file_size" gorm:"not null;index"`
 	MimeType     string    `json:"mime_type" gorm:"not null"`
+	Kind         string    `json:"kind" gorm:"index"` // See KindImage
 	URL          string    `json:"url" gorm:"-"`
-	CreatedAt    time.Time `json:"created_at"`
+	BlobURL      string    `json:"blob_url,omitempty" gorm:"-"`      // Content-addressed URL, see FileService.FindBlob
+	RelativePath string    `json:"relative_path,omitempty" gorm:"-"` // Path below the listed folder in recursive listings
+	CreatedAt    time.Time `json:"created_at" gorm:"index"`
+	UpdatedAt    time.Time `json:"updated_at" gorm:"index"` // Last rename, move, transfer or content change
+
+	// Type views recorded at upload: client Content-Type, filename extension and content sniffing
+	DeclaredMimeType  string `json:"declared_mime_type"`
+	ExtensionMimeType string `json:"extension_mime_type"`
+	DetectedMimeType  string `json:"detected_mime_type"`
+	MimeMismatch      bool   `json:"mime_mismatch" gorm:"default:false"`
+
+	// Image metadata recorded at upload; zero for non-images and legacy rows
+	Width        int    `json:"width,omitempty"`
+	Height       int    `json:"height,omitempty"`
+	FrameCount   int    `json:"frame_count,omitempty"`
+	ColorProfile string `json:"color_profile,omitempty"` // "icc", "srgb" or empty
+	// Name of the embedded ICC profile, e.g. "Display P3", or "sRGB" for
+	// PNGs marked sRGB; empty for untagged images, which are shown as sRGB
+	ColorSpace string `json:"color_space,omitempty"`
+
+	// Processing profile the image was uploaded with, empty for the default treatment
+	ProcessingProfile string `json:"processing_profile,omitempty"`
+	// Processing state of a direct upload, see ProcessingUnprocessed
+	ProcessingStatus string `json:"processing_status,omitempty"`
+
+	// Compression at rest, "gzip" or "zstd"; FileSize stays the uploaded size
+	// and StoredSize is the size on disk
+	Compression string `json:"compression,omitempty"`
+	StoredSize  int64  `json:"stored_size,omitempty"`
+
+	// Checksums of the content as uploaded, hex encoded; empty for files
+	// stored before they were recorded until the checksums backfill ran
+	SHA256 string `json:"sha256,omitempty" gorm:"size:64;index"`
+	MD5    string `json:"md5,omitempty" gorm:"size:32"`
+	// Blob the content is counted in, see Blob; nil for content that isn't,
+	// such as edits, restored versions and files stored before blobs
+	BlobID *uint `json:"-" gorm:"index"`
+
+	// Lowercase extension of the original name without the dot, e.g. "pdf";
+	// kept in sync on save
+	Extension string `json:"extension" gorm:"index"`
+
+	// Orders names naturally, see NaturalSortKey; kept in sync on save
+	NameSortKey string `json:"-" gorm:"index"`
+	// Matches name searches regardless of case and accents, see SearchKey;
+	// kept in sync on save
+	SearchKey string `json:"-"`
+	// NameWarning flags names that may not read as what they are, see
+	// NameWarningBidi; only set when FLAG_SUSPICIOUS_NAMES is on
+	NameWarning string `json:"name_warning,omitempty" gorm:"size:32"`
+
+	// Virus scan status, see ScanPending; ScanSignature names the malware found
+	ScanStatus    string     `json:"scan_status" gorm:"default:unscanned;index"`
+	ScanSignature string     `json:"scan_signature,omitempty"`
+	ScannedAt     *time.Time `json:"scanned_at,omitempty"`
+
+	// How the file was uploaded, stored as upload_source, upload_ip, ...
+	Upload UploadSource `json:"upload" gorm:"embedded;embeddedPrefix:upload_"`
+
+	// Version numbers the content; it grows by one each time new content
+	// replaces the current one, which is kept as a FileVersion
+	Version int `json:"version" gorm:"not null;default:1"`
+
+	// Pinned files are exempt from lifecycle actions such as folder rule expiry
+	Pinned bool `json:"pinned" gorm:"not null;default:false"`
+
+	// ExpiresAt is set for scratch files, which are deleted once it passed.
+	// They are left out of folder listings and the storage limits.
+	ExpiresAt *time.Time `json:"expires_at,omitempty" gorm:"index"`
+
+	// DeletedAt is set while the file is in the trash. GORM leaves trashed
+	// files out of every query that isn't Unscoped.
+	DeletedAt gorm.DeletedAt `json:"deleted_at" gorm:"index"`
+
+	// Derived renditions such as thumbnails, loaded on demand
+	Variants []FileVariant `json:"variants,omitempty" gorm:"-"`
+	// Exif is attached to images uploaded with EXIF data, see ImageMetadata
+	Exif *ImageMetadata `json:"exif,omitempty" gorm:"-"`
+	// Tags are attached by listings and file lookups, see FileTag
+	Tags []string `json:"tags,omitempty" gorm:"-"`
+}
+
+// BeforeSave enforces the invariants of a file on every write of the whole
+// record, whichever code path makes it: the original name must be a plain
+// file name in Unicode form NFC, the folder path is normalized and derived
+// columns are kept in sync with the fields they derive from. Updates of
+// single columns don't carry the record and are left alone.
+func (f *File) BeforeSave(tx *gorm.DB) error {
+	if _, ok := tx.Statement.Dest.(map[string]interface{}); ok {
+		return nil
+	}
+	f.OriginalName = NormalizeName(f.OriginalName)
+	if err := ValidateOriginalName(f.OriginalName); err != nil {
+		return err
+	}
+	f.FolderPath = CleanFolderPath(f.FolderPath)
+	f.Kind = FileKind(f.MimeType, f.OriginalName)
+	f.Extension = FileExtension(f.OriginalName)
+	f.NameSortKey = NaturalSortKey(f.OriginalName)
+	f.SearchKey = SearchKey(f.OriginalName)
+	return nil
+}
+
+// AfterSave creates the folder of a file and its parents unless they exist,
+// in the transaction that saved the file. Scratch files, which listings
+// leave out, and updates of single columns create none.
+func (f *File) AfterSave(tx *gorm.DB) error {
+	if _, ok := tx.Statement.Dest.(map[string]interface{}); ok || f.FolderPath == "" || f.ExpiresAt != nil {
+		return nil
+	}
+	folders := FolderTree(f.UserID, f.FolderPath)
+	return tx.Session(&gorm.Session{NewDB: true}).Clauses(clause.OnConflict{DoNothing: true}).Create(&folders).Error
 }
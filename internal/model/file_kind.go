@@ -0,0 +1,104 @@
+package model
+
+import (
+	"path/filepath"
+	"storage-service/internal/detect"
+	"strings"
+)
+
+// codeExtensions are source and config files, which are mostly stored as
+// text/plain or application/octet-stream
+var codeExtensions = map[string]bool{
+	".go": true, ".py": true, ".js": true, ".mjs": true, ".ts": true, ".tsx": true, ".jsx": true,
+	".java": true, ".kt": true, ".c": true, ".h": true, ".cpp": true, ".hpp": true, ".cs": true,
+	".rs": true, ".rb": true, ".php": true, ".swift": true, ".sh": true, ".bash": true, ".ps1": true,
+	".sql": true, ".json": true, ".yaml": true, ".yml": true, ".toml": true, ".ini": true, ".xml": true,
+	".html": true, ".htm": true, ".css": true, ".scss": true, ".vue": true, ".lua": true, ".r": true,
+}
+
+var documentTypes = map[string]bool{
+	"application/pdf":               true,
+	"application/rtf":               true,
+	"application/msword":            true,
+	"application/vnd.ms-excel":      true,
+	"application/vnd.ms-powerpoint": true,
+	"application/epub+zip":          true,
+	"text/plain":                    true,
+	"text/markdown":                 true,
+	"text/csv":                      true,
+	"application/csv":               true,
+}
+
+var archiveTypes = map[string]bool{
+	"application/zip":              true,
+	"application/gzip":             true,
+	"application/x-gzip":           true,
+	"application/x-tar":            true,
+	"application/x-bzip2":          true,
+	"application/x-xz":             true,
+	"application/zstd":             true,
+	"application/x-7z-compressed":  true,
+	"application/x-rar-compressed": true,
+	"application/vnd.rar":          true,
+}
+
+var codeTypes = map[string]bool{
+	"application/json":       true,
+	"application/javascript": true,
+	"application/xml":        true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/sql":        true,
+	"application/x-sh":       true,
+	"text/html":              true,
+	"text/css":               true,
+	"text/javascript":        true,
+	"text/xml":               true,
+}
+
+// FileKind classifies a file by its MIME type, falling back to the filename
+// extension when the type is generic
+func FileKind(mimeType, filename string) string {
+	mimeType = detect.Normalize(mimeType)
+	if codeExtensions[strings.ToLower(filepath.Ext(filename))] {
+		return KindCode
+	}
+	if kind := kindOfType(mimeType); kind != KindOther {
+		return kind
+	}
+	if extType := detect.ExtensionType(filename); extType != "" {
+		return kindOfType(extType)
+	}
+	return KindOther
+}
+
+func kindOfType(mimeType string) string {
+	major, _, _ := strings.Cut(mimeType, "/")
+	switch {
+	case major == "image":
+		return KindImage
+	case major == "video":
+		return KindVideo
+	case major == "audio":
+		return KindAudio
+	case archiveTypes[mimeType]:
+		return KindArchive
+	case codeTypes[mimeType] || strings.HasPrefix(mimeType, "text/x-"):
+		return KindCode
+	case documentTypes[mimeType],
+		strings.HasPrefix(mimeType, "application/vnd.openxmlformats-officedocument."),
+		strings.HasPrefix(mimeType, "application/vnd.oasis.opendocument."):
+		return KindDocument
+	}
+	return KindOther
+}
+
+// ValidKind reports whether kind is one of Kinds
+func ValidKind(kind string) bool {
+	for _, k := range Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
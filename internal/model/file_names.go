@@ -0,0 +1,48 @@
+package model
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidOriginalName is returned when a file is saved with a name that
+// is empty, a path or contains control characters
+var ErrInvalidOriginalName = errors.New("original name must be a file name without slashes or control characters")
+
+// CleanFolderPath normalizes a virtual folder path and strips traversal.
+// Paths are kept in Unicode form NFC, see NormalizeName.
+func CleanFolderPath(path string) string {
+	// Remove leading/trailing slashes and whitespace
+	path = NormalizeName(strings.TrimSpace(path))
+	path = strings.Trim(path, "/\\")
+
+	// Remove any path traversal attempts
+	path = strings.ReplaceAll(path, "..", "")
+	path = strings.ReplaceAll(path, "//", "/")
+
+	// Replace backslashes with forward slashes
+	path = strings.ReplaceAll(path, "\\", "/")
+
+	return path
+}
+
+// ValidateOriginalName checks that name is a single file name as uploads
+// store it, see ErrInvalidOriginalName
+func ValidateOriginalName(name string) error {
+	if strings.TrimSpace(name) == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return ErrInvalidOriginalName
+	}
+	for _, r := range name {
+		if r < 32 || r == 127 {
+			return ErrInvalidOriginalName
+		}
+	}
+	return nil
+}
+
+// FileExtension returns the lowercase extension of a file name without the
+// dot, e.g. "pdf", or "" if it has none
+func FileExtension(name string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// FileTag is a label a user put on one of their files. Tags are lowercase,
+// see FileService.Batch, and a file has each tag at most once.
+type FileTag struct {
+	ID        uint      `json:"-" gorm:"primaryKey"`
+	FileID    uint      `json:"file_id" gorm:"not null;uniqueIndex:idx_file_tags_file_tag"`
+	UserID    uint      `json:"user_id" gorm:"not null;index"`
+	Tag       string    `json:"tag" gorm:"not null;size:64;uniqueIndex:idx_file_tags_file_tag;index"`
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,24 @@
+package model
+
+import (
+	"time"
+)
+
+// FileVariant is a rendition derived from a file, such as a thumbnail.
+// Variants are regenerated from the original and never edited directly.
+type FileVariant struct {
+	ID        uint      `json:"id" gorm:"primaryKey"`
+	FileID    uint      `json:"file_id" gorm:"not null;uniqueIndex:idx_file_variant"`
+	Name      string    `json:"name" gorm:"not null;uniqueIndex:idx_file_variant"`
+	FilePath  string    `json:"-" gorm:"not null"`
+	MimeType  string    `json:"mime_type" gorm:"not null"`
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	FileSize  int64     `json:"file_size"`
+	URL       string    `json:"url" gorm:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// LastAccessedAt orders variants for eviction from the derived-asset cache
+	LastAccessedAt time.Time `json:"-" gorm:"index"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+)
+
+// FileVersion is an earlier content of a file, kept when an edit, a
+// re-upload under the same name or a restore replaced it. The current
+// content is the file itself, numbered File.Version.
+type FileVersion struct {
+	ID          uint   `json:"-" gorm:"primaryKey"`
+	FileID      uint   `json:"file_id" gorm:"not null;uniqueIndex:idx_file_version"`
+	Version     int    `json:"version" gorm:"not null;uniqueIndex:idx_file_version"`
+	Filename    string `json:"-" gorm:"not null"`
+	FilePath    string `json:"-" gorm:"not null;index"`
+	FileSize    int64  `json:"file_size"`
+	MimeType    string `json:"mime_type"`
+	Compression string `json:"-"`
+	StoredSize  int64  `json:"-"`
+	SHA256      string `json:"sha256,omitempty" gorm:"size:64"`
+	MD5         string `json:"md5,omitempty" gorm:"size:32"`
+	ScanStatus  string `json:"scan_status"`
+	// CreatedAt is when newer content replaced this one
+	CreatedAt time.Time `json:"created_at"`
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Folder is a folder of a user. Folders are created explicitly, so they
+// can be empty, and for the folders files are saved in along with their
+// parents, see File.AfterSave; they stay once their files are gone until
+// they are deleted. Folders of files saved bypassing the record, like
+// transfers, exist through the folder_path of their files only.
+type Folder struct {
+	ID     uint   `json:"id" gorm:"primaryKey"`
+	UserID uint   `json:"user_id" gorm:"not null;uniqueIndex:idx_user_folder;index:idx_folder_parent"`
+	Path   string `json:"path" gorm:"not null;uniqueIndex:idx_user_folder"`
+	// Name and Parent, the path of the parent folder and "" at the top
+	// level, derive from Path and are kept in sync on save
+	Name        string `json:"name" gorm:"not null;default:''"`
+	Parent      string `json:"parent" gorm:"not null;default:'';index:idx_folder_parent"`
+	Starred     bool   `json:"starred" gorm:"not null;default:false"`
+	Color       string `json:"color,omitempty"` // One of FolderColors
+	Description string `json:"description,omitempty" gorm:"type:text"`
+	// WriteOnce folders and their subfolders take new files, but their files
+	// can't be changed, renamed, moved or deleted until an admin releases
+	// the folder
+	WriteOnce bool `json:"write_once" gorm:"not null;default:false"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// FolderColors are the color labels a folder can carry
+var FolderColors = []string{"red", "orange", "yellow", "green", "blue", "purple", "pink", "gray"}
+
+// BeforeSave derives the name and parent of a folder from its path
+func (f *Folder) BeforeSave(tx *gorm.DB) error {
+	f.Parent, f.Name = SplitFolderPath(f.Path)
+	return nil
+}
+
+// SplitFolderPath returns the parent path and the name of a cleaned folder
+// path, see CleanFolderPath; the parent of a top-level folder is ""
+func SplitFolderPath(path string) (parent, name string) {
+	if i := strings.LastIndex(path, "/"); i >= 0 {
+		return path[:i], path[i+1:]
+	}
+	return "", path
+}
+
+// FolderTree returns the folders of userID along folderPath, from the top
+// level down to the folder itself
+func FolderTree(userID uint, folderPath string) []Folder {
+	if folderPath == "" {
+		return nil
+	}
+	segments := strings.Split(folderPath, "/")
+	folders := make([]Folder, len(segments))
+	for i := range segments {
+		folders[i] = Folder{UserID: userID, Path: strings.Join(segments[:i+1], "/")}
+	}
+	return folders
+}
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+)
+
+// FolderRule automates a folder: files landing in it can be processed with
+// an image profile and announced to a webhook, and old files expire
+type FolderRule struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	UserID     uint   `json:"user_id" gorm:"not null;index"`
+	Name       string `json:"name"`
+	FolderPath string `json:"folder_path" gorm:"not null;default:''"`
+	// Recursive applies the rule to subfolders as well
+	Recursive bool `json:"recursive" gorm:"not null"`
+	Enabled   bool `json:"enabled" gorm:"not null"`
+
+	// Actions; at least one is set
+	Profile       string `json:"profile,omitempty"`
+	WebhookURL    string `json:"webhook_url,omitempty" gorm:"type:text"`
+	WebhookSecret string `json:"webhook_secret,omitempty"` // Signs webhook bodies, see README
+	ExpireAfter   string `json:"expire_after,omitempty"`   // e.g. "7d" or "12h"
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,25 @@
+package model
+
+import (
+	"time"
+)
+
+// ImageMetadata is what the camera recorded in the EXIF data of an uploaded
+// image. Width and Height are those of the upload as shown, before it was
+// resized.
+type ImageMetadata struct {
+	ID          uint       `json:"-" gorm:"primaryKey"`
+	FileID      uint       `json:"file_id" gorm:"not null;uniqueIndex"`
+	CameraMake  string     `json:"camera_make,omitempty"`
+	CameraModel string     `json:"camera_model,omitempty" gorm:"index"`
+	LensModel   string     `json:"lens_model,omitempty"`
+	TakenAt     *time.Time `json:"taken_at,omitempty" gorm:"index"`
+	Width       int        `json:"width"`
+	Height      int        `json:"height"`
+	// Orientation is the EXIF orientation of the upload, 1 when it was upright
+	Orientation int `json:"orientation"`
+	// HasGPS reports that the upload recorded a location, which the stored
+	// image keeps unless IMAGE_STRIP_GPS is set
+	HasGPS    bool      `json:"has_gps"`
+	CreatedAt time.Time `json:"created_at"`
+}
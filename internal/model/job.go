@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+)
+
+// Job statuses
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+	JobPaused    = "paused"
+)
+
+// Job is a resumable background operation processed in batches. Cursor holds
+// the last processed record ID so an interrupted job continues where it stopped.
+// Jobs that walk something else than records, like a directory tree, keep
+// their position in Checkpoint instead.
+type Job struct {
+	ID         uint       `json:"id" gorm:"primaryKey"`
+	Type       string     `json:"type" gorm:"not null;index"`
+	Status     string     `json:"status" gorm:"not null;index"`
+	Params     string     `json:"params,omitempty" gorm:"type:text"` // JSON encoded parameters
+	Cursor     uint       `json:"cursor"`
+	Total      int64      `json:"total"`
+	Processed  int64      `json:"processed"`
+	Failed     int64      `json:"failed"`
+	Error      string     `json:"error,omitempty"`
+	CreatedBy  uint       `json:"created_by"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+
+	Checkpoint string `json:"checkpoint,omitempty" gorm:"type:text"`
+}
@@ -0,0 +1,70 @@
+package model
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Warnings about file names that may not read as what they are, see
+// NameWarning
+const (
+	// NameWarningBidi names contain bidirectional controls, which can
+	// reorder how a name is shown, e.g. to hide an ".exe" extension
+	NameWarningBidi = "bidi_control"
+	// NameWarningInvisible names contain zero-width or other invisible
+	// characters, so two names that look the same are different
+	NameWarningInvisible = "invisible_character"
+	// NameWarningMixedScript names mix Latin, Cyrillic or Greek letters in
+	// one word, like "pаypal" with a Cyrillic "а"
+	NameWarningMixedScript = "mixed_script"
+)
+
+// NormalizeName returns name in Unicode normalization form C, so that a
+// name typed with combining accents, as macOS does, and one typed with
+// precomposed letters are stored the same
+func NormalizeName(name string) string {
+	return norm.NFC.String(name)
+}
+
+// foldedLetters are letters that don't decompose into a base letter and an
+// accent but are searched for as one
+var foldedLetters = strings.NewReplacer("đ", "d", "ø", "o", "ł", "l", "ß", "ss", "æ", "ae", "œ", "oe", "ı", "i")
+
+// SearchKey folds a name for searching: lowercase, without accents, so
+// "Résumé.pdf" and "resume.pdf" have the same key
+func SearchKey(name string) string {
+	var key strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(name)) {
+		if !unicode.Is(unicode.Mn, r) {
+			key.WriteRune(r)
+		}
+	}
+	return foldedLetters.Replace(norm.NFC.String(key.String()))
+}
+
+// NameWarning returns why a name may be misleading, see NameWarningBidi, or
+// "" for names that read as what they are
+func NameWarning(name string) string {
+	for _, r := range name {
+		switch {
+		case unicode.Is(unicode.Bidi_Control, r):
+			return NameWarningBidi
+		case r == '\u200b' || r == '\u2060' || r == '\ufeff' || unicode.Is(unicode.Join_Control, r):
+			return NameWarningInvisible
+		}
+	}
+	for _, word := range strings.FieldsFunc(name, func(r rune) bool { return !unicode.IsLetter(r) }) {
+		var latin, cyrillic, greek bool
+		for _, r := range word {
+			latin = latin || unicode.Is(unicode.Latin, r)
+			cyrillic = cyrillic || unicode.Is(unicode.Cyrillic, r)
+			greek = greek || unicode.Is(unicode.Greek, r)
+		}
+		if (latin && cyrillic) || (latin && greek) || (cyrillic && greek) {
+			return NameWarningMixedScript
+		}
+	}
+	return ""
+}
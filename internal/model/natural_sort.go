@@ -0,0 +1,34 @@
+package model
+
+import (
+	"strconv"
+	"strings"
+)
+
+// NaturalSortKey returns a key that sorts names naturally when compared
+// bytewise: case-insensitively and with numbers by value, so "file2" comes
+// before "File10". Each run of digits is prefixed with its length.
+func NaturalSortKey(name string) string {
+	name = strings.ToLower(name)
+	var key strings.Builder
+	for i := 0; i < len(name); {
+		if name[i] < '0' || name[i] > '9' {
+			key.WriteByte(name[i])
+			i++
+			continue
+		}
+		j := i
+		for j < len(name) && name[j] >= '0' && name[j] <= '9' {
+			j++
+		}
+		digits := strings.TrimLeft(name[i:j], "0")
+		if digits == "" {
+			digits = "0"
+		}
+		// Two digit length prefixes; numbers this long compare as text
+		key.WriteString(strconv.Itoa(min(len(digits)+10, 99)))
+		key.WriteString(digits)
+		i = j
+	}
+	return key.String()
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"time"
+)
+
+// PasswordReset is a single-use token sent by email to reset a password.
+// Only the SHA-256 of the token is stored.
+type PasswordReset struct {
+	ID        uint       `json:"id" gorm:"primaryKey"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
@@ -0,0 +1,21 @@
+package model
+
+import (
+	"time"
+)
+
+// ProxyCacheEntry is an optimized copy of a remote image fetched through the
+// image proxy, cached per user
+type ProxyCacheEntry struct {
+	ID             uint      `json:"id" gorm:"primaryKey"`
+	UserID         uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_proxy_cache_key"`
+	CacheKey       string    `json:"cache_key" gorm:"not null;size:64;uniqueIndex:idx_proxy_cache_key"`
+	SourceURL      string    `json:"source_url" gorm:"type:text;not null"`
+	Width          int       `json:"width"`
+	FilePath       string    `json:"-" gorm:"not null"`
+	MimeType       string    `json:"mime_type" gorm:"not null"`
+	FileSize       int64     `json:"file_size"`
+	LastAccessedAt time.Time `json:"last_accessed_at" gorm:"index"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
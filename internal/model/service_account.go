@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+)
+
+// ServiceAccountGrant allows a service account to act on behalf of a user
+// via the X-On-Behalf-Of header
+type ServiceAccountGrant struct {
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	ServiceAccountID uint      `json:"service_account_id" gorm:"not null;uniqueIndex:idx_service_account_grant"`
+	UserID           uint      `json:"user_id" gorm:"not null;uniqueIndex:idx_service_account_grant"`
+	CreatedAt        time.Time `json:"created_at"`
+}
@@ -0,0 +1,23 @@
+package model
+
+import (
+	"time"
+)
+
+// Session is a login from one device. Clients hold a short-lived access
+// token and a refresh token; only the SHA-256 of the refresh token is stored.
+type Session struct {
+	ID          uint      `json:"id" gorm:"primaryKey"`
+	UserID      uint      `json:"user_id" gorm:"not null;index"`
+	RefreshHash string    `json:"-" gorm:"not null;size:64;uniqueIndex"`
+	Device      string    `json:"device" gorm:"size:255"`
+	IP          string    `json:"ip" gorm:"size:64"`
+	LastSeenAt  time.Time `json:"last_seen_at"`
+	ExpiresAt   time.Time `json:"expires_at" gorm:"index"`
+	CreatedAt   time.Time `json:"created_at"`
+	Current     bool      `json:"current" gorm:"-"`
+
+	// Set for sessions an admin opened to act as the user; they expire
+	// without refresh
+	ImpersonatorID *uint `json:"impersonator_id,omitempty" gorm:"index"`
+}
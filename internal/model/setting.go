@@ -0,0 +1,15 @@
+package model
+
+import (
+	"time"
+)
+
+// Setting is a runtime setting an admin changed, overriding the environment
+// variable it is named after; see service.SettingsService. Value has the
+// syntax of the environment variable.
+type Setting struct {
+	Key       string    `json:"key" gorm:"primaryKey;size:64"`
+	Value     string    `json:"value" gorm:"type:text;not null"`
+	UpdatedBy uint      `json:"updated_by"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+)
+
+// Share is a public link to a file. Anyone holding the token can view its
+// landing page and download the file until it expires or is revoked.
+type Share struct {
+	ID            uint       `json:"id" gorm:"primaryKey"`
+	Token         string     `json:"token" gorm:"not null;size:64;uniqueIndex"`
+	FileID        uint       `json:"file_id" gorm:"not null;index"`
+	UserID        uint       `json:"user_id" gorm:"not null;index"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	DownloadCount int64      `json:"download_count" gorm:"not null;default:0"`
+	URL           string     `json:"url" gorm:"-"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
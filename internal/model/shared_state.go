@@ -0,0 +1,14 @@
+package model
+
+import (
+	"time"
+)
+
+// SharedState is a short-lived key/value entry shared by all service
+// instances, used for upload sessions, counters and scheduler claims
+type SharedState struct {
+	Key       string    `gorm:"primaryKey;size:255"`
+	Value     []byte    `gorm:"type:bytea"`
+	Counter   int64     `gorm:"not null;default:0"`
+	ExpiresAt time.Time `gorm:"not null;index"`
+}
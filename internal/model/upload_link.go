@@ -0,0 +1,34 @@
+package model
+
+import (
+	"time"
+)
+
+// UploadLink is a public upload-only link to a folder. Anyone holding the
+// token can upload files into the folder of its owner, within the limits of
+// the link, but can't see what is in it.
+type UploadLink struct {
+	ID         uint   `json:"id" gorm:"primaryKey"`
+	Token      string `json:"token" gorm:"not null;size:64;uniqueIndex"`
+	UserID     uint   `json:"user_id" gorm:"not null;index"`
+	Name       string `json:"name"`
+	FolderPath string `json:"folder_path" gorm:"not null;default:''"`
+
+	// Limits; empty or 0 means unlimited. Extensions are comma separated
+	// and lowercase, e.g. "pdf,docx"
+	AllowedExtensions string     `json:"allowed_extensions" gorm:"not null;default:''"`
+	MaxFiles          int        `json:"max_files" gorm:"not null;default:0"`
+	MaxTotalSize      int64      `json:"max_total_size" gorm:"not null;default:0"`
+	ExpiresAt         *time.Time `json:"expires_at,omitempty"`
+
+	// NotifyEmail gets an email per received file
+	NotifyEmail string `json:"notify_email,omitempty"`
+
+	// Received so far, counted against the limits
+	FileCount int   `json:"file_count" gorm:"not null;default:0"`
+	TotalSize int64 `json:"total_size" gorm:"not null;default:0"`
+
+	URL       string    `json:"url" gorm:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -2,31 +2,52 @@ package model
 
 import (
 	"time"
-
-	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
 type User struct {
-	ID          uint      `json:"id" gorm:"primaryKey"`
-	Username    string    `json:"username" gorm:"unique;not null"`
-	Email       string    `json:"email" gorm:"unique;not null"`
-	APIKey      string    `json:"api_key" gorm:"unique;not null;index"`
-	MaxFiles    int64     `json:"max_files" gorm:"default:1000"`
-	MaxFileSize int64     `json:"max_file_size" gorm:"default:10485760"`  // 10MB default
-	MaxStorage  int64     `json:"max_storage" gorm:"default:1073741824"` // 1GB default
-	CreatedAt   time.Time `json:"created_at"`
-	UpdatedAt   time.Time `json:"updated_at"`
-	Files       []File    `json:"files,omitempty" gorm:"foreignKey:UserID"`
-}
+	ID               uint      `json:"id" gorm:"primaryKey"`
+	Username         string    `json:"username" gorm:"unique;not null"`
+	Email            string    `json:"email" gorm:"unique;not null"`
+	MaxFiles         int64     `json:"max_files" gorm:"default:1000"`
+	MaxFileSize      int64     `json:"max_file_size" gorm:"default:10485760"`   // 10MB default
+	MaxStorage       int64     `json:"max_storage" gorm:"default:1073741824"`   // 1GB default
+	IsServiceAccount bool      `json:"is_service_account" gorm:"default:false"` // May act for users in ServiceAccountGrant
+	IsAdmin          bool      `json:"is_admin" gorm:"default:false"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Files            []File    `json:"files,omitempty" gorm:"foreignKey:UserID"`
+
+	// bcrypt hash; empty for accounts that only use their API key
+	PasswordHash string `json:"-"`
+
+	// APIKey is only set in responses that issued a new key to the user:
+	// registration, key rotation and credential changes. Keys are APIKey rows.
+	APIKey string `json:"api_key,omitempty" gorm:"-"`
 
-func (u *User) BeforeCreate(tx *gorm.DB) error {
-	if u.APIKey == "" {
-		u.APIKey = uuid.New().String()
-	}
-	return nil
+	// Image types accepted on top of the defaults, comma separated, set by
+	// an admin, e.g. "image/tiff,image/bmp"
+	ExtraImageTypes string `json:"extra_image_types" gorm:"default:''"`
+
+	// Plan whose ceilings bound the limits above, see PLANS; empty is the
+	// default plan. Only admins change it.
+	Plan string `json:"plan" gorm:"default:''"`
+
+	// Stripe subscription of the plan, see BillingStatus; GraceUntil is set
+	// while a failed payment is retried
+	StripeCustomerID     string     `json:"-" gorm:"index"`
+	StripeSubscriptionID string     `json:"-"`
+	BillingStatus        string     `json:"billing_status,omitempty" gorm:"default:''"`
+	GraceUntil           *time.Time `json:"grace_until,omitempty"`
 }
 
-func (u *User) RegenerateAPIKey() {
-	u.APIKey = uuid.New().String()
+// Billing statuses of users who subscribed to a plan
+const (
+	BillingActive   = "active"
+	BillingPastDue  = "past_due"
+	BillingCanceled = "canceled"
+)
+
+// HasPassword reports whether the user can log in with a password
+func (u *User) HasPassword() bool {
+	return u.PasswordHash != ""
 }
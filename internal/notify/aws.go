@@ -0,0 +1,350 @@
+package notify
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// awsCredentials are the keys requests to AWS are signed with. Token is set
+// for temporary credentials, which expire.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	Token           string
+	Expires         time.Time
+}
+
+// awsCredentialChain finds credentials the way the AWS SDKs do, so the
+// service can use the role of the instance, task or pod it runs in:
+// AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, a web identity token
+// (EKS pods), the ECS container endpoint and the EC2 instance metadata.
+// Temporary credentials are cached until shortly before they expire.
+type awsCredentialChain struct {
+	mu     sync.Mutex
+	cached *awsCredentials
+}
+
+// credentials is shared by every AWS publisher
+var credentials awsCredentialChain
+
+func (c *awsCredentialChain) get(ctx context.Context) (*awsCredentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached != nil && (c.cached.Expires.IsZero() || time.Until(c.cached.Expires) > 5*time.Minute) {
+		return c.cached, nil
+	}
+
+	creds, err := c.find(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.cached = creds
+	return creds, nil
+}
+
+func (c *awsCredentialChain) find(ctx context.Context) (*awsCredentials, error) {
+	if id, secret := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && secret != "" {
+		return &awsCredentials{AccessKeyID: id, SecretAccessKey: secret, Token: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if tokenFile, role := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"), os.Getenv("AWS_ROLE_ARN"); tokenFile != "" && role != "" {
+		return assumeRoleWithWebIdentity(ctx, tokenFile, role)
+	}
+	if path := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); path != "" {
+		return containerCredentials(ctx, "http://169.254.170.2"+path, "")
+	}
+	if full := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); full != "" {
+		return containerCredentials(ctx, full, os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"))
+	}
+	creds, err := instanceCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no AWS credentials found in the environment or instance metadata: %w", err)
+	}
+	return creds, nil
+}
+
+// assumeRoleWithWebIdentity exchanges the token of a Kubernetes service
+// account for credentials of role
+func assumeRoleWithWebIdentity(ctx context.Context, tokenFile, role string) (*awsCredentials, error) {
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return nil, err
+	}
+	session := os.Getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = "storage-service"
+	}
+	endpoint := "https://sts.amazonaws.com/"
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		endpoint = "https://sts." + region + ".amazonaws.com/"
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {role},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := send(req, "sts")
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil {
+		return nil, fmt.Errorf("invalid sts response: %w", err)
+	}
+	creds := result.Credentials
+	return &awsCredentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, Token: creds.SessionToken, Expires: creds.Expiration}, nil
+}
+
+// containerCredentials reads the credentials of an ECS task role
+func containerCredentials(ctx context.Context, endpoint, authorization string) (*awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	body, err := send(req, "container credentials")
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoleCredentials(body)
+}
+
+// instanceCredentials reads the credentials of the EC2 instance role with
+// IMDSv2
+func instanceCredentials(ctx context.Context) (*awsCredentials, error) {
+	const imds = "http://169.254.169.254/latest"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, imds+"/api/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "21600")
+	token, err := send(req, "instance metadata")
+	if err != nil {
+		return nil, err
+	}
+
+	get := func(path string) ([]byte, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, imds+path, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Aws-Ec2-Metadata-Token", string(token))
+		return send(req, "instance metadata")
+	}
+	roles, err := get("/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(string(roles)), "\n")
+	if role == "" {
+		return nil, errors.New("the instance has no role")
+	}
+	body, err := get("/meta-data/iam/security-credentials/" + role)
+	if err != nil {
+		return nil, err
+	}
+	return decodeRoleCredentials(body)
+}
+
+func decodeRoleCredentials(body []byte) (*awsCredentials, error) {
+	var creds struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+	if err := json.Unmarshal(body, &creds); err != nil || creds.AccessKeyID == "" {
+		return nil, fmt.Errorf("invalid role credentials: %v", err)
+	}
+	return &awsCredentials{AccessKeyID: creds.AccessKeyID, SecretAccessKey: creds.SecretAccessKey, Token: creds.Token, Expires: creds.Expiration}, nil
+}
+
+// awsQuery is a publisher calling an action of the AWS query API, which SNS
+// and SQS share
+type awsQuery struct {
+	name     string
+	service  string
+	region   string
+	endpoint string
+	// params are sent with every message, with the body under bodyParam and
+	// attributes under attributePrefix
+	params          url.Values
+	bodyParam       string
+	attributePrefix string
+}
+
+// newSNS publishes to a topic ARN such as
+// arn:aws:sns:eu-central-1:123456789012:uploads
+func newSNS(arn string) (*awsQuery, error) {
+	parts := strings.Split(arn, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" || parts[3] == "" {
+		return nil, fmt.Errorf("invalid SNS topic ARN %q", arn)
+	}
+	region := parts[3]
+	return &awsQuery{
+		name:            "sns:" + arn,
+		service:         "sns",
+		region:          region,
+		endpoint:        "https://sns." + region + ".amazonaws.com/",
+		params:          url.Values{"Action": {"Publish"}, "Version": {"2010-03-31"}, "TopicArn": {arn}},
+		bodyParam:       "Message",
+		attributePrefix: "MessageAttributes.entry",
+	}, nil
+}
+
+// newSQS sends to a queue URL such as
+// https://sqs.eu-central-1.amazonaws.com/123456789012/uploads. Queues of
+// services emulating SQS take the region from AWS_REGION.
+func newSQS(queueURL string) (*awsQuery, error) {
+	u, err := url.Parse(queueURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && u.Scheme != "http") {
+		return nil, fmt.Errorf("invalid SQS queue URL %q", queueURL)
+	}
+	region := os.Getenv("AWS_REGION")
+	labels := strings.Split(u.Hostname(), ".")
+	switch {
+	case len(labels) >= 3 && labels[0] == "sqs":
+		region = labels[1]
+	case len(labels) >= 3 && labels[1] == "queue":
+		region = labels[0]
+	}
+	if region == "" {
+		return nil, fmt.Errorf("the region of SQS queue %q is unknown, set AWS_REGION", queueURL)
+	}
+	return &awsQuery{
+		name:            "sqs:" + queueURL,
+		service:         "sqs",
+		region:          region,
+		endpoint:        queueURL,
+		params:          url.Values{"Action": {"SendMessage"}, "Version": {"2012-11-05"}},
+		bodyParam:       "MessageBody",
+		attributePrefix: "MessageAttribute",
+	}, nil
+}
+
+func (q *awsQuery) Name() string { return q.name }
+
+func (q *awsQuery) Publish(ctx context.Context, msg Message) error {
+	creds, err := credentials.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	form := url.Values{}
+	for name, values := range q.params {
+		form[name] = values
+	}
+	form.Set(q.bodyParam, string(msg.Body))
+	names := make([]string, 0, len(msg.Attributes))
+	for name := range msg.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		prefix := q.attributePrefix + "." + strconv.Itoa(i+1) + "."
+		form.Set(prefix+"Name", name)
+		form.Set(prefix+"Value.DataType", "String")
+		form.Set(prefix+"Value.StringValue", msg.Attributes[name])
+	}
+	body := form.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, q.endpoint, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	signAWS(req, []byte(body), q.service, q.region, creds, time.Now())
+	_, err = send(req, q.service)
+	return err
+}
+
+// signAWS adds the Authorization header of AWS Signature Version 4,
+// covering the body and the content type, host and x-amz headers
+func signAWS(req *http.Request, body []byte, service, region string, creds *awsCredentials, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	payloadHash := sha256.Sum256(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	headers := map[string]string{
+		"content-type": req.Header.Get("Content-Type"),
+		"host":         req.URL.Host,
+		"x-amz-date":   amzDate,
+	}
+	if creds.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.Token)
+		headers["x-amz-security-token"] = creds.Token
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.TrimSpace(headers[name]) + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		path,
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,195 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const pubsubScope = "https://www.googleapis.com/auth/pubsub"
+
+// googleTokenSource finds an access token the way the Google client
+// libraries do: from the service account key file GOOGLE_APPLICATION_CREDENTIALS
+// names or else from the metadata server, which serves the service account
+// of the Cloud Run service, GKE workload or Compute Engine instance the
+// service runs on. Tokens are cached until shortly before they expire.
+type googleTokenSource struct {
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// googleTokens is shared by every Pub/Sub publisher
+var googleTokens googleTokenSource
+
+func (t *googleTokenSource) get(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token != "" && time.Until(t.expires) > 5*time.Minute {
+		return t.token, nil
+	}
+
+	var token string
+	var expiresIn int
+	var err error
+	if path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); path != "" {
+		token, expiresIn, err = serviceAccountToken(ctx, path)
+	} else {
+		token, expiresIn, err = metadataToken(ctx)
+	}
+	if err != nil {
+		return "", fmt.Errorf("no Google credentials: %w", err)
+	}
+	t.token, t.expires = token, time.Now().Add(time.Duration(expiresIn)*time.Second)
+	return token, nil
+}
+
+type googleToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+func decodeGoogleToken(body []byte) (string, int, error) {
+	var token googleToken
+	if err := json.Unmarshal(body, &token); err != nil || token.AccessToken == "" {
+		return "", 0, fmt.Errorf("invalid token response: %v", err)
+	}
+	return token.AccessToken, token.ExpiresIn, nil
+}
+
+func metadataToken(ctx context.Context) (string, int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		"http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	body, err := send(req, "metadata server")
+	if err != nil {
+		return "", 0, err
+	}
+	return decodeGoogleToken(body)
+}
+
+// serviceAccountToken exchanges a JWT signed with the key of a service
+// account for an access token
+func serviceAccountToken(ctx context.Context, path string) (string, int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", 0, err
+	}
+	var key struct {
+		Type        string `json:"type"`
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &key); err != nil || key.Type != "service_account" {
+		return "", 0, fmt.Errorf("%s is not a service account key", path)
+	}
+	if key.TokenURI == "" {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return "", 0, errors.New("invalid private key in service account key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid private key in service account key: %w", err)
+	}
+	privateKey, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return "", 0, errors.New("the service account key is not an RSA key")
+	}
+
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   key.ClientEmail,
+		"scope": pubsubScope,
+		"aud":   key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, privateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", 0, err
+	}
+	assertion := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	body, err := send(req, "google oauth")
+	if err != nil {
+		return "", 0, err
+	}
+	return decodeGoogleToken(body)
+}
+
+var pubsubTopicPattern = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// pubsub publishes to a Google Pub/Sub topic
+type pubsub struct {
+	topic string
+}
+
+func newPubSub(topic string) (*pubsub, error) {
+	if !pubsubTopicPattern.MatchString(topic) {
+		return nil, fmt.Errorf("invalid Pub/Sub topic %q, use projects/<project>/topics/<topic>", topic)
+	}
+	return &pubsub{topic: topic}, nil
+}
+
+func (p *pubsub) Name() string { return "pubsub:" + p.topic }
+
+func (p *pubsub) Publish(ctx context.Context, msg Message) error {
+	token, err := googleTokens.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"messages": []map[string]interface{}{{
+			"data":       base64.StdEncoding.EncodeToString(msg.Body),
+			"attributes": msg.Attributes,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		"https://pubsub.googleapis.com/v1/"+p.topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	_, err = send(req, "pubsub")
+	return err
+}
@@ -0,0 +1,82 @@
+// Package notify delivers messages to cloud message services, so serverless
+// consumers can react to file events without polling: AWS SNS topics, AWS
+// SQS queues and Google Pub/Sub topics. Credentials come from the
+// environment the service runs in, as the cloud SDKs find them.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Message is published as its body with string attributes, which consumers
+// can filter on without parsing the body
+type Message struct {
+	Body       []byte
+	Attributes map[string]string
+}
+
+// Publisher sends messages to one topic or queue
+type Publisher interface {
+	// Name identifies the target in logs, e.g. "sqs:https://..."
+	Name() string
+	Publish(ctx context.Context, msg Message) error
+}
+
+// requestTimeout bounds every request to a message service or credential
+// provider
+const requestTimeout = 15 * time.Second
+
+var httpClient = &http.Client{Timeout: requestTimeout}
+
+// NewPublishers parses a comma separated list of targets:
+// "sns:<topic ARN>", "sqs:<queue URL>" and "pubsub:projects/<project>/topics/<topic>"
+func NewPublishers(spec string) ([]Publisher, error) {
+	var publishers []Publisher
+	for _, target := range strings.Split(spec, ",") {
+		target = strings.TrimSpace(target)
+		if target == "" {
+			continue
+		}
+		kind, address, _ := strings.Cut(target, ":")
+		var publisher Publisher
+		var err error
+		switch kind {
+		case "sns":
+			publisher, err = newSNS(address)
+		case "sqs":
+			publisher, err = newSQS(address)
+		case "pubsub":
+			publisher, err = newPubSub(address)
+		default:
+			err = fmt.Errorf("unknown target %q, use sns:, sqs: or pubsub:", target)
+		}
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, publisher)
+	}
+	return publishers, nil
+}
+
+// send sends a request, turning error responses into errors, and returns the
+// body of a successful one
+func send(req *http.Request, service string) ([]byte, error) {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", service, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("%s request failed: %w", service, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("%s responded with %s: %s", service, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
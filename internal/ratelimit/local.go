@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often full buckets are dropped from memory
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	rate    Rate
+}
+
+// refill adds the tokens earned since the last update
+func (b *bucket) refill(now time.Time) {
+	b.tokens = min(b.rate.Limit, b.tokens+now.Sub(b.updated).Seconds()/b.rate.Per.Seconds()*b.rate.Limit)
+	b.updated = now
+}
+
+// LocalLimiter keeps buckets in process memory, for a single instance
+type LocalLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+func NewLocalLimiter() *LocalLimiter {
+	return &LocalLimiter{buckets: make(map[string]*bucket), lastSweep: time.Now()}
+}
+
+func (l *LocalLimiter) Take(_ context.Context, key string, cost float64, rate Rate) (bool, time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(key, rate)
+	if wait := rate.wait(b.tokens, cost); wait > 0 {
+		return false, wait, nil
+	}
+	b.tokens -= cost
+	return true, 0, nil
+}
+
+func (l *LocalLimiter) Spend(_ context.Context, key string, cost float64, rate Rate) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.bucket(key, rate).tokens -= cost
+	return nil
+}
+
+// bucket returns the refilled bucket of key; l.mu must be held
+func (l *LocalLimiter) bucket(key string, rate Rate) *bucket {
+	now := time.Now()
+	if now.Sub(l.lastSweep) > sweepInterval {
+		// A full bucket is the same as a missing one
+		for k, b := range l.buckets {
+			if b.refill(now); b.tokens >= b.rate.Limit {
+				delete(l.buckets, k)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: rate.Limit, updated: now}
+		l.buckets[key] = b
+	}
+	b.rate = rate
+	b.refill(now)
+	return b
+}
@@ -0,0 +1,62 @@
+// Package ratelimit provides token buckets kept in process memory or shared
+// between instances through Redis
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Rate is a bucket of Limit tokens that refills completely over Per
+type Rate struct {
+	Limit float64
+	Per   time.Duration
+}
+
+// need returns the tokens a bucket must hold to spend cost: cost itself,
+// or the full bucket for costs larger than it holds
+func (r Rate) need(cost float64) float64 {
+	return min(cost, r.Limit)
+}
+
+// wait returns how long a bucket holding tokens needs to refill to spend
+// cost, or to above zero for free requests
+func (r Rate) wait(tokens, cost float64) time.Duration {
+	need := r.need(cost)
+	if tokens > 0 && tokens >= need {
+		return 0
+	}
+	return time.Duration((need-tokens)/r.Limit*float64(r.Per)) + time.Millisecond
+}
+
+// Limiter keeps one token bucket per key. Buckets may go into debt, so a
+// single request can cost more than a bucket holds, e.g. an upload larger
+// than the hourly byte limit; it is let through once the bucket is full and
+// later requests wait until the debt is repaid.
+type Limiter interface {
+	// Take spends cost tokens when the bucket holds them, or is full for a
+	// cost larger than it holds. Otherwise nothing is spent and wait tells
+	// when to retry.
+	Take(ctx context.Context, key string, cost float64, rate Rate) (ok bool, wait time.Duration, err error)
+	// Spend spends cost tokens even when the bucket is empty, for costs
+	// only known once the request has been served
+	Spend(ctx context.Context, key string, cost float64, rate Rate) error
+}
+
+// New returns the limiter of a backend: "local" keeps buckets in process
+// memory, "redis" shares them through the server at redisURL, e.g.
+// "redis://:password@localhost:6379/0"
+func New(backend, redisURL string) (Limiter, error) {
+	switch backend {
+	case "", "local":
+		return NewLocalLimiter(), nil
+	case "redis":
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL is required for the redis rate limit backend")
+		}
+		return NewRedisLimiter(redisURL)
+	default:
+		return nil, fmt.Errorf("unknown rate limit backend %q", backend)
+	}
+}
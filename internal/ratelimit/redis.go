@@ -0,0 +1,277 @@
+package ratelimit
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	redisPoolSize    = 16
+	redisDialTimeout = 5 * time.Second
+	// redisTimeout bounds a command when the request context has no deadline
+	redisTimeout = 2 * time.Second
+)
+
+// bucketScript refills and spends a bucket atomically on the Redis server,
+// using the server clock so instances with skewed clocks agree. Buckets
+// expire once they would be full again. Needs Redis 5 or later.
+const bucketScript = `
+local limit = tonumber(ARGV[1])
+local per = tonumber(ARGV[2])
+local cost = tonumber(ARGV[3])
+local clock = redis.call('TIME')
+local now = tonumber(clock[1]) * 1000 + math.floor(tonumber(clock[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(state[1]) or limit
+local updated = tonumber(state[2]) or now
+tokens = math.min(limit, tokens + (now - updated) / per * limit)
+local need = math.min(cost, limit)
+if (tokens <= 0 or tokens < need) and ARGV[4] ~= '1' then
+	return {0, math.ceil((need - tokens) / limit * per) + 1}
+end
+tokens = tokens - cost
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(per + math.max(0, -tokens) / limit * per))
+return {1, 0}
+`
+
+var bucketScriptSHA = func() string {
+	sum := sha1.Sum([]byte(bucketScript))
+	return hex.EncodeToString(sum[:])
+}()
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+type redisConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// RedisLimiter keeps buckets on a Redis server shared by every instance.
+// It speaks the few commands it needs over a small connection pool.
+type RedisLimiter struct {
+	addr     string
+	username string
+	password string
+	db       int
+	tls      *tls.Config
+	conns    chan *redisConn
+}
+
+// NewRedisLimiter parses a redis:// or rediss:// (TLS) URL; no connection is
+// made until the first request
+func NewRedisLimiter(rawURL string) (*RedisLimiter, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid REDIS_URL %q, use redis://[:password@]host[:port][/db]", rawURL)
+	}
+	l := &RedisLimiter{addr: u.Host, conns: make(chan *redisConn, redisPoolSize)}
+	if u.Port() == "" {
+		l.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		l.username = u.User.Username()
+		l.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid database %q in REDIS_URL", db)
+		}
+	}
+	if u.Scheme == "rediss" {
+		l.tls = &tls.Config{ServerName: u.Hostname()}
+	}
+	return l, nil
+}
+
+func (l *RedisLimiter) Take(ctx context.Context, key string, cost float64, rate Rate) (bool, time.Duration, error) {
+	reply, err := l.run(ctx, key, cost, rate, false)
+	if err != nil {
+		return false, 0, err
+	}
+	if reply[0] == 1 {
+		return true, 0, nil
+	}
+	return false, time.Duration(reply[1]) * time.Millisecond, nil
+}
+
+func (l *RedisLimiter) Spend(ctx context.Context, key string, cost float64, rate Rate) error {
+	_, err := l.run(ctx, key, cost, rate, true)
+	return err
+}
+
+// run executes bucketScript, loading it into the server's script cache
+// the first time
+func (l *RedisLimiter) run(ctx context.Context, key string, cost float64, rate Rate, force bool) ([2]int64, error) {
+	args := []string{key,
+		strconv.FormatFloat(rate.Limit, 'f', -1, 64),
+		strconv.FormatInt(rate.Per.Milliseconds(), 10),
+		strconv.FormatFloat(cost, 'f', -1, 64),
+		"0",
+	}
+	if force {
+		args[4] = "1"
+	}
+
+	reply, err := l.do(ctx, append([]string{"EVALSHA", bucketScriptSHA, "1"}, args...)...)
+	var replyErr redisError
+	if errors.As(err, &replyErr) && strings.HasPrefix(string(replyErr), "NOSCRIPT") {
+		reply, err = l.do(ctx, append([]string{"EVAL", bucketScript, "1"}, args...)...)
+	}
+	if err != nil {
+		return [2]int64{}, err
+	}
+
+	values, ok := reply.([]any)
+	if !ok || len(values) != 2 {
+		return [2]int64{}, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+	var result [2]int64
+	for i, value := range values {
+		if result[i], ok = value.(int64); !ok {
+			return [2]int64{}, fmt.Errorf("redis: unexpected reply %v", reply)
+		}
+	}
+	return result, nil
+}
+
+// do sends one command and reads its reply. Connections that fail are
+// dropped; those answering with an error reply go back to the pool.
+func (l *RedisLimiter) do(ctx context.Context, args ...string) (any, error) {
+	conn, err := l.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(redisTimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	reply, err := conn.command(args...)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		conn.Close()
+		return nil, err
+	}
+	select {
+	case l.conns <- conn:
+	default:
+		conn.Close()
+	}
+	return reply, err
+}
+
+// conn takes a connection from the pool or dials a new one
+func (l *RedisLimiter) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	default:
+	}
+
+	dialer := &net.Dialer{Timeout: redisDialTimeout}
+	var netConn net.Conn
+	var err error
+	if l.tls != nil {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: l.tls}).DialContext(ctx, "tcp", l.addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", l.addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	conn := &redisConn{Conn: netConn, reader: bufio.NewReader(netConn)}
+	conn.SetDeadline(time.Now().Add(redisDialTimeout))
+
+	if l.password != "" {
+		auth := []string{"AUTH", l.password}
+		if l.username != "" {
+			auth = []string{"AUTH", l.username, l.password}
+		}
+		if _, err := conn.command(auth...); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if l.db != 0 {
+		if _, err := conn.command("SELECT", strconv.Itoa(l.db)); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// command writes args as a RESP array of bulk strings and reads the reply
+func (c *redisConn) command(args ...string) (any, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(c.Conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(c.reader)
+}
+
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				// Not a redisError, so the connection with the rest of
+				// the array unread is dropped
+				return nil, fmt.Errorf("redis: array element: %v", err)
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply %q", line)
+	}
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type APIKeyRepository struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyRepository(db *gorm.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *model.APIKey) error {
+	return conn(ctx, r.db).Create(key).Error
+}
+
+// Update saves the settings and hash of a key; its usage is only changed by
+// Touch
+func (r *APIKeyRepository) Update(ctx context.Context, key *model.APIKey) error {
+	return conn(ctx, r.db).Model(key).Select("label", "key_hash", "prefix", "scopes", "expires_at", "folder_path").Updates(key).Error
+}
+
+func (r *APIKeyRepository) Delete(ctx context.Context, key *model.APIKey) error {
+	return conn(ctx, r.db).Delete(key).Error
+}
+
+func (r *APIKeyRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	return conn(ctx, r.db).Where("user_id = ?", userID).Delete(&model.APIKey{}).Error
+}
+
+// FindByID returns a key of any user, for the S3 gateway, which looks keys
+// up by ID
+func (r *APIKeyRepository) FindByID(ctx context.Context, id uint) (*model.APIKey, error) {
+	var key model.APIKey
+	if err := conn(ctx, r.db).First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// FindByIDAndUser returns a key only when userID owns it
+func (r *APIKeyRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.APIKey, error) {
+	var key model.APIKey
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&key, id).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) FindByHash(ctx context.Context, keyHash string) (*model.APIKey, error) {
+	var key model.APIKey
+	if err := conn(ctx, r.db).Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *APIKeyRepository) FindByUserID(ctx context.Context, userID uint) ([]model.APIKey, error) {
+	var keys []model.APIKey
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Order("created_at DESC").Find(&keys).Error; err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// Touch records that a key was used
+func (r *APIKeyRepository) Touch(ctx context.Context, id uint, ip string, at time.Time) error {
+	return conn(ctx, r.db).Model(&model.APIKey{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"last_used_at": at,
+		"last_used_ip": ip,
+	}).Error
+}
+
+func (r *APIKeyRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTx(ctx, r.db, fn)
+}
@@ -0,0 +1,47 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type AuditRepository struct {
+	db *gorm.DB
+	// replica serves listings that tolerate replication lag
+	replica *gorm.DB
+}
+
+func NewAuditRepository(db *gorm.DB) *AuditRepository {
+	return &AuditRepository{db: db, replica: readReplica(db)}
+}
+
+func (r *AuditRepository) Create(ctx context.Context, event *model.AuditEvent) error {
+	return conn(ctx, r.db).Create(event).Error
+}
+
+// FindAll lists events newest first, only those of userID when it is non-zero
+func (r *AuditRepository) FindAll(ctx context.Context, userID uint, limit, offset int) ([]model.AuditEvent, error) {
+	var events []model.AuditEvent
+	if err := r.auditQuery(ctx, userID).Order("id DESC").Limit(limit).Offset(offset).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (r *AuditRepository) Count(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	if err := r.auditQuery(ctx, userID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *AuditRepository) auditQuery(ctx context.Context, userID uint) *gorm.DB {
+	query := conn(ctx, r.replica).Model(&model.AuditEvent{})
+	if userID != 0 {
+		query = query.Where("user_id = ?", userID)
+	}
+	return query
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BlobRepository stores the content users stored, see model.Blob. Files are
+// counted in and out of their blob by FileRepository as their rows are
+// created, updated and deleted.
+type BlobRepository struct {
+	db *gorm.DB
+}
+
+func NewBlobRepository(db *gorm.DB) *BlobRepository {
+	return &BlobRepository{db: db}
+}
+
+// Acquire returns the blob of blob.UserID with blob.SHA256, creating it from
+// blob when there is none. It is locked until the transaction of ctx ends;
+// the file created with it in that transaction counts it in, or a blob
+// created with no file goes when the transaction is rolled back.
+func (r *BlobRepository) Acquire(ctx context.Context, blob *model.Blob) (*model.Blob, error) {
+	var acquired model.Blob
+	err := conn(ctx, r.db).Raw(`INSERT INTO blobs (user_id, sha256, file_path, compression, stored_size, ref_count, created_at)
+		VALUES (?, ?, ?, ?, ?, 0, NOW())
+		ON CONFLICT (user_id, sha256) DO UPDATE SET user_id = blobs.user_id
+		RETURNING *`, blob.UserID, blob.SHA256, blob.FilePath, blob.Compression, blob.StoredSize).Scan(&acquired).Error
+	if err != nil {
+		return nil, err
+	}
+	return &acquired, nil
+}
+
+// countBlob moves the file fileID from the blob its row points at to blobID,
+// either of which may be nil, for the update of the row that follows in the
+// same transaction. A blob no file points at any more is deleted.
+func countBlob(ctx context.Context, db *gorm.DB, fileID uint, blobID *uint) error {
+	var rows []model.File
+	err := conn(ctx, db).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id", "blob_id").Where("id = ?", fileID).Find(&rows).Error
+	if err != nil {
+		return err
+	}
+	var current *uint
+	if len(rows) > 0 {
+		current = rows[0].BlobID
+	}
+	if sameBlob(current, blobID) {
+		return nil
+	}
+	if blobID != nil {
+		if err := retainBlob(ctx, db, *blobID); err != nil {
+			return err
+		}
+	}
+	if current != nil {
+		return releaseBlob(ctx, db, *current)
+	}
+	return nil
+}
+
+// retainBlob counts one more file in the blob id
+func retainBlob(ctx context.Context, db *gorm.DB, id uint) error {
+	return conn(ctx, db).Model(&model.Blob{}).Where("id = ?", id).UpdateColumn("ref_count", gorm.Expr("ref_count + 1")).Error
+}
+
+// releaseBlob counts a file out of the blob id, deleting it with the last
+func releaseBlob(ctx context.Context, db *gorm.DB, id uint) error {
+	if err := conn(ctx, db).Model(&model.Blob{}).Where("id = ?", id).UpdateColumn("ref_count", gorm.Expr("ref_count - 1")).Error; err != nil {
+		return err
+	}
+	return conn(ctx, db).Where("id = ? AND ref_count <= 0", id).Delete(&model.Blob{}).Error
+}
+
+func sameBlob(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -1,14 +1,20 @@
 package repository
 
 import (
+	"context"
 	"fmt"
 	"storage-service/internal/config"
 	"storage-service/internal/model"
+	"strings"
 
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
 )
 
+// replicaResolver names the dbresolver configuration for DB_REPLICA_DSN
+const replicaResolver = "replica"
+
 func InitDB(cfg *config.Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
 		cfg.DBHost, cfg.DBPort, cfg.DBUsername, cfg.DBPassword, cfg.DBDatabase)
@@ -18,10 +24,130 @@ func InitDB(cfg *config.Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	if cfg.DBReplicaDSN != "" {
+		// Only queries that opt in through readReplica use the replica;
+		// everything else, including reads, stays on the primary
+		resolver := dbresolver.Register(dbresolver.Config{
+			Replicas: []gorm.Dialector{postgres.Open(cfg.DBReplicaDSN)},
+		}, replicaResolver)
+		if err := db.Use(resolver); err != nil {
+			return nil, fmt.Errorf("failed to connect to database replica: %w", err)
+		}
+	}
+
+	// Folders without a name predate folders as entities, see migrateFolders
+	folderEntities := db.Migrator().HasColumn(&model.Folder{}, "Name")
+	// API keys stored with their users predate API keys as rows, see migrateAPIKeys
+	userAPIKeys := db.Migrator().HasColumn("users", "api_key")
+	// Upload folders stored with their users predate folders of API keys, see migrateAPIKeyFolders
+	userKeyFolders := db.Migrator().HasColumn("users", "api_key_folder")
+
 	// Auto migrate models
-	if err := db.AutoMigrate(&model.User{}, &model.File{}); err != nil {
+	if err := db.AutoMigrate(&model.User{}, &model.File{}, &model.ServiceAccountGrant{}, &model.SharedState{},
+		&model.FileVariant{}, &model.Job{}, &model.ProxyCacheEntry{}, &model.FolderRule{}, &model.Share{},
+		&model.PasswordReset{}, &model.Session{}, &model.AuditEvent{}, &model.Folder{}, &model.FileVersion{}, &model.UploadLink{},
+		&model.ImageMetadata{}, &model.FileTag{}, &model.Setting{}, &model.APIKey{}, &model.Blob{}); err != nil {
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
+	}
+	// Files stored before updated_at existed were last changed when created
+	if err := db.Exec("UPDATE files SET updated_at = created_at WHERE updated_at IS NULL").Error; err != nil {
 		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
+	if !folderEntities {
+		if err := migrateFolders(db); err != nil {
+			return nil, fmt.Errorf("failed to migrate folders: %w", err)
+		}
+	}
+	if userAPIKeys {
+		if err := migrateAPIKeys(db); err != nil {
+			return nil, fmt.Errorf("failed to migrate API keys: %w", err)
+		}
+	}
+	if userKeyFolders {
+		if err := migrateAPIKeyFolders(db); err != nil {
+			return nil, fmt.Errorf("failed to migrate API key folders: %w", err)
+		}
+	}
 
 	return db, nil
 }
+
+// migrateFolders turns the folders that only existed through the paths of
+// files into folder rows, with their parents, and names the folders that
+// had metadata. It runs once, when folders get their names.
+func migrateFolders(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE folders SET name = regexp_replace(path, '^.*/', ''),
+			parent = CASE WHEN strpos(path, '/') > 0 THEN regexp_replace(path, '/[^/]*$', '') ELSE '' END`).Error; err != nil {
+			return err
+		}
+		return tx.Exec(`INSERT INTO folders (user_id, path, name, parent, starred, created_at, updated_at)
+			SELECT DISTINCT p.user_id, array_to_string(p.parts[1:n], '/'), p.parts[n], array_to_string(p.parts[1:n-1], '/'), false, NOW(), NOW()
+			FROM (SELECT DISTINCT user_id, string_to_array(folder_path, '/') AS parts FROM files
+				WHERE folder_path <> '' AND deleted_at IS NULL AND expires_at IS NULL) AS p,
+				generate_series(1, array_length(p.parts, 1)) AS n
+			ON CONFLICT (user_id, path) DO NOTHING`).Error
+	})
+}
+
+// migrateAPIKeys moves the key of every user into an API key with every
+// scope the user may hold, so existing clients keep working, and drops the
+// old column. It runs once, when API keys get their own table.
+func migrateAPIKeys(db *gorm.DB) error {
+	userScopes := strings.Join([]string{model.ScopeRead, model.ScopeUpload, model.ScopeDelete}, ",")
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`INSERT INTO api_keys (user_id, label, key_hash, prefix, scopes, created_at)
+			SELECT id, 'default', encode(sha256(convert_to(api_key, 'UTF8')), 'hex'), left(api_key, 8),
+				CASE WHEN is_admin THEN ? ELSE ? END, NOW()
+			FROM users WHERE api_key <> ''`, strings.Join(model.APIKeyScopes, ","), userScopes).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn("users", "api_key")
+	})
+}
+
+// migrateAPIKeyFolders gives the keys of every user the upload folder the
+// user had, so uploads keep landing where they did, and drops the old
+// column. It runs once, when keys get their own folders.
+func migrateAPIKeyFolders(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`UPDATE api_keys SET folder_path = users.api_key_folder
+			FROM users WHERE api_keys.user_id = users.id AND users.api_key_folder <> ''`).Error; err != nil {
+			return err
+		}
+		return tx.Migrator().DropColumn("users", "api_key_folder")
+	})
+}
+
+// readReplica returns a session whose read queries go to the replica when one
+// is configured and to the primary otherwise. Writes always use the primary.
+func readReplica(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Use(replicaResolver)).Session(&gorm.Session{})
+}
+
+// txKey is the context key of the transaction started by withTx
+type txKey struct{}
+
+// withTx runs fn in a transaction. Repository calls made with the context
+// passed to fn join the transaction, whichever repository they belong to,
+// so a multi-step mutation commits or rolls back as a whole. Calls nested in
+// a transaction join the outer one. fn must not have side effects that can't
+// be rolled back, like removing files or publishing events; do those after
+// withTx returns.
+func withTx(ctx context.Context, db *gorm.DB, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return fn(ctx)
+	}
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(context.WithValue(ctx, txKey{}, tx))
+	})
+}
+
+// conn returns the transaction ctx carries, if any, and db otherwise.
+// Reads inside a transaction use it too, so they see its writes.
+func conn(ctx context.Context, db *gorm.DB) *gorm.DB {
+	if tx, ok := ctx.Value(txKey{}).(*gorm.DB); ok {
+		return tx.WithContext(ctx)
+	}
+	return db.WithContext(ctx)
+}
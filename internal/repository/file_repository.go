@@ -1,155 +1,815 @@
 package repository
 
 import (
+	"context"
 	"storage-service/internal/model"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type FileRepository struct {
 	db *gorm.DB
+	// replica serves listings and counts that tolerate replication lag
+	replica *gorm.DB
 }
 
 func NewFileRepository(db *gorm.DB) *FileRepository {
-	return &FileRepository{db: db}
+	return &FileRepository{db: db, replica: readReplica(db)}
 }
 
-func (r *FileRepository) Create(file *model.File) error {
-	return r.db.Create(file).Error
+// Create inserts a file, counting it in its blob
+func (r *FileRepository) Create(ctx context.Context, file *model.File) error {
+	return withTx(ctx, r.db, func(ctx context.Context) error {
+		if file.BlobID != nil {
+			if err := retainBlob(ctx, r.db, *file.BlobID); err != nil {
+				return err
+			}
+		}
+		return conn(ctx, r.db).Create(file).Error
+	})
+}
+
+// CreateBatch inserts many files in a few statements, all or none of them
+func (r *FileRepository) CreateBatch(ctx context.Context, files []model.File) error {
+	return conn(ctx, r.db).CreateInBatches(files, 100).Error
+}
+
+func (r *FileRepository) FindByID(ctx context.Context, id uint) (*model.File, error) {
+	var file model.File
+	if err := conn(ctx, r.db).First(&file, id).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
 }
 
-func (r *FileRepository) FindByID(id uint) (*model.File, error) {
+// FindByIDAndUser returns a file only when userID owns it, so services can't
+// hand out another user's file by forgetting a check
+func (r *FileRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.File, error) {
 	var file model.File
-	if err := r.db.First(&file, id).Error; err != nil {
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&file, id).Error; err != nil {
 		return nil, err
 	}
 	return &file, nil
 }
 
-func (r *FileRepository) FindByUserID(userID uint, limit, offset int) ([]model.File, error) {
+func (r *FileRepository) FindByIDs(ctx context.Context, ids []uint) ([]model.File, error) {
+	var files []model.File
+	if len(ids) == 0 {
+		return files, nil
+	}
+	if err := conn(ctx, r.replica).Where("id IN ?", ids).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// FindByIDsAndUser returns the files among ids that userID owns
+func (r *FileRepository) FindByIDsAndUser(ctx context.Context, ids []uint, userID uint) ([]model.File, error) {
+	var files []model.File
+	if len(ids) == 0 {
+		return files, nil
+	}
+	if err := conn(ctx, r.replica).Where("id IN ? AND user_id = ?", ids, userID).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// FileFilter selects files for background jobs and admin searches. Empty
+// fields match everything.
+type FileFilter struct {
+	IDs       []uint
+	UserIDs   []uint
+	MimeTypes []string
+	// Missing limits the selection to legacy rows lacking a field, see missingFieldConditions
+	Missing string
+	// Folder limits the selection to one folder, and its subfolders when Recursive is set
+	Folder    *string
+	Recursive bool
+	// CreatedBefore limits the selection to files uploaded before this time
+	CreatedBefore time.Time
+	// PathPrefix limits the selection to files stored below a directory
+	PathPrefix string
+	// ScanStatuses limits the selection to files with one of these scan statuses
+	ScanStatuses []string
+	// Sources limits the selection to files uploaded one of these ways, see model.SourceWeb
+	Sources []string
+	// Name matches part of the original name, ignoring case and accents
+	Name string
+	// MimePrefix matches MIME types starting with it, e.g. "image/"
+	MimePrefix string
+	// NameWarnings limits the selection to files with a name warning, see model.NameWarning
+	NameWarnings bool
+	// MinSize and MaxSize bound the file size in bytes; zero is unbounded
+	MinSize int64
+	MaxSize int64
+	// CreatedAfter limits the selection to files uploaded after this time
+	CreatedAfter time.Time
+	// Unpinned skips pinned files, for lifecycle actions
+	Unpinned bool
+	// IncludeTrashed also selects files in the trash, for jobs that move
+	// stored files around
+	IncludeTrashed bool
+}
+
+// missingFieldConditions tell which rows predate a field computed at upload time
+var missingFieldConditions = map[string]string{
+	"detected_mime_type": "detected_mime_type IS NULL OR detected_mime_type = ''",
+	"width":              "(width IS NULL OR width = 0) AND mime_type IN ('image/jpeg', 'image/png', 'image/gif')",
+	"kind":               "kind IS NULL OR kind = ''",
+	"sha256":             "sha256 IS NULL OR sha256 = ''",
+	"name_sort_key":      "name_sort_key IS NULL OR name_sort_key = ''",
+	"color_space":        "(color_space IS NULL OR color_space = '') AND color_profile IN ('icc', 'srgb')",
+	"extension":          "extension IS NULL",
+	"search_key":         "search_key IS NULL OR search_key = ''",
+}
+
+func (r *FileRepository) filterQuery(ctx context.Context, filter FileFilter) *gorm.DB {
+	query := conn(ctx, r.db).Model(&model.File{})
+	if filter.IncludeTrashed {
+		query = query.Unscoped()
+	}
+	if len(filter.IDs) > 0 {
+		query = query.Where("id IN ?", filter.IDs)
+	}
+	if len(filter.UserIDs) > 0 {
+		query = query.Where("user_id IN ?", filter.UserIDs)
+	}
+	if len(filter.MimeTypes) > 0 {
+		query = query.Where("mime_type IN ?", filter.MimeTypes)
+	}
+	if filter.Missing != "" {
+		condition, ok := missingFieldConditions[filter.Missing]
+		if !ok {
+			// Unknown fields match nothing rather than everything
+			condition = "1 = 0"
+		}
+		query = query.Where(condition)
+	}
+	if filter.Folder != nil {
+		if !filter.Recursive {
+			query = query.Where("folder_path = ?", *filter.Folder)
+		} else if *filter.Folder != "" {
+			query = query.Where("folder_path = ? OR folder_path LIKE ?", *filter.Folder, escapeLike(*filter.Folder)+"/%")
+		}
+	}
+	if !filter.CreatedBefore.IsZero() {
+		query = query.Where("created_at < ?", filter.CreatedBefore)
+	}
+	if filter.PathPrefix != "" {
+		query = query.Where("file_path LIKE ?", escapeLike(filter.PathPrefix)+"%")
+	}
+	if len(filter.ScanStatuses) > 0 {
+		query = query.Where("scan_status IN ?", filter.ScanStatuses)
+	}
+	if len(filter.Sources) > 0 {
+		query = query.Where("upload_source IN ?", filter.Sources)
+	}
+	if filter.Name != "" {
+		query = matchName(query, filter.Name)
+	}
+	if filter.NameWarnings {
+		query = query.Where("name_warning <> ''")
+	}
+	if filter.MimePrefix != "" {
+		query = query.Where("mime_type LIKE ?", escapeLike(filter.MimePrefix)+"%")
+	}
+	if filter.MinSize > 0 {
+		query = query.Where("file_size >= ?", filter.MinSize)
+	}
+	if filter.MaxSize > 0 {
+		query = query.Where("file_size <= ?", filter.MaxSize)
+	}
+	if !filter.CreatedAfter.IsZero() {
+		query = query.Where("created_at > ?", filter.CreatedAfter)
+	}
+	if filter.Unpinned {
+		query = query.Where("pinned = ?", false)
+	}
+	return query
+}
+
+// matchName selects files whose name contains name, regardless of case and
+// accents. Files stored before search keys existed are matched on their name
+// until the search_key backfill ran.
+func matchName(query *gorm.DB, name string) *gorm.DB {
+	return query.Where("search_key LIKE ? OR ((search_key IS NULL OR search_key = '') AND original_name ILIKE ?)",
+		"%"+escapeLike(model.SearchKey(name))+"%", "%"+escapeLike(name)+"%")
+}
+
+// escapeLike escapes the wildcards of a LIKE pattern
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// FindPage returns a page of the files matching filter across all users
+func (r *FileRepository) FindPage(ctx context.Context, filter FileFilter, limit, offset int, sortBy, sortOrder string) ([]model.File, error) {
+	var files []model.File
+	if err := r.filterQuery(ctx, filter).Order(fileSortClause(sortBy, sortOrder)).Order("id ASC").Limit(limit).Offset(offset).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// UpdateFields sets the given columns of a file without touching the others,
+// including updated_at, which is for changes made by the user
+func (r *FileRepository) UpdateFields(ctx context.Context, id uint, fields map[string]interface{}) error {
+	return conn(ctx, r.db).Model(&model.File{}).Where("id = ?", id).UpdateColumns(fields).Error
+}
+
+// UpdateFilePath moves a file to newPath unless its path changed since it
+// was read, and reports whether it was moved. The moved file leaves its
+// blob, whose stored file stays where it was.
+func (r *FileRepository) UpdateFilePath(ctx context.Context, id uint, oldPath, newPath string) (bool, error) {
+	var moved bool
+	err := withTx(ctx, r.db, func(ctx context.Context) error {
+		var rows []model.File
+		if err := conn(ctx, r.db).Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "blob_id").Where("id = ? AND file_path = ?", id, oldPath).Find(&rows).Error; err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		if err := countBlob(ctx, r.db, id, nil); err != nil {
+			return err
+		}
+		moved = true
+		return conn(ctx, r.db).Unscoped().Model(&model.File{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"file_path": newPath, "blob_id": nil}).Error
+	})
+	return moved, err
+}
+
+// DetachBlob counts a file out of its blob, before its stored file moves
+// or is removed, so uploads of the same content no longer point at it
+func (r *FileRepository) DetachBlob(ctx context.Context, file *model.File) error {
+	err := withTx(ctx, r.db, func(ctx context.Context) error {
+		if err := countBlob(ctx, r.db, file.ID, nil); err != nil {
+			return err
+		}
+		return conn(ctx, r.db).Unscoped().Model(&model.File{}).Where("id = ?", file.ID).UpdateColumn("blob_id", nil).Error
+	})
+	if err == nil {
+		file.BlobID = nil
+	}
+	return err
+}
+
+// FindBatchAfter returns up to limit files matching filter with an ID above afterID, in ID order
+func (r *FileRepository) FindBatchAfter(ctx context.Context, filter FileFilter, afterID uint, limit int) ([]model.File, error) {
 	var files []model.File
-	if err := r.db.Where("user_id = ?", userID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&files).Error; err != nil {
+	if err := r.filterQuery(ctx, filter).Where("id > ?", afterID).Order("id ASC").Limit(limit).Find(&files).Error; err != nil {
 		return nil, err
 	}
 	return files, nil
 }
 
-func (r *FileRepository) FindByUserIDAndFolder(userID uint, folderPath string, limit, offset int, sortBy, sortOrder string) ([]model.File, error) {
+func (r *FileRepository) CountMatching(ctx context.Context, filter FileFilter) (int64, error) {
+	var count int64
+	if err := r.filterQuery(ctx, filter).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *FileRepository) FindByUserID(ctx context.Context, userID uint, limit, offset int) ([]model.File, error) {
+	var files []model.File
+	if err := conn(ctx, r.replica).Where("user_id = ? AND expires_at IS NULL", userID).Limit(limit).Offset(offset).Order("created_at DESC").Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (r *FileRepository) FindByUserIDAndFolder(ctx context.Context, userID uint, folderPath string, filter ListFilter, limit, offset int, sortBy, sortOrder string) ([]model.File, error) {
+	var files []model.File
+	query := filter.apply(conn(ctx, r.replica).Where("user_id = ? AND folder_path = ?", userID, folderPath))
+
+	if err := query.Order(fileSortClause(sortBy, sortOrder)).Limit(limit).Offset(offset).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// FindByUserIDAndFolderTree returns a page of files in a folder and all of its subfolders.
+// An empty folder path covers every file of the user.
+func (r *FileRepository) FindByUserIDAndFolderTree(ctx context.Context, userID uint, folderPath string, filter ListFilter, limit, offset int, sortBy, sortOrder string) ([]model.File, error) {
+	var files []model.File
+	query := filter.apply(r.folderTreeQuery(ctx, userID, folderPath))
+
+	// Secondary order by id keeps pagination stable across equal sort keys
+	if err := query.Order(fileSortClause(sortBy, sortOrder)).Order("id ASC").Limit(limit).Offset(offset).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (r *FileRepository) CountByUserIDAndFolderTree(ctx context.Context, userID uint, folderPath string, filter ListFilter) (int64, error) {
+	var count int64
+	if err := filter.apply(r.folderTreeQuery(ctx, userID, folderPath)).Model(&model.File{}).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// StreamByUserIDAndFolder calls fn for every listed file as rows are read
+// from the database cursor, without loading the listing into memory. Files
+// are in folderPath, or with recursive in its whole subtree. Returning an
+// error from fn stops the iteration and returns that error.
+func (r *FileRepository) StreamByUserIDAndFolder(ctx context.Context, userID uint, folderPath string, recursive bool, filter ListFilter, sortBy, sortOrder string, fn func(*model.File) error) error {
+	query := conn(ctx, r.replica).Where("user_id = ? AND folder_path = ?", userID, folderPath)
+	if recursive {
+		query = r.folderTreeQuery(ctx, userID, folderPath)
+	}
+	rows, err := filter.apply(query).Model(&model.File{}).Order(fileSortClause(sortBy, sortOrder)).Order("id ASC").Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var file model.File
+		if err := r.replica.ScanRows(rows, &file); err != nil {
+			return err
+		}
+		if err := fn(&file); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *FileRepository) folderTreeQuery(ctx context.Context, userID uint, folderPath string) *gorm.DB {
+	query := conn(ctx, r.replica).Where("user_id = ?", userID)
+	if folderPath != "" {
+		query = query.Where("folder_path = ? OR folder_path LIKE ?", folderPath, escapeLike(folderPath)+"/%")
+	}
+	return query
+}
+
+// StreamTreeByPath calls fn for every file of a user in folderPath and its
+// subfolders, ordered by folder and then name compared bytewise, so every
+// call returns a tree in the same order. Scratch files are left out.
+func (r *FileRepository) StreamTreeByPath(ctx context.Context, userID uint, folderPath string, fn func(*model.File) error) error {
+	rows, err := ListFilter{}.apply(r.folderTreeQuery(ctx, userID, folderPath)).Model(&model.File{}).
+		Order(`folder_path COLLATE "C", original_name COLLATE "C", id`).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var file model.File
+		if err := r.replica.ScanRows(rows, &file); err != nil {
+			return err
+		}
+		if err := fn(&file); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// filePathExpr is the path of a file: its folder and name
+const filePathExpr = "(folder_path || '/' || original_name)"
+
+// FindTreeByPathAfter returns up to limit files of a user in folderPath and
+// its subfolders whose path below folderPath starts with prefix and sorts
+// after after, ordered by that path compared bytewise and newest first
+// among files of the same path. Scratch files are left out.
+func (r *FileRepository) FindTreeByPathAfter(ctx context.Context, userID uint, folderPath, prefix, after string, limit int) ([]model.File, error) {
 	var files []model.File
-	query := r.db.Where("user_id = ? AND folder_path = ?", userID, folderPath)
-	
-	// Validate and apply sort
+	base := folderPath + "/"
+	err := ListFilter{}.apply(r.folderTreeQuery(ctx, userID, folderPath)).
+		Where(filePathExpr+` COLLATE "C" > ?`, base+after).
+		Where(filePathExpr+" LIKE ?", escapeLike(base+prefix)+"%").
+		Order(filePathExpr + ` COLLATE "C", id DESC`).Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// ListFilter narrows file listings; zero fields match every file
+type ListFilter struct {
+	MimePrefix string // e.g. "image/"
+	Kind       string // see model.KindImage
+	SHA256     string // content checksum, to find copies of a file
+	Source     string // how files were uploaded, see model.SourceWeb
+	Tag        string // files with this tag, see model.FileTag
+	Name       string // part of the name, ignoring case and accents
+	Scratch    bool   // list scratch files, which are left out otherwise
+
+	// Images whose EXIF data names the camera model, or that were taken in
+	// a time range, see model.ImageMetadata
+	Camera      string
+	TakenAfter  time.Time
+	TakenBefore time.Time
+}
+
+func (f ListFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.MimePrefix != "" {
+		query = query.Where("mime_type LIKE ?", escapeLike(f.MimePrefix)+"%")
+	}
+	if f.Kind != "" {
+		query = query.Where("kind = ?", f.Kind)
+	}
+	if f.SHA256 != "" {
+		query = query.Where("sha256 = ?", f.SHA256)
+	}
+	if f.Source != "" {
+		query = query.Where("upload_source = ?", f.Source)
+	}
+	if f.Name != "" {
+		query = matchName(query, f.Name)
+	}
+	if f.Tag != "" {
+		tagged := query.Session(&gorm.Session{NewDB: true}).Model(&model.FileTag{}).Select("file_id").Where("tag = ?", f.Tag)
+		query = query.Where("id IN (?)", tagged)
+	}
+	if f.Scratch {
+		query = query.Where("expires_at IS NOT NULL")
+	} else {
+		query = query.Where("expires_at IS NULL")
+	}
+	if f.Camera != "" || !f.TakenAfter.IsZero() || !f.TakenBefore.IsZero() {
+		exif := query.Session(&gorm.Session{NewDB: true}).Model(&model.ImageMetadata{}).Select("file_id")
+		if f.Camera != "" {
+			exif = exif.Where("camera_model ILIKE ?", "%"+escapeLike(f.Camera)+"%")
+		}
+		if !f.TakenAfter.IsZero() {
+			exif = exif.Where("taken_at >= ?", f.TakenAfter)
+		}
+		if !f.TakenBefore.IsZero() {
+			exif = exif.Where("taken_at < ?", f.TakenBefore)
+		}
+		query = query.Where("id IN (?)", exif)
+	}
+	return query
+}
+
+// maxSortKeys bounds the keys a listing can be sorted by
+const maxSortKeys = 4
+
+// fileSortClause validates the requested sort fields and orders against an
+// allowlist. sortBy is a comma separated list of keys, e.g. "folder,name";
+// sortOrder is one order for every key or a comma separated order per key.
+// Names are compared by their natural sort key in bytewise collation, so
+// "file2" sorts before "file10" regardless of the database locale.
+func fileSortClause(sortBy, sortOrder string) string {
 	allowedSortFields := map[string]string{
-		"name":       "original_name",
+		"name":       `name_sort_key COLLATE "C"`,
 		"size":       "file_size",
+		"folder":     "folder_path",
+		"kind":       "kind",
 		"created_at": "created_at",
 		"updated_at": "updated_at",
 	}
-	sortField, ok := allowedSortFields[sortBy]
-	if !ok {
-		sortField = "created_at"
+	orders := strings.Split(sortOrder, ",")
+	sortDirection := func(i int) string {
+		order := strings.TrimSpace(orders[min(i, len(orders)-1)])
+		if order != "asc" && order != "desc" {
+			order = "desc"
+		}
+		return order
+	}
+
+	var clauses []string
+	seen := map[string]bool{}
+	for i, key := range strings.Split(sortBy, ",") {
+		key = strings.TrimSpace(key)
+		sortField, ok := allowedSortFields[key]
+		if !ok || seen[key] {
+			continue
+		}
+		seen[key] = true
+		clauses = append(clauses, sortField+" "+sortDirection(i))
+		if len(clauses) == maxSortKeys {
+			break
+		}
 	}
-	if sortOrder != "asc" && sortOrder != "desc" {
-		sortOrder = "desc"
+	if len(clauses) == 0 {
+		return "created_at " + sortDirection(0)
 	}
-	
-	if err := query.Order(sortField + " " + sortOrder).Limit(limit).Offset(offset).Find(&files).Error; err != nil {
+	return strings.Join(clauses, ", ")
+}
+
+func (r *FileRepository) CountByUserIDAndFolder(ctx context.Context, userID uint, folderPath string, filter ListFilter) (int64, error) {
+	var count int64
+	query := conn(ctx, r.replica).Model(&model.File{}).Where("user_id = ? AND folder_path = ?", userID, folderPath)
+	if err := filter.apply(query).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FindByName returns the newest file of a user with the given name in a folder
+func (r *FileRepository) FindByName(ctx context.Context, userID uint, folderPath, originalName string) (*model.File, error) {
+	var file model.File
+	if err := conn(ctx, r.db).Where("user_id = ? AND folder_path = ? AND original_name = ? AND expires_at IS NULL", userID, folderPath, originalName).
+		Order("id DESC").First(&file).Error; err != nil {
 		return nil, err
 	}
-	return files, nil
+	return &file, nil
+}
+
+// CountOthersByFilePath counts the files other than excludeID stored at
+// path, including files in the trash
+func (r *FileRepository) CountOthersByFilePath(ctx context.Context, path string, excludeID uint) (int64, error) {
+	var count int64
+	if err := conn(ctx, r.db).Unscoped().Model(&model.File{}).Where("file_path = ? AND id <> ?", path, excludeID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
 }
 
-func (r *FileRepository) CountByUserIDAndFolder(userID uint, folderPath string) (int64, error) {
+// FindBySHA256 returns up to limit other files with the given content
+// checksum and compression, oldest first
+func (r *FileRepository) FindBySHA256(ctx context.Context, sha256, compression string, excludeID uint, limit int) ([]model.File, error) {
+	var files []model.File
+	err := conn(ctx, r.db).Where("sha256 = ? AND compression = ? AND id <> ?", sha256, compression, excludeID).
+		Order("id ASC").Limit(limit).Find(&files).Error
+	return files, err
+}
+
+// FindDownloadableBySHA256 returns the oldest file with the given content
+// checksum that isn't infected or quarantined
+func (r *FileRepository) FindDownloadableBySHA256(ctx context.Context, sha256 string) (*model.File, error) {
+	var file model.File
+	err := conn(ctx, r.db).Where("sha256 = ? AND scan_status NOT IN ?", sha256, []string{model.ScanInfected, model.ScanQuarantined}).
+		Order("id ASC").First(&file).Error
+	if err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// FindStoredPaths returns which of paths are stored paths of files,
+// including files in the trash
+func (r *FileRepository) FindStoredPaths(ctx context.Context, paths []string) ([]string, error) {
+	var stored []string
+	if err := conn(ctx, r.db).Unscoped().Model(&model.File{}).Where("file_path IN ?", paths).Pluck("file_path", &stored).Error; err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// CountByUserID counts the files of a user, leaving out scratch files
+func (r *FileRepository) CountByUserID(ctx context.Context, userID uint) (int64, error) {
 	var count int64
-	if err := r.db.Model(&model.File{}).Where("user_id = ? AND folder_path = ?", userID, folderPath).Count(&count).Error; err != nil {
+	if err := conn(ctx, r.db).Model(&model.File{}).Where("user_id = ? AND expires_at IS NULL", userID).Count(&count).Error; err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (r *FileRepository) CountByUserID(userID uint) (int64, error) {
+// GetTotalSizeByUserID sums the files of a user counted against their
+// storage limit, which scratch files aren't
+func (r *FileRepository) GetTotalSizeByUserID(ctx context.Context, userID uint) (int64, error) {
+	var total int64
+	if err := conn(ctx, r.db).Model(&model.File{}).Where("user_id = ? AND expires_at IS NULL", userID).Select("COALESCE(SUM(file_size), 0)").Scan(&total).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// GetScratchSizeByUserID sums the scratch files of a user
+func (r *FileRepository) GetScratchSizeByUserID(ctx context.Context, userID uint) (int64, error) {
+	var total int64
+	if err := conn(ctx, r.db).Model(&model.File{}).Where("user_id = ? AND expires_at IS NOT NULL", userID).Select("COALESCE(SUM(file_size), 0)").Scan(&total).Error; err != nil {
+		return 0, err
+	}
+	return total, nil
+}
+
+// FindExpiredScratch returns unpinned scratch files whose expiry passed
+// before now, in ID order after afterID
+func (r *FileRepository) FindExpiredScratch(ctx context.Context, now time.Time, afterID uint, limit int) ([]model.File, error) {
+	var files []model.File
+	if err := conn(ctx, r.db).Where("expires_at < ? AND pinned = ? AND id > ?", now, false, afterID).Order("id ASC").Limit(limit).Find(&files).Error; err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (r *FileRepository) Count(ctx context.Context) (int64, error) {
 	var count int64
-	if err := r.db.Model(&model.File{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+	if err := conn(ctx, r.replica).Model(&model.File{}).Count(&count).Error; err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (r *FileRepository) GetTotalSizeByUserID(userID uint) (int64, error) {
+func (r *FileRepository) GetTotalSize(ctx context.Context) (int64, error) {
 	var total int64
-	if err := r.db.Model(&model.File{}).Where("user_id = ?", userID).Select("COALESCE(SUM(file_size), 0)").Scan(&total).Error; err != nil {
+	if err := conn(ctx, r.replica).Model(&model.File{}).Select("COALESCE(SUM(file_size), 0)").Scan(&total).Error; err != nil {
 		return 0, err
 	}
 	return total, nil
 }
 
-func (r *FileRepository) GetFoldersByUserID(userID uint) ([]string, error) {
+func (r *FileRepository) GetFoldersByUserID(ctx context.Context, userID uint) ([]string, error) {
 	var folders []string
-	if err := r.db.Model(&model.File{}).Where("user_id = ?", userID).
+	if err := conn(ctx, r.replica).Model(&model.File{}).Where("user_id = ? AND expires_at IS NULL", userID).
 		Distinct("folder_path").Pluck("folder_path", &folders).Error; err != nil {
 		return nil, err
 	}
 	return folders, nil
 }
 
-func (r *FileRepository) Delete(file *model.File) error {
-	return r.db.Delete(file).Error
+// CountByFolder returns how many files each folder of a user holds directly,
+// leaving out scratch files like listings
+func (r *FileRepository) CountByFolder(ctx context.Context, userID uint) (map[string]int64, error) {
+	var rows []struct {
+		FolderPath string
+		Count      int64
+	}
+	if err := conn(ctx, r.replica).Model(&model.File{}).Select("folder_path, COUNT(*) AS count").
+		Where("user_id = ? AND expires_at IS NULL", userID).Group("folder_path").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.FolderPath] = row.Count
+	}
+	return counts, nil
 }
 
-func (r *FileRepository) Update(file *model.File) error {
-	return r.db.Save(file).Error
+// UserGrowth is what a user added in a report window
+type UserGrowth struct {
+	UserID   uint   `json:"user_id"`
+	Username string `json:"username"`
+	Files    int64  `json:"files"`
+	Bytes    int64  `json:"bytes"`
 }
 
-func (r *FileRepository) FindByUserIDAndFolderPrefix(userID uint, folderPrefix string) ([]model.File, error) {
+// DayTotal is what was uploaded on one day (UTC)
+type DayTotal struct {
+	Day   time.Time
+	Files int64
+	Bytes int64
+}
+
+// FindLargest returns the biggest files of all users, the trash left out
+func (r *FileRepository) FindLargest(ctx context.Context, limit int) ([]model.File, error) {
 	var files []model.File
-	query := r.db.Where("user_id = ?", userID)
-	if folderPrefix != "" {
-		query = query.Where("folder_path = ? OR folder_path LIKE ?", folderPrefix, folderPrefix+"/%")
-	} else {
-		query = query.Where("folder_path = ?", "")
-	}
-	if err := query.Find(&files).Error; err != nil {
+	if err := conn(ctx, r.replica).Order("file_size DESC, id").Limit(limit).Find(&files).Error; err != nil {
 		return nil, err
 	}
 	return files, nil
 }
 
-func (r *FileRepository) UpdateFolderPath(userID uint, oldPath, newPath string) error {
-	// Update exact matches
-	if err := r.db.Model(&model.File{}).
-		Where("user_id = ? AND folder_path = ?", userID, oldPath).
-		Update("folder_path", newPath).Error; err != nil {
-		return err
+// SumByUserSince returns the users whose files created since then add up
+// to the most bytes, biggest first. Files deleted since don't count, so it
+// is the net growth of each user.
+func (r *FileRepository) SumByUserSince(ctx context.Context, since time.Time, limit int) ([]UserGrowth, error) {
+	var rows []UserGrowth
+	if err := conn(ctx, r.replica).Model(&model.File{}).
+		Select("files.user_id, users.username, COUNT(*) AS files, COALESCE(SUM(files.file_size), 0) AS bytes").
+		Joins("JOIN users ON users.id = files.user_id").
+		Where("files.created_at >= ?", since).
+		Group("files.user_id, users.username").Order("bytes DESC, files.user_id").Limit(limit).
+		Scan(&rows).Error; err != nil {
+		return nil, err
 	}
-	
-	// Update children paths (replace prefix)
-	if oldPath != "" {
-		oldPrefix := oldPath + "/"
-		newPrefix := newPath + "/"
-		// Use REPLACE function for PostgreSQL compatibility
-		return r.db.Exec(
-			"UPDATE files SET folder_path = REPLACE(folder_path, ?, ?) WHERE user_id = ? AND folder_path LIKE ?",
-			oldPrefix, newPrefix, userID, oldPrefix+"%",
-		).Error
+	return rows, nil
+}
+
+// SumByDaySince returns the files uploaded on each day since then, oldest
+// first. Files deleted since count too, as they were ingested all the same.
+// Days without uploads are left out.
+func (r *FileRepository) SumByDaySince(ctx context.Context, since time.Time) ([]DayTotal, error) {
+	var rows []DayTotal
+	if err := conn(ctx, r.replica).Unscoped().Model(&model.File{}).
+		Select("date_trunc('day', created_at AT TIME ZONE 'UTC') AS day, COUNT(*) AS files, COALESCE(SUM(file_size), 0) AS bytes").
+		Where("created_at >= ?", since).Group("day").Order("day").
+		Scan(&rows).Error; err != nil {
+		return nil, err
 	}
-	return nil
+	return rows, nil
+}
+
+// Delete removes the record of a file for good, also from the trash
+func (r *FileRepository) Delete(ctx context.Context, file *model.File) error {
+	return withTx(ctx, r.db, func(ctx context.Context) error {
+		if err := countBlob(ctx, r.db, file.ID, nil); err != nil {
+			return err
+		}
+		return conn(ctx, r.db).Unscoped().Delete(file).Error
+	})
 }
 
-func (r *FileRepository) DeleteByFolderPath(userID uint, folderPath string) ([]model.File, error) {
+// Trash moves a file to the trash by setting its deleted_at
+func (r *FileRepository) Trash(ctx context.Context, file *model.File) error {
+	return conn(ctx, r.db).Delete(file).Error
+}
+
+// Restore takes a file out of the trash
+func (r *FileRepository) Restore(ctx context.Context, id uint) error {
+	return conn(ctx, r.db).Unscoped().Model(&model.File{}).Where("id = ?", id).UpdateColumn("deleted_at", nil).Error
+}
+
+// FindTrashedByIDAndUser returns a file of userID in the trash
+func (r *FileRepository) FindTrashedByIDAndUser(ctx context.Context, id, userID uint) (*model.File, error) {
+	var file model.File
+	if err := conn(ctx, r.db).Unscoped().Where("deleted_at IS NOT NULL AND user_id = ?", userID).First(&file, id).Error; err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// FindTrashed returns a page of a user's trash, most recently deleted first
+func (r *FileRepository) FindTrashed(ctx context.Context, userID uint, limit, offset int) ([]model.File, error) {
 	var files []model.File
-	query := r.db.Where("user_id = ?", userID)
-	if folderPath != "" {
-		query = query.Where("folder_path = ? OR folder_path LIKE ?", folderPath, folderPath+"/%")
+	if err := conn(ctx, r.replica).Unscoped().Where("user_id = ? AND deleted_at IS NOT NULL", userID).
+		Order("deleted_at DESC, id DESC").Limit(limit).Offset(offset).Find(&files).Error; err != nil {
+		return nil, err
 	}
-	if err := query.Find(&files).Error; err != nil {
+	return files, nil
+}
+
+func (r *FileRepository) CountTrashed(ctx context.Context, userID uint) (int64, error) {
+	var count int64
+	if err := conn(ctx, r.replica).Unscoped().Model(&model.File{}).Where("user_id = ? AND deleted_at IS NOT NULL", userID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FindPurgeable returns up to limit files trashed before cutoff, oldest
+// first. Pinned files are never purged automatically.
+func (r *FileRepository) FindPurgeable(ctx context.Context, cutoff time.Time, limit int) ([]model.File, error) {
+	var files []model.File
+	if err := conn(ctx, r.db).Unscoped().Where("deleted_at < ? AND pinned = ?", cutoff, false).
+		Order("deleted_at ASC, id ASC").Limit(limit).Find(&files).Error; err != nil {
 		return nil, err
 	}
-	
-	if len(files) > 0 {
-		if err := query.Delete(&model.File{}).Error; err != nil {
-			return nil, err
+	return files, nil
+}
+
+// Update saves a file, moving it to the blob it now points at
+func (r *FileRepository) Update(ctx context.Context, file *model.File) error {
+	return withTx(ctx, r.db, func(ctx context.Context) error {
+		if err := countBlob(ctx, r.db, file.ID, file.BlobID); err != nil {
+			return err
 		}
+		return conn(ctx, r.db).Save(file).Error
+	})
+}
+
+func (r *FileRepository) FindByUserIDAndFolderPrefix(ctx context.Context, userID uint, folderPrefix string) ([]model.File, error) {
+	var files []model.File
+	query := conn(ctx, r.db).Where("user_id = ?", userID)
+	if folderPrefix != "" {
+		query = query.Where("folder_path = ? OR folder_path LIKE ?", folderPrefix, escapeLike(folderPrefix)+"/%")
+	} else {
+		query = query.Where("folder_path = ?", "")
+	}
+	if err := query.Find(&files).Error; err != nil {
+		return nil, err
 	}
-	
 	return files, nil
 }
+
+// GetFolderStats returns the number of files and their total size in a folder and its subfolders
+func (r *FileRepository) GetFolderStats(ctx context.Context, userID uint, folderPath string) (int64, int64, error) {
+	var stats struct {
+		Count int64
+		Total int64
+	}
+	query := conn(ctx, r.db).Model(&model.File{}).Where("user_id = ? AND expires_at IS NULL", userID)
+	if folderPath != "" {
+		query = query.Where("folder_path = ? OR folder_path LIKE ?", folderPath, escapeLike(folderPath)+"/%")
+	}
+	if err := query.Select("COUNT(*) AS count, COALESCE(SUM(file_size), 0) AS total").Scan(&stats).Error; err != nil {
+		return 0, 0, err
+	}
+	return stats.Count, stats.Total, nil
+}
+
+// WithTx runs fn in a database transaction that repository calls made with
+// the context passed to fn join
+func (r *FileRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTx(ctx, r.db, fn)
+}
+
+func (r *FileRepository) UpdateFolderPath(ctx context.Context, userID uint, oldPath, newPath string) error {
+	return withTx(ctx, r.db, func(ctx context.Context) error {
+		// Update exact matches, also in the trash like the children below
+		if err := conn(ctx, r.db).Unscoped().Model(&model.File{}).
+			Where("user_id = ? AND folder_path = ?", userID, oldPath).
+			Update("folder_path", newPath).Error; err != nil {
+			return err
+		}
+
+		// Update children paths: only the leading oldPath is replaced, like
+		// FolderRepository.MovePath does for folder rows. SUBSTRING counts
+		// characters, not bytes.
+		if oldPath != "" {
+			return conn(ctx, r.db).Exec(
+				"UPDATE files SET folder_path = ? || SUBSTRING(folder_path FROM ?), updated_at = NOW() WHERE user_id = ? AND folder_path LIKE ?",
+				newPath, utf8.RuneCountInString(oldPath)+1, userID, escapeLike(oldPath)+"/%",
+			).Error
+		}
+		return nil
+	})
+}
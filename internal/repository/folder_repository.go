@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type FolderRepository struct {
+	db *gorm.DB
+}
+
+func NewFolderRepository(db *gorm.DB) *FolderRepository {
+	return &FolderRepository{db: db}
+}
+
+// Save creates or replaces the metadata of a folder
+func (r *FolderRepository) Save(ctx context.Context, folder *model.Folder) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "path"}},
+		DoUpdates: clause.AssignmentColumns([]string{"starred", "color", "description", "write_once", "updated_at"}),
+	}).Create(folder).Error
+}
+
+// CreateTree creates folders, skipping those that exist
+func (r *FolderRepository) CreateTree(ctx context.Context, folders []model.Folder) error {
+	if len(folders) == 0 {
+		return nil
+	}
+	return conn(ctx, r.db).Clauses(clause.OnConflict{DoNothing: true}).Create(&folders).Error
+}
+
+// FindByIDAndUser returns a folder only when userID owns it
+func (r *FolderRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.Folder, error) {
+	var folder model.Folder
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&folder, id).Error; err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func (r *FolderRepository) FindByPath(ctx context.Context, userID uint, path string) (*model.Folder, error) {
+	var folder model.Folder
+	if err := conn(ctx, r.db).Where("user_id = ? AND path = ?", userID, path).First(&folder).Error; err != nil {
+		return nil, err
+	}
+	return &folder, nil
+}
+
+func (r *FolderRepository) FindByUserID(ctx context.Context, userID uint) ([]model.Folder, error) {
+	var folders []model.Folder
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Order("path ASC").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+// FindWriteOncePaths returns the paths of the write-once folders of userID
+func (r *FolderRepository) FindWriteOncePaths(ctx context.Context, userID uint) ([]string, error) {
+	var paths []string
+	if err := conn(ctx, r.db).Model(&model.Folder{}).Where("user_id = ? AND write_once = ?", userID, true).Pluck("path", &paths).Error; err != nil {
+		return nil, err
+	}
+	return paths, nil
+}
+
+// FindChildren returns the folders directly inside parent, by name
+func (r *FolderRepository) FindChildren(ctx context.Context, userID uint, parent string) ([]model.Folder, error) {
+	var folders []model.Folder
+	if err := conn(ctx, r.db).Where("user_id = ? AND parent = ?", userID, parent).Order("name ASC").Find(&folders).Error; err != nil {
+		return nil, err
+	}
+	return folders, nil
+}
+
+func (r *FolderRepository) Delete(ctx context.Context, folder *model.Folder) error {
+	return conn(ctx, r.db).Delete(folder).Error
+}
+
+// MovePath moves a folder and its subfolders to newPath. Where both trees
+// have a folder, the moved one wins.
+func (r *FolderRepository) MovePath(ctx context.Context, userID uint, oldPath, newPath string) error {
+	// SUBSTRING counts characters, not bytes
+	oldLen, newLen := utf8.RuneCountInString(oldPath), utf8.RuneCountInString(newPath)
+	newParent, newName := model.SplitFolderPath(newPath)
+	return conn(ctx, r.db).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(
+			"DELETE FROM folders WHERE user_id = ? AND (path = ? OR path LIKE ?) AND ? || SUBSTRING(path FROM ?) IN (SELECT path FROM folders WHERE user_id = ?)",
+			userID, newPath, escapeLike(newPath)+"/%", oldPath, newLen+1, userID,
+		).Error; err != nil {
+			return err
+		}
+		return tx.Exec(
+			"UPDATE folders SET path = ? || SUBSTRING(path FROM ?), "+
+				"parent = CASE WHEN path = ? THEN ? ELSE ? || SUBSTRING(parent FROM ?) END, "+
+				"name = CASE WHEN path = ? THEN ? ELSE name END, updated_at = NOW() "+
+				"WHERE user_id = ? AND (path = ? OR path LIKE ?)",
+			newPath, oldLen+1, oldPath, newParent, newPath, oldLen+1, oldPath, newName, userID, oldPath, escapeLike(oldPath)+"/%",
+		).Error
+	})
+}
+
+// DeleteTree removes a folder and its subfolders
+func (r *FolderRepository) DeleteTree(ctx context.Context, userID uint, path string) error {
+	return conn(ctx, r.db).Where("user_id = ? AND (path = ? OR path LIKE ?)", userID, path, escapeLike(path)+"/%").
+		Delete(&model.Folder{}).Error
+}
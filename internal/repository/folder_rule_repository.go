@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type FolderRuleRepository struct {
+	db *gorm.DB
+}
+
+func NewFolderRuleRepository(db *gorm.DB) *FolderRuleRepository {
+	return &FolderRuleRepository{db: db}
+}
+
+func (r *FolderRuleRepository) Create(ctx context.Context, rule *model.FolderRule) error {
+	return conn(ctx, r.db).Create(rule).Error
+}
+
+func (r *FolderRuleRepository) Update(ctx context.Context, rule *model.FolderRule) error {
+	return conn(ctx, r.db).Save(rule).Error
+}
+
+func (r *FolderRuleRepository) Delete(ctx context.Context, rule *model.FolderRule) error {
+	return conn(ctx, r.db).Delete(rule).Error
+}
+
+// FindByIDAndUser returns a rule only when userID owns it
+func (r *FolderRuleRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.FolderRule, error) {
+	var rule model.FolderRule
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&rule, id).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func (r *FolderRuleRepository) FindByIDs(ctx context.Context, ids []uint) ([]model.FolderRule, error) {
+	var rules []model.FolderRule
+	if len(ids) == 0 {
+		return rules, nil
+	}
+	if err := conn(ctx, r.db).Where("id IN ?", ids).Order("id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// FindByUserID returns the rules of a user, optionally only those of one folder
+func (r *FolderRuleRepository) FindByUserID(ctx context.Context, userID uint, folderPath *string) ([]model.FolderRule, error) {
+	var rules []model.FolderRule
+	query := conn(ctx, r.db).Where("user_id = ?", userID)
+	if folderPath != nil {
+		query = query.Where("folder_path = ?", *folderPath)
+	}
+	if err := query.Order("folder_path ASC, id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// FindMatching returns the enabled rules of a user that apply to a folder:
+// rules on the folder itself and recursive rules on its parents. Parents are
+// compared as a prefix, not as a pattern, since folder names may hold % and _.
+func (r *FolderRuleRepository) FindMatching(ctx context.Context, userID uint, folderPath string) ([]model.FolderRule, error) {
+	var rules []model.FolderRule
+	if err := conn(ctx, r.db).Where("user_id = ? AND enabled = ?", userID, true).
+		Where("folder_path = ? OR (recursive = ? AND (folder_path = '' OR LEFT(?, LENGTH(folder_path) + 1) = folder_path || '/'))", folderPath, true, folderPath).
+		Order("id ASC").
+		Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// FindExpiring returns the enabled rules that expire files
+func (r *FolderRuleRepository) FindExpiring(ctx context.Context) ([]model.FolderRule, error) {
+	var rules []model.FolderRule
+	if err := conn(ctx, r.db).Where("enabled = ? AND expire_after <> ''", true).Order("id ASC").Find(&rules).Error; err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
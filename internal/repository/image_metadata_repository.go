@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ImageMetadataRepository struct {
+	db *gorm.DB
+}
+
+func NewImageMetadataRepository(db *gorm.DB) *ImageMetadataRepository {
+	return &ImageMetadataRepository{db: db}
+}
+
+// Save creates the metadata of a file or replaces the existing one
+func (r *ImageMetadataRepository) Save(ctx context.Context, meta *model.ImageMetadata) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"camera_make", "camera_model", "lens_model", "taken_at", "width", "height", "orientation", "has_gps"}),
+	}).Create(meta).Error
+}
+
+// FindByFileIDs returns the metadata of several files at once
+func (r *ImageMetadataRepository) FindByFileIDs(ctx context.Context, fileIDs []uint) ([]model.ImageMetadata, error) {
+	var metas []model.ImageMetadata
+	if len(fileIDs) == 0 {
+		return metas, nil
+	}
+	if err := conn(ctx, r.db).Where("file_id IN ?", fileIDs).Find(&metas).Error; err != nil {
+		return nil, err
+	}
+	return metas, nil
+}
+
+func (r *ImageMetadataRepository) DeleteByFileID(ctx context.Context, fileID uint) error {
+	return conn(ctx, r.db).Where("file_id = ?", fileID).Delete(&model.ImageMetadata{}).Error
+}
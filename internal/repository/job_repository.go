@@ -0,0 +1,142 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type JobRepository struct {
+	db *gorm.DB
+}
+
+func NewJobRepository(db *gorm.DB) *JobRepository {
+	return &JobRepository{db: db}
+}
+
+func (r *JobRepository) Create(ctx context.Context, job *model.Job) error {
+	return conn(ctx, r.db).Create(job).Error
+}
+
+func (r *JobRepository) FindByID(ctx context.Context, id uint) (*model.Job, error) {
+	var job model.Job
+	if err := conn(ctx, r.db).First(&job, id).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// JobFilter narrows job listings; zero fields match every job
+type JobFilter struct {
+	CreatedBy uint
+	Type      string
+	Status    string
+}
+
+func (f JobFilter) apply(query *gorm.DB) *gorm.DB {
+	if f.CreatedBy != 0 {
+		query = query.Where("created_by = ?", f.CreatedBy)
+	}
+	if f.Type != "" {
+		query = query.Where("type = ?", f.Type)
+	}
+	if f.Status != "" {
+		query = query.Where("status = ?", f.Status)
+	}
+	return query
+}
+
+func (r *JobRepository) FindAll(ctx context.Context, filter JobFilter, limit, offset int) ([]model.Job, error) {
+	var jobs []model.Job
+	if err := filter.apply(conn(ctx, r.db)).Order("id DESC").Limit(limit).Offset(offset).Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+func (r *JobRepository) Count(ctx context.Context, filter JobFilter) (int64, error) {
+	var count int64
+	if err := filter.apply(conn(ctx, r.db).Model(&model.Job{})).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// CountByStatus counts the jobs in any of the given statuses
+func (r *JobRepository) CountByStatus(ctx context.Context, statuses ...string) (int64, error) {
+	var count int64
+	if err := conn(ctx, r.db).Model(&model.Job{}).Where("status IN ?", statuses).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FindRunnable returns pending jobs and running jobs interrupted by a restart, oldest first
+func (r *JobRepository) FindRunnable(ctx context.Context) ([]model.Job, error) {
+	var jobs []model.Job
+	if err := conn(ctx, r.db).Where("status IN ?", []string{model.JobPending, model.JobRunning}).Order("id ASC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Start marks a job as running, keeping the original start time of a resumed job
+func (r *JobRepository) Start(ctx context.Context, job *model.Job) error {
+	now := time.Now()
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	job.Status = model.JobRunning
+	return conn(ctx, r.db).Model(job).Where("status IN ?", []string{model.JobPending, model.JobRunning}).
+		Updates(map[string]interface{}{"status": job.Status, "started_at": job.StartedAt}).Error
+}
+
+// SaveProgress stores the cursor and counters of a running job. It returns
+// false when the job is no longer running, e.g. because it was cancelled.
+func (r *JobRepository) SaveProgress(ctx context.Context, job *model.Job) (bool, error) {
+	result := conn(ctx, r.db).Model(job).Where("status = ?", model.JobRunning).Updates(map[string]interface{}{
+		"cursor":     job.Cursor,
+		"checkpoint": job.Checkpoint,
+		"total":      job.Total,
+		"processed":  job.Processed,
+		"failed":     job.Failed,
+	})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Finish moves a running job to a final status
+func (r *JobRepository) Finish(ctx context.Context, job *model.Job, status, errMessage string) error {
+	now := time.Now()
+	job.Status = status
+	job.Error = errMessage
+	job.FinishedAt = &now
+	return conn(ctx, r.db).Model(job).Where("status = ?", model.JobRunning).Updates(map[string]interface{}{
+		"status":      status,
+		"error":       errMessage,
+		"finished_at": now,
+	}).Error
+}
+
+// Cancel stops a pending, running or paused job and reports whether it was still active
+func (r *JobRepository) Cancel(ctx context.Context, id uint) (bool, error) {
+	result := conn(ctx, r.db).Model(&model.Job{}).Where("id = ? AND status IN ?", id, []string{model.JobPending, model.JobRunning, model.JobPaused}).
+		Updates(map[string]interface{}{"status": model.JobCancelled, "finished_at": time.Now()})
+	return result.RowsAffected > 0, result.Error
+}
+
+// Pause stops a pending or running job so it keeps its progress, and reports
+// whether it was still active
+func (r *JobRepository) Pause(ctx context.Context, id uint) (bool, error) {
+	result := conn(ctx, r.db).Model(&model.Job{}).Where("id = ? AND status IN ?", id, []string{model.JobPending, model.JobRunning}).
+		Update("status", model.JobPaused)
+	return result.RowsAffected > 0, result.Error
+}
+
+// Resume queues a paused job again and reports whether it was paused
+func (r *JobRepository) Resume(ctx context.Context, id uint) (bool, error) {
+	result := conn(ctx, r.db).Model(&model.Job{}).Where("id = ? AND status = ?", id, model.JobPaused).
+		Update("status", model.JobPending)
+	return result.RowsAffected > 0, result.Error
+}
@@ -0,0 +1,52 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type PasswordResetRepository struct {
+	db *gorm.DB
+}
+
+func NewPasswordResetRepository(db *gorm.DB) *PasswordResetRepository {
+	return &PasswordResetRepository{db: db}
+}
+
+func (r *PasswordResetRepository) Create(ctx context.Context, reset *model.PasswordReset) error {
+	return conn(ctx, r.db).Create(reset).Error
+}
+
+func (r *PasswordResetRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*model.PasswordReset, error) {
+	var reset model.PasswordReset
+	if err := conn(ctx, r.db).Where("token_hash = ?", tokenHash).First(&reset).Error; err != nil {
+		return nil, err
+	}
+	return &reset, nil
+}
+
+// MarkUsed consumes a token. It reports false when the token was already
+// used, so concurrent requests cannot both reset the password.
+func (r *PasswordResetRepository) MarkUsed(ctx context.Context, id uint) (bool, error) {
+	result := conn(ctx, r.db).Model(&model.PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteByUserID removes every outstanding token of a user
+func (r *PasswordResetRepository) DeleteByUserID(ctx context.Context, userID uint) error {
+	return conn(ctx, r.db).Where("user_id = ?", userID).Delete(&model.PasswordReset{}).Error
+}
+
+// DeleteExpired removes tokens that can no longer be used
+func (r *PasswordResetRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := conn(ctx, r.db).Where("expires_at < ? OR used_at IS NOT NULL", before).Delete(&model.PasswordReset{})
+	return result.RowsAffected, result.Error
+}
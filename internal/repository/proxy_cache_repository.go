@@ -0,0 +1,76 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type ProxyCacheRepository struct {
+	db *gorm.DB
+}
+
+func NewProxyCacheRepository(db *gorm.DB) *ProxyCacheRepository {
+	return &ProxyCacheRepository{db: db}
+}
+
+func (r *ProxyCacheRepository) Find(ctx context.Context, userID uint, cacheKey string) (*model.ProxyCacheEntry, error) {
+	var entry model.ProxyCacheEntry
+	if err := conn(ctx, r.db).Where("user_id = ? AND cache_key = ?", userID, cacheKey).First(&entry).Error; err != nil {
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// Save creates the entry or replaces a cached copy of the same source
+func (r *ProxyCacheRepository) Save(ctx context.Context, entry *model.ProxyCacheEntry) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "user_id"}, {Name: "cache_key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"source_url", "width", "file_path", "mime_type", "file_size", "last_accessed_at", "created_at", "updated_at"}),
+	}).Create(entry).Error
+}
+
+func (r *ProxyCacheRepository) Touch(ctx context.Context, entry *model.ProxyCacheEntry) error {
+	entry.LastAccessedAt = time.Now()
+	return conn(ctx, r.db).Model(entry).UpdateColumn("last_accessed_at", entry.LastAccessedAt).Error
+}
+
+func (r *ProxyCacheRepository) Delete(ctx context.Context, entry *model.ProxyCacheEntry) error {
+	return conn(ctx, r.db).Delete(entry).Error
+}
+
+// Usage returns the number and total size of all cached images
+func (r *ProxyCacheRepository) Usage(ctx context.Context) (int64, int64, error) {
+	var usage struct {
+		Count int64
+		Size  int64
+	}
+	if err := conn(ctx, r.db).Model(&model.ProxyCacheEntry{}).Select("COUNT(*) AS count, COALESCE(SUM(file_size), 0) AS size").Scan(&usage).Error; err != nil {
+		return 0, 0, err
+	}
+	return usage.Count, usage.Size, nil
+}
+
+// FindCreatedBefore returns entries fetched before the given time, i.e. expired ones
+func (r *ProxyCacheRepository) FindCreatedBefore(ctx context.Context, before time.Time, limit int) ([]model.ProxyCacheEntry, error) {
+	var entries []model.ProxyCacheEntry
+	if err := conn(ctx, r.db).Where("created_at < ?", before).Order("id ASC").Limit(limit).Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// FindLeastRecentlyUsed returns entries last served before the given time, least recently used first
+func (r *ProxyCacheRepository) FindLeastRecentlyUsed(ctx context.Context, before time.Time, limit int) ([]model.ProxyCacheEntry, error) {
+	var entries []model.ProxyCacheEntry
+	if err := conn(ctx, r.db).Where("last_accessed_at < ?", before).
+		Order("last_accessed_at ASC, id ASC").
+		Limit(limit).
+		Find(&entries).Error; err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
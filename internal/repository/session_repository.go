@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type SessionRepository struct {
+	db *gorm.DB
+}
+
+func NewSessionRepository(db *gorm.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *model.Session) error {
+	return conn(ctx, r.db).Create(session).Error
+}
+
+func (r *SessionRepository) Update(ctx context.Context, session *model.Session) error {
+	return conn(ctx, r.db).Save(session).Error
+}
+
+func (r *SessionRepository) FindByID(ctx context.Context, id uint) (*model.Session, error) {
+	var session model.Session
+	if err := conn(ctx, r.db).First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// FindByIDAndUser returns a session only when userID owns it
+func (r *SessionRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.Session, error) {
+	var session model.Session
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&session, id).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (r *SessionRepository) FindByRefreshHash(ctx context.Context, refreshHash string) (*model.Session, error) {
+	var session model.Session
+	if err := conn(ctx, r.db).Where("refresh_hash = ?", refreshHash).First(&session).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// FindActiveByUserID lists the unexpired sessions of a user, most recently used first
+func (r *SessionRepository) FindActiveByUserID(ctx context.Context, userID uint) ([]model.Session, error) {
+	var sessions []model.Session
+	if err := conn(ctx, r.db).Where("user_id = ? AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error; err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// Rotate saves a session with a new refresh token as long as it still holds
+// oldHash. It reports false when another request rotated it first.
+func (r *SessionRepository) Rotate(ctx context.Context, session *model.Session, oldHash string) (bool, error) {
+	result := conn(ctx, r.db).Model(&model.Session{}).
+		Where("id = ? AND refresh_hash = ?", session.ID, oldHash).
+		Updates(map[string]interface{}{
+			"refresh_hash": session.RefreshHash,
+			"last_seen_at": session.LastSeenAt,
+			"expires_at":   session.ExpiresAt,
+			"ip":           session.IP,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Touch records activity without loading the session
+func (r *SessionRepository) Touch(ctx context.Context, id uint, lastSeen time.Time, ip string) error {
+	return conn(ctx, r.db).Model(&model.Session{}).Where("id = ?", id).
+		Updates(map[string]interface{}{"last_seen_at": lastSeen, "ip": ip}).Error
+}
+
+func (r *SessionRepository) Delete(ctx context.Context, session *model.Session) error {
+	return conn(ctx, r.db).Delete(session).Error
+}
+
+func (r *SessionRepository) DeleteByUserID(ctx context.Context, userID uint) (int64, error) {
+	result := conn(ctx, r.db).Where("user_id = ?", userID).Delete(&model.Session{})
+	return result.RowsAffected, result.Error
+}
+
+func (r *SessionRepository) DeleteExpired(ctx context.Context, before time.Time) (int64, error) {
+	result := conn(ctx, r.db).Where("expires_at < ?", before).Delete(&model.Session{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,36 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type SettingRepository struct {
+	db *gorm.DB
+}
+
+func NewSettingRepository(db *gorm.DB) *SettingRepository {
+	return &SettingRepository{db: db}
+}
+
+func (r *SettingRepository) FindAll(ctx context.Context) ([]model.Setting, error) {
+	var settings []model.Setting
+	err := conn(ctx, r.db).Order("key").Find(&settings).Error
+	return settings, err
+}
+
+// Save stores a setting, replacing its previous value
+func (r *SettingRepository) Save(ctx context.Context, setting *model.Setting) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{UpdateAll: true}).Create(setting).Error
+}
+
+func (r *SettingRepository) Delete(ctx context.Context, key string) error {
+	return conn(ctx, r.db).Where("key = ?", key).Delete(&model.Setting{}).Error
+}
+
+func (r *SettingRepository) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return withTx(ctx, r.db, fn)
+}
@@ -0,0 +1,58 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+)
+
+type ShareRepository struct {
+	db *gorm.DB
+}
+
+func NewShareRepository(db *gorm.DB) *ShareRepository {
+	return &ShareRepository{db: db}
+}
+
+func (r *ShareRepository) Create(ctx context.Context, share *model.Share) error {
+	return conn(ctx, r.db).Create(share).Error
+}
+
+// FindByIDAndUser returns a share only when userID owns it
+func (r *ShareRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.Share, error) {
+	var share model.Share
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&share, id).Error; err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (r *ShareRepository) FindByToken(ctx context.Context, token string) (*model.Share, error) {
+	var share model.Share
+	if err := conn(ctx, r.db).Where("token = ?", token).First(&share).Error; err != nil {
+		return nil, err
+	}
+	return &share, nil
+}
+
+func (r *ShareRepository) FindByFileID(ctx context.Context, fileID uint) ([]model.Share, error) {
+	var shares []model.Share
+	if err := conn(ctx, r.db).Where("file_id = ?", fileID).Order("created_at DESC").Find(&shares).Error; err != nil {
+		return nil, err
+	}
+	return shares, nil
+}
+
+func (r *ShareRepository) Delete(ctx context.Context, share *model.Share) error {
+	return conn(ctx, r.db).Delete(share).Error
+}
+
+func (r *ShareRepository) DeleteByFileID(ctx context.Context, fileID uint) error {
+	return conn(ctx, r.db).Where("file_id = ?", fileID).Delete(&model.Share{}).Error
+}
+
+// IncrementDownloads counts a download without loading the share
+func (r *ShareRepository) IncrementDownloads(ctx context.Context, id uint) error {
+	return conn(ctx, r.db).Model(&model.Share{}).Where("id = ?", id).UpdateColumn("download_count", gorm.Expr("download_count + 1")).Error
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type TagRepository struct {
+	db *gorm.DB
+}
+
+func NewTagRepository(db *gorm.DB) *TagRepository {
+	return &TagRepository{db: db}
+}
+
+// Add tags a file, skipping tags it already has
+func (r *TagRepository) Add(ctx context.Context, userID, fileID uint, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	rows := make([]model.FileTag, len(tags))
+	for i, tag := range tags {
+		rows[i] = model.FileTag{FileID: fileID, UserID: userID, Tag: tag}
+	}
+	return conn(ctx, r.db).Clauses(clause.OnConflict{DoNothing: true}).Create(&rows).Error
+}
+
+func (r *TagRepository) Remove(ctx context.Context, fileID uint, tags []string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	return conn(ctx, r.db).Where("file_id = ? AND tag IN ?", fileID, tags).Delete(&model.FileTag{}).Error
+}
+
+// FindByFileIDs returns the tags of several files at once, by file ID and
+// in alphabetical order
+func (r *TagRepository) FindByFileIDs(ctx context.Context, fileIDs []uint) (map[uint][]string, error) {
+	tags := make(map[uint][]string)
+	if len(fileIDs) == 0 {
+		return tags, nil
+	}
+	var rows []model.FileTag
+	if err := conn(ctx, r.db).Where("file_id IN ?", fileIDs).Order("tag ASC").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		tags[row.FileID] = append(tags[row.FileID], row.Tag)
+	}
+	return tags, nil
+}
+
+func (r *TagRepository) DeleteByFileID(ctx context.Context, fileID uint) error {
+	return conn(ctx, r.db).Where("file_id = ?", fileID).Delete(&model.FileTag{}).Error
+}
@@ -0,0 +1,91 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type UploadLinkRepository struct {
+	db *gorm.DB
+}
+
+func NewUploadLinkRepository(db *gorm.DB) *UploadLinkRepository {
+	return &UploadLinkRepository{db: db}
+}
+
+func (r *UploadLinkRepository) Create(ctx context.Context, link *model.UploadLink) error {
+	return conn(ctx, r.db).Create(link).Error
+}
+
+// Update saves the settings of a link; the received counters are only
+// changed by Reserve and Release
+func (r *UploadLinkRepository) Update(ctx context.Context, link *model.UploadLink) error {
+	return conn(ctx, r.db).Model(link).Select("name", "folder_path", "allowed_extensions", "max_files", "max_total_size", "expires_at", "notify_email", "updated_at").Updates(link).Error
+}
+
+func (r *UploadLinkRepository) Delete(ctx context.Context, link *model.UploadLink) error {
+	return conn(ctx, r.db).Delete(link).Error
+}
+
+func (r *UploadLinkRepository) FindByID(ctx context.Context, id uint) (*model.UploadLink, error) {
+	var link model.UploadLink
+	if err := conn(ctx, r.db).First(&link, id).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// FindByIDAndUser returns an upload link only when userID owns it
+func (r *UploadLinkRepository) FindByIDAndUser(ctx context.Context, id, userID uint) (*model.UploadLink, error) {
+	var link model.UploadLink
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).First(&link, id).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *UploadLinkRepository) FindByToken(ctx context.Context, token string) (*model.UploadLink, error) {
+	var link model.UploadLink
+	if err := conn(ctx, r.db).Where("token = ?", token).First(&link).Error; err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+func (r *UploadLinkRepository) FindByUserID(ctx context.Context, userID uint) ([]model.UploadLink, error) {
+	var links []model.UploadLink
+	if err := conn(ctx, r.db).Where("user_id = ?", userID).Order("created_at DESC").Find(&links).Error; err != nil {
+		return nil, err
+	}
+	return links, nil
+}
+
+// Reserve counts a file of size bytes against a link in one statement, so
+// concurrent uploads can't exceed its limits. It returns false when the
+// link has expired or the file doesn't fit.
+func (r *UploadLinkRepository) Reserve(ctx context.Context, id uint, size int64) (bool, error) {
+	result := conn(ctx, r.db).Model(&model.UploadLink{}).
+		Where("id = ?", id).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		Where("max_files = 0 OR file_count < max_files").
+		Where("max_total_size = 0 OR total_size + ? <= max_total_size", size).
+		UpdateColumns(map[string]interface{}{
+			"file_count": gorm.Expr("file_count + 1"),
+			"total_size": gorm.Expr("total_size + ?", size),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected > 0, nil
+}
+
+// Release returns a reservation of an upload that failed
+func (r *UploadLinkRepository) Release(ctx context.Context, id uint, size int64) error {
+	return conn(ctx, r.db).Model(&model.UploadLink{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{
+		"file_count": gorm.Expr("file_count - 1"),
+		"total_size": gorm.Expr("total_size - ?", size),
+	}).Error
+}
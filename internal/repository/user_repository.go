@@ -1,47 +1,89 @@
 package repository
 
 import (
+	"context"
 	"storage-service/internal/model"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type UserRepository struct {
 	db *gorm.DB
+	// replica serves counts that tolerate replication lag
+	replica *gorm.DB
 }
 
 func NewUserRepository(db *gorm.DB) *UserRepository {
-	return &UserRepository{db: db}
+	return &UserRepository{db: db, replica: readReplica(db)}
 }
 
-func (r *UserRepository) Create(user *model.User) error {
-	return r.db.Create(user).Error
+func (r *UserRepository) Create(ctx context.Context, user *model.User) error {
+	return conn(ctx, r.db).Create(user).Error
 }
 
-func (r *UserRepository) FindByAPIKey(apiKey string) (*model.User, error) {
+func (r *UserRepository) FindByID(ctx context.Context, id uint) (*model.User, error) {
 	var user model.User
-	if err := r.db.Where("api_key = ?", apiKey).First(&user).Error; err != nil {
+	if err := conn(ctx, r.db).First(&user, id).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UserRepository) FindByID(id uint) (*model.User, error) {
+func (r *UserRepository) Update(ctx context.Context, user *model.User) error {
+	return conn(ctx, r.db).Save(user).Error
+}
+
+func (r *UserRepository) Count(ctx context.Context) (int64, error) {
+	var count int64
+	if err := conn(ctx, r.replica).Model(&model.User{}).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*model.User, error) {
 	var user model.User
-	if err := r.db.First(&user, id).Error; err != nil {
+	if err := conn(ctx, r.db).Where("email = ?", email).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
-func (r *UserRepository) Update(user *model.User) error {
-	return r.db.Save(user).Error
-}
-
-func (r *UserRepository) FindByEmail(email string) (*model.User, error) {
+// FindByStripeCustomerID returns the user billed as a Stripe customer
+func (r *UserRepository) FindByStripeCustomerID(ctx context.Context, customerID string) (*model.User, error) {
 	var user model.User
-	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+	if err := conn(ctx, r.db).Where("stripe_customer_id = ?", customerID).First(&user).Error; err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
+
+// FindByBillingStatus returns the users with a billing status, e.g. the active subscribers
+func (r *UserRepository) FindByBillingStatus(ctx context.Context, status string) ([]model.User, error) {
+	var users []model.User
+	if err := conn(ctx, r.db).Where("billing_status = ?", status).Order("id ASC").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// FindGraceExpired returns the users whose payment grace period ended before t
+func (r *UserRepository) FindGraceExpired(ctx context.Context, t time.Time) ([]model.User, error) {
+	var users []model.User
+	if err := conn(ctx, r.db).Where("grace_until IS NOT NULL AND grace_until < ?", t).Order("id ASC").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// HasServiceAccountGrant reports whether a service account may act on behalf of a user
+func (r *UserRepository) HasServiceAccountGrant(ctx context.Context, serviceAccountID, userID uint) (bool, error) {
+	var count int64
+	if err := conn(ctx, r.db).Model(&model.ServiceAccountGrant{}).
+		Where("service_account_id = ? AND user_id = ?", serviceAccountID, userID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
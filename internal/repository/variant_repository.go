@@ -0,0 +1,98 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type VariantRepository struct {
+	db *gorm.DB
+}
+
+func NewVariantRepository(db *gorm.DB) *VariantRepository {
+	return &VariantRepository{db: db}
+}
+
+// Save creates the variant or replaces the existing one with the same file and name
+func (r *VariantRepository) Save(ctx context.Context, variant *model.FileVariant) error {
+	return conn(ctx, r.db).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "file_id"}, {Name: "name"}},
+		DoUpdates: clause.AssignmentColumns([]string{"file_path", "mime_type", "width", "height", "file_size", "last_accessed_at", "updated_at"}),
+	}).Create(variant).Error
+}
+
+func (r *VariantRepository) FindByFileID(ctx context.Context, fileID uint) ([]model.FileVariant, error) {
+	var variants []model.FileVariant
+	if err := conn(ctx, r.db).Where("file_id = ?", fileID).Order("name ASC").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+// FindByFileIDs returns the variants of several files at once
+func (r *VariantRepository) FindByFileIDs(ctx context.Context, fileIDs []uint) ([]model.FileVariant, error) {
+	var variants []model.FileVariant
+	if len(fileIDs) == 0 {
+		return variants, nil
+	}
+	if err := conn(ctx, r.db).Where("file_id IN ?", fileIDs).Order("file_id ASC, name ASC").Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+func (r *VariantRepository) Delete(ctx context.Context, variant *model.FileVariant) error {
+	return conn(ctx, r.db).Delete(variant).Error
+}
+
+// DeleteByFileID removes all variants of a file and returns them so their files can be removed
+func (r *VariantRepository) DeleteByFileID(ctx context.Context, fileID uint) ([]model.FileVariant, error) {
+	var variants []model.FileVariant
+	if err := conn(ctx, r.db).Clauses(clause.Returning{}).Where("file_id = ?", fileID).Delete(&variants).Error; err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
+
+// FindStoredPaths returns which of paths are stored paths of variants
+func (r *VariantRepository) FindStoredPaths(ctx context.Context, paths []string) ([]string, error) {
+	var stored []string
+	if err := conn(ctx, r.db).Model(&model.FileVariant{}).Where("file_path IN ?", paths).Pluck("file_path", &stored).Error; err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// TouchByFileID marks the variants of a file as recently used
+func (r *VariantRepository) TouchByFileID(ctx context.Context, fileID uint) error {
+	return conn(ctx, r.db).Model(&model.FileVariant{}).Where("file_id = ?", fileID).UpdateColumn("last_accessed_at", time.Now()).Error
+}
+
+// Usage returns the number and total size of all variants
+func (r *VariantRepository) Usage(ctx context.Context) (int64, int64, error) {
+	var usage struct {
+		Count int64
+		Size  int64
+	}
+	if err := conn(ctx, r.db).Model(&model.FileVariant{}).Select("COUNT(*) AS count, COALESCE(SUM(file_size), 0) AS size").Scan(&usage).Error; err != nil {
+		return 0, 0, err
+	}
+	return usage.Count, usage.Size, nil
+}
+
+// FindLeastRecentlyUsed returns variants last used before the given time, least
+// recently used first. Variants never used since tracking began count from their last update.
+func (r *VariantRepository) FindLeastRecentlyUsed(ctx context.Context, before time.Time, limit int) ([]model.FileVariant, error) {
+	var variants []model.FileVariant
+	if err := conn(ctx, r.db).Where("COALESCE(last_accessed_at, updated_at) < ?", before).
+		Order("COALESCE(last_accessed_at, updated_at) ASC, id ASC").
+		Limit(limit).
+		Find(&variants).Error; err != nil {
+		return nil, err
+	}
+	return variants, nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type VersionRepository struct {
+	db *gorm.DB
+}
+
+func NewVersionRepository(db *gorm.DB) *VersionRepository {
+	return &VersionRepository{db: db}
+}
+
+func (r *VersionRepository) Create(ctx context.Context, version *model.FileVersion) error {
+	return conn(ctx, r.db).Create(version).Error
+}
+
+// FindByFileID returns the earlier versions of a file, newest first
+func (r *VersionRepository) FindByFileID(ctx context.Context, fileID uint) ([]model.FileVersion, error) {
+	var versions []model.FileVersion
+	if err := conn(ctx, r.db).Where("file_id = ?", fileID).Order("version DESC").Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (r *VersionRepository) FindByFileIDAndVersion(ctx context.Context, fileID uint, version int) (*model.FileVersion, error) {
+	var fileVersion model.FileVersion
+	if err := conn(ctx, r.db).Where("file_id = ? AND version = ?", fileID, version).First(&fileVersion).Error; err != nil {
+		return nil, err
+	}
+	return &fileVersion, nil
+}
+
+// FindExcess returns the versions of a file beyond the newest keep ones
+func (r *VersionRepository) FindExcess(ctx context.Context, fileID uint, keep int) ([]model.FileVersion, error) {
+	var versions []model.FileVersion
+	if err := conn(ctx, r.db).Where("file_id = ?", fileID).Order("version DESC").Offset(keep).Find(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func (r *VersionRepository) UpdateFilePath(ctx context.Context, id uint, path string) error {
+	return conn(ctx, r.db).Model(&model.FileVersion{}).Where("id = ?", id).UpdateColumn("file_path", path).Error
+}
+
+func (r *VersionRepository) Delete(ctx context.Context, version *model.FileVersion) error {
+	return conn(ctx, r.db).Delete(version).Error
+}
+
+// DeleteByFileID removes all versions of a file and returns them so their files can be removed
+func (r *VersionRepository) DeleteByFileID(ctx context.Context, fileID uint) ([]model.FileVersion, error) {
+	var versions []model.FileVersion
+	if err := conn(ctx, r.db).Clauses(clause.Returning{}).Where("file_id = ?", fileID).Delete(&versions).Error; err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+// CountOthersByFilePath counts the versions other than excludeID stored at path
+func (r *VersionRepository) CountOthersByFilePath(ctx context.Context, path string, excludeID uint) (int64, error) {
+	var count int64
+	if err := conn(ctx, r.db).Model(&model.FileVersion{}).Where("file_path = ? AND id <> ?", path, excludeID).Count(&count).Error; err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// FindStoredPaths returns which of paths are stored paths of versions
+func (r *VersionRepository) FindStoredPaths(ctx context.Context, paths []string) ([]string, error) {
+	var stored []string
+	if err := conn(ctx, r.db).Model(&model.FileVersion{}).Where("file_path IN ?", paths).Pluck("file_path", &stored).Error; err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
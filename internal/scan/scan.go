@@ -0,0 +1,116 @@
+// Package scan checks file content for malware with an external scanner
+package scan
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// chunkSize is the size of the INSTREAM chunks sent to clamd; it must stay
+// below clamd's StreamMaxLength
+const chunkSize = 64 << 10
+
+// Result is the verdict on a scanned file; Signature names the detected
+// malware when Infected is set
+type Result struct {
+	Infected  bool
+	Signature string
+}
+
+// Scanner checks content for malware
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, r io.Reader) (Result, error)
+}
+
+// NewScanner returns a clamd client for an address like "tcp://host:3310",
+// "host:3310" or "unix:///run/clamav/clamd.ctl", or nil when address is empty
+func NewScanner(address string, timeout time.Duration) (Scanner, error) {
+	if address == "" {
+		return nil, nil
+	}
+
+	network, addr := "tcp", address
+	if rest, ok := strings.CutPrefix(address, "unix://"); ok {
+		network, addr = "unix", rest
+	} else if rest, ok := strings.CutPrefix(address, "tcp://"); ok {
+		addr = rest
+	}
+	if addr == "" {
+		return nil, fmt.Errorf("invalid clamd address %q", address)
+	}
+	return &clamd{network: network, address: addr, timeout: timeout}, nil
+}
+
+// clamd talks to a ClamAV daemon with the INSTREAM command
+type clamd struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+func (c *clamd) Name() string { return "clamd" }
+
+func (c *clamd) Scan(ctx context.Context, r io.Reader) (Result, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return Result{}, err
+	}
+
+	buf := make([]byte, 4+chunkSize)
+	for {
+		n, err := io.ReadFull(r, buf[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(buf[:4], uint32(n))
+			if _, err := conn.Write(buf[:4+n]); err != nil {
+				return Result{}, fmt.Errorf("failed to send file to clamd: %w", err)
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return Result{}, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return Result{}, err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return Result{}, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseReply reads "stream: OK", "stream: <signature> FOUND" or an error
+func parseReply(reply string) (Result, error) {
+	_, verdict, _ := strings.Cut(reply, ": ")
+	switch {
+	case verdict == "OK":
+		return Result{}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return Result{Infected: true, Signature: strings.TrimSuffix(verdict, " FOUND")}, nil
+	default:
+		return Result{}, fmt.Errorf("clamd: %s", reply)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	shutdownTimeout = 30 * time.Second
+	unixSocketMode  = 0660
+)
+
+// Address is a network address to listen on
+type Address struct {
+	Network string
+	Address string
+}
+
+func (a Address) String() string {
+	if a.Network == "unix" {
+		return "unix:" + a.Address
+	}
+	return a.Address
+}
+
+// ParseAddresses parses a comma-separated list of listen addresses. Entries
+// prefixed with "unix:" are Unix domain sockets, everything else is TCP.
+func ParseAddresses(spec string) ([]Address, error) {
+	var addrs []Address
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if path, ok := strings.CutPrefix(part, "unix:"); ok {
+			if path == "" {
+				return nil, fmt.Errorf("empty unix socket path in %q", spec)
+			}
+			addrs = append(addrs, Address{Network: "unix", Address: path})
+			continue
+		}
+		addrs = append(addrs, Address{Network: "tcp", Address: part})
+	}
+	return addrs, nil
+}
+
+type entry struct {
+	name     string
+	server   *http.Server
+	listener net.Listener
+	address  Address
+}
+
+// Server runs several HTTP handlers on their own sets of listeners and shuts
+// them down together
+type Server struct {
+	entries []entry
+}
+
+func New() *Server {
+	return &Server{}
+}
+
+// Add opens listeners for a handler. Nothing is served until Run is called.
+func (s *Server) Add(name string, handler http.Handler, spec string) error {
+	addrs, err := ParseAddresses(spec)
+	if err != nil {
+		return err
+	}
+
+	for _, addr := range addrs {
+		listener, err := listen(addr)
+		if err != nil {
+			s.closeListeners()
+			return fmt.Errorf("failed to listen on %s for %s: %w", addr, name, err)
+		}
+		s.entries = append(s.entries, entry{
+			name:     name,
+			server:   &http.Server{Handler: handler, ReadHeaderTimeout: 30 * time.Second},
+			listener: listener,
+			address:  addr,
+		})
+	}
+	return nil
+}
+
+// Run serves all listeners until one of them fails or the process receives
+// SIGINT/SIGTERM, then shuts everything down gracefully
+func (s *Server) Run() error {
+	if len(s.entries) == 0 {
+		return errors.New("no listeners configured")
+	}
+
+	errCh := make(chan error, len(s.entries))
+	for _, e := range s.entries {
+		log.Printf("Starting %s server on %s", e.name, e.address)
+		go func(e entry) {
+			if err := e.server.Serve(e.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				errCh <- fmt.Errorf("%s server on %s: %w", e.name, e.address, err)
+			}
+		}(e)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		log.Printf("Shutting down servers")
+	case runErr = <-errCh:
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, e := range s.entries {
+		if err := e.server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down %s server on %s: %v", e.name, e.address, err)
+		}
+	}
+	s.removeSockets()
+	return runErr
+}
+
+func listen(addr Address) (net.Listener, error) {
+	if addr.Network != "unix" {
+		return net.Listen(addr.Network, addr.Address)
+	}
+
+	// Remove a stale socket left behind by a previous run
+	if info, err := os.Stat(addr.Address); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(addr.Address)
+	}
+	listener, err := net.Listen("unix", addr.Address)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(addr.Address, unixSocketMode); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+func (s *Server) closeListeners() {
+	for _, e := range s.entries {
+		e.listener.Close()
+	}
+	s.removeSockets()
+}
+
+func (s *Server) removeSockets() {
+	for _, e := range s.entries {
+		if e.address.Network == "unix" {
+			os.Remove(e.address.Address)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"time"
+)
+
+// Bulk actions admins can apply to files of any user
+const (
+	AdminActionDelete     = "delete"
+	AdminActionQuarantine = "quarantine"
+	AdminActionRelease    = "release"
+	AdminActionTransfer   = "transfer"
+)
+
+// AdminFileQuery selects files across all users. Empty fields match everything.
+type AdminFileQuery struct {
+	UserID uint
+	// Name matches part of the original name, ignoring case and accents
+	Name string
+	// NameWarnings selects files with a misleading name, see model.NameWarning
+	NameWarnings bool
+	// MimePrefix matches MIME types starting with it, e.g. "image/"
+	MimePrefix string
+	MinSize    int64
+	MaxSize    int64
+	// CreatedAfter and CreatedBefore bound the upload time
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+	ScanStatus    string
+	// Source is how the files were uploaded, see model.SourceWeb
+	Source string
+}
+
+// AdminBulkResult lists the files a bulk action was applied to and why the
+// others were skipped
+type AdminBulkResult struct {
+	Action    string           `json:"action"`
+	Succeeded []uint           `json:"succeeded"`
+	Errors    []BatchItemError `json:"errors"`
+}
+
+// AdminFileService lets admins browse files of all users and act on them,
+// recording every action in the owner's audit log
+type AdminFileService struct {
+	fileRepo *repository.FileRepository
+	files    *FileService
+	scans    *ScanService
+	audit    *AuditService
+}
+
+func NewAdminFileService(fileRepo *repository.FileRepository, files *FileService, scans *ScanService, audit *AuditService) *AdminFileService {
+	return &AdminFileService{fileRepo: fileRepo, files: files, scans: scans, audit: audit}
+}
+
+// Search returns a page of the files matching query
+func (s *AdminFileService) Search(ctx context.Context, query AdminFileQuery, page, pageSize int, sortBy, sortOrder string) ([]model.File, int64, error) {
+	filter := repository.FileFilter{
+		Name:          query.Name,
+		NameWarnings:  query.NameWarnings,
+		MimePrefix:    query.MimePrefix,
+		MinSize:       query.MinSize,
+		MaxSize:       query.MaxSize,
+		CreatedAfter:  query.CreatedAfter,
+		CreatedBefore: query.CreatedBefore,
+	}
+	if query.UserID != 0 {
+		filter.UserIDs = []uint{query.UserID}
+	}
+	if query.ScanStatus != "" {
+		filter.ScanStatuses = []string{query.ScanStatus}
+	}
+	if query.Source != "" {
+		filter.Sources = []string{query.Source}
+	}
+
+	offset := (page - 1) * pageSize
+	files, err := s.fileRepo.FindPage(ctx, filter, pageSize, offset, sortBy, sortOrder)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.fileRepo.CountMatching(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range files {
+		s.files.generateFileURL(&files[i])
+	}
+	return files, total, nil
+}
+
+// Bulk applies an action to several files of any user. Files that fail are
+// reported per ID instead of failing the whole batch. targetUserID is only
+// used by transfers; reason is recorded with every action.
+func (s *AdminFileService) Bulk(ctx context.Context, action string, ids []uint, targetUserID uint, reason string, adminID uint, ip string) (*AdminBulkResult, error) {
+	auditAction, ok := map[string]string{
+		AdminActionDelete:     model.AuditFileDeleted,
+		AdminActionQuarantine: model.AuditFileQuarantined,
+		AdminActionRelease:    model.AuditFileReleased,
+		AdminActionTransfer:   model.AuditFileTransferred,
+	}[action]
+	if !ok {
+		return nil, ErrUnknownBulkAction.WithArgs(action)
+	}
+	if len(ids) > MaxBatchGetIDs {
+		return nil, ErrTooManyIDs.WithArgs(MaxBatchGetIDs)
+	}
+	if action == AdminActionTransfer {
+		if targetUserID == 0 {
+			return nil, ErrTargetUserRequired
+		}
+		if _, err := s.files.userService.GetUserByID(ctx, targetUserID); err != nil {
+			return nil, err
+		}
+	}
+
+	found, err := s.fileRepo.FindByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*model.File, len(found))
+	for i := range found {
+		byID[found[i].ID] = &found[i]
+	}
+
+	result := &AdminBulkResult{Action: action, Succeeded: []uint{}, Errors: []BatchItemError{}}
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		file, ok := byID[id]
+		if !ok {
+			result.Errors = append(result.Errors, BatchItemError{ID: id, Error: "File not found"})
+			continue
+		}
+
+		ownerID := file.UserID
+		if err := s.apply(ctx, action, file, targetUserID, reason); err != nil {
+			result.Errors = append(result.Errors, BatchItemError{ID: id, Error: bulkErrorMessage(err)})
+			continue
+		}
+
+		details := map[string]interface{}{"file_id": file.ID, "name": file.OriginalName}
+		if reason != "" {
+			details["reason"] = reason
+		}
+		if action == AdminActionTransfer {
+			details["target_user_id"] = targetUserID
+		}
+		s.audit.Record(ctx, ownerID, adminID, auditAction, ip, details)
+		result.Succeeded = append(result.Succeeded, id)
+	}
+
+	return result, nil
+}
+
+// ReleaseWriteOnce releases a write-once folder of userID, see
+// FileService.ReleaseWriteOnce, recording it with reason in the owner's
+// audit log
+func (s *AdminFileService) ReleaseWriteOnce(ctx context.Context, userID uint, folderPath, reason string, adminID uint, ip string) (*model.Folder, error) {
+	folder, err := s.files.ReleaseWriteOnce(ctx, userID, folderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	details := map[string]interface{}{"path": folder.Path}
+	if reason != "" {
+		details["reason"] = reason
+	}
+	s.audit.Record(ctx, userID, adminID, model.AuditWriteOnceReleased, ip, details)
+	return folder, nil
+}
+
+func (s *AdminFileService) apply(ctx context.Context, action string, file *model.File, targetUserID uint, reason string) error {
+	switch action {
+	case AdminActionDelete:
+		return s.files.DeleteFileAsAdmin(ctx, file)
+	case AdminActionQuarantine:
+		return s.scans.Quarantine(ctx, file, reason)
+	case AdminActionRelease:
+		return s.scans.Release(ctx, file)
+	default:
+		return s.files.TransferFile(ctx, file, targetUserID)
+	}
+}
+
+// bulkErrorMessage keeps internal errors out of the per-item results
+func bulkErrorMessage(err error) string {
+	var appErr *apperror.Error
+	if errors.As(err, &appErr) && appErr.Status < http.StatusInternalServerError {
+		return appErr.Error()
+	}
+	return "Internal error"
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"storage-service/internal/repository"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type AdminService struct {
+	userRepo  *repository.UserRepository
+	fileRepo  *repository.FileRepository
+	diskGuard *DiskGuard
+	derived   *DerivedCache
+}
+
+// AdminStats summarizes the whole installation
+type AdminStats struct {
+	TotalUsers int64      `json:"total_users"`
+	TotalFiles int64      `json:"total_files"`
+	TotalSize  int64      `json:"total_size"`
+	Disk       *DiskStats `json:"disk,omitempty"`
+	// Derived is the disk used by variants and proxied images, which is not
+	// part of TotalSize or any user quota
+	Derived *DerivedUsage `json:"derived"`
+}
+
+func NewAdminService(userRepo *repository.UserRepository, fileRepo *repository.FileRepository, diskGuard *DiskGuard, derived *DerivedCache) *AdminService {
+	return &AdminService{
+		userRepo:  userRepo,
+		fileRepo:  fileRepo,
+		diskGuard: diskGuard,
+		derived:   derived,
+	}
+}
+
+func (s *AdminService) GetStats(ctx context.Context) (*AdminStats, error) {
+	totalUsers, err := s.userRepo.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	totalFiles, err := s.fileRepo.Count(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	totalSize, err := s.fileRepo.GetTotalSize(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	derived, err := s.derived.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := &AdminStats{
+		TotalUsers: totalUsers,
+		TotalFiles: totalFiles,
+		TotalSize:  totalSize,
+		Derived:    derived,
+	}
+
+	// Disk usage is optional, e.g. on platforms without statfs
+	if disk, err := s.diskGuard.Stats(); err == nil {
+		stats.Disk = disk
+	}
+
+	return stats, nil
+}
+
+// SetUserImageTypes replaces the optional image types a user may upload to
+// the image endpoints and returns every type the user may now upload
+func (s *AdminService) SetUserImageTypes(ctx context.Context, userID uint, mimeTypes []string) ([]string, error) {
+	parsed, err := ParseImageTypes(mimeTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	user.ExtraImageTypes = strings.Join(parsed, ",")
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return sortedKeys(userImageTypes(user)), nil
+}
@@ -0,0 +1,285 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"expvar"
+	"fmt"
+	"log"
+	"net/http"
+	"storage-service/internal/config"
+	"storage-service/internal/coord"
+	"storage-service/internal/mail"
+	"storage-service/internal/metrics"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Metrics alert rules can be written against
+const (
+	// AlertErrorRate is the share of requests answered with a 5xx status, in percent
+	AlertErrorRate = "error_rate"
+	// AlertUploadErrorRate is the share of uploads answered with a 5xx status, in percent
+	AlertUploadErrorRate = "upload_error_rate"
+	// AlertDiskFree is the free space left on UPLOAD_PATH, in percent
+	AlertDiskFree = "disk_free"
+	// AlertQueueDepth is the number of pending and running jobs
+	AlertQueueDepth = "queue_depth"
+)
+
+// alertMinRequests keeps a handful of failures on an idle instance from
+// counting as a high error rate
+const alertMinRequests = 20
+
+// alertStateTTL bounds how long a firing alert is remembered without being
+// evaluated again
+const alertStateTTL = 7 * 24 * time.Hour
+
+// uploadRoutes are the requests counted by AlertUploadErrorRate
+var uploadRoutes = []string{"POST /api/upload", "POST /api/upload-image", "PUT /api/images/direct/:token"}
+
+// AlertRule fires when a metric is above (">") or below ("<") a threshold
+type AlertRule struct {
+	Metric    string
+	Above     bool
+	Threshold float64
+}
+
+func (r AlertRule) String() string {
+	op := "<"
+	if r.Above {
+		op = ">"
+	}
+	return r.Metric + op + strconv.FormatFloat(r.Threshold, 'f', -1, 64)
+}
+
+func (r AlertRule) breached(value float64) bool {
+	if r.Above {
+		return value > r.Threshold
+	}
+	return value < r.Threshold
+}
+
+// ParseAlertRules parses a spec such as "error_rate>5%,disk_free<10%,queue_depth>500"
+func ParseAlertRules(spec string) ([]AlertRule, error) {
+	var rules []AlertRule
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		i := strings.IndexAny(entry, "<>")
+		if i <= 0 {
+			return nil, fmt.Errorf("invalid alert rule %q, expected metric>value or metric<value", entry)
+		}
+		rule := AlertRule{Metric: strings.TrimSpace(entry[:i]), Above: entry[i] == '>'}
+		switch rule.Metric {
+		case AlertErrorRate, AlertUploadErrorRate, AlertDiskFree, AlertQueueDepth:
+		default:
+			return nil, fmt.Errorf("unknown metric %q in alert rule", rule.Metric)
+		}
+
+		threshold, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(entry[i+1:]), "%"), 64)
+		if err != nil || threshold < 0 {
+			return nil, fmt.Errorf("invalid threshold in alert rule %q", entry)
+		}
+		rule.Threshold = threshold
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// alertNotification is the body POSTed to ALERT_WEBHOOK_URL
+type alertNotification struct {
+	Rule      string    `json:"rule"`
+	Metric    string    `json:"metric"`
+	Value     float64   `json:"value"`
+	Threshold float64   `json:"threshold"`
+	Status    string    `json:"status"`
+	Time      time.Time `json:"time"`
+}
+
+// requestTotals is a snapshot of the request counters
+type requestTotals struct {
+	requests, errors             int64
+	uploadRequests, uploadErrors int64
+}
+
+// AlertService evaluates alert rules against the service's own metrics and
+// notifies a webhook and email addresses when a rule starts or stops firing,
+// for deployments without a monitoring stack
+type AlertService struct {
+	rules      []AlertRule
+	jobRepo    *repository.JobRepository
+	diskGuard  *DiskGuard
+	store      coord.Store
+	mailer     mail.Mailer
+	webhookURL string
+	emails     []string
+	client     *http.Client
+	last       requestTotals
+}
+
+// NewAlertService returns the service evaluating rules, see ParseAlertRules
+func NewAlertService(jobRepo *repository.JobRepository, diskGuard *DiskGuard, store coord.Store, mailer mail.Mailer, rules []AlertRule, cfg *config.Config) *AlertService {
+	var emails []string
+	for _, email := range strings.Split(cfg.AlertEmails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	return &AlertService{
+		rules:      rules,
+		jobRepo:    jobRepo,
+		diskGuard:  diskGuard,
+		store:      store,
+		mailer:     mailer,
+		webhookURL: cfg.AlertWebhookURL,
+		emails:     emails,
+		client:     &http.Client{Timeout: 10 * time.Second},
+		last:       currentRequestTotals(),
+	}
+}
+
+// Enabled reports whether any rule is configured
+func (s *AlertService) Enabled() bool {
+	return len(s.rules) > 0
+}
+
+// Evaluate checks every rule and notifies about rules that started or
+// stopped firing since the previous evaluation. Request rates cover the
+// requests this instance handled since it last evaluated.
+func (s *AlertService) Evaluate(ctx context.Context) error {
+	values := s.measure(ctx)
+	for _, rule := range s.rules {
+		value, ok := values[rule.Metric]
+		if !ok {
+			continue
+		}
+
+		key := "alert:" + rule.String()
+		_, firing, err := s.store.Get(ctx, key)
+		if err != nil {
+			return err
+		}
+		breached := rule.breached(value)
+		if breached == firing {
+			continue
+		}
+
+		status := "resolved"
+		if breached {
+			status = "firing"
+			err = s.store.Set(ctx, key, []byte(status), alertStateTTL)
+		} else {
+			err = s.store.Delete(ctx, key)
+		}
+		if err != nil {
+			return err
+		}
+		s.notify(ctx, alertNotification{Rule: rule.String(), Metric: rule.Metric, Value: value, Threshold: rule.Threshold, Status: status, Time: time.Now()})
+	}
+	return nil
+}
+
+// measure returns the current value of every metric that could be determined
+func (s *AlertService) measure(ctx context.Context) map[string]float64 {
+	values := map[string]float64{}
+
+	totals := currentRequestTotals()
+	requests, failed := totals.requests-s.last.requests, totals.errors-s.last.errors
+	uploads, uploadErrors := totals.uploadRequests-s.last.uploadRequests, totals.uploadErrors-s.last.uploadErrors
+	s.last = totals
+	if requests >= alertMinRequests {
+		values[AlertErrorRate] = 100 * float64(failed) / float64(requests)
+	}
+	if uploads >= alertMinRequests {
+		values[AlertUploadErrorRate] = 100 * float64(uploadErrors) / float64(uploads)
+	}
+
+	if stats, err := s.diskGuard.Stats(); err == nil && stats.Total > 0 {
+		values[AlertDiskFree] = 100 * float64(stats.Free) / float64(stats.Total)
+	}
+
+	if depth, err := s.jobRepo.CountByStatus(ctx, model.JobPending, model.JobRunning); err != nil {
+		log.Printf("[WARN] Failed to count queued jobs for alerts: %v", err)
+	} else {
+		values[AlertQueueDepth] = float64(depth)
+	}
+	return values
+}
+
+func (s *AlertService) notify(ctx context.Context, alert alertNotification) {
+	log.Printf("[WARN] Alert %s: %s (value %s)", alert.Status, alert.Rule, strconv.FormatFloat(alert.Value, 'f', 2, 64))
+
+	if s.webhookURL != "" {
+		if err := s.postWebhook(ctx, alert); err != nil {
+			log.Printf("[WARN] Failed to send alert %s to webhook: %v", alert.Rule, err)
+		}
+	}
+
+	subject := fmt.Sprintf("[%s] Storage alert: %s", strings.ToUpper(alert.Status), alert.Rule)
+	body := fmt.Sprintf("Rule: %s\nStatus: %s\nCurrent value: %s\nTime: %s\n",
+		alert.Rule, alert.Status, strconv.FormatFloat(alert.Value, 'f', 2, 64), alert.Time.Format(time.RFC1123))
+	for _, email := range s.emails {
+		if err := s.mailer.Send(email, subject, body); err != nil {
+			log.Printf("[WARN] Failed to email alert %s to %s: %v", alert.Rule, email, err)
+		}
+	}
+}
+
+func (s *AlertService) postWebhook(ctx context.Context, alert alertNotification) error {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+func currentRequestTotals() requestTotals {
+	var totals requestTotals
+	totals.requests = sumCounters(metrics.Requests, nil)
+	totals.errors = sumCounters(metrics.RequestErrors, nil)
+	totals.uploadRequests = sumCounters(metrics.Requests, uploadRoutes)
+	totals.uploadErrors = sumCounters(metrics.RequestErrors, uploadRoutes)
+	return totals
+}
+
+// sumCounters adds up the counters of a map, or only those of keys when given
+func sumCounters(m *expvar.Map, keys []string) int64 {
+	var sum int64
+	if keys != nil {
+		for _, key := range keys {
+			if counter, ok := m.Get(key).(*expvar.Int); ok {
+				sum += counter.Value()
+			}
+		}
+		return sum
+	}
+	m.Do(func(kv expvar.KeyValue) {
+		if counter, ok := kv.Value.(*expvar.Int); ok {
+			sum += counter.Value()
+		}
+	})
+	return sum
+}
@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"storage-service/internal/config"
+	"storage-service/internal/coord"
+	"storage-service/internal/detect"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// moderationReason is recorded as the quarantine reason of anonymous uploads
+const moderationReason = "awaiting moderation"
+
+// AnonymousUpload is what an anonymous uploader gets back: a share link that
+// works once a moderator approved the file
+type AnonymousUpload struct {
+	ShareURL  string     `json:"share_url"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Status    string     `json:"status"`
+}
+
+// AnonymousUploadService accepts uploads without an account, for file-drop
+// deployments. Uploads must pass a captcha, are limited in size, type and
+// per client IP, and are stored quarantined in one account until an admin
+// approves or rejects them from the moderation queue.
+type AnonymousUploadService struct {
+	fileRepo    *repository.FileRepository
+	files       *FileService
+	scans       *ScanService
+	shares      *ShareService
+	userService *UserService
+	store       coord.Store
+	captcha     *CaptchaVerifier
+	enabled     bool
+	userID      uint
+	maxSize     int64
+	perHour     int64
+	shareExpiry string
+}
+
+// NewAnonymousUploadService returns the service; maxSize is
+// ANONYMOUS_MAX_SIZE in bytes
+func NewAnonymousUploadService(fileRepo *repository.FileRepository, files *FileService, scans *ScanService, shares *ShareService, userService *UserService, store coord.Store, maxSize int64, cfg *config.Config) *AnonymousUploadService {
+	return &AnonymousUploadService{
+		fileRepo:    fileRepo,
+		files:       files,
+		scans:       scans,
+		shares:      shares,
+		userService: userService,
+		store:       store,
+		captcha:     NewCaptchaVerifier(cfg.CaptchaVerifyURL, cfg.CaptchaSecret),
+		enabled:     cfg.AnonymousUploads,
+		userID:      cfg.AnonymousUploadUserID,
+		maxSize:     maxSize,
+		perHour:     cfg.AnonymousUploadsPerHour,
+		shareExpiry: cfg.AnonymousShareExpiry,
+	}
+}
+
+// Enabled reports whether ANONYMOUS_UPLOADS is set
+func (s *AnonymousUploadService) Enabled() bool {
+	return s.enabled
+}
+
+// MaxSize returns the largest anonymous upload accepted
+func (s *AnonymousUploadService) MaxSize() int64 {
+	return s.maxSize
+}
+
+// Check makes sure the account receiving anonymous uploads exists
+func (s *AnonymousUploadService) Check(ctx context.Context) error {
+	if !s.enabled {
+		return nil
+	}
+	if _, err := s.userService.GetUserByID(ctx, s.userID); err != nil {
+		return fmt.Errorf("user %d: %w", s.userID, err)
+	}
+	return nil
+}
+
+// Upload stores an anonymous upload in the moderation queue and returns a
+// share link to it; client is the request that sent it
+func (s *AnonymousUploadService) Upload(ctx context.Context, fileHeader *multipart.FileHeader, captchaToken string, client model.UploadSource) (*AnonymousUpload, error) {
+	if !s.enabled {
+		return nil, ErrAnonymousUploadsDisabled
+	}
+	if fileHeader.Size > s.maxSize {
+		return nil, ErrAnonymousUploadTooLarge.WithArgs(formatByteSize(s.maxSize))
+	}
+
+	uploads, err := s.store.Incr(ctx, "anonymous-uploads:"+client.IP, time.Hour)
+	if err != nil {
+		return nil, err
+	}
+	if uploads > s.perHour {
+		return nil, ErrAnonymousRateLimited
+	}
+	if err := s.captcha.Verify(ctx, captchaToken, client.IP); err != nil {
+		return nil, err
+	}
+
+	// Both the claimed and the detected type must be allowed
+	detection, err := s.files.scanFileContent(fileHeader)
+	if err != nil {
+		return nil, err
+	}
+	mimeType := detection.Effective(s.files.mimePolicy)
+	types := s.files.settings.Current().AnonymousAllowedTypes
+	if !types[mimeType] || (detect.Conclusive(detection.Detected) && !types[detection.Detected]) {
+		return nil, ErrAnonymousTypeNotAllowed.WithArgs(strings.Join(sortedKeys(types), ", "))
+	}
+
+	client.Source = model.SourceAnonymous
+	file, err := s.files.storeUpload(ctx, s.userID, fileHeader, UploadOptions{Source: client})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.scans.Quarantine(ctx, file, moderationReason); err != nil {
+		// Never leave an unmoderated file downloadable
+		s.files.deleteFile(context.WithoutCancel(ctx), file)
+		return nil, err
+	}
+	share, err := s.shares.CreateShare(ctx, file.ID, s.userID, s.shareExpiry)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnonymousUpload{ShareURL: share.URL, ExpiresAt: share.ExpiresAt, Status: "pending_moderation"}, nil
+}
+
+// Queue returns a page of anonymous uploads awaiting moderation, oldest first
+func (s *AnonymousUploadService) Queue(ctx context.Context, page, pageSize int) ([]model.File, int64, error) {
+	filter := repository.FileFilter{UserIDs: []uint{s.userID}, ScanStatuses: []string{model.ScanQuarantined}}
+	files, err := s.fileRepo.FindPage(ctx, filter, pageSize, (page-1)*pageSize, "created_at", "asc")
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.fileRepo.CountMatching(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range files {
+		s.files.generateFileURL(&files[i])
+	}
+	return files, total, nil
+}
+
+// Approve releases an anonymous upload, making its share link work
+func (s *AnonymousUploadService) Approve(ctx context.Context, fileID uint) (*model.File, error) {
+	file, err := s.queued(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.scans.Release(ctx, file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// Reject deletes an anonymous upload and its share link
+func (s *AnonymousUploadService) Reject(ctx context.Context, fileID uint) error {
+	file, err := s.queued(ctx, fileID)
+	if err != nil {
+		return err
+	}
+	return s.files.DeleteFileAsAdmin(ctx, file)
+}
+
+// queued returns an anonymous upload still awaiting moderation
+func (s *AnonymousUploadService) queued(ctx context.Context, fileID uint) (*model.File, error) {
+	file, err := s.fileRepo.FindByID(ctx, fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) || (err == nil && (file.UserID != s.userID || file.ScanStatus != model.ScanQuarantined)) {
+		return nil, ErrNotInModeration
+	}
+	return file, err
+}
@@ -0,0 +1,329 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// maxAPIKeys bounds the keys a user may hold
+const maxAPIKeys = 50
+
+// apiKeyTouchInterval is how often the last use of a key is recorded
+const apiKeyTouchInterval = time.Minute
+
+// s3AccessKeyPrefix starts the S3 access key ID of a key, followed by its ID
+const s3AccessKeyPrefix = "SK"
+
+// userScopes are the scopes of keys created without any, everything but
+// admin
+var userScopes = []string{model.ScopeRead, model.ScopeUpload, model.ScopeDelete}
+
+// APIKeyInput describes a new API key, or the changes to one; empty fields
+// keep what a key has
+type APIKeyInput struct {
+	Label string `json:"label" binding:"max=100"`
+	// Scopes default to read, upload and delete
+	Scopes []string `json:"scopes"`
+	// ExpiresIn counts from now, e.g. "90d"; empty keys don't expire
+	ExpiresIn string `json:"expires_in"`
+	// FolderPath receives uploads that pass no folder_path; "" clears it
+	FolderPath *string `json:"folder_path"`
+}
+
+// APIKeyService manages the API keys of users and authenticates requests
+// with them. A key may only grant scopes the request creating it holds, so a
+// limited key can't create a broader one.
+type APIKeyService struct {
+	keyRepo  *repository.APIKeyRepository
+	userRepo *repository.UserRepository
+	// s3Secret derives the S3 credentials of keys, nil when the S3 gateway
+	// is off
+	s3Secret []byte
+}
+
+func NewAPIKeyService(keyRepo *repository.APIKeyRepository, userRepo *repository.UserRepository, cfg *config.Config) *APIKeyService {
+	s := &APIKeyService{keyRepo: keyRepo, userRepo: userRepo}
+	if cfg.S3Listen != "" {
+		s.s3Secret = []byte(cfg.AppSecret)
+	}
+	return s
+}
+
+// Authenticate returns the key and its user. Requests made with a key are
+// recorded as its last use at most once per apiKeyTouchInterval.
+func (s *APIKeyService) Authenticate(ctx context.Context, secret, ip string) (*model.APIKey, *model.User, error) {
+	key, err := s.keyRepo.FindByHash(ctx, hashToken(secret))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	user, err := s.Use(ctx, key, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, user, nil
+}
+
+// S3Credentials returns the key of an S3 access key ID and its secret
+// access key, for the gateway to check the request's signature with
+func (s *APIKeyService) S3Credentials(ctx context.Context, accessKeyID string) (*model.APIKey, string, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(accessKeyID, s3AccessKeyPrefix), 10, 32)
+	if err != nil || s.s3Secret == nil || !strings.HasPrefix(accessKeyID, s3AccessKeyPrefix) {
+		return nil, "", ErrInvalidAPIKey
+	}
+	key, err := s.keyRepo.FindByID(ctx, uint(id))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, "", ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return key, s.s3SecretOf(key), nil
+}
+
+// Use returns the user of a key whose secret the request has shown,
+// recording the use at most once per apiKeyTouchInterval
+func (s *APIKeyService) Use(ctx context.Context, key *model.APIKey, ip string) (*model.User, error) {
+	if key.Expired() {
+		return nil, ErrAPIKeyExpired
+	}
+	user, err := s.userRepo.FindByID(ctx, key.UserID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if now := time.Now(); key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) > apiKeyTouchInterval || key.LastUsedIP != ip {
+		// Failing to record the use must not fail the request
+		if err := s.keyRepo.Touch(ctx, key.ID, ip, now); err == nil {
+			key.LastUsedAt, key.LastUsedIP = &now, ip
+		}
+	}
+	return user, nil
+}
+
+func (s *APIKeyService) ListKeys(ctx context.Context, userID uint) ([]model.APIKey, error) {
+	return s.keyRepo.FindByUserID(ctx, userID)
+}
+
+// CreateKey creates a key for user. granted are the scopes of the key the
+// request is made with, nil for requests with a session.
+func (s *APIKeyService) CreateKey(ctx context.Context, user *model.User, input APIKeyInput, granted []string) (*model.APIKey, error) {
+	keys, err := s.keyRepo.FindByUserID(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) >= maxAPIKeys {
+		return nil, ErrTooManyAPIKeys.WithArgs(maxAPIKeys)
+	}
+
+	scopes := input.Scopes
+	if len(scopes) == 0 {
+		scopes = userScopes
+		if granted != nil {
+			scopes = slices.DeleteFunc(slices.Clone(granted), func(scope string) bool { return scope == model.ScopeAdmin })
+		}
+	}
+	key := &model.APIKey{UserID: user.ID, Label: strings.TrimSpace(input.Label)}
+	if key.Scopes, err = validateScopes(user, scopes, granted); err != nil {
+		return nil, err
+	}
+	if err := applyKeyInput(key, input); err != nil {
+		return nil, err
+	}
+
+	if err := setAPIKeySecret(key); err != nil {
+		return nil, err
+	}
+	if err := s.keyRepo.Create(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to create API key: %w", err)
+	}
+	s.setS3Credentials(key)
+	return key, nil
+}
+
+// UpdateKey changes the label, scopes, expiry or upload folder of a key of
+// user, keeping its secret. Like RotateKey, a request can only change keys
+// with the granted scopes, and only to those.
+func (s *APIKeyService) UpdateKey(ctx context.Context, keyID uint, user *model.User, input APIKeyInput, granted []string) (*model.APIKey, error) {
+	key, err := s.findKey(ctx, keyID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range key.ScopeList() {
+		if granted != nil && !slices.Contains(granted, scope) {
+			return nil, ErrScopeNotGranted.WithArgs(scope)
+		}
+	}
+	if label := strings.TrimSpace(input.Label); label != "" {
+		key.Label = label
+	}
+	if len(input.Scopes) > 0 {
+		if key.Scopes, err = validateScopes(user, input.Scopes, granted); err != nil {
+			return nil, err
+		}
+	}
+	if err := applyKeyInput(key, input); err != nil {
+		return nil, err
+	}
+	if err := s.keyRepo.Update(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to update API key: %w", err)
+	}
+	return key, nil
+}
+
+// applyKeyInput sets the expiry and upload folder of input on key
+func applyKeyInput(key *model.APIKey, input APIKeyInput) error {
+	if input.ExpiresIn != "" {
+		ttl, err := ParseExpireAfter(input.ExpiresIn)
+		if err != nil {
+			return ErrInvalidAPIKeyExpiry.WithArgs(input.ExpiresIn)
+		}
+		expiresAt := time.Now().Add(ttl)
+		key.ExpiresAt = &expiresAt
+	}
+	if input.FolderPath != nil {
+		key.FolderPath = model.CleanFolderPath(*input.FolderPath)
+	}
+	return nil
+}
+
+// RotateKey replaces the secret of a key, keeping its label, scopes and
+// expiry. The old secret stops working at once. Like CreateKey, a request
+// can only obtain a key with the granted scopes.
+func (s *APIKeyService) RotateKey(ctx context.Context, keyID, userID uint, granted []string) (*model.APIKey, error) {
+	key, err := s.findKey(ctx, keyID, userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, scope := range key.ScopeList() {
+		if granted != nil && !slices.Contains(granted, scope) {
+			return nil, ErrScopeNotGranted.WithArgs(scope)
+		}
+	}
+	if err := setAPIKeySecret(key); err != nil {
+		return nil, err
+	}
+	if err := s.keyRepo.Update(ctx, key); err != nil {
+		return nil, fmt.Errorf("failed to rotate API key: %w", err)
+	}
+	s.setS3Credentials(key)
+	return key, nil
+}
+
+func (s *APIKeyService) RevokeKey(ctx context.Context, keyID, userID uint) error {
+	key, err := s.findKey(ctx, keyID, userID)
+	if err != nil {
+		return err
+	}
+	return s.keyRepo.Delete(ctx, key)
+}
+
+// IssueKey creates a key with every scope the user may hold and puts it in
+// user.APIKey. With replace, every other key of the user is revoked, as
+// credential changes do. It joins the transaction ctx carries.
+func (s *APIKeyService) IssueKey(ctx context.Context, user *model.User, replace bool) error {
+	return s.keyRepo.WithTx(ctx, func(ctx context.Context) error {
+		if replace {
+			if err := s.keyRepo.DeleteByUserID(ctx, user.ID); err != nil {
+				return err
+			}
+		}
+		scopes := userScopes
+		if user.IsAdmin {
+			scopes = model.APIKeyScopes
+		}
+		key := &model.APIKey{UserID: user.ID, Label: "default", Scopes: strings.Join(scopes, ",")}
+		if err := setAPIKeySecret(key); err != nil {
+			return err
+		}
+		if err := s.keyRepo.Create(ctx, key); err != nil {
+			return fmt.Errorf("failed to create API key: %w", err)
+		}
+		user.APIKey = key.Key
+		return nil
+	})
+}
+
+func (s *APIKeyService) findKey(ctx context.Context, keyID, userID uint) (*model.APIKey, error) {
+	key, err := s.keyRepo.FindByIDAndUser(ctx, keyID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// validateScopes checks requested scopes and returns them comma separated.
+// Only admins hold the admin scope, and with granted set only those scopes
+// may be given.
+func validateScopes(user *model.User, scopes, granted []string) (string, error) {
+	var valid []string
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if !slices.Contains(model.APIKeyScopes, scope) {
+			return "", ErrInvalidScope.WithArgs(scope)
+		}
+		if scope == model.ScopeAdmin && !user.IsAdmin {
+			return "", ErrAdminScopeNotAllowed
+		}
+		if granted != nil && !slices.Contains(granted, scope) {
+			return "", ErrScopeNotGranted.WithArgs(scope)
+		}
+		if !slices.Contains(valid, scope) {
+			valid = append(valid, scope)
+		}
+	}
+	if len(valid) == 0 {
+		return "", ErrInvalidScope.WithArgs("")
+	}
+	return strings.Join(valid, ","), nil
+}
+
+// setS3Credentials shows the S3 credentials along with a new secret. The S3
+// secret derives from the hash of the key and APP_SECRET, so it changes with
+// either and is never stored.
+func (s *APIKeyService) setS3Credentials(key *model.APIKey) {
+	if s.s3Secret != nil {
+		key.S3AccessKeyID = s3AccessKeyPrefix + strconv.FormatUint(uint64(key.ID), 10)
+		key.S3SecretAccessKey = s.s3SecretOf(key)
+	}
+}
+
+func (s *APIKeyService) s3SecretOf(key *model.APIKey) string {
+	mac := hmac.New(sha256.New, s.s3Secret)
+	mac.Write([]byte("s3:" + key.KeyHash))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// setAPIKeySecret gives a key a new random secret
+func setAPIKeySecret(key *model.APIKey) error {
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return err
+	}
+	key.Key = base64.RawURLEncoding.EncodeToString(secret)
+	key.KeyHash = hashToken(key.Key)
+	key.Prefix = key.Key[:8]
+	return nil
+}
@@ -0,0 +1,57 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+)
+
+// AuditService records security-relevant actions for the audit log and the
+// users' activity feeds
+type AuditService struct {
+	auditRepo *repository.AuditRepository
+}
+
+func NewAuditService(auditRepo *repository.AuditRepository) *AuditService {
+	return &AuditService{auditRepo: auditRepo}
+}
+
+// Record stores an action on userID's account. actorID is zero when the
+// user acted themselves. A failure is logged and does not fail the action.
+func (s *AuditService) Record(ctx context.Context, userID, actorID uint, action, ip string, details map[string]interface{}) {
+	event := &model.AuditEvent{UserID: userID, Action: action, IP: ip}
+	if actorID != 0 && actorID != userID {
+		event.ActorID = &actorID
+	}
+	if len(details) > 0 {
+		encoded, err := json.Marshal(details)
+		if err != nil {
+			log.Printf("[WARN] Failed to encode details of audit event %s: %v", action, err)
+		} else {
+			event.Details = string(encoded)
+		}
+	}
+
+	if err := s.auditRepo.Create(ctx, event); err != nil {
+		log.Printf("[WARN] Failed to record audit event %s for user %d: %v", action, userID, err)
+	}
+}
+
+// ListEvents returns a page of events, only those of userID when it is non-zero
+func (s *AuditService) ListEvents(ctx context.Context, userID uint, page, pageSize int) ([]model.AuditEvent, int64, error) {
+	offset := (page - 1) * pageSize
+
+	events, err := s.auditRepo.FindAll(ctx, userID, pageSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.auditRepo.Count(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return events, total, nil
+}
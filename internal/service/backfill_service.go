@@ -0,0 +1,278 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"storage-service/internal/detect"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+)
+
+// JobBackfill computes fields introduced after files were uploaded
+const JobBackfill = "backfill"
+
+// Backfiller fills one group of fields for legacy files
+type Backfiller struct {
+	Name        string
+	Description string
+	// Missing is the file column whose absence marks a legacy row
+	Missing string
+	// Fill computes the columns to store for a file
+	Fill func(file *model.File) (map[string]interface{}, error)
+}
+
+// BackfillParams selects what a backfill job computes and for whom
+type BackfillParams struct {
+	Field   string `json:"field" binding:"required"`
+	UserIDs []uint `json:"user_ids"`
+}
+
+// BackfillField describes a backfiller and how many files still lack its fields
+type BackfillField struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Missing     int64  `json:"missing"`
+}
+
+// BackfillService runs backfillers over existing files as resumable jobs
+type BackfillService struct {
+	fileRepo    *repository.FileRepository
+	jobs        *JobService
+	backfillers map[string]Backfiller
+}
+
+func NewBackfillService(fileRepo *repository.FileRepository, jobs *JobService, detector detect.Detector) *BackfillService {
+	s := &BackfillService{
+		fileRepo:    fileRepo,
+		jobs:        jobs,
+		backfillers: make(map[string]Backfiller),
+	}
+	s.Register(mimeBackfiller(detector))
+	s.Register(dimensionsBackfiller())
+	s.Register(colorSpaceBackfiller())
+	s.Register(kindBackfiller())
+	s.Register(checksumBackfiller())
+	s.Register(nameSortKeyBackfiller())
+	s.Register(extensionBackfiller())
+	s.Register(searchKeyBackfiller())
+	jobs.Register(JobBackfill, s.step)
+	return s
+}
+
+// Register adds a backfiller; its name is the field accepted by Start
+func (s *BackfillService) Register(b Backfiller) {
+	s.backfillers[b.Name] = b
+}
+
+// Fields lists the available backfillers with the number of files lacking their fields
+func (s *BackfillService) Fields(ctx context.Context) ([]BackfillField, error) {
+	fields := make([]BackfillField, 0, len(s.backfillers))
+	for _, b := range s.backfillers {
+		missing, err := s.fileRepo.CountMatching(ctx, repository.FileFilter{Missing: b.Missing})
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, BackfillField{Name: b.Name, Description: b.Description, Missing: missing})
+	}
+	sort.Slice(fields, func(i, j int) bool { return fields[i].Name < fields[j].Name })
+	return fields, nil
+}
+
+// Start queues a backfill job for one field
+func (s *BackfillService) Start(ctx context.Context, params BackfillParams, adminID uint) (*model.Job, error) {
+	if _, ok := s.backfillers[params.Field]; !ok {
+		return nil, ErrUnknownBackfill.WithArgs(params.Field)
+	}
+	return s.jobs.Enqueue(ctx, JobBackfill, params, adminID)
+}
+
+func (s *BackfillService) step(ctx context.Context, job *model.Job) (bool, error) {
+	var params BackfillParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+	b, ok := s.backfillers[params.Field]
+	if !ok {
+		return false, fmt.Errorf("unknown backfill field %q", params.Field)
+	}
+
+	// Filled rows drop out of the filter; the cursor skips rows that failed
+	filter := repository.FileFilter{UserIDs: params.UserIDs, Missing: b.Missing}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		file := &files[i]
+		fields, err := b.Fill(file)
+		if err == nil {
+			err = s.fileRepo.UpdateFields(ctx, file.ID, fields)
+		}
+		if err != nil {
+			log.Printf("[WARN] Backfill %s failed for file %d: %v", b.Name, file.ID, err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = file.ID
+	}
+
+	return len(files) < jobBatchSize, nil
+}
+
+// dimensionsBackfiller stores width, height, frame count and color profile of images
+func dimensionsBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "dimensions",
+		Description: "Image width, height, frame count and color profile",
+		Missing:     "width",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			meta, err := readImageMetadataFile(file.FilePath, file.MimeType)
+			if err != nil {
+				return nil, err
+			}
+			return meta.Fields(), nil
+		},
+	}
+}
+
+// colorSpaceBackfiller names the color profile of images whose dimensions
+// were stored before color spaces were recorded
+func colorSpaceBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "color_space",
+		Description: "Name of the embedded color profile of images",
+		Missing:     "color_space",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			meta, err := readImageMetadataFile(file.FilePath, file.MimeType)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{"color_space": meta.ColorSpace}, nil
+		},
+	}
+}
+
+// kindBackfiller classifies files uploaded before the kind was stored
+func kindBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "kind",
+		Description: "File kind such as image, document or archive",
+		Missing:     "kind",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			return map[string]interface{}{"kind": model.FileKind(file.MimeType, file.OriginalName)}, nil
+		},
+	}
+}
+
+// nameSortKeyBackfiller derives the natural sort key of files stored before
+// names were sorted naturally
+func nameSortKeyBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "name_sort_key",
+		Description: "Key ordering original names naturally",
+		Missing:     "name_sort_key",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			return map[string]interface{}{"name_sort_key": model.NaturalSortKey(file.OriginalName)}, nil
+		},
+	}
+}
+
+// extensionBackfiller stores the extension of files stored before it was
+// recorded
+func extensionBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "extension",
+		Description: "Lowercase extension of the original name",
+		Missing:     "extension",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			return map[string]interface{}{"extension": model.FileExtension(file.OriginalName)}, nil
+		},
+	}
+}
+
+// searchKeyBackfiller derives the search key of files stored before searches
+// ignored accents, and stores their names in the form new names are stored in
+func searchKeyBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "search_key",
+		Description: "Key name searches match regardless of case and accents, and NFC names",
+		Missing:     "search_key",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			name := model.NormalizeName(file.OriginalName)
+			return map[string]interface{}{"original_name": name, "search_key": model.SearchKey(name)}, nil
+		},
+	}
+}
+
+// checksumBackfiller computes the SHA-256 and MD5 of files uploaded before
+// checksums were stored
+func checksumBackfiller() Backfiller {
+	return Backfiller{
+		Name:        "checksums",
+		Description: "SHA-256 and MD5 checksums of the content",
+		Missing:     "sha256",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			f, err := OpenFileContent(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+
+			digest := newContentDigest()
+			if _, err := io.Copy(digest, f); err != nil {
+				return nil, err
+			}
+			var sums model.File
+			digest.apply(&sums)
+			return map[string]interface{}{"sha256": sums.SHA256, "md5": sums.MD5}, nil
+		},
+	}
+}
+
+// mimeBackfiller records the declared, extension and detected MIME types of
+// files uploaded before content detection was stored
+func mimeBackfiller(detector detect.Detector) Backfiller {
+	return Backfiller{
+		Name:        "mime",
+		Description: "Declared, extension and detected MIME types",
+		Missing:     "detected_mime_type",
+		Fill: func(file *model.File) (map[string]interface{}, error) {
+			f, err := OpenFileContent(file)
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+
+			buffer := make([]byte, detectionHeaderSize)
+			n, err := io.ReadFull(f, buffer)
+			if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+				return nil, err
+			}
+
+			// The stored MIME type is the best record of what the client declared
+			declared := file.DeclaredMimeType
+			if declared == "" {
+				declared = file.MimeType
+			}
+			result := detect.Inspect(detector, file.OriginalName, declared, buffer[:n])
+			return map[string]interface{}{
+				"declared_mime_type":  result.Declared,
+				"extension_mime_type": result.Extension,
+				"detected_mime_type":  result.Detected,
+				"mime_mismatch":       result.Mismatch(),
+			}, nil
+		},
+	}
+}
@@ -0,0 +1,392 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"storage-service/internal/billing"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// BillingStatus is the subscription of a user as shown to them
+type BillingStatus struct {
+	Plan       string     `json:"plan"`
+	Status     string     `json:"status,omitempty"`
+	GraceUntil *time.Time `json:"grace_until,omitempty"`
+	// Plans lists the plans that can be subscribed to
+	Plans []string `json:"plans"`
+}
+
+// BillingService sells plans through Stripe. Checkout and the customer
+// portal are hosted by Stripe; its webhooks move users between plans, so
+// quotas follow the subscription. After a failed payment the user keeps the
+// plan for the grace period, then falls back to the default plan.
+type BillingService struct {
+	userRepo     *repository.UserRepository
+	fileRepo     *repository.FileRepository
+	users        *UserService
+	client       *billing.Client
+	prices       map[string]string
+	successURL   string
+	cancelURL    string
+	grace        time.Duration
+	overageMeter string
+	usagePeriod  time.Duration
+}
+
+// NewBillingService returns the service; prices are STRIPE_PRICES, see
+// ParseBillingPrices
+func NewBillingService(userRepo *repository.UserRepository, fileRepo *repository.FileRepository, users *UserService, prices map[string]string, cfg *config.Config) *BillingService {
+	appURL := strings.TrimSuffix(cfg.StorageURL, "/") + "/app/settings"
+	successURL, cancelURL := cfg.BillingSuccessURL, cfg.BillingCancelURL
+	if successURL == "" {
+		successURL = appURL + "?billing=success"
+	}
+	if cancelURL == "" {
+		cancelURL = appURL
+	}
+
+	return &BillingService{
+		userRepo:     userRepo,
+		fileRepo:     fileRepo,
+		users:        users,
+		client:       billing.NewClient(cfg.StripeSecretKey, cfg.StripeWebhookSecret),
+		prices:       prices,
+		successURL:   successURL,
+		cancelURL:    cancelURL,
+		grace:        cfg.BillingGracePeriod,
+		overageMeter: cfg.StripeOverageMeter,
+		usagePeriod:  cfg.BillingUsageInterval,
+	}
+}
+
+// ParseBillingPrices parses a spec such as "pro=price_123,business=price_456"
+// mapping plans to the Stripe prices they are sold at. The default plan is
+// free and can't have a price.
+func ParseBillingPrices(spec string, plans Plans, defaultPlan string) (map[string]string, error) {
+	prices := map[string]string{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		plan, price, ok := strings.Cut(entry, "=")
+		plan, price = strings.TrimSpace(plan), strings.TrimSpace(price)
+		if !ok || plan == "" || price == "" {
+			return nil, fmt.Errorf("invalid price %q, expected plan=price_id", entry)
+		}
+		if _, ok := plans[plan]; !ok {
+			return nil, fmt.Errorf("price for unknown plan %q", plan)
+		}
+		if plan == defaultPlan {
+			return nil, fmt.Errorf("the default plan %q can't have a price", plan)
+		}
+		prices[plan] = price
+	}
+	return prices, nil
+}
+
+// Enabled reports whether STRIPE_SECRET_KEY is set
+func (s *BillingService) Enabled() bool {
+	return s.client != nil
+}
+
+// OverageEnabled reports whether overage usage is reported to Stripe
+func (s *BillingService) OverageEnabled() bool {
+	return s.client != nil && s.overageMeter != ""
+}
+
+// Status returns the plan and subscription of a user
+func (s *BillingService) Status(ctx context.Context, userID uint) (*BillingStatus, error) {
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &BillingStatus{
+		Plan:       s.users.planOf(user).Name,
+		Status:     user.BillingStatus,
+		GraceUntil: user.GraceUntil,
+		Plans:      sortedKeys(s.billablePlans()),
+	}, nil
+}
+
+func (s *BillingService) billablePlans() map[string]bool {
+	plans := make(map[string]bool, len(s.prices))
+	for plan := range s.prices {
+		plans[plan] = true
+	}
+	return plans
+}
+
+// Checkout starts a Stripe checkout subscribing a user to a plan and returns
+// the page to send them to. Users who already subscribed change their plan
+// in the portal instead.
+func (s *BillingService) Checkout(ctx context.Context, userID uint, plan string) (*billing.CheckoutSession, error) {
+	if !s.Enabled() {
+		return nil, ErrBillingDisabled
+	}
+	price, ok := s.prices[plan]
+	if !ok {
+		return nil, ErrPlanNotBillable.WithArgs(plan)
+	}
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.StripeSubscriptionID != "" && user.BillingStatus != model.BillingCanceled {
+		return nil, ErrSubscriptionExists
+	}
+
+	return s.client.CreateCheckoutSession(ctx, billing.CheckoutParams{
+		CustomerID:        user.StripeCustomerID,
+		CustomerEmail:     user.Email,
+		PriceID:           price,
+		SuccessURL:        s.successURL,
+		CancelURL:         s.cancelURL,
+		ClientReferenceID: strconv.FormatUint(uint64(user.ID), 10),
+		Metadata:          map[string]string{"user_id": strconv.FormatUint(uint64(user.ID), 10), "plan": plan},
+	})
+}
+
+// Portal returns the Stripe customer portal of a user, where they change
+// plan, update their payment method or cancel
+func (s *BillingService) Portal(ctx context.Context, userID uint) (string, error) {
+	if !s.Enabled() {
+		return "", ErrBillingDisabled
+	}
+	user, err := s.users.GetUserByID(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	if user.StripeCustomerID == "" {
+		return "", ErrNoSubscription
+	}
+	return s.client.CreatePortalSession(ctx, user.StripeCustomerID, s.cancelURL)
+}
+
+// Stripe objects carried by the webhook events handled below
+type (
+	stripeCheckoutSession struct {
+		ClientReferenceID string            `json:"client_reference_id"`
+		Customer          string            `json:"customer"`
+		Subscription      string            `json:"subscription"`
+		Metadata          map[string]string `json:"metadata"`
+	}
+	stripeSubscription struct {
+		ID       string `json:"id"`
+		Customer string `json:"customer"`
+		Status   string `json:"status"`
+		Items    struct {
+			Data []struct {
+				Price struct {
+					ID string `json:"id"`
+				} `json:"price"`
+			} `json:"data"`
+		} `json:"items"`
+	}
+	stripeInvoice struct {
+		Customer string `json:"customer"`
+	}
+)
+
+// HandleWebhook verifies and applies a Stripe webhook. Events are applied
+// by setting state, so Stripe redelivering one is harmless.
+func (s *BillingService) HandleWebhook(ctx context.Context, payload []byte, signature string) error {
+	if !s.Enabled() {
+		return ErrBillingDisabled
+	}
+	event, err := s.client.ParseWebhook(payload, signature)
+	if err != nil {
+		return err
+	}
+
+	switch event.Type {
+	case "checkout.session.completed":
+		var session stripeCheckoutSession
+		if err := json.Unmarshal(event.Data.Object, &session); err != nil {
+			return err
+		}
+		return s.subscribed(ctx, session)
+	case "customer.subscription.created", "customer.subscription.updated", "customer.subscription.deleted":
+		var subscription stripeSubscription
+		if err := json.Unmarshal(event.Data.Object, &subscription); err != nil {
+			return err
+		}
+		if event.Type == "customer.subscription.deleted" {
+			subscription.Status = "canceled"
+		}
+		return s.subscriptionChanged(ctx, subscription)
+	case "invoice.payment_failed", "invoice.paid":
+		var invoice stripeInvoice
+		if err := json.Unmarshal(event.Data.Object, &invoice); err != nil {
+			return err
+		}
+		return s.withCustomer(ctx, invoice.Customer, func(user *model.User) {
+			if event.Type == "invoice.paid" {
+				if user.BillingStatus == model.BillingPastDue {
+					s.activate(user)
+				}
+			} else {
+				s.startGrace(user)
+			}
+		})
+	}
+	return nil
+}
+
+func (s *BillingService) subscribed(ctx context.Context, session stripeCheckoutSession) error {
+	userID, err := strconv.ParseUint(session.ClientReferenceID, 10, 32)
+	if err != nil {
+		log.Printf("[WARN] Ignoring Stripe checkout without a user: %q", session.ClientReferenceID)
+		return nil
+	}
+	user, err := s.users.GetUserByID(ctx, uint(userID))
+	if errors.Is(err, ErrUserNotFound) {
+		log.Printf("[WARN] Ignoring Stripe checkout of unknown user %d", userID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	user.StripeCustomerID, user.StripeSubscriptionID = session.Customer, session.Subscription
+	s.activate(user)
+	if plan, ok := s.users.plans[session.Metadata["plan"]]; ok && s.prices[plan.Name] != "" {
+		assignPlan(user, plan)
+	}
+	return s.userRepo.Update(ctx, user)
+}
+
+func (s *BillingService) subscriptionChanged(ctx context.Context, subscription stripeSubscription) error {
+	return s.withCustomer(ctx, subscription.Customer, func(user *model.User) {
+		// Changes of a replaced subscription don't affect the current one
+		if user.StripeSubscriptionID != "" && user.StripeSubscriptionID != subscription.ID {
+			return
+		}
+		user.StripeSubscriptionID = subscription.ID
+
+		switch subscription.Status {
+		case "active", "trialing":
+			s.activate(user)
+			for _, item := range subscription.Items.Data {
+				if plan, ok := s.planOfPrice(item.Price.ID); ok && plan.Name != user.Plan {
+					assignPlan(user, plan)
+				}
+			}
+		case "past_due", "unpaid":
+			s.startGrace(user)
+		case "canceled", "incomplete_expired":
+			s.downgrade(user)
+		}
+	})
+}
+
+// withCustomer applies change to the user billed as a Stripe customer and
+// saves them. Events of customers created outside this service are ignored.
+func (s *BillingService) withCustomer(ctx context.Context, customerID string, change func(user *model.User)) error {
+	user, err := s.userRepo.FindByStripeCustomerID(ctx, customerID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	change(user)
+	return s.userRepo.Update(ctx, user)
+}
+
+func (s *BillingService) planOfPrice(price string) (Plan, bool) {
+	for name, id := range s.prices {
+		if id == price {
+			return s.users.plans[name], true
+		}
+	}
+	return Plan{}, false
+}
+
+func (s *BillingService) activate(user *model.User) {
+	user.BillingStatus = model.BillingActive
+	user.GraceUntil = nil
+}
+
+// startGrace keeps the plan of a user whose payment failed until the grace
+// period ends; retried failures don't extend it
+func (s *BillingService) startGrace(user *model.User) {
+	user.BillingStatus = model.BillingPastDue
+	if user.GraceUntil == nil {
+		until := time.Now().Add(s.grace)
+		user.GraceUntil = &until
+	}
+}
+
+// downgrade ends the subscription of a user and moves them to the default
+// plan. Their files are kept, but uploads fail while they are above its limits.
+func (s *BillingService) downgrade(user *model.User) {
+	user.BillingStatus = model.BillingCanceled
+	user.StripeSubscriptionID = ""
+	user.GraceUntil = nil
+	assignPlan(user, s.users.plans[s.users.defaultPlan()])
+}
+
+// ExpireGrace downgrades users whose grace period ended without a payment.
+// Their subscription stays with Stripe, which cancels it by its own retry
+// settings; a later payment activates the plan again.
+func (s *BillingService) ExpireGrace(ctx context.Context) error {
+	users, err := s.userRepo.FindGraceExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	for i := range users {
+		user := &users[i]
+		log.Printf("[INFO] Grace period of user %d ended, moving them to plan %s", user.ID, s.users.defaultPlan())
+		subscription := user.StripeSubscriptionID
+		s.downgrade(user)
+		user.StripeSubscriptionID = subscription
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReportOverage reports the storage active subscribers use above their
+// max_storage, in whole gigabytes, to the STRIPE_OVERAGE_METER meter once
+// per BILLING_USAGE_INTERVAL. The meter sums the reports, so usage is
+// billed in gigabyte-periods.
+func (s *BillingService) ReportOverage(ctx context.Context) error {
+	users, err := s.userRepo.FindByBillingStatus(ctx, model.BillingActive)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	period := now.Truncate(s.usagePeriod).Unix()
+	for i := range users {
+		user := &users[i]
+		if user.StripeCustomerID == "" || s.users.planOf(user).OverageStorage == 0 {
+			continue
+		}
+		used, err := s.fileRepo.GetTotalSizeByUserID(ctx, user.ID)
+		if err != nil {
+			return err
+		}
+		over := used - user.MaxStorage
+		if over <= 0 {
+			continue
+		}
+		gigabytes := (over + 1<<30 - 1) >> 30
+		identifier := fmt.Sprintf("overage-%d-%d", user.ID, period)
+		if err := s.client.ReportUsage(ctx, s.overageMeter, user.StripeCustomerID, gigabytes, now, identifier); err != nil {
+			log.Printf("[WARN] Failed to report overage of user %d: %v", user.ID, err)
+		}
+	}
+	return nil
+}
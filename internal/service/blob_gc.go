@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/metrics"
+	"storage-service/internal/repository"
+	"strings"
+	"time"
+)
+
+// blobSweepBatch is the number of paths looked up in the database at once
+const blobSweepBatch = 500
+
+// BlobCollector sweeps UPLOAD_PATH for stored files that no file, variant or
+// file version references anymore, e.g. left behind by failed deletes, and removes them.
+// Files younger than BLOB_GC_GRACE are kept, since an upload saves its
+// record only after the file is in place.
+type BlobCollector struct {
+	fileRepo    *repository.FileRepository
+	variantRepo *repository.VariantRepository
+	versionRepo *repository.VersionRepository
+	uploadPath  string
+	grace       time.Duration
+	// Configured directories that may live inside UPLOAD_PATH
+	skip map[string]bool
+}
+
+func NewBlobCollector(fileRepo *repository.FileRepository, variantRepo *repository.VariantRepository, versionRepo *repository.VersionRepository, cfg *config.Config) *BlobCollector {
+	skip := make(map[string]bool)
+	for _, dir := range []string{cfg.TempUploadPath, cfg.ReplicaPath, cfg.PreviousUploadPath, cfg.ImageProxyCachePath} {
+		if dir != "" {
+			skip[filepath.Clean(dir)] = true
+		}
+	}
+	return &BlobCollector{fileRepo: fileRepo, variantRepo: variantRepo, versionRepo: versionRepo, uploadPath: cfg.UploadPath, grace: cfg.BlobGCGrace, skip: skip}
+}
+
+// blobCandidate is a stored file, or an HLS stream directory identified by
+// its master playlist, that may be unreferenced
+type blobCandidate struct {
+	path   string // Path a record would reference
+	remove string // Path to remove when unreferenced
+	size   int64
+}
+
+// Sweep removes unreferenced blobs and records the reclaimed space in the
+// blob_gc metrics
+func (c *BlobCollector) Sweep(ctx context.Context) error {
+	cutoff := time.Now().Add(-c.grace)
+	var batch []blobCandidate
+	var removed, reclaimed int64
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		n, size, err := c.removeUnreferenced(ctx, batch)
+		removed, reclaimed = removed+n, reclaimed+size
+		batch = batch[:0]
+		return err
+	}
+
+	err := filepath.WalkDir(c.uploadPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if path == c.uploadPath {
+			return nil
+		}
+
+		name := entry.Name()
+		// Skip the temp directory and streams still being transcoded
+		if strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") || c.skip[filepath.Clean(path)] {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Descend into user and date directories
+		isStream := strings.HasSuffix(name, "_"+VariantHLS)
+		if entry.IsDir() && !isStream {
+			return nil
+		}
+
+		if info, err := entry.Info(); err == nil && info.ModTime().Before(cutoff) {
+			if !entry.IsDir() {
+				batch = append(batch, blobCandidate{path: path, remove: path, size: info.Size()})
+			} else if size, err := directorySize(path); err == nil {
+				batch = append(batch, blobCandidate{path: filepath.Join(path, hlsMasterPlaylist), remove: path, size: size})
+			}
+		}
+
+		if len(batch) >= blobSweepBatch {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if entry.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+
+	metrics.BlobGC.Add("runs", 1)
+	metrics.BlobGC.Add("removed_files", removed)
+	metrics.BlobGC.Add("reclaimed_bytes", reclaimed)
+	if removed > 0 {
+		log.Printf("Removed %d unreferenced blobs, reclaimed %d bytes", removed, reclaimed)
+	}
+	return err
+}
+
+// removeUnreferenced removes the candidates no file, variant or version stores
+func (c *BlobCollector) removeUnreferenced(ctx context.Context, batch []blobCandidate) (int64, int64, error) {
+	paths := make([]string, len(batch))
+	for i := range batch {
+		paths[i] = batch[i].path
+	}
+
+	referenced := make(map[string]bool, len(paths))
+	filePaths, err := c.fileRepo.FindStoredPaths(ctx, paths)
+	if err != nil {
+		return 0, 0, err
+	}
+	variantPaths, err := c.variantRepo.FindStoredPaths(ctx, paths)
+	if err != nil {
+		return 0, 0, err
+	}
+	versionPaths, err := c.versionRepo.FindStoredPaths(ctx, paths)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, path := range append(append(filePaths, variantPaths...), versionPaths...) {
+		referenced[path] = true
+	}
+
+	var removed, reclaimed int64
+	for _, candidate := range batch {
+		if referenced[candidate.path] {
+			continue
+		}
+		if err := os.RemoveAll(candidate.remove); err != nil {
+			log.Printf("[WARN] Failed to remove unreferenced blob %s: %v", candidate.remove, err)
+			continue
+		}
+		removed++
+		reclaimed += candidate.size
+	}
+	return removed, reclaimed, nil
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/storage"
+	"strings"
+)
+
+// BlobStore keeps stored files in the storage backend. Services read and
+// write files below UPLOAD_PATH; with a remote backend that directory is only
+// a cache: committed files are uploaded to the backend, and files missing on
+// disk are downloaded on first read. Instances then need no shared volume.
+// With the local backend UPLOAD_PATH is the storage itself and BlobStore has
+// nothing to do.
+type BlobStore struct {
+	backend    storage.Storage
+	uploadPath string
+	remote     bool
+	temp       *TempStore
+}
+
+func NewBlobStore(backend storage.Storage, cfg *config.Config) *BlobStore {
+	local, ok := backend.(*storage.Local)
+	return &BlobStore{
+		backend:    backend,
+		uploadPath: cfg.UploadPath,
+		remote:     !ok || filepath.Clean(local.Root()) != filepath.Clean(cfg.UploadPath),
+		temp:       NewTempStore(cfg),
+	}
+}
+
+// Remote reports whether files are stored outside UPLOAD_PATH
+func (b *BlobStore) Remote() bool {
+	return b.remote
+}
+
+// key returns the storage key of a file below UPLOAD_PATH
+func (b *BlobStore) key(localPath string) (string, error) {
+	rel, err := filepath.Rel(b.uploadPath, localPath)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not below the upload directory", localPath)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// Publish stores a file that was committed below UPLOAD_PATH
+func (b *BlobStore) Publish(ctx context.Context, localPath string) error {
+	if !b.remote {
+		return nil
+	}
+	key, err := b.key(localPath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := b.backend.Put(ctx, key, f, info.Size()); err != nil {
+		return fmt.Errorf("failed to store %s: %w", key, err)
+	}
+	return nil
+}
+
+// Fetch makes sure a stored file is on local disk, downloading it from the
+// backend when it isn't. Files the backend doesn't have are left missing.
+func (b *BlobStore) Fetch(ctx context.Context, localPath string) error {
+	if !b.remote {
+		return nil
+	}
+	if _, err := os.Stat(localPath); err == nil {
+		return nil
+	}
+	key, err := b.key(localPath)
+	if err != nil {
+		return nil
+	}
+	r, err := b.backend.Get(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", key, err)
+	}
+	defer r.Close()
+
+	dst, err := b.temp.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, contextReader{ctx, r}); err != nil {
+		b.temp.Discard(dst)
+		return fmt.Errorf("failed to fetch %s: %w", key, err)
+	}
+	return b.temp.Commit(dst, localPath)
+}
+
+// Remove deletes a stored file from the backend. The caller removes the
+// local copy.
+func (b *BlobStore) Remove(ctx context.Context, localPath string) error {
+	if !b.remote {
+		return nil
+	}
+	key, err := b.key(localPath)
+	if err != nil {
+		return err
+	}
+	return b.backend.Delete(ctx, key)
+}
+
+// Move stores a file that was moved below UPLOAD_PATH under its new path and
+// removes it from its old one
+func (b *BlobStore) Move(ctx context.Context, oldPath, newPath string) error {
+	if !b.remote || oldPath == newPath {
+		return nil
+	}
+	if err := b.Publish(ctx, newPath); err != nil {
+		return err
+	}
+	return b.Remove(ctx, oldPath)
+}
+
+// FetchUpload fetches a file served below /uploads, which may only be stored
+// compressed under its name with a compression suffix
+func (b *BlobStore) FetchUpload(ctx context.Context, localPath string) error {
+	if !b.remote {
+		return nil
+	}
+	candidates := []string{localPath}
+	for _, algorithm := range CompressionAlgorithms {
+		candidates = append(candidates, localPath+CompressionSuffix(algorithm))
+	}
+	for _, candidate := range candidates {
+		if err := b.Fetch(ctx, candidate); err != nil {
+			return err
+		}
+		if _, err := os.Stat(candidate); err == nil {
+			return nil
+		}
+	}
+	return nil
+}
+
+// removeVariant deletes the files of a variant from disk and the backend.
+// HLS renditions are only kept on local disk.
+func (b *BlobStore) removeVariant(ctx context.Context, variant *model.FileVariant) error {
+	if err := removeVariantFiles(variant); err != nil {
+		return err
+	}
+	if variant.Name == VariantHLS {
+		return nil
+	}
+	return b.Remove(ctx, variant.FilePath)
+}
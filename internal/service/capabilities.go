@@ -0,0 +1,79 @@
+package service
+
+// Capabilities describes which optional subsystems are enabled on this
+// deployment and their limits, so generic clients can adapt to it
+type Capabilities struct {
+	VirusScan   Capability            `json:"virus_scan"`
+	OCR         Capability            `json:"ocr"`
+	Transcoding TranscodingCapability `json:"transcoding"`
+	WebP        WebPCapability        `json:"webp"`
+	Tus         Capability            `json:"tus"`
+	S3Gateway   Capability            `json:"s3_gateway"`
+	// Maximum size in bytes per content family or MIME type, on top of the
+	// max_file_size of each user's plan
+	SizeLimits SizeLimits `json:"size_limits"`
+	// Processing profiles accepted by the profile field of image uploads
+	ImageProfiles []ImageProfile `json:"image_profiles"`
+}
+
+// Capability reports whether a subsystem without further settings is enabled
+type Capability struct {
+	Enabled bool `json:"enabled"`
+}
+
+// TranscodingCapability describes HLS transcoding of uploaded videos
+type TranscodingCapability struct {
+	Enabled bool `json:"enabled"`
+	// Video types that are transcoded
+	MimeTypes []string `json:"mime_types"`
+	// Length of HLS segments in seconds
+	SegmentDuration int `json:"segment_duration"`
+}
+
+// WebPCapability describes WebP output. Images are only converted to WebP by
+// processing profiles, so it is enabled when one of them produces WebP.
+type WebPCapability struct {
+	Enabled  bool     `json:"enabled"`
+	Lossless bool     `json:"lossless"`
+	Profiles []string `json:"profiles"`
+}
+
+// CapabilitiesService reports the capabilities of this deployment
+type CapabilitiesService struct {
+	files   *FileService
+	scans   *ScanService
+	streams *StreamService
+	s3      bool
+}
+
+func NewCapabilitiesService(files *FileService, scans *ScanService, streams *StreamService, s3Gateway bool) *CapabilitiesService {
+	return &CapabilitiesService{files: files, scans: scans, streams: streams, s3: s3Gateway}
+}
+
+// Get returns the capabilities. OCR and tus uploads are not part of this
+// build and always reported as disabled.
+func (s *CapabilitiesService) Get() *Capabilities {
+	profiles := s.files.imageProfiles.List()
+	webp := WebPCapability{Lossless: true, Profiles: []string{}}
+	for _, profile := range profiles {
+		if profile.Format == "webp" {
+			webp.Profiles = append(webp.Profiles, profile.Name)
+		}
+	}
+	webp.Enabled = len(webp.Profiles) > 0
+
+	transcoding := TranscodingCapability{Enabled: s.streams.Enabled(), MimeTypes: []string{}}
+	if transcoding.Enabled {
+		transcoding.MimeTypes = sortedKeys(transcodableVideoTypes)
+		transcoding.SegmentDuration = s.streams.segmentDuration
+	}
+
+	return &Capabilities{
+		VirusScan:     Capability{Enabled: s.scans.Enabled()},
+		Transcoding:   transcoding,
+		WebP:          webp,
+		S3Gateway:     Capability{Enabled: s.s3},
+		SizeLimits:    s.files.sizeLimits(),
+		ImageProfiles: profiles,
+	}
+}
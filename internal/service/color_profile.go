@@ -0,0 +1,435 @@
+package service
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/disintegration/imaging"
+)
+
+// Values of IMAGE_COLOR_MODE
+const (
+	// ColorModePreserve embeds the ICC profile of the source in re-encoded images
+	ColorModePreserve = "preserve"
+	// ColorModeSRGB converts images with an RGB profile to sRGB and stores
+	// them untagged, which every viewer shows the same way
+	ColorModeSRGB = "srgb"
+)
+
+// CheckColorMode validates IMAGE_COLOR_MODE
+func CheckColorMode(mode string) error {
+	if mode != ColorModePreserve && mode != ColorModeSRGB {
+		return fmt.Errorf("IMAGE_COLOR_MODE must be preserve or srgb, got %q", mode)
+	}
+	return nil
+}
+
+var (
+	jpegICCPrefix = []byte("ICC_PROFILE\x00")
+	pngSignature  = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// jpegICCChunkSize is the most profile data one APP2 segment holds
+const jpegICCChunkSize = 65535 - 2 - 14
+
+// colorHandling keeps the colors of images that are decoded and re-encoded.
+// The encoders of the standard library write no color profile, so without
+// it wide-gamut photos would be shown as sRGB and look washed out.
+type colorHandling struct {
+	toSRGB bool
+}
+
+func newColorHandling(mode string) colorHandling {
+	return colorHandling{toSRGB: mode == ColorModeSRGB}
+}
+
+// prepare returns the decoded image img of data ready for re-encoding and
+// the ICC profile to embed in the result, nil when the result is sRGB.
+// Profiles that can't be converted, like LUT-based ones, are kept.
+func (h colorHandling) prepare(img image.Image, data []byte, mimeType string) (image.Image, []byte) {
+	icc := iccProfile(data, mimeType)
+	// Gray and CMYK sources are decoded to RGB, their profiles don't apply
+	if len(icc) < 128 || string(icc[16:20]) != "RGB " {
+		return img, nil
+	}
+	if !h.toSRGB {
+		return img, icc
+	}
+	if strings.Contains(iccDescription(icc), "sRGB") {
+		return img, nil
+	}
+	transform, ok := newSRGBTransform(icc)
+	if !ok {
+		return img, icc
+	}
+	return transform.apply(img), nil
+}
+
+// iccProfile returns the ICC profile embedded in a JPEG or PNG, nil if it
+// has none
+func iccProfile(data []byte, mimeType string) []byte {
+	switch mimeType {
+	case "image/jpeg", "image/jpg":
+		return jpegICCProfile(data)
+	case "image/png":
+		return pngICCProfile(data)
+	}
+	return nil
+}
+
+// jpegICCProfile joins the APP2 segments a profile is split into
+func jpegICCProfile(data []byte) []byte {
+	chunks := make(map[byte][]byte)
+	jpegSegments(data, func(marker byte, segment []byte) bool {
+		if marker == 0xE2 && len(segment) > len(jpegICCPrefix)+2 && bytes.HasPrefix(segment, jpegICCPrefix) {
+			chunks[segment[len(jpegICCPrefix)]] = segment[len(jpegICCPrefix)+2:]
+		}
+		return true
+	})
+	if len(chunks) == 0 {
+		return nil
+	}
+	seqs := make([]int, 0, len(chunks))
+	for seq := range chunks {
+		seqs = append(seqs, int(seq))
+	}
+	sort.Ints(seqs)
+	var icc []byte
+	for _, seq := range seqs {
+		icc = append(icc, chunks[byte(seq)]...)
+	}
+	return icc
+}
+
+// pngICCProfile inflates the profile of an iCCP chunk
+func pngICCProfile(data []byte) []byte {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return nil
+	}
+	for _, chunk := range chunks {
+		if chunk.Type != "iCCP" {
+			continue
+		}
+		// Profile name, NUL, compression method, zlib stream
+		name := bytes.IndexByte(chunk.Data, 0)
+		if name < 0 || name+2 > len(chunk.Data) {
+			return nil
+		}
+		r, err := zlib.NewReader(bytes.NewReader(chunk.Data[name+2:]))
+		if err != nil {
+			return nil
+		}
+		defer r.Close()
+		icc, err := io.ReadAll(io.LimitReader(r, 16<<20))
+		if err != nil {
+			return nil
+		}
+		return icc
+	}
+	return nil
+}
+
+// embedICCProfile adds an ICC profile to an image encoded as JPEG, PNG or
+// lossless WebP. Other formats are returned unchanged.
+func embedICCProfile(encoded []byte, mimeType string, icc []byte) []byte {
+	if len(icc) == 0 {
+		return encoded
+	}
+	switch mimeType {
+	case "image/jpeg":
+		return embedJPEGProfile(encoded, icc)
+	case "image/png":
+		return embedPNGProfile(encoded, icc)
+	case "image/webp":
+		return embedWebPProfile(encoded, icc)
+	}
+	return encoded
+}
+
+// embedJPEGProfile inserts the profile as APP2 segments right after SOI
+func embedJPEGProfile(encoded, icc []byte) []byte {
+	if len(encoded) < 2 || encoded[0] != 0xFF || encoded[1] != 0xD8 {
+		return encoded
+	}
+	count := (len(icc) + jpegICCChunkSize - 1) / jpegICCChunkSize
+	if count > 255 {
+		return encoded
+	}
+	var buf bytes.Buffer
+	buf.Write(encoded[:2])
+	for i := 0; i < count; i++ {
+		chunk := icc[i*jpegICCChunkSize : min((i+1)*jpegICCChunkSize, len(icc))]
+		buf.Write([]byte{0xFF, 0xE2})
+		binary.Write(&buf, binary.BigEndian, uint16(2+len(jpegICCPrefix)+2+len(chunk)))
+		buf.Write(jpegICCPrefix)
+		buf.Write([]byte{byte(i + 1), byte(count)})
+		buf.Write(chunk)
+	}
+	buf.Write(encoded[2:])
+	return buf.Bytes()
+}
+
+// embedPNGProfile inserts an iCCP chunk after IHDR
+func embedPNGProfile(encoded, icc []byte) []byte {
+	// Signature, then the 13 byte IHDR chunk
+	const ihdrEnd = 8 + 12 + 13
+	if len(encoded) < ihdrEnd || !bytes.Equal(encoded[:8], pngSignature) || string(encoded[12:16]) != "IHDR" {
+		return encoded
+	}
+	var data bytes.Buffer
+	data.WriteString("ICC Profile\x00\x00")
+	w := zlib.NewWriter(&data)
+	w.Write(icc)
+	w.Close()
+
+	var buf bytes.Buffer
+	buf.Write(encoded[:ihdrEnd])
+	binary.Write(&buf, binary.BigEndian, uint32(data.Len()))
+	chunk := append([]byte("iCCP"), data.Bytes()...)
+	buf.Write(chunk)
+	binary.Write(&buf, binary.BigEndian, crc32.ChecksumIEEE(chunk))
+	buf.Write(encoded[ihdrEnd:])
+	return buf.Bytes()
+}
+
+// embedWebPProfile turns a simple lossless WebP into the extended format,
+// which can carry an ICCP chunk
+func embedWebPProfile(encoded, icc []byte) []byte {
+	if len(encoded) < 25 || string(encoded[:4]) != "RIFF" || string(encoded[8:12]) != "WEBP" ||
+		string(encoded[12:16]) != "VP8L" || encoded[20] != 0x2F {
+		return encoded
+	}
+	// The VP8L header packs width-1 and height-1 in 14 bits each, then
+	// whether alpha is used
+	bits := binary.LittleEndian.Uint32(encoded[21:25])
+	width, height := bits&0x3FFF, (bits>>14)&0x3FFF
+	flags := byte(0x20) // ICC profile
+	if bits>>28&1 == 1 {
+		flags |= 0x10
+	}
+
+	var chunks bytes.Buffer
+	chunks.WriteString("VP8X")
+	binary.Write(&chunks, binary.LittleEndian, uint32(10))
+	chunks.Write([]byte{flags, 0, 0, 0})
+	chunks.Write([]byte{byte(width), byte(width >> 8), byte(width >> 16)})
+	chunks.Write([]byte{byte(height), byte(height >> 8), byte(height >> 16)})
+	chunks.WriteString("ICCP")
+	binary.Write(&chunks, binary.LittleEndian, uint32(len(icc)))
+	chunks.Write(icc)
+	if len(icc)%2 == 1 {
+		chunks.WriteByte(0)
+	}
+	chunks.Write(encoded[12:])
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(4+chunks.Len()))
+	buf.WriteString("WEBP")
+	buf.Write(chunks.Bytes())
+	return buf.Bytes()
+}
+
+// iccTag returns the data of a tag of an ICC profile, nil if it is missing
+func iccTag(icc []byte, signature string) []byte {
+	if len(icc) < 132 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(icc[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(icc); i++ {
+		entry := icc[132+12*i:]
+		if string(entry[:4]) != signature {
+			continue
+		}
+		offset, size := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset+size > len(icc) {
+			return nil
+		}
+		return icc[offset : offset+size]
+	}
+	return nil
+}
+
+// iccDescription returns the name of an ICC profile, e.g. "Display P3"
+func iccDescription(icc []byte) string {
+	desc := iccTag(icc, "desc")
+	if len(desc) < 12 {
+		return ""
+	}
+	switch string(desc[:4]) {
+	case "desc":
+		// ICC v2: ASCII count and text
+		n := int(binary.BigEndian.Uint32(desc[8:]))
+		if n <= 0 || 12+n > len(desc) {
+			return ""
+		}
+		return strings.TrimRight(string(desc[12:12+n]), "\x00")
+	case "mluc":
+		// ICC v4: the first of the localized UTF-16 records
+		if len(desc) < 28 || binary.BigEndian.Uint32(desc[8:]) == 0 {
+			return ""
+		}
+		n, offset := int(binary.BigEndian.Uint32(desc[20:])), int(binary.BigEndian.Uint32(desc[24:]))
+		if offset+n > len(desc) || n%2 != 0 {
+			return ""
+		}
+		units := make([]uint16, n/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(desc[offset+2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+// xyzD50ToSRGB converts linear XYZ relative to D50, the profile connection
+// space, to linear sRGB (the inverse of the Bradford-adapted sRGB primaries)
+var xyzD50ToSRGB = [3][3]float64{
+	{3.1338561, -1.6168667, -0.4906146},
+	{-0.9787684, 1.9161415, 0.0334540},
+	{0.0719453, -0.2289914, 1.4052427},
+}
+
+// srgbTransform converts pixels described by a matrix/TRC RGB profile, like
+// Display P3 or Adobe RGB, to sRGB
+type srgbTransform struct {
+	curves [3][256]float64 // per channel: 8-bit source value to linear light
+	matrix [3][3]float64   // linear source RGB to linear sRGB
+}
+
+func newSRGBTransform(icc []byte) (*srgbTransform, bool) {
+	if string(icc[20:24]) != "XYZ " {
+		return nil, false
+	}
+	t := &srgbTransform{}
+	var toXYZ [3][3]float64
+	for c, name := range []string{"r", "g", "b"} {
+		xyz := iccTag(icc, name+"XYZ")
+		if len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, false
+		}
+		for row := 0; row < 3; row++ {
+			toXYZ[row][c] = s15Fixed16(xyz[8+4*row:])
+		}
+		curve, ok := iccCurve(iccTag(icc, name+"TRC"))
+		if !ok {
+			return nil, false
+		}
+		for v := range t.curves[c] {
+			t.curves[c][v] = curve(float64(v) / 255)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				t.matrix[i][j] += xyzD50ToSRGB[i][k] * toXYZ[k][j]
+			}
+		}
+	}
+	return t, true
+}
+
+// srgbEncodeSteps is the resolution of the table encoding linear light as sRGB
+const srgbEncodeSteps = 4096
+
+func (t *srgbTransform) apply(img image.Image) *image.NRGBA {
+	var encode [srgbEncodeSteps + 1]uint8
+	for i := range encode {
+		v := float64(i) / srgbEncodeSteps
+		if v <= 0.0031308 {
+			v *= 12.92
+		} else {
+			v = 1.055*math.Pow(v, 1/2.4) - 0.055
+		}
+		encode[i] = uint8(math.Round(v * 255))
+	}
+
+	out := imaging.Clone(img)
+	for i := 0; i+3 < len(out.Pix); i += 4 {
+		r, g, b := t.curves[0][out.Pix[i]], t.curves[1][out.Pix[i+1]], t.curves[2][out.Pix[i+2]]
+		for c := 0; c < 3; c++ {
+			v := t.matrix[c][0]*r + t.matrix[c][1]*g + t.matrix[c][2]*b
+			out.Pix[i+c] = encode[int(math.Round(min(max(v, 0), 1)*srgbEncodeSteps))]
+		}
+	}
+	return out
+}
+
+// iccCurve parses a curv or para tone curve to a function from encoded to
+// linear values
+func iccCurve(tag []byte) (func(float64) float64, bool) {
+	if len(tag) < 12 {
+		return nil, false
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+2*n > len(tag) {
+			return nil, false
+		}
+		switch n {
+		case 0:
+			return func(x float64) float64 { return x }, true
+		case 1:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, true
+		}
+		table := make([]float64, n)
+		for i := range table {
+			table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+		}
+		return func(x float64) float64 {
+			pos := x * float64(n-1)
+			i := int(pos)
+			if i >= n-1 {
+				return table[n-1]
+			}
+			return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+		}, true
+	case "para":
+		counts := []int{1, 3, 4, 5, 7}
+		kind := int(binary.BigEndian.Uint16(tag[8:]))
+		if kind >= len(counts) || 12+4*counts[kind] > len(tag) {
+			return nil, false
+		}
+		// g, a, b, c, d, e, f; unused ones stay zero
+		var p [7]float64
+		for i := 0; i < counts[kind]; i++ {
+			p[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+		switch kind {
+		case 0:
+			return func(x float64) float64 { return math.Pow(x, g) }, true
+		case 1, 2:
+			return func(x float64) float64 {
+				if a != 0 && x >= -b/a {
+					return math.Pow(a*x+b, g) + c
+				}
+				return c
+			}, true
+		default:
+			return func(x float64) float64 {
+				if x >= d {
+					return math.Pow(a*x+b, g) + e
+				}
+				return c*x + f
+			}, true
+		}
+	}
+	return nil, false
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
@@ -0,0 +1,337 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobComplianceExport copies a snapshot of a user's originals and their
+// metadata to COMPLIANCE_EXPORT_PATH
+const JobComplianceExport = "compliance_export"
+
+// Names inside a compliance snapshot
+const (
+	complianceFilesDir  = "files"
+	complianceChecksums = "SHA256SUMS"
+	complianceManifest  = "manifest.json"
+)
+
+// ComplianceExportParams selects the user to export. SnapshotAt and Dir are
+// set when the export is queued: files uploaded after SnapshotAt are left
+// out, and the snapshot is written to Dir below COMPLIANCE_EXPORT_PATH.
+type ComplianceExportParams struct {
+	UserID     uint      `json:"user_id" binding:"required"`
+	SnapshotAt time.Time `json:"snapshot_at"`
+	Dir        string    `json:"dir"`
+}
+
+// complianceRecord is the metadata stored next to each exported file
+type complianceRecord struct {
+	File *model.File `json:"file"`
+	// Path of the content relative to the snapshot
+	Path   string `json:"path"`
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	// Verified reports whether the content matches the checksum recorded at
+	// upload; files stored before checksums were recorded aren't verified
+	Verified bool `json:"verified"`
+}
+
+// complianceSummary is the manifest.json of a finished snapshot
+type complianceSummary struct {
+	UserID     uint      `json:"user_id"`
+	SnapshotAt time.Time `json:"snapshot_at"`
+	JobID      uint      `json:"job_id"`
+	Files      int       `json:"files"`
+	TotalSize  int64     `json:"total_size"`
+	Unverified int       `json:"unverified"`
+	// Checksum of SHA256SUMS, which covers every file of the snapshot
+	ChecksumsSHA256 string `json:"checksums_sha256"`
+}
+
+// ComplianceExportService writes point-in-time snapshots of a user's files
+// for legal discovery. Only originals are exported, as uploaded, each with
+// its metadata; a SHA256SUMS manifest and a summary are written last.
+// COMPLIANCE_EXPORT_PATH is treated as write-once: files are created
+// read-only and never replaced, so a resumed export continues after the
+// files it already wrote.
+type ComplianceExportService struct {
+	fileRepo    *repository.FileRepository
+	files       *FileService
+	userService *UserService
+	jobs        *JobService
+	exportPath  string
+}
+
+func NewComplianceExportService(fileRepo *repository.FileRepository, files *FileService, userService *UserService, jobs *JobService, cfg *config.Config) *ComplianceExportService {
+	s := &ComplianceExportService{
+		fileRepo:    fileRepo,
+		files:       files,
+		userService: userService,
+		jobs:        jobs,
+		exportPath:  cfg.ComplianceExportPath,
+	}
+	jobs.Register(JobComplianceExport, s.step)
+	return s
+}
+
+// Start creates the snapshot directory and queues the export of a user's
+// files as they are now
+func (s *ComplianceExportService) Start(ctx context.Context, params ComplianceExportParams, createdBy uint) (*model.Job, error) {
+	if s.exportPath == "" {
+		return nil, ErrComplianceExportDisabled
+	}
+	if _, err := s.userService.GetUserByID(ctx, params.UserID); err != nil {
+		return nil, err
+	}
+
+	params.SnapshotAt = time.Now().UTC()
+	params.Dir = fmt.Sprintf("user-%d-%s", params.UserID, params.SnapshotAt.Format("20060102T150405Z"))
+	if err := os.Mkdir(filepath.Join(s.exportPath, params.Dir), 0755); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return nil, ErrComplianceExportExists
+		}
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	return s.jobs.Enqueue(ctx, JobComplianceExport, params, createdBy)
+}
+
+func (s *ComplianceExportService) step(ctx context.Context, job *model.Job) (bool, error) {
+	if s.exportPath == "" {
+		return false, ErrComplianceExportDisabled
+	}
+
+	var params ComplianceExportParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+	if params.Dir == "" || filepath.Base(params.Dir) != params.Dir {
+		return false, fmt.Errorf("invalid snapshot directory %q", params.Dir)
+	}
+	snapshot := filepath.Join(s.exportPath, params.Dir)
+
+	filter := repository.FileFilter{UserIDs: []uint{params.UserID}, CreatedBefore: params.SnapshotAt}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err := s.exportFile(ctx, snapshot, &files[i]); err != nil {
+			log.Printf("[WARN] Failed to export file %d to %s: %v", files[i].ID, snapshot, err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = files[i].ID
+	}
+
+	if len(files) < jobBatchSize {
+		if err := s.finish(snapshot, params, job.ID); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// exportFile writes the content of a file as uploaded and its metadata
+// record. Files exported before a resume are left as they are.
+func (s *ComplianceExportService) exportFile(ctx context.Context, snapshot string, file *model.File) error {
+	id := strconv.FormatUint(uint64(file.ID), 10)
+	recordPath := filepath.Join(snapshot, complianceFilesDir, id+".json")
+	if _, err := os.Stat(recordPath); err == nil {
+		return nil
+	}
+
+	name := filepath.Base(file.OriginalName)
+	if name == "." || name == string(filepath.Separator) || strings.HasPrefix(name, ".") {
+		name = file.Filename
+	}
+	rel := filepath.ToSlash(filepath.Join(complianceFilesDir, id, name))
+	contentPath := filepath.Join(snapshot, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(contentPath), 0755); err != nil {
+		return err
+	}
+
+	sum, size, err := s.writeContent(ctx, contentPath, file)
+	if errors.Is(err, fs.ErrExist) {
+		// Written before the export was interrupted
+		sum, size, err = storedChecksum(contentPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	record, err := json.MarshalIndent(complianceRecord{
+		File:     file,
+		Path:     rel,
+		SHA256:   sum,
+		Size:     size,
+		Verified: file.SHA256 != "" && file.SHA256 == sum,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, _, err := writeOnce(recordPath, bytes.NewReader(record)); err != nil {
+		return err
+	}
+	if file.SHA256 != "" && file.SHA256 != sum {
+		return fmt.Errorf("content checksum %s does not match the recorded %s", sum, file.SHA256)
+	}
+	return nil
+}
+
+// writeContent copies the content of a file as uploaded to target
+func (s *ComplianceExportService) writeContent(ctx context.Context, target string, file *model.File) (string, int64, error) {
+	path, err := s.files.Locate(ctx, file)
+	if err != nil {
+		return "", 0, err
+	}
+	r, err := OpenStored(path, file.Compression)
+	if err != nil {
+		return "", 0, err
+	}
+	defer r.Close()
+	return writeOnce(target, contextReader{ctx, r})
+}
+
+// finish writes SHA256SUMS, covering every content and metadata file of the
+// snapshot, and the summary. They are only written once all files are.
+func (s *ComplianceExportService) finish(snapshot string, params ComplianceExportParams, jobID uint) error {
+	manifestPath := filepath.Join(snapshot, complianceManifest)
+	if _, err := os.Stat(manifestPath); err == nil {
+		return nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(snapshot, complianceFilesDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var records []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") {
+			records = append(records, entry.Name())
+		}
+	}
+	// Numeric order, the order files were exported in
+	sort.Slice(records, func(i, j int) bool {
+		a, _ := strconv.ParseUint(strings.TrimSuffix(records[i], ".json"), 10, 64)
+		b, _ := strconv.ParseUint(strings.TrimSuffix(records[j], ".json"), 10, 64)
+		return a < b
+	})
+
+	summary := complianceSummary{UserID: params.UserID, SnapshotAt: params.SnapshotAt, JobID: jobID}
+	var checksums strings.Builder
+	for _, name := range records {
+		recordPath := filepath.Join(snapshot, complianceFilesDir, name)
+		data, err := os.ReadFile(recordPath)
+		if err != nil {
+			return err
+		}
+		var record complianceRecord
+		if err := json.Unmarshal(data, &record); err != nil {
+			return fmt.Errorf("failed to read %s: %w", recordPath, err)
+		}
+		recordSum := sha256.Sum256(data)
+		fmt.Fprintf(&checksums, "%s  %s\n", record.SHA256, record.Path)
+		fmt.Fprintf(&checksums, "%s  %s/%s\n", hex.EncodeToString(recordSum[:]), complianceFilesDir, name)
+
+		summary.Files++
+		summary.TotalSize += record.Size
+		if !record.Verified {
+			summary.Unverified++
+		}
+	}
+
+	checksumsPath := filepath.Join(snapshot, complianceChecksums)
+	sum, _, err := writeOnce(checksumsPath, strings.NewReader(checksums.String()))
+	if errors.Is(err, fs.ErrExist) {
+		sum, _, err = storedChecksum(checksumsPath)
+	}
+	if err != nil {
+		return err
+	}
+	summary.ChecksumsSHA256 = sum
+
+	manifest, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, _, err = writeOnce(manifestPath, bytes.NewReader(manifest))
+	return err
+}
+
+// writeOnce creates a read-only file with the content of r, failing with
+// fs.ErrExist instead of replacing a file that is already there. The content
+// is written to a hidden file first, so target never holds a partial copy.
+func writeOnce(target string, r io.Reader) (string, int64, error) {
+	if _, err := os.Lstat(target); err == nil {
+		return "", 0, fs.ErrExist
+	}
+	f, err := os.CreateTemp(filepath.Dir(target), ".partial-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(f.Name())
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(f, hash), r)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0444)
+	}
+	if err != nil {
+		return "", 0, err
+	}
+	// Unlike a rename, a link never replaces an existing file
+	if err := os.Link(f.Name(), target); err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), size, nil
+}
+
+// storedChecksum returns the hex SHA-256 and size of a file written earlier
+func storedChecksum(path string) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	sum, err := fileChecksum(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(sum[:]), info.Size(), nil
+}
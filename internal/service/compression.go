@@ -0,0 +1,202 @@
+package service
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"storage-service/internal/model"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms for text-like files at rest
+const (
+	CompressionGzip = "gzip"
+	CompressionZstd = "zstd"
+)
+
+// CompressionAlgorithms lists the supported algorithms
+var CompressionAlgorithms = []string{CompressionGzip, CompressionZstd}
+
+// compressionSuffixes are appended to the paths of compressed files, so the
+// raw bytes are never served under the original name
+var compressionSuffixes = map[string]string{
+	CompressionGzip: ".gz",
+	CompressionZstd: ".zst",
+}
+
+// compressibleTypes are text-like types that are not text/*
+var compressibleTypes = map[string]bool{
+	"application/json":       true,
+	"application/x-ndjson":   true,
+	"application/xml":        true,
+	"application/x-yaml":     true,
+	"application/yaml":       true,
+	"application/javascript": true,
+	"application/sql":        true,
+	"application/csv":        true,
+}
+
+// Compressor decides which uploads are stored compressed
+type Compressor struct {
+	algorithm string
+	minSize   int64
+}
+
+// NewCompressor returns the compressor of an algorithm and minimum size
+// from ParseCompression
+func NewCompressor(algorithm string, minSize int64) *Compressor {
+	return &Compressor{algorithm: algorithm, minSize: minSize}
+}
+
+// ParseCompression validates COMPRESSION and COMPRESSION_MIN_SIZE; an empty
+// algorithm disables compression
+func ParseCompression(algorithm, minSize string) (string, int64, error) {
+	algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+	if algorithm == "" || algorithm == "none" {
+		return "", 0, nil
+	}
+	if _, ok := compressionSuffixes[algorithm]; !ok {
+		return "", 0, fmt.Errorf("COMPRESSION must be gzip, zstd or empty, got %q", algorithm)
+	}
+	size, err := ParseByteSize(minSize)
+	if err != nil {
+		return "", 0, fmt.Errorf("COMPRESSION_MIN_SIZE: %w", err)
+	}
+	return algorithm, size, nil
+}
+
+// Algorithm returns the algorithm for a new file of mimeType and size, or ""
+// to store it as is
+func (c *Compressor) Algorithm(mimeType string, size int64) string {
+	if c == nil || c.algorithm == "" || size < c.minSize {
+		return ""
+	}
+	mimeType, _, _ = strings.Cut(mimeType, ";")
+	mimeType = strings.TrimSpace(mimeType)
+	if strings.HasPrefix(mimeType, "text/") || compressibleTypes[mimeType] {
+		return c.algorithm
+	}
+	return ""
+}
+
+// CompressionSuffix returns the path suffix of files compressed with algorithm
+func CompressionSuffix(algorithm string) string {
+	return compressionSuffixes[algorithm]
+}
+
+// OpenStored opens a file on disk, decompressing it when algorithm is set
+func OpenStored(path, algorithm string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	switch algorithm {
+	case "":
+		return f, nil
+	case CompressionGzip:
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &decompressReader{Reader: zr, close: zr.Close, file: f}, nil
+	case CompressionZstd:
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &decompressReader{Reader: zr, close: func() error { zr.Close(); return nil }, file: f}, nil
+	}
+	f.Close()
+	return nil, fmt.Errorf("unknown compression %q", algorithm)
+}
+
+// OpenFileContent opens the content of a file as uploaded
+func OpenFileContent(file *model.File) (io.ReadCloser, error) {
+	return OpenStored(file.FilePath, file.Compression)
+}
+
+type decompressReader struct {
+	io.Reader
+	close func() error
+	file  *os.File
+}
+
+func (r *decompressReader) Close() error {
+	err := r.close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// contextReader fails reads once ctx is done, so a copy stops when the
+// request is cancelled or times out
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// writeStored copies src through a temp file to finalPath, compressed with
+// algorithm when set, and returns the number of bytes written to disk. The
+// uncompressed content is also written to sinks in the same pass, e.g. a
+// contentDigest. The copy stops when ctx is done.
+func writeStored(ctx context.Context, temp *TempStore, finalPath, algorithm string, src io.Reader, sinks ...io.Writer) (int64, error) {
+	src = contextReader{ctx, src}
+
+	dst, err := temp.Create()
+	if err != nil {
+		return 0, err
+	}
+
+	var w io.WriteCloser
+	switch algorithm {
+	case "":
+		w = nopWriteCloser{dst}
+	case CompressionGzip:
+		w = gzip.NewWriter(dst)
+	case CompressionZstd:
+		w, err = zstd.NewWriter(dst)
+	default:
+		err = fmt.Errorf("unknown compression %q", algorithm)
+	}
+	if err != nil {
+		temp.Discard(dst)
+		return 0, err
+	}
+
+	_, err = io.Copy(io.MultiWriter(append([]io.Writer{w}, sinks...)...), src)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = dst.Stat()
+	}
+	if err != nil {
+		temp.Discard(dst)
+		return 0, err
+	}
+	if err := temp.Commit(dst, finalPath); err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,270 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"storage-service/internal/config"
+	"storage-service/internal/mail"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Password length limits; bcrypt ignores everything past 72 bytes
+const (
+	minPasswordLength = 8
+	maxPasswordLength = 72
+)
+
+// dummyPasswordHash is compared against when a login names an unknown user,
+// so the response time does not reveal which emails are registered
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("storage-service"), bcrypt.DefaultCost)
+
+// CredentialService handles password login, password resets by email and
+// changes of password or email. Every change replaces the user's API keys
+// with a new one and ends all sessions, which signs out all other clients.
+type CredentialService struct {
+	userRepo  *repository.UserRepository
+	resetRepo *repository.PasswordResetRepository
+	sessions  *SessionService
+	keys      *APIKeyService
+	mailer    mail.Mailer
+	resetURL  string
+	resetTTL  time.Duration
+}
+
+func NewCredentialService(userRepo *repository.UserRepository, resetRepo *repository.PasswordResetRepository, sessions *SessionService, keys *APIKeyService, mailer mail.Mailer, cfg *config.Config) *CredentialService {
+	resetURL := cfg.PasswordResetURL
+	if resetURL == "" {
+		resetURL = cfg.StorageURL + "/reset-password"
+	}
+
+	return &CredentialService{
+		userRepo:  userRepo,
+		resetRepo: resetRepo,
+		sessions:  sessions,
+		keys:      keys,
+		mailer:    mailer,
+		resetURL:  resetURL,
+		resetTTL:  cfg.PasswordResetTTL,
+	}
+}
+
+// Login returns the user when the email and password match
+func (s *CredentialService) Login(ctx context.Context, email, password string) (*model.User, error) {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		_ = bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !user.HasPassword() || !checkPassword(user, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// ChangePassword sets a new password. The current password is required
+// unless the account has none yet.
+func (s *CredentialService) ChangePassword(ctx context.Context, userID uint, currentPassword, newPassword string) (*model.User, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.HasPassword() && !checkPassword(user, currentPassword) {
+		return nil, ErrWrongPassword
+	}
+	if err := s.setPassword(ctx, user, newPassword); err != nil {
+		return nil, err
+	}
+
+	s.notify(user.Email, "Your password was changed",
+		"The password of your storage account was changed. If this wasn't you, reset your password now.")
+	return user, nil
+}
+
+// ChangeEmail moves the account to a new email address after checking the
+// current password. The old address is told about the change.
+func (s *CredentialService) ChangeEmail(ctx context.Context, userID uint, currentPassword, email string) (*model.User, error) {
+	user, err := s.findUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !user.HasPassword() {
+		return nil, ErrPasswordNotSet
+	}
+	if !checkPassword(user, currentPassword) {
+		return nil, ErrWrongPassword
+	}
+	if email == user.Email {
+		return user, nil
+	}
+
+	_, err = s.userRepo.FindByEmail(ctx, email)
+	if err == nil {
+		return nil, ErrEmailRegistered
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	oldEmail := user.Email
+	user.Email = email
+	if err := s.invalidateSessions(ctx, user); err != nil {
+		return nil, err
+	}
+
+	s.notify(oldEmail, "Your email address was changed",
+		fmt.Sprintf("The email address of your storage account was changed to %s. If this wasn't you, contact support.", email))
+	return user, nil
+}
+
+// ForgotPassword emails a reset link. Unknown emails are ignored without an
+// error so the endpoint cannot be used to find registered addresses.
+func (s *CredentialService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.userRepo.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	token := base64.RawURLEncoding.EncodeToString(raw)
+
+	reset := &model.PasswordReset{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(s.resetTTL),
+	}
+	if err := s.resetRepo.Create(ctx, reset); err != nil {
+		return fmt.Errorf("failed to create password reset: %w", err)
+	}
+
+	link := s.resetURL + "?token=" + url.QueryEscape(token)
+	body := fmt.Sprintf("Someone asked to reset the password of your storage account.\n\n"+
+		"Open this link within %s to choose a new password:\n%s\n\n"+
+		"If this wasn't you, ignore this email.", s.resetTTL, link)
+	return s.mailer.Send(user.Email, "Reset your password", body)
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword. The
+// token works once; all other tokens of the user are discarded.
+func (s *CredentialService) ResetPassword(ctx context.Context, token, newPassword string) (*model.User, error) {
+	if err := validatePassword(newPassword); err != nil {
+		return nil, err
+	}
+
+	reset, err := s.resetRepo.FindByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidResetToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reset.UsedAt != nil || time.Now().After(reset.ExpiresAt) {
+		return nil, ErrInvalidResetToken
+	}
+
+	consumed, err := s.resetRepo.MarkUsed(ctx, reset.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !consumed {
+		return nil, ErrInvalidResetToken
+	}
+
+	user, err := s.findUser(ctx, reset.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.setPassword(ctx, user, newPassword); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// PruneResets deletes used and expired reset tokens
+func (s *CredentialService) PruneResets(ctx context.Context) error {
+	removed, err := s.resetRepo.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Pruned %d password reset tokens", removed)
+	}
+	return nil
+}
+
+func (s *CredentialService) setPassword(ctx context.Context, user *model.User, password string) error {
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	user.PasswordHash = string(hash)
+	return s.invalidateSessions(ctx, user)
+}
+
+// invalidateSessions saves a credential change with a new API key in place
+// of all others, ends all sessions and discards outstanding reset tokens
+func (s *CredentialService) invalidateSessions(ctx context.Context, user *model.User) error {
+	err := s.keys.keyRepo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.Update(ctx, user); err != nil {
+			return err
+		}
+		return s.keys.IssueKey(ctx, user, true)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := s.sessions.RevokeAll(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+	if err := s.resetRepo.DeleteByUserID(ctx, user.ID); err != nil {
+		log.Printf("[WARN] Failed to delete password resets of user %d: %v", user.ID, err)
+	}
+	return nil
+}
+
+func (s *CredentialService) findUser(ctx context.Context, userID uint) (*model.User, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	return user, err
+}
+
+// notify sends a security notice; failures are logged, the change stands
+func (s *CredentialService) notify(to, subject, body string) {
+	if err := s.mailer.Send(to, subject, body); err != nil {
+		log.Printf("[WARN] Failed to send %q to %s: %v", subject, to, err)
+	}
+}
+
+func checkPassword(user *model.User, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return ErrInvalidPassword.WithArgs(minPasswordLength, maxPasswordLength)
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// DAVEntry is a resource of the WebDAV endpoint: a folder or a file. Name
+// is the last segment of its path.
+type DAVEntry struct {
+	Name string
+	// Folder is set for collections. Folders that only exist through their
+	// files have no ID or times; the root has an empty path.
+	Folder *model.Folder
+	File   *model.File
+}
+
+// DAVService maps WebDAV onto folders and files: collections are folders
+// and their members the subfolders and files in them, by original name. The
+// newest file wins when several have the same name, and a folder hides a
+// file of its name.
+type DAVService struct {
+	files         *FileService
+	fileRepo      *repository.FileRepository
+	folderRepo    *repository.FolderRepository
+	folderDeletes *FolderDeleteService
+}
+
+func NewDAVService(files *FileService, fileRepo *repository.FileRepository, folderRepo *repository.FolderRepository, folderDeletes *FolderDeleteService) *DAVService {
+	return &DAVService{files: files, fileRepo: fileRepo, folderRepo: folderRepo, folderDeletes: folderDeletes}
+}
+
+// Stat returns the resource at name, a slash-separated path, or
+// ErrFileNotFound
+func (s *DAVService) Stat(ctx context.Context, userID uint, name string) (*DAVEntry, error) {
+	folderPath, fileName := s.davPath(name)
+	if fileName == "" {
+		return &DAVEntry{Folder: &model.Folder{UserID: userID}}, nil
+	}
+
+	collection := model.CleanFolderPath(name)
+	folder, err := s.folderRepo.FindByPath(ctx, userID, collection)
+	if err == nil {
+		return &DAVEntry{Name: folder.Name, Folder: folder}, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	count, _, err := s.fileRepo.GetFolderStats(ctx, userID, collection)
+	if err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		parent, folderName := model.SplitFolderPath(collection)
+		return &DAVEntry{Name: folderName, Folder: &model.Folder{UserID: userID, Path: collection, Name: folderName, Parent: parent}}, nil
+	}
+
+	file, err := s.fileRepo.FindByName(ctx, userID, folderPath, fileName)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &DAVEntry{Name: file.OriginalName, File: file}, nil
+}
+
+// List returns the subfolders of a folder of userID, by name, followed by
+// its files
+func (s *DAVService) List(ctx context.Context, userID uint, folderPath string) ([]DAVEntry, error) {
+	stored, err := s.folderRepo.FindChildren(ctx, userID, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	// Folders of files saved bypassing the record have no row
+	paths, err := s.fileRepo.GetFoldersByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]DAVEntry, 0, len(stored))
+	seen := make(map[string]int, len(stored))
+	for i := range stored {
+		seen[stored[i].Name] = len(entries)
+		entries = append(entries, DAVEntry{Name: stored[i].Name, Folder: &stored[i]})
+	}
+	for _, p := range paths {
+		name := childFolder(folderPath, p)
+		if _, ok := seen[name]; name == "" || ok {
+			continue
+		}
+		seen[name] = len(entries)
+		folder := &model.Folder{UserID: userID, Path: strings.TrimPrefix(folderPath+"/"+name, "/"), Name: name, Parent: folderPath}
+		entries = append(entries, DAVEntry{Name: name, Folder: folder})
+	}
+
+	err = s.fileRepo.StreamByUserIDAndFolder(ctx, userID, folderPath, false, repository.ListFilter{}, "name", "asc", func(file *model.File) error {
+		i, ok := seen[file.OriginalName]
+		if !ok {
+			seen[file.OriginalName] = len(entries)
+			entries = append(entries, DAVEntry{Name: file.OriginalName, File: file})
+		} else if entries[i].File != nil {
+			// Files of the same name come oldest first
+			entries[i].File = file
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Mkdir creates the folder at name. Its parent has to exist.
+func (s *DAVService) Mkdir(ctx context.Context, userID uint, name string) error {
+	folderPath, folderName := s.davPath(name)
+	if folderName == "" {
+		return ErrFolderExists
+	}
+	if err := s.checkCollection(ctx, userID, folderPath); err != nil {
+		return err
+	}
+	_, err := s.files.CreateFolder(ctx, userID, model.CleanFolderPath(name))
+	return err
+}
+
+// CheckUploadAllowed checks a write of size bytes against the quota of
+// userID before it is received
+func (s *DAVService) CheckUploadAllowed(ctx context.Context, userID uint, size int64) error {
+	return s.files.userService.CheckUploadAllowed(ctx, userID, size)
+}
+
+// CheckPut fails unless a file can be written at name: its folder has to
+// exist and name may not be a folder
+func (s *DAVService) CheckPut(ctx context.Context, userID uint, name string) error {
+	folderPath, fileName := s.davPath(name)
+	if fileName == "" {
+		return ErrFolderExists
+	}
+	if err := s.checkCollection(ctx, userID, folderPath); err != nil {
+		return err
+	}
+	exists, err := s.files.folderExists(ctx, userID, model.CleanFolderPath(name))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return ErrFolderExists
+	}
+	return nil
+}
+
+// Put stores body as the file at name once CheckPut passes, see
+// FileService.putFile
+func (s *DAVService) Put(ctx context.Context, userID uint, name string, body io.Reader, source model.UploadSource) (*model.File, error) {
+	if err := s.CheckPut(ctx, userID, name); err != nil {
+		return nil, err
+	}
+	folderPath, fileName := s.davPath(name)
+	return s.files.putFile(ctx, userID, folderPath, fileName, "", -1, body, source)
+}
+
+// Remove deletes the file at name like DeleteFile, or starts deleting the
+// folder at name like FolderDeleteService.Start. Folders that need a
+// confirmation can't be deleted.
+func (s *DAVService) Remove(ctx context.Context, userID uint, name string) error {
+	entry, err := s.Stat(ctx, userID, name)
+	if err != nil {
+		return err
+	}
+	if entry.File != nil {
+		return s.files.DeleteFile(ctx, entry.File.ID, userID)
+	}
+	_, _, err = s.folderDeletes.Start(ctx, userID, entry.Folder.Path, "")
+	return err
+}
+
+// Rename moves the file or folder at oldName to newName, whose parent has
+// to exist. Files keep their extension, as when renamed through the API.
+func (s *DAVService) Rename(ctx context.Context, userID uint, oldName, newName string) error {
+	entry, err := s.Stat(ctx, userID, oldName)
+	if err != nil {
+		return err
+	}
+	folderPath, fileName := s.davPath(newName)
+	if fileName == "" {
+		return ErrFolderExists
+	}
+	if err := s.checkCollection(ctx, userID, folderPath); err != nil {
+		return err
+	}
+
+	if entry.Folder != nil {
+		if entry.Folder.Path == "" {
+			return ErrRootFolder
+		}
+		newPath, err := s.files.sanitizeFolderPath(newName)
+		if err != nil {
+			return err
+		}
+		if newPath == entry.Folder.Path || strings.HasPrefix(newPath, entry.Folder.Path+"/") {
+			return ErrInvalidFolderPath
+		}
+		exists, err := s.files.folderExists(ctx, userID, newPath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return ErrFolderExists
+		}
+		_, err = s.files.moveFolder(ctx, userID, entry.Folder.Path, newPath, "")
+		return err
+	}
+
+	file := entry.File
+	if file.FolderPath != folderPath {
+		if file, err = s.files.MoveFile(ctx, file.ID, userID, folderPath); err != nil {
+			return err
+		}
+	}
+	if file.OriginalName != fileName {
+		if _, err := s.files.RenameFile(ctx, file.ID, userID, fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkCollection fails with ErrFolderNotFound unless folderPath is the
+// root or a folder of userID
+func (s *DAVService) checkCollection(ctx context.Context, userID uint, folderPath string) error {
+	if folderPath == "" {
+		return nil
+	}
+	exists, err := s.files.folderExists(ctx, userID, folderPath)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrFolderNotFound
+	}
+	return nil
+}
+
+// davPath returns the folder of the resource at name and its last segment,
+// normalized like uploads are, or "" for the root
+func (s *DAVService) davPath(name string) (string, string) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", ""
+	}
+	dir, base := path.Split(name)
+	return model.CleanFolderPath(dir), s.files.filenamePolicy().Apply(s.files.sanitizeFilename(base))
+}
+
+// childFolder returns the name of the subfolder of parent that folderPath
+// is or lies below, "" when it lies elsewhere
+func childFolder(parent, folderPath string) string {
+	rest := folderPath
+	if parent != "" {
+		var ok bool
+		if rest, ok = strings.CutPrefix(folderPath, parent+"/"); !ok {
+			return ""
+		}
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return name
+}
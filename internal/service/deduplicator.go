@@ -0,0 +1,89 @@
+package service
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/metrics"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+)
+
+// dedupeCandidates bounds the stored copies tried as link targets, e.g. when
+// the oldest ones are on another volume
+const dedupeCandidates = 5
+
+// Deduplicator saves disk space when the same content is uploaded again by
+// replacing the new stored file with a hard link to an existing copy. Every
+// record keeps its own path, so deleting, quarantining or migrating one file
+// only affects its own link, and content updates write a new file through a
+// temp file and a rename instead of changing the shared one in place.
+type Deduplicator struct {
+	fileRepo *repository.FileRepository
+}
+
+func NewDeduplicator(fileRepo *repository.FileRepository, bus *events.Bus, cfg *config.Config) *Deduplicator {
+	d := &Deduplicator{fileRepo: fileRepo}
+	if cfg.DedupeHardLinks {
+		bus.Subscribe(events.FileCreated, d.onFileStored)
+		bus.Subscribe(events.FileUpdated, d.onFileStored)
+		bus.Subscribe(events.FileReplaced, d.onFileStored)
+	}
+	return d
+}
+
+func (d *Deduplicator) onFileStored(event events.Event) {
+	if _, err := d.Link(context.Background(), event.File); err != nil {
+		log.Printf("[WARN] Failed to deduplicate file %d: %v", event.File.ID, err)
+	}
+}
+
+// Link replaces the stored file of file with a hard link to another stored
+// copy of the same content and reports whether it did. Copies are matched by
+// SHA-256 and compression and must have the same size on disk.
+func (d *Deduplicator) Link(ctx context.Context, file *model.File) (bool, error) {
+	if file.SHA256 == "" {
+		return false, nil
+	}
+	stored, err := os.Stat(file.FilePath)
+	if err != nil {
+		return false, err
+	}
+
+	candidates, err := d.fileRepo.FindBySHA256(ctx, file.SHA256, file.Compression, file.ID, dedupeCandidates)
+	if err != nil {
+		return false, err
+	}
+	for i := range candidates {
+		if candidates[i].FilePath == file.FilePath {
+			continue
+		}
+		existing, err := os.Stat(candidates[i].FilePath)
+		if err != nil || !existing.Mode().IsRegular() || existing.Size() != stored.Size() {
+			continue
+		}
+		if os.SameFile(stored, existing) {
+			return false, nil
+		}
+
+		// Link next to the file and rename over it, so readers see either
+		// copy but never a missing file. Linking fails across volumes and
+		// once a copy has too many links; the next candidate may still work.
+		link := filepath.Join(filepath.Dir(file.FilePath), "."+filepath.Base(file.FilePath)+".link")
+		os.Remove(link)
+		if err := os.Link(candidates[i].FilePath, link); err != nil {
+			continue
+		}
+		if err := os.Rename(link, file.FilePath); err != nil {
+			os.Remove(link)
+			return false, err
+		}
+		metrics.Dedupe.Add("linked", 1)
+		metrics.Dedupe.Add("saved_bytes", stored.Size())
+		return true, nil
+	}
+	return false, nil
+}
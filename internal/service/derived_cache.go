@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"time"
+)
+
+const derivedCleanupBatchSize = 100
+
+// AssetUsage is the number and total size of one kind of derived asset
+type AssetUsage struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+// DerivedUsage is the disk used by assets derived from user files. It is not
+// counted against user quotas.
+type DerivedUsage struct {
+	Variants   AssetUsage `json:"variants"`
+	ProxyCache AssetUsage `json:"proxy_cache"`
+	TotalSize  int64      `json:"total_size"`
+	MaxSize    int64      `json:"max_size"`
+}
+
+// ParseDerivedCacheMaxSize parses the total size budget of derived assets.
+// Empty or "0" disables the limit.
+func ParseDerivedCacheMaxSize(maxSize string) (int64, error) {
+	if maxSize == "" || maxSize == "0" {
+		return 0, nil
+	}
+	size, err := ParseByteSize(maxSize)
+	if err != nil {
+		return 0, fmt.Errorf("DERIVED_CACHE_MAX_SIZE: %w", err)
+	}
+	return size, nil
+}
+
+// DerivedCache accounts for and evicts derived assets: image variants and
+// images cached by the proxy. Both can be rebuilt from their source.
+type DerivedCache struct {
+	variantRepo    *repository.VariantRepository
+	proxyCacheRepo *repository.ProxyCacheRepository
+	blobs          *BlobStore
+	maxSize        int64
+	variantTTL     time.Duration
+	proxyTTL       time.Duration
+}
+
+// NewDerivedCache returns the cache; maxSize is DERIVED_CACHE_MAX_SIZE, see
+// ParseDerivedCacheMaxSize
+func NewDerivedCache(variantRepo *repository.VariantRepository, proxyCacheRepo *repository.ProxyCacheRepository, blobs *BlobStore, maxSize int64, cfg *config.Config) *DerivedCache {
+	return &DerivedCache{
+		variantRepo:    variantRepo,
+		proxyCacheRepo: proxyCacheRepo,
+		blobs:          blobs,
+		maxSize:        maxSize,
+		variantTTL:     cfg.VariantCacheTTL,
+		proxyTTL:       cfg.ImageProxyCacheTTL,
+	}
+}
+
+func (c *DerivedCache) Usage(ctx context.Context) (*DerivedUsage, error) {
+	variantCount, variantSize, err := c.variantRepo.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	proxyCount, proxySize, err := c.proxyCacheRepo.Usage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DerivedUsage{
+		Variants:   AssetUsage{Count: variantCount, Size: variantSize},
+		ProxyCache: AssetUsage{Count: proxyCount, Size: proxySize},
+		TotalSize:  variantSize + proxySize,
+		MaxSize:    c.maxSize,
+	}, nil
+}
+
+// Cleanup removes expired proxy cache entries and variants unused for longer
+// than the variant TTL, then evicts the least recently used assets of either
+// kind until the total fits the size budget. Evicted variants are rebuilt by
+// the regeneration job.
+func (c *DerivedCache) Cleanup(ctx context.Context) error {
+	if err := c.removeExpiredProxyEntries(ctx); err != nil {
+		return err
+	}
+	if err := c.removeStaleVariants(ctx); err != nil {
+		return err
+	}
+	return c.evictToBudget(ctx)
+}
+
+func (c *DerivedCache) removeExpiredProxyEntries(ctx context.Context) error {
+	before := time.Now().Add(-c.proxyTTL)
+	for ctx.Err() == nil {
+		entries, err := c.proxyCacheRepo.FindCreatedBefore(ctx, before, derivedCleanupBatchSize)
+		if err != nil || len(entries) == 0 {
+			return err
+		}
+		for i := range entries {
+			if err := c.removeProxyEntry(ctx, &entries[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (c *DerivedCache) removeStaleVariants(ctx context.Context) error {
+	if c.variantTTL == 0 {
+		return nil
+	}
+
+	before := time.Now().Add(-c.variantTTL)
+	for ctx.Err() == nil {
+		variants, err := c.variantRepo.FindLeastRecentlyUsed(ctx, before, derivedCleanupBatchSize)
+		if err != nil || len(variants) == 0 {
+			return err
+		}
+		for i := range variants {
+			if err := c.removeVariant(ctx, &variants[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+// evictToBudget merges both kinds of assets by last access and removes the
+// oldest until the total size is within the budget
+func (c *DerivedCache) evictToBudget(ctx context.Context) error {
+	if c.maxSize == 0 {
+		return nil
+	}
+
+	usage, err := c.Usage(ctx)
+	if err != nil {
+		return err
+	}
+
+	total := usage.TotalSize
+	now := time.Now()
+	for total > c.maxSize && ctx.Err() == nil {
+		variants, err := c.variantRepo.FindLeastRecentlyUsed(ctx, now, derivedCleanupBatchSize)
+		if err != nil {
+			return err
+		}
+		entries, err := c.proxyCacheRepo.FindLeastRecentlyUsed(ctx, now, derivedCleanupBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(variants) == 0 && len(entries) == 0 {
+			return nil
+		}
+
+		v, e := 0, 0
+		for total > c.maxSize {
+			if v == len(variants) && e == len(entries) {
+				break
+			}
+			// A full batch may continue beyond its end, so fetch again before
+			// comparing the other kind against assets not loaded yet
+			if (v == len(variants) && v == derivedCleanupBatchSize) || (e == len(entries) && e == derivedCleanupBatchSize) {
+				break
+			}
+
+			if e == len(entries) || (v < len(variants) && variantLastAccess(&variants[v]).Before(entries[e].LastAccessedAt)) {
+				if err := c.removeVariant(ctx, &variants[v]); err != nil {
+					return err
+				}
+				total -= variants[v].FileSize
+				v++
+			} else {
+				if err := c.removeProxyEntry(ctx, &entries[e]); err != nil {
+					return err
+				}
+				total -= entries[e].FileSize
+				e++
+			}
+		}
+	}
+	return ctx.Err()
+}
+
+func (c *DerivedCache) removeVariant(ctx context.Context, variant *model.FileVariant) error {
+	if err := c.variantRepo.Delete(ctx, variant); err != nil {
+		return fmt.Errorf("failed to delete variant %d: %w", variant.ID, err)
+	}
+	if err := c.blobs.removeVariant(ctx, variant); err != nil {
+		log.Printf("[WARN] Failed to remove variant %s: %v", variant.FilePath, err)
+	}
+	return nil
+}
+
+func (c *DerivedCache) removeProxyEntry(ctx context.Context, entry *model.ProxyCacheEntry) error {
+	if err := c.proxyCacheRepo.Delete(ctx, entry); err != nil {
+		return fmt.Errorf("failed to delete proxy cache entry %d: %w", entry.ID, err)
+	}
+	if err := os.Remove(entry.FilePath); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Failed to remove cached image %s: %v", entry.FilePath, err)
+	}
+	return nil
+}
+
+func variantLastAccess(variant *model.FileVariant) time.Time {
+	if variant.LastAccessedAt.IsZero() {
+		return variant.UpdatedAt
+	}
+	return variant.LastAccessedAt
+}
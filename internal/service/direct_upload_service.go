@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/coord"
+	"storage-service/internal/detect"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/h2non/filetype"
+	"gorm.io/gorm"
+)
+
+// JobProcessImages optimizes images that were uploaded directly
+const JobProcessImages = "process_images"
+
+// ProcessImagesParams selects the images a processing job optimizes
+type ProcessImagesParams struct {
+	FileIDs []uint `json:"file_ids"`
+	Profile string `json:"profile,omitempty"`
+}
+
+// DirectUploadRequest describes an image the client is about to upload
+type DirectUploadRequest struct {
+	Filename    string
+	Size        int64
+	ContentType string
+	FolderPath  string
+	Profile     string
+	// Label names the API key's account that asked for the URL, see
+	// model.UploadSource
+	Label string
+}
+
+// DirectUpload is an upload URL the client sends the original image to
+type DirectUpload struct {
+	UploadURL string    `json:"upload_url"`
+	Method    string    `json:"method"`
+	MaxSize   int64     `json:"max_size"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// directUploadGrant is what an upload URL allows, kept in the shared store
+type directUploadGrant struct {
+	UserID     uint   `json:"user_id"`
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	MimeType   string `json:"mime_type"`
+	FolderPath string `json:"folder_path"`
+	Profile    string `json:"profile"`
+	Label      string `json:"label,omitempty"`
+}
+
+// DirectUploadService splits image uploads in two: the client sends the
+// original to a short-lived upload URL, which stores it as is, and processing
+// runs later as a background job. Request handlers never decode or re-encode
+// large photos.
+type DirectUploadService struct {
+	fileRepo   *repository.FileRepository
+	images     *ImageService
+	jobs       *JobService
+	store      coord.Store
+	bus        *events.Bus
+	uploadPath string
+	storageURL string
+	ttl        time.Duration
+}
+
+func NewDirectUploadService(fileRepo *repository.FileRepository, images *ImageService, jobs *JobService, store coord.Store, bus *events.Bus, cfg *config.Config) *DirectUploadService {
+	s := &DirectUploadService{
+		fileRepo:   fileRepo,
+		images:     images,
+		jobs:       jobs,
+		store:      store,
+		bus:        bus,
+		uploadPath: cfg.UploadPath,
+		storageURL: cfg.StorageURL,
+		ttl:        cfg.DirectUploadTTL,
+	}
+	jobs.Register(JobProcessImages, s.step)
+	return s
+}
+
+// Create checks an announced upload against the user's limits and returns
+// the URL to upload it to
+func (s *DirectUploadService) Create(ctx context.Context, userID uint, req DirectUploadRequest) (*DirectUpload, error) {
+	if req.Size <= 0 {
+		return nil, ErrInvalidUploadSize
+	}
+	if err := s.images.userService.CheckUploadAllowed(ctx, userID, req.Size); err != nil {
+		return nil, err
+	}
+	if err := s.images.diskGuard.Check(req.Size); err != nil {
+		return nil, err
+	}
+	if err := s.images.filenamePolicy().Validate(req.Filename); err != nil {
+		return nil, err
+	}
+	mimeType := detect.Normalize(req.ContentType)
+	if allowed, err := s.images.imageTypeAllowed(ctx, userID, mimeType); err != nil {
+		return nil, err
+	} else if !allowed {
+		return nil, ErrImageTypeNotAllowed
+	}
+	if err := s.images.sizeLimits().Check(mimeType, req.Size); err != nil {
+		return nil, err
+	}
+	if _, err := s.images.profiles.Get(req.Profile); err != nil {
+		return nil, err
+	}
+
+	folderPath, err := s.images.sanitizeFolderPath(req.FolderPath)
+	if err != nil {
+		return nil, err
+	}
+
+	grant := directUploadGrant{
+		UserID:     userID,
+		Filename:   req.Filename,
+		Size:       req.Size,
+		MimeType:   mimeType,
+		FolderPath: folderPath,
+		Profile:    req.Profile,
+		Label:      req.Label,
+	}
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return nil, err
+	}
+	token := uuid.New().String()
+	if err := s.store.Set(ctx, directUploadKey(token), data, s.ttl); err != nil {
+		return nil, fmt.Errorf("failed to create upload URL: %w", err)
+	}
+
+	return &DirectUpload{
+		UploadURL: fmt.Sprintf("%s/api/images/direct/%s", strings.TrimSuffix(s.storageURL, "/"), token),
+		Method:    "PUT",
+		MaxSize:   req.Size,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}, nil
+}
+
+// Receive stores the original image sent to an upload URL without
+// processing it. Each URL accepts one upload; client is the request that
+// sent it.
+func (s *DirectUploadService) Receive(ctx context.Context, token string, body io.Reader, client model.UploadSource) (*model.File, error) {
+	data, ok, err := s.store.Get(ctx, directUploadKey(token))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrUploadURLNotFound
+	}
+	if claimed, err := s.store.Claim(ctx, directUploadKey(token)+":used", s.ttl); err != nil || !claimed {
+		return nil, ErrUploadURLNotFound
+	}
+	var grant directUploadGrant
+	if err := json.Unmarshal(data, &grant); err != nil {
+		return nil, err
+	}
+
+	client.Source, client.Label = model.SourceDirectUpload, grant.Label
+	stored, err := s.storeOriginal(ctx, grant, body, client)
+	if err != nil {
+		// A failed upload may be retried with the same URL
+		s.store.Delete(context.WithoutCancel(ctx), directUploadKey(token)+":used")
+		return nil, err
+	}
+	s.store.Delete(context.WithoutCancel(ctx), directUploadKey(token))
+	return stored, nil
+}
+
+func (s *DirectUploadService) storeOriginal(ctx context.Context, grant directUploadGrant, body io.Reader, source model.UploadSource) (*model.File, error) {
+	// Limits may have changed since the URL was issued
+	if err := s.images.userService.CheckUploadAllowed(ctx, grant.UserID, grant.Size); err != nil {
+		return nil, err
+	}
+	if err := s.images.diskGuard.Check(grant.Size); err != nil {
+		return nil, err
+	}
+
+	dst, err := s.images.temp.Create()
+	if err != nil {
+		return nil, err
+	}
+	digest := newContentDigest()
+	size, err := io.Copy(io.MultiWriter(dst, digest), io.LimitReader(contextReader{ctx, body}, grant.Size+1))
+	if err != nil {
+		s.images.temp.Discard(dst)
+		return nil, fmt.Errorf("failed to receive file: %w", err)
+	}
+	if size > grant.Size {
+		s.images.temp.Discard(dst)
+		return nil, ErrUploadTooLarge.WithArgs(grant.Size)
+	}
+
+	head := make([]byte, 512)
+	n, err := dst.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		s.images.temp.Discard(dst)
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	kind, _ := filetype.Match(head[:n])
+	mimeType := kind.MIME.Value
+	if allowed, err := s.images.imageTypeAllowed(ctx, grant.UserID, mimeType); err != nil || !allowed {
+		s.images.temp.Discard(dst)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrImageTypeNotAllowed
+	}
+
+	now := time.Now()
+	dateFolder := now.Format("2006-01-02")
+	userFolder := fmt.Sprintf("%d", grant.UserID)
+	uniqueFilename := uuid.New().String() + s.images.getExtensionForMimeType(mimeType)
+	filePath := filepath.Join(s.uploadPath, userFolder, dateFolder, uniqueFilename)
+	if err := s.images.temp.Commit(dst, filePath); err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := s.images.blobs.Publish(ctx, filePath); err != nil {
+		os.Remove(filePath)
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+
+	file := &model.File{
+		UserID:       grant.UserID,
+		Filename:     uniqueFilename,
+		OriginalName: s.images.filenamePolicy().Apply(s.images.sanitizeFilename(grant.Filename)),
+		FilePath:     filePath,
+		FolderPath:   grant.FolderPath,
+		FileSize:     size,
+		MimeType:     mimeType,
+		Kind:         model.KindImage,
+
+		DeclaredMimeType:  grant.MimeType,
+		ExtensionMimeType: detect.ExtensionType(grant.Filename),
+		DetectedMimeType:  mimeType,
+
+		ProcessingProfile: grant.Profile,
+		ProcessingStatus:  model.ProcessingUnprocessed,
+		Upload:            source,
+	}
+	file.NameWarning = s.images.filenamePolicy().Warning(file.OriginalName)
+	digest.apply(file)
+	if meta, err := readImageMetadataFile(filePath, mimeType); err == nil {
+		file.Width, file.Height = meta.Width, meta.Height
+		file.FrameCount, file.ColorProfile, file.ColorSpace = meta.FrameCount, meta.ColorProfile, meta.ColorSpace
+	}
+
+	if err := s.fileRepo.Create(ctx, file); err != nil {
+		os.Remove(filePath)
+		s.images.blobs.Remove(context.WithoutCancel(ctx), filePath)
+		return nil, fmt.Errorf("failed to save file metadata: %w", err)
+	}
+	s.images.generateURL(file)
+	s.bus.Publish(events.NewFileCreated(file))
+
+	return file, nil
+}
+
+// Process queues optimization of a directly uploaded image of userID.
+// profile overrides the profile given when the upload URL was created.
+func (s *DirectUploadService) Process(ctx context.Context, fileID, userID uint, profile string) (*model.File, *model.Job, error) {
+	file, err := s.fileRepo.FindByIDAndUser(ctx, fileID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if file.ProcessingStatus != model.ProcessingUnprocessed && file.ProcessingStatus != model.ProcessingFailed {
+		return nil, nil, ErrImageNotProcessable
+	}
+	if profile == "" {
+		profile = file.ProcessingProfile
+	}
+	if _, err := s.images.profiles.Get(profile); err != nil {
+		return nil, nil, err
+	}
+
+	file.ProcessingStatus = model.ProcessingPending
+	if err := s.fileRepo.UpdateFields(ctx, file.ID, map[string]interface{}{"processing_status": model.ProcessingPending}); err != nil {
+		return nil, nil, err
+	}
+	job, err := s.jobs.Enqueue(ctx, JobProcessImages, ProcessImagesParams{FileIDs: []uint{file.ID}, Profile: profile}, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.images.generateURL(file)
+	return file, job, nil
+}
+
+func (s *DirectUploadService) step(ctx context.Context, job *model.Job) (bool, error) {
+	var params ProcessImagesParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+	profile, err := s.images.profiles.Get(params.Profile)
+	if err != nil {
+		return false, err
+	}
+
+	filter := repository.FileFilter{IDs: params.FileIDs}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		file := &files[i]
+		if err := s.images.reprocess(ctx, file, profile, params.Profile, model.ProcessingDone); err != nil {
+			log.Printf("[WARN] Failed to process image %d: %v", file.ID, err)
+			if err := s.fileRepo.UpdateFields(ctx, file.ID, map[string]interface{}{"processing_status": model.ProcessingFailed}); err != nil {
+				log.Printf("[WARN] Failed to mark image %d as failed: %v", file.ID, err)
+			}
+			job.Failed++
+		} else {
+			s.images.generateURL(file)
+			s.images.variants.publishCompleted(ctx, file)
+		}
+		job.Processed++
+		job.Cursor = file.ID
+	}
+
+	return len(files) < jobBatchSize, nil
+}
+
+func directUploadKey(token string) string {
+	return "direct-upload:" + token
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"errors"
+	"log"
+	"storage-service/internal/diskspace"
+)
+
+// DiskGuard refuses uploads when the upload filesystem is close to full
+type DiskGuard struct {
+	path    string
+	minFree int64
+}
+
+// DiskStats is the capacity of the upload filesystem as reported to admins
+type DiskStats struct {
+	Path    string `json:"path"`
+	Total   uint64 `json:"total"`
+	Free    uint64 `json:"free"`
+	Used    uint64 `json:"used"`
+	MinFree int64  `json:"min_free"`
+	Low     bool   `json:"low"`
+}
+
+func NewDiskGuard(path string, minFree int64) *DiskGuard {
+	return &DiskGuard{path: path, minFree: minFree}
+}
+
+// Check returns ErrInsufficientStorage when storing size more bytes would
+// leave less than the configured minimum free space
+func (g *DiskGuard) Check(size int64) error {
+	if g == nil || g.minFree <= 0 {
+		return nil
+	}
+
+	usage, err := diskspace.Stat(g.path)
+	if err != nil {
+		// Do not block uploads when the filesystem cannot be inspected
+		if !errors.Is(err, diskspace.ErrUnsupported) {
+			log.Printf("[WARN] Failed to check free space on %s: %v", g.path, err)
+		}
+		return nil
+	}
+
+	if int64(usage.Free)-size < g.minFree {
+		return ErrInsufficientStorage
+	}
+	return nil
+}
+
+// Stats returns the current usage of the upload filesystem
+func (g *DiskGuard) Stats() (*DiskStats, error) {
+	usage, err := diskspace.Stat(g.path)
+	if err != nil {
+		return nil, err
+	}
+	return &DiskStats{
+		Path:    g.path,
+		Total:   usage.Total,
+		Free:    usage.Free,
+		Used:    usage.Used,
+		MinFree: g.minFree,
+		Low:     g.minFree > 0 && int64(usage.Free) < g.minFree,
+	}, nil
+}
+
+// ParseMinFreeSpace parses MIN_FREE_SPACE; empty or "0" disables the guard
+func ParseMinFreeSpace(value string) (int64, error) {
+	if value == "" || value == "0" {
+		return 0, nil
+	}
+	return ParseByteSize(value)
+}
@@ -0,0 +1,251 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"storage-service/internal/coord"
+	"storage-service/internal/model"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// DefaultEditSessionTTL is how long an edit session lasts without a
+	// heartbeat when the client doesn't ask for another lifetime
+	DefaultEditSessionTTL = 2 * time.Minute
+	// MaxEditSessionTTL is the longest lifetime a heartbeat can extend a
+	// session by
+	MaxEditSessionTTL = 30 * time.Minute
+)
+
+// editSessionAttempts and editSessionRetry bound how long a change of an
+// edit session waits for another change of the same session
+const (
+	editSessionAttempts = 20
+	editSessionRetry    = 50 * time.Millisecond
+)
+
+// EditSession locks a text file for one editor. Holder names the editor to
+// whoever else opens the file; Token is only returned to the editor, who
+// sends it with heartbeats, the release and the saved content.
+type EditSession struct {
+	FileID     uint      `json:"file_id"`
+	Token      string    `json:"token,omitempty"`
+	Holder     string    `json:"holder"`
+	AcquiredAt time.Time `json:"acquired_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// EditSessionService keeps edit sessions in the shared store, so they hold
+// across instances. Sessions end when released or once they expire without
+// a heartbeat; saving content while another editor holds the file fails
+// with ErrFileLocked.
+type EditSessionService struct {
+	files  *FileService
+	store  coord.Store
+	locker coord.Locker
+}
+
+func NewEditSessionService(files *FileService, store coord.Store, locker coord.Locker) *EditSessionService {
+	return &EditSessionService{files: files, store: store, locker: locker}
+}
+
+// Get returns the session holding a text file of userID, without its token,
+// or nil
+func (s *EditSessionService) Get(ctx context.Context, fileID, userID uint) (*EditSession, error) {
+	if _, err := s.files.findFile(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+	session, err := s.load(ctx, fileID)
+	if err != nil || session == nil {
+		return nil, err
+	}
+	return session.public(), nil
+}
+
+// Acquire starts an edit session on a text file of userID for ttl. When
+// another editor holds the file, their session is returned along with
+// ErrFileLocked.
+func (s *EditSessionService) Acquire(ctx context.Context, fileID, userID uint, holder string, ttl time.Duration) (*EditSession, error) {
+	file, err := s.files.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !s.files.IsEditable(file) {
+		return nil, ErrFileNotEditable
+	}
+	if err := s.files.checkWriteOnce(ctx, file); err != nil {
+		return nil, err
+	}
+	if ttl, err = editSessionTTL(ttl); err != nil {
+		return nil, err
+	}
+
+	var session *EditSession
+	err = s.locked(ctx, fileID, func() error {
+		current, err := s.load(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			session = current.public()
+			return ErrFileLocked.WithArgs(current.Holder)
+		}
+
+		now := time.Now()
+		session = &EditSession{FileID: fileID, Token: uuid.New().String(), Holder: holder, AcquiredAt: now, ExpiresAt: now.Add(ttl)}
+		return s.save(ctx, session)
+	})
+	return session, err
+}
+
+// Heartbeat extends the session with token by ttl from now
+func (s *EditSessionService) Heartbeat(ctx context.Context, fileID, userID uint, token string, ttl time.Duration) (*EditSession, error) {
+	if _, err := s.files.findFile(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+	ttl, err := editSessionTTL(ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	var session *EditSession
+	err = s.locked(ctx, fileID, func() error {
+		current, err := s.load(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		if current == nil {
+			return ErrEditSessionExpired
+		}
+		if !current.heldWith(token) {
+			session = current.public()
+			return ErrFileLocked.WithArgs(current.Holder)
+		}
+
+		current.ExpiresAt = time.Now().Add(ttl)
+		session = current
+		return s.save(ctx, current)
+	})
+	return session, err
+}
+
+// Release ends the session with token. Releasing a session that already
+// ended succeeds.
+func (s *EditSessionService) Release(ctx context.Context, fileID, userID uint, token string) error {
+	if _, err := s.files.findFile(ctx, fileID, userID); err != nil {
+		return err
+	}
+	return s.locked(ctx, fileID, func() error {
+		current, err := s.load(ctx, fileID)
+		if err != nil || current == nil {
+			return err
+		}
+		if !current.heldWith(token) {
+			return ErrFileLocked.WithArgs(current.Holder)
+		}
+		return s.store.Delete(ctx, editSessionKey(fileID))
+	})
+}
+
+// UpdateContent saves the content of a text file like
+// FileService.UpdateFileContent, unless another editor holds it. token is
+// the session of the caller, if they started one. The session of another
+// editor is returned along with ErrFileLocked.
+func (s *EditSessionService) UpdateContent(ctx context.Context, fileID, userID uint, token, content string) (*model.File, *EditSession, error) {
+	if _, err := s.files.findFile(ctx, fileID, userID); err != nil {
+		return nil, nil, err
+	}
+
+	var file *model.File
+	var holder *EditSession
+	err := s.locked(ctx, fileID, func() error {
+		current, err := s.load(ctx, fileID)
+		if err != nil {
+			return err
+		}
+		if current != nil && !current.heldWith(token) {
+			holder = current.public()
+			return ErrFileLocked.WithArgs(current.Holder)
+		}
+		file, err = s.files.UpdateFileContent(ctx, fileID, userID, content)
+		return err
+	})
+	return file, holder, err
+}
+
+// locked runs fn while no other change of the session of fileID runs, on
+// any instance
+func (s *EditSessionService) locked(ctx context.Context, fileID uint, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		release, ok, err := s.locker.TryLock(ctx, editSessionKey(fileID))
+		if err != nil {
+			return err
+		}
+		if ok {
+			defer release()
+			return fn()
+		}
+		if attempt == editSessionAttempts {
+			return ErrEditSessionBusy
+		}
+		select {
+		case <-time.After(editSessionRetry):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *EditSessionService) load(ctx context.Context, fileID uint) (*EditSession, error) {
+	data, ok, err := s.store.Get(ctx, editSessionKey(fileID))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var session EditSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return nil, nil
+	}
+	return &session, nil
+}
+
+func (s *EditSessionService) save(ctx context.Context, session *EditSession) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return s.store.Set(ctx, editSessionKey(session.FileID), data, time.Until(session.ExpiresAt))
+}
+
+// heldWith reports whether token is the token of the session
+func (e *EditSession) heldWith(token string) bool {
+	return token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(e.Token)) == 1
+}
+
+// public returns the session as others see it, without its token
+func (e *EditSession) public() *EditSession {
+	session := *e
+	session.Token = ""
+	return &session
+}
+
+func editSessionKey(fileID uint) string {
+	return fmt.Sprintf("edit_session:%d", fileID)
+}
+
+// editSessionTTL applies the default to an unset ttl and checks it
+func editSessionTTL(ttl time.Duration) (time.Duration, error) {
+	if ttl == 0 {
+		return DefaultEditSessionTTL, nil
+	}
+	if ttl < time.Second || ttl > MaxEditSessionTTL {
+		return 0, ErrInvalidEditSessionTTL.WithArgs(int(MaxEditSessionTTL.Seconds()))
+	}
+	return ttl, nil
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"net/http"
+	"storage-service/internal/apperror"
+)
+
+// Errors returned to API clients. Codes are stable and used for translations.
+var (
+	ErrFileNotFound          = apperror.New(http.StatusNotFound, "file_not_found", "File not found")
+	ErrFileNotInTrash        = apperror.New(http.StatusNotFound, "file_not_in_trash", "file is not in the trash")
+	ErrVersionNotFound       = apperror.New(http.StatusNotFound, "version_not_found", "file version not found")
+	ErrFileTypeNotAllowed    = apperror.New(http.StatusBadRequest, "file_type_not_allowed", "file type not allowed for security reasons")
+	ErrContentTypeNotAllowed = apperror.New(http.StatusBadRequest, "content_type_not_allowed", "file content type not allowed for security reasons")
+	ErrDangerousContent      = apperror.New(http.StatusBadRequest, "dangerous_content", "file contains potentially dangerous content")
+	ErrMimeMismatch          = apperror.New(http.StatusBadRequest, "mime_mismatch", "file content (%s) does not match its declared type or extension")
+	ErrInvalidFilename       = apperror.New(http.StatusBadRequest, "invalid_filename", "invalid filename")
+	ErrExtensionChange       = apperror.New(http.StatusBadRequest, "extension_change_not_allowed", "cannot change file extension")
+	ErrExtensionAdd          = apperror.New(http.StatusBadRequest, "extension_add_not_allowed", "cannot add extension to file without extension")
+	ErrFileNotEditable       = apperror.New(http.StatusBadRequest, "file_not_editable", "file is not editable")
+	ErrFileTooLargeToEdit    = apperror.New(http.StatusBadRequest, "file_too_large_to_edit", "file too large to edit")
+	ErrTooManyIDs            = apperror.New(http.StatusBadRequest, "too_many_ids", "too many ids, maximum is %d")
+	ErrInvalidFileType       = apperror.New(http.StatusBadRequest, "invalid_file_type", "type must be image, video, audio or text")
+	ErrInvalidKind           = apperror.New(http.StatusBadRequest, "invalid_kind", "kind must be image, video, audio, document, archive, code or other")
+	ErrInvalidSHA256         = apperror.New(http.StatusBadRequest, "invalid_sha256", "sha256 must be 64 hexadecimal characters")
+	ErrInvalidUploadSource   = apperror.New(http.StatusBadRequest, "invalid_upload_source", "source must be web, api, upload_link, anonymous, direct_upload, import, s3 or webdav")
+
+	ErrUnknownBatchOperation = apperror.New(http.StatusBadRequest, "unknown_batch_operation", "operation must be delete, move, copy or tag")
+	ErrTargetFolderRequired  = apperror.New(http.StatusBadRequest, "target_folder_required", "folder_path is required to move or copy files")
+	ErrTagsRequired          = apperror.New(http.StatusBadRequest, "tags_required", "add_tags or remove_tags is required")
+	ErrInvalidTag            = apperror.New(http.StatusBadRequest, "invalid_tag", "tags must be 1 to %d characters long without commas or slashes")
+
+	ErrInvalidFolderPath    = apperror.New(http.StatusBadRequest, "invalid_folder_path", "invalid folder path")
+	ErrInvalidFolderName    = apperror.New(http.StatusBadRequest, "invalid_folder_name", "invalid folder path or name")
+	ErrFolderNotFound       = apperror.New(http.StatusNotFound, "folder_not_found", "Folder not found")
+	ErrFolderExists         = apperror.New(http.StatusConflict, "folder_exists", "folder already exists")
+	ErrInvalidFolderColor   = apperror.New(http.StatusBadRequest, "invalid_folder_color", "color must be red, orange, yellow, green, blue, purple, pink, gray or empty")
+	ErrDescriptionTooLong   = apperror.New(http.StatusBadRequest, "description_too_long", "description may be at most %d characters long")
+	ErrRootFolder           = apperror.New(http.StatusBadRequest, "root_folder", "cannot delete root folder")
+	ErrConfirmationRequired = apperror.New(http.StatusConflict, "confirmation_required", "this operation affects many files and requires confirmation")
+	ErrFolderTooDeep        = apperror.New(http.StatusBadRequest, "folder_too_deep", "folder paths may nest at most %d folders")
+	ErrFolderPathTooLong    = apperror.New(http.StatusBadRequest, "folder_path_too_long", "folder paths may be at most %d characters long")
+	ErrReservedFolderName   = apperror.New(http.StatusBadRequest, "reserved_folder_name", "%q is a reserved folder name")
+
+	ErrShareNotFound      = apperror.New(http.StatusNotFound, "share_not_found", "Share link not found")
+	ErrShareExpired       = apperror.New(http.StatusGone, "share_expired", "Share link has expired")
+	ErrInvalidShareExpiry = apperror.New(http.StatusBadRequest, "invalid_share_expiry", "invalid expires_in %q, use a duration such as 7d or 12h")
+
+	ErrUploadLinkNotFound     = apperror.New(http.StatusNotFound, "upload_link_not_found", "Upload link not found")
+	ErrUploadLinkExpired      = apperror.New(http.StatusGone, "upload_link_expired", "Upload link has expired")
+	ErrUploadLinkFull         = apperror.New(http.StatusConflict, "upload_link_full", "this link accepts at most %d files")
+	ErrUploadLinkSizeExceeded = apperror.New(http.StatusRequestEntityTooLarge, "upload_link_size_exceeded", "files uploaded through this link may not exceed %s in total")
+	ErrExtensionNotAllowed    = apperror.New(http.StatusBadRequest, "extension_not_allowed", "file type not allowed, this link accepts %s")
+	ErrInvalidUploadLinkLimit = apperror.New(http.StatusBadRequest, "invalid_upload_link_limit", "max_files and max_total_size may not be negative")
+	ErrInvalidExtension       = apperror.New(http.StatusBadRequest, "invalid_extension", "invalid extension %q")
+
+	ErrFolderRuleNotFound = apperror.New(http.StatusNotFound, "folder_rule_not_found", "Folder rule not found")
+	ErrFolderRuleNoAction = apperror.New(http.StatusBadRequest, "folder_rule_no_action", "a folder rule needs a profile, webhook_url or expire_after")
+	ErrInvalidWebhookURL  = apperror.New(http.StatusBadRequest, "invalid_webhook_url", "webhook_url must be an absolute http or https URL")
+	ErrInvalidExpireAfter = apperror.New(http.StatusBadRequest, "invalid_expire_after", "invalid expire_after %q, use a duration such as 7d or 12h")
+
+	ErrUnknownImageType       = apperror.New(http.StatusBadRequest, "unknown_file_type", "unable to determine file type")
+	ErrTooManyPixels          = apperror.New(http.StatusBadRequest, "image_too_many_pixels", "image is %dx%d pixels, at most %d pixels are allowed")
+	ErrTooManyAnimationPixels = apperror.New(http.StatusBadRequest, "animation_too_many_pixels", "animation has %d frames of %dx%d pixels, at most %d pixels are allowed in total")
+	ErrImageTypeNotAllowed    = apperror.New(http.StatusBadRequest, "image_type_not_allowed", "file type not allowed, only images (JPEG, PNG, GIF) are accepted")
+	ErrImageNotFound          = apperror.New(http.StatusNotFound, "image_not_found", "Image not found")
+	ErrUnknownImageProfile    = apperror.New(http.StatusBadRequest, "unknown_image_profile", "unknown image profile %q")
+	ErrInvalidProxyURL        = apperror.New(http.StatusBadRequest, "invalid_proxy_url", "url must be an absolute http or https URL")
+	ErrInvalidProxyWidth      = apperror.New(http.StatusBadRequest, "invalid_proxy_width", "w must be between 0 and %d")
+	ErrProxyHostNotAllowed    = apperror.New(http.StatusForbidden, "proxy_host_not_allowed", "fetching images from this host is not allowed")
+	ErrProxyFetchFailed       = apperror.New(http.StatusBadGateway, "proxy_fetch_failed", "failed to fetch remote image: %s")
+	ErrProxyImageTooLarge     = apperror.New(http.StatusBadGateway, "proxy_image_too_large", "remote image is larger than %s")
+	ErrInvalidUploadSize      = apperror.New(http.StatusBadRequest, "invalid_upload_size", "size must be a positive number of bytes")
+	ErrUploadURLNotFound      = apperror.New(http.StatusNotFound, "upload_url_not_found", "upload URL is invalid, expired or already used")
+	ErrUploadTooLarge         = apperror.New(http.StatusRequestEntityTooLarge, "upload_too_large", "upload is larger than the announced %d bytes")
+	ErrImageNotProcessable    = apperror.New(http.StatusConflict, "image_not_processable", "image is already processed or being processed")
+
+	ErrImageTypeNotOptional = apperror.New(http.StatusBadRequest, "image_type_not_optional", "%s can't be enabled for image uploads, use %s")
+
+	ErrStreamNotAvailable  = apperror.New(http.StatusNotFound, "stream_not_available", "no stream is available for this file")
+	ErrInvalidStreamToken  = apperror.New(http.StatusForbidden, "invalid_stream_token", "stream token is invalid or has expired")
+	ErrInvalidSignedURL    = apperror.New(http.StatusForbidden, "invalid_signed_url", "download link is invalid or has expired")
+	ErrInvalidExpiresIn    = apperror.New(http.StatusBadRequest, "invalid_expires_in", "expires_in must be a positive duration of at most %s")
+	ErrTranscodingDisabled = apperror.New(http.StatusConflict, "transcoding_disabled", "video transcoding is not configured")
+	ErrReplicationDisabled = apperror.New(http.StatusConflict, "replication_disabled", "replication is not configured")
+	ErrMigrationDisabled   = apperror.New(http.StatusConflict, "migration_disabled", "PREVIOUS_UPLOAD_PATH is not configured")
+	ErrScanningDisabled    = apperror.New(http.StatusConflict, "scanning_disabled", "virus scanning is not configured")
+	ErrFileInfected        = apperror.New(http.StatusForbidden, "file_infected", "file is infected and was quarantined")
+	ErrFileQuarantined     = apperror.New(http.StatusForbidden, "file_quarantined", "file was quarantined by an administrator")
+	ErrFileNotQuarantined  = apperror.New(http.StatusConflict, "file_not_quarantined", "file is not quarantined")
+
+	ErrCannotRelocate      = apperror.New(http.StatusConflict, "cannot_relocate", "file can't be relocated while it is quarantined or its content changes")
+	ErrRelocateTargetTaken = apperror.New(http.StatusConflict, "relocate_target_taken", "another file is already stored as %q in this folder")
+
+	ErrFileLocked            = apperror.New(http.StatusConflict, "file_locked", "file is being edited by %s")
+	ErrEditSessionExpired    = apperror.New(http.StatusConflict, "edit_session_expired", "edit session has ended, start a new one")
+	ErrEditSessionBusy       = apperror.New(http.StatusServiceUnavailable, "edit_session_busy", "edit session is being changed, try again")
+	ErrInvalidEditSessionTTL = apperror.New(http.StatusBadRequest, "invalid_edit_session_ttl", "ttl_seconds must be between 1 and %d")
+
+	ErrWriteOnceFolder    = apperror.New(http.StatusForbidden, "write_once_folder", "folder %q is write-once, its files can't be changed, moved or deleted")
+	ErrWriteOnceAdminOnly = apperror.New(http.StatusForbidden, "write_once_admin_only", "only an administrator can release a write-once folder")
+	ErrNotWriteOnce       = apperror.New(http.StatusConflict, "not_write_once", "folder is not write-once")
+
+	ErrEmailRegistered      = apperror.New(http.StatusBadRequest, "email_registered", "email already registered")
+	ErrUserNotFound         = apperror.New(http.StatusNotFound, "user_not_found", "User not found")
+	ErrFileSizeLimit        = apperror.New(http.StatusBadRequest, "file_size_limit", "file size exceeds your limit")
+	ErrFileCountLimit       = apperror.New(http.StatusBadRequest, "file_count_limit", "maximum number of files reached")
+	ErrStorageLimitExceeded = apperror.New(http.StatusBadRequest, "storage_limit_exceeded", "storage limit exceeded")
+	ErrTypeSizeLimit        = apperror.New(http.StatusBadRequest, "type_size_limit", "%s files may not be larger than %s")
+	ErrInsufficientStorage  = apperror.New(http.StatusInsufficientStorage, "insufficient_storage", "server is running out of storage space, try again later")
+	ErrStorageUnavailable   = apperror.New(http.StatusServiceUnavailable, "storage_unavailable", "storage is temporarily unavailable, try again later")
+
+	ErrScratchQuotaExceeded = apperror.New(http.StatusBadRequest, "scratch_quota_exceeded", "scratch files may take at most %s, delete some or wait for them to expire")
+	ErrInvalidScratchTTL    = apperror.New(http.StatusBadRequest, "invalid_scratch_ttl", "invalid ttl %q, use a duration of at most %s such as 24h")
+
+	ErrUnknownPlan       = apperror.New(http.StatusBadRequest, "unknown_plan", "unknown plan %q")
+	ErrPlanLimitExceeded = apperror.New(http.StatusForbidden, "plan_limit_exceeded", "%s may be at most %s on the %s plan, ask an administrator to change your plan")
+
+	ErrBillingDisabled    = apperror.New(http.StatusConflict, "billing_disabled", "billing is not configured")
+	ErrPlanNotBillable    = apperror.New(http.StatusBadRequest, "plan_not_billable", "plan %q can't be subscribed to")
+	ErrSubscriptionExists = apperror.New(http.StatusConflict, "subscription_exists", "you already have a subscription, change it in the billing portal")
+	ErrNoSubscription     = apperror.New(http.StatusConflict, "no_subscription", "you have no subscription to manage")
+
+	ErrAnonymousUploadsDisabled = apperror.New(http.StatusNotFound, "anonymous_uploads_disabled", "anonymous uploads are not enabled")
+	ErrAnonymousUploadTooLarge  = apperror.New(http.StatusRequestEntityTooLarge, "anonymous_upload_too_large", "anonymous uploads may not be larger than %s")
+	ErrAnonymousTypeNotAllowed  = apperror.New(http.StatusBadRequest, "anonymous_type_not_allowed", "file type not allowed for anonymous uploads, use %s")
+	ErrAnonymousRateLimited     = apperror.New(http.StatusTooManyRequests, "anonymous_rate_limited", "too many uploads, try again later")
+	ErrCaptchaFailed            = apperror.New(http.StatusForbidden, "captcha_failed", "captcha verification failed")
+	ErrNotInModeration          = apperror.New(http.StatusNotFound, "not_in_moderation", "file is not awaiting moderation")
+
+	ErrInvalidCredentials = apperror.New(http.StatusUnauthorized, "invalid_credentials", "invalid email or password")
+	ErrWrongPassword      = apperror.New(http.StatusForbidden, "wrong_password", "current password is incorrect")
+	ErrPasswordNotSet     = apperror.New(http.StatusConflict, "password_not_set", "set a password before changing your email")
+	ErrInvalidPassword    = apperror.New(http.StatusBadRequest, "invalid_password", "password must be between %d and %d characters long")
+	ErrInvalidResetToken  = apperror.New(http.StatusBadRequest, "invalid_reset_token", "password reset link is invalid or has expired")
+
+	ErrInvalidAccessToken  = apperror.New(http.StatusUnauthorized, "invalid_access_token", "access token is invalid or has expired")
+	ErrInvalidRefreshToken = apperror.New(http.StatusUnauthorized, "invalid_refresh_token", "refresh token is invalid or has expired")
+	ErrSessionNotFound     = apperror.New(http.StatusNotFound, "session_not_found", "Session not found")
+	ErrCannotImpersonate   = apperror.New(http.StatusForbidden, "cannot_impersonate", "admins and your own account cannot be impersonated")
+
+	ErrInvalidAPIKey        = apperror.New(http.StatusUnauthorized, "invalid_api_key", "Invalid API key")
+	ErrAPIKeyExpired        = apperror.New(http.StatusUnauthorized, "api_key_expired", "API key has expired")
+	ErrAPIKeyNotFound       = apperror.New(http.StatusNotFound, "api_key_not_found", "API key not found")
+	ErrTooManyAPIKeys       = apperror.New(http.StatusConflict, "too_many_api_keys", "a user may hold at most %d API keys")
+	ErrInvalidScope         = apperror.New(http.StatusBadRequest, "invalid_scope", "invalid scope %q, use read, upload, delete or admin")
+	ErrAdminScopeNotAllowed = apperror.New(http.StatusForbidden, "admin_scope_not_allowed", "Only admins can hold keys with the admin scope")
+	ErrScopeNotGranted      = apperror.New(http.StatusForbidden, "scope_not_granted", "the key used for this request lacks the %s scope, so it can't grant it")
+	ErrInsufficientScope    = apperror.New(http.StatusForbidden, "insufficient_scope", "this API key lacks the %s scope")
+	ErrInvalidAPIKeyExpiry  = apperror.New(http.StatusBadRequest, "invalid_api_key_expiry", "invalid expires_in %q, use a duration such as 90d or 12h")
+
+	ErrJobNotFound       = apperror.New(http.StatusNotFound, "job_not_found", "Job not found")
+	ErrJobNotActive      = apperror.New(http.StatusConflict, "job_not_active", "Job has already finished")
+	ErrJobNotCancellable = apperror.New(http.StatusForbidden, "job_not_cancellable", "%s jobs can only be cancelled by an administrator")
+	ErrJobNotPaused      = apperror.New(http.StatusConflict, "job_not_paused", "Job is not paused")
+	ErrUnknownJobType    = apperror.New(http.StatusBadRequest, "unknown_job_type", "unknown job type %q")
+	ErrUnknownBackfill   = apperror.New(http.StatusBadRequest, "unknown_backfill", "unknown backfill field %q")
+
+	ErrInvalidReportWindow = apperror.New(http.StatusBadRequest, "invalid_report_window", "invalid window %q, use a duration of up to 366 days such as 30d")
+
+	ErrUnknownBulkAction  = apperror.New(http.StatusBadRequest, "unknown_bulk_action", "unknown action %q, use delete, quarantine, release or transfer")
+	ErrTargetUserRequired = apperror.New(http.StatusBadRequest, "target_user_required", "target_user_id is required to transfer files")
+
+	ErrUnknownSetting = apperror.New(http.StatusBadRequest, "unknown_setting", "unknown setting %q")
+	ErrInvalidSetting = apperror.New(http.StatusBadRequest, "invalid_setting", "invalid value for %s: %s")
+
+	ErrImportDisabled      = apperror.New(http.StatusConflict, "import_disabled", "IMPORT_ROOT is not configured")
+	ErrInvalidImportSource = apperror.New(http.StatusBadRequest, "invalid_import_source", "source_dir must be a directory below IMPORT_ROOT")
+	ErrUnknownImportMode   = apperror.New(http.StatusBadRequest, "unknown_import_mode", "unknown import mode %q, use copy, move or link")
+
+	ErrComplianceExportDisabled = apperror.New(http.StatusConflict, "compliance_export_disabled", "COMPLIANCE_EXPORT_PATH is not configured")
+	ErrComplianceExportExists   = apperror.New(http.StatusConflict, "compliance_export_exists", "a snapshot of this user was just started, try again in a second")
+
+	ErrBucketNotFound   = apperror.New(http.StatusNotFound, "bucket_not_found", "Bucket not found")
+	ErrInvalidObjectKey = apperror.New(http.StatusBadRequest, "invalid_object_key", "invalid object key %q")
+)
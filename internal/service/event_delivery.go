@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/notify"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobDeliverEvent publishes a file event to the EVENT_SINKS
+const JobDeliverEvent = "deliver_event"
+
+// eventDeliveryAttempts is how often publishing to a sink is tried before
+// the delivery counts as failed
+const eventDeliveryAttempts = 3
+
+// DeliverEventParams is the message a delivery job publishes. The body is
+// built when the event happens, so it still describes a file deleted since.
+type DeliverEventParams struct {
+	Event string          `json:"event"`
+	Body  json.RawMessage `json:"body"`
+}
+
+// eventNotification is the body of a message published to a sink
+type eventNotification struct {
+	Event  string      `json:"event"`
+	UserID uint        `json:"user_id"`
+	File   *model.File `json:"file"`
+	Time   time.Time   `json:"time"`
+}
+
+// ParseEventTypes parses EVENT_TYPES, a comma separated list of event
+// types; empty selects every type
+func ParseEventTypes(spec string) ([]string, error) {
+	if strings.TrimSpace(spec) == "" {
+		return events.Types, nil
+	}
+	var types []string
+	for _, eventType := range strings.Split(spec, ",") {
+		eventType = strings.TrimSpace(eventType)
+		if eventType == "" {
+			continue
+		}
+		known := false
+		for _, t := range events.Types {
+			known = known || t == eventType
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown event type %q, use %s", eventType, strings.Join(events.Types, ", "))
+		}
+		types = append(types, eventType)
+	}
+	return types, nil
+}
+
+// EventDeliveryService publishes file events to message services such as
+// SNS, SQS and Pub/Sub, so serverless functions can process uploads without
+// polling. Each event is delivered by a background job; messages carry the
+// event type and, with EVENT_SIGNING_SECRET, a signature as attributes.
+type EventDeliveryService struct {
+	publishers  []notify.Publisher
+	fileService *FileService
+	jobs        *JobService
+	secret      string
+}
+
+// NewEventDeliveryService publishes the events of eventTypes, see
+// ParseEventTypes, to publishers
+func NewEventDeliveryService(publishers []notify.Publisher, fileService *FileService, jobs *JobService, bus *events.Bus, eventTypes []string, cfg *config.Config) *EventDeliveryService {
+	s := &EventDeliveryService{publishers: publishers, fileService: fileService, jobs: jobs, secret: cfg.EventSigningSecret}
+	jobs.Register(JobDeliverEvent, s.step)
+	if len(publishers) == 0 {
+		return s
+	}
+
+	for _, eventType := range eventTypes {
+		bus.Subscribe(eventType, s.onEvent)
+	}
+	return s
+}
+
+// onEvent queues a job delivering the event
+func (s *EventDeliveryService) onEvent(event events.Event) {
+	var file *model.File
+	if event.File != nil {
+		copied := *event.File
+		s.fileService.generateFileURL(&copied)
+		file = &copied
+	}
+	body, err := json.Marshal(eventNotification{Event: event.Type, UserID: event.UserID, File: file, Time: event.Time})
+	if err != nil {
+		log.Printf("[WARN] Failed to encode %s event: %v", event.Type, err)
+		return
+	}
+
+	params := DeliverEventParams{Event: event.Type, Body: body}
+	if _, err := s.jobs.Enqueue(context.Background(), JobDeliverEvent, params, event.UserID); err != nil {
+		log.Printf("[WARN] Failed to queue delivery of %s event: %v", event.Type, err)
+	}
+}
+
+// step publishes the message to every sink not yet done. A sink still
+// failing after eventDeliveryAttempts counts in job.Failed.
+func (s *EventDeliveryService) step(ctx context.Context, job *model.Job) (bool, error) {
+	var params DeliverEventParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+	job.Total = int64(len(s.publishers))
+	msg := s.message(params)
+
+	for i, publisher := range s.publishers {
+		if uint(i) < job.Cursor {
+			continue
+		}
+		if err := s.publish(ctx, publisher, msg); err != nil {
+			log.Printf("[WARN] Failed to deliver %s event to %s: %v", params.Event, publisher.Name(), err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = uint(i + 1)
+	}
+	return true, nil
+}
+
+// message sets the attributes consumers can filter on and check: the event
+// type, the time it was signed and a signature of both with the body
+func (s *EventDeliveryService) message(params DeliverEventParams) notify.Message {
+	msg := notify.Message{Body: params.Body, Attributes: map[string]string{"event": params.Event}}
+	if s.secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(s.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(params.Body)
+		msg.Attributes["timestamp"] = timestamp
+		msg.Attributes["signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return msg
+}
+
+func (s *EventDeliveryService) publish(ctx context.Context, publisher notify.Publisher, msg notify.Message) error {
+	var err error
+	for attempt := 1; attempt <= eventDeliveryAttempts; attempt++ {
+		if err = publisher.Publish(ctx, msg); err == nil {
+			return nil
+		}
+		if attempt < eventDeliveryAttempts {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt) * time.Second):
+			}
+		}
+	}
+	return err
+}
@@ -0,0 +1,319 @@
+package service
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"io"
+	"storage-service/internal/model"
+	"strings"
+	"time"
+)
+
+// EXIF tags read or rewritten, see the EXIF 2.3 specification
+const (
+	exifTagMake               = 0x010F
+	exifTagModel              = 0x0110
+	exifTagOrientation        = 0x0112
+	exifTagDateTime           = 0x0132
+	exifTagThumbnailOffset    = 0x0201
+	exifTagThumbnailLength    = 0x0202
+	exifTagExifIFD            = 0x8769
+	exifTagGPSIFD             = 0x8825
+	exifTagDateTimeOriginal   = 0x9003
+	exifTagOffsetTimeOriginal = 0x9011
+	exifTagPixelXDimension    = 0xA002
+	exifTagPixelYDimension    = 0xA003
+	exifTagLensModel          = 0xA434
+)
+
+var jpegExifPrefix = []byte("Exif\x00\x00")
+
+// exifTypeSizes are the sizes in bytes of the TIFF field types
+var exifTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// tiffData is the TIFF structure EXIF data is stored in
+type tiffData struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// exifEntry is a field of an IFD; value is the position of its value in the
+// TIFF data, inline in the entry for values of up to four bytes
+type exifEntry struct {
+	tag   uint16
+	typ   uint16
+	count int
+	value int
+	size  int
+}
+
+func parseTIFF(data []byte) (*tiffData, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	t := &tiffData{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, false
+	}
+	return t, true
+}
+
+// firstIFD returns the offset of IFD0
+func (t *tiffData) firstIFD() int {
+	return int(t.order.Uint32(t.data[4:]))
+}
+
+// ifd returns the entries of the IFD at offset and the position of its
+// pointer to the next IFD; ok is false for data out of bounds
+func (t *tiffData) ifd(offset int) (entries []exifEntry, next int, ok bool) {
+	if offset < 8 || offset+2 > len(t.data) {
+		return nil, 0, false
+	}
+	n := int(t.order.Uint16(t.data[offset:]))
+	next = offset + 2 + 12*n
+	if next+4 > len(t.data) {
+		return nil, 0, false
+	}
+	for i := 0; i < n; i++ {
+		pos := offset + 2 + 12*i
+		e := exifEntry{
+			tag:   t.order.Uint16(t.data[pos:]),
+			typ:   t.order.Uint16(t.data[pos+2:]),
+			count: int(t.order.Uint32(t.data[pos+4:])),
+			value: pos + 8,
+		}
+		e.size = exifTypeSizes[e.typ] * e.count
+		if e.size < 0 || e.count < 0 {
+			continue
+		}
+		if e.size > 4 {
+			e.value = int(t.order.Uint32(t.data[pos+8:]))
+		}
+		if e.value < 0 || e.value+e.size > len(t.data) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, next, true
+}
+
+func (t *tiffData) uint(e exifEntry) int {
+	switch {
+	case e.typ == 3 && e.count >= 1:
+		return int(t.order.Uint16(t.data[e.value:]))
+	case e.typ == 4 && e.count >= 1:
+		return int(t.order.Uint32(t.data[e.value:]))
+	}
+	return 0
+}
+
+func (t *tiffData) string(e exifEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	value := t.data[e.value : e.value+e.size]
+	if i := bytes.IndexByte(value, 0); i >= 0 {
+		value = value[:i]
+	}
+	return strings.TrimSpace(strings.ToValidUTF8(string(value), ""))
+}
+
+// findExifEntry returns the entry with tag
+func findExifEntry(entries []exifEntry, tag uint16) (exifEntry, bool) {
+	for _, e := range entries {
+		if e.tag == tag {
+			return e, true
+		}
+	}
+	return exifEntry{}, false
+}
+
+// jpegExif returns the TIFF data of the EXIF segment of a JPEG, nil if it
+// has none
+func jpegExif(data []byte) []byte {
+	var exif []byte
+	jpegSegments(data, func(marker byte, segment []byte) bool {
+		if marker == 0xE1 && bytes.HasPrefix(segment, jpegExifPrefix) {
+			exif = segment[len(jpegExifPrefix):]
+			return false
+		}
+		return true
+	})
+	return exif
+}
+
+// exifHeaderSize is how much of an image is read for its EXIF data, the
+// most a JPEG segment holds plus the segments that may come before it
+const exifHeaderSize = 256 << 10
+
+// readExifMetadata reads what the camera recorded about the JPEG in src:
+// make, model, lens, when it was taken and its orientation, with the
+// dimensions of the image as shown. It returns nil for images without EXIF
+// data.
+func readExifMetadata(src io.ReadSeeker, mimeType string) *model.ImageMetadata {
+	if mimeType != "image/jpeg" && mimeType != "image/jpg" {
+		return nil
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil
+	}
+	header, err := io.ReadAll(io.LimitReader(src, exifHeaderSize))
+	if err != nil {
+		return nil
+	}
+	t, ok := parseTIFF(jpegExif(header))
+	if !ok {
+		return nil
+	}
+	ifd0, _, ok := t.ifd(t.firstIFD())
+	if !ok {
+		return nil
+	}
+
+	meta := &model.ImageMetadata{Orientation: 1}
+	for _, e := range ifd0 {
+		switch e.tag {
+		case exifTagMake:
+			meta.CameraMake = t.string(e)
+		case exifTagModel:
+			meta.CameraModel = t.string(e)
+		case exifTagOrientation:
+			if o := t.uint(e); o >= 1 && o <= 8 {
+				meta.Orientation = o
+			}
+		case exifTagDateTime:
+			if meta.TakenAt == nil {
+				meta.TakenAt = parseExifTime(t.string(e), "")
+			}
+		}
+	}
+	if e, ok := findExifEntry(ifd0, exifTagExifIFD); ok {
+		if entries, _, ok := t.ifd(t.uint(e)); ok {
+			offset := ""
+			if e, ok := findExifEntry(entries, exifTagOffsetTimeOriginal); ok {
+				offset = t.string(e)
+			}
+			if e, ok := findExifEntry(entries, exifTagDateTimeOriginal); ok {
+				if taken := parseExifTime(t.string(e), offset); taken != nil {
+					meta.TakenAt = taken
+				}
+			}
+			if e, ok := findExifEntry(entries, exifTagLensModel); ok {
+				meta.LensModel = t.string(e)
+			}
+		}
+	}
+	_, meta.HasGPS = findExifEntry(ifd0, exifTagGPSIFD)
+
+	if _, err := src.Seek(0, io.SeekStart); err == nil {
+		if cfg, _, err := image.DecodeConfig(src); err == nil {
+			meta.Width, meta.Height = cfg.Width, cfg.Height
+			// Orientations 5 to 8 turn the image by 90 degrees
+			if meta.Orientation >= 5 {
+				meta.Width, meta.Height = cfg.Height, cfg.Width
+			}
+		}
+	}
+	return meta
+}
+
+// parseExifTime parses an EXIF date such as "2024:03:01 14:05:09", in the
+// time zone offset such as "+02:00" when recorded and as UTC otherwise
+func parseExifTime(value, offset string) *time.Time {
+	layout := "2006:01:02 15:04:05"
+	if offset != "" {
+		value, layout = value+offset, layout+"-07:00"
+	}
+	t, err := time.Parse(layout, value)
+	if err != nil || t.Year() < 1900 {
+		return nil
+	}
+	return &t
+}
+
+// carryExif returns the EXIF data of a JPEG source ready for embedding in
+// its re-encoded result of width x height pixels, nil if it has none. The
+// pixels were rotated upright when decoding, so the orientation becomes 1.
+// The embedded thumbnail shows the image before resizing or cropping and is
+// dropped, and with stripGPS so is the location.
+func carryExif(header []byte, width, height int, stripGPS bool) []byte {
+	source := jpegExif(header)
+	t, ok := parseTIFF(bytes.Clone(source))
+	if !ok {
+		return nil
+	}
+	ifd0, next, ok := t.ifd(t.firstIFD())
+	if !ok {
+		return nil
+	}
+	if e, ok := findExifEntry(ifd0, exifTagOrientation); ok && e.typ == 3 {
+		t.order.PutUint16(t.data[e.value:], 1)
+	}
+	if e, ok := findExifEntry(ifd0, exifTagExifIFD); ok {
+		if entries, _, ok := t.ifd(t.uint(e)); ok {
+			t.setUint(entries, exifTagPixelXDimension, width)
+			t.setUint(entries, exifTagPixelYDimension, height)
+		}
+	}
+	if ifd1 := int(t.order.Uint32(t.data[next:])); ifd1 != 0 {
+		if entries, _, ok := t.ifd(ifd1); ok {
+			offset, okOffset := findExifEntry(entries, exifTagThumbnailOffset)
+			length, okLength := findExifEntry(entries, exifTagThumbnailLength)
+			if start, size := t.uint(offset), t.uint(length); okOffset && okLength && start >= 0 && start+size <= len(t.data) {
+				clear(t.data[start : start+size])
+			}
+			t.clearIFD(ifd1)
+		}
+		t.order.PutUint32(t.data[next:], 0)
+	}
+	if e, ok := findExifEntry(ifd0, exifTagGPSIFD); ok && stripGPS {
+		t.clearIFD(t.uint(e))
+	}
+	return t.data
+}
+
+// setUint stores value in the SHORT or LONG entry with tag, if there is one
+func (t *tiffData) setUint(entries []exifEntry, tag uint16, value int) {
+	e, ok := findExifEntry(entries, tag)
+	switch {
+	case !ok || e.count < 1:
+	case e.typ == 3 && value <= 0xFFFF:
+		t.order.PutUint16(t.data[e.value:], uint16(value))
+	case e.typ == 4:
+		t.order.PutUint32(t.data[e.value:], uint32(value))
+	}
+}
+
+// clearIFD zeroes the IFD at offset and the values it points to, leaving an
+// empty IFD in its place so no pointer to it becomes invalid
+func (t *tiffData) clearIFD(offset int) {
+	entries, next, ok := t.ifd(offset)
+	if !ok {
+		return
+	}
+	for _, e := range entries {
+		clear(t.data[e.value : e.value+e.size])
+	}
+	clear(t.data[offset : next+4])
+}
+
+// embedExif inserts EXIF data as an APP1 segment after the start of image
+// marker of a JPEG
+func embedExif(encoded, exif []byte) []byte {
+	size := 2 + len(jpegExifPrefix) + len(exif)
+	if len(exif) == 0 || size > 0xFFFF || len(encoded) < 2 {
+		return encoded
+	}
+	out := make([]byte, 0, len(encoded)+2+size)
+	out = append(out, encoded[:2]...)
+	out = append(out, 0xFF, 0xE1, byte(size>>8), byte(size))
+	out = append(out, jpegExifPrefix...)
+	out = append(out, exif...)
+	return append(out, encoded[2:]...)
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Operations of FileService.Batch
+const (
+	BatchDelete = "delete"
+	BatchMove   = "move"
+	BatchCopy   = "copy"
+	BatchTag    = "tag"
+)
+
+// MaxBatchOperationIDs is the maximum number of file IDs one batch operation
+// accepts
+const MaxBatchOperationIDs = 1000
+
+// maxTagLength is the longest a tag may be, in characters
+const maxTagLength = 64
+
+// BatchOperation applies one operation to several files of a user
+type BatchOperation struct {
+	// Operation is delete, move, copy or tag
+	Operation string `json:"operation" binding:"required"`
+	IDs       []uint `json:"ids" binding:"required"`
+	// FolderPath is where move and copy put the files
+	FolderPath *string `json:"folder_path"`
+	// AddTags and RemoveTags change the tags of the files
+	AddTags    []string `json:"add_tags"`
+	RemoveTags []string `json:"remove_tags"`
+}
+
+// BatchResult lists the files an operation was applied to, as they are
+// afterwards, and why the others were skipped. Copies are listed in Files
+// and their sources in Succeeded.
+type BatchResult struct {
+	Operation string           `json:"operation"`
+	Succeeded []uint           `json:"succeeded"`
+	Errors    []BatchItemError `json:"errors"`
+	Files     []model.File     `json:"files"`
+}
+
+// Batch applies an operation to several files of userID. Files that don't
+// exist or belong to someone else are reported per ID; the others change in
+// a single transaction, so either all of them do or, when the database
+// fails, none. Stored files and events of deleted files are only handled
+// once the transaction committed. Deleted files go to the trash when it is
+// enabled. Copies share the stored file of their source and count against
+// the user's storage.
+func (s *FileService) Batch(ctx context.Context, userID uint, op BatchOperation) (*BatchResult, error) {
+	if len(op.IDs) > MaxBatchOperationIDs {
+		return nil, ErrTooManyIDs.WithArgs(MaxBatchOperationIDs)
+	}
+	var folderPath string
+	switch op.Operation {
+	case BatchDelete:
+	case BatchMove, BatchCopy:
+		if op.FolderPath == nil {
+			return nil, ErrTargetFolderRequired
+		}
+		var err error
+		if folderPath, err = s.sanitizeFolderPath(*op.FolderPath); err != nil {
+			return nil, err
+		}
+	case BatchTag:
+		var err error
+		if op.AddTags, err = normalizeTags(op.AddTags); err != nil {
+			return nil, err
+		}
+		if op.RemoveTags, err = normalizeTags(op.RemoveTags); err != nil {
+			return nil, err
+		}
+		if len(op.AddTags) == 0 && len(op.RemoveTags) == 0 {
+			return nil, ErrTagsRequired
+		}
+	default:
+		return nil, ErrUnknownBatchOperation
+	}
+
+	found, err := s.fileRepo.FindByIDsAndUser(ctx, op.IDs, userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint]*model.File, len(found))
+	for i := range found {
+		byID[found[i].ID] = &found[i]
+	}
+	// Files of write-once folders may be copied and tagged, but stay where
+	// they are
+	var writeOnce []string
+	if op.Operation == BatchDelete || op.Operation == BatchMove {
+		if writeOnce, err = s.folderRepo.FindWriteOncePaths(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &BatchResult{Operation: op.Operation, Succeeded: []uint{}, Errors: []BatchItemError{}, Files: []model.File{}}
+	var files []*model.File
+	seen := make(map[uint]bool, len(op.IDs))
+	for _, id := range op.IDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		file, ok := byID[id]
+		switch {
+		case !ok:
+			result.Errors = append(result.Errors, BatchItemError{ID: id, Error: "File not found"})
+		case op.Operation == BatchCopy && CheckDownload(file) != nil:
+			result.Errors = append(result.Errors, BatchItemError{ID: id, Error: bulkErrorMessage(CheckDownload(file))})
+		case writeOnceParent(writeOnce, file.FolderPath) != "":
+			result.Errors = append(result.Errors, BatchItemError{ID: id, Error: ErrWriteOnceFolder.WithArgs(writeOnceParent(writeOnce, file.FolderPath)).Error()})
+		default:
+			files = append(files, file)
+		}
+	}
+
+	// applied pairs each file changed with its result, a new record for copies
+	type applied struct{ file, result *model.File }
+	var done []applied
+	var skipped []BatchItemError
+	err = s.fileRepo.WithTx(ctx, func(ctx context.Context) error {
+		done, skipped = done[:0], skipped[:0]
+		for _, file := range files {
+			if op.Operation == BatchCopy {
+				// Limits are checked inside the transaction, so they count the
+				// copies made before this one
+				if err := s.userService.CheckUploadAllowed(ctx, file.UserID, file.FileSize); err != nil {
+					if bulkErrorMessage(err) == "Internal error" {
+						return err
+					}
+					skipped = append(skipped, BatchItemError{ID: file.ID, Error: bulkErrorMessage(err)})
+					continue
+				}
+			}
+			result, err := s.applyBatch(ctx, op, file, folderPath)
+			if err != nil {
+				return err
+			}
+			done = append(done, applied{file, result})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	result.Errors = append(result.Errors, skipped...)
+
+	for _, item := range done {
+		result.Succeeded = append(result.Succeeded, item.file.ID)
+		switch op.Operation {
+		case BatchDelete:
+			s.afterBatchDelete(ctx, item.file)
+			continue
+		case BatchCopy:
+			s.events.Publish(events.NewFileCreated(item.result))
+			s.copyVariants(ctx, item.file, item.result)
+		}
+		s.generateFileURL(item.result)
+		result.Files = append(result.Files, *item.result)
+	}
+	if op.Operation != BatchDelete {
+		s.attachTags(ctx, result.Files)
+	}
+	return result, nil
+}
+
+// applyBatch makes the database changes of an operation on one file and
+// returns the file as it is afterwards, a new record for copies
+func (s *FileService) applyBatch(ctx context.Context, op BatchOperation, file *model.File, folderPath string) (*model.File, error) {
+	switch op.Operation {
+	case BatchDelete:
+		if s.TrashEnabled() && file.ExpiresAt == nil {
+			return file, s.trashFile(ctx, file)
+		}
+		if err := s.fileRepo.Delete(ctx, file); err != nil {
+			return nil, fmt.Errorf("failed to delete file metadata: %w", err)
+		}
+		return file, s.tagRepo.DeleteByFileID(ctx, file.ID)
+	case BatchMove:
+		file.FolderPath = folderPath
+		if err := s.fileRepo.Update(ctx, file); err != nil {
+			return nil, fmt.Errorf("failed to move file: %w", err)
+		}
+		return file, nil
+	case BatchCopy:
+		copied := *file
+		copied.ID = 0
+		copied.FolderPath = folderPath
+		copied.Version = 1
+		copied.Pinned = false
+		copied.CreatedAt, copied.UpdatedAt = time.Time{}, time.Time{}
+		if folderPath == file.FolderPath {
+			ext := path.Ext(file.OriginalName)
+			copied.OriginalName = strings.TrimSuffix(file.OriginalName, ext) + " (copy)" + ext
+		}
+		if err := s.fileRepo.Create(ctx, &copied); err != nil {
+			return nil, fmt.Errorf("failed to copy file: %w", err)
+		}
+		tags, err := s.tagRepo.FindByFileIDs(ctx, []uint{file.ID})
+		if err != nil {
+			return nil, err
+		}
+		return &copied, s.tagRepo.Add(ctx, copied.UserID, copied.ID, tags[file.ID])
+	default:
+		if err := s.tagRepo.Add(ctx, file.UserID, file.ID, op.AddTags); err != nil {
+			return nil, err
+		}
+		return file, s.tagRepo.Remove(ctx, file.ID, op.RemoveTags)
+	}
+}
+
+// copyVariants builds the renditions of a copy when its source has any, like
+// refreshVariants does for new content
+func (s *FileService) copyVariants(ctx context.Context, source, copied *model.File) {
+	variants, err := s.variants.GetVariants(ctx, source.ID)
+	if err != nil || len(variants) == 0 {
+		return
+	}
+	if _, err := s.variants.Generate(ctx, copied); err != nil {
+		log.Printf("[WARN] Failed to generate variants of file %d: %v", copied.ID, err)
+	}
+}
+
+// afterBatchDelete removes what a file deleted for good leaves behind, once
+// its record is gone; see deleteFile
+func (s *FileService) afterBatchDelete(ctx context.Context, file *model.File) {
+	if s.TrashEnabled() && file.ExpiresAt == nil {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	if err := s.removeBlob(ctx, file); err != nil {
+		// Blob GC removes it later
+		log.Printf("[WARN] Failed to delete physical file of file %d: %v", file.ID, err)
+	}
+	s.variants.DeleteVariants(ctx, file.ID)
+	s.deleteVersions(ctx, file.ID)
+	s.events.Publish(events.NewFileDeleted(file))
+}
+
+// normalizeTags lowercases and trims tags and drops duplicates
+func normalizeTags(tags []string) ([]string, error) {
+	seen := make(map[string]bool, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.ContainsAny(tag, ",/") {
+			return nil, ErrInvalidTag.WithArgs(maxTagLength)
+		}
+		if !seen[tag] {
+			seen[tag] = true
+			normalized = append(normalized, tag)
+		}
+	}
+	return normalized, nil
+}
+
+// attachTags sets the tags of files; failures leave them without
+func (s *FileService) attachTags(ctx context.Context, files []model.File) {
+	ids := make([]uint, len(files))
+	for i := range files {
+		ids[i] = files[i].ID
+	}
+	tags, err := s.tagRepo.FindByFileIDs(ctx, ids)
+	if err != nil {
+		log.Printf("[WARN] Failed to load tags: %v", err)
+		return
+	}
+	for i := range files {
+		files[i].Tags = tags[files[i].ID]
+	}
+}
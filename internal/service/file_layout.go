@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"strconv"
+	"strings"
+)
+
+// RelocateFile moves the stored file of a file to a path mirroring its
+// folder and original name, e.g. UPLOAD_PATH/7/photos/2024/beach.jpg instead
+// of UPLOAD_PATH/7/2024-03-01/<uuid>.jpg, for operators who browse the upload
+// volume directly. The file's /uploads URL changes with it. A name already
+// taken on disk gets the file ID appended, e.g. "beach (42).jpg".
+func (s *FileService) RelocateFile(ctx context.Context, fileID, userID uint) (*model.File, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Quarantined files stay in the quarantine directory
+	if file.ScanStatus == model.ScanQuarantined || file.ScanStatus == model.ScanInfected {
+		return nil, ErrCannotRelocate
+	}
+
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		return nil, err
+	}
+	previousPath := file.FilePath
+	target, err := s.linkLayoutPath(file)
+	if err != nil {
+		return nil, err
+	}
+	if target == previousPath {
+		s.generateFileURL(file)
+		return file, nil
+	}
+
+	// The stored file is linked at its new path first, so it stays readable
+	// at one of them, and the old link is only removed once the record
+	// points at the new one
+	discard := func() {
+		os.Remove(target)
+		s.blobs.Remove(context.WithoutCancel(ctx), target)
+	}
+	if err := s.blobs.Publish(ctx, target); err != nil {
+		discard()
+		return nil, fmt.Errorf("failed to relocate file: %w", err)
+	}
+	moved, err := s.fileRepo.UpdateFilePath(ctx, file.ID, previousPath, target)
+	if err != nil || !moved {
+		discard()
+		if err == nil {
+			// The content was replaced in the meantime
+			err = ErrCannotRelocate
+		}
+		return nil, err
+	}
+	file.FilePath, file.BlobID = target, nil
+
+	// Other files stored at the same path still read it there
+	if others, err := s.fileRepo.CountOthersByFilePath(ctx, previousPath, file.ID); err == nil && others == 0 {
+		os.Remove(previousPath)
+		if err := s.blobs.Remove(ctx, previousPath); err != nil {
+			log.Printf("[WARN] Failed to remove relocated file %s: %v", previousPath, err)
+		}
+	}
+	s.events.Publish(events.NewFileUpdated(file, previousPath))
+
+	s.generateFileURL(file)
+	return file, nil
+}
+
+// linkLayoutPath hard links the stored file of file at the path mirroring
+// its folder and name and returns that path, or the current one if the file
+// is already stored there. Linking never replaces another file, so
+// concurrent relocations can't overwrite each other.
+func (s *FileService) linkLayoutPath(file *model.File) (string, error) {
+	userDir := filepath.Join(s.uploadPath, strconv.FormatUint(uint64(file.UserID), 10))
+	dir := filepath.Join(userDir, filepath.FromSlash(file.FolderPath))
+	if rel, err := filepath.Rel(userDir, dir); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidFolderPath
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create directory: %w", err)
+	}
+
+	ext := filepath.Ext(file.OriginalName)
+	suffix := CompressionSuffix(file.Compression)
+	candidates := []string{
+		filepath.Join(dir, file.OriginalName) + suffix,
+		filepath.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(file.OriginalName, ext), file.ID, ext)) + suffix,
+	}
+	for _, candidate := range candidates {
+		if candidate == file.FilePath {
+			return candidate, nil
+		}
+		err := os.Link(file.FilePath, candidate)
+		if err == nil {
+			return candidate, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("failed to relocate file: %w", err)
+		}
+	}
+	return "", ErrRelocateTargetTaken.WithArgs(file.OriginalName)
+}
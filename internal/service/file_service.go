@@ -1,19 +1,31 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"log"
 	"mime/multipart"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/detect"
+	"storage-service/internal/events"
+	"storage-service/internal/metrics"
 	"storage-service/internal/model"
 	"storage-service/internal/repository"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // Dangerous file extensions that should never be allowed
@@ -50,90 +62,275 @@ var dangerousMimeTypes = map[string]bool{
 	"application/x-javascript":    true,
 	"text/vbscript":               true,
 	"application/x-powershell":    true,
+
+	"application/vnd.microsoft.portable-executable": true,
+	"application/x-elf":                             true,
+	"application/x-mach-binary":                     true,
+	"application/x-sharedlib":                       true,
+	"text/x-shellscript":                            true,
+	"text/x-python":                                 true,
+	"text/x-perl":                                   true,
+	"text/x-lua":                                    true,
+	"text/x-tcl":                                    true,
 }
 
+const folderConfirmTokenTTL = 10 * time.Minute
+
+// MaxSignedURLTTL is the longest a signed download URL may stay valid
+const MaxSignedURLTTL = 7 * 24 * time.Hour
+
+// detectionHeaderSize is how much of a file is read for content type detection
+const detectionHeaderSize = 3072
+
 type FileService struct {
-	fileRepo    *repository.FileRepository
-	userService *UserService
-	uploadPath  string
-	storageURL  string
+	fileRepo               *repository.FileRepository
+	blobRepo               *repository.BlobRepository
+	folderRepo             *repository.FolderRepository
+	versionRepo            *repository.VersionRepository
+	tagRepo                *repository.TagRepository
+	userService            *UserService
+	uploadPath             string
+	roots                  uploadRoots
+	storageURL             string
+	secret                 []byte
+	folderConfirmThreshold int64
+	uploads                *UploadTracker
+	detector               detect.Detector
+	mimePolicy             detect.Policy
+	folderPolicy           FolderPolicy
+	settings               *SettingsService
+	diskGuard              *DiskGuard
+	blobs                  *BlobStore
+	variants               *VariantService
+	events                 *events.Bus
+	imageProfiles          ImageProfiles
+	temp                   *TempStore
+	compressor             *Compressor
+	replica                *Replica
+	storage                *StorageGuard
+	media                  *MediaSigner
+	signedURLTTL           time.Duration
+	trashRetention         time.Duration
+	maxVersions            int
+}
+
+// UploadOptions holds optional parameters for an upload
+type UploadOptions struct {
+	FolderPath string
+	// UploadID links the upload to a progress session created with UploadTracker
+	UploadID string
+	// Profile names the image processing profile, see ImageProfiles
+	Profile string
+	// Replace gives an existing file with the same name in the folder the
+	// new content, keeping its old content as a version
+	Replace bool
+	// ExpiresAt stores the upload as a scratch file, see ScratchService
+	ExpiresAt *time.Time
+	// Source records how the upload arrived and from which client
+	Source model.UploadSource
 }
 
-func NewFileService(fileRepo *repository.FileRepository, userService *UserService, uploadPath string, storageURL string) *FileService {
+// FolderOperationSummary describes the files affected by a folder delete or rename
+type FolderOperationSummary struct {
+	Operation            string `json:"operation"`
+	Path                 string `json:"path"`
+	FileCount            int64  `json:"file_count"`
+	TotalSize            int64  `json:"total_size"`
+	ConfirmationRequired bool   `json:"confirmation_required"`
+	ConfirmToken         string `json:"confirm_token,omitempty"`
+}
+
+func NewFileService(fileRepo *repository.FileRepository, blobRepo *repository.BlobRepository, folderRepo *repository.FolderRepository, versionRepo *repository.VersionRepository, tagRepo *repository.TagRepository, userService *UserService, uploads *UploadTracker, detector detect.Detector, diskGuard *DiskGuard, blobs *BlobStore, variants *VariantService, bus *events.Bus, settings *SettingsService, imageProfiles ImageProfiles, compressor *Compressor, cfg *config.Config) *FileService {
 	return &FileService{
-		fileRepo:    fileRepo,
-		userService: userService,
-		uploadPath:  uploadPath,
-		storageURL:  storageURL,
+		detector:               detector,
+		mimePolicy:             detect.Policy(cfg.MimeMismatchPolicy),
+		folderPolicy:           newFolderPolicy(cfg),
+		settings:               settings,
+		diskGuard:              diskGuard,
+		blobs:                  blobs,
+		variants:               variants,
+		events:                 bus,
+		imageProfiles:          imageProfiles,
+		temp:                   NewTempStore(cfg),
+		compressor:             compressor,
+		replica:                NewReplica(cfg),
+		storage:                NewStorageGuard(cfg),
+		media:                  NewMediaSigner(cfg),
+		fileRepo:               fileRepo,
+		blobRepo:               blobRepo,
+		folderRepo:             folderRepo,
+		versionRepo:            versionRepo,
+		tagRepo:                tagRepo,
+		userService:            userService,
+		uploads:                uploads,
+		uploadPath:             cfg.UploadPath,
+		roots:                  newUploadRoots(cfg),
+		storageURL:             cfg.StorageURL,
+		secret:                 []byte(cfg.AppSecret),
+		folderConfirmThreshold: cfg.FolderConfirmThreshold,
+		signedURLTTL:           cfg.SignedURLTTL,
+		trashRetention:         time.Duration(cfg.TrashRetentionDays) * 24 * time.Hour,
+		maxVersions:            cfg.MaxFileVersions,
 	}
 }
 
-func (s *FileService) ValidateFile(userID uint, fileHeader *multipart.FileHeader) error {
-	// Check user limits
-	if err := s.userService.CheckUploadAllowed(userID, fileHeader.Size); err != nil {
+func (s *FileService) ValidateFile(ctx context.Context, userID uint, fileHeader *multipart.FileHeader) error {
+	if err := s.validateFileMetadata(ctx, userID, fileHeader, UploadOptions{}); err != nil {
+		return err
+	}
+	_, err := s.scanFileContent(fileHeader)
+	return err
+}
+
+// validateFileMetadata checks user limits and the filename
+func (s *FileService) validateFileMetadata(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, opts UploadOptions) error {
+	// Check user limits; scratch files have a quota of their own
+	if opts.ExpiresAt == nil {
+		if err := s.userService.CheckUploadAllowed(ctx, userID, fileHeader.Size); err != nil {
+			return err
+		}
+	}
+
+	// Check free space on the upload filesystem
+	if err := s.diskGuard.Check(fileHeader.Size); err != nil {
 		return err
 	}
 
 	// Check dangerous file extensions
-	ext := strings.ToLower(filepath.Ext(fileHeader.Filename))
-	if dangerousExtensions[ext] {
-		return errors.New("file type not allowed for security reasons")
+	if err := s.filenamePolicy().Validate(fileHeader.Filename); err != nil {
+		return err
 	}
 
 	// Check filename for path traversal attempts
 	if strings.Contains(fileHeader.Filename, "..") ||
 		strings.Contains(fileHeader.Filename, "/") ||
 		strings.Contains(fileHeader.Filename, "\\") {
-		return errors.New("invalid filename")
+		return ErrInvalidFilename
 	}
 
+	return nil
+}
+
+// scanFileContent inspects the file content for dangerous types and scripts and
+// compares the detected type with the declared Content-Type and extension
+func (s *FileService) scanFileContent(fileHeader *multipart.FileHeader) (detect.Result, error) {
 	// Verify actual content type by reading file header
 	file, err := fileHeader.Open()
 	if err != nil {
-		return fmt.Errorf("failed to open file for validation: %w", err)
+		return detect.Result{}, fmt.Errorf("failed to open file for validation: %w", err)
 	}
 	defer file.Close()
 
-	// Read first 512 bytes to detect content type
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil && err != io.EOF {
-		return fmt.Errorf("failed to read file for validation: %w", err)
+	head, err := readDetectionHeader(file)
+	if err != nil {
+		return detect.Result{}, err
+	}
+	return s.inspectContent(fileHeader, head)
+}
+
+// readDetectionHeader reads the start of content used to detect its type
+func readDetectionHeader(r io.Reader) ([]byte, error) {
+	buffer := make([]byte, detectionHeaderSize)
+	n, err := io.ReadFull(r, buffer)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("failed to read file for validation: %w", err)
 	}
+	return buffer[:n], nil
+}
 
+// inspectContent detects the type of an upload from its first bytes and
+// checks it against the upload policy
+func (s *FileService) inspectContent(fileHeader *multipart.FileHeader, head []byte) (detect.Result, error) {
 	// Detect content type from actual file content
-	detectedType := http.DetectContentType(buffer[:n])
+	result := detect.Inspect(s.detector, fileHeader.Filename, fileHeader.Header.Get("Content-Type"), head)
+	detectedType := result.Detected
 
 	// Check if detected type is dangerous
 	if dangerousMimeTypes[detectedType] {
-		return errors.New("file content type not allowed for security reasons")
+		return result, ErrContentTypeNotAllowed
 	}
 
 	// Check for HTML/SVG that might contain scripts
 	if strings.Contains(detectedType, "html") || strings.Contains(detectedType, "svg") {
-		contentStr := strings.ToLower(string(buffer[:n]))
+		contentStr := strings.ToLower(string(head))
 		if strings.Contains(contentStr, "<script") ||
 			strings.Contains(contentStr, "javascript:") ||
 			strings.Contains(contentStr, "onerror=") ||
 			strings.Contains(contentStr, "onload=") {
-			return errors.New("file contains potentially dangerous content")
+			return result, ErrDangerousContent
 		}
 	}
 
-	return nil
+	if result.Mismatch() {
+		if s.mimePolicy == detect.PolicyReject {
+			return result, ErrMimeMismatch.WithArgs(detectedType)
+		}
+		log.Printf("[WARN] MIME mismatch for %q: declared=%q extension=%q detected=%q",
+			fileHeader.Filename, result.Declared, result.Extension, result.Detected)
+	}
+
+	// Per-type size limits use the detected type so a false Content-Type
+	// cannot move a file into a more generous family
+	sizeType := result.Effective(s.mimePolicy)
+	if detect.Conclusive(detectedType) {
+		sizeType = detectedType
+	}
+	if err := s.sizeLimits().Check(sizeType, fileHeader.Size); err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+func (s *FileService) UploadFile(ctx context.Context, userID uint, fileHeader *multipart.FileHeader) (*model.File, error) {
+	return s.UploadFileWithFolder(ctx, userID, fileHeader, "")
 }
 
-func (s *FileService) UploadFile(userID uint, fileHeader *multipart.FileHeader) (*model.File, error) {
-	return s.UploadFileWithFolder(userID, fileHeader, "")
+func (s *FileService) UploadFileWithFolder(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, folderPath string) (*model.File, error) {
+	return s.UploadFileWithOptions(ctx, userID, fileHeader, UploadOptions{FolderPath: folderPath})
 }
 
-func (s *FileService) UploadFileWithFolder(userID uint, fileHeader *multipart.FileHeader, folderPath string) (*model.File, error) {
-	if err := s.ValidateFile(userID, fileHeader); err != nil {
+// UploadFileWithOptions stores an uploaded file, reporting each processing
+// stage to the upload session when opts.UploadID is set
+func (s *FileService) UploadFileWithOptions(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, opts UploadOptions) (*model.File, error) {
+	file, err := s.storeUpload(ctx, userID, fileHeader, opts)
+	if err != nil {
+		s.uploads.Fail(opts.UploadID, err)
+		return nil, err
+	}
+	s.uploads.Complete(opts.UploadID, file.ID)
+	return file, nil
+}
+
+func (s *FileService) storeUpload(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, opts UploadOptions) (*model.File, error) {
+	s.uploads.SetStage(opts.UploadID, StageValidating)
+	if err := s.validateFileMetadata(ctx, userID, fileHeader, opts); err != nil {
+		return nil, err
+	}
+
+	// The upload is read once: its first bytes are inspected, then the
+	// whole content is stored, hashed and counted in a single pass
+	src, err := fileHeader.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	s.uploads.SetStage(opts.UploadID, StageScanning)
+	head, err := readDetectionHeader(src)
+	if err != nil {
+		return nil, err
+	}
+	detection, err := s.inspectContent(fileHeader, head)
+	if err != nil {
 		return nil, err
 	}
 
 	// Sanitize folder path
-	folderPath = s.sanitizeFolderPath(folderPath)
+	folderPath, err := s.sanitizeFolderPath(opts.FolderPath)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate date-based folder structure: uploads/{user_id}/{YYYY-MM-DD}/
 
@@ -145,7 +342,7 @@ func (s *FileService) UploadFileWithFolder(userID uint, fileHeader *multipart.Fi
 	uploadDir := filepath.Join(s.uploadPath, userFolder, dateFolder)
 
 	// Create user/date directory if not exists
-	if err := os.MkdirAll(uploadDir, 0755); err != nil {
+	if err := s.storage.Retry(ctx, "mkdir", func() error { return os.MkdirAll(uploadDir, 0755) }); err != nil {
 		return nil, fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
@@ -155,24 +352,23 @@ func (s *FileService) UploadFileWithFolder(userID uint, fileHeader *multipart.Fi
 		ext = ".bin" // Default extension for unknown types
 	}
 	uniqueFilename := uuid.New().String() + ext
-	filePath := filepath.Join(uploadDir, uniqueFilename)
-
-	// Open the uploaded file
-	src, err := fileHeader.Open()
-	if err != nil {
-		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
-	}
-	defer src.Close()
-
-	// Create destination file with restricted permissions
-	dst, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	mimeType := detection.Effective(s.mimePolicy)
+	compression := s.compressor.Algorithm(mimeType, fileHeader.Size)
+	filePath := filepath.Join(uploadDir, uniqueFilename) + CompressionSuffix(compression)
+
+	// Write to a temp file and move it into place once complete, so the
+	// file is never visible half written. The upload can't be read twice,
+	// so this is not retried.
+	digest := newContentDigest()
+	var storedSize int64
+	err = s.storage.Run(ctx, "write", func() error {
+		storedSize, err = writeStored(ctx, s.temp, filePath, compression, io.MultiReader(bytes.NewReader(head), src), digest)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create file: %w", err)
+		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
-	defer dst.Close()
-
-	// Copy file content
-	if _, err := io.Copy(dst, src); err != nil {
+	if err := s.blobs.Publish(ctx, filePath); err != nil {
 		os.Remove(filePath)
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
@@ -181,52 +377,157 @@ func (s *FileService) UploadFileWithFolder(userID uint, fileHeader *multipart.Fi
 	relativePath := filepath.Join(userFolder, dateFolder, uniqueFilename)
 	fileURL := fmt.Sprintf("%s/uploads/%s", strings.TrimSuffix(s.storageURL, "/"), filepath.ToSlash(relativePath))
 
-	// Detect MIME type from file header content type or detect it
-	mimeType := fileHeader.Header.Get("Content-Type")
-	if mimeType == "" || mimeType == "application/octet-stream" {
-		// Re-read file to detect type
-		f, _ := os.Open(filePath)
-		if f != nil {
-			buffer := make([]byte, 512)
-			n, _ := f.Read(buffer)
-			mimeType = http.DetectContentType(buffer[:n])
-			f.Close()
+	// Save file metadata to database
+	file := &model.File{
+		UserID:            userID,
+		Filename:          uniqueFilename,
+		OriginalName:      s.filenamePolicy().Apply(s.sanitizeFilename(fileHeader.Filename)),
+		FilePath:          filePath,
+		FolderPath:        folderPath,
+		FileSize:          fileHeader.Size,
+		MimeType:          mimeType,
+		Kind:              model.FileKind(mimeType, fileHeader.Filename),
+		DeclaredMimeType:  detection.Declared,
+		ExtensionMimeType: detection.Extension,
+		DetectedMimeType:  detection.Detected,
+		MimeMismatch:      detection.Mismatch(),
+		URL:               fileURL,
+		ExpiresAt:         opts.ExpiresAt,
+		Upload:            opts.Source,
+	}
+	file.NameWarning = s.filenamePolicy().Warning(file.OriginalName)
+	digest.apply(file)
+	if compression != "" {
+		file.Compression, file.StoredSize = compression, storedSize
+	}
+
+	if allowedImageTypes[file.MimeType] {
+		if meta, err := readImageMetadataFile(filePath, file.MimeType); err == nil {
+			file.Width, file.Height = meta.Width, meta.Height
+			file.FrameCount, file.ColorProfile, file.ColorSpace = meta.FrameCount, meta.ColorProfile, meta.ColorSpace
 		}
 	}
 
-	// Save file metadata to database
-	file := &model.File{
-		UserID:       userID,
-		Filename:     uniqueFilename,
-		OriginalName: s.sanitizeFilename(fileHeader.Filename),
-		FilePath:     filePath,
-		FolderPath:   folderPath,
-		FileSize:     fileHeader.Size,
-		MimeType:     mimeType,
-		URL:          fileURL,
+	// Uploading a name again in the same folder gives the existing file
+	// new content
+	if opts.Replace && s.VersioningEnabled() {
+		existing, err := s.fileRepo.FindByName(ctx, userID, folderPath, file.OriginalName)
+		if err == nil && existing.ScanStatus != model.ScanQuarantined {
+			if err := s.checkWriteOnce(ctx, existing); err != nil {
+				os.Remove(filePath)
+				s.blobs.Remove(context.WithoutCancel(ctx), filePath)
+				return nil, err
+			}
+			replaced, err := s.replaceUpload(ctx, existing, file)
+			if err != nil {
+				os.Remove(filePath)
+				s.blobs.Remove(context.WithoutCancel(ctx), filePath)
+				return nil, fmt.Errorf("failed to save file metadata: %w", err)
+			}
+			if replaced.FilePath != filePath {
+				s.removeUnused(ctx, filePath)
+			}
+			return replaced, nil
+		}
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			os.Remove(filePath)
+			s.blobs.Remove(context.WithoutCancel(ctx), filePath)
+			return nil, fmt.Errorf("failed to look up file: %w", err)
+		}
 	}
 
-	if err := s.fileRepo.Create(file); err != nil {
+	err = s.fileRepo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.acquireBlob(ctx, file); err != nil {
+			return err
+		}
+		return s.fileRepo.Create(ctx, file)
+	})
+	if err != nil {
 		os.Remove(filePath)
+		s.blobs.Remove(context.WithoutCancel(ctx), filePath)
 		return nil, fmt.Errorf("failed to save file metadata: %w", err)
 	}
+	if file.FilePath != filePath {
+		s.removeUnused(ctx, filePath)
+		s.generateFileURL(file)
+	}
+
+	s.events.Publish(events.NewFileCreated(file))
 
 	return file, nil
 }
 
-func (s *FileService) sanitizeFolderPath(path string) string {
-	// Remove leading/trailing slashes and whitespace
-	path = strings.TrimSpace(path)
-	path = strings.Trim(path, "/\\")
+// acquireBlob counts an upload in the blob of its content, in the
+// transaction of ctx. Content its owner stored before is kept once: the
+// upload takes the stored file of the blob, see removeUnused.
+func (s *FileService) acquireBlob(ctx context.Context, file *model.File) error {
+	blob, err := s.blobRepo.Acquire(ctx, &model.Blob{
+		UserID:      file.UserID,
+		SHA256:      file.SHA256,
+		FilePath:    file.FilePath,
+		Compression: file.Compression,
+		StoredSize:  file.StoredSize,
+	})
+	if err != nil {
+		return err
+	}
+	file.BlobID = &blob.ID
+	file.FilePath, file.Compression, file.StoredSize = blob.FilePath, blob.Compression, blob.StoredSize
+	file.Filename = strings.TrimSuffix(filepath.Base(blob.FilePath), CompressionSuffix(blob.Compression))
+	return nil
+}
 
-	// Remove any path traversal attempts
-	path = strings.ReplaceAll(path, "..", "")
-	path = strings.ReplaceAll(path, "//", "/")
+// removeUnused removes the stored file an upload wrote at filePath once the
+// upload took the stored file of its blob instead
+func (s *FileService) removeUnused(ctx context.Context, filePath string) {
+	ctx = context.WithoutCancel(ctx)
+	err := s.storage.Retry(ctx, "remove", func() error {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.blobs.Remove(ctx, filePath)
+	})
+	if err != nil {
+		log.Printf("[WARN] Failed to remove duplicate upload %s: %v", filePath, err)
+	}
+}
 
-	// Replace backslashes with forward slashes
-	path = strings.ReplaceAll(path, "\\", "/")
+// replaceUpload gives a file the content of an upload, keeping the content
+// it had as an earlier version. The new content is scanned and processed
+// like a new upload.
+func (s *FileService) replaceUpload(ctx context.Context, file, upload *model.File) (*model.File, error) {
+	previous := newVersion(file)
+	file.Filename, file.FilePath = upload.Filename, upload.FilePath
+	file.FileSize, file.MimeType, file.Kind = upload.FileSize, upload.MimeType, upload.Kind
+	file.DeclaredMimeType, file.ExtensionMimeType = upload.DeclaredMimeType, upload.ExtensionMimeType
+	file.DetectedMimeType, file.MimeMismatch = upload.DetectedMimeType, upload.MimeMismatch
+	file.Compression, file.StoredSize = upload.Compression, upload.StoredSize
+	file.SHA256, file.MD5 = upload.SHA256, upload.MD5
+	file.Width, file.Height = upload.Width, upload.Height
+	file.FrameCount, file.ColorProfile, file.ColorSpace = upload.FrameCount, upload.ColorProfile, upload.ColorSpace
+	file.ScanStatus, file.ScanSignature, file.ScannedAt = model.ScanUnscanned, "", nil
+	file.Upload = upload.Upload
+
+	if err := s.saveReplaced(ctx, file, previous, nil, true); err != nil {
+		return nil, err
+	}
+	s.refreshVariants(ctx, file)
+	s.events.Publish(events.NewFileReplaced(file, previous.FilePath))
 
-	return path
+	s.generateFileURL(file)
+	return file, nil
+}
+
+// sanitizeFolderPath normalizes a folder path files are stored in and checks
+// it against FolderPolicy. Paths of existing folders are only cleaned with
+// model.CleanFolderPath, so folders created before a limit was lowered stay
+// reachable.
+func (s *FileService) sanitizeFolderPath(path string) (string, error) {
+	path = model.CleanFolderPath(path)
+	if err := s.folderPolicy.Validate(path); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 func (s *FileService) sanitizeFilename(name string) string {
@@ -241,22 +542,105 @@ func (s *FileService) sanitizeFilename(name string) string {
 		}
 	}
 
-	return result.String()
+	return model.NormalizeName(result.String())
 }
 
-func (s *FileService) GetFile(fileID uint) (*model.File, error) {
-	file, err := s.fileRepo.FindByID(fileID)
+// findFile loads a file of userID by ID. Files of other users are missing
+// too, so no caller can hand one out by forgetting an ownership check.
+func (s *FileService) findFile(ctx context.Context, fileID, userID uint) (*model.File, error) {
+	file, err := s.fileRepo.FindByIDAndUser(ctx, fileID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	return file, nil
+}
 
-	s.generateFileURL(file)
+// GetFile returns a file of userID with its URL and tags
+func (s *FileService) GetFile(ctx context.Context, fileID, userID uint) (*model.File, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.describeFile(ctx, file)
 	return file, nil
 }
 
-func (s *FileService) GetUserFiles(userID uint, page, pageSize int) ([]model.File, int64, error) {
+// getFile is GetFile for a file of any user, for access a share token or a
+// background job already authorized
+func (s *FileService) getFile(ctx context.Context, fileID uint) (*model.File, error) {
+	file, err := s.fileRepo.FindByID(ctx, fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.describeFile(ctx, file)
+	return file, nil
+}
+
+// describeFile sets the URL and tags of a file
+func (s *FileService) describeFile(ctx context.Context, file *model.File) {
+	s.generateFileURL(file)
+	if tags, err := s.tagRepo.FindByFileIDs(ctx, []uint{file.ID}); err == nil {
+		file.Tags = tags[file.ID]
+	}
+}
+
+// MaxBatchGetIDs is the maximum number of file IDs accepted by GetFilesByIDs
+const MaxBatchGetIDs = 100
+
+// BatchItemError reports why a single ID in a batch request was not returned
+type BatchItemError struct {
+	ID    uint   `json:"id"`
+	Error string `json:"error"`
+}
+
+// GetFilesByIDs fetches metadata for several files at once. Files that do not exist
+// or belong to another user are reported per ID as not found instead of failing
+// the whole batch.
+func (s *FileService) GetFilesByIDs(ctx context.Context, userID uint, ids []uint) ([]model.File, []BatchItemError, error) {
+	if len(ids) > MaxBatchGetIDs {
+		return nil, nil, ErrTooManyIDs.WithArgs(MaxBatchGetIDs)
+	}
+
+	found, err := s.fileRepo.FindByIDsAndUser(ctx, ids, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	byID := make(map[uint]*model.File, len(found))
+	for i := range found {
+		byID[found[i].ID] = &found[i]
+	}
+
+	files := make([]model.File, 0, len(ids))
+	itemErrors := []BatchItemError{}
+	seen := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		file, ok := byID[id]
+		if !ok {
+			itemErrors = append(itemErrors, BatchItemError{ID: id, Error: "File not found"})
+			continue
+		}
+		s.generateFileURL(file)
+		files = append(files, *file)
+	}
+	s.attachTags(ctx, files)
+
+	return files, itemErrors, nil
+}
+
+func (s *FileService) GetUserFiles(ctx context.Context, userID uint, page, pageSize int) ([]model.File, int64, error) {
 	offset := (page - 1) * pageSize
-	files, err := s.fileRepo.FindByUserID(userID, pageSize, offset)
+	files, err := s.fileRepo.FindByUserID(ctx, userID, pageSize, offset)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -265,7 +649,7 @@ func (s *FileService) GetUserFiles(userID uint, page, pageSize int) ([]model.Fil
 		s.generateFileURL(&files[i])
 	}
 
-	total, err := s.fileRepo.CountByUserID(userID)
+	total, err := s.fileRepo.CountByUserID(ctx, userID)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -273,9 +657,52 @@ func (s *FileService) GetUserFiles(userID uint, page, pageSize int) ([]model.Fil
 	return files, total, nil
 }
 
-func (s *FileService) GetUserFilesByFolder(userID uint, folderPath string, page, pageSize int, sortBy, sortOrder string) ([]model.File, int64, error) {
+// listableTypes are the values of the type filter on file listings
+var listableTypes = map[string]bool{"image": true, "video": true, "audio": true, "text": true}
+
+// ParseListFilter validates the type, kind, sha256, upload source and tag
+// filters of a listing. A type such as "image" matches its MIME type family;
+// empty values match every file.
+func ParseListFilter(fileType, kind, sha256, source, tag string) (repository.ListFilter, error) {
+	var filter repository.ListFilter
+	if fileType != "" {
+		if !listableTypes[fileType] {
+			return filter, ErrInvalidFileType
+		}
+		filter.MimePrefix = fileType + "/"
+	}
+	if kind != "" {
+		if !model.ValidKind(kind) {
+			return filter, ErrInvalidKind
+		}
+		filter.Kind = kind
+	}
+	if sha256 != "" {
+		sha256 = strings.ToLower(sha256)
+		if _, err := hex.DecodeString(sha256); err != nil || len(sha256) != 64 {
+			return filter, ErrInvalidSHA256
+		}
+		filter.SHA256 = sha256
+	}
+	if source != "" {
+		if !model.ValidUploadSource(source) {
+			return filter, ErrInvalidUploadSource
+		}
+		filter.Source = source
+	}
+	if tag != "" {
+		tags, err := normalizeTags([]string{tag})
+		if err != nil {
+			return filter, err
+		}
+		filter.Tag = tags[0]
+	}
+	return filter, nil
+}
+
+func (s *FileService) GetUserFilesByFolder(ctx context.Context, userID uint, folderPath string, filter repository.ListFilter, page, pageSize int, sortBy, sortOrder string) ([]model.File, int64, error) {
 	offset := (page - 1) * pageSize
-	files, err := s.fileRepo.FindByUserIDAndFolder(userID, folderPath, pageSize, offset, sortBy, sortOrder)
+	files, err := s.fileRepo.FindByUserIDAndFolder(ctx, userID, folderPath, filter, pageSize, offset, sortBy, sortOrder)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -283,8 +710,9 @@ func (s *FileService) GetUserFilesByFolder(userID uint, folderPath string, page,
 	for i := range files {
 		s.generateFileURL(&files[i])
 	}
+	s.attachTags(ctx, files)
 
-	total, err := s.fileRepo.CountByUserIDAndFolder(userID, folderPath)
+	total, err := s.fileRepo.CountByUserIDAndFolder(ctx, userID, folderPath, filter)
 	if err != nil {
 		return nil, 0, err
 	}
@@ -292,44 +720,337 @@ func (s *FileService) GetUserFilesByFolder(userID uint, folderPath string, page,
 	return files, total, nil
 }
 
+// GetUserFilesRecursive lists files in a folder subtree, setting RelativePath on each
+// file to its location relative to the requested folder
+func (s *FileService) GetUserFilesRecursive(ctx context.Context, userID uint, folderPath string, filter repository.ListFilter, page, pageSize int, sortBy, sortOrder string) ([]model.File, int64, error) {
+	folderPath = model.CleanFolderPath(folderPath)
+	offset := (page - 1) * pageSize
+	files, err := s.fileRepo.FindByUserIDAndFolderTree(ctx, userID, folderPath, filter, pageSize, offset, sortBy, sortOrder)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for i := range files {
+		s.generateFileURL(&files[i])
+		files[i].RelativePath = relativeFilePath(folderPath, &files[i])
+	}
+	s.attachTags(ctx, files)
+
+	total, err := s.fileRepo.CountByUserIDAndFolderTree(ctx, userID, folderPath, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return files, total, nil
+}
+
+// ExportUserFiles calls fn for every file of a listing, in the listing's
+// order, as rows are read from the database. Unlike the paginated listings
+// it has no page size, so very large accounts are enumerated in one request.
+func (s *FileService) ExportUserFiles(ctx context.Context, userID uint, folderPath string, recursive bool, filter repository.ListFilter, sortBy, sortOrder string, fn func(*model.File) error) error {
+	if recursive {
+		folderPath = model.CleanFolderPath(folderPath)
+	}
+	return s.fileRepo.StreamByUserIDAndFolder(ctx, userID, folderPath, recursive, filter, sortBy, sortOrder, func(file *model.File) error {
+		s.generateFileURL(file)
+		if recursive {
+			file.RelativePath = relativeFilePath(folderPath, file)
+		}
+		return fn(file)
+	})
+}
+
+func relativeFilePath(baseFolder string, file *model.File) string {
+	rel := file.FolderPath
+	if baseFolder != "" {
+		rel = strings.TrimPrefix(strings.TrimPrefix(rel, baseFolder), "/")
+	}
+	if rel == "" {
+		return file.OriginalName
+	}
+	return rel + "/" + file.OriginalName
+}
+
 func (s *FileService) generateFileURL(file *model.File) {
-	relativePath := strings.TrimPrefix(file.FilePath, s.uploadPath+string(filepath.Separator))
-	file.URL = fmt.Sprintf("%s/uploads/%s", strings.TrimSuffix(s.storageURL, "/"), filepath.ToSlash(relativePath))
+	// Relocated files are stored under their original name, which may
+	// contain spaces or '#'
+	name := (&url.URL{Path: s.uploadName(file)}).EscapedPath()
+	file.URL = strings.TrimSuffix(s.storageURL, "/") + "/uploads" + name
+	if file.SHA256 != "" && !s.media.Private() {
+		file.BlobURL = strings.TrimSuffix(s.storageURL, "/") + "/blob/" + file.SHA256
+	}
+}
+
+// uploadName returns the path of a file below /uploads
+func (s *FileService) uploadName(file *model.File) string {
+	relativePath := s.roots.relative(file.FilePath)
+	// Compressed files are served decompressed under their original name
+	relativePath = strings.TrimSuffix(relativePath, CompressionSuffix(file.Compression))
+	return "/" + filepath.ToSlash(relativePath)
+}
+
+// MediaURLs are signed URLs of files, keyed by file ID
+type MediaURLs struct {
+	URLs      map[uint]string  `json:"urls"`
+	ExpiresAt time.Time        `json:"expires_at"`
+	Errors    []BatchItemError `json:"errors"`
+}
+
+// Media returns the signer that protects /uploads
+func (s *FileService) Media() *MediaSigner {
+	return s.media
+}
+
+// GetMediaURLs returns short-lived signed URLs of files of userID that work
+// without credentials, e.g. in <img> tags, even when uploads are private
+func (s *FileService) GetMediaURLs(ctx context.Context, userID uint, ids []uint) (*MediaURLs, error) {
+	files, itemErrors, err := s.GetFilesByIDs(ctx, userID, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &MediaURLs{URLs: make(map[uint]string, len(files)), Errors: itemErrors}
+	for i := range files {
+		if err := CheckDownload(&files[i]); err != nil {
+			result.Errors = append(result.Errors, BatchItemError{ID: files[i].ID, Error: bulkErrorMessage(err)})
+			continue
+		}
+		result.URLs[files[i].ID], result.ExpiresAt = s.media.Sign(files[i].URL, s.uploadName(&files[i]))
+	}
+	return result, nil
+}
+
+// SignedURL is a download URL of a file that works without credentials
+type SignedURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
 }
 
-func (s *FileService) GetFolders(userID uint) ([]string, error) {
-	return s.fileRepo.GetFoldersByUserID(userID)
+// GetSignedURL returns a download URL of a file owned by userID, valid for
+// expiresIn or SIGNED_URL_TTL when it is zero. The signature covers the file
+// and its owner, so the URL stops working when the file is transferred.
+func (s *FileService) GetSignedURL(ctx context.Context, fileID, userID uint, expiresIn time.Duration) (*SignedURL, error) {
+	if expiresIn == 0 {
+		expiresIn = s.signedURLTTL
+	}
+	if expiresIn < 0 || expiresIn > MaxSignedURLTTL {
+		return nil, ErrInvalidExpiresIn.WithArgs(MaxSignedURLTTL.String())
+	}
+
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := CheckDownload(file); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(expiresIn)
+	token := s.signDownloadToken(file.ID, file.UserID, expiresAt)
+	return &SignedURL{
+		URL:       fmt.Sprintf("%s/api/files/%d/signed?token=%s", strings.TrimSuffix(s.storageURL, "/"), file.ID, token),
+		ExpiresAt: expiresAt.Truncate(time.Second),
+	}, nil
+}
+
+// OpenSignedURL returns the file of a signed download URL
+func (s *FileService) OpenSignedURL(ctx context.Context, fileID uint, token string) (*model.File, error) {
+	file, err := s.fileRepo.FindByID(ctx, fileID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidSignedURL
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !s.verifyDownloadToken(token, file.ID, file.UserID) {
+		return nil, ErrInvalidSignedURL
+	}
+	if err := CheckDownload(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (s *FileService) signDownloadToken(fileID, userID uint, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "download\n%d\n%d\n%s", fileID, userID, expiry)
+	return expiry + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *FileService) verifyDownloadToken(token string, fileID, userID uint) bool {
+	expiry, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	expected := s.signDownloadToken(fileID, userID, time.Unix(unix, 0))
+	return hmac.Equal([]byte(token), []byte(expected))
 }
 
-func (s *FileService) DeleteFile(fileID, userID uint) error {
-	file, err := s.fileRepo.FindByID(fileID)
+// GetFolders returns the paths of the folders files of userID are in and of
+// the folders created, empty ones included
+func (s *FileService) GetFolders(ctx context.Context, userID uint) ([]string, error) {
+	folders, err := s.fileRepo.GetFoldersByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := s.folderRepo.FindByUserID(ctx, userID)
 	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(folders))
+	for _, folder := range folders {
+		seen[folder] = true
+	}
+	for _, folder := range stored {
+		if !seen[folder.Path] {
+			seen[folder.Path] = true
+			folders = append(folders, folder.Path)
+		}
+	}
+	return folders, nil
+}
+
+func (s *FileService) DeleteFile(ctx context.Context, fileID, userID uint) error {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return err
+	}
+	if err := s.checkWriteOnce(ctx, file); err != nil {
 		return err
 	}
 
-	if file.UserID != userID {
-		return errors.New("unauthorized to delete this file")
+	// Scratch files are short-lived anyway, so they skip the trash
+	if s.TrashEnabled() && file.ExpiresAt == nil {
+		return s.trashFile(ctx, file)
+	}
+	return s.deleteFile(ctx, file)
+}
+
+// PinFile pins or unpins a file owned by userID. Pinned files are skipped
+// by lifecycle actions; pinning is bookkeeping and keeps updated_at.
+func (s *FileService) PinFile(ctx context.Context, fileID, userID uint, pinned bool) (*model.File, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if file.Pinned != pinned {
+		if err := s.fileRepo.UpdateFields(ctx, file.ID, map[string]interface{}{"pinned": pinned}); err != nil {
+			return nil, fmt.Errorf("failed to pin file: %w", err)
+		}
+		file.Pinned = pinned
+	}
+	s.generateFileURL(file)
+	return file, nil
+}
+
+// DeleteFileAsAdmin deletes a file regardless of its owner. Files of
+// write-once folders stay until the folder is released.
+func (s *FileService) DeleteFileAsAdmin(ctx context.Context, file *model.File) error {
+	if err := s.checkWriteOnce(ctx, file); err != nil {
+		return err
 	}
+	return s.deleteFile(ctx, file)
+}
 
-	if err := os.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
+func (s *FileService) deleteFile(ctx context.Context, file *model.File) error {
+	// Uploads of the same content stop taking the stored file before it goes
+	if err := s.fileRepo.DetachBlob(ctx, file); err != nil {
+		return fmt.Errorf("failed to delete file metadata: %w", err)
+	}
+	if err := s.removeBlob(ctx, file); err != nil {
 		return fmt.Errorf("failed to delete physical file: %w", err)
 	}
 
-	if err := s.fileRepo.Delete(file); err != nil {
+	// The blob is gone, so the record goes too even if the client has left
+	ctx = context.WithoutCancel(ctx)
+	if err := s.fileRepo.Delete(ctx, file); err != nil {
 		return fmt.Errorf("failed to delete file metadata: %w", err)
 	}
+	s.variants.DeleteVariants(ctx, file.ID)
+	s.deleteVersions(ctx, file.ID)
+	if err := s.tagRepo.DeleteByFileID(ctx, file.ID); err != nil {
+		log.Printf("[WARN] Failed to delete tags of file %d: %v", file.ID, err)
+	}
+	s.events.Publish(events.NewFileDeleted(file))
 
 	return nil
 }
 
-func (s *FileService) RenameFile(fileID, userID uint, newName string) (*model.File, error) {
-	file, err := s.fileRepo.FindByID(fileID)
+// TransferFile hands a file over to another user, keeping its folder. The
+// target user's limits apply as if they had uploaded it.
+func (s *FileService) TransferFile(ctx context.Context, file *model.File, targetUserID uint) error {
+	if file.UserID == targetUserID {
+		return nil
+	}
+	if err := s.checkWriteOnce(ctx, file); err != nil {
+		return err
+	}
+	if _, err := s.userService.GetUserByID(ctx, targetUserID); err != nil {
+		return err
+	}
+	if err := s.userService.CheckUploadAllowed(ctx, targetUserID, file.FileSize); err != nil {
+		return err
+	}
+
+	// Blobs are content of a single user
+	if err := s.fileRepo.DetachBlob(ctx, file); err != nil {
+		return fmt.Errorf("failed to transfer file: %w", err)
+	}
+	if err := s.fileRepo.UpdateFields(ctx, file.ID, map[string]interface{}{"user_id": targetUserID, "updated_at": time.Now()}); err != nil {
+		return fmt.Errorf("failed to transfer file: %w", err)
+	}
+	previousUserID := file.UserID
+	file.UserID = targetUserID
+	s.events.Publish(events.NewFileTransferred(file, previousUserID))
+	return nil
+}
+
+// removeBlob deletes the stored file of a file record unless another record
+// still uses it
+func (s *FileService) removeBlob(ctx context.Context, file *model.File) error {
+	shared, err := s.sharesStoredFile(ctx, file)
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if shared {
+		metrics.BlobGC.Add("shared_kept", 1)
+		return nil
 	}
+	return s.storage.Retry(ctx, "remove", func() error {
+		if err := os.Remove(file.FilePath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.blobs.Remove(ctx, file.FilePath)
+	})
+}
 
-	if file.UserID != userID {
-		return nil, errors.New("unauthorized to rename this file")
+// sharesStoredFile reports whether another file or a version uses the
+// stored file of file, e.g. a copy or an upload of the same content
+func (s *FileService) sharesStoredFile(ctx context.Context, file *model.File) (bool, error) {
+	others, err := s.fileRepo.CountOthersByFilePath(ctx, file.FilePath, file.ID)
+	if err != nil {
+		return false, err
+	}
+	versions, err := s.versionRepo.CountOthersByFilePath(ctx, file.FilePath, 0)
+	if err != nil {
+		return false, err
+	}
+	return others > 0 || versions > 0, nil
+}
+
+func (s *FileService) RenameFile(ctx context.Context, fileID, userID uint, newName string) (*model.File, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWriteOnce(ctx, file); err != nil {
+		return nil, err
 	}
 
 	// Extract current extension from original filename
@@ -341,12 +1062,12 @@ func (s *FileService) RenameFile(fileID, userID uint, newName string) (*model.Fi
 	// Validate extension: cannot change file extension
 	// If both have extensions, they must match
 	if currentExt != "" && newExt != "" && currentExt != newExt {
-		return nil, errors.New("cannot change file extension")
+		return nil, ErrExtensionChange
 	}
 
 	// If original file has no extension, newName must also have no extension
 	if currentExt == "" && newExt != "" {
-		return nil, errors.New("cannot add extension to file without extension")
+		return nil, ErrExtensionAdd
 	}
 
 	// If newName has no extension and current file has extension, append it
@@ -359,11 +1080,16 @@ func (s *FileService) RenameFile(fileID, userID uint, newName string) (*model.Fi
 	// Sanitize new name
 	newName = s.sanitizeFilename(newName)
 	if newName == "" {
-		return nil, errors.New("invalid filename")
+		return nil, ErrInvalidFilename
 	}
+	if err := s.filenamePolicy().Validate(newName); err != nil {
+		return nil, err
+	}
+	newName = s.filenamePolicy().Apply(newName)
 
 	file.OriginalName = newName
-	if err := s.fileRepo.Update(file); err != nil {
+	file.NameWarning = s.filenamePolicy().Warning(model.NormalizeName(newName))
+	if err := s.fileRepo.Update(ctx, file); err != nil {
 		return nil, fmt.Errorf("failed to rename file: %w", err)
 	}
 
@@ -371,54 +1097,148 @@ func (s *FileService) RenameFile(fileID, userID uint, newName string) (*model.Fi
 	return file, nil
 }
 
-func (s *FileService) RenameFolder(userID uint, oldPath, newName string) error {
-	oldPath = s.sanitizeFolderPath(oldPath)
-	newName = s.sanitizeFilename(newName)
+// PreviewFolderOperation reports how many files a folder operation would touch
+// and issues a confirm token when the operation is above the confirmation threshold
+func (s *FileService) PreviewFolderOperation(ctx context.Context, userID uint, operation, folderPath string) (*FolderOperationSummary, error) {
+	folderPath = model.CleanFolderPath(folderPath)
+	if folderPath == "" {
+		return nil, ErrInvalidFolderPath
+	}
 
-	if oldPath == "" || newName == "" {
-		return errors.New("invalid folder path or name")
+	count, size, err := s.fileRepo.GetFolderStats(ctx, userID, folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect folder: %w", err)
 	}
 
-	// Build new path
-	parts := strings.Split(oldPath, "/")
-	parts[len(parts)-1] = newName
-	newPath := strings.Join(parts, "/")
+	summary := &FolderOperationSummary{
+		Operation: operation,
+		Path:      folderPath,
+		FileCount: count,
+		TotalSize: size,
+	}
+	if s.folderConfirmThreshold > 0 && count > s.folderConfirmThreshold {
+		summary.ConfirmationRequired = true
+		summary.ConfirmToken = s.signFolderToken(userID, operation, folderPath, time.Now().Add(folderConfirmTokenTTL))
+	}
 
-	return s.fileRepo.UpdateFolderPath(userID, oldPath, newPath)
+	return summary, nil
 }
 
-func (s *FileService) DeleteFolder(userID uint, folderPath string) error {
-	folderPath = s.sanitizeFolderPath(folderPath)
-	if folderPath == "" {
-		return errors.New("cannot delete root folder")
+// checkFolderConfirmation returns the operation summary, or ErrConfirmationRequired
+// alongside it when the operation needs a valid confirm token that was not supplied
+func (s *FileService) checkFolderConfirmation(ctx context.Context, userID uint, operation, folderPath, confirmToken string) (*FolderOperationSummary, error) {
+	summary, err := s.PreviewFolderOperation(ctx, userID, operation, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWriteOnceTree(ctx, userID, summary.Path); err != nil {
+		return nil, err
 	}
+	if summary.ConfirmationRequired && !s.verifyFolderToken(confirmToken, userID, operation, summary.Path) {
+		return summary, ErrConfirmationRequired
+	}
+	summary.ConfirmToken = ""
+	return summary, nil
+}
 
-	// Get all files in folder
-	files, err := s.fileRepo.DeleteByFolderPath(userID, folderPath)
+func (s *FileService) signFolderToken(userID uint, operation, folderPath string, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d\n%s\n%s\n%s", userID, operation, folderPath, expiry)
+	return expiry + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *FileService) verifyFolderToken(token string, userID uint, operation, folderPath string) bool {
+	expiry, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	expected := s.signFolderToken(userID, operation, folderPath, time.Unix(unix, 0))
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+func (s *FileService) RenameFolder(ctx context.Context, userID uint, oldPath, newName, confirmToken string) (*FolderOperationSummary, error) {
+	oldPath, newPath, err := s.renamedFolderPath(oldPath, newName)
 	if err != nil {
-		return fmt.Errorf("failed to delete folder: %w", err)
+		return nil, err
+	}
+	return s.moveFolder(ctx, userID, oldPath, newPath, confirmToken)
+}
+
+// PreviewFolderRename is PreviewFolderOperation for a rename, failing like
+// RenameFolder would when the new name or the folder can't be renamed
+func (s *FileService) PreviewFolderRename(ctx context.Context, userID uint, oldPath, newName string) (*FolderOperationSummary, error) {
+	oldPath, newPath, err := s.renamedFolderPath(oldPath, newName)
+	if err != nil {
+		return nil, err
 	}
+	if err := s.folderPolicy.Validate(newPath); err != nil {
+		return nil, err
+	}
+	if err := s.checkWriteOnceTree(ctx, userID, oldPath); err != nil {
+		return nil, err
+	}
+	return s.PreviewFolderOperation(ctx, userID, "rename", oldPath)
+}
+
+// renamedFolderPath returns the cleaned path of a folder and its path once
+// renamed to newName
+func (s *FileService) renamedFolderPath(oldPath, newName string) (string, string, error) {
+	oldPath = model.CleanFolderPath(oldPath)
+	newName = s.sanitizeFilename(newName)
 
-	// Delete physical files
-	for _, file := range files {
-		os.Remove(file.FilePath)
+	if oldPath == "" || newName == "" {
+		return "", "", ErrInvalidFolderName
 	}
 
-	return nil
+	// Build new path
+	parts := strings.Split(oldPath, "/")
+	parts[len(parts)-1] = newName
+	return oldPath, strings.Join(parts, "/"), nil
 }
 
-func (s *FileService) MoveFile(fileID, userID uint, newFolderPath string) (*model.File, error) {
-	file, err := s.fileRepo.FindByID(fileID)
+// moveFolder moves a folder with its files and subfolders to newPath, once
+// confirmed like a rename
+func (s *FileService) moveFolder(ctx context.Context, userID uint, oldPath, newPath, confirmToken string) (*FolderOperationSummary, error) {
+	summary, err := s.checkFolderConfirmation(ctx, userID, "rename", oldPath, confirmToken)
 	if err != nil {
+		return summary, err
+	}
+	if err := s.folderPolicy.Validate(newPath); err != nil {
 		return nil, err
 	}
 
-	if file.UserID != userID {
-		return nil, errors.New("unauthorized to move this file")
+	// Files and folder metadata move together or not at all
+	err = s.fileRepo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.fileRepo.UpdateFolderPath(ctx, userID, oldPath, newPath); err != nil {
+			return err
+		}
+		return s.folderRepo.MovePath(ctx, userID, oldPath, newPath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+func (s *FileService) MoveFile(ctx context.Context, fileID, userID uint, newFolderPath string) (*model.File, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWriteOnce(ctx, file); err != nil {
+		return nil, err
 	}
 
-	file.FolderPath = s.sanitizeFolderPath(newFolderPath)
-	if err := s.fileRepo.Update(file); err != nil {
+	file.FolderPath, err = s.sanitizeFolderPath(newFolderPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.fileRepo.Update(ctx, file); err != nil {
 		return nil, fmt.Errorf("failed to move file: %w", err)
 	}
 
@@ -441,11 +1261,24 @@ var editableTextTypes = map[string]bool{
 }
 
 func (s *FileService) IsEditable(file *model.File) bool {
-	if editableTextTypes[file.MimeType] {
+	return editable(file.MimeType, file.OriginalName)
+}
+
+// MutableUpload reports whether the stored file named name below /uploads
+// may be edited in place. Other stored files never change: new content is
+// stored under a new name.
+func MutableUpload(name string) bool {
+	return editable(detect.ExtensionType(name), name)
+}
+
+// editable reports whether content of a type or with a name can be edited
+// as text
+func editable(mimeType, name string) bool {
+	if editableTextTypes[mimeType] {
 		return true
 	}
 	// Check by extension
-	ext := strings.ToLower(filepath.Ext(file.OriginalName))
+	ext := strings.ToLower(filepath.Ext(name))
 	editableExts := map[string]bool{
 		".txt": true, ".md": true, ".json": true, ".xml": true,
 		".html": true, ".css": true, ".csv": true, ".yaml": true,
@@ -454,26 +1287,26 @@ func (s *FileService) IsEditable(file *model.File) bool {
 	return editableExts[ext]
 }
 
-func (s *FileService) GetFileContent(fileID, userID uint) (string, error) {
-	file, err := s.fileRepo.FindByID(fileID)
+func (s *FileService) GetFileContent(ctx context.Context, fileID, userID uint) (string, error) {
+	file, err := s.findFile(ctx, fileID, userID)
 	if err != nil {
 		return "", err
 	}
 
-	if file.UserID != userID {
-		return "", errors.New("unauthorized to read this file")
+	if err := CheckDownload(file); err != nil {
+		return "", err
 	}
 
 	if !s.IsEditable(file) {
-		return "", errors.New("file is not editable")
+		return "", ErrFileNotEditable
 	}
 
 	// Limit file size for editing (max 1MB)
 	if file.FileSize > 1024*1024 {
-		return "", errors.New("file too large to edit")
+		return "", ErrFileTooLargeToEdit
 	}
 
-	content, err := os.ReadFile(file.FilePath)
+	content, err := s.readContent(ctx, file)
 	if err != nil {
 		return "", fmt.Errorf("failed to read file: %w", err)
 	}
@@ -481,31 +1314,144 @@ func (s *FileService) GetFileContent(fileID, userID uint) (string, error) {
 	return string(content), nil
 }
 
-func (s *FileService) UpdateFileContent(fileID, userID uint, content string) (*model.File, error) {
-	file, err := s.fileRepo.FindByID(fileID)
+// Locate returns the path to read a file from, which is its mirror when the
+// primary copy is unavailable. It fails with ErrStorageUnavailable when
+// storage is down and there is no mirror to fall back to.
+func (s *FileService) Locate(ctx context.Context, file *model.File) (string, error) {
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		log.Printf("[WARN] %v", err)
+	}
+	err := s.storage.Retry(ctx, "stat", func() error {
+		_, err := os.Stat(file.FilePath)
+		return err
+	})
+	if err == nil {
+		return file.FilePath, nil
+	}
+	if mirror, ok := s.replica.Mirror(file.FilePath); ok {
+		log.Printf("[WARN] Reading %s from the replica: %v", file.FilePath, err)
+		return mirror, nil
+	}
+	if errors.Is(err, ErrStorageUnavailable) {
+		return "", err
+	}
+	return file.FilePath, nil
+}
+
+// readContent returns the content of a file as uploaded
+func (s *FileService) readContent(ctx context.Context, file *model.File) ([]byte, error) {
+	path, err := s.Locate(ctx, file)
 	if err != nil {
 		return nil, err
 	}
+	var content []byte
+	err = s.storage.Retry(ctx, "read", func() error {
+		r, err := OpenStored(path, file.Compression)
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		content, err = io.ReadAll(r)
+		return err
+	})
+	return content, err
+}
 
-	if file.UserID != userID {
-		return nil, errors.New("unauthorized to edit this file")
+func (s *FileService) UpdateFileContent(ctx context.Context, fileID, userID uint, content string) (*model.File, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkWriteOnce(ctx, file); err != nil {
+		return nil, err
 	}
 
 	if !s.IsEditable(file) {
-		return nil, errors.New("file is not editable")
+		return nil, ErrFileNotEditable
 	}
 
-	// Write content to file
-	if err := os.WriteFile(file.FilePath, []byte(content), 0644); err != nil {
+	// Compress the new content by the current settings, which may move the
+	// file to a path with a different suffix
+	oldPath := file.FilePath
+	compression := s.compressor.Algorithm(file.MimeType, int64(len(content)))
+	filePath := strings.TrimSuffix(oldPath, CompressionSuffix(file.Compression)) + CompressionSuffix(compression)
+	var previous *model.FileVersion
+	if s.VersioningEnabled() {
+		previous = newVersion(file)
+	}
+	// Only names served as mutable are edited in place, see MutableUpload,
+	// and only when no other file or version has the content
+	shared, err := s.sharesStoredFile(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	if !MutableUpload(file.Filename) || shared {
+		file.Filename = uuid.New().String() + filepath.Ext(file.Filename)
+		filePath = filepath.Join(filepath.Dir(oldPath), file.Filename) + CompressionSuffix(compression)
+	}
+	// The content edited in place is copied aside to keep it
+	if previous != nil && filePath == oldPath {
+		if previous.FilePath, err = s.preserveContent(ctx, file); err != nil {
+			return nil, fmt.Errorf("failed to keep file version: %w", err)
+		}
+		previous.Filename = strings.TrimSuffix(filepath.Base(previous.FilePath), CompressionSuffix(previous.Compression))
+	}
+
+	// Replace the file atomically so readers see the old or the new content
+	var digest *contentDigest
+	var storedSize int64
+	err = s.storage.Retry(ctx, "write", func() error {
+		digest = newContentDigest()
+		storedSize, err = writeStored(ctx, s.temp, filePath, compression, strings.NewReader(content), digest)
+		return err
+	})
+	if err == nil {
+		err = s.blobs.Publish(ctx, filePath)
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to write file: %w", err)
 	}
 
-	// Update file size
-	file.FileSize = int64(len(content))
-	if err := s.fileRepo.Update(file); err != nil {
+	// Update file size and checksums
+	file.FilePath, file.BlobID = filePath, nil
+	digest.apply(file)
+	file.Compression, file.StoredSize = compression, 0
+	if compression != "" {
+		file.StoredSize = storedSize
+	}
+	if err := s.saveReplaced(ctx, file, previous, nil, false); err != nil {
 		return nil, fmt.Errorf("failed to update file metadata: %w", err)
 	}
+	previousPath := oldPath
+	if previous != nil {
+		// Kept as a version, mirror included
+		previousPath = ""
+	} else if filePath != oldPath {
+		if err := s.removeBlob(ctx, &model.File{ID: file.ID, FilePath: oldPath}); err != nil {
+			log.Printf("[WARN] Failed to remove replaced file %s: %v", oldPath, err)
+		}
+	}
+	s.events.Publish(events.NewFileUpdated(file, previousPath))
 
 	s.generateFileURL(file)
 	return file, nil
 }
+
+// FindBlob returns a file with the content of SHA-256 checksum sha256, for
+// /blob URLs. Files with the same content may differ in type; the oldest is
+// returned. Blob URLs aren't available while uploads are private, as they
+// can't be signed for a single file.
+func (s *FileService) FindBlob(ctx context.Context, sha256 string) (*model.File, error) {
+	sha256 = strings.ToLower(sha256)
+	if _, err := hex.DecodeString(sha256); err != nil || len(sha256) != 64 || s.media.Private() {
+		return nil, ErrFileNotFound
+	}
+	file, err := s.fileRepo.FindDownloadableBySHA256(ctx, sha256)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
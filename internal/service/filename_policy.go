@@ -0,0 +1,101 @@
+package service
+
+import (
+	"storage-service/internal/model"
+	"strings"
+)
+
+// FilenamePolicy controls how uploaded filenames are checked for hidden or
+// double extensions such as invoice.pdf.exe or shell.php.jpg
+type FilenamePolicy struct {
+	// Strict checks every extension segment instead of only the last one
+	Strict bool
+	// Normalize rewrites inner dots of stored names so only the last extension remains
+	Normalize bool
+	// Flag records why names may be misleading, see model.NameWarning
+	Flag bool
+}
+
+// bidiControls are characters that can visually reorder a filename to hide its extension
+var bidiControls = []string{"\u202a", "\u202b", "\u202c", "\u202d", "\u202e", "\u2066", "\u2067", "\u2068", "\u2069"}
+
+// Validate rejects filenames whose extensions are dangerous
+func (p FilenamePolicy) Validate(name string) error {
+	if p.Strict {
+		for _, c := range bidiControls {
+			if strings.Contains(name, c) {
+				return ErrInvalidFilename
+			}
+		}
+	}
+
+	segments := extensionSegments(name)
+	if len(segments) == 0 {
+		return nil
+	}
+	if !p.Strict {
+		segments = segments[len(segments)-1:]
+	}
+	for _, ext := range segments {
+		if dangerousExtensions[ext] {
+			return ErrFileTypeNotAllowed
+		}
+	}
+	return nil
+}
+
+// Apply returns the name to store, collapsing inner extension dots when normalization is enabled
+func (p FilenamePolicy) Apply(name string) string {
+	if !p.Normalize {
+		return name
+	}
+	name = trimHiddenSuffix(name)
+	dot := strings.LastIndex(name, ".")
+	if dot <= 0 {
+		return name
+	}
+	base, ext := name[:dot], name[dot:]
+	// Keep a leading dot of hidden files like .env
+	leading := ""
+	if strings.HasPrefix(base, ".") {
+		leading, base = ".", base[1:]
+	}
+	return leading + strings.ReplaceAll(base, ".", "_") + ext
+}
+
+// Warning returns the name warning to store with a file named name, "" when
+// flagging is off
+func (p FilenamePolicy) Warning(name string) string {
+	if !p.Flag {
+		return ""
+	}
+	return model.NameWarning(name)
+}
+
+// extensionSegments returns every extension in a filename in order, lowercased
+// with a leading dot, e.g. "shell.PHP.jpg" -> [".php", ".jpg"]
+func extensionSegments(name string) []string {
+	name = strings.ToLower(trimHiddenSuffix(name))
+	name = strings.TrimPrefix(name, ".")
+	parts := strings.Split(name, ".")
+	if len(parts) < 2 {
+		if strings.HasPrefix(strings.ToLower(name), "htaccess") {
+			return []string{".htaccess"}
+		}
+		return nil
+	}
+
+	segments := make([]string, 0, len(parts)-1)
+	for _, part := range parts[1:] {
+		if part = strings.TrimSpace(part); part != "" {
+			segments = append(segments, "."+part)
+		}
+	}
+	return segments
+}
+
+// trimHiddenSuffix removes trailing dots and spaces, which Windows drops when
+// saving a file and which can hide the real extension ("evil.exe. ")
+func trimHiddenSuffix(name string) string {
+	return strings.TrimRight(name, ". ")
+}
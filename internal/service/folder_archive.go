@@ -0,0 +1,116 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+)
+
+// FolderArchive is a folder ready to be streamed as a ZIP archive, see
+// FileService.OpenFolderArchive
+type FolderArchive struct {
+	// Name is the archive's file name, the folder's name with ".zip"
+	Name string
+
+	files  *FileService
+	userID uint
+	path   string
+}
+
+// storedKinds are already compressed, so they are stored in archives
+// without deflating them again
+var storedKinds = map[string]bool{model.KindImage: true, model.KindVideo: true, model.KindAudio: true, model.KindArchive: true}
+
+// OpenFolderArchive checks that a folder of userID exists; the empty path
+// archives the whole account
+func (s *FileService) OpenFolderArchive(ctx context.Context, userID uint, folderPath string) (*FolderArchive, error) {
+	folderPath = model.CleanFolderPath(folderPath)
+	name := "files"
+	if folderPath != "" {
+		exists, err := s.folderExists(ctx, userID, folderPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect folder: %w", err)
+		}
+		if !exists {
+			return nil, ErrFolderNotFound
+		}
+		name = path.Base(folderPath)
+	}
+	return &FolderArchive{Name: name + ".zip", files: s, userID: userID, path: folderPath}, nil
+}
+
+// WriteTo streams the files of the folder and its subfolders to w as a ZIP
+// archive, one file at a time, so only a batch of records and the copy
+// buffer are held in memory. Entries are named by their path below the
+// folder. Scratch files are left out, like in listings, and so are files
+// that can't be downloaded, such as infected ones. Once writing has started
+// an error leaves the archive truncated, which unzip tools report.
+func (a *FolderArchive) WriteTo(ctx context.Context, w io.Writer) error {
+	archive := zip.NewWriter(w)
+	filter := repository.FileFilter{UserIDs: []uint{a.userID}, Folder: &a.path, Recursive: true}
+	names := make(map[string]bool)
+
+	var cursor uint
+	for {
+		files, err := a.files.fileRepo.FindBatchAfter(ctx, filter, cursor, jobBatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			file := &files[i]
+			cursor = file.ID
+			if file.ExpiresAt != nil || CheckDownload(file) != nil {
+				continue
+			}
+			if err := a.add(ctx, archive, file, names); err != nil {
+				return err
+			}
+		}
+		if len(files) < jobBatchSize {
+			break
+		}
+	}
+	return archive.Close()
+}
+
+// add copies one file into archive under a name not used yet
+func (a *FolderArchive) add(ctx context.Context, archive *zip.Writer, file *model.File, names map[string]bool) error {
+	dir := strings.TrimPrefix(strings.TrimPrefix(file.FolderPath, a.path), "/")
+	name := path.Join(dir, file.OriginalName)
+	if names[name] {
+		ext := path.Ext(file.OriginalName)
+		name = path.Join(dir, fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(file.OriginalName, ext), file.ID, ext))
+	}
+	names[name] = true
+
+	filePath, err := a.files.Locate(ctx, file)
+	if err != nil {
+		return err
+	}
+	src, err := OpenStored(filePath, file.Compression)
+	if err != nil {
+		// A missing stored file shouldn't fail the rest of the archive
+		log.Printf("[WARN] Failed to archive file %d: %v", file.ID, err)
+		return nil
+	}
+	defer src.Close()
+
+	header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: file.UpdatedAt}
+	if storedKinds[file.Kind] {
+		header.Method = zip.Store
+	}
+	dst, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, contextReader{ctx, src}); err != nil {
+		return fmt.Errorf("failed to archive file %d: %w", file.ID, err)
+	}
+	return nil
+}
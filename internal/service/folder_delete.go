@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"log"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"time"
+)
+
+// JobDeleteFolder deletes a folder with its subfolders and files
+const JobDeleteFolder = "delete_folder"
+
+// DeleteFolderParams names the folder a delete job removes
+type DeleteFolderParams struct {
+	UserID uint   `json:"user_id"`
+	Path   string `json:"path"`
+}
+
+// FolderDeleteService deletes folders in the background. A folder with tens
+// of thousands of files would otherwise hold the request, and the disk,
+// until every stored file is removed. Files are deleted in batches, moved to
+// the trash when it is enabled, so the job reports its progress and can be
+// cancelled, which keeps the files not deleted yet. Stored files are removed
+// at FOLDER_DELETE_RATE.
+type FolderDeleteService struct {
+	fileRepo   *repository.FileRepository
+	folderRepo *repository.FolderRepository
+	files      *FileService
+	jobs       *JobService
+	interval   time.Duration // between removals of stored files, 0 when unthrottled
+}
+
+func NewFolderDeleteService(fileRepo *repository.FileRepository, folderRepo *repository.FolderRepository, files *FileService, jobs *JobService, cfg *config.Config) *FolderDeleteService {
+	s := &FolderDeleteService{
+		fileRepo:   fileRepo,
+		folderRepo: folderRepo,
+		files:      files,
+		jobs:       jobs,
+	}
+	if cfg.FolderDeleteRate > 0 {
+		s.interval = time.Second / time.Duration(cfg.FolderDeleteRate)
+	}
+	jobs.Register(JobDeleteFolder, s.step)
+	return s
+}
+
+// Start queues the deletion of a folder of userID, once confirmed like any
+// folder operation, see FileService.PreviewFolderOperation
+func (s *FolderDeleteService) Start(ctx context.Context, userID uint, folderPath, confirmToken string) (*FolderOperationSummary, *model.Job, error) {
+	folderPath = model.CleanFolderPath(folderPath)
+	if folderPath == "" {
+		return nil, nil, ErrRootFolder
+	}
+
+	summary, err := s.files.checkFolderConfirmation(ctx, userID, "delete", folderPath, confirmToken)
+	if err != nil {
+		return summary, nil, err
+	}
+
+	job, err := s.jobs.Enqueue(ctx, JobDeleteFolder, DeleteFolderParams{UserID: userID, Path: summary.Path}, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	return summary, job, nil
+}
+
+func (s *FolderDeleteService) step(ctx context.Context, job *model.Job) (bool, error) {
+	var params DeleteFolderParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+	if params.Path == "" {
+		return false, ErrRootFolder
+	}
+	// The folder may have become write-once since the job was queued
+	if err := s.files.checkWriteOnceTree(ctx, params.UserID, params.Path); err != nil {
+		return false, err
+	}
+
+	filter := repository.FileFilter{UserIDs: []uint{params.UserID}, Folder: &params.Path, Recursive: true}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	next := time.Now()
+	for i := range files {
+		file := &files[i]
+		// Trashed files keep their content until they are purged
+		if s.files.TrashEnabled() && file.ExpiresAt == nil {
+			err = s.files.trashFile(ctx, file)
+		} else {
+			if s.interval > 0 {
+				if !sleepUntil(ctx, next) {
+					// Shutting down; the job resumes after the last file done
+					return false, nil
+				}
+				next = time.Now().Add(s.interval)
+			}
+			err = s.files.deleteFile(ctx, file)
+		}
+		if err != nil {
+			log.Printf("[WARN] Failed to delete file %d of folder %q: %v", file.ID, params.Path, err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = file.ID
+	}
+	if len(files) == jobBatchSize {
+		return false, nil
+	}
+
+	// The folder still exists through the files that failed, so its
+	// metadata stays with them
+	if job.Failed == 0 {
+		if err := s.folderRepo.DeleteTree(ctx, params.UserID, params.Path); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// sleepUntil waits until t and reports false if ctx ends first
+func sleepUntil(ctx context.Context, t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"storage-service/internal/model"
+	"strings"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+)
+
+// maxFolderDescription limits folder descriptions, in characters
+const maxFolderDescription = 500
+
+// FolderMetaInput changes the metadata of a folder; nil fields are kept and
+// an empty color removes the label
+type FolderMetaInput struct {
+	Path        string  `json:"path" binding:"required"`
+	Starred     *bool   `json:"starred"`
+	Color       *string `json:"color"`
+	Description *string `json:"description"`
+	// WriteOnce can only be turned on; admins release folders, see
+	// FileService.ReleaseWriteOnce
+	WriteOnce *bool `json:"write_once"`
+}
+
+// GetFolderMeta returns the stored folders of the user that still exist,
+// given the folder list returned by GetFolders
+func (s *FileService) GetFolderMeta(ctx context.Context, userID uint, folders []string) ([]model.Folder, error) {
+	stored, err := s.folderRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make([]model.Folder, 0, len(stored))
+	for _, folder := range stored {
+		if folderExists(folder.Path, folders) {
+			meta = append(meta, folder)
+		}
+	}
+	return meta, nil
+}
+
+// folderExists reports whether path is one of folders or a parent of one
+func folderExists(path string, folders []string) bool {
+	for _, f := range folders {
+		if f == path || strings.HasPrefix(f, path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// UpdateFolderMeta stars, labels, describes or makes write-once a folder
+// that was created or contains files
+func (s *FileService) UpdateFolderMeta(ctx context.Context, userID uint, input FolderMetaInput) (*model.Folder, error) {
+	path := model.CleanFolderPath(input.Path)
+	if path == "" {
+		return nil, ErrInvalidFolderPath
+	}
+	exists, err := s.folderExists(ctx, userID, path)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, ErrFolderNotFound
+	}
+
+	folder, err := s.folderRepo.FindByPath(ctx, userID, path)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		folder, err = &model.Folder{UserID: userID, Path: path}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Starred != nil {
+		folder.Starred = *input.Starred
+	}
+	if input.Color != nil {
+		color := strings.ToLower(strings.TrimSpace(*input.Color))
+		if color != "" && !validFolderColor(color) {
+			return nil, ErrInvalidFolderColor
+		}
+		folder.Color = color
+	}
+	if input.Description != nil {
+		description := strings.TrimSpace(*input.Description)
+		if utf8.RuneCountInString(description) > maxFolderDescription {
+			return nil, ErrDescriptionTooLong.WithArgs(maxFolderDescription)
+		}
+		folder.Description = description
+	}
+	if input.WriteOnce != nil && *input.WriteOnce != folder.WriteOnce {
+		if !*input.WriteOnce {
+			return nil, ErrWriteOnceAdminOnly
+		}
+		folder.WriteOnce = true
+	}
+
+	if err := s.folderRepo.Save(ctx, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
+
+func validFolderColor(color string) bool {
+	for _, c := range model.FolderColors {
+		if c == color {
+			return true
+		}
+	}
+	return false
+}
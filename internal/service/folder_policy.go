@@ -0,0 +1,82 @@
+package service
+
+import (
+	"storage-service/internal/config"
+	"strings"
+)
+
+// windowsDeviceNames can't be used as file or folder names on Windows, with
+// or without an extension, so folders named after them break archives and
+// exports extracted there
+var windowsDeviceNames = map[string]bool{
+	"con": true, "prn": true, "aux": true, "nul": true,
+	"com1": true, "com2": true, "com3": true, "com4": true, "com5": true, "com6": true, "com7": true, "com8": true, "com9": true,
+	"lpt1": true, "lpt2": true, "lpt3": true, "lpt4": true, "lpt5": true, "lpt6": true, "lpt7": true, "lpt8": true, "lpt9": true,
+}
+
+// FolderPolicy bounds the folder trees users create, so pathological paths
+// such as thousands of nested folders can't be stored
+type FolderPolicy struct {
+	// MaxDepth is the most folders a path may nest, 0 for no limit
+	MaxDepth int `json:"max_depth"`
+	// MaxLength is the longest a path may be in characters, 0 for no limit
+	MaxLength int `json:"max_path_length"`
+	// Reserved names may not be used for any folder of a path, compared
+	// case-insensitively; "." and ".." and Windows device names never can
+	Reserved []string `json:"reserved_names"`
+}
+
+// newFolderPolicy reads FOLDER_MAX_DEPTH, FOLDER_MAX_PATH_LENGTH and
+// RESERVED_FOLDER_NAMES, comma separated names such as "trash,.git"
+func newFolderPolicy(cfg *config.Config) FolderPolicy {
+	policy := FolderPolicy{MaxDepth: cfg.FolderMaxDepth, MaxLength: cfg.FolderMaxPathLength, Reserved: []string{}}
+	for _, name := range strings.Split(cfg.ReservedFolderNames, ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			policy.Reserved = append(policy.Reserved, name)
+		}
+	}
+	return policy
+}
+
+// Validate checks a cleaned folder path, see model.CleanFolderPath; the root
+// folder "" is always valid
+func (p FolderPolicy) Validate(path string) error {
+	if path == "" {
+		return nil
+	}
+	if p.MaxLength > 0 && len([]rune(path)) > p.MaxLength {
+		return ErrFolderPathTooLong.WithArgs(p.MaxLength)
+	}
+	segments := strings.Split(path, "/")
+	if p.MaxDepth > 0 && len(segments) > p.MaxDepth {
+		return ErrFolderTooDeep.WithArgs(p.MaxDepth)
+	}
+	for _, segment := range segments {
+		if strings.TrimSpace(segment) == "" {
+			return ErrInvalidFolderPath
+		}
+		if p.reserved(segment) {
+			return ErrReservedFolderName.WithArgs(segment)
+		}
+	}
+	return nil
+}
+
+func (p FolderPolicy) reserved(name string) bool {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "." || name == ".." {
+		return true
+	}
+	// Windows ignores trailing dots and spaces and what follows the first
+	// dot when matching device names, so "con.txt" is reserved too
+	device, _, _ := strings.Cut(strings.TrimRight(name, ". "), ".")
+	if windowsDeviceNames[device] {
+		return true
+	}
+	for _, reserved := range p.Reserved {
+		if name == reserved {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,389 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobApplyFolderRules runs the actions of folder rules on a new file
+const JobApplyFolderRules = "apply_folder_rules"
+
+// ApplyFolderRulesParams names the file and the rules that matched it
+type ApplyFolderRulesParams struct {
+	FileID  uint   `json:"file_id"`
+	RuleIDs []uint `json:"rule_ids"`
+	// Event is the event the rules react to; empty for file.created. After
+	// processing.completed only webhooks are called.
+	Event string `json:"event,omitempty"`
+}
+
+// FolderRuleInput is the editable part of a folder rule
+type FolderRuleInput struct {
+	Name       string `json:"name"`
+	FolderPath string `json:"folder_path"`
+	Recursive  bool   `json:"recursive"`
+	Enabled    *bool  `json:"enabled"`
+	Profile    string `json:"profile"`
+	WebhookURL string `json:"webhook_url"`
+	// ExpireAfter deletes files older than this, e.g. "7d" or "12h"
+	ExpireAfter string `json:"expire_after"`
+}
+
+// folderRuleWebhook is the body POSTed to a rule's webhook URL
+type folderRuleWebhook struct {
+	Event  string      `json:"event"`
+	RuleID uint        `json:"rule_id"`
+	File   *model.File `json:"file"`
+	Time   time.Time   `json:"time"`
+}
+
+// FolderRuleService manages folder rules and runs them: actions on new files
+// as background jobs, expiry as a periodic task
+type FolderRuleService struct {
+	ruleRepo     *repository.FolderRuleRepository
+	fileRepo     *repository.FileRepository
+	fileService  *FileService
+	imageService *ImageService
+	jobs         *JobService
+	profiles     ImageProfiles
+	client       *http.Client
+}
+
+func NewFolderRuleService(ruleRepo *repository.FolderRuleRepository, fileRepo *repository.FileRepository, fileService *FileService, imageService *ImageService, jobs *JobService, bus *events.Bus) *FolderRuleService {
+	s := &FolderRuleService{
+		ruleRepo:     ruleRepo,
+		fileRepo:     fileRepo,
+		fileService:  fileService,
+		imageService: imageService,
+		jobs:         jobs,
+		profiles:     imageService.profiles,
+		// Webhook URLs are user supplied, so only public addresses are called
+		client: newProxyClient(),
+	}
+	jobs.Register(JobApplyFolderRules, s.step)
+	bus.Subscribe(events.FileCreated, s.onFileCreated)
+	bus.Subscribe(events.ProcessingCompleted, s.onProcessingCompleted)
+	return s
+}
+
+func (s *FolderRuleService) ListRules(ctx context.Context, userID uint, folderPath *string) ([]model.FolderRule, error) {
+	if folderPath != nil {
+		folder := model.CleanFolderPath(*folderPath)
+		folderPath = &folder
+	}
+	return s.ruleRepo.FindByUserID(ctx, userID, folderPath)
+}
+
+func (s *FolderRuleService) CreateRule(ctx context.Context, userID uint, input FolderRuleInput) (*model.FolderRule, error) {
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+
+	rule := &model.FolderRule{UserID: userID, Enabled: true, WebhookSecret: hex.EncodeToString(secret)}
+	if err := s.applyInput(rule, input); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Create(ctx, rule); err != nil {
+		return nil, fmt.Errorf("failed to create folder rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (s *FolderRuleService) UpdateRule(ctx context.Context, ruleID, userID uint, input FolderRuleInput) (*model.FolderRule, error) {
+	rule, err := s.findRule(ctx, ruleID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyInput(rule, input); err != nil {
+		return nil, err
+	}
+	if err := s.ruleRepo.Update(ctx, rule); err != nil {
+		return nil, fmt.Errorf("failed to update folder rule: %w", err)
+	}
+	return rule, nil
+}
+
+func (s *FolderRuleService) DeleteRule(ctx context.Context, ruleID, userID uint) error {
+	rule, err := s.findRule(ctx, ruleID, userID)
+	if err != nil {
+		return err
+	}
+	return s.ruleRepo.Delete(ctx, rule)
+}
+
+func (s *FolderRuleService) findRule(ctx context.Context, ruleID, userID uint) (*model.FolderRule, error) {
+	rule, err := s.ruleRepo.FindByIDAndUser(ctx, ruleID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFolderRuleNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+func (s *FolderRuleService) applyInput(rule *model.FolderRule, input FolderRuleInput) error {
+	if input.Profile != "" {
+		if _, err := s.profiles.Get(input.Profile); err != nil {
+			return err
+		}
+	}
+	if input.WebhookURL != "" {
+		target, err := url.Parse(input.WebhookURL)
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Hostname() == "" {
+			return ErrInvalidWebhookURL
+		}
+	}
+	if input.ExpireAfter != "" {
+		if _, err := ParseExpireAfter(input.ExpireAfter); err != nil {
+			return err
+		}
+	}
+	if input.Profile == "" && input.WebhookURL == "" && input.ExpireAfter == "" {
+		return ErrFolderRuleNoAction
+	}
+
+	folderPath, err := s.fileService.sanitizeFolderPath(input.FolderPath)
+	if err != nil {
+		return err
+	}
+
+	rule.Name = strings.TrimSpace(input.Name)
+	rule.FolderPath = folderPath
+	rule.Recursive = input.Recursive
+	if input.Enabled != nil {
+		rule.Enabled = *input.Enabled
+	}
+	rule.Profile = input.Profile
+	rule.WebhookURL = input.WebhookURL
+	rule.ExpireAfter = input.ExpireAfter
+	return nil
+}
+
+// ParseExpireAfter parses a retention such as "7d" or "36h"
+func ParseExpireAfter(value string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(value)
+	}
+	if err != nil || d <= 0 {
+		return 0, ErrInvalidExpireAfter.WithArgs(value)
+	}
+	return d, nil
+}
+
+// onFileCreated queues a job for the rules matching the folder of a new file
+func (s *FolderRuleService) onFileCreated(event events.Event) {
+	rules, err := s.ruleRepo.FindMatching(context.Background(), event.UserID, event.File.FolderPath)
+	if err != nil {
+		log.Printf("[WARN] Failed to load folder rules for file %d: %v", event.File.ID, err)
+		return
+	}
+
+	var ruleIDs []uint
+	for _, rule := range rules {
+		if rule.Profile != "" || rule.WebhookURL != "" {
+			ruleIDs = append(ruleIDs, rule.ID)
+		}
+	}
+	if len(ruleIDs) == 0 {
+		return
+	}
+
+	params := ApplyFolderRulesParams{FileID: event.File.ID, RuleIDs: ruleIDs}
+	if _, err := s.jobs.Enqueue(context.Background(), JobApplyFolderRules, params, event.UserID); err != nil {
+		log.Printf("[WARN] Failed to queue folder rules for file %d: %v", event.File.ID, err)
+	}
+}
+
+// onProcessingCompleted queues a job calling the webhooks of the rules
+// matching the folder of a file whose derived assets are ready
+func (s *FolderRuleService) onProcessingCompleted(event events.Event) {
+	rules, err := s.ruleRepo.FindMatching(context.Background(), event.UserID, event.File.FolderPath)
+	if err != nil {
+		log.Printf("[WARN] Failed to load folder rules for file %d: %v", event.File.ID, err)
+		return
+	}
+
+	var ruleIDs []uint
+	for _, rule := range rules {
+		if rule.WebhookURL != "" {
+			ruleIDs = append(ruleIDs, rule.ID)
+		}
+	}
+	if len(ruleIDs) == 0 {
+		return
+	}
+
+	params := ApplyFolderRulesParams{FileID: event.File.ID, RuleIDs: ruleIDs, Event: events.ProcessingCompleted}
+	if _, err := s.jobs.Enqueue(context.Background(), JobApplyFolderRules, params, event.UserID); err != nil {
+		log.Printf("[WARN] Failed to queue folder rules for file %d: %v", event.File.ID, err)
+	}
+}
+
+func (s *FolderRuleService) step(ctx context.Context, job *model.Job) (bool, error) {
+	var params ApplyFolderRulesParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+
+	rules, err := s.ruleRepo.FindByIDs(ctx, params.RuleIDs)
+	if err != nil {
+		return false, err
+	}
+	job.Total = int64(len(rules))
+	event := params.Event
+	if event == "" {
+		event = events.FileCreated
+	}
+
+	for i := range rules {
+		if rules[i].ID <= job.Cursor {
+			continue
+		}
+		// Re-read the file, an earlier rule may have converted it
+		file, err := s.fileService.getFile(ctx, params.FileID)
+		if errors.Is(err, ErrFileNotFound) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+
+		if rules[i].Enabled {
+			if err := s.applyRule(ctx, &rules[i], file, event); err != nil {
+				log.Printf("[WARN] Folder rule %d failed on file %d: %v", rules[i].ID, file.ID, err)
+				job.Failed++
+			}
+		}
+		job.Processed++
+		job.Cursor = rules[i].ID
+	}
+	return true, nil
+}
+
+func (s *FolderRuleService) applyRule(ctx context.Context, rule *model.FolderRule, file *model.File, event string) error {
+	if event == events.ProcessingCompleted {
+		if rule.WebhookURL == "" {
+			return nil
+		}
+		variants, err := s.imageService.variants.GetVariants(ctx, file.ID)
+		if err != nil {
+			return err
+		}
+		file.Variants = variants
+		return s.notify(ctx, rule, file, event)
+	}
+
+	if rule.Profile != "" {
+		// Files of write-once folders keep the bytes they were stored with
+		err := s.fileService.checkWriteOnce(ctx, file)
+		if err == nil {
+			var converted bool
+			if converted, err = s.imageService.ApplyProfile(ctx, file, rule.Profile); converted {
+				s.fileService.generateFileURL(file)
+			}
+		}
+		if err != nil && !errors.Is(err, ErrWriteOnceFolder) {
+			return err
+		}
+	}
+	if rule.WebhookURL != "" {
+		return s.notify(ctx, rule, file, event)
+	}
+	return nil
+}
+
+func (s *FolderRuleService) notify(ctx context.Context, rule *model.FolderRule, file *model.File, event string) error {
+	body, err := json.Marshal(folderRuleWebhook{Event: event, RuleID: rule.ID, File: file, Time: time.Now()})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rule.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(rule.WebhookSecret))
+	mac.Write(body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Storage-Event", event)
+	req.Header.Set("X-Storage-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// ExpireFiles deletes files older than the retention of their folder's rules
+func (s *FolderRuleService) ExpireFiles(ctx context.Context) error {
+	rules, err := s.ruleRepo.FindExpiring(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		retention, err := ParseExpireAfter(rule.ExpireAfter)
+		if err != nil {
+			continue
+		}
+
+		filter := repository.FileFilter{
+			UserIDs:       []uint{rule.UserID},
+			Folder:        &rule.FolderPath,
+			Recursive:     rule.Recursive,
+			CreatedBefore: time.Now().Add(-retention),
+			Unpinned:      true,
+		}
+		var afterID uint
+		for ctx.Err() == nil {
+			files, err := s.fileRepo.FindBatchAfter(ctx, filter, afterID, jobBatchSize)
+			if err != nil {
+				return err
+			}
+			for _, file := range files {
+				err := s.fileService.DeleteFile(ctx, file.ID, file.UserID)
+				// Files of write-once folders outlive the retention
+				if err != nil && !errors.Is(err, ErrWriteOnceFolder) {
+					log.Printf("[WARN] Failed to expire file %d by folder rule %d: %v", file.ID, rule.ID, err)
+				}
+				afterID = file.ID
+			}
+			if len(files) < jobBatchSize {
+				break
+			}
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// FolderInfo is a folder with what it holds
+type FolderInfo struct {
+	model.Folder
+	// FileCount and TotalSize count the files of the folder and its
+	// subfolders
+	FileCount  int64          `json:"file_count"`
+	TotalSize  int64          `json:"total_size"`
+	Subfolders []model.Folder `json:"subfolders"`
+}
+
+// FolderNode is a folder of a tree returned by GetFolderTree. Folders that
+// only exist through their files have no ID.
+type FolderNode struct {
+	model.Folder
+	// FileCount counts the files directly in the folder
+	FileCount int64         `json:"file_count"`
+	Children  []*FolderNode `json:"children"`
+}
+
+// CreateFolder creates an empty folder of userID, and its parents unless
+// they exist
+func (s *FileService) CreateFolder(ctx context.Context, userID uint, folderPath string) (*model.Folder, error) {
+	folderPath, err := s.sanitizeFolderPath(folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if folderPath == "" {
+		return nil, ErrInvalidFolderPath
+	}
+	exists, err := s.folderExists(ctx, userID, folderPath)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrFolderExists
+	}
+
+	if err := s.folderRepo.CreateTree(ctx, model.FolderTree(userID, folderPath)); err != nil {
+		return nil, err
+	}
+	return s.folderRepo.FindByPath(ctx, userID, folderPath)
+}
+
+// folderExists reports whether a folder of userID was created or holds files
+func (s *FileService) folderExists(ctx context.Context, userID uint, folderPath string) (bool, error) {
+	_, err := s.folderRepo.FindByPath(ctx, userID, folderPath)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return false, err
+	}
+	count, _, err := s.fileRepo.GetFolderStats(ctx, userID, folderPath)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// GetFolder returns a folder of userID by ID with its subfolders
+func (s *FileService) GetFolder(ctx context.Context, userID, folderID uint) (*FolderInfo, error) {
+	folder, err := s.folderRepo.FindByIDAndUser(ctx, folderID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &FolderInfo{Folder: *folder}
+	if info.FileCount, info.TotalSize, err = s.fileRepo.GetFolderStats(ctx, userID, folder.Path); err != nil {
+		return nil, err
+	}
+	if info.Subfolders, err = s.folderRepo.FindChildren(ctx, userID, folder.Path); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// GetFolderTree returns every folder of userID as a tree, top-level folders
+// first, each with its subfolders in alphabetical order
+func (s *FileService) GetFolderTree(ctx context.Context, userID uint) ([]*FolderNode, error) {
+	stored, err := s.folderRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	counts, err := s.fileRepo.CountByFolder(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make(map[string]*FolderNode, len(stored))
+	for _, folder := range stored {
+		nodes[folder.Path] = &FolderNode{Folder: folder, Children: []*FolderNode{}}
+	}
+	for folderPath, count := range counts {
+		for _, folder := range model.FolderTree(userID, folderPath) {
+			if nodes[folder.Path] == nil {
+				folder.Parent, folder.Name = model.SplitFolderPath(folder.Path)
+				nodes[folder.Path] = &FolderNode{Folder: folder, Children: []*FolderNode{}}
+			}
+		}
+		if node := nodes[folderPath]; node != nil {
+			node.FileCount = count
+		}
+	}
+
+	paths := make([]string, 0, len(nodes))
+	for folderPath := range nodes {
+		paths = append(paths, folderPath)
+	}
+	sort.Strings(paths)
+
+	roots := []*FolderNode{}
+	for _, folderPath := range paths {
+		node := nodes[folderPath]
+		if parent := nodes[node.Parent]; node.Parent != "" && parent != nil {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+	return roots, nil
+}
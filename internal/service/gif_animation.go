@@ -0,0 +1,141 @@
+package service
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+
+	"github.com/disintegration/imaging"
+)
+
+// Values of ANIMATED_GIFS
+const (
+	// AnimatedGIFPreserve resizes animated GIFs frame by frame and keeps them
+	// animated GIFs
+	AnimatedGIFPreserve = "preserve"
+	// AnimatedGIFOriginal stores animated GIFs as uploaded, without
+	// optimization
+	AnimatedGIFOriginal = "original"
+)
+
+// CheckAnimatedGIFMode validates ANIMATED_GIFS
+func CheckAnimatedGIFMode(mode string) error {
+	if mode != AnimatedGIFPreserve && mode != AnimatedGIFOriginal {
+		return fmt.Errorf("ANIMATED_GIFS must be preserve or original, got %q", mode)
+	}
+	return nil
+}
+
+// readAnimation returns the GIF in src and its frame count. Every frame is
+// decoded at once, so the pixels of all frames together are checked against
+// limit, which a frame count needs the whole file for.
+func readAnimation(src io.ReadSeeker, limit pixelLimit) ([]byte, int, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, fmt.Errorf("failed to read image: %w", err)
+	}
+	data, err := io.ReadAll(src)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read image: %w", err)
+	}
+	frames := gifFrameCount(data)
+	if limit > 0 && frames > 1 {
+		cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+		if err == nil && int64(cfg.Width)*int64(cfg.Height)*int64(frames) > int64(limit) {
+			return nil, 0, ErrTooManyAnimationPixels.WithArgs(frames, cfg.Width, cfg.Height, int(limit))
+		}
+	}
+	return data, frames, nil
+}
+
+// resizeAnimation renders every frame of anim on the full canvas, the way a
+// viewer shows it, and passes it through transform. Frames may only cover
+// part of the canvas and rely on what earlier frames left there, so they
+// can't be resized on their own. The result has one full frame per source
+// frame with the same delays; grayscale maps its palettes to gray.
+func resizeAnimation(anim *gif.GIF, transform func(image.Image) image.Image, grayscale bool) *gif.GIF {
+	canvasBounds := image.Rect(0, 0, anim.Config.Width, anim.Config.Height)
+	canvas := image.NewRGBA(canvasBounds)
+	global, _ := anim.Config.ColorModel.(color.Palette)
+
+	out := &gif.GIF{LoopCount: anim.LoopCount}
+	for i, frame := range anim.Image {
+		disposal := byte(gif.DisposalNone)
+		if i < len(anim.Disposal) {
+			disposal = anim.Disposal[i]
+		}
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvasBounds)
+			draw.Draw(previous, canvasBounds, canvas, image.Point{}, draw.Src)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+		rendered := transform(canvas)
+
+		// A frame covering part of the canvas may have a local palette
+		// without the colors of what is drawn around it
+		palette := frame.Palette
+		if frame.Bounds() != canvasBounds && len(global) > 0 {
+			palette = global
+		}
+		if grayscale {
+			palette = grayPalette(palette)
+		}
+		paletted := image.NewPaletted(rendered.Bounds(), palette)
+		draw.Draw(paletted, paletted.Bounds(), rendered, rendered.Bounds().Min, draw.Src)
+
+		out.Image = append(out.Image, paletted)
+		if i < len(anim.Delay) {
+			out.Delay = append(out.Delay, anim.Delay[i])
+		} else {
+			out.Delay = append(out.Delay, 0)
+		}
+		// Every frame is complete, so the next one starts from a clear canvas
+		out.Disposal = append(out.Disposal, gif.DisposalBackground)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+	if len(out.Image) > 0 {
+		bounds := out.Image[0].Bounds()
+		out.Config = image.Config{ColorModel: out.Image[0].Palette, Width: bounds.Dx(), Height: bounds.Dy()}
+	}
+	return out
+}
+
+// grayPalette returns palette with every color converted to gray, keeping
+// transparency
+func grayPalette(palette color.Palette) color.Palette {
+	gray := make(color.Palette, len(palette))
+	for i, c := range palette {
+		_, _, _, a := c.RGBA()
+		// The luma of premultiplied components, as color.RGBA expects
+		y := color.GrayModel.Convert(c).(color.Gray).Y
+		gray[i] = color.RGBA{R: y, G: y, B: y, A: uint8(a >> 8)}
+	}
+	return gray
+}
+
+// fitAnimation returns the transform that encodeImage applies to still
+// images, or nil when the animation is left as it is
+func fitAnimation(width, height, maxWidth, maxHeight int, crop bool) func(image.Image) image.Image {
+	switch {
+	case crop:
+		return func(img image.Image) image.Image {
+			return imaging.Fill(img, maxWidth, maxHeight, imaging.Center, imaging.Lanczos)
+		}
+	case width > maxWidth || height > maxHeight:
+		return func(img image.Image) image.Image {
+			return imaging.Fit(img, maxWidth, maxHeight, imaging.Lanczos)
+		}
+	}
+	return nil
+}
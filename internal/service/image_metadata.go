@@ -0,0 +1,262 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+
+	"github.com/disintegration/imaging"
+
+	// Register decoders for image.DecodeConfig
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// Color profile values stored in File.ColorProfile
+const (
+	ColorProfileICC  = "icc"
+	ColorProfileSRGB = "srgb"
+)
+
+// ImageMetadata is the image information stored with a file at upload time
+type ImageMetadata struct {
+	Width        int
+	Height       int
+	FrameCount   int
+	ColorProfile string
+	ColorSpace   string
+}
+
+// Fields returns the metadata as File columns
+func (m ImageMetadata) Fields() map[string]interface{} {
+	return map[string]interface{}{
+		"width":         m.Width,
+		"height":        m.Height,
+		"frame_count":   m.FrameCount,
+		"color_profile": m.ColorProfile,
+		"color_space":   m.ColorSpace,
+	}
+}
+
+// readImageMetadata reads dimensions, frame count and color profile without
+// decoding the pixels
+func readImageMetadata(data []byte, mimeType string) (ImageMetadata, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+
+	meta := ImageMetadata{Width: cfg.Width, Height: cfg.Height, FrameCount: 1}
+	switch mimeType {
+	case "image/gif":
+		if frames := gifFrameCount(data); frames > 0 {
+			meta.FrameCount = frames
+		}
+	case "image/jpeg", "image/jpg":
+		meta.ColorProfile = jpegColorProfile(data)
+	case "image/png":
+		meta.ColorProfile = pngColorProfile(data)
+	}
+	if icc := iccProfile(data, mimeType); icc != nil {
+		meta.ColorSpace = iccDescription(icc)
+	} else if meta.ColorProfile == ColorProfileSRGB {
+		meta.ColorSpace = "sRGB"
+	}
+	return meta, nil
+}
+
+// readImageMetadataFile reads the metadata of an image stored on disk
+func readImageMetadataFile(path, mimeType string) (ImageMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	defer f.Close()
+
+	data, err := io.ReadAll(bufio.NewReader(f))
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	return readImageMetadata(data, mimeType)
+}
+
+// jpegColorProfile looks for an APP2 ICC_PROFILE segment before the image data
+func jpegColorProfile(data []byte) string {
+	profile := ""
+	jpegSegments(data, func(marker byte, segment []byte) bool {
+		if marker == 0xE2 && bytes.HasPrefix(segment, jpegICCPrefix) {
+			profile = ColorProfileICC
+			return false
+		}
+		return true
+	})
+	return profile
+}
+
+// jpegSegments calls fn with the metadata segments of a JPEG until it
+// returns false or the image data starts
+func jpegSegments(data []byte, fn func(marker byte, segment []byte) bool) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return
+		}
+		marker := data[i+1]
+		// Start of scan: no more metadata segments
+		if marker == 0xDA {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return
+		}
+		if !fn(marker, data[i+4:i+2+length]) {
+			return
+		}
+		i += 2 + length
+	}
+}
+
+// pixelLimit rejects images too large to decode safely, see MAX_IMAGE_PIXELS.
+// A few kilobytes of a highly compressible format can claim dimensions
+// whose decoded pixels take gigabytes, so only the header is read.
+type pixelLimit int
+
+// check returns ErrTooManyPixels if the image read from r has more pixels
+// than allowed. Data that isn't a known image is left to the decoder to reject.
+func (l pixelLimit) check(r io.Reader) error {
+	if l <= 0 {
+		return nil
+	}
+	cfg, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil
+	}
+	if int64(cfg.Width)*int64(cfg.Height) > int64(l) {
+		return ErrTooManyPixels.WithArgs(cfg.Width, cfg.Height, int(l))
+	}
+	return nil
+}
+
+// imageHeaderSize is how much of an image is kept for reading its ICC
+// profile, which is stored before the pixel data. Larger profiles are lost.
+const imageHeaderSize = 1 << 20
+
+// decodeImage decodes the image in src, which is read as a stream so its
+// file is never held in memory, only the decoded pixels. The pixel count is
+// checked against limit from the header first. It also returns the first
+// imageHeaderSize bytes of src, for colorHandling.prepare.
+func decodeImage(src io.ReadSeeker, limit pixelLimit, opts ...imaging.DecodeOption) (image.Image, []byte, error) {
+	header, err := io.ReadAll(io.LimitReader(src, imageHeaderSize))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if err := limit.check(src); err != nil {
+		return nil, nil, err
+	}
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	img, err := imaging.Decode(src, opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, header, nil
+}
+
+// gifFrameCount counts the frames of a GIF by walking its blocks, without
+// decoding them; 0 if the data isn't a GIF
+func gifFrameCount(data []byte) int {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0
+	}
+	i := 13
+	// Global color table
+	if data[10]&0x80 != 0 {
+		i += 3 << (data[10]&0x07 + 1)
+	}
+	// skipSubBlocks returns the position after a chain of data sub-blocks
+	skipSubBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		return i + 1
+	}
+	frames := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: label, then sub-blocks
+			i = skipSubBlocks(i + 2)
+		case 0x2C: // Image descriptor, local color table, LZW code size, data
+			if i+10 > len(data) {
+				return frames
+			}
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			i = skipSubBlocks(i + 1)
+			frames++
+		default: // Trailer or corrupt data
+			return frames
+		}
+	}
+	return frames
+}
+
+var errNotPNG = errors.New("not a png")
+
+// pngColorProfile looks for iCCP or sRGB chunks before the image data
+func pngColorProfile(data []byte) string {
+	chunks, err := pngChunks(data)
+	if err != nil {
+		return ""
+	}
+	for _, chunk := range chunks {
+		switch chunk.Type {
+		case "iCCP":
+			return ColorProfileICC
+		case "sRGB":
+			return ColorProfileSRGB
+		}
+	}
+	return ""
+}
+
+// pngChunk is a chunk of a PNG before the image data
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+func pngChunks(data []byte) ([]pngChunk, error) {
+	if len(data) < 8 || !bytes.Equal(data[:8], pngSignature) {
+		return nil, errNotPNG
+	}
+	var chunks []pngChunk
+	for i := 8; i+8 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		chunk := pngChunk{Type: string(data[i+4 : i+8])}
+		if chunk.Type == "IDAT" {
+			break
+		}
+		if length >= 0 && i+8+length <= len(data) {
+			chunk.Data = data[i+8 : i+8+length]
+		}
+		chunks = append(chunks, chunk)
+		i += 12 + length
+	}
+	return chunks, nil
+}
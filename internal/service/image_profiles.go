@@ -0,0 +1,130 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImageProfile is a named set of processing parameters applied to images
+// uploaded with profile=name, so every client gets the same treatment
+type ImageProfile struct {
+	Name   string `json:"name"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	// Crop fills exactly Width x Height, cutting the overflow around the
+	// center; otherwise images are only shrunk to fit
+	Crop bool `json:"crop"`
+	// Format is "jpeg", "png" or "webp", or empty to keep PNG and convert
+	// everything else to JPEG. WebP output is lossless.
+	Format    string `json:"format,omitempty"`
+	Quality   int    `json:"quality,omitempty"`
+	Grayscale bool   `json:"grayscale"`
+}
+
+// ImageProfiles maps profile names to their parameters
+type ImageProfiles map[string]ImageProfile
+
+var imageProfileFormats = map[string]string{
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"png":  "image/png",
+	"webp": "image/webp",
+}
+
+// ParseImageProfiles parses a spec such as
+// "avatar:512x512,crop,webp;document-scan:grayscale,jpeg,q70". Sizes are
+// WIDTHxHEIGHT or a single bound for both.
+func ParseImageProfiles(spec string) (ImageProfiles, error) {
+	profiles := make(ImageProfiles)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, ",= ") {
+			return nil, fmt.Errorf("invalid image profile %q, expected name:option,...", entry)
+		}
+		if _, ok := profiles[name]; ok {
+			return nil, fmt.Errorf("duplicate image profile %q", name)
+		}
+
+		profile := ImageProfile{Name: name}
+		for _, option := range strings.Split(options, ",") {
+			option = strings.ToLower(strings.TrimSpace(option))
+			if err := profile.apply(option); err != nil {
+				return nil, fmt.Errorf("image profile %q: %w", name, err)
+			}
+		}
+		if profile.Crop && (profile.Width == 0 || profile.Height == 0) {
+			return nil, fmt.Errorf("image profile %q: crop needs a size", name)
+		}
+		profiles[name] = profile
+	}
+	return profiles, nil
+}
+
+func (p *ImageProfile) apply(option string) error {
+	switch {
+	case option == "":
+		return nil
+	case option == "crop":
+		p.Crop = true
+	case option == "fit":
+		p.Crop = false
+	case option == "grayscale":
+		p.Grayscale = true
+	case imageProfileFormats[option] != "":
+		p.Format = option
+		if option == "jpg" {
+			p.Format = "jpeg"
+		}
+	case strings.HasPrefix(option, "q"):
+		quality, err := strconv.Atoi(option[1:])
+		if err != nil || quality < 1 || quality > 100 {
+			return fmt.Errorf("invalid quality %q, expected q1 to q100", option)
+		}
+		p.Quality = quality
+	default:
+		w, h, square := strings.Cut(option, "x")
+		if !square {
+			h = w
+		}
+		width, err := strconv.Atoi(w)
+		if err != nil || width <= 0 {
+			return fmt.Errorf("unknown option %q", option)
+		}
+		height, err := strconv.Atoi(h)
+		if err != nil || height <= 0 {
+			return fmt.Errorf("invalid size %q", option)
+		}
+		p.Width, p.Height = width, height
+	}
+	return nil
+}
+
+// Get returns the named profile, or nil when name is empty
+func (p ImageProfiles) Get(name string) (*ImageProfile, error) {
+	if name == "" {
+		return nil, nil
+	}
+	profile, ok := p[name]
+	if !ok {
+		return nil, ErrUnknownImageProfile.WithArgs(name)
+	}
+	return &profile, nil
+}
+
+// List returns the profiles sorted by name
+func (p ImageProfiles) List() []ImageProfile {
+	list := make([]ImageProfile, 0, len(p))
+	for _, profile := range p {
+		list = append(list, profile)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
@@ -0,0 +1,293 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/disintegration/imaging"
+	"github.com/h2non/filetype"
+	"gorm.io/gorm"
+)
+
+const (
+	proxyFetchTimeout = 15 * time.Second
+	proxyMaxRedirects = 3
+)
+
+// ProxyMaxWidth is the largest width the image proxy resizes to
+const ProxyMaxWidth = 2048
+
+var errPrivateAddress = errors.New("destination address is not public")
+
+// ProxiedImage is a cached, optimized remote image ready to be served
+type ProxiedImage struct {
+	FilePath string
+	MimeType string
+	// Cached is true when the image was served from the cache without fetching
+	Cached bool
+}
+
+// ImageProxyService fetches remote images, resizes and re-encodes them and
+// keeps the result in a per-user cache
+type ImageProxyService struct {
+	cacheRepo    *repository.ProxyCacheRepository
+	diskGuard    *DiskGuard
+	client       *http.Client
+	cachePath    string
+	cacheTTL     time.Duration
+	maxSize      int64
+	allowedHosts map[string]bool
+	jpegQuality  int
+	color        colorHandling
+	settings     *SettingsService
+	workers      *ImageWorkers
+}
+
+// NewImageProxyService returns the proxy; maxSize is IMAGE_PROXY_MAX_SIZE in
+// bytes
+func NewImageProxyService(cacheRepo *repository.ProxyCacheRepository, diskGuard *DiskGuard, workers *ImageWorkers, settings *SettingsService, maxSize int64, cfg *config.Config) *ImageProxyService {
+	allowedHosts := make(map[string]bool)
+	for _, host := range strings.Split(cfg.ImageProxyAllowedHosts, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			allowedHosts[host] = true
+		}
+	}
+
+	return &ImageProxyService{
+		cacheRepo:    cacheRepo,
+		diskGuard:    diskGuard,
+		client:       newProxyClient(),
+		cachePath:    cfg.ImageProxyCachePath,
+		cacheTTL:     cfg.ImageProxyCacheTTL,
+		maxSize:      maxSize,
+		allowedHosts: allowedHosts,
+		jpegQuality:  85,
+		color:        newColorHandling(cfg.ImageColorMode),
+		settings:     settings,
+		workers:      workers,
+	}
+}
+
+// newProxyClient returns an HTTP client that only connects to public
+// addresses. The check runs on every dial so DNS rebinding and redirects to
+// internal hosts are refused as well.
+func newProxyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return errPrivateAddress
+			}
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: proxyFetchTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConns:        10,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= proxyMaxRedirects {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return errors.New("redirect to unsupported scheme")
+			}
+			return nil
+		},
+	}
+}
+
+func isPublicIP(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	// Carrier-grade NAT range, often used for internal services
+	if ip4 := ip.To4(); ip4 != nil && ip4[0] == 100 && ip4[1]&0xC0 == 64 {
+		return false
+	}
+	return true
+}
+
+// Fetch returns the optimized version of a remote image at the given width
+// (0 keeps the original width up to the maximum), from the cache if possible
+func (s *ImageProxyService) Fetch(ctx context.Context, userID uint, rawURL string, width int) (*ProxiedImage, error) {
+	source, err := s.validateURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if width < 0 || width > ProxyMaxWidth {
+		return nil, ErrInvalidProxyWidth.WithArgs(ProxyMaxWidth)
+	}
+
+	key := proxyCacheKey(source.String(), width)
+	entry, err := s.cacheRepo.Find(ctx, userID, key)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	if entry != nil && time.Since(entry.CreatedAt) < s.cacheTTL {
+		if _, statErr := os.Stat(entry.FilePath); statErr == nil {
+			s.cacheRepo.Touch(ctx, entry)
+			return &ProxiedImage{FilePath: entry.FilePath, MimeType: entry.MimeType, Cached: true}, nil
+		}
+	}
+
+	data, err := s.download(ctx, source)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, _ := filetype.Match(data)
+	if !allowedImageTypes[kind.MIME.Value] {
+		return nil, ErrImageTypeNotAllowed
+	}
+
+	var processed []byte
+	mimeType := kind.MIME.Value
+	err = s.workers.Do(ctx, func() error {
+		var err error
+		processed, mimeType, err = s.optimize(data, mimeType, width)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.diskGuard.Check(int64(len(processed))); err != nil {
+		return nil, err
+	}
+
+	ext := ".jpg"
+	if mimeType == "image/png" {
+		ext = ".png"
+	}
+	dir := filepath.Join(s.cachePath, strconv.FormatUint(uint64(userID), 10))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	filePath := filepath.Join(dir, key+ext)
+	if err := os.WriteFile(filePath, processed, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write cached image: %w", err)
+	}
+
+	now := time.Now()
+	cached := &model.ProxyCacheEntry{
+		UserID:         userID,
+		CacheKey:       key,
+		SourceURL:      source.String(),
+		Width:          width,
+		FilePath:       filePath,
+		MimeType:       mimeType,
+		FileSize:       int64(len(processed)),
+		LastAccessedAt: now,
+		CreatedAt:      now,
+	}
+	if err := s.cacheRepo.Save(ctx, cached); err != nil {
+		os.Remove(filePath)
+		return nil, fmt.Errorf("failed to save cache entry: %w", err)
+	}
+
+	return &ProxiedImage{FilePath: filePath, MimeType: mimeType}, nil
+}
+
+func (s *ImageProxyService) validateURL(rawURL string) (*url.URL, error) {
+	source, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil || (source.Scheme != "http" && source.Scheme != "https") || source.Hostname() == "" || source.User != nil {
+		return nil, ErrInvalidProxyURL
+	}
+	source.Fragment = ""
+
+	if len(s.allowedHosts) > 0 && !s.allowedHosts[strings.ToLower(source.Hostname())] {
+		return nil, ErrProxyHostNotAllowed
+	}
+	return source, nil
+}
+
+func (s *ImageProxyService) download(ctx context.Context, source *url.URL) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, source.String(), nil)
+	if err != nil {
+		return nil, ErrInvalidProxyURL
+	}
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, ErrProxyFetchFailed.WithArgs(err.Error())
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, ErrProxyFetchFailed.WithArgs(resp.Status)
+	}
+	if resp.ContentLength > s.maxSize {
+		return nil, ErrProxyImageTooLarge.WithArgs(formatByteSize(s.maxSize))
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, s.maxSize+1))
+	if err != nil {
+		return nil, ErrProxyFetchFailed.WithArgs(err.Error())
+	}
+	if int64(len(data)) > s.maxSize {
+		return nil, ErrProxyImageTooLarge.WithArgs(formatByteSize(s.maxSize))
+	}
+	return data, nil
+}
+
+// optimize resizes the image to width (keeping the aspect ratio) and
+// re-encodes it, keeping PNG for PNG sources and JPEG for everything else
+func (s *ImageProxyService) optimize(data []byte, mimeType string, width int) ([]byte, string, error) {
+	img, header, err := decodeImage(bytes.NewReader(data), s.settings.Current().PixelLimit, imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, "", err
+	}
+	img, icc := s.color.prepare(img, header, mimeType)
+
+	if width > 0 && img.Bounds().Dx() > width {
+		img = imaging.Resize(img, width, 0, imaging.Lanczos)
+	} else if img.Bounds().Dx() > ProxyMaxWidth || img.Bounds().Dy() > ProxyMaxWidth {
+		img = imaging.Fit(img, ProxyMaxWidth, ProxyMaxWidth, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if mimeType == "image/png" {
+		err = png.Encode(&buf, img)
+	} else {
+		mimeType = "image/jpeg"
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: s.jpegQuality})
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return embedICCProfile(buf.Bytes(), mimeType, icc), mimeType, nil
+}
+
+func proxyCacheKey(sourceURL string, width int) string {
+	sum := sha256.Sum256([]byte(sourceURL + "|" + strconv.Itoa(width)))
+	return hex.EncodeToString(sum[:])
+}
@@ -2,44 +2,95 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"image"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
+	"log"
 	"mime/multipart"
 	"os"
 	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/detect"
+	"storage-service/internal/events"
 	"storage-service/internal/model"
 	"storage-service/internal/repository"
 	"strings"
 	"time"
 
+	"github.com/HugoSmits86/nativewebp"
 	"github.com/disintegration/imaging"
 	"github.com/google/uuid"
 	"github.com/h2non/filetype"
+	"gorm.io/gorm"
 )
 
 type ImageService struct {
 	fileRepo    *repository.FileRepository
+	exifRepo    *repository.ImageMetadataRepository
 	userService *UserService
 	uploadPath  string
+	roots       uploadRoots
 	storageURL  string
 	maxWidth    int
 	maxHeight   int
 	jpegQuality int
+	uploads     *UploadTracker
+
+	folderPolicy FolderPolicy
+	settings     *SettingsService
+	diskGuard    *DiskGuard
+	blobs        *BlobStore
+	variants     *VariantService
+	profiles     ImageProfiles
+	color        colorHandling
+	animatedGIFs string
+	stripGPS     bool
+	workers      *ImageWorkers
+	events       *events.Bus
+	temp         *TempStore
 }
 
-func NewImageService(fileRepo *repository.FileRepository, userService *UserService, uploadPath string, storageURL string) *ImageService {
-	return &ImageService{
+func NewImageService(fileRepo *repository.FileRepository, exifRepo *repository.ImageMetadataRepository, userService *UserService, uploads *UploadTracker, diskGuard *DiskGuard, blobs *BlobStore, variants *VariantService, workers *ImageWorkers, bus *events.Bus, settings *SettingsService, profiles ImageProfiles, cfg *config.Config) *ImageService {
+	s := &ImageService{
 		fileRepo:    fileRepo,
+		exifRepo:    exifRepo,
 		userService: userService,
-		uploadPath:  uploadPath,
-		storageURL:  storageURL,
+		uploads:     uploads,
+		uploadPath:  cfg.UploadPath,
+		roots:       newUploadRoots(cfg),
+		storageURL:  cfg.StorageURL,
 		maxWidth:    2048,
 		maxHeight:   2048,
 		jpegQuality: 85,
+
+		folderPolicy: newFolderPolicy(cfg),
+		settings:     settings,
+		diskGuard:    diskGuard,
+		blobs:        blobs,
+		variants:     variants,
+		profiles:     profiles,
+		color:        newColorHandling(cfg.ImageColorMode),
+		animatedGIFs: cfg.AnimatedGIFs,
+		stripGPS:     cfg.ImageStripGPS,
+		workers:      workers,
+		events:       bus,
+		temp:         NewTempStore(cfg),
+	}
+	// EXIF data describes the content it was uploaded with
+	bus.Subscribe(events.FileDeleted, s.onContentGone)
+	bus.Subscribe(events.FileReplaced, s.onContentGone)
+	return s
+}
+
+func (s *ImageService) onContentGone(event events.Event) {
+	if err := s.exifRepo.DeleteByFileID(context.Background(), event.File.ID); err != nil {
+		log.Printf("[WARN] Failed to delete EXIF data of file %d: %v", event.File.ID, err)
 	}
 }
 
@@ -50,9 +101,19 @@ var allowedImageTypes = map[string]bool{
 	"image/gif":  true,
 }
 
-func (s *ImageService) ValidateImage(userID uint, fileHeader *multipart.FileHeader) error {
+func (s *ImageService) ValidateImage(ctx context.Context, userID uint, fileHeader *multipart.FileHeader) error {
 	// Check user limits
-	if err := s.userService.CheckUploadAllowed(userID, fileHeader.Size); err != nil {
+	if err := s.userService.CheckUploadAllowed(ctx, userID, fileHeader.Size); err != nil {
+		return err
+	}
+
+	// Check free space on the upload filesystem
+	if err := s.diskGuard.Check(fileHeader.Size); err != nil {
+		return err
+	}
+
+	// Images are re-encoded, but a name like shell.php.jpg is still refused
+	if err := s.filenamePolicy().Validate(fileHeader.Filename); err != nil {
 		return err
 	}
 
@@ -73,29 +134,55 @@ func (s *ImageService) ValidateImage(userID uint, fileHeader *multipart.FileHead
 	// Detect file type
 	kind, err := filetype.Match(head)
 	if err != nil {
-		return errors.New("unable to determine file type")
+		return ErrUnknownImageType
 	}
 
-	// Check if it's an allowed image type
+	// Check if it's an image type allowed for the user
 	mimeType := kind.MIME.Value
-	if !allowedImageTypes[mimeType] {
-		return fmt.Errorf("file type not allowed, only images (JPEG, PNG, GIF) are accepted")
+	if allowed, err := s.imageTypeAllowed(ctx, userID, mimeType); err != nil {
+		return err
+	} else if !allowed {
+		return ErrImageTypeNotAllowed
 	}
 
-	return nil
+	return s.sizeLimits().Check(mimeType, fileHeader.Size)
+}
+
+func (s *ImageService) UploadImage(ctx context.Context, userID uint, fileHeader *multipart.FileHeader) (*model.File, error) {
+	return s.UploadImageWithFolder(ctx, userID, fileHeader, "")
 }
 
-func (s *ImageService) UploadImage(userID uint, fileHeader *multipart.FileHeader) (*model.File, error) {
-	return s.UploadImageWithFolder(userID, fileHeader, "")
+func (s *ImageService) UploadImageWithFolder(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, folderPath string) (*model.File, error) {
+	return s.UploadImageWithOptions(ctx, userID, fileHeader, UploadOptions{FolderPath: folderPath})
 }
 
-func (s *ImageService) UploadImageWithFolder(userID uint, fileHeader *multipart.FileHeader, folderPath string) (*model.File, error) {
-	if err := s.ValidateImage(userID, fileHeader); err != nil {
+// UploadImageWithOptions validates, optimizes and stores an image, reporting
+// each processing stage to the upload session when opts.UploadID is set
+func (s *ImageService) UploadImageWithOptions(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, opts UploadOptions) (*model.File, error) {
+	file, err := s.storeImage(ctx, userID, fileHeader, opts)
+	if err != nil {
+		s.uploads.Fail(opts.UploadID, err)
+		return nil, err
+	}
+	s.uploads.Complete(opts.UploadID, file.ID)
+	return file, nil
+}
+
+func (s *ImageService) storeImage(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, opts UploadOptions) (*model.File, error) {
+	s.uploads.SetStage(opts.UploadID, StageValidating)
+	if err := s.ValidateImage(ctx, userID, fileHeader); err != nil {
+		return nil, err
+	}
+	profile, err := s.profiles.Get(opts.Profile)
+	if err != nil {
 		return nil, err
 	}
 
 	// Sanitize folder path
-	folderPath = s.sanitizeFolderPath(folderPath)
+	folderPath, err := s.sanitizeFolderPath(opts.FolderPath)
+	if err != nil {
+		return nil, err
+	}
 
 	// Generate date-based folder structure
 	now := time.Now()
@@ -107,21 +194,21 @@ func (s *ImageService) UploadImageWithFolder(userID uint, fileHeader *multipart.
 		return nil, fmt.Errorf("failed to create upload directory: %w", err)
 	}
 
-	src, err := fileHeader.Open()
+	// The upload is streamed to a temp file and decoded from there, so
+	// only its pixels are held in memory
+	src, err := s.spool(fileHeader)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
-	}
-	defer src.Close()
-
-	fileBytes, err := io.ReadAll(src)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read file: %w", err)
+		return nil, err
 	}
+	defer s.temp.Discard(src)
 
-	kind, _ := filetype.Match(fileBytes)
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(src, head)
+	kind, _ := filetype.Match(head[:n])
 	mimeType := kind.MIME.Value
 
-	processedBytes, finalMimeType, err := s.processImage(fileBytes, mimeType)
+	s.uploads.SetStage(opts.UploadID, StageOptimizing)
+	processedBytes, finalMimeType, err := s.processImage(ctx, src, mimeType, profile)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process image: %w", err)
 	}
@@ -130,7 +217,11 @@ func (s *ImageService) UploadImageWithFolder(userID uint, fileHeader *multipart.
 	uniqueFilename := uuid.New().String() + ext
 	filePath := filepath.Join(uploadDir, uniqueFilename)
 
-	if err := os.WriteFile(filePath, processedBytes, 0644); err != nil {
+	if err := s.temp.WriteFile(filePath, processedBytes); err != nil {
+		return nil, fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := s.blobs.Publish(ctx, filePath); err != nil {
+		os.Remove(filePath)
 		return nil, fmt.Errorf("failed to save file: %w", err)
 	}
 
@@ -140,29 +231,154 @@ func (s *ImageService) UploadImageWithFolder(userID uint, fileHeader *multipart.
 	file := &model.File{
 		UserID:       userID,
 		Filename:     uniqueFilename,
-		OriginalName: s.sanitizeFilename(fileHeader.Filename),
+		OriginalName: s.filenamePolicy().Apply(s.sanitizeFilename(fileHeader.Filename)),
 		FilePath:     filePath,
 		FolderPath:   folderPath,
 		FileSize:     int64(len(processedBytes)),
 		MimeType:     finalMimeType,
+		Kind:         model.KindImage,
 		URL:          fileURL,
+
+		DeclaredMimeType:  detect.Normalize(fileHeader.Header.Get("Content-Type")),
+		ExtensionMimeType: detect.ExtensionType(fileHeader.Filename),
+		DetectedMimeType:  detect.Normalize(mimeType),
+
+		ProcessingProfile: opts.Profile,
+		Upload:            opts.Source,
 	}
+	file.NameWarning = s.filenamePolicy().Warning(file.OriginalName)
+	digestBytes(processedBytes).apply(file)
 
-	if err := s.fileRepo.Create(file); err != nil {
+	if meta, err := readImageMetadata(processedBytes, finalMimeType); err == nil {
+		file.Width, file.Height = meta.Width, meta.Height
+		file.FrameCount, file.ColorProfile, file.ColorSpace = meta.FrameCount, meta.ColorProfile, meta.ColorSpace
+	}
+
+	if err := s.fileRepo.Create(ctx, file); err != nil {
 		os.Remove(filePath)
+		s.blobs.Remove(context.WithoutCancel(ctx), filePath)
 		return nil, fmt.Errorf("failed to save file metadata: %w", err)
 	}
 
+	// Missing EXIF data does not fail the upload either
+	if exif := readExifMetadata(src, mimeType); exif != nil {
+		exif.FileID = file.ID
+		if err := s.exifRepo.Save(ctx, exif); err != nil {
+			log.Printf("[WARN] Failed to save EXIF data of file %d: %v", file.ID, err)
+		} else {
+			file.Exif = exif
+		}
+	}
+
+	// A missing thumbnail does not fail the upload; it can be rebuilt by the regeneration job
+	variants, err := s.variants.Generate(ctx, file)
+	if err != nil {
+		log.Printf("[WARN] Failed to generate variants of file %d: %v", file.ID, err)
+	}
+	file.Variants = variants
+
+	s.events.Publish(events.NewFileCreated(file))
+
 	return file, nil
 }
 
-func (s *ImageService) sanitizeFolderPath(path string) string {
+// ApplyProfile re-processes a stored image with a processing profile,
+// replacing the file and its variants. Non-images are left untouched and
+// reported with false.
+func (s *ImageService) ApplyProfile(ctx context.Context, file *model.File, profileName string) (bool, error) {
+	profile, err := s.profiles.Get(profileName)
+	if err != nil || profile == nil {
+		return false, err
+	}
+	if !variantSourceTypes[file.MimeType] {
+		return false, nil
+	}
+
+	// A direct upload awaiting processing is done once a profile was applied
+	status := file.ProcessingStatus
+	if status != "" {
+		status = model.ProcessingDone
+	}
+	if err := s.reprocess(ctx, file, profile, profileName, status); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// reprocess optimizes a stored image in place with profile, nil for the
+// default treatment, and rebuilds its variants. processingStatus is recorded
+// along with the result.
+func (s *ImageService) reprocess(ctx context.Context, file *model.File, profile *ImageProfile, profileName, processingStatus string) error {
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		return err
+	}
+	src, err := os.Open(file.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	processedBytes, finalMimeType, err := s.processImage(ctx, src, file.MimeType, profile)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("failed to process image: %w", err)
+	}
+
+	oldPath := file.FilePath
+	// A new name keeps URLs of stored files immutable, see MutableUpload
+	filename := uuid.New().String() + s.getExtensionForMimeType(finalMimeType)
+	filePath := filepath.Join(filepath.Dir(oldPath), filename)
+	if err := s.temp.WriteFile(filePath, processedBytes); err != nil {
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+	if err := s.blobs.Publish(ctx, filePath); err != nil {
+		os.Remove(filePath)
+		return fmt.Errorf("failed to save file: %w", err)
+	}
+
+	file.Filename = filename
+	file.FilePath, file.BlobID = filePath, nil
+	digestBytes(processedBytes).apply(file)
+	file.MimeType = finalMimeType
+	file.ProcessingProfile = profileName
+	file.ProcessingStatus = processingStatus
+	if meta, err := readImageMetadata(processedBytes, finalMimeType); err == nil {
+		file.Width, file.Height = meta.Width, meta.Height
+		file.FrameCount, file.ColorProfile, file.ColorSpace = meta.FrameCount, meta.ColorProfile, meta.ColorSpace
+	}
+
+	if err := s.fileRepo.Update(ctx, file); err != nil {
+		if filePath != oldPath {
+			os.Remove(filePath)
+			s.blobs.Remove(context.WithoutCancel(ctx), filePath)
+		}
+		return fmt.Errorf("failed to save file metadata: %w", err)
+	}
+	// Other files stored at the same path still read the old content
+	if others, err := s.fileRepo.CountOthersByFilePath(ctx, oldPath, file.ID); err == nil && others == 0 {
+		os.Remove(oldPath)
+		if err := s.blobs.Remove(ctx, oldPath); err != nil {
+			log.Printf("[WARN] Failed to remove replaced file %s: %v", oldPath, err)
+		}
+	}
+	s.events.Publish(events.NewFileUpdated(file, oldPath))
+
+	if _, err := s.variants.Generate(ctx, file); err != nil {
+		log.Printf("[WARN] Failed to generate variants of file %d: %v", file.ID, err)
+	}
+	return nil
+}
+
+// sanitizeFolderPath normalizes a folder path images are stored in and
+// checks it against FolderPolicy, see FileService.sanitizeFolderPath
+func (s *ImageService) sanitizeFolderPath(path string) (string, error) {
 	path = strings.TrimSpace(path)
 	path = strings.Trim(path, "/\\")
 	path = strings.ReplaceAll(path, "..", "")
 	path = strings.ReplaceAll(path, "//", "/")
 	path = strings.ReplaceAll(path, "\\", "/")
-	return path
+	if err := s.folderPolicy.Validate(path); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 func (s *ImageService) sanitizeFilename(name string) string {
@@ -173,43 +389,122 @@ func (s *ImageService) sanitizeFilename(name string) string {
 			result.WriteRune(r)
 		}
 	}
-	return result.String()
+	return model.NormalizeName(result.String())
 }
 
-func (s *ImageService) processImage(imageBytes []byte, mimeType string) ([]byte, string, error) {
-	img, err := imaging.Decode(bytes.NewReader(imageBytes))
+// spool copies an uploaded file to a temp file, which the caller discards
+func (s *ImageService) spool(fileHeader *multipart.FileHeader) (*os.File, error) {
+	src, err := fileHeader.Open()
 	if err != nil {
-		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+		return nil, fmt.Errorf("failed to open uploaded file: %w", err)
+	}
+	defer src.Close()
+
+	f, err := s.temp.Create()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(f, src); err != nil {
+		s.temp.Discard(f)
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		s.temp.Discard(f)
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	return f, nil
+}
+
+// processImage shrinks and re-encodes the image read from src, following
+// profile when set. It waits for a free image worker first.
+func (s *ImageService) processImage(ctx context.Context, src io.ReadSeeker, mimeType string, profile *ImageProfile) ([]byte, string, error) {
+	var processed []byte
+	var finalMimeType string
+	err := s.workers.Do(ctx, func() error {
+		var err error
+		processed, finalMimeType, err = s.encodeImage(src, mimeType, profile)
+		return err
+	})
+	return processed, finalMimeType, err
+}
+
+func (s *ImageService) encodeImage(src io.ReadSeeker, mimeType string, profile *ImageProfile) ([]byte, string, error) {
+	// Animated GIFs stay animated unless a profile converts them to another
+	// format, which takes the first frame
+	if mimeType == "image/gif" && (profile == nil || profile.Format == "") {
+		data, frames, err := readAnimation(src, s.pixelLimit())
+		if err != nil {
+			return nil, "", err
+		}
+		if frames > 1 {
+			return s.encodeAnimation(data, profile)
+		}
+		src = bytes.NewReader(data)
+	}
+
+	// Photos are stored upright, whichever way the camera was held
+	img, header, err := decodeImage(src, s.pixelLimit(), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, "", err
+	}
+	img, icc := s.color.prepare(img, header, mimeType)
+
+	maxWidth, maxHeight, quality := s.maxWidth, s.maxHeight, s.jpegQuality
+	if profile != nil {
+		if profile.Width > 0 {
+			maxWidth, maxHeight = profile.Width, profile.Height
+		}
+		if profile.Quality > 0 {
+			quality = profile.Quality
+		}
 	}
 
 	bounds := img.Bounds()
 	width := bounds.Dx()
 	height := bounds.Dy()
 
-	needsResize := width > s.maxWidth || height > s.maxHeight
+	needsResize := width > maxWidth || height > maxHeight
 
 	var processedImg image.Image
-	if needsResize {
-		processedImg = imaging.Fit(img, s.maxWidth, s.maxHeight, imaging.Lanczos)
+	if profile != nil && profile.Crop {
+		processedImg = imaging.Fill(img, maxWidth, maxHeight, imaging.Center, imaging.Lanczos)
+	} else if needsResize {
+		processedImg = imaging.Fit(img, maxWidth, maxHeight, imaging.Lanczos)
 	} else {
 		processedImg = img
 	}
 
+	if profile != nil && profile.Grayscale {
+		gray := image.NewGray(processedImg.Bounds())
+		draw.Draw(gray, gray.Bounds(), processedImg, processedImg.Bounds().Min, draw.Src)
+		processedImg = gray
+		// An RGB profile doesn't apply to a grayscale result
+		icc = nil
+	}
+
 	var buf bytes.Buffer
 	var finalMimeType string
 
-	switch mimeType {
+	format := mimeType
+	if profile != nil && profile.Format != "" {
+		format = imageProfileFormats[profile.Format]
+	}
+
+	switch format {
 	case "image/png":
 		err = png.Encode(&buf, processedImg)
 		finalMimeType = "image/png"
+	case "image/webp":
+		err = nativewebp.Encode(&buf, processedImg, nil)
+		finalMimeType = "image/webp"
 	case "image/jpeg", "image/jpg":
-		err = jpeg.Encode(&buf, processedImg, &jpeg.Options{Quality: s.jpegQuality})
+		err = jpeg.Encode(&buf, processedImg, &jpeg.Options{Quality: quality})
 		finalMimeType = "image/jpeg"
 	case "image/gif":
-		err = jpeg.Encode(&buf, processedImg, &jpeg.Options{Quality: s.jpegQuality})
+		err = jpeg.Encode(&buf, processedImg, &jpeg.Options{Quality: quality})
 		finalMimeType = "image/jpeg"
 	default:
-		err = jpeg.Encode(&buf, processedImg, &jpeg.Options{Quality: s.jpegQuality})
+		err = jpeg.Encode(&buf, processedImg, &jpeg.Options{Quality: quality})
 		finalMimeType = "image/jpeg"
 	}
 
@@ -217,7 +512,47 @@ func (s *ImageService) processImage(imageBytes []byte, mimeType string) ([]byte,
 		return nil, "", fmt.Errorf("failed to encode image: %w", err)
 	}
 
-	return buf.Bytes(), finalMimeType, nil
+	encoded := embedICCProfile(buf.Bytes(), finalMimeType, icc)
+	if finalMimeType == "image/jpeg" && (mimeType == "image/jpeg" || mimeType == "image/jpg") {
+		bounds := processedImg.Bounds()
+		encoded = embedExif(encoded, carryExif(header, bounds.Dx(), bounds.Dy(), s.stripGPS))
+	}
+	return encoded, finalMimeType, nil
+}
+
+// encodeAnimation shrinks the animated GIF data following profile, see
+// ANIMATED_GIFS. Animations that need no change are stored as they are,
+// since re-encoding them only loses colors.
+func (s *ImageService) encodeAnimation(data []byte, profile *ImageProfile) ([]byte, string, error) {
+	if s.animatedGIFs == AnimatedGIFOriginal {
+		return data, "image/gif", nil
+	}
+	anim, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to decode image: %w", err)
+	}
+
+	maxWidth, maxHeight := s.maxWidth, s.maxHeight
+	crop, grayscale := false, false
+	if profile != nil {
+		if profile.Width > 0 {
+			maxWidth, maxHeight = profile.Width, profile.Height
+		}
+		crop, grayscale = profile.Crop, profile.Grayscale
+	}
+	transform := fitAnimation(anim.Config.Width, anim.Config.Height, maxWidth, maxHeight, crop)
+	if transform == nil {
+		if !grayscale {
+			return data, "image/gif", nil
+		}
+		transform = func(img image.Image) image.Image { return img }
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, resizeAnimation(anim, transform, grayscale)); err != nil {
+		return nil, "", fmt.Errorf("failed to encode image: %w", err)
+	}
+	return buf.Bytes(), "image/gif", nil
 }
 
 func (s *ImageService) getExtensionForMimeType(mimeType string) string {
@@ -228,29 +563,140 @@ func (s *ImageService) getExtensionForMimeType(mimeType string) string {
 		return ".jpg"
 	case "image/gif":
 		return ".gif"
+	case "image/webp":
+		return ".webp"
+	case "image/tiff":
+		return ".tiff"
+	case "image/bmp":
+		return ".bmp"
 	default:
 		return ".jpg"
 	}
 }
 
-func (s *ImageService) GetImageInfo(fileID uint) (*model.File, map[string]interface{}, error) {
-	file, err := s.fileRepo.FindByID(fileID)
+// ExifFilter narrows image listings by EXIF data: the camera model, matched
+// in part and case-insensitively, and when images were taken. Zero fields
+// match every image, also those without EXIF data.
+type ExifFilter struct {
+	Camera      string
+	TakenAfter  time.Time
+	TakenBefore time.Time
+}
+
+// ListImages returns a page of the user's images in a folder, or its whole
+// subtree when recursive is set, with their thumbnails, other variants and
+// EXIF data
+func (s *ImageService) ListImages(ctx context.Context, userID uint, folderPath string, recursive bool, exif ExifFilter, page, pageSize int, sortBy, sortOrder string) ([]model.File, int64, error) {
+	folderPath = model.CleanFolderPath(folderPath)
+	offset := (page - 1) * pageSize
+	filter := repository.ListFilter{
+		MimePrefix:  "image/",
+		Camera:      exif.Camera,
+		TakenAfter:  exif.TakenAfter,
+		TakenBefore: exif.TakenBefore,
+	}
+
+	var files []model.File
+	var total int64
+	var err error
+	if recursive {
+		files, err = s.fileRepo.FindByUserIDAndFolderTree(ctx, userID, folderPath, filter, pageSize, offset, sortBy, sortOrder)
+		if err == nil {
+			total, err = s.fileRepo.CountByUserIDAndFolderTree(ctx, userID, folderPath, filter)
+		}
+	} else {
+		files, err = s.fileRepo.FindByUserIDAndFolder(ctx, userID, folderPath, filter, pageSize, offset, sortBy, sortOrder)
+		if err == nil {
+			total, err = s.fileRepo.CountByUserIDAndFolder(ctx, userID, folderPath, filter)
+		}
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, 0, err
+	}
+
+	for i := range files {
+		s.generateURL(&files[i])
+		if recursive {
+			files[i].RelativePath = relativeFilePath(folderPath, &files[i])
+		}
+	}
+	if err := s.variants.AttachVariants(ctx, files); err != nil {
+		return nil, 0, err
+	}
+	if err := s.attachExif(ctx, files); err != nil {
+		return nil, 0, err
+	}
+
+	return files, total, nil
+}
+
+// attachExif loads the EXIF data of files in one query
+func (s *ImageService) attachExif(ctx context.Context, files []model.File) error {
+	ids := make([]uint, len(files))
+	for i := range files {
+		ids[i] = files[i].ID
+	}
+	metas, err := s.exifRepo.FindByFileIDs(ctx, ids)
+	if err != nil {
+		return err
 	}
+	byFile := make(map[uint]*model.ImageMetadata, len(metas))
+	for i := range metas {
+		byFile[metas[i].FileID] = &metas[i]
+	}
+	for i := range files {
+		files[i].Exif = byFile[files[i].ID]
+	}
+	return nil
+}
 
-	relativePath := strings.TrimPrefix(file.FilePath, s.uploadPath+string(filepath.Separator))
+func (s *ImageService) generateURL(file *model.File) {
+	relativePath := s.roots.relative(file.FilePath)
 	file.URL = fmt.Sprintf("%s/uploads/%s", strings.TrimSuffix(s.storageURL, "/"), filepath.ToSlash(relativePath))
+}
 
-	img, err := imaging.Open(file.FilePath)
+// GetImageInfo returns an image of userID with its variants, EXIF and dimensions
+func (s *ImageService) GetImageInfo(ctx context.Context, fileID, userID uint) (*model.File, map[string]interface{}, error) {
+	file, err := s.fileRepo.FindByIDAndUser(ctx, fileID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrImageNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.generateURL(file)
+
+	if file.Variants, err = s.variants.GetVariants(ctx, file.ID); err != nil {
+		return nil, nil, err
+	}
+	exif, err := s.exifRepo.FindByFileIDs(ctx, []uint{file.ID})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(exif) > 0 {
+		file.Exif = &exif[0]
+	}
+
+	if file.Width > 0 && file.Height > 0 {
+		return file, map[string]interface{}{
+			"width":         file.Width,
+			"height":        file.Height,
+			"frame_count":   file.FrameCount,
+			"color_profile": file.ColorProfile,
+			"color_space":   file.ColorSpace,
+		}, nil
+	}
+
+	// Legacy rows without stored dimensions; only the header is read
+	meta, err := readImageMetadataFile(file.FilePath, file.MimeType)
 	if err != nil {
 		return file, nil, nil
 	}
 
-	bounds := img.Bounds()
 	info := map[string]interface{}{
-		"width":  bounds.Dx(),
-		"height": bounds.Dy(),
+		"width":  meta.Width,
+		"height": meta.Height,
 	}
 
 	return file, info, nil
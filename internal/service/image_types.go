@@ -0,0 +1,64 @@
+package service
+
+import (
+	"context"
+	"storage-service/internal/detect"
+	"storage-service/internal/model"
+	"strings"
+)
+
+// optionalImageTypes can be decoded and converted by the image endpoints but
+// are only accepted for users an admin enabled them for, e.g. for a
+// scanning workflow
+var optionalImageTypes = map[string]bool{
+	"image/tiff": true,
+	"image/bmp":  true,
+}
+
+// ParseImageTypes normalizes a list of optional image types an admin enables
+// for a user
+func ParseImageTypes(mimeTypes []string) ([]string, error) {
+	seen := map[string]bool{}
+	var parsed []string
+	for _, mimeType := range mimeTypes {
+		mimeType = detect.Normalize(mimeType)
+		if mimeType == "" || allowedImageTypes[mimeType] || seen[mimeType] {
+			continue
+		}
+		if !optionalImageTypes[mimeType] {
+			return nil, ErrImageTypeNotOptional.WithArgs(mimeType, strings.Join(sortedKeys(optionalImageTypes), ", "))
+		}
+		seen[mimeType] = true
+		parsed = append(parsed, mimeType)
+	}
+	return parsed, nil
+}
+
+// userImageTypes returns the image types a user may upload
+func userImageTypes(user *model.User) map[string]bool {
+	types := make(map[string]bool, len(allowedImageTypes))
+	for mimeType, allowed := range allowedImageTypes {
+		types[mimeType] = allowed
+	}
+	for _, mimeType := range strings.Split(user.ExtraImageTypes, ",") {
+		if mimeType = strings.TrimSpace(mimeType); optionalImageTypes[mimeType] {
+			types[mimeType] = true
+		}
+	}
+	return types
+}
+
+// imageTypeAllowed reports whether userID may upload images of mimeType
+func (s *ImageService) imageTypeAllowed(ctx context.Context, userID uint, mimeType string) (bool, error) {
+	if allowedImageTypes[mimeType] {
+		return true, nil
+	}
+	if !optionalImageTypes[mimeType] {
+		return false, nil
+	}
+	user, err := s.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	return userImageTypes(user)[mimeType], nil
+}
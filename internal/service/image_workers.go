@@ -0,0 +1,34 @@
+package service
+
+import (
+	"context"
+	"runtime"
+)
+
+// ImageWorkers bounds how many images are decoded at once. A decoded image
+// takes width*height*4 bytes whatever the size of its file, so without a
+// bound concurrent uploads of large photos can exhaust memory.
+type ImageWorkers struct {
+	slots chan struct{}
+}
+
+// NewImageWorkers allows n images to be processed at once, or one per CPU
+// when n is 0
+func NewImageWorkers(n int) *ImageWorkers {
+	if n <= 0 {
+		n = runtime.NumCPU()
+	}
+	return &ImageWorkers{slots: make(chan struct{}, n)}
+}
+
+// Do runs fn once a worker is free. It returns ctx.Err() without running fn
+// when ctx ends first, e.g. because the client went away while waiting.
+func (w *ImageWorkers) Do(ctx context.Context, fn func() error) error {
+	select {
+	case w.slots <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	defer func() { <-w.slots }()
+	return fn()
+}
@@ -0,0 +1,384 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/detect"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// JobImportDirectory imports a directory tree on the server into an account
+const JobImportDirectory = "import_directory"
+
+// How an import places the imported files in UPLOAD_PATH
+const (
+	// ImportCopy copies files and leaves the source tree untouched
+	ImportCopy = "copy"
+	// ImportMove moves files out of the source tree
+	ImportMove = "move"
+	// ImportLink hard-links files, so they keep sharing their blocks with the source
+	ImportLink = "link"
+)
+
+// importBatchSize is the number of files created per step, in batched inserts
+const importBatchSize = 500
+
+// ImportParams configures a directory import. SourceDir is relative to
+// IMPORT_ROOT; its subdirectories become folders below FolderPath.
+type ImportParams struct {
+	SourceDir  string `json:"source_dir" binding:"required"`
+	UserID     uint   `json:"user_id" binding:"required"`
+	FolderPath string `json:"folder_path"`
+	Mode       string `json:"mode"`
+}
+
+// ImportService ingests existing directory trees, e.g. a plain file share
+// being migrated, into a user's account. The tree is walked in lexical order
+// and the position is checkpointed after every batch, so an import can be
+// paused and resumed like any other job. Imports are admin operations and
+// bypass the user's upload limits.
+type ImportService struct {
+	fileRepo    *repository.FileRepository
+	files       *FileService
+	userService *UserService
+	jobs        *JobService
+	events      *events.Bus
+	importRoot  string
+}
+
+func NewImportService(fileRepo *repository.FileRepository, files *FileService, userService *UserService, jobs *JobService, bus *events.Bus, cfg *config.Config) *ImportService {
+	s := &ImportService{
+		fileRepo:    fileRepo,
+		files:       files,
+		userService: userService,
+		jobs:        jobs,
+		events:      bus,
+		importRoot:  cfg.ImportRoot,
+	}
+	jobs.Register(JobImportDirectory, s.step)
+	return s
+}
+
+// Start queues an import of a directory below IMPORT_ROOT
+func (s *ImportService) Start(ctx context.Context, params ImportParams, createdBy uint) (*model.Job, error) {
+	if s.importRoot == "" {
+		return nil, ErrImportDisabled
+	}
+	if params.Mode == "" {
+		params.Mode = ImportCopy
+	}
+	switch params.Mode {
+	case ImportCopy, ImportMove, ImportLink:
+	default:
+		return nil, ErrUnknownImportMode.WithArgs(params.Mode)
+	}
+	if _, err := s.userService.GetUserByID(ctx, params.UserID); err != nil {
+		return nil, err
+	}
+
+	source, err := s.sourceDir(params.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Stat(source); err != nil || !info.IsDir() {
+		return nil, ErrInvalidImportSource
+	}
+	params.SourceDir = source
+	if params.FolderPath, err = s.files.sanitizeFolderPath(params.FolderPath); err != nil {
+		return nil, err
+	}
+
+	return s.jobs.Enqueue(ctx, JobImportDirectory, params, createdBy)
+}
+
+// sourceDir resolves a directory given relative to IMPORT_ROOT and makes
+// sure it does not leave the root, also through symlinks
+func (s *ImportService) sourceDir(dir string) (string, error) {
+	root, err := filepath.EvalSymlinks(s.importRoot)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve IMPORT_ROOT: %w", err)
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(root, dir)
+	}
+	resolved, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return "", ErrInvalidImportSource
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidImportSource
+	}
+	return resolved, nil
+}
+
+func (s *ImportService) step(ctx context.Context, job *model.Job) (bool, error) {
+	if s.importRoot == "" {
+		return false, ErrImportDisabled
+	}
+
+	var params ImportParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+	source, err := s.sourceDir(params.SourceDir)
+	if err != nil {
+		return false, err
+	}
+
+	if job.Checkpoint == "" && job.Total == 0 {
+		total := int64(0)
+		if err := walkImport(source, "", func(string) bool { total++; return true }); err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	var batch []string
+	err = walkImport(source, job.Checkpoint, func(rel string) bool {
+		batch = append(batch, rel)
+		return len(batch) < importBatchSize
+	})
+	if err != nil {
+		return false, err
+	}
+
+	files := make([]model.File, 0, len(batch))
+	var placed []importedBlob
+	for _, rel := range batch {
+		if ctx.Err() != nil {
+			undoImport(placed)
+			return false, ctx.Err()
+		}
+		file, blob, err := s.place(ctx, params, source, rel)
+		if err != nil {
+			log.Printf("[WARN] Failed to import %s: %v", filepath.Join(source, filepath.FromSlash(rel)), err)
+			job.Failed++
+		} else {
+			files = append(files, *file)
+			placed = append(placed, blob)
+		}
+		job.Processed++
+		job.Checkpoint = rel
+	}
+
+	if len(files) > 0 {
+		if err := s.fileRepo.CreateBatch(ctx, files); err != nil {
+			undoImport(placed)
+			return false, fmt.Errorf("failed to save file metadata: %w", err)
+		}
+	}
+	for i := range files {
+		s.events.Publish(events.NewFileCreated(&files[i]))
+	}
+
+	return len(batch) < importBatchSize, nil
+}
+
+// importedBlob is a file placed in UPLOAD_PATH whose record is not saved yet
+type importedBlob struct {
+	source, target, mode string
+}
+
+// undo reverts the placement of a file whose record could not be saved
+func (b importedBlob) undo() {
+	var err error
+	if b.mode == ImportMove {
+		err = os.Rename(b.target, b.source)
+	} else {
+		err = os.Remove(b.target)
+	}
+	if err != nil {
+		log.Printf("[WARN] Failed to revert import of %s: %v", b.source, err)
+	}
+}
+
+func undoImport(blobs []importedBlob) {
+	for _, blob := range blobs {
+		blob.undo()
+	}
+}
+
+// place stores one file of the source tree in UPLOAD_PATH and returns its
+// unsaved record
+func (s *ImportService) place(ctx context.Context, params ImportParams, source, rel string) (*model.File, importedBlob, error) {
+	sourcePath := filepath.Join(source, filepath.FromSlash(rel))
+	name := path.Base(rel)
+	folder := path.Dir(rel)
+	if folder == "." {
+		folder = ""
+	}
+	folderPath, err := s.files.sanitizeFolderPath(path.Join(params.FolderPath, folder))
+	if err != nil {
+		return nil, importedBlob{}, err
+	}
+
+	f, err := os.Open(sourcePath)
+	if err != nil {
+		return nil, importedBlob{}, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, importedBlob{}, err
+	}
+	head, err := readDetectionHeader(f)
+	f.Close()
+	if err != nil {
+		return nil, importedBlob{}, err
+	}
+	detection := detect.Inspect(s.files.detector, name, "", head)
+	mimeType := detection.Effective(s.files.mimePolicy)
+
+	ext := filepath.Ext(name)
+	if ext == "" {
+		ext = ".bin"
+	}
+	uniqueFilename := uuid.New().String() + ext
+	uploadDir := filepath.Join(s.files.uploadPath, fmt.Sprintf("%d", params.UserID), time.Now().Format("2006-01-02"))
+	if err := s.files.storage.Retry(ctx, "mkdir", func() error { return os.MkdirAll(uploadDir, 0755) }); err != nil {
+		return nil, importedBlob{}, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	var compression string
+	digest := newContentDigest()
+	storedSize := info.Size()
+	target := filepath.Join(uploadDir, uniqueFilename)
+	switch params.Mode {
+	case ImportMove, ImportLink:
+		place := os.Link
+		if params.Mode == ImportMove {
+			place = os.Rename
+		}
+		if err := s.files.storage.Run(ctx, params.Mode, func() error { return place(sourcePath, target) }); err != nil {
+			return nil, importedBlob{}, err
+		}
+		if err := hashFile(target, digest); err != nil {
+			importedBlob{sourcePath, target, params.Mode}.undo()
+			return nil, importedBlob{}, err
+		}
+	default:
+		if err := s.files.diskGuard.Check(info.Size()); err != nil {
+			return nil, importedBlob{}, err
+		}
+		compression = s.files.compressor.Algorithm(mimeType, info.Size())
+		target += CompressionSuffix(compression)
+		err := s.files.storage.Retry(ctx, "write", func() error {
+			src, err := os.Open(sourcePath)
+			if err != nil {
+				return err
+			}
+			defer src.Close()
+			digest = newContentDigest()
+			storedSize, err = writeStored(ctx, s.files.temp, target, compression, src, digest)
+			return err
+		})
+		if err != nil {
+			return nil, importedBlob{}, fmt.Errorf("failed to save file: %w", err)
+		}
+	}
+
+	file := &model.File{
+		UserID:            params.UserID,
+		Filename:          uniqueFilename,
+		OriginalName:      s.files.filenamePolicy().Apply(s.files.sanitizeFilename(name)),
+		FilePath:          target,
+		FolderPath:        folderPath,
+		MimeType:          mimeType,
+		Kind:              model.FileKind(mimeType, name),
+		ExtensionMimeType: detection.Extension,
+		DetectedMimeType:  detection.Detected,
+		MimeMismatch:      detection.Mismatch(),
+		Upload:            model.UploadSource{Source: model.SourceImport, Label: params.SourceDir},
+	}
+	file.NameWarning = s.files.filenamePolicy().Warning(file.OriginalName)
+	digest.apply(file)
+	if compression != "" {
+		file.Compression, file.StoredSize = compression, storedSize
+	}
+	if allowedImageTypes[file.MimeType] {
+		if meta, err := readImageMetadataFile(target, file.MimeType); err == nil {
+			file.Width, file.Height = meta.Width, meta.Height
+			file.FrameCount, file.ColorProfile, file.ColorSpace = meta.FrameCount, meta.ColorProfile, meta.ColorSpace
+		}
+	}
+	s.files.generateFileURL(file)
+
+	return file, importedBlob{sourcePath, target, params.Mode}, nil
+}
+
+func hashFile(path string, digest *contentDigest) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(digest, f)
+	return err
+}
+
+// walkImport calls visit with the slash separated path of every importable
+// file below root that comes after checkpoint in walk order, until visit
+// returns false. Hidden files and directories and anything that is not a
+// regular file, like symlinks, are skipped.
+func walkImport(root, checkpoint string, visit func(rel string) bool) error {
+	return filepath.WalkDir(root, func(p string, entry fs.DirEntry, err error) error {
+		if p == root {
+			return err
+		}
+		rel, relErr := filepath.Rel(root, p)
+		if relErr != nil {
+			return relErr
+		}
+		rel = filepath.ToSlash(rel)
+		isHidden := strings.HasPrefix(entry.Name(), ".")
+
+		if entry.IsDir() {
+			if err != nil {
+				log.Printf("[WARN] Skipping unreadable directory %s: %v", p, err)
+				return nil
+			}
+			// Directories entirely before the checkpoint were imported already
+			if isHidden || (checkpoint != "" && compareWalkOrder(rel, checkpoint) < 0 && !strings.HasPrefix(checkpoint, rel+"/")) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if err != nil {
+			log.Printf("[WARN] Skipping unreadable file %s: %v", p, err)
+			return nil
+		}
+		if isHidden || !entry.Type().IsRegular() || (checkpoint != "" && compareWalkOrder(rel, checkpoint) <= 0) {
+			return nil
+		}
+		if !visit(rel) {
+			return fs.SkipAll
+		}
+		return nil
+	})
+}
+
+// compareWalkOrder compares slash separated paths in the order WalkDir visits
+// them, which sorts by path component rather than by the whole string
+func compareWalkOrder(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"storage-service/internal/coord"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+
+	"gorm.io/gorm"
+)
+
+// JobStep processes the next batch of a job, advancing job.Cursor and its
+// counters. It returns true once there is nothing left to process.
+type JobStep func(ctx context.Context, job *model.Job) (bool, error)
+
+// JobService queues background jobs and runs them in batches. Progress is
+// saved after every batch so jobs resume after a restart.
+type JobService struct {
+	jobRepo *repository.JobRepository
+	locker  coord.Locker
+	steps   map[string]JobStep
+}
+
+func NewJobService(jobRepo *repository.JobRepository, locker coord.Locker) *JobService {
+	return &JobService{
+		jobRepo: jobRepo,
+		locker:  locker,
+		steps:   make(map[string]JobStep),
+	}
+}
+
+// Register adds the step function for a job type
+func (s *JobService) Register(jobType string, step JobStep) {
+	s.steps[jobType] = step
+}
+
+// Enqueue creates a pending job; params are stored as JSON
+func (s *JobService) Enqueue(ctx context.Context, jobType string, params interface{}, createdBy uint) (*model.Job, error) {
+	if _, ok := s.steps[jobType]; !ok {
+		return nil, ErrUnknownJobType.WithArgs(jobType)
+	}
+
+	encoded, err := json.Marshal(params)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &model.Job{
+		Type:      jobType,
+		Status:    model.JobPending,
+		Params:    string(encoded),
+		CreatedBy: createdBy,
+	}
+	if err := s.jobRepo.Create(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %w", err)
+	}
+	return job, nil
+}
+
+func (s *JobService) GetJob(ctx context.Context, id uint) (*model.Job, error) {
+	job, err := s.jobRepo.FindByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrJobNotFound
+	}
+	return job, err
+}
+
+// ListJobs returns a page of jobs, newest first, of a type and in a status
+// when they are set
+func (s *JobService) ListJobs(ctx context.Context, jobType, status string, page, pageSize int) ([]model.Job, int64, error) {
+	return s.listJobs(ctx, repository.JobFilter{Type: jobType, Status: status}, page, pageSize)
+}
+
+func (s *JobService) listJobs(ctx context.Context, filter repository.JobFilter, page, pageSize int) ([]model.Job, int64, error) {
+	offset := (page - 1) * pageSize
+	jobs, err := s.jobRepo.FindAll(ctx, filter, pageSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := s.jobRepo.Count(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return jobs, total, nil
+}
+
+// userCancellableJobs are the types of jobs users may cancel themselves.
+// Other jobs on their behalf, like transcodes of their uploads, finish the
+// work of a request that already succeeded.
+var userCancellableJobs = map[string]bool{
+	JobDeleteFolder: true,
+}
+
+// ListUserJobs is ListJobs for the jobs started by or on behalf of userID
+func (s *JobService) ListUserJobs(ctx context.Context, userID uint, jobType, status string, page, pageSize int) ([]model.Job, int64, error) {
+	return s.listJobs(ctx, repository.JobFilter{CreatedBy: userID, Type: jobType, Status: status}, page, pageSize)
+}
+
+// GetUserJob returns a job started by or on behalf of userID, hiding other
+// jobs as not found
+func (s *JobService) GetUserJob(ctx context.Context, id, userID uint) (*model.Job, error) {
+	job, err := s.GetJob(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if job.CreatedBy != userID {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// CancelUserJob cancels a job of userID, see userCancellableJobs
+func (s *JobService) CancelUserJob(ctx context.Context, id, userID uint) (*model.Job, error) {
+	job, err := s.GetUserJob(ctx, id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !userCancellableJobs[job.Type] {
+		return nil, ErrJobNotCancellable.WithArgs(job.Type)
+	}
+	return s.CancelJob(ctx, id)
+}
+
+// CancelJob stops a job; a running job stops after its current batch
+func (s *JobService) CancelJob(ctx context.Context, id uint) (*model.Job, error) {
+	if _, err := s.GetJob(ctx, id); err != nil {
+		return nil, err
+	}
+
+	cancelled, err := s.jobRepo.Cancel(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !cancelled {
+		return nil, ErrJobNotActive
+	}
+	return s.GetJob(ctx, id)
+}
+
+// PauseJob stops a job after its current batch; ResumeJob continues it from
+// where it stopped
+func (s *JobService) PauseJob(ctx context.Context, id uint) (*model.Job, error) {
+	if _, err := s.GetJob(ctx, id); err != nil {
+		return nil, err
+	}
+
+	paused, err := s.jobRepo.Pause(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !paused {
+		return nil, ErrJobNotActive
+	}
+	return s.GetJob(ctx, id)
+}
+
+func (s *JobService) ResumeJob(ctx context.Context, id uint) (*model.Job, error) {
+	if _, err := s.GetJob(ctx, id); err != nil {
+		return nil, err
+	}
+
+	resumed, err := s.jobRepo.Resume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !resumed {
+		return nil, ErrJobNotPaused
+	}
+	return s.GetJob(ctx, id)
+}
+
+// RunPending runs queued and interrupted jobs one after another until they
+// finish or ctx is cancelled
+func (s *JobService) RunPending(ctx context.Context) error {
+	jobs, err := s.jobRepo.FindRunnable(ctx)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs {
+		if ctx.Err() != nil {
+			return nil
+		}
+		s.run(ctx, &jobs[i])
+	}
+	return nil
+}
+
+func (s *JobService) run(ctx context.Context, job *model.Job) {
+	release, ok, err := s.locker.TryLock(ctx, fmt.Sprintf("job:%d", job.ID))
+	if err != nil || !ok {
+		return
+	}
+	defer release()
+
+	step, ok := s.steps[job.Type]
+	if !ok {
+		s.finish(ctx, job, model.JobFailed, fmt.Sprintf("unknown job type %q", job.Type))
+		return
+	}
+
+	if err := s.jobRepo.Start(ctx, job); err != nil {
+		log.Printf("Failed to start job %d: %v", job.ID, err)
+		return
+	}
+	log.Printf("Running %s job %d from cursor %d", job.Type, job.ID, job.Cursor)
+
+	for ctx.Err() == nil {
+		done, err := step(ctx, job)
+		if err != nil {
+			s.finish(ctx, job, model.JobFailed, err.Error())
+			return
+		}
+
+		running, err := s.jobRepo.SaveProgress(ctx, job)
+		if err != nil {
+			log.Printf("Failed to save progress of job %d: %v", job.ID, err)
+			return
+		}
+		if !running {
+			log.Printf("Job %d was cancelled or paused", job.ID)
+			return
+		}
+
+		if done {
+			s.finish(ctx, job, model.JobDone, "")
+			return
+		}
+	}
+	// Interrupted by shutdown; the job stays running and resumes on the next run
+}
+
+func (s *JobService) finish(ctx context.Context, job *model.Job, status, errMessage string) {
+	if err := s.jobRepo.Finish(ctx, job, status, errMessage); err != nil {
+		log.Printf("Failed to finish job %d: %v", job.ID, err)
+		return
+	}
+	log.Printf("Job %d %s: processed=%d failed=%d", job.ID, status, job.Processed, job.Failed)
+}
+
+// decodeJobParams unmarshals the stored parameters of a job
+func decodeJobParams(job *model.Job, params interface{}) error {
+	if job.Params == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(job.Params), params)
+}
@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"storage-service/internal/model"
+	"time"
+)
+
+// ManifestEntry describes one file of a sync manifest
+type ManifestEntry struct {
+	ID uint `json:"id"`
+	// Path is below the folder of the manifest, e.g. "2024/beach.jpg"
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// SHA256 is empty for files uploaded before checksums were recorded
+	SHA256 string `json:"sha256,omitempty"`
+	// MTime is the last content change, rename or move
+	MTime time.Time `json:"mtime"`
+}
+
+// StreamManifest calls fn for every file in folderPath and its subfolders,
+// the whole account when it is empty. Entries come sorted by folder and
+// then name, bytewise, the same on every call, so sync clients can merge
+// them with a sorted local listing in one pass.
+func (s *FileService) StreamManifest(ctx context.Context, userID uint, folderPath string, fn func(*ManifestEntry) error) error {
+	folderPath = model.CleanFolderPath(folderPath)
+	if folderPath != "" {
+		exists, err := s.folderExists(ctx, userID, folderPath)
+		if err != nil {
+			return fmt.Errorf("failed to inspect folder: %w", err)
+		}
+		if !exists {
+			return ErrFolderNotFound
+		}
+	}
+
+	return s.fileRepo.StreamTreeByPath(ctx, userID, folderPath, func(file *model.File) error {
+		return fn(&ManifestEntry{
+			ID:     file.ID,
+			Path:   relativeFilePath(folderPath, file),
+			Size:   file.FileSize,
+			SHA256: file.SHA256,
+			MTime:  file.UpdatedAt.UTC(),
+		})
+	})
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Plan is a tier of limits. Users may change their own limits up to the
+// ceilings of their plan; only admins move them to another plan.
+type Plan struct {
+	Name        string `json:"name"`
+	MaxFiles    int64  `json:"max_files"`
+	MaxFileSize int64  `json:"max_file_size"`
+	MaxStorage  int64  `json:"max_storage"`
+
+	// OverageStorage is storage active subscribers may use beyond their
+	// max_storage, reported to Stripe as usage; zero disables overage
+	OverageStorage int64 `json:"overage_storage,omitempty"`
+}
+
+// Plans maps plan names to their limits
+type Plans map[string]Plan
+
+// ParsePlans parses a spec such as
+// "free:files=1000,file_size=10MB,storage=1GB;pro:files=100000,file_size=2GB,storage=100GB,overage=50GB"
+// and checks that defaultPlan is one of the plans. Every plan sets files,
+// file_size and storage; overage is optional.
+func ParsePlans(spec, defaultPlan string) (Plans, error) {
+	plans := make(Plans)
+	for _, entry := range strings.Split(spec, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(entry, ":")
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, ",= ") {
+			return nil, fmt.Errorf("invalid plan %q, expected name:limit=value,...", entry)
+		}
+		if _, ok := plans[name]; ok {
+			return nil, fmt.Errorf("duplicate plan %q", name)
+		}
+
+		plan := Plan{Name: name}
+		for _, option := range strings.Split(options, ",") {
+			if err := plan.apply(strings.TrimSpace(option)); err != nil {
+				return nil, fmt.Errorf("plan %q: %w", name, err)
+			}
+		}
+		if plan.MaxFiles <= 0 || plan.MaxFileSize <= 0 || plan.MaxStorage <= 0 {
+			return nil, fmt.Errorf("plan %q: files, file_size and storage are required", name)
+		}
+		plans[name] = plan
+	}
+	if _, ok := plans[defaultPlan]; !ok {
+		return nil, fmt.Errorf("default plan %q is not defined in PLANS", defaultPlan)
+	}
+	return plans, nil
+}
+
+func (p *Plan) apply(option string) error {
+	if option == "" {
+		return nil
+	}
+	key, value, ok := strings.Cut(option, "=")
+	if !ok {
+		return fmt.Errorf("invalid limit %q, expected limit=value", option)
+	}
+	var err error
+	switch strings.ToLower(strings.TrimSpace(key)) {
+	case "files":
+		p.MaxFiles, err = strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	case "file_size":
+		p.MaxFileSize, err = ParseByteSize(value)
+	case "storage":
+		p.MaxStorage, err = ParseByteSize(value)
+	case "overage":
+		p.OverageStorage, err = ParseByteSize(value)
+	default:
+		return fmt.Errorf("unknown limit %q, use files, file_size, storage or overage", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid limit %q: %w", option, err)
+	}
+	return nil
+}
+
+// Get returns the plan with the given name
+func (p Plans) Get(name string) (Plan, error) {
+	plan, ok := p[name]
+	if !ok {
+		return Plan{}, ErrUnknownPlan.WithArgs(name)
+	}
+	return plan, nil
+}
+
+// List returns every plan ordered by name
+func (p Plans) List() []Plan {
+	list := make([]Plan, 0, len(p))
+	for _, plan := range p {
+		list = append(list, plan)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// checkSettings returns ErrPlanLimitExceeded when settings raise a limit
+// above the ceiling of the plan; zero values leave a limit unchanged
+func (p Plan) checkSettings(settings *UserSettings) error {
+	if settings.MaxFiles > p.MaxFiles {
+		return ErrPlanLimitExceeded.WithArgs("max_files", strconv.FormatInt(p.MaxFiles, 10), p.Name)
+	}
+	if settings.MaxFileSize > p.MaxFileSize {
+		return ErrPlanLimitExceeded.WithArgs("max_file_size", formatByteSize(p.MaxFileSize), p.Name)
+	}
+	if settings.MaxStorage > p.MaxStorage {
+		return ErrPlanLimitExceeded.WithArgs("max_storage", formatByteSize(p.MaxStorage), p.Name)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"storage-service/internal/model"
+
+	"gorm.io/gorm"
+)
+
+// rawUploadMemory is how much of a raw upload is kept in memory, the rest is
+// spooled to a temporary file
+const rawUploadMemory = 8 << 20
+
+// spoolUpload turns a raw request body, as S3 and WebDAV clients send it, into the
+// file of a multipart form, so it takes the same path through validation
+// and storage as any other upload. remove deletes the spooled copy.
+func spoolUpload(filename, contentType string, body io.Reader) (*multipart.FileHeader, func(), error) {
+	disposition := mime.FormatMediaType("form-data", map[string]string{"name": "file", "filename": filename})
+	if disposition == "" {
+		return nil, func() {}, ErrInvalidFilename
+	}
+
+	pr, pw := io.Pipe()
+	writer := multipart.NewWriter(pw)
+	go func() {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Disposition", disposition)
+		if contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		part, err := writer.CreatePart(header)
+		if err == nil {
+			_, err = io.Copy(part, body)
+		}
+		if err == nil {
+			err = writer.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	form, err := multipart.NewReader(pr, writer.Boundary()).ReadForm(rawUploadMemory)
+	// Unblocks the writer when reading stopped early
+	pr.CloseWithError(io.ErrClosedPipe)
+	if err != nil {
+		return nil, func() {}, err
+	}
+	remove := func() { form.RemoveAll() }
+	files := form.File["file"]
+	if len(files) != 1 {
+		remove()
+		return nil, func() {}, ErrInvalidFilename
+	}
+	return files[0], remove, nil
+}
+
+// putFile stores body as the file name in folderPath, creating the folder,
+// for clients that write files by path like S3 and WebDAV. A file already
+// there gets the new content as a version when versioning is on, and goes to
+// the trash otherwise. Files in write-once folders can't be overwritten.
+// size is the declared length, -1 when unknown.
+func (s *FileService) putFile(ctx context.Context, userID uint, folderPath, name, contentType string, size int64, body io.Reader, source model.UploadSource) (*model.File, error) {
+	// Refuse what can't be stored before spooling it
+	if size > 0 {
+		if err := s.userService.CheckUploadAllowed(ctx, userID, size); err != nil {
+			return nil, err
+		}
+	}
+	previous, err := s.fileRepo.FindByName(ctx, userID, folderPath, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		previous, err = nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil {
+		if err := s.checkWriteOnce(ctx, previous); err != nil {
+			return nil, err
+		}
+	}
+
+	fileHeader, remove, err := spoolUpload(name, contentType, body)
+	defer remove()
+	if err != nil {
+		return nil, fmt.Errorf("failed to receive file: %w", err)
+	}
+
+	file, err := s.UploadFileWithOptions(ctx, userID, fileHeader, UploadOptions{FolderPath: folderPath, Replace: true, Source: source})
+	if err != nil {
+		return nil, err
+	}
+	if previous != nil && previous.ID != file.ID && !s.VersioningEnabled() {
+		if err := s.DeleteFile(context.WithoutCancel(ctx), previous.ID, userID); err != nil {
+			log.Printf("[WARN] Failed to remove replaced file %d: %v", previous.ID, err)
+		}
+	}
+	return file, nil
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"strings"
+)
+
+// Replica maps files under UPLOAD_PATH to their mirror under REPLICA_PATH.
+// A nil Replica means replication is disabled; its methods are safe to call.
+type Replica struct {
+	uploadPath string
+	dir        string
+}
+
+// NewReplica returns nil when REPLICA_PATH is empty
+func NewReplica(cfg *config.Config) *Replica {
+	if cfg.ReplicaPath == "" {
+		return nil
+	}
+	return &Replica{uploadPath: cfg.UploadPath, dir: cfg.ReplicaPath}
+}
+
+// Dir returns the replica root, or "" when replication is disabled
+func (r *Replica) Dir() string {
+	if r == nil {
+		return ""
+	}
+	return r.dir
+}
+
+// Path returns the mirror of a file stored under UPLOAD_PATH
+func (r *Replica) Path(primaryPath string) (string, bool) {
+	if r == nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(r.uploadPath, primaryPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(r.dir, rel), true
+}
+
+// Mirror returns the mirror of a file stored under UPLOAD_PATH if it exists
+func (r *Replica) Mirror(primaryPath string) (string, bool) {
+	mirror, ok := r.Path(primaryPath)
+	if !ok {
+		return "", false
+	}
+	if _, err := os.Stat(mirror); err != nil {
+		return "", false
+	}
+	return mirror, true
+}
+
+// Locate returns the path to read a file from: the primary copy when it can
+// be accessed, otherwise its mirror if that exists
+func (r *Replica) Locate(primaryPath string) string {
+	_, err := os.Stat(primaryPath)
+	if err == nil {
+		return primaryPath
+	}
+	mirror, ok := r.Mirror(primaryPath)
+	if !ok {
+		return primaryPath
+	}
+	log.Printf("[WARN] Reading %s from the replica: %v", primaryPath, err)
+	return mirror
+}
@@ -0,0 +1,249 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+)
+
+// JobReplicateFiles copies files to the replica, or verifies existing copies
+const JobReplicateFiles = "replicate_files"
+
+// ReplicateFilesParams selects the files a replication job processes. Verify
+// compares checksums of both copies instead of only their sizes.
+type ReplicateFilesParams struct {
+	FileIDs []uint `json:"file_ids,omitempty"`
+	Verify  bool   `json:"verify"`
+}
+
+// ReplicationService mirrors every stored file to REPLICA_PATH in the
+// background and periodically verifies the mirror. Reads fall back to the
+// mirror through Replica.Locate when the primary copy is unavailable.
+type ReplicationService struct {
+	fileRepo *repository.FileRepository
+	jobs     *JobService
+	replica  *Replica
+	temp     *TempStore
+}
+
+func NewReplicationService(fileRepo *repository.FileRepository, jobs *JobService, bus *events.Bus, cfg *config.Config) *ReplicationService {
+	replica := NewReplica(cfg)
+	s := &ReplicationService{fileRepo: fileRepo, jobs: jobs, replica: replica}
+	jobs.Register(JobReplicateFiles, s.step)
+	if replica == nil {
+		return s
+	}
+
+	s.temp = &TempStore{dir: filepath.Join(replica.Dir(), ".tmp"), maxAge: cfg.TempFileMaxAge}
+
+	bus.Subscribe(events.FileCreated, func(event events.Event) { s.Queue(context.Background(), event.File) })
+	bus.Subscribe(events.FileUpdated, s.onFileUpdated)
+	bus.Subscribe(events.FileReplaced, func(event events.Event) { s.Queue(context.Background(), event.File) })
+	bus.Subscribe(events.FileDeleted, s.onFileDeleted)
+	return s
+}
+
+// Enabled reports whether REPLICA_PATH is configured
+func (s *ReplicationService) Enabled() bool {
+	return s.replica != nil
+}
+
+// Replica returns the path mapping used for failover reads
+func (s *ReplicationService) Replica() *Replica {
+	return s.replica
+}
+
+// Check verifies that the replica directory is writable
+func (s *ReplicationService) Check() error {
+	if !s.Enabled() {
+		return nil
+	}
+	f, err := s.temp.Create()
+	if err != nil {
+		return err
+	}
+	s.temp.Discard(f)
+	return nil
+}
+
+// Queue schedules copying a new or changed file to the replica
+func (s *ReplicationService) Queue(ctx context.Context, file *model.File) {
+	if !s.Enabled() {
+		return
+	}
+	params := ReplicateFilesParams{FileIDs: []uint{file.ID}}
+	if _, err := s.jobs.Enqueue(ctx, JobReplicateFiles, params, file.UserID); err != nil {
+		log.Printf("[WARN] Failed to queue replication of file %d: %v", file.ID, err)
+	}
+}
+
+// StartVerify queues a job comparing checksums of every file with its
+// mirror and copying files that are missing or differ
+func (s *ReplicationService) StartVerify(ctx context.Context, createdBy uint) (*model.Job, error) {
+	if !s.Enabled() {
+		return nil, ErrReplicationDisabled
+	}
+	return s.jobs.Enqueue(ctx, JobReplicateFiles, ReplicateFilesParams{Verify: true}, createdBy)
+}
+
+// Verify is the periodic task behind REPLICA_VERIFY_INTERVAL
+func (s *ReplicationService) Verify(ctx context.Context) error {
+	_, err := s.StartVerify(ctx, 0)
+	return err
+}
+
+// Cleanup removes stale temp files from the replica
+func (s *ReplicationService) Cleanup(ctx context.Context) error {
+	if !s.Enabled() {
+		return nil
+	}
+	return s.temp.Cleanup(ctx)
+}
+
+func (s *ReplicationService) onFileUpdated(event events.Event) {
+	if event.PreviousPath != "" && event.PreviousPath != event.File.FilePath {
+		s.remove(event.PreviousPath)
+	}
+	s.Queue(context.Background(), event.File)
+}
+
+func (s *ReplicationService) onFileDeleted(event events.Event) {
+	// Keep the mirror of a blob other files still use
+	others, err := s.fileRepo.CountOthersByFilePath(context.Background(), event.File.FilePath, event.File.ID)
+	if err != nil || others > 0 {
+		return
+	}
+	s.remove(event.File.FilePath)
+}
+
+// remove deletes the mirror of a file that is gone from the primary
+func (s *ReplicationService) remove(primaryPath string) {
+	mirror, ok := s.replica.Path(primaryPath)
+	if !ok {
+		return
+	}
+	if err := os.Remove(mirror); err != nil && !os.IsNotExist(err) {
+		log.Printf("[WARN] Failed to remove replica %s: %v", mirror, err)
+	}
+}
+
+func (s *ReplicationService) step(ctx context.Context, job *model.Job) (bool, error) {
+	if !s.Enabled() {
+		return false, ErrReplicationDisabled
+	}
+
+	var params ReplicateFilesParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+
+	filter := repository.FileFilter{IDs: params.FileIDs}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err := s.sync(ctx, &files[i], params.Verify); err != nil {
+			log.Printf("[WARN] Failed to replicate file %d: %v", files[i].ID, err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = files[i].ID
+	}
+
+	return len(files) < jobBatchSize, nil
+}
+
+// sync copies a file to the replica unless an identical copy is there
+func (s *ReplicationService) sync(ctx context.Context, file *model.File, verify bool) error {
+	mirror, ok := s.replica.Path(file.FilePath)
+	if !ok {
+		return fmt.Errorf("%s is outside UPLOAD_PATH", file.FilePath)
+	}
+
+	same, err := sameContent(file.FilePath, mirror, verify)
+	if err != nil || same {
+		return err
+	}
+	if verify {
+		log.Printf("[WARN] Replica of file %d is missing or differs, copying it again", file.ID)
+	}
+
+	src, err := os.Open(file.FilePath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// Copy the bytes as stored, compressed files stay compressed
+	_, err = writeStored(ctx, s.temp, mirror, "", src)
+	return err
+}
+
+// sameContent compares a file with its mirror by size, and by checksum when
+// verify is set
+func sameContent(primaryPath, mirrorPath string, verify bool) (bool, error) {
+	primary, err := os.Stat(primaryPath)
+	if err != nil {
+		return false, err
+	}
+	mirror, err := os.Stat(mirrorPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if primary.Size() != mirror.Size() {
+		return false, nil
+	}
+	if !verify {
+		return true, nil
+	}
+
+	primarySum, err := fileChecksum(primaryPath)
+	if err != nil {
+		return false, err
+	}
+	mirrorSum, err := fileChecksum(mirrorPath)
+	if err != nil {
+		return false, err
+	}
+	return primarySum == mirrorSum, nil
+}
+
+func fileChecksum(path string) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	f, err := os.Open(path)
+	if err != nil {
+		return sum, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"time"
+)
+
+// MaxReportWindow bounds how far back storage reports look
+const MaxReportWindow = 366 * 24 * time.Hour
+
+// ReportService builds the storage reports admins use for capacity
+// planning. Every report is a single aggregate query on the read replica.
+type ReportService struct {
+	fileRepo *repository.FileRepository
+}
+
+func NewReportService(fileRepo *repository.FileRepository) *ReportService {
+	return &ReportService{fileRepo: fileRepo}
+}
+
+// IngestDay is what was uploaded on one day (UTC), e.g. "2024-03-01"
+type IngestDay struct {
+	Day   string `json:"day"`
+	Files int64  `json:"files"`
+	Bytes int64  `json:"bytes"`
+}
+
+// ParseReportWindow parses how far back a report looks, e.g. "30d"
+func ParseReportWindow(value string) (time.Duration, error) {
+	window, err := ParseExpireAfter(value)
+	if err != nil || window > MaxReportWindow {
+		return 0, ErrInvalidReportWindow.WithArgs(value)
+	}
+	return window, nil
+}
+
+// LargestFiles returns the biggest files of all users
+func (s *ReportService) LargestFiles(ctx context.Context, limit int) ([]model.File, error) {
+	return s.fileRepo.FindLargest(ctx, limit)
+}
+
+// FastestGrowingUsers returns the users who added the most bytes in the
+// window, biggest first
+func (s *ReportService) FastestGrowingUsers(ctx context.Context, window time.Duration, limit int) ([]repository.UserGrowth, error) {
+	return s.fileRepo.SumByUserSince(ctx, time.Now().Add(-window), limit)
+}
+
+// IngestByDay returns the uploads of every day in the window, oldest first.
+// Days without uploads are included with zeros, so charts have no gaps.
+func (s *ReportService) IngestByDay(ctx context.Context, window time.Duration) ([]IngestDay, error) {
+	since := time.Now().UTC().Add(-window)
+	rows, err := s.fileRepo.SumByDaySince(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+
+	byDay := make(map[string]repository.DayTotal, len(rows))
+	for _, row := range rows {
+		byDay[row.Day.Format(time.DateOnly)] = row
+	}
+	var days []IngestDay
+	today := time.Now().UTC().Format(time.DateOnly)
+	for day := since.Truncate(24 * time.Hour); ; day = day.AddDate(0, 0, 1) {
+		key := day.Format(time.DateOnly)
+		row := byDay[key]
+		days = append(days, IngestDay{Day: key, Files: row.Files, Bytes: row.Bytes})
+		if key >= today {
+			break
+		}
+	}
+	return days, nil
+}
@@ -0,0 +1,212 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// MaxS3Keys is the most objects and common prefixes a listing returns
+const MaxS3Keys = 1000
+
+// s3ListBatch is how many files a listing reads at a time
+const s3ListBatch = 500
+
+// s3PrefixEnd sorts after any key continuing a common prefix, so a listing
+// can skip the keys it groups; it is the highest code point
+const s3PrefixEnd = "\U0010FFFF"
+
+// S3Object is a file listed by its key
+type S3Object struct {
+	Key  string
+	File *model.File
+}
+
+// S3ListQuery selects the objects a listing returns. After is the key or
+// common prefix the previous page ended with.
+type S3ListQuery struct {
+	Prefix    string
+	Delimiter string
+	After     string
+	MaxKeys   int
+}
+
+// S3ObjectList is a page of objects. Keys continuing a common prefix up to
+// the delimiter are summarized by the prefix.
+type S3ObjectList struct {
+	Objects        []S3Object
+	CommonPrefixes []string
+	Truncated      bool
+	// Next continues a truncated listing as the After of the next query
+	Next string
+}
+
+// S3Service maps the S3 gateway onto folders and files: buckets are the
+// top-level folders of the user and the key of an object is the path of a
+// file below its bucket, e.g. "2024/beach.jpg" in bucket "photos" is
+// beach.jpg in folder photos/2024.
+type S3Service struct {
+	files    *FileService
+	fileRepo *repository.FileRepository
+}
+
+func NewS3Service(files *FileService, fileRepo *repository.FileRepository) *S3Service {
+	return &S3Service{files: files, fileRepo: fileRepo}
+}
+
+// Buckets returns the top-level folders of userID
+func (s *S3Service) Buckets(ctx context.Context, userID uint) ([]model.Folder, error) {
+	roots, err := s.files.GetFolderTree(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	buckets := make([]model.Folder, 0, len(roots))
+	for _, root := range roots {
+		if root.Path != "" {
+			buckets = append(buckets, root.Folder)
+		}
+	}
+	return buckets, nil
+}
+
+// CheckBucket fails with ErrBucketNotFound unless bucket is a folder of
+// userID
+func (s *S3Service) CheckBucket(ctx context.Context, userID uint, bucket string) error {
+	if !validBucket(bucket) {
+		return ErrBucketNotFound
+	}
+	exists, err := s.files.folderExists(ctx, userID, bucket)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrBucketNotFound
+	}
+	return nil
+}
+
+// CreateBucket creates the folder of a bucket, failing with ErrFolderExists
+// when there is one
+func (s *S3Service) CreateBucket(ctx context.Context, userID uint, bucket string) error {
+	if !validBucket(bucket) {
+		return ErrInvalidFolderPath
+	}
+	_, err := s.files.CreateFolder(ctx, userID, bucket)
+	return err
+}
+
+// GetObject returns the file of a key, the newest if several files have
+// its path
+func (s *S3Service) GetObject(ctx context.Context, userID uint, bucket, key string) (*model.File, error) {
+	folderPath, name, err := s.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := s.fileRepo.FindByName(ctx, userID, folderPath, name)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// PutObject stores body as the file of a key, creating its folders, see
+// FileService.putFile. size is the declared length, -1 when unknown.
+func (s *S3Service) PutObject(ctx context.Context, userID uint, bucket, key, contentType string, size int64, body io.Reader, source model.UploadSource) (*model.File, error) {
+	folderPath, name, err := s.objectPath(bucket, key)
+	if err != nil {
+		return nil, err
+	}
+	return s.files.putFile(ctx, userID, folderPath, name, contentType, size, body, source)
+}
+
+// DeleteObject deletes the file of a key like DeleteFile. Deleting a
+// missing key succeeds, as in S3.
+func (s *S3Service) DeleteObject(ctx context.Context, userID uint, bucket, key string) error {
+	file, err := s.GetObject(ctx, userID, bucket, key)
+	if errors.Is(err, ErrFileNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.files.DeleteFile(ctx, file.ID, userID)
+}
+
+// ListObjects returns a page of the objects of a bucket in key order,
+// compared bytewise
+func (s *S3Service) ListObjects(ctx context.Context, userID uint, bucket string, query S3ListQuery) (*S3ObjectList, error) {
+	if err := s.CheckBucket(ctx, userID, bucket); err != nil {
+		return nil, err
+	}
+	if query.MaxKeys < 0 || query.MaxKeys > MaxS3Keys {
+		query.MaxKeys = MaxS3Keys
+	}
+
+	list := &S3ObjectList{Objects: []S3Object{}, CommonPrefixes: []string{}}
+	after := query.After
+	for {
+		files, err := s.fileRepo.FindTreeByPathAfter(ctx, userID, bucket, query.Prefix, after, s3ListBatch)
+		if err != nil {
+			return nil, err
+		}
+
+		skipped := false
+		for i := range files {
+			key := relativeFilePath(bucket, &files[i])
+			if key <= after {
+				// An older file of the same path
+				continue
+			}
+			if len(list.Objects)+len(list.CommonPrefixes) == query.MaxKeys {
+				list.Truncated, list.Next = true, after
+				return list, nil
+			}
+
+			if query.Delimiter != "" {
+				rest := strings.TrimPrefix(key, query.Prefix)
+				if i := strings.Index(rest, query.Delimiter); i >= 0 {
+					prefix := query.Prefix + rest[:i+len(query.Delimiter)]
+					list.CommonPrefixes = append(list.CommonPrefixes, prefix)
+					after = prefix + s3PrefixEnd
+					skipped = true
+					break
+				}
+			}
+			list.Objects = append(list.Objects, S3Object{Key: key, File: &files[i]})
+			after = key
+		}
+		if !skipped && len(files) < s3ListBatch {
+			return list, nil
+		}
+	}
+}
+
+// objectPath returns the folder and the name of the file a key names,
+// normalized like uploads are
+func (s *S3Service) objectPath(bucket, key string) (string, string, error) {
+	if !validBucket(bucket) {
+		return "", "", ErrBucketNotFound
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." || strings.Contains(segment, `\`) {
+			return "", "", ErrInvalidObjectKey.WithArgs(key)
+		}
+	}
+	dir, name := path.Split(key)
+	folderPath := model.CleanFolderPath(bucket + "/" + dir)
+	return folderPath, s.files.filenamePolicy().Apply(s.files.sanitizeFilename(name)), nil
+}
+
+// validBucket reports whether bucket names a top-level folder
+func validBucket(bucket string) bool {
+	return bucket != "" && !strings.ContainsAny(bucket, `/\`) && model.CleanFolderPath(bucket) == bucket
+}
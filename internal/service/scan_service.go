@@ -0,0 +1,297 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"storage-service/internal/scan"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// JobScanFiles scans stored files for malware
+const JobScanFiles = "scan_files"
+
+// quarantineDir holds infected files inside UPLOAD_PATH; as a dot directory
+// it is never served
+const quarantineDir = ".quarantine"
+
+// ScanFilesParams selects the files a scan job processes. Empty lists match
+// all files, e.g. to rescan everything after a signature update.
+type ScanFilesParams struct {
+	FileIDs  []uint   `json:"file_ids,omitempty"`
+	UserIDs  []uint   `json:"user_ids,omitempty"`
+	Statuses []string `json:"statuses,omitempty"`
+}
+
+// ScanService scans uploads for malware in the background. Infected files
+// are moved to a quarantine directory and can't be downloaded; a later
+// clean scan moves them back. Admins can quarantine files the same way.
+type ScanService struct {
+	fileRepo   *repository.FileRepository
+	jobs       *JobService
+	blobs      *BlobStore
+	events     *events.Bus
+	scanner    scan.Scanner
+	uploadPath string
+}
+
+func NewScanService(fileRepo *repository.FileRepository, jobs *JobService, blobs *BlobStore, bus *events.Bus, scanner scan.Scanner, cfg *config.Config) *ScanService {
+	s := &ScanService{fileRepo: fileRepo, jobs: jobs, blobs: blobs, events: bus, scanner: scanner, uploadPath: cfg.UploadPath}
+	jobs.Register(JobScanFiles, s.step)
+	if scanner != nil {
+		bus.Subscribe(events.FileCreated, s.onFileCreated)
+		bus.Subscribe(events.FileReplaced, s.onFileCreated)
+	}
+	return s
+}
+
+// Enabled reports whether a scanner is configured
+func (s *ScanService) Enabled() bool {
+	return s.scanner != nil
+}
+
+// Rescan marks a file of userID as pending and queues a scan
+func (s *ScanService) Rescan(ctx context.Context, fileID, userID uint) (*model.File, error) {
+	if !s.Enabled() {
+		return nil, ErrScanningDisabled
+	}
+
+	file, err := s.fileRepo.FindByIDAndUser(ctx, fileID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if file.ScanStatus == model.ScanQuarantined {
+		return nil, ErrFileQuarantined
+	}
+
+	if err := s.markPending(ctx, file); err != nil {
+		return nil, err
+	}
+	if _, err := s.jobs.Enqueue(ctx, JobScanFiles, ScanFilesParams{FileIDs: []uint{file.ID}}, userID); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// StartRescan queues a job scanning the selected files again
+func (s *ScanService) StartRescan(ctx context.Context, params ScanFilesParams, createdBy uint) (*model.Job, error) {
+	if !s.Enabled() {
+		return nil, ErrScanningDisabled
+	}
+	return s.jobs.Enqueue(ctx, JobScanFiles, params, createdBy)
+}
+
+// CheckDownload refuses infected and quarantined files
+func CheckDownload(file *model.File) error {
+	switch file.ScanStatus {
+	case model.ScanInfected:
+		return ErrFileInfected
+	case model.ScanQuarantined:
+		return ErrFileQuarantined
+	}
+	return nil
+}
+
+// Quarantine blocks a file on an admin's behalf, e.g. for abuse or a DMCA
+// request. Scans leave it alone until Release.
+func (s *ScanService) Quarantine(ctx context.Context, file *model.File, reason string) error {
+	if file.ScanStatus == model.ScanQuarantined {
+		return nil
+	}
+	return s.setPlacement(ctx, file, true, model.ScanQuarantined, reason)
+}
+
+// Release lifts an admin quarantine and queues a new scan when scanning is
+// enabled
+func (s *ScanService) Release(ctx context.Context, file *model.File) error {
+	if file.ScanStatus != model.ScanQuarantined {
+		return ErrFileNotQuarantined
+	}
+	status := model.ScanUnscanned
+	if s.Enabled() {
+		status = model.ScanPending
+	}
+	if err := s.setPlacement(ctx, file, false, status, ""); err != nil {
+		return err
+	}
+	if s.Enabled() {
+		if _, err := s.jobs.Enqueue(ctx, JobScanFiles, ScanFilesParams{FileIDs: []uint{file.ID}}, file.UserID); err != nil {
+			log.Printf("[WARN] Failed to queue scan of file %d: %v", file.ID, err)
+		}
+	}
+	return nil
+}
+
+func (s *ScanService) onFileCreated(event events.Event) {
+	file := event.File
+	if err := s.markPending(context.Background(), file); err != nil {
+		log.Printf("[WARN] Failed to mark file %d for scanning: %v", file.ID, err)
+		return
+	}
+	if _, err := s.jobs.Enqueue(context.Background(), JobScanFiles, ScanFilesParams{FileIDs: []uint{file.ID}}, file.UserID); err != nil {
+		log.Printf("[WARN] Failed to queue scan of file %d: %v", file.ID, err)
+	}
+}
+
+func (s *ScanService) markPending(ctx context.Context, file *model.File) error {
+	file.ScanStatus = model.ScanPending
+	return s.fileRepo.UpdateFields(ctx, file.ID, map[string]interface{}{"scan_status": model.ScanPending})
+}
+
+func (s *ScanService) step(ctx context.Context, job *model.Job) (bool, error) {
+	if !s.Enabled() {
+		return false, ErrScanningDisabled
+	}
+
+	var params ScanFilesParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+
+	filter := repository.FileFilter{IDs: params.FileIDs, UserIDs: params.UserIDs, ScanStatuses: params.Statuses}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		if err := s.Scan(ctx, &files[i]); err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			log.Printf("[WARN] Failed to scan file %d: %v", files[i].ID, err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = files[i].ID
+	}
+
+	return len(files) < jobBatchSize, nil
+}
+
+// Scan checks a file and records the verdict, quarantining infected files
+func (s *ScanService) Scan(ctx context.Context, file *model.File) error {
+	if file.ScanStatus == model.ScanQuarantined {
+		return nil
+	}
+
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		return err
+	}
+	content, err := OpenFileContent(file)
+	if err != nil {
+		return err
+	}
+	result, err := s.scanner.Scan(ctx, content)
+	content.Close()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	file.ScannedAt = &now
+	if result.Infected {
+		log.Printf("[WARN] File %d of user %d is infected with %s and was quarantined", file.ID, file.UserID, result.Signature)
+		return s.setPlacement(ctx, file, true, model.ScanInfected, result.Signature)
+	}
+	return s.setPlacement(ctx, file, false, model.ScanClean, "")
+}
+
+// setPlacement records a scan status and moves the file into quarantine, or
+// out of it
+func (s *ScanService) setPlacement(ctx context.Context, file *model.File, quarantine bool, status, signature string) error {
+	// A blob shared with other files stays where they expect it
+	previousPath, filePath := file.FilePath, file.FilePath
+	others, err := s.fileRepo.CountOthersByFilePath(ctx, file.FilePath, file.ID)
+	if err != nil {
+		return err
+	}
+	if others == 0 {
+		// Uploads of the same content stop taking the stored file as it moves
+		if err := s.fileRepo.DetachBlob(ctx, file); err != nil {
+			return err
+		}
+		if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+			return err
+		}
+		if filePath, err = s.placeFile(file.FilePath, quarantine); err != nil {
+			return err
+		}
+		// The stored copy moves too, or it would still be served at its old path
+		if err := s.blobs.Move(ctx, previousPath, filePath); err != nil {
+			os.Rename(filePath, previousPath)
+			return err
+		}
+	}
+
+	file.FilePath = filePath
+	file.ScanStatus, file.ScanSignature = status, signature
+	if err := s.fileRepo.UpdateFields(ctx, file.ID, map[string]interface{}{
+		"file_path":      file.FilePath,
+		"scan_status":    file.ScanStatus,
+		"scan_signature": file.ScanSignature,
+		"scanned_at":     file.ScannedAt,
+	}); err != nil {
+		// Put the file back where the record points
+		if filePath != previousPath {
+			os.Rename(filePath, previousPath)
+			s.blobs.Move(ctx, filePath, previousPath)
+			file.FilePath = previousPath
+		}
+		return err
+	}
+	if filePath != previousPath {
+		s.events.Publish(events.NewFileUpdated(file, previousPath))
+	}
+	return nil
+}
+
+// placeFile moves a file into quarantine, or out of it, and returns its new
+// path
+func (s *ScanService) placeFile(filePath string, quarantine bool) (string, error) {
+	rel, err := filepath.Rel(s.uploadPath, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		// Files outside UPLOAD_PATH, e.g. during a storage migration, stay put
+		return filePath, nil
+	}
+
+	quarantined, inQuarantine := strings.CutPrefix(rel, quarantineDir+string(filepath.Separator))
+	var target string
+	switch {
+	case quarantine && !inQuarantine:
+		target = filepath.Join(s.uploadPath, quarantineDir, rel)
+	case !quarantine && inQuarantine:
+		target = filepath.Join(s.uploadPath, quarantined)
+	default:
+		return filePath, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(filePath, target); err != nil {
+		return "", fmt.Errorf("failed to move file: %w", err)
+	}
+	return target, nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"log"
+	"mime/multipart"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"time"
+)
+
+// ScratchUsage is how much of the scratch quota a user takes
+type ScratchUsage struct {
+	Used  int64 `json:"used"`
+	Quota int64 `json:"quota"`
+}
+
+// ScratchService stores short-lived uploads such as CI artifacts and debug
+// dumps. Scratch files are regular files with an expiry: they can be
+// downloaded, shared and deleted like any other, but are left out of folder
+// listings and the storage limits, and ExpireFiles deletes them once expired.
+type ScratchService struct {
+	fileRepo   *repository.FileRepository
+	files      *FileService
+	ttl        time.Duration
+	maxTTL     time.Duration
+	maxTTLSpec string // SCRATCH_MAX_TTL as configured, for error messages
+	quota      int64
+}
+
+// NewScratchService returns the service for SCRATCH_TTL, SCRATCH_MAX_TTL and
+// SCRATCH_QUOTA, parsed into ttl, maxTTL and quota
+func NewScratchService(fileRepo *repository.FileRepository, files *FileService, ttl, maxTTL time.Duration, quota int64, cfg *config.Config) *ScratchService {
+	return &ScratchService{
+		fileRepo:   fileRepo,
+		files:      files,
+		ttl:        ttl,
+		maxTTL:     maxTTL,
+		maxTTLSpec: cfg.ScratchMaxTTL,
+		quota:      quota,
+	}
+}
+
+// Upload stores a scratch file that expires after ttl, SCRATCH_TTL when
+// empty
+func (s *ScratchService) Upload(ctx context.Context, userID uint, fileHeader *multipart.FileHeader, ttl, uploadID string, source model.UploadSource) (*model.File, error) {
+	lifetime := s.ttl
+	if ttl != "" {
+		var err error
+		lifetime, err = ParseExpireAfter(ttl)
+		if err != nil || lifetime > s.maxTTL {
+			return nil, ErrInvalidScratchTTL.WithArgs(ttl, s.maxTTLSpec)
+		}
+	}
+
+	used, err := s.fileRepo.GetScratchSizeByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if used+fileHeader.Size > s.quota {
+		return nil, ErrScratchQuotaExceeded.WithArgs(formatByteSize(s.quota))
+	}
+
+	expiresAt := time.Now().Add(lifetime)
+	return s.files.UploadFileWithOptions(ctx, userID, fileHeader, UploadOptions{UploadID: uploadID, ExpiresAt: &expiresAt, Source: source})
+}
+
+// List returns a page of the user's scratch files, newest first
+func (s *ScratchService) List(ctx context.Context, userID uint, page, pageSize int) ([]model.File, int64, error) {
+	filter := repository.ListFilter{Scratch: true}
+	files, err := s.fileRepo.FindByUserIDAndFolderTree(ctx, userID, "", filter, pageSize, (page-1)*pageSize, "created_at", "desc")
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.fileRepo.CountByUserIDAndFolderTree(ctx, userID, "", filter)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range files {
+		s.files.generateFileURL(&files[i])
+	}
+	return files, total, nil
+}
+
+// Usage returns how much of the scratch quota a user takes
+func (s *ScratchService) Usage(ctx context.Context, userID uint) (*ScratchUsage, error) {
+	used, err := s.fileRepo.GetScratchSizeByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &ScratchUsage{Used: used, Quota: s.quota}, nil
+}
+
+// ExpireFiles deletes expired scratch files for good. Pinned files are
+// kept; files that fail to delete are skipped and retried on the next run.
+func (s *ScratchService) ExpireFiles(ctx context.Context) error {
+	now := time.Now()
+	var cursor uint
+	for ctx.Err() == nil {
+		files, err := s.fileRepo.FindExpiredScratch(ctx, now, cursor, jobBatchSize)
+		if err != nil {
+			return err
+		}
+		for i := range files {
+			if err := s.files.deleteFile(ctx, &files[i]); err != nil {
+				log.Printf("[WARN] Failed to delete expired scratch file %d: %v", files[i].ID, err)
+			}
+			cursor = files[i].ID
+		}
+		if len(files) < jobBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"storage-service/internal/config"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// sessionTouchInterval limits how often last_seen_at is written for a session
+const sessionTouchInterval = time.Minute
+
+// SessionTokens are handed to a client when it logs in or refreshes
+type SessionTokens struct {
+	SessionID    uint   `json:"session_id"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// SessionService issues and verifies login sessions. Access tokens are
+// signed and short-lived; refresh tokens are stored hashed and rotate on
+// every use. Deleting a session revokes both immediately.
+type SessionService struct {
+	sessionRepo      *repository.SessionRepository
+	userRepo         *repository.UserRepository
+	audit            *AuditService
+	secret           []byte
+	accessTTL        time.Duration
+	sessionTTL       time.Duration
+	impersonationTTL time.Duration
+}
+
+func NewSessionService(sessionRepo *repository.SessionRepository, userRepo *repository.UserRepository, audit *AuditService, cfg *config.Config) *SessionService {
+	return &SessionService{
+		sessionRepo:      sessionRepo,
+		userRepo:         userRepo,
+		audit:            audit,
+		secret:           []byte(cfg.AppSecret),
+		accessTTL:        cfg.AccessTokenTTL,
+		sessionTTL:       cfg.SessionTTL,
+		impersonationTTL: cfg.ImpersonationTTL,
+	}
+}
+
+// Create starts a session for a user who just logged in
+func (s *SessionService) Create(ctx context.Context, user *model.User, device, ip string) (*SessionTokens, error) {
+	refreshToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &model.Session{
+		UserID:      user.ID,
+		RefreshHash: hashToken(refreshToken),
+		Device:      truncate(device, 255),
+		IP:          ip,
+		LastSeenAt:  now,
+		ExpiresAt:   now.Add(s.sessionTTL),
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return s.tokens(session, refreshToken, s.accessTTL), nil
+}
+
+// Impersonate opens a session in which an admin acts as another user for
+// support. It lasts IMPERSONATION_TTL, cannot be refreshed, is recorded in
+// the user's activity feed and shows up in their session list.
+func (s *SessionService) Impersonate(ctx context.Context, adminID, userID uint, reason, ip string) (*SessionTokens, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.ID == adminID || user.IsAdmin {
+		return nil, ErrCannotImpersonate
+	}
+
+	// Impersonation sessions have no usable refresh token
+	unused, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session := &model.Session{
+		UserID:         user.ID,
+		RefreshHash:    hashToken(unused),
+		Device:         fmt.Sprintf("Impersonation by admin #%d", adminID),
+		IP:             ip,
+		LastSeenAt:     now,
+		ExpiresAt:      now.Add(s.impersonationTTL),
+		ImpersonatorID: &adminID,
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	s.audit.Record(ctx, user.ID, adminID, model.AuditImpersonationStarted, ip, map[string]interface{}{
+		"session_id": session.ID,
+		"reason":     reason,
+		"expires_at": session.ExpiresAt,
+	})
+	log.Printf("[WARN] Admin %d is impersonating user %d until %s: %s", adminID, user.ID, session.ExpiresAt.Format(time.RFC3339), reason)
+
+	return s.tokens(session, "", s.impersonationTTL), nil
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token.
+// The old refresh token stops working and the session is extended.
+func (s *SessionService) Refresh(ctx context.Context, refreshToken, ip string) (*SessionTokens, error) {
+	oldHash := hashToken(refreshToken)
+	session, err := s.sessionRepo.FindByRefreshHash(ctx, oldHash)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	if session.ImpersonatorID != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	if time.Now().After(session.ExpiresAt) {
+		if err := s.sessionRepo.Delete(ctx, session); err != nil {
+			log.Printf("[WARN] Failed to delete expired session %d: %v", session.ID, err)
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	newToken, err := newRefreshToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	session.RefreshHash = hashToken(newToken)
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(s.sessionTTL)
+	session.IP = ip
+	rotated, err := s.sessionRepo.Rotate(ctx, session, oldHash)
+	if err != nil {
+		return nil, err
+	}
+	if !rotated {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.tokens(session, newToken, s.accessTTL), nil
+}
+
+// Authenticate verifies an access token and returns its session and user.
+// Activity is recorded at most once per sessionTouchInterval.
+func (s *SessionService) Authenticate(ctx context.Context, accessToken, ip string) (*model.Session, *model.User, error) {
+	sessionID, ok := s.verifyAccessToken(accessToken)
+	if !ok {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	session, err := s.sessionRepo.FindByID(ctx, sessionID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if time.Now().After(session.ExpiresAt) {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	user, err := s.userRepo.FindByID(ctx, session.UserID)
+	if err != nil {
+		return nil, nil, ErrInvalidAccessToken
+	}
+
+	if time.Since(session.LastSeenAt) > sessionTouchInterval || session.IP != ip {
+		if err := s.sessionRepo.Touch(ctx, session.ID, time.Now(), ip); err != nil {
+			log.Printf("[WARN] Failed to record activity of session %d: %v", session.ID, err)
+		}
+	}
+	return session, user, nil
+}
+
+// ListSessions returns the active sessions of a user, marking the one the
+// request was made with
+func (s *SessionService) ListSessions(ctx context.Context, userID, currentID uint) ([]model.Session, error) {
+	sessions, err := s.sessionRepo.FindActiveByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+	return sessions, nil
+}
+
+// Revoke ends one session of a user
+func (s *SessionService) Revoke(ctx context.Context, userID, sessionID uint) error {
+	session, err := s.sessionRepo.FindByIDAndUser(ctx, sessionID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrSessionNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.sessionRepo.Delete(ctx, session)
+}
+
+// RevokeAll ends every session of a user
+func (s *SessionService) RevokeAll(ctx context.Context, userID uint) (int64, error) {
+	return s.sessionRepo.DeleteByUserID(ctx, userID)
+}
+
+// PruneSessions deletes expired sessions
+func (s *SessionService) PruneSessions(ctx context.Context) error {
+	removed, err := s.sessionRepo.DeleteExpired(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		log.Printf("Pruned %d expired sessions", removed)
+	}
+	return nil
+}
+
+func (s *SessionService) tokens(session *model.Session, refreshToken string, ttl time.Duration) *SessionTokens {
+	return &SessionTokens{
+		SessionID:    session.ID,
+		AccessToken:  s.signAccessToken(session.ID, time.Now().Add(ttl)),
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(ttl.Seconds()),
+	}
+}
+
+// signAccessToken returns "<session id>.<unix expiry>.<hmac>"
+func (s *SessionService) signAccessToken(sessionID uint, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d.%d", sessionID, expiresAt.Unix())
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "session\n%s", payload)
+	return payload + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *SessionService) verifyAccessToken(token string) (uint, bool) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return 0, false
+	}
+	sessionID, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, false
+	}
+	unix, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return 0, false
+	}
+	expected := s.signAccessToken(uint(sessionID), time.Unix(unix, 0))
+	if !hmac.Equal([]byte(token), []byte(expected)) {
+		return 0, false
+	}
+	return uint(sessionID), true
+}
+
+func newRefreshToken() (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func truncate(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	// Cut on a rune boundary
+	return strings.ToValidUTF8(value[:limit], "")
+}
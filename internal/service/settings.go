@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"storage-service/internal/config"
+	"storage-service/internal/detect"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// settingsRefreshInterval is how long an instance keeps using the settings
+// it loaded, so changes made through another instance apply within it
+const settingsRefreshInterval = 30 * time.Second
+
+// RuntimeSettings are the settings admins can change without a restart, see
+// SettingsService
+type RuntimeSettings struct {
+	// DefaultPlan is the plan new users start on, setting their quotas
+	DefaultPlan           string
+	SizeLimits            SizeLimits
+	PixelLimit            pixelLimit
+	AnonymousAllowedTypes map[string]bool
+	FilenamePolicy        FilenamePolicy
+}
+
+// SettingInfo describes a runtime setting and where its value comes from
+type SettingInfo struct {
+	Key         string `json:"key"`
+	Env         string `json:"env"`
+	Description string `json:"description"`
+	Value       string `json:"value"`
+	// Default is the value of the environment variable, which applies until
+	// an admin changes the setting
+	Default    string     `json:"default"`
+	Overridden bool       `json:"overridden"`
+	UpdatedBy  uint       `json:"updated_by,omitempty"`
+	UpdatedAt  *time.Time `json:"updated_at,omitempty"`
+}
+
+// settingDef is a runtime setting: the environment variable it starts from
+// and how a value is validated and applied
+type settingDef struct {
+	key, env, description string
+	envValue              func(cfg *config.Config) string
+	apply                 func(s *SettingsService, settings *RuntimeSettings, value string) error
+}
+
+var settingDefs = []settingDef{
+	{
+		key: "default_plan", env: "DEFAULT_PLAN",
+		description: "Plan new users start on, which sets their quotas",
+		envValue:    func(cfg *config.Config) string { return cfg.DefaultPlan },
+		apply: func(s *SettingsService, settings *RuntimeSettings, value string) error {
+			if _, ok := s.plans[value]; !ok {
+				return fmt.Errorf("plan %q is not defined in PLANS", value)
+			}
+			if _, err := ParseBillingPrices(s.cfg.StripePrices, s.plans, value); err != nil {
+				return err
+			}
+			settings.DefaultPlan = value
+			return nil
+		},
+	},
+	{
+		key: "size_limits", env: "SIZE_LIMITS",
+		description: "Maximum upload size per content family or MIME type, e.g. image=20MB,video=2GB",
+		envValue:    func(cfg *config.Config) string { return cfg.SizeLimits },
+		apply: func(s *SettingsService, settings *RuntimeSettings, value string) error {
+			limits, err := ParseSizeLimits(value)
+			settings.SizeLimits = limits
+			return err
+		},
+	},
+	{
+		key: "max_image_pixels", env: "MAX_IMAGE_PIXELS",
+		description: "Images with more pixels are rejected before they are decoded; 0 disables the check",
+		envValue:    func(cfg *config.Config) string { return strconv.Itoa(cfg.MaxImagePixels) },
+		apply: func(s *SettingsService, settings *RuntimeSettings, value string) error {
+			pixels, err := strconv.Atoi(value)
+			if err != nil || pixels < 0 {
+				return errors.New("must be a number of pixels, 0 or more")
+			}
+			settings.PixelLimit = pixelLimit(pixels)
+			return nil
+		},
+	},
+	{
+		key: "anonymous_allowed_types", env: "ANONYMOUS_ALLOWED_TYPES",
+		description: "Comma separated MIME types accepted from anonymous uploaders",
+		envValue:    func(cfg *config.Config) string { return cfg.AnonymousAllowedTypes },
+		apply: func(s *SettingsService, settings *RuntimeSettings, value string) error {
+			settings.AnonymousAllowedTypes = map[string]bool{}
+			for _, mimeType := range strings.Split(value, ",") {
+				if mimeType = detect.Normalize(mimeType); mimeType != "" {
+					settings.AnonymousAllowedTypes[mimeType] = true
+				}
+			}
+			return nil
+		},
+	},
+	{
+		key: "strict_filename_extensions", env: "STRICT_FILENAME_EXTENSIONS",
+		description: "Check every extension of a filename, e.g. the .exe of invoice.pdf.exe",
+		envValue:    func(cfg *config.Config) string { return strconv.FormatBool(cfg.StrictFilenameExtensions) },
+		apply: func(s *SettingsService, settings *RuntimeSettings, value string) (err error) {
+			settings.FilenamePolicy.Strict, err = parseSettingBool(value)
+			return err
+		},
+	},
+	{
+		key: "flag_suspicious_names", env: "FLAG_SUSPICIOUS_NAMES",
+		description: "Flag new names that may not read as what they are, see name_warning",
+		envValue:    func(cfg *config.Config) string { return strconv.FormatBool(cfg.FlagSuspiciousNames) },
+		apply: func(s *SettingsService, settings *RuntimeSettings, value string) (err error) {
+			settings.FilenamePolicy.Flag, err = parseSettingBool(value)
+			return err
+		},
+	},
+}
+
+func parseSettingBool(value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, errors.New("must be true or false")
+	}
+	return b, nil
+}
+
+func findSettingDef(key string) (settingDef, bool) {
+	for _, def := range settingDefs {
+		if def.key == key {
+			return def, true
+		}
+	}
+	return settingDef{}, false
+}
+
+// SettingsService holds the runtime settings: tunables that start from
+// their environment variable and that admins can change at runtime. Changes
+// are stored in the settings table, so they survive restarts and apply to
+// every instance, and recorded in the admin's audit log. Services read the
+// settings with Current on every use.
+type SettingsService struct {
+	settingRepo *repository.SettingRepository
+	audit       *AuditService
+	cfg         *config.Config
+	plans       Plans
+
+	current    atomic.Pointer[RuntimeSettings]
+	loadedAt   atomic.Int64
+	refreshing atomic.Bool
+}
+
+// NewSettingsService returns the service; plans are PLANS, see ParsePlans
+func NewSettingsService(settingRepo *repository.SettingRepository, audit *AuditService, plans Plans, cfg *config.Config) *SettingsService {
+	s := &SettingsService{settingRepo: settingRepo, audit: audit, cfg: cfg, plans: plans}
+	settings, _ := s.build(nil, nil)
+	s.current.Store(settings)
+	return s
+}
+
+// Current returns the settings in effect. Once they are older than
+// settingsRefreshInterval they are reloaded in the background.
+func (s *SettingsService) Current() *RuntimeSettings {
+	if time.Since(time.Unix(0, s.loadedAt.Load())) > settingsRefreshInterval && s.refreshing.CompareAndSwap(false, true) {
+		s.loadedAt.Store(time.Now().UnixNano())
+		go func() {
+			defer s.refreshing.Store(false)
+			if err := s.Load(context.Background()); err != nil {
+				log.Printf("[WARN] Failed to reload settings: %v", err)
+			}
+		}()
+	}
+	return s.current.Load()
+}
+
+// Load reads the settings changed by admins. Stored values that are no
+// longer valid, e.g. a plan since removed from PLANS, are logged and the
+// environment variable applies instead.
+func (s *SettingsService) Load(ctx context.Context) error {
+	stored, err := s.settingRepo.FindAll(ctx)
+	if err != nil {
+		return err
+	}
+	s.loadedAt.Store(time.Now().UnixNano())
+	settings, _ := s.build(stored, nil)
+	s.current.Store(settings)
+	return nil
+}
+
+// build applies the environment, then stored values and then changes.
+// Invalid stored values are skipped; an invalid change fails the build.
+func (s *SettingsService) build(stored []model.Setting, changes map[string]*string) (*RuntimeSettings, error) {
+	values := s.values(stored)
+	settings := &RuntimeSettings{FilenamePolicy: FilenamePolicy{Normalize: s.cfg.NormalizeFilenames}}
+	for _, def := range settingDefs {
+		// Environment variables were validated at startup
+		def.apply(s, settings, def.envValue(s.cfg))
+		if change, ok := changes[def.key]; ok {
+			if change == nil {
+				continue
+			}
+			if err := def.apply(s, settings, strings.TrimSpace(*change)); err != nil {
+				return nil, ErrInvalidSetting.WithArgs(def.key, err.Error())
+			}
+			continue
+		}
+		if value, ok := values[def.key]; ok {
+			if err := def.apply(s, settings, value.Value); err != nil {
+				log.Printf("[WARN] Ignoring invalid setting %s=%q: %v", def.key, value.Value, err)
+				def.apply(s, settings, def.envValue(s.cfg))
+			}
+		}
+	}
+	return settings, nil
+}
+
+func (s *SettingsService) values(stored []model.Setting) map[string]model.Setting {
+	values := make(map[string]model.Setting, len(stored))
+	for _, setting := range stored {
+		values[setting.Key] = setting
+	}
+	return values
+}
+
+// List describes every runtime setting with its current value
+func (s *SettingsService) List(ctx context.Context) ([]SettingInfo, error) {
+	stored, err := s.settingRepo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	values := s.values(stored)
+
+	infos := make([]SettingInfo, 0, len(settingDefs))
+	for _, def := range settingDefs {
+		info := SettingInfo{Key: def.key, Env: def.env, Description: def.description, Default: def.envValue(s.cfg)}
+		info.Value = info.Default
+		if value, ok := values[def.key]; ok {
+			updatedAt := value.UpdatedAt
+			info.Value, info.Overridden, info.UpdatedBy, info.UpdatedAt = value.Value, true, value.UpdatedBy, &updatedAt
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Update changes settings by key; a nil value resets a setting to its
+// environment variable. Either every change is valid and applied or none
+// is. The changes apply to this instance at once and to the others within
+// settingsRefreshInterval.
+func (s *SettingsService) Update(ctx context.Context, changes map[string]*string, adminID uint, ip string) ([]SettingInfo, error) {
+	for key := range changes {
+		if _, ok := findSettingDef(key); !ok {
+			return nil, ErrUnknownSetting.WithArgs(key)
+		}
+	}
+	before, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var settings *RuntimeSettings
+	err = s.settingRepo.WithTx(ctx, func(ctx context.Context) error {
+		for key, value := range changes {
+			if value == nil {
+				if err := s.settingRepo.Delete(ctx, key); err != nil {
+					return err
+				}
+				continue
+			}
+			setting := &model.Setting{Key: key, Value: strings.TrimSpace(*value), UpdatedBy: adminID}
+			if err := s.settingRepo.Save(ctx, setting); err != nil {
+				return err
+			}
+		}
+		stored, err := s.settingRepo.FindAll(ctx)
+		if err != nil {
+			return err
+		}
+		settings, err = s.build(stored, changes)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.current.Store(settings)
+	s.loadedAt.Store(time.Now().UnixNano())
+
+	after, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i, info := range after {
+		if _, changed := changes[info.Key]; !changed || info.Value == before[i].Value {
+			continue
+		}
+		s.audit.Record(ctx, adminID, adminID, model.AuditSettingChanged, ip, map[string]interface{}{
+			"key": info.Key, "old": before[i].Value, "new": info.Value,
+		})
+	}
+	return after, nil
+}
+
+// filenamePolicy returns the filename checks uploads and renames apply
+func (s *FileService) filenamePolicy() FilenamePolicy {
+	return s.settings.Current().FilenamePolicy
+}
+
+func (s *FileService) sizeLimits() SizeLimits {
+	return s.settings.Current().SizeLimits
+}
+
+func (s *ImageService) filenamePolicy() FilenamePolicy {
+	return s.settings.Current().FilenamePolicy
+}
+
+func (s *ImageService) sizeLimits() SizeLimits {
+	return s.settings.Current().SizeLimits
+}
+
+func (s *ImageService) pixelLimit() pixelLimit {
+	return s.settings.Current().PixelLimit
+}
+
+// defaultPlan returns the name of the plan new users start on
+func (s *UserService) defaultPlan() string {
+	return s.settings.Current().DefaultPlan
+}
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SharePreviewVariant is the variant shown on share landing pages
+const SharePreviewVariant = "thumb"
+
+// ShareService creates public links to files and resolves them for
+// anonymous visitors
+type ShareService struct {
+	shareRepo   *repository.ShareRepository
+	fileService *FileService
+	variants    *VariantService
+	storageURL  string
+}
+
+func NewShareService(shareRepo *repository.ShareRepository, fileService *FileService, variants *VariantService, bus *events.Bus, cfg *config.Config) *ShareService {
+	s := &ShareService{
+		shareRepo:   shareRepo,
+		fileService: fileService,
+		variants:    variants,
+		storageURL:  cfg.StorageURL,
+	}
+	bus.Subscribe(events.FileDeleted, s.onFileDeleted)
+	// Links handed out by the previous owner stop working
+	bus.Subscribe(events.FileTransferred, s.onFileDeleted)
+	return s
+}
+
+// CreateShare creates a link to a file owned by userID. expiresIn is empty
+// for links that never expire, otherwise a duration such as "7d" or "12h".
+func (s *ShareService) CreateShare(ctx context.Context, fileID, userID uint, expiresIn string) (*model.Share, error) {
+	if _, err := s.fileService.findFile(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
+	share := &model.Share{FileID: fileID, UserID: userID}
+	if expiresIn != "" {
+		ttl, err := ParseExpireAfter(expiresIn)
+		if err != nil {
+			return nil, ErrInvalidShareExpiry.WithArgs(expiresIn)
+		}
+		expiresAt := time.Now().Add(ttl)
+		share.ExpiresAt = &expiresAt
+	}
+
+	token := make([]byte, 18)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	share.Token = base64.RawURLEncoding.EncodeToString(token)
+
+	if err := s.shareRepo.Create(ctx, share); err != nil {
+		return nil, fmt.Errorf("failed to create share: %w", err)
+	}
+	s.generateURL(share)
+	return share, nil
+}
+
+func (s *ShareService) ListShares(ctx context.Context, fileID, userID uint) ([]model.Share, error) {
+	if _, err := s.fileService.findFile(ctx, fileID, userID); err != nil {
+		return nil, err
+	}
+
+	shares, err := s.shareRepo.FindByFileID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range shares {
+		s.generateURL(&shares[i])
+	}
+	return shares, nil
+}
+
+func (s *ShareService) DeleteShare(ctx context.Context, shareID, userID uint) error {
+	share, err := s.shareRepo.FindByIDAndUser(ctx, shareID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrShareNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return s.shareRepo.Delete(ctx, share)
+}
+
+// Resolve returns a valid share and its file with URLs filled in
+func (s *ShareService) Resolve(ctx context.Context, token string) (*model.Share, *model.File, error) {
+	share, err := s.shareRepo.FindByToken(ctx, token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if share.ExpiresAt != nil && time.Now().After(*share.ExpiresAt) {
+		return nil, nil, ErrShareExpired
+	}
+
+	file, err := s.fileService.getFile(ctx, share.FileID)
+	if errors.Is(err, ErrFileNotFound) {
+		return nil, nil, ErrShareNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	s.generateURL(share)
+	return share, file, nil
+}
+
+// Preview returns the image shown for a shared file: its thumbnail, or the
+// image itself when it has none. ok is false for files without a preview.
+func (s *ShareService) Preview(ctx context.Context, file *model.File) (filePath, mimeType string, ok bool) {
+	variants, err := s.variants.GetVariants(ctx, file.ID)
+	if err != nil {
+		log.Printf("[WARN] Failed to load variants of file %d: %v", file.ID, err)
+	}
+	for _, variant := range variants {
+		if variant.Name == SharePreviewVariant {
+			if err := s.fileService.blobs.Fetch(ctx, variant.FilePath); err != nil {
+				log.Printf("[WARN] %v", err)
+			}
+			return variant.FilePath, variant.MimeType, true
+		}
+	}
+	if variantSourceTypes[file.MimeType] {
+		path, err := s.fileService.Locate(ctx, file)
+		if err != nil {
+			log.Printf("[WARN] Failed to locate file %d: %v", file.ID, err)
+			return "", "", false
+		}
+		return path, file.MimeType, true
+	}
+	return "", "", false
+}
+
+// Locate returns the path to read a shared file from
+func (s *ShareService) Locate(ctx context.Context, file *model.File) (string, error) {
+	return s.fileService.Locate(ctx, file)
+}
+
+// RecordDownload counts a download through a share
+func (s *ShareService) RecordDownload(ctx context.Context, share *model.Share) {
+	if err := s.shareRepo.IncrementDownloads(ctx, share.ID); err != nil {
+		log.Printf("[WARN] Failed to count download of share %d: %v", share.ID, err)
+	}
+}
+
+func (s *ShareService) onFileDeleted(event events.Event) {
+	if err := s.shareRepo.DeleteByFileID(context.Background(), event.File.ID); err != nil {
+		log.Printf("[WARN] Failed to delete shares of file %d: %v", event.File.ID, err)
+	}
+}
+
+func (s *ShareService) generateURL(share *model.Share) {
+	share.URL = fmt.Sprintf("%s/s/%s", strings.TrimSuffix(s.storageURL, "/"), share.Token)
+}
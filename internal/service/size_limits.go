@@ -0,0 +1,107 @@
+package service
+
+import (
+	"fmt"
+	"storage-service/internal/detect"
+	"strconv"
+	"strings"
+)
+
+// SizeLimits maps a content family ("image", "video", ...) or an exact MIME
+// type to the maximum upload size in bytes. Exact types win over families.
+type SizeLimits map[string]int64
+
+// ParseSizeLimits parses a spec such as "image=20MB,video=2GB,application/pdf=50MB"
+func ParseSizeLimits(spec string) (SizeLimits, error) {
+	limits := SizeLimits{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		key, value, ok := strings.Cut(entry, "=")
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid size limit %q, expected type=size", entry)
+		}
+		if !strings.Contains(key, "/") && !isFamily(key) {
+			return nil, fmt.Errorf("unknown content family %q in size limit", key)
+		}
+
+		size, err := ParseByteSize(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size limit %q: %w", entry, err)
+		}
+		limits[key] = size
+	}
+	return limits, nil
+}
+
+// MaxSize returns the limit that applies to mimeType and the key it was found under
+func (l SizeLimits) MaxSize(mimeType string) (string, int64, bool) {
+	mimeType = detect.Normalize(mimeType)
+	if size, ok := l[mimeType]; ok {
+		return mimeType, size, true
+	}
+	family := detect.Family(mimeType)
+	size, ok := l[family]
+	return family, size, ok
+}
+
+// Check returns ErrTypeSizeLimit when size exceeds the limit for mimeType
+func (l SizeLimits) Check(mimeType string, size int64) error {
+	key, max, ok := l.MaxSize(mimeType)
+	if ok && size > max {
+		return ErrTypeSizeLimit.WithArgs(key, formatByteSize(max))
+	}
+	return nil
+}
+
+func isFamily(name string) bool {
+	for _, family := range detect.Families {
+		if family == name {
+			return true
+		}
+	}
+	return false
+}
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"TB", 1 << 40},
+	{"GB", 1 << 30},
+	{"MB", 1 << 20},
+	{"KB", 1 << 10},
+	{"B", 1},
+}
+
+// ParseByteSize parses plain byte counts or sizes with a KB/MB/GB/TB suffix
+func ParseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range byteUnits {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("size must be a positive number")
+	}
+	return n * multiplier, nil
+}
+
+func formatByteSize(size int64) string {
+	for _, unit := range byteUnits {
+		if size >= unit.size && size%unit.size == 0 {
+			return fmt.Sprintf("%d%s", size/unit.size, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", size)
+}
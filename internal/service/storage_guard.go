@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"math/rand/v2"
+	"os"
+	"storage-service/internal/apperror"
+	"storage-service/internal/config"
+	"storage-service/internal/metrics"
+	"sync"
+	"time"
+)
+
+// Circuit breaker states reported in metrics.Storage
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// StorageGuard shields requests from a storage volume that fails
+// intermittently, like a network mount. Idempotent operations are retried with
+// jittered backoff. After STORAGE_BREAKER_THRESHOLD consecutive failures the
+// breaker opens and operations fail fast with ErrStorageUnavailable; after
+// STORAGE_BREAKER_COOLDOWN a single operation is let through, and its success
+// closes the breaker again.
+type StorageGuard struct {
+	retries   int
+	delay     time.Duration
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+func NewStorageGuard(cfg *config.Config) *StorageGuard {
+	g := &StorageGuard{
+		retries:   cfg.StorageRetries,
+		delay:     cfg.StorageRetryDelay,
+		threshold: cfg.StorageBreakerThreshold,
+		cooldown:  cfg.StorageBreakerCooldown,
+	}
+	metrics.Storage.Set("breaker_state", expvar.Func(func() any { return g.State() }))
+	return g
+}
+
+// Retry runs an operation that is safe to repeat, retrying backend failures
+func (g *StorageGuard) Retry(ctx context.Context, op string, fn func() error) error {
+	var err error
+	for attempt := 0; ; attempt++ {
+		err = g.Run(ctx, op, fn)
+		if attempt >= g.retries || !isBackendFailure(err) {
+			return err
+		}
+		metrics.Storage.Add("retries", 1)
+
+		// Full jitter keeps instances from retrying in lockstep
+		backoff := time.Duration(rand.Int64N(int64(g.delay<<attempt) + 1))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// Run runs an operation once, failing fast while the breaker is open. Use it
+// for operations that must not be repeated, like moving a file into place.
+func (g *StorageGuard) Run(ctx context.Context, op string, fn func() error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !g.allow() {
+		metrics.Storage.Add("fast_failed", 1)
+		return ErrStorageUnavailable
+	}
+	err := fn()
+	g.record(op, isBackendFailure(err))
+	return err
+}
+
+// State returns the breaker state: closed, open or half-open
+func (g *StorageGuard) State() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	switch {
+	case g.openUntil.IsZero():
+		return breakerClosed
+	case g.probing || !time.Now().Before(g.openUntil):
+		return breakerHalfOpen
+	}
+	return breakerOpen
+}
+
+func (g *StorageGuard) allow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.openUntil.IsZero() {
+		return true
+	}
+	if g.probing || time.Now().Before(g.openUntil) {
+		return false
+	}
+	g.probing = true
+	return true
+}
+
+func (g *StorageGuard) record(op string, failed bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	wasOpen := !g.openUntil.IsZero()
+	g.probing = false
+	if !failed {
+		g.failures = 0
+		g.openUntil = time.Time{}
+		if wasOpen {
+			log.Print("Storage recovered, closing the circuit breaker")
+		}
+		return
+	}
+
+	metrics.Storage.Add("failures", 1)
+	g.failures++
+	if g.threshold <= 0 || (g.failures < g.threshold && !wasOpen) {
+		return
+	}
+	if !wasOpen {
+		metrics.Storage.Add("breaker_trips", 1)
+		log.Printf("[WARN] Storage failed %d times in a row (last: %s), opening the circuit breaker for %s", g.failures, op, g.cooldown)
+	}
+	g.openUntil = time.Now().Add(g.cooldown)
+}
+
+// isBackendFailure tells failures of the storage itself from expected
+// outcomes like a missing file, which say nothing about its health
+func isBackendFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	var appErr *apperror.Error
+	return !errors.Is(err, os.ErrNotExist) &&
+		!errors.Is(err, os.ErrExist) &&
+		!errors.Is(err, os.ErrPermission) &&
+		!errors.Is(err, context.Canceled) &&
+		!errors.Is(err, context.DeadlineExceeded) &&
+		!errors.As(err, &appErr)
+}
@@ -0,0 +1,281 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+)
+
+// JobMigrateStorage moves files from PREVIOUS_UPLOAD_PATH into UPLOAD_PATH
+const JobMigrateStorage = "migrate_storage"
+
+// StorageMigrationParams configures a storage migration. KeepSource leaves
+// the original files in place after they were copied.
+type StorageMigrationParams struct {
+	KeepSource bool `json:"keep_source"`
+}
+
+// StorageMigrationService moves stored files to a new volume while the
+// service keeps running. Each file, its variants and its earlier versions
+// are copied, verified by checksum and then switched over in the database, so
+// a file is always readable from one of the two locations.
+type StorageMigrationService struct {
+	fileRepo    *repository.FileRepository
+	variantRepo *repository.VariantRepository
+	versionRepo *repository.VersionRepository
+	jobs        *JobService
+	events      *events.Bus
+	uploadPath  string
+	sourcePath  string
+	temp        *TempStore
+}
+
+func NewStorageMigrationService(fileRepo *repository.FileRepository, variantRepo *repository.VariantRepository, versionRepo *repository.VersionRepository, jobs *JobService, bus *events.Bus, cfg *config.Config) *StorageMigrationService {
+	s := &StorageMigrationService{
+		fileRepo:    fileRepo,
+		variantRepo: variantRepo,
+		versionRepo: versionRepo,
+		jobs:        jobs,
+		events:      bus,
+		uploadPath:  cfg.UploadPath,
+		sourcePath:  cfg.PreviousUploadPath,
+		temp:        NewTempStore(cfg),
+	}
+	jobs.Register(JobMigrateStorage, s.step)
+	return s
+}
+
+// Start queues a migration of every file still stored below
+// PREVIOUS_UPLOAD_PATH. Pause and resume it like any other job.
+func (s *StorageMigrationService) Start(ctx context.Context, params StorageMigrationParams, createdBy uint) (*model.Job, error) {
+	if s.sourcePath == "" {
+		return nil, ErrMigrationDisabled
+	}
+	return s.jobs.Enqueue(ctx, JobMigrateStorage, params, createdBy)
+}
+
+func (s *StorageMigrationService) step(ctx context.Context, job *model.Job) (bool, error) {
+	if s.sourcePath == "" {
+		return false, ErrMigrationDisabled
+	}
+
+	var params StorageMigrationParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+
+	// Files in the trash are moved too, so they can still be restored
+	filter := repository.FileFilter{PathPrefix: s.sourcePath + string(filepath.Separator), IncludeTrashed: true}
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		if ctx.Err() != nil {
+			return false, ctx.Err()
+		}
+		if err := s.migrate(ctx, &files[i], params.KeepSource); err != nil {
+			log.Printf("[WARN] Failed to migrate file %d: %v", files[i].ID, err)
+			job.Failed++
+		}
+		job.Processed++
+		job.Cursor = files[i].ID
+	}
+
+	return len(files) < jobBatchSize, nil
+}
+
+// migrate copies a file, its variants and its versions into UPLOAD_PATH and
+// switches the records over. A file changed while it was copied is left for
+// a later run.
+func (s *StorageMigrationService) migrate(ctx context.Context, file *model.File, keepSource bool) error {
+	target, ok := s.targetPath(file.FilePath)
+	if !ok {
+		return nil
+	}
+
+	variants, err := s.variantRepo.FindByFileID(ctx, file.ID)
+	if err != nil {
+		return err
+	}
+
+	var copied []string
+	discard := func() {
+		for _, path := range copied {
+			os.RemoveAll(path)
+		}
+	}
+
+	if err := s.copyVerified(ctx, file.FilePath, target); err != nil {
+		return err
+	}
+	copied = append(copied, target)
+
+	variantTargets := make([]string, len(variants))
+	for i := range variants {
+		variantTarget, ok := s.targetPath(variants[i].FilePath)
+		if !ok {
+			continue
+		}
+		paths, err := s.copyVariant(ctx, &variants[i], variantTarget)
+		copied = append(copied, paths...)
+		if err != nil {
+			discard()
+			return fmt.Errorf("variant %s: %w", variants[i].Name, err)
+		}
+		variantTargets[i] = variantTarget
+	}
+
+	moved, err := s.fileRepo.UpdateFilePath(ctx, file.ID, file.FilePath, target)
+	if err != nil || !moved {
+		discard()
+		return err
+	}
+
+	sources := []string{file.FilePath}
+	for i := range variants {
+		if variantTargets[i] == "" {
+			continue
+		}
+		sources = append(sources, variantSource(&variants[i]))
+		variants[i].FilePath = variantTargets[i]
+		if err := s.variantRepo.Save(ctx, &variants[i]); err != nil {
+			log.Printf("[WARN] Failed to update variant %s of file %d: %v", variants[i].Name, file.ID, err)
+		}
+	}
+
+	sources = append(sources, s.migrateVersions(ctx, file.ID)...)
+
+	previousPath := file.FilePath
+	file.FilePath, file.BlobID = target, nil
+	s.events.Publish(events.NewFileUpdated(file, previousPath))
+
+	// Other files stored at the same path still read the source
+	if others, err := s.fileRepo.CountOthersByFilePath(ctx, previousPath, file.ID); err != nil || others > 0 {
+		keepSource = true
+	}
+	if !keepSource {
+		for _, source := range sources {
+			if err := os.RemoveAll(source); err != nil {
+				log.Printf("[WARN] Failed to remove migrated file %s: %v", source, err)
+			}
+		}
+	}
+	return nil
+}
+
+// migrateVersions copies the earlier versions of a file still below
+// PREVIOUS_UPLOAD_PATH and returns the sources of the ones it switched over.
+// Versions that fail stay readable from the old volume.
+func (s *StorageMigrationService) migrateVersions(ctx context.Context, fileID uint) []string {
+	versions, err := s.versionRepo.FindByFileID(ctx, fileID)
+	if err != nil {
+		log.Printf("[WARN] Failed to migrate versions of file %d: %v", fileID, err)
+		return nil
+	}
+	var sources []string
+	for i := range versions {
+		target, ok := s.targetPath(versions[i].FilePath)
+		if !ok {
+			continue
+		}
+		if err := s.copyVerified(ctx, versions[i].FilePath, target); err != nil {
+			log.Printf("[WARN] Failed to migrate version %d of file %d: %v", versions[i].Version, fileID, err)
+			continue
+		}
+		if err := s.versionRepo.UpdateFilePath(ctx, versions[i].ID, target); err != nil {
+			os.Remove(target)
+			log.Printf("[WARN] Failed to migrate version %d of file %d: %v", versions[i].Version, fileID, err)
+			continue
+		}
+		sources = append(sources, versions[i].FilePath)
+	}
+	return sources
+}
+
+// targetPath maps a path below PREVIOUS_UPLOAD_PATH to UPLOAD_PATH
+func (s *StorageMigrationService) targetPath(path string) (string, bool) {
+	rel, err := filepath.Rel(s.sourcePath, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return filepath.Join(s.uploadPath, rel), true
+}
+
+// copyVariant copies a variant file, or the whole directory of an HLS
+// stream, and returns the paths it created
+func (s *StorageMigrationService) copyVariant(ctx context.Context, variant *model.FileVariant, target string) ([]string, error) {
+	if variant.Name != VariantHLS {
+		if err := s.copyVerified(ctx, variant.FilePath, target); err != nil {
+			return nil, err
+		}
+		return []string{target}, nil
+	}
+
+	sourceDir, targetDir := filepath.Dir(variant.FilePath), filepath.Dir(target)
+	err := filepath.WalkDir(sourceDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(sourceDir, path)
+		if err != nil {
+			return err
+		}
+		return s.copyVerified(ctx, path, filepath.Join(targetDir, rel))
+	})
+	return []string{targetDir}, err
+}
+
+// variantSource returns what to remove once a variant was migrated
+func variantSource(variant *model.FileVariant) string {
+	if variant.Name == VariantHLS {
+		return filepath.Dir(variant.FilePath)
+	}
+	return variant.FilePath
+}
+
+// copyVerified copies src to dst through a temp file and checks that the
+// checksum of the copy on disk matches the source
+func (s *StorageMigrationService) copyVerified(ctx context.Context, src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := writeStored(ctx, s.temp, dst, "", io.TeeReader(f, h)); err != nil {
+		return err
+	}
+
+	sum, err := fileChecksum(dst)
+	if err != nil {
+		os.Remove(dst)
+		return err
+	}
+	if !bytes.Equal(sum[:], h.Sum(nil)) {
+		os.Remove(dst)
+		return fmt.Errorf("checksum of the copy of %s does not match", src)
+	}
+	return nil
+}
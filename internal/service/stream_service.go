@@ -0,0 +1,367 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// JobTranscodeVideos converts videos to HLS for in-browser playback
+const JobTranscodeVideos = "transcode_videos"
+
+// VariantHLS is the variant holding the HLS rendition of a video. Its path
+// is the master playlist; playlists and segments share its directory.
+const VariantHLS = "hls"
+
+const (
+	hlsMasterPlaylist = "master.m3u8"
+	hlsMediaPlaylist  = "index.m3u8"
+)
+
+// transcodableVideoTypes are the video types handed to ffmpeg
+var transcodableVideoTypes = map[string]bool{
+	"video/mp4":        true,
+	"video/quicktime":  true,
+	"video/webm":       true,
+	"video/x-matroska": true,
+	"video/x-msvideo":  true,
+}
+
+// TranscodeVideosParams selects the files a transcoding job processes.
+// Empty lists match all users and all videos.
+type TranscodeVideosParams struct {
+	FileIDs []uint `json:"file_ids"`
+	UserIDs []uint `json:"user_ids"`
+}
+
+// StreamURL is a tokenized playlist URL that players can load without an API key
+type StreamURL struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// StreamService transcodes videos to HLS with ffmpeg and serves the
+// playlists and segments to holders of a signed stream token
+type StreamService struct {
+	fileRepo        *repository.FileRepository
+	variantRepo     *repository.VariantRepository
+	variants        *VariantService
+	jobs            *JobService
+	diskGuard       *DiskGuard
+	blobs           *BlobStore
+	ffmpegPath      string
+	segmentDuration int
+	tokenTTL        time.Duration
+	storageURL      string
+	secret          []byte
+}
+
+func NewStreamService(fileRepo *repository.FileRepository, variantRepo *repository.VariantRepository, variants *VariantService, jobs *JobService, diskGuard *DiskGuard, blobs *BlobStore, bus *events.Bus, cfg *config.Config) *StreamService {
+	s := &StreamService{
+		fileRepo:        fileRepo,
+		variantRepo:     variantRepo,
+		variants:        variants,
+		jobs:            jobs,
+		diskGuard:       diskGuard,
+		blobs:           blobs,
+		ffmpegPath:      cfg.FFmpegPath,
+		segmentDuration: cfg.HLSSegmentDuration,
+		tokenTTL:        cfg.StreamTokenTTL,
+		storageURL:      cfg.StorageURL,
+		secret:          []byte(cfg.AppSecret),
+	}
+	jobs.Register(JobTranscodeVideos, s.step)
+	bus.Subscribe(events.FileCreated, func(event events.Event) { s.Queue(context.Background(), event.File) })
+	bus.Subscribe(events.FileReplaced, func(event events.Event) { s.Queue(context.Background(), event.File) })
+	return s
+}
+
+// Enabled reports whether ffmpeg is configured
+func (s *StreamService) Enabled() bool {
+	return s.ffmpegPath != ""
+}
+
+// Supports reports whether a file can be transcoded for streaming
+func (s *StreamService) Supports(mimeType string) bool {
+	return s.Enabled() && transcodableVideoTypes[mimeType]
+}
+
+// Queue schedules transcoding of a newly uploaded video
+func (s *StreamService) Queue(ctx context.Context, file *model.File) {
+	if !s.Supports(file.MimeType) {
+		return
+	}
+	params := TranscodeVideosParams{FileIDs: []uint{file.ID}}
+	if _, err := s.jobs.Enqueue(ctx, JobTranscodeVideos, params, file.UserID); err != nil {
+		log.Printf("[WARN] Failed to queue transcoding of file %d: %v", file.ID, err)
+	}
+}
+
+// StartTranscode queues a job that transcodes existing videos
+func (s *StreamService) StartTranscode(ctx context.Context, params TranscodeVideosParams, createdBy uint) (*model.Job, error) {
+	if !s.Enabled() {
+		return nil, ErrTranscodingDisabled
+	}
+	return s.jobs.Enqueue(ctx, JobTranscodeVideos, params, createdBy)
+}
+
+func (s *StreamService) step(ctx context.Context, job *model.Job) (bool, error) {
+	var params TranscodeVideosParams
+	if err := decodeJobParams(job, &params); err != nil {
+		return false, err
+	}
+
+	filter := repository.FileFilter{
+		IDs:       params.FileIDs,
+		UserIDs:   params.UserIDs,
+		MimeTypes: sortedKeys(transcodableVideoTypes),
+	}
+
+	if job.Cursor == 0 && job.Total == 0 {
+		total, err := s.fileRepo.CountMatching(ctx, filter)
+		if err != nil {
+			return false, err
+		}
+		job.Total = total
+	}
+
+	// Videos are slow to transcode, so progress is saved after each one
+	files, err := s.fileRepo.FindBatchAfter(ctx, filter, job.Cursor, 1)
+	if err != nil {
+		return false, err
+	}
+
+	for i := range files {
+		if _, err := s.Transcode(ctx, &files[i]); err != nil {
+			if ctx.Err() != nil {
+				return false, ctx.Err()
+			}
+			log.Printf("[WARN] Failed to transcode file %d: %v", files[i].ID, err)
+			job.Failed++
+		} else {
+			s.variants.publishCompleted(ctx, &files[i])
+		}
+		job.Processed++
+		job.Cursor = files[i].ID
+	}
+
+	return len(files) == 0, nil
+}
+
+// Transcode builds the HLS rendition of a video next to the original,
+// replacing an earlier one
+func (s *StreamService) Transcode(ctx context.Context, file *model.File) (*model.FileVariant, error) {
+	if !s.Supports(file.MimeType) {
+		return nil, ErrStreamNotAvailable
+	}
+
+	// Transcoded output is usually smaller than the original
+	if err := s.diskGuard.Check(file.FileSize); err != nil {
+		return nil, err
+	}
+
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		return nil, err
+	}
+
+	base := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename))
+	dir := filepath.Join(filepath.Dir(file.FilePath), base+"_"+VariantHLS)
+	tmpDir := dir + ".tmp"
+	os.RemoveAll(tmpDir)
+	if err := os.MkdirAll(tmpDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create stream directory: %w", err)
+	}
+
+	cmd := exec.CommandContext(ctx, s.ffmpegPath,
+		"-nostdin", "-y", "-loglevel", "error",
+		"-i", file.FilePath,
+		"-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p",
+		"-c:a", "aac", "-b:a", "128k",
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(s.segmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(tmpDir, "segment_%05d.ts"),
+		"-master_pl_name", hlsMasterPlaylist,
+		filepath.Join(tmpDir, hlsMediaPlaylist),
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("ffmpeg failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	size, err := directorySize(tmpDir)
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, err
+	}
+
+	os.RemoveAll(dir)
+	if err := os.Rename(tmpDir, dir); err != nil {
+		os.RemoveAll(tmpDir)
+		return nil, fmt.Errorf("failed to move stream directory: %w", err)
+	}
+
+	variant := &model.FileVariant{
+		FileID:   file.ID,
+		Name:     VariantHLS,
+		FilePath: filepath.Join(dir, hlsMasterPlaylist),
+		MimeType: "application/vnd.apple.mpegurl",
+		FileSize: size,
+
+		LastAccessedAt: time.Now(),
+	}
+	if err := s.variantRepo.Save(ctx, variant); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to save stream metadata: %w", err)
+	}
+	return variant, nil
+}
+
+// GetStreamURL returns a signed URL of the master playlist of a file owned by userID
+func (s *StreamService) GetStreamURL(ctx context.Context, fileID, userID uint) (*StreamURL, error) {
+	if _, err := s.fileRepo.FindByIDAndUser(ctx, fileID, userID); err != nil {
+		return nil, ErrFileNotFound
+	}
+	if _, err := s.findStream(ctx, fileID); err != nil {
+		return nil, err
+	}
+
+	expiresAt := time.Now().Add(s.tokenTTL)
+	token := s.signStreamToken(fileID, expiresAt)
+	return &StreamURL{
+		URL:       fmt.Sprintf("%s/api/files/%d/stream?token=%s", strings.TrimSuffix(s.storageURL, "/"), fileID, token),
+		ExpiresAt: expiresAt,
+	}, nil
+}
+
+// OpenStream returns the content of a playlist or the path of a segment of a
+// file's stream. name is empty for the master playlist. Playlist URIs are
+// rewritten to carry the token so players can fetch every part with it.
+func (s *StreamService) OpenStream(ctx context.Context, fileID uint, token, name string) (content []byte, filePath, mimeType string, err error) {
+	if !s.verifyStreamToken(token, fileID) {
+		return nil, "", "", ErrInvalidStreamToken
+	}
+
+	variant, err := s.findStream(ctx, fileID)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	prefix := ""
+	if name == "" {
+		// The master playlist is served at .../stream, so relative URIs
+		// must be resolved against .../stream/
+		name = hlsMasterPlaylist
+		prefix = "stream/"
+	}
+	if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+		return nil, "", "", ErrFileNotFound
+	}
+
+	filePath = filepath.Join(filepath.Dir(variant.FilePath), name)
+	switch filepath.Ext(name) {
+	case ".ts":
+		if _, err := os.Stat(filePath); err != nil {
+			return nil, "", "", ErrFileNotFound
+		}
+		return nil, filePath, "video/mp2t", nil
+	case ".m3u8":
+		playlist, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, "", "", ErrFileNotFound
+		}
+		if err := s.variantRepo.TouchByFileID(ctx, fileID); err != nil {
+			log.Printf("[WARN] Failed to update last access of stream of file %d: %v", fileID, err)
+		}
+		return rewritePlaylist(playlist, prefix, token), "", variant.MimeType, nil
+	default:
+		return nil, "", "", ErrFileNotFound
+	}
+}
+
+func (s *StreamService) findStream(ctx context.Context, fileID uint) (*model.FileVariant, error) {
+	variants, err := s.variantRepo.FindByFileID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range variants {
+		if variants[i].Name == VariantHLS {
+			return &variants[i], nil
+		}
+	}
+	return nil, ErrStreamNotAvailable
+}
+
+func (s *StreamService) signStreamToken(fileID uint, expiresAt time.Time) string {
+	expiry := strconv.FormatInt(expiresAt.Unix(), 10)
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "stream\n%d\n%s", fileID, expiry)
+	return expiry + "." + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (s *StreamService) verifyStreamToken(token string, fileID uint) bool {
+	expiry, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return false
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	expected := s.signStreamToken(fileID, time.Unix(unix, 0))
+	return hmac.Equal([]byte(token), []byte(expected))
+}
+
+// rewritePlaylist appends the token to every URI line of an m3u8 playlist
+func rewritePlaylist(playlist []byte, prefix, token string) []byte {
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			line = prefix + line + "?token=" + token
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	return out.Bytes()
+}
+
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(_ string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return 0, err
+	}
+	return size, nil
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"strings"
+	"time"
+)
+
+// tempFilePrefix marks files created by TempStore so cleanup leaves anything
+// else in the directory alone
+const tempFilePrefix = "upload-"
+
+// TempStore stages writes in a temp directory on the same volume as the
+// uploads and renames finished files into place, so readers never see a
+// partially written file
+type TempStore struct {
+	dir    string
+	maxAge time.Duration
+}
+
+// NewTempStore uses TEMP_UPLOAD_PATH, or a ".tmp" directory inside
+// UPLOAD_PATH, which is on the same volume by construction
+func NewTempStore(cfg *config.Config) *TempStore {
+	dir := cfg.TempUploadPath
+	if dir == "" {
+		dir = filepath.Join(cfg.UploadPath, ".tmp")
+	}
+	return &TempStore{dir: dir, maxAge: cfg.TempFileMaxAge}
+}
+
+// Check verifies that files can be renamed from the temp directory into
+// uploadPath, which fails when they are on different volumes
+func (t *TempStore) Check(uploadPath string) error {
+	if err := os.MkdirAll(uploadPath, 0755); err != nil {
+		return err
+	}
+	probe, err := t.Create()
+	if err != nil {
+		return err
+	}
+	target := filepath.Join(uploadPath, filepath.Base(probe.Name()))
+	if err := t.Commit(probe, target); err != nil {
+		return fmt.Errorf("temp directory %s must be on the same volume as %s: %w", t.dir, uploadPath, err)
+	}
+	return os.Remove(target)
+}
+
+// Create opens a new temp file. Finish it with Commit or Discard.
+func (t *TempStore) Create() (*os.File, error) {
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	f, err := os.CreateTemp(t.dir, tempFilePrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	return f, nil
+}
+
+// Commit flushes and closes a temp file and atomically moves it to finalPath,
+// replacing any file there. The temp file is removed on failure.
+func (t *TempStore) Commit(f *os.File, finalPath string) error {
+	err := f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(finalPath), 0755)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), finalPath)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return nil
+}
+
+// Discard closes and removes a temp file that won't be committed
+func (t *TempStore) Discard(f *os.File) {
+	f.Close()
+	os.Remove(f.Name())
+}
+
+// WriteFile writes data to finalPath through a temp file
+func (t *TempStore) WriteFile(finalPath string, data []byte) error {
+	f, err := t.Create()
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		t.Discard(f)
+		return err
+	}
+	return t.Commit(f, finalPath)
+}
+
+// Cleanup removes temp files older than TEMP_FILE_MAX_AGE, left behind by
+// crashes or interrupted uploads
+func (t *TempStore) Cleanup(ctx context.Context) error {
+	entries, err := os.ReadDir(t.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	cutoff := time.Now().Add(-t.maxAge)
+	removed := 0
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || !strings.HasPrefix(entry.Name(), tempFilePrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(t.dir, entry.Name())); err != nil && !os.IsNotExist(err) {
+			log.Printf("[WARN] Failed to remove stale temp file %s: %v", entry.Name(), err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		log.Printf("Removed %d stale temp files", removed)
+	}
+	return nil
+}
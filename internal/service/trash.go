@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"storage-service/internal/model"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TrashEnabled reports whether deleted files go to the trash, which
+// TRASH_RETENTION_DAYS=0 turns off
+func (s *FileService) TrashEnabled() bool {
+	return s.trashRetention > 0
+}
+
+// trashFile moves a file to the trash. Its stored content, variants and
+// shares are kept, so a restore brings it back as it was.
+func (s *FileService) trashFile(ctx context.Context, file *model.File) error {
+	if err := s.fileRepo.Trash(ctx, file); err != nil {
+		return fmt.Errorf("failed to move file to the trash: %w", err)
+	}
+	return nil
+}
+
+// ListTrash returns a page of the user's trash, most recently deleted first
+func (s *FileService) ListTrash(ctx context.Context, userID uint, page, pageSize int) ([]model.File, int64, error) {
+	offset := (page - 1) * pageSize
+	files, err := s.fileRepo.FindTrashed(ctx, userID, pageSize, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	total, err := s.fileRepo.CountTrashed(ctx, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	for i := range files {
+		s.generateFileURL(&files[i])
+	}
+	return files, total, nil
+}
+
+// findTrashed loads a file of userID from the trash
+func (s *FileService) findTrashed(ctx context.Context, fileID, userID uint) (*model.File, error) {
+	file, err := s.fileRepo.FindTrashedByIDAndUser(ctx, fileID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFileNotInTrash
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// RestoreFile takes a file out of the trash into its folder. It counts
+// against the user's limits again, as if it was uploaded.
+func (s *FileService) RestoreFile(ctx context.Context, fileID, userID uint) (*model.File, error) {
+	file, err := s.findTrashed(ctx, fileID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.userService.CheckUploadAllowed(ctx, userID, file.FileSize); err != nil {
+		return nil, err
+	}
+
+	if err := s.fileRepo.Restore(ctx, file.ID); err != nil {
+		return nil, fmt.Errorf("failed to restore file: %w", err)
+	}
+	file.DeletedAt = gorm.DeletedAt{}
+	s.generateFileURL(file)
+	return file, nil
+}
+
+// PurgeFile deletes a file in the trash for good
+func (s *FileService) PurgeFile(ctx context.Context, fileID, userID uint) error {
+	file, err := s.findTrashed(ctx, fileID, userID)
+	if err != nil {
+		return err
+	}
+	return s.deleteFile(ctx, file)
+}
+
+// EmptyTrash deletes every file in the user's trash for good and returns
+// how many were deleted
+func (s *FileService) EmptyTrash(ctx context.Context, userID uint) (int64, error) {
+	var purged int64
+	for {
+		files, err := s.fileRepo.FindTrashed(ctx, userID, jobBatchSize, 0)
+		if err != nil {
+			return purged, err
+		}
+		for i := range files {
+			if err := s.deleteFile(ctx, &files[i]); err != nil {
+				return purged, err
+			}
+			purged++
+		}
+		if len(files) < jobBatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// PurgeTrash deletes files that have been in the trash for longer than
+// TRASH_RETENTION_DAYS. Pinned files stay until they are restored or
+// purged by hand.
+func (s *FileService) PurgeTrash(ctx context.Context) error {
+	if !s.TrashEnabled() {
+		return nil
+	}
+	cutoff := time.Now().Add(-s.trashRetention)
+	for ctx.Err() == nil {
+		files, err := s.fileRepo.FindPurgeable(ctx, cutoff, jobBatchSize)
+		if err != nil {
+			return err
+		}
+		failed := false
+		for i := range files {
+			if err := s.deleteFile(ctx, &files[i]); err != nil {
+				log.Printf("[WARN] Failed to purge file %d from the trash: %v", files[i].ID, err)
+				failed = true
+			}
+		}
+		// Failed files would be found again, so they wait for the next run
+		if failed || len(files) < jobBatchSize {
+			return nil
+		}
+	}
+	return ctx.Err()
+}
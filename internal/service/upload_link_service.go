@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"mime/multipart"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/mail"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UploadLinkInput is the editable part of an upload link. Limits left at
+// zero or empty don't apply.
+type UploadLinkInput struct {
+	Name       string `json:"name"`
+	FolderPath string `json:"folder_path"`
+	// AllowedExtensions is comma separated, e.g. "pdf,docx"
+	AllowedExtensions string `json:"allowed_extensions"`
+	MaxFiles          int    `json:"max_files"`
+	MaxTotalSize      int64  `json:"max_total_size"`
+	// ExpiresIn counts from now, e.g. "7d" or "12h"
+	ExpiresIn   string `json:"expires_in"`
+	NotifyEmail string `json:"notify_email" binding:"omitempty,email"`
+}
+
+// UploadLinkService manages upload-only links to folders and accepts the
+// files guests upload through them. Every limit of a link is enforced here,
+// whatever the guest's client does.
+type UploadLinkService struct {
+	linkRepo   *repository.UploadLinkRepository
+	files      *FileService
+	mailer     mail.Mailer
+	storageURL string
+}
+
+func NewUploadLinkService(linkRepo *repository.UploadLinkRepository, files *FileService, mailer mail.Mailer, cfg *config.Config) *UploadLinkService {
+	return &UploadLinkService{
+		linkRepo:   linkRepo,
+		files:      files,
+		mailer:     mailer,
+		storageURL: cfg.StorageURL,
+	}
+}
+
+func (s *UploadLinkService) ListLinks(ctx context.Context, userID uint) ([]model.UploadLink, error) {
+	links, err := s.linkRepo.FindByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		s.generateURL(&links[i])
+	}
+	return links, nil
+}
+
+func (s *UploadLinkService) CreateLink(ctx context.Context, userID uint, input UploadLinkInput) (*model.UploadLink, error) {
+	link := &model.UploadLink{UserID: userID}
+	if err := s.applyInput(link, input); err != nil {
+		return nil, err
+	}
+
+	token := make([]byte, 18)
+	if _, err := rand.Read(token); err != nil {
+		return nil, err
+	}
+	link.Token = base64.RawURLEncoding.EncodeToString(token)
+
+	if err := s.linkRepo.Create(ctx, link); err != nil {
+		return nil, fmt.Errorf("failed to create upload link: %w", err)
+	}
+	s.generateURL(link)
+	return link, nil
+}
+
+// UpdateLink changes the settings of a link. Files already received keep
+// counting against the new limits.
+func (s *UploadLinkService) UpdateLink(ctx context.Context, linkID, userID uint, input UploadLinkInput) (*model.UploadLink, error) {
+	link, err := s.findLink(ctx, linkID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.applyInput(link, input); err != nil {
+		return nil, err
+	}
+	if err := s.linkRepo.Update(ctx, link); err != nil {
+		return nil, fmt.Errorf("failed to update upload link: %w", err)
+	}
+	s.generateURL(link)
+	return link, nil
+}
+
+func (s *UploadLinkService) DeleteLink(ctx context.Context, linkID, userID uint) error {
+	link, err := s.findLink(ctx, linkID, userID)
+	if err != nil {
+		return err
+	}
+	return s.linkRepo.Delete(ctx, link)
+}
+
+// Resolve returns a link that still accepts uploads
+func (s *UploadLinkService) Resolve(ctx context.Context, token string) (*model.UploadLink, error) {
+	link, err := s.linkRepo.FindByToken(ctx, token)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if link.ExpiresAt != nil && time.Now().After(*link.ExpiresAt) {
+		return nil, ErrUploadLinkExpired
+	}
+	return link, nil
+}
+
+// Upload stores a file a guest uploaded through a link in the link's
+// folder. The file counts against the owner's storage like their own
+// uploads. client is the request that sent it.
+func (s *UploadLinkService) Upload(ctx context.Context, token string, fileHeader *multipart.FileHeader, client model.UploadSource) (*model.File, error) {
+	link, err := s.Resolve(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	if extensions := linkExtensions(link); len(extensions) > 0 {
+		ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileHeader.Filename), "."))
+		if !extensions[ext] {
+			return nil, ErrExtensionNotAllowed.WithArgs(link.AllowedExtensions)
+		}
+	}
+
+	// The file is counted before it is stored, so concurrent uploads can't
+	// get past the limits together
+	reserved, err := s.linkRepo.Reserve(ctx, link.ID, fileHeader.Size)
+	if err != nil {
+		return nil, err
+	}
+	if !reserved {
+		return nil, s.limitError(ctx, link)
+	}
+
+	client.Source, client.Label = model.SourceUploadLink, link.Name
+	if client.Label == "" {
+		client.Label = fmt.Sprintf("upload link %d", link.ID)
+	}
+	file, err := s.files.storeUpload(ctx, link.UserID, fileHeader, UploadOptions{FolderPath: link.FolderPath, Source: client})
+	if err != nil {
+		if err := s.linkRepo.Release(context.WithoutCancel(ctx), link.ID, fileHeader.Size); err != nil {
+			log.Printf("[WARN] Failed to release upload link %d: %v", link.ID, err)
+		}
+		return nil, err
+	}
+
+	if link.NotifyEmail != "" {
+		s.notify(link, file)
+	}
+	return file, nil
+}
+
+// RemainingSize returns how many more bytes a link accepts, or 0 when its
+// total size is unlimited
+func RemainingSize(link *model.UploadLink) int64 {
+	if link.MaxTotalSize == 0 {
+		return 0
+	}
+	return max(link.MaxTotalSize-link.TotalSize, 0)
+}
+
+// limitError tells why a link refused a file, from its current state
+func (s *UploadLinkService) limitError(ctx context.Context, link *model.UploadLink) error {
+	current, err := s.linkRepo.FindByID(ctx, link.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrUploadLinkNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if current.ExpiresAt != nil && time.Now().After(*current.ExpiresAt) {
+		return ErrUploadLinkExpired
+	}
+	if current.MaxFiles > 0 && current.FileCount >= current.MaxFiles {
+		return ErrUploadLinkFull.WithArgs(current.MaxFiles)
+	}
+	return ErrUploadLinkSizeExceeded.WithArgs(formatByteSize(current.MaxTotalSize))
+}
+
+// notify emails the link's notification address about a received file;
+// failures are logged, the upload stands
+func (s *UploadLinkService) notify(link *model.UploadLink, file *model.File) {
+	name := link.Name
+	if name == "" {
+		name = "your upload link"
+	}
+	subject := fmt.Sprintf("New file received: %s", file.OriginalName)
+	body := fmt.Sprintf("%s (%s) was uploaded through %s into /%s.\n",
+		file.OriginalName, formatByteSize(file.FileSize), name, file.FolderPath)
+
+	if err := s.mailer.Send(link.NotifyEmail, subject, body); err != nil {
+		log.Printf("[WARN] Failed to send %q to %s: %v", subject, link.NotifyEmail, err)
+	}
+}
+
+func (s *UploadLinkService) findLink(ctx context.Context, linkID, userID uint) (*model.UploadLink, error) {
+	link, err := s.linkRepo.FindByIDAndUser(ctx, linkID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUploadLinkNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return link, nil
+}
+
+func (s *UploadLinkService) applyInput(link *model.UploadLink, input UploadLinkInput) error {
+	if input.MaxFiles < 0 || input.MaxTotalSize < 0 {
+		return ErrInvalidUploadLinkLimit
+	}
+
+	var extensions []string
+	for _, ext := range strings.Split(input.AllowedExtensions, ",") {
+		ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), "."))
+		if ext == "" {
+			continue
+		}
+		if strings.ContainsAny(ext, "./\\ ") {
+			return ErrInvalidExtension.WithArgs(ext)
+		}
+		extensions = append(extensions, ext)
+	}
+
+	link.ExpiresAt = nil
+	if input.ExpiresIn != "" {
+		ttl, err := ParseExpireAfter(input.ExpiresIn)
+		if err != nil {
+			return ErrInvalidShareExpiry.WithArgs(input.ExpiresIn)
+		}
+		expiresAt := time.Now().Add(ttl)
+		link.ExpiresAt = &expiresAt
+	}
+
+	folderPath, err := s.files.sanitizeFolderPath(input.FolderPath)
+	if err != nil {
+		return err
+	}
+
+	link.Name = strings.TrimSpace(input.Name)
+	link.FolderPath = folderPath
+	link.AllowedExtensions = strings.Join(extensions, ",")
+	link.MaxFiles = input.MaxFiles
+	link.MaxTotalSize = input.MaxTotalSize
+	link.NotifyEmail = strings.TrimSpace(input.NotifyEmail)
+	return nil
+}
+
+// linkExtensions returns the extensions a link accepts, none for any
+func linkExtensions(link *model.UploadLink) map[string]bool {
+	extensions := make(map[string]bool)
+	for _, ext := range strings.Split(link.AllowedExtensions, ",") {
+		if ext != "" {
+			extensions[ext] = true
+		}
+	}
+	return extensions
+}
+
+func (s *UploadLinkService) generateURL(link *model.UploadLink) {
+	link.URL = fmt.Sprintf("%s/u/%s", strings.TrimSuffix(s.storageURL, "/"), link.Token)
+}
@@ -0,0 +1,77 @@
+package service
+
+import (
+	"context"
+	"sort"
+)
+
+// UploadPolicy describes the validation rules applied to uploads for a user,
+// allowing clients to check files before sending them
+type UploadPolicy struct {
+	BlockedExtensions []string `json:"blocked_extensions"`
+	BlockedMimeTypes  []string `json:"blocked_mime_types"`
+	ImageMimeTypes    []string `json:"image_mime_types"`
+	// What happens when declared type, extension and content disagree
+	MimeMismatchPolicy string     `json:"mime_mismatch_policy"`
+	StrictExtensions   bool       `json:"strict_extensions"` // Every extension segment is checked, not only the last
+	Limits             UserLimits `json:"limits"`
+	// Maximum size in bytes per content family or MIME type, on top of max_file_size
+	SizeLimits SizeLimits `json:"size_limits"`
+	// Processing profiles accepted by the profile field of image uploads
+	ImageProfiles []ImageProfile `json:"image_profiles"`
+	// Limits of the folder paths files can be stored in
+	Folders FolderPolicy `json:"folders"`
+}
+
+// UserLimits contains the quota limits of a user and how much of them is left
+type UserLimits struct {
+	MaxFileSize      int64 `json:"max_file_size"`
+	MaxFiles         int64 `json:"max_files"`
+	MaxStorage       int64 `json:"max_storage"`
+	UsedFiles        int64 `json:"used_files"`
+	UsedStorage      int64 `json:"used_storage"`
+	RemainingFiles   int64 `json:"remaining_files"`
+	RemainingStorage int64 `json:"remaining_storage"`
+}
+
+func (s *FileService) GetUploadPolicy(ctx context.Context, userID uint) (*UploadPolicy, error) {
+	stats, err := s.userService.GetUserStats(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userService.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &UploadPolicy{
+		BlockedExtensions:  sortedKeys(dangerousExtensions),
+		BlockedMimeTypes:   sortedKeys(dangerousMimeTypes),
+		ImageMimeTypes:     sortedKeys(userImageTypes(user)),
+		MimeMismatchPolicy: string(s.mimePolicy),
+		StrictExtensions:   s.filenamePolicy().Strict,
+		SizeLimits:         s.sizeLimits(),
+		ImageProfiles:      s.imageProfiles.List(),
+		Folders:            s.folderPolicy,
+		Limits: UserLimits{
+			MaxFileSize:      stats.MaxFileSize,
+			MaxFiles:         stats.MaxFiles,
+			MaxStorage:       stats.MaxStorage,
+			UsedFiles:        stats.TotalFiles,
+			UsedStorage:      stats.TotalSize,
+			RemainingFiles:   max(stats.MaxFiles-stats.TotalFiles, 0),
+			RemainingStorage: max(stats.MaxStorage-stats.TotalSize, 0),
+		},
+	}, nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k, allowed := range set {
+		if allowed {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,31 @@
+package service
+
+import (
+	"path/filepath"
+	"storage-service/internal/config"
+	"strings"
+)
+
+// uploadRoots resolves stored paths to their path below /uploads. Files live
+// in UPLOAD_PATH, or in PREVIOUS_UPLOAD_PATH until the storage migration job
+// has moved them.
+type uploadRoots struct {
+	current  string
+	previous string
+}
+
+func newUploadRoots(cfg *config.Config) uploadRoots {
+	return uploadRoots{current: cfg.UploadPath, previous: cfg.PreviousUploadPath}
+}
+
+func (r uploadRoots) relative(path string) string {
+	for _, root := range []string{r.current, r.previous} {
+		if root == "" {
+			continue
+		}
+		if rel, ok := strings.CutPrefix(path, root+string(filepath.Separator)); ok {
+			return rel
+		}
+	}
+	return path
+}
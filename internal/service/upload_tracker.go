@@ -0,0 +1,216 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"storage-service/internal/apperror"
+	"storage-service/internal/coord"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// UploadStage is the processing step an upload is currently in
+type UploadStage string
+
+const (
+	StagePending    UploadStage = "pending"
+	StageReceiving  UploadStage = "receiving"
+	StageValidating UploadStage = "validating"
+	StageScanning   UploadStage = "scanning"
+	StageOptimizing UploadStage = "optimizing"
+	StageDone       UploadStage = "done"
+	StageFailed     UploadStage = "failed"
+)
+
+// uploadSessionTTL is how long a session is kept after its last update
+const uploadSessionTTL = time.Hour
+
+var ErrUploadSessionNotFound = apperror.New(http.StatusNotFound, "upload_session_not_found", "Upload session not found")
+
+// UploadProgress is the state of a server-proxied upload
+type UploadProgress struct {
+	ID            string      `json:"upload_id"`
+	UserID        uint        `json:"-"`
+	Stage         UploadStage `json:"stage"`
+	BytesReceived int64       `json:"bytes_received"`
+	TotalBytes    int64       `json:"total_bytes"`
+	FileID        uint        `json:"file_id,omitempty"`
+	Error         string      `json:"error,omitempty"`
+	CreatedAt     time.Time   `json:"created_at"`
+	UpdatedAt     time.Time   `json:"updated_at"`
+}
+
+// Finished reports whether the upload reached a terminal stage
+func (p *UploadProgress) Finished() bool {
+	return p.Stage == StageDone || p.Stage == StageFailed
+}
+
+// UploadTracker keeps progress of upload sessions in the shared store so any
+// instance can report on an upload received by another one.
+// All update methods are no-ops for an empty or unknown upload ID.
+type UploadTracker struct {
+	store coord.Store
+	// mu serializes read-modify-write cycles made by this instance; a session
+	// is only updated by the instance receiving its upload
+	mu sync.Mutex
+}
+
+// storedUpload is the persisted form of a session, including its owner
+type storedUpload struct {
+	UploadProgress
+	Owner uint `json:"owner"`
+}
+
+func NewUploadTracker(store coord.Store) *UploadTracker {
+	return &UploadTracker{store: store}
+}
+
+// Create starts a new upload session for a user
+func (t *UploadTracker) Create(userID uint, totalBytes int64) (UploadProgress, error) {
+	now := time.Now()
+	p := UploadProgress{
+		ID:         uuid.New().String(),
+		UserID:     userID,
+		Stage:      StagePending,
+		TotalBytes: totalBytes,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	if err := t.save(&p); err != nil {
+		return UploadProgress{}, err
+	}
+	return p, nil
+}
+
+// Get returns a snapshot of a session owned by the user
+func (t *UploadTracker) Get(id string, userID uint) (UploadProgress, error) {
+	p, err := t.load(id)
+	if err != nil {
+		return UploadProgress{}, err
+	}
+	if p == nil || p.UserID != userID {
+		return UploadProgress{}, ErrUploadSessionNotFound
+	}
+	return *p, nil
+}
+
+// TrackReader wraps an upload body so bytes read from it are counted
+// against the session. The session must belong to the user.
+func (t *UploadTracker) TrackReader(id string, userID uint, body io.ReadCloser, totalBytes int64) (io.ReadCloser, error) {
+	if _, err := t.Get(id, userID); err != nil {
+		return nil, err
+	}
+	t.update(id, func(p *UploadProgress) {
+		p.Stage = StageReceiving
+		if p.TotalBytes == 0 && totalBytes > 0 {
+			p.TotalBytes = totalBytes
+		}
+	})
+	return &progressReader{ReadCloser: body, tracker: t, id: id}, nil
+}
+
+func (t *UploadTracker) SetStage(id string, stage UploadStage) {
+	t.update(id, func(p *UploadProgress) { p.Stage = stage })
+}
+
+func (t *UploadTracker) Complete(id string, fileID uint) {
+	t.update(id, func(p *UploadProgress) {
+		p.Stage = StageDone
+		p.FileID = fileID
+	})
+}
+
+func (t *UploadTracker) Fail(id string, err error) {
+	t.update(id, func(p *UploadProgress) {
+		p.Stage = StageFailed
+		p.Error = err.Error()
+	})
+}
+
+func (t *UploadTracker) update(id string, fn func(p *UploadProgress)) {
+	if id == "" {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	p, err := t.load(id)
+	if err != nil {
+		log.Printf("[WARN] Failed to load upload session %s: %v", id, err)
+		return
+	}
+	if p == nil {
+		return
+	}
+	fn(p)
+	p.UpdatedAt = time.Now()
+	if err := t.save(p); err != nil {
+		log.Printf("[WARN] Failed to save upload session %s: %v", id, err)
+	}
+}
+
+func (t *UploadTracker) load(id string) (*UploadProgress, error) {
+	data, ok, err := t.store.Get(context.Background(), uploadSessionKey(id))
+	if err != nil || !ok {
+		return nil, err
+	}
+	var stored storedUpload
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	stored.UploadProgress.UserID = stored.Owner
+	return &stored.UploadProgress, nil
+}
+
+// save writes the session and extends its lifetime to uploadSessionTTL
+func (t *UploadTracker) save(p *UploadProgress) error {
+	data, err := json.Marshal(storedUpload{UploadProgress: *p, Owner: p.UserID})
+	if err != nil {
+		return err
+	}
+	return t.store.Set(context.Background(), uploadSessionKey(p.ID), data, uploadSessionTTL)
+}
+
+func uploadSessionKey(id string) string {
+	return "upload:" + id
+}
+
+// progressFlushInterval limits how often received bytes are written to the store
+const progressFlushInterval = 250 * time.Millisecond
+
+type progressReader struct {
+	io.ReadCloser
+	tracker   *UploadTracker
+	id        string
+	pending   int64
+	lastFlush time.Time
+}
+
+func (r *progressReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	r.pending += int64(n)
+	if r.pending > 0 && (err != nil || time.Since(r.lastFlush) >= progressFlushInterval) {
+		r.flush()
+	}
+	return n, err
+}
+
+func (r *progressReader) Close() error {
+	r.flush()
+	return r.ReadCloser.Close()
+}
+
+func (r *progressReader) flush() {
+	if r.pending == 0 {
+		return
+	}
+	received := r.pending
+	r.pending = 0
+	r.lastFlush = time.Now()
+	r.tracker.update(r.id, func(p *UploadProgress) { p.BytesReceived += received })
+}
@@ -1,6 +1,7 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"storage-service/internal/model"
 	"storage-service/internal/repository"
@@ -11,6 +12,9 @@ import (
 type UserService struct {
 	userRepo *repository.UserRepository
 	fileRepo *repository.FileRepository
+	keys     *APIKeyService
+	plans    Plans
+	settings *SettingsService
 }
 
 type UserStats struct {
@@ -25,66 +29,74 @@ type UserSettings struct {
 	MaxFiles    int64 `json:"max_files"`
 	MaxFileSize int64 `json:"max_file_size"`
 	MaxStorage  int64 `json:"max_storage"`
+
+	// Plan bounds the limits above; it is ignored by UpdateUserSettings
+	Plan *Plan `json:"plan,omitempty"`
 }
 
-func NewUserService(userRepo *repository.UserRepository, fileRepo *repository.FileRepository) *UserService {
+func NewUserService(userRepo *repository.UserRepository, fileRepo *repository.FileRepository, keys *APIKeyService, settings *SettingsService) *UserService {
 	return &UserService{
 		userRepo: userRepo,
 		fileRepo: fileRepo,
+		keys:     keys,
+		plans:    settings.plans,
+		settings: settings,
 	}
 }
 
-func (s *UserService) Register(username, email string) (*model.User, error) {
-	_, err := s.userRepo.FindByEmail(email)
+func (s *UserService) Register(ctx context.Context, username, email string) (*model.User, error) {
+	_, err := s.userRepo.FindByEmail(ctx, email)
 	if err == nil {
-		return nil, errors.New("email already registered")
+		return nil, ErrEmailRegistered
 	}
 	if !errors.Is(err, gorm.ErrRecordNotFound) {
 		return nil, err
 	}
 
+	plan := s.plans[s.defaultPlan()]
 	user := &model.User{
-		Username: username,
-		Email:    email,
+		Username:    username,
+		Email:       email,
+		Plan:        plan.Name,
+		MaxFiles:    plan.MaxFiles,
+		MaxFileSize: plan.MaxFileSize,
+		MaxStorage:  plan.MaxStorage,
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	// The user gets a first API key, returned once in user.APIKey
+	err = s.keys.keyRepo.WithTx(ctx, func(ctx context.Context) error {
+		if err := s.userRepo.Create(ctx, user); err != nil {
+			return err
+		}
+		return s.keys.IssueKey(ctx, user, false)
+	})
+	if err != nil {
 		return nil, err
 	}
 
 	return user, nil
 }
 
-func (s *UserService) GetUserByID(id uint) (*model.User, error) {
-	return s.userRepo.FindByID(id)
-}
-
-func (s *UserService) RegenerateAPIKey(userID uint) (*model.User, error) {
-	user, err := s.userRepo.FindByID(userID)
-	if err != nil {
-		return nil, err
-	}
-
-	user.RegenerateAPIKey()
-	if err := s.userRepo.Update(user); err != nil {
-		return nil, err
+func (s *UserService) GetUserByID(ctx context.Context, id uint) (*model.User, error) {
+	user, err := s.userRepo.FindByID(ctx, id)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrUserNotFound
 	}
-
-	return user, nil
+	return user, err
 }
 
-func (s *UserService) GetUserStats(userID uint) (*UserStats, error) {
-	user, err := s.userRepo.FindByID(userID)
+func (s *UserService) GetUserStats(ctx context.Context, userID uint) (*UserStats, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	totalFiles, err := s.fileRepo.CountByUserID(userID)
+	totalFiles, err := s.fileRepo.CountByUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	totalSize, err := s.fileRepo.GetTotalSizeByUserID(userID)
+	totalSize, err := s.fileRepo.GetTotalSizeByUserID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -98,24 +110,45 @@ func (s *UserService) GetUserStats(userID uint) (*UserStats, error) {
 	}, nil
 }
 
-func (s *UserService) GetUserSettings(userID uint) (*UserSettings, error) {
-	user, err := s.userRepo.FindByID(userID)
+// StorageUsage is the storage a user fills and may fill, in bytes
+type StorageUsage struct {
+	Used  int64
+	Limit int64
+}
+
+// GetStorageUsage returns the storage a user fills and their storage limit,
+// including the overage of their plan
+func (s *UserService) GetStorageUsage(ctx context.Context, userID uint) (*StorageUsage, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	used, err := s.fileRepo.GetTotalSizeByUserID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &StorageUsage{Used: used, Limit: s.storageLimit(user)}, nil
+}
 
-	return &UserSettings{
-		MaxFiles:    user.MaxFiles,
-		MaxFileSize: user.MaxFileSize,
-		MaxStorage:  user.MaxStorage,
-	}, nil
+func (s *UserService) GetUserSettings(ctx context.Context, userID uint) (*UserSettings, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.settingsOf(user), nil
 }
 
-func (s *UserService) UpdateUserSettings(userID uint, settings *UserSettings) (*UserSettings, error) {
-	user, err := s.userRepo.FindByID(userID)
+// UpdateUserSettings changes the limits of a user within the ceilings of
+// their plan
+func (s *UserService) UpdateUserSettings(ctx context.Context, userID uint, settings *UserSettings) (*UserSettings, error) {
+	user, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		return nil, err
 	}
+	if err := s.planOf(user).checkSettings(settings); err != nil {
+		return nil, err
+	}
 
 	// Validate settings
 	if settings.MaxFiles > 0 {
@@ -128,44 +161,98 @@ func (s *UserService) UpdateUserSettings(userID uint, settings *UserSettings) (*
 		user.MaxStorage = settings.MaxStorage
 	}
 
-	if err := s.userRepo.Update(user); err != nil {
+	if err := s.userRepo.Update(ctx, user); err != nil {
 		return nil, err
 	}
 
+	return s.settingsOf(user), nil
+}
+
+func (s *UserService) settingsOf(user *model.User) *UserSettings {
+	plan := s.planOf(user)
 	return &UserSettings{
 		MaxFiles:    user.MaxFiles,
 		MaxFileSize: user.MaxFileSize,
 		MaxStorage:  user.MaxStorage,
-	}, nil
+		Plan:        &plan,
+	}
+}
+
+// planOf returns the plan of a user. Users without a plan, or whose plan was
+// removed from PLANS, are on the default plan.
+func (s *UserService) planOf(user *model.User) Plan {
+	if plan, ok := s.plans[user.Plan]; ok {
+		return plan
+	}
+	return s.plans[s.defaultPlan()]
+}
+
+// SetPlan moves a user to another plan and sets their limits to its
+// ceilings. Only admins may change plans.
+func (s *UserService) SetPlan(ctx context.Context, userID uint, name string) (*UserSettings, error) {
+	plan, err := s.plans.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	assignPlan(user, plan)
+	if err := s.userRepo.Update(ctx, user); err != nil {
+		return nil, err
+	}
+	return s.settingsOf(user), nil
+}
+
+// assignPlan puts user on plan with its ceilings as limits
+func assignPlan(user *model.User, plan Plan) {
+	user.Plan = plan.Name
+	user.MaxFiles, user.MaxFileSize, user.MaxStorage = plan.MaxFiles, plan.MaxFileSize, plan.MaxStorage
+}
+
+// storageLimit returns the storage a user may fill, including the overage
+// of their plan while their subscription is active
+func (s *UserService) storageLimit(user *model.User) int64 {
+	if user.BillingStatus == model.BillingActive {
+		return user.MaxStorage + s.planOf(user).OverageStorage
+	}
+	return user.MaxStorage
+}
+
+// Plans returns every plan users can be assigned to
+func (s *UserService) Plans() []Plan {
+	return s.plans.List()
 }
 
-func (s *UserService) CheckUploadAllowed(userID uint, fileSize int64) error {
-	user, err := s.userRepo.FindByID(userID)
+func (s *UserService) CheckUploadAllowed(ctx context.Context, userID uint, fileSize int64) error {
+	user, err := s.userRepo.FindByID(ctx, userID)
 	if err != nil {
 		return err
 	}
 
 	// Check file size limit
 	if fileSize > user.MaxFileSize {
-		return errors.New("file size exceeds your limit")
+		return ErrFileSizeLimit
 	}
 
 	// Check total files limit
-	totalFiles, err := s.fileRepo.CountByUserID(userID)
+	totalFiles, err := s.fileRepo.CountByUserID(ctx, userID)
 	if err != nil {
 		return err
 	}
 	if totalFiles >= user.MaxFiles {
-		return errors.New("maximum number of files reached")
+		return ErrFileCountLimit
 	}
 
 	// Check total storage limit
-	totalSize, err := s.fileRepo.GetTotalSizeByUserID(userID)
+	totalSize, err := s.fileRepo.GetTotalSizeByUserID(ctx, userID)
 	if err != nil {
 		return err
 	}
-	if totalSize+fileSize > user.MaxStorage {
-		return errors.New("storage limit exceeded")
+	if totalSize+fileSize > s.storageLimit(user) {
+		return ErrStorageLimitExceeded
 	}
 
 	return nil
@@ -0,0 +1,62 @@
+package service
+
+import (
+	"context"
+	"log"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+)
+
+// JobRegenerateVariants rebuilds thumbnails and other variants of existing images
+const JobRegenerateVariants = "regenerate_variants"
+
+// jobBatchSize is the number of files processed between progress saves
+const jobBatchSize = 50
+
+// RegenerateVariantsParams selects the files a regeneration job processes.
+// Empty lists match all users and all image types.
+type RegenerateVariantsParams struct {
+	UserIDs   []uint   `json:"user_ids"`
+	MimeTypes []string `json:"mime_types"`
+}
+
+// RegisterVariantJobs adds the variant regeneration job to the job service
+func RegisterVariantJobs(jobs *JobService, fileRepo *repository.FileRepository, variants *VariantService) {
+	jobs.Register(JobRegenerateVariants, func(ctx context.Context, job *model.Job) (bool, error) {
+		var params RegenerateVariantsParams
+		if err := decodeJobParams(job, &params); err != nil {
+			return false, err
+		}
+
+		filter := repository.FileFilter{UserIDs: params.UserIDs, MimeTypes: params.MimeTypes}
+		if len(filter.MimeTypes) == 0 {
+			filter.MimeTypes = sortedKeys(variantSourceTypes)
+		}
+
+		if job.Cursor == 0 && job.Total == 0 {
+			total, err := fileRepo.CountMatching(ctx, filter)
+			if err != nil {
+				return false, err
+			}
+			job.Total = total
+		}
+
+		files, err := fileRepo.FindBatchAfter(ctx, filter, job.Cursor, jobBatchSize)
+		if err != nil {
+			return false, err
+		}
+
+		for i := range files {
+			if _, err := variants.Generate(ctx, &files[i]); err != nil {
+				log.Printf("[WARN] Failed to regenerate variants of file %d: %v", files[i].ID, err)
+				job.Failed++
+			} else {
+				variants.publishCompleted(ctx, &files[i])
+			}
+			job.Processed++
+			job.Cursor = files[i].ID
+		}
+
+		return len(files) < jobBatchSize, nil
+	})
+}
@@ -0,0 +1,316 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/config"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+	"storage-service/internal/repository"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// VariantSize is a named rendition that fits within MaxSize x MaxSize pixels
+type VariantSize struct {
+	Name    string `json:"name"`
+	MaxSize int    `json:"max_size"`
+}
+
+// ParseVariantSizes parses a spec such as "thumb=256,medium=1024"
+func ParseVariantSizes(spec string) ([]VariantSize, error) {
+	var sizes []VariantSize
+	seen := make(map[string]bool)
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, "/\\. ") {
+			return nil, fmt.Errorf("invalid variant size %q, expected name=pixels", entry)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate variant name %q", name)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid variant size %q, pixels must be a positive number", entry)
+		}
+		seen[name] = true
+		sizes = append(sizes, VariantSize{Name: name, MaxSize: size})
+	}
+	return sizes, nil
+}
+
+// VariantService builds derived renditions of image files next to the original
+type VariantService struct {
+	variantRepo *repository.VariantRepository
+	sizes       []VariantSize
+	uploadPath  string
+	roots       uploadRoots
+	storageURL  string
+	jpegQuality int
+	color       colorHandling
+	settings    *SettingsService
+	workers     *ImageWorkers
+	temp        *TempStore
+	blobs       *BlobStore
+	events      *events.Bus
+}
+
+// NewVariantService returns the service generating the variants of sizes,
+// see ParseVariantSizes
+func NewVariantService(variantRepo *repository.VariantRepository, blobs *BlobStore, workers *ImageWorkers, bus *events.Bus, settings *SettingsService, sizes []VariantSize, cfg *config.Config) *VariantService {
+	return &VariantService{
+		variantRepo: variantRepo,
+		sizes:       sizes,
+		uploadPath:  cfg.UploadPath,
+		roots:       newUploadRoots(cfg),
+		storageURL:  cfg.StorageURL,
+		jpegQuality: 85,
+		color:       newColorHandling(cfg.ImageColorMode),
+		settings:    settings,
+		workers:     workers,
+		temp:        NewTempStore(cfg),
+		blobs:       blobs,
+		events:      bus,
+	}
+}
+
+// variantSourceTypes are the stored image types variants are built from:
+// accepted uploads plus WebP produced by processing profiles
+var variantSourceTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/jpg":  true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// Supports reports whether variants can be generated for a MIME type
+func (s *VariantService) Supports(mimeType string) bool {
+	return variantSourceTypes[mimeType]
+}
+
+// Generate (re)builds every configured variant of a file and removes variants
+// whose size is no longer configured
+func (s *VariantService) Generate(ctx context.Context, file *model.File) ([]model.FileVariant, error) {
+	if !s.Supports(file.MimeType) {
+		return nil, nil
+	}
+
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		return nil, err
+	}
+	existing, err := s.variantRepo.FindByFileID(ctx, file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	variants := make([]model.FileVariant, 0, len(s.sizes))
+	configured := make(map[string]bool)
+	err = s.workers.Do(ctx, func() error {
+		src, err := os.Open(file.FilePath)
+		if err != nil {
+			return fmt.Errorf("failed to open image: %w", err)
+		}
+		defer src.Close()
+
+		img, header, err := decodeImage(src, s.settings.Current().PixelLimit, imaging.AutoOrientation(true))
+		if err != nil {
+			return fmt.Errorf("failed to open image: %w", err)
+		}
+		img, icc := s.color.prepare(img, header, file.MimeType)
+
+		for _, size := range s.sizes {
+			configured[size.Name] = true
+			variant, err := s.render(ctx, file, img, icc, size)
+			if err != nil {
+				return err
+			}
+			variants = append(variants, *variant)
+		}
+		return nil
+	})
+	if err != nil {
+		return variants, err
+	}
+
+	for i := range existing {
+		if !configured[existing[i].Name] && existing[i].Name != VariantHLS {
+			s.remove(ctx, &existing[i])
+		}
+	}
+
+	return variants, nil
+}
+
+// render builds one variant; icc is the color profile to embed, if any
+func (s *VariantService) render(ctx context.Context, file *model.File, img image.Image, icc []byte, size VariantSize) (*model.FileVariant, error) {
+	resized := imaging.Fit(img, size.MaxSize, size.MaxSize, imaging.Lanczos)
+
+	mimeType, ext := "image/jpeg", ".jpg"
+	if file.MimeType == "image/png" {
+		mimeType, ext = "image/png", ".png"
+	}
+
+	base := strings.TrimSuffix(file.Filename, filepath.Ext(file.Filename))
+	filePath := filepath.Join(filepath.Dir(file.FilePath), base+"_"+size.Name+ext)
+
+	out, err := s.temp.Create()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create variant: %w", err)
+	}
+	var buf bytes.Buffer
+	if mimeType == "image/png" {
+		err = png.Encode(&buf, resized)
+	} else {
+		err = jpeg.Encode(&buf, resized, &jpeg.Options{Quality: s.jpegQuality})
+	}
+	if err == nil {
+		_, err = out.Write(embedICCProfile(buf.Bytes(), mimeType, icc))
+	}
+	if err != nil {
+		s.temp.Discard(out)
+		return nil, fmt.Errorf("failed to encode variant: %w", err)
+	}
+	if err := s.temp.Commit(out, filePath); err != nil {
+		return nil, err
+	}
+	if err := s.blobs.Publish(ctx, filePath); err != nil {
+		os.Remove(filePath)
+		return nil, err
+	}
+
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := resized.Bounds()
+	variant := &model.FileVariant{
+		FileID:   file.ID,
+		Name:     size.Name,
+		FilePath: filePath,
+		MimeType: mimeType,
+		Width:    bounds.Dx(),
+		Height:   bounds.Dy(),
+		FileSize: info.Size(),
+
+		LastAccessedAt: time.Now(),
+	}
+	if err := s.variantRepo.Save(ctx, variant); err != nil {
+		os.Remove(filePath)
+		s.blobs.Remove(context.WithoutCancel(ctx), filePath)
+		return nil, fmt.Errorf("failed to save variant metadata: %w", err)
+	}
+	s.generateURL(variant)
+	return variant, nil
+}
+
+// GetVariants returns the stored variants of a file with their URLs
+func (s *VariantService) GetVariants(ctx context.Context, fileID uint) ([]model.FileVariant, error) {
+	variants, err := s.variantRepo.FindByFileID(ctx, fileID)
+	if err != nil {
+		return nil, err
+	}
+	if len(variants) > 0 {
+		if err := s.variantRepo.TouchByFileID(ctx, fileID); err != nil {
+			log.Printf("[WARN] Failed to update last access of variants of file %d: %v", fileID, err)
+		}
+	}
+	for i := range variants {
+		s.generateURL(&variants[i])
+	}
+	return variants, nil
+}
+
+// AttachVariants loads the variants of a page of files with one query
+func (s *VariantService) AttachVariants(ctx context.Context, files []model.File) error {
+	ids := make([]uint, len(files))
+	for i := range files {
+		ids[i] = files[i].ID
+	}
+	variants, err := s.variantRepo.FindByFileIDs(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	byFile := make(map[uint][]model.FileVariant, len(files))
+	for i := range variants {
+		s.generateURL(&variants[i])
+		byFile[variants[i].FileID] = append(byFile[variants[i].FileID], variants[i])
+	}
+	for i := range files {
+		files[i].Variants = byFile[files[i].ID]
+	}
+	return nil
+}
+
+// publishCompleted announces that background processing of a file finished,
+// with the URLs of all its variants
+func (s *VariantService) publishCompleted(ctx context.Context, file *model.File) {
+	variants, err := s.variantRepo.FindByFileID(ctx, file.ID)
+	if err != nil {
+		log.Printf("[WARN] Failed to load variants of file %d: %v", file.ID, err)
+		return
+	}
+	for i := range variants {
+		s.generateURL(&variants[i])
+	}
+	file.Variants = variants
+	s.events.Publish(events.NewProcessingCompleted(file))
+}
+
+// DeleteVariants removes all variants of a file from disk and the database
+func (s *VariantService) DeleteVariants(ctx context.Context, fileID uint) {
+	variants, err := s.variantRepo.DeleteByFileID(ctx, fileID)
+	if err != nil {
+		log.Printf("[WARN] Failed to delete variants of file %d: %v", fileID, err)
+		return
+	}
+	for i := range variants {
+		if err := s.blobs.removeVariant(ctx, &variants[i]); err != nil {
+			log.Printf("[WARN] Failed to remove variant %s: %v", variants[i].FilePath, err)
+		}
+	}
+}
+
+func (s *VariantService) remove(ctx context.Context, variant *model.FileVariant) {
+	if err := s.variantRepo.Delete(ctx, variant); err != nil {
+		log.Printf("[WARN] Failed to delete variant %d: %v", variant.ID, err)
+		return
+	}
+	s.blobs.removeVariant(ctx, variant)
+}
+
+// removeVariantFiles deletes the files of a variant from disk; HLS renditions
+// are a directory of playlists and segments
+func removeVariantFiles(variant *model.FileVariant) error {
+	if variant.Name == VariantHLS {
+		return os.RemoveAll(filepath.Dir(variant.FilePath))
+	}
+	if err := os.Remove(variant.FilePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *VariantService) generateURL(variant *model.FileVariant) {
+	relativePath := s.roots.relative(variant.FilePath)
+	variant.URL = fmt.Sprintf("%s/uploads/%s", strings.TrimSuffix(s.storageURL, "/"), filepath.ToSlash(relativePath))
+}
@@ -0,0 +1,271 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"storage-service/internal/events"
+	"storage-service/internal/model"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// VersioningEnabled reports whether replaced content is kept as earlier
+// versions, which MAX_FILE_VERSIONS=0 turns off
+func (s *FileService) VersioningEnabled() bool {
+	return s.maxVersions > 0
+}
+
+// ListVersions returns the earlier versions of a file of userID, newest first
+func (s *FileService) ListVersions(ctx context.Context, fileID, userID uint) (*model.File, []model.FileVersion, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	versions, err := s.versionRepo.FindByFileID(ctx, file.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	s.generateFileURL(file)
+	return file, versions, nil
+}
+
+// findVersion loads an earlier version of a file of userID
+func (s *FileService) findVersion(ctx context.Context, fileID, userID uint, version int) (*model.File, *model.FileVersion, error) {
+	file, err := s.findFile(ctx, fileID, userID)
+	if err != nil {
+		return nil, nil, err
+	}
+	fileVersion, err := s.versionRepo.FindByFileIDAndVersion(ctx, file.ID, version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil, ErrVersionNotFound
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, fileVersion, nil
+}
+
+// OpenVersion returns a file as it was at an earlier version, to be read
+// like the current content
+func (s *FileService) OpenVersion(ctx context.Context, fileID, userID uint, version int) (*model.File, error) {
+	file, fileVersion, err := s.findVersion(ctx, fileID, userID, version)
+	if err != nil {
+		return nil, err
+	}
+	applyVersion(file, fileVersion)
+	if err := CheckDownload(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// RestoreVersion makes an earlier version the current content of a file.
+// The content it replaces is kept as a new version, so a restore can be
+// undone like any other change.
+func (s *FileService) RestoreVersion(ctx context.Context, fileID, userID uint, version int) (*model.File, error) {
+	file, fileVersion, err := s.findVersion(ctx, fileID, userID, version)
+	if err != nil {
+		return nil, err
+	}
+	if file.ScanStatus == model.ScanQuarantined {
+		return nil, ErrFileQuarantined
+	}
+	if fileVersion.ScanStatus == model.ScanInfected {
+		return nil, ErrFileInfected
+	}
+	if err := s.checkWriteOnce(ctx, file); err != nil {
+		return nil, err
+	}
+
+	previous := newVersion(file)
+	applyVersion(file, fileVersion)
+	file.Width, file.Height, file.FrameCount = 0, 0, 0
+	file.ColorProfile, file.ColorSpace = "", ""
+	if allowedImageTypes[file.MimeType] {
+		if path, err := s.Locate(ctx, file); err == nil {
+			if meta, err := readImageMetadataFile(path, file.MimeType); err == nil {
+				file.Width, file.Height = meta.Width, meta.Height
+				file.FrameCount, file.ColorProfile, file.ColorSpace = meta.FrameCount, meta.ColorProfile, meta.ColorSpace
+			}
+		}
+	}
+
+	// The restored version's stored file now belongs to the file
+	if err := s.saveReplaced(ctx, file, previous, fileVersion, false); err != nil {
+		return nil, fmt.Errorf("failed to restore version: %w", err)
+	}
+	s.refreshVariants(ctx, file)
+	s.events.Publish(events.NewFileReplaced(file, previous.FilePath))
+
+	s.generateFileURL(file)
+	return file, nil
+}
+
+// refreshVariants rebuilds the renditions of a file that got other content.
+// Files that had no thumbnails, like general uploads, get none; streams are
+// transcoded again on the FileReplaced event.
+func (s *FileService) refreshVariants(ctx context.Context, file *model.File) {
+	variants, err := s.variants.GetVariants(ctx, file.ID)
+	s.variants.DeleteVariants(ctx, file.ID)
+	if err != nil || !s.variants.Supports(file.MimeType) {
+		return
+	}
+	for i := range variants {
+		if variants[i].Name == VariantHLS {
+			continue
+		}
+		if _, err := s.variants.Generate(ctx, file); err != nil {
+			log.Printf("[WARN] Failed to generate variants of file %d: %v", file.ID, err)
+		}
+		return
+	}
+}
+
+// newVersion records the current content of a file as an earlier version
+func newVersion(file *model.File) *model.FileVersion {
+	return &model.FileVersion{
+		FileID:      file.ID,
+		Version:     file.Version,
+		Filename:    file.Filename,
+		FilePath:    file.FilePath,
+		FileSize:    file.FileSize,
+		MimeType:    file.MimeType,
+		Compression: file.Compression,
+		StoredSize:  file.StoredSize,
+		SHA256:      file.SHA256,
+		MD5:         file.MD5,
+		ScanStatus:  file.ScanStatus,
+	}
+}
+
+// applyVersion gives a file the content of an earlier version
+func applyVersion(file *model.File, version *model.FileVersion) {
+	file.Filename, file.FilePath = version.Filename, version.FilePath
+	file.FileSize, file.MimeType = version.FileSize, version.MimeType
+	file.Kind = model.FileKind(version.MimeType, file.OriginalName)
+	file.Compression, file.StoredSize = version.Compression, version.StoredSize
+	file.SHA256, file.MD5 = version.SHA256, version.MD5
+	file.ScanStatus = version.ScanStatus
+	file.BlobID = nil
+}
+
+// saveReplaced stores a file that got new content. previous, the content it
+// replaced, is kept as a version unless it is nil; restored is the version
+// the new content came from, which is removed. Versions beyond
+// MAX_FILE_VERSIONS are pruned, oldest first. Uploaded content is counted
+// in its blob when acquire is set, see acquireBlob.
+func (s *FileService) saveReplaced(ctx context.Context, file *model.File, previous, restored *model.FileVersion, acquire bool) error {
+	var pruned []model.FileVersion
+	err := s.fileRepo.WithTx(ctx, func(ctx context.Context) error {
+		if acquire {
+			if err := s.acquireBlob(ctx, file); err != nil {
+				return err
+			}
+		}
+		if restored != nil {
+			if err := s.versionRepo.Delete(ctx, restored); err != nil {
+				return err
+			}
+		}
+		if previous != nil {
+			if err := s.versionRepo.Create(ctx, previous); err != nil {
+				return err
+			}
+			excess, err := s.versionRepo.FindExcess(ctx, file.ID, s.maxVersions)
+			if err != nil {
+				return err
+			}
+			for i := range excess {
+				if err := s.versionRepo.Delete(ctx, &excess[i]); err != nil {
+					return err
+				}
+			}
+			pruned = excess
+			file.Version = previous.Version + 1
+		}
+		return s.fileRepo.Update(ctx, file)
+	})
+	if err != nil {
+		return err
+	}
+	s.removeVersionBlobs(context.WithoutCancel(ctx), pruned)
+	return nil
+}
+
+// preserveContent copies the stored file of a file that is about to be
+// overwritten in place, returning the path of the copy
+func (s *FileService) preserveContent(ctx context.Context, file *model.File) (string, error) {
+	if err := s.blobs.Fetch(ctx, file.FilePath); err != nil {
+		return "", err
+	}
+	target := filepath.Join(filepath.Dir(file.FilePath), uuid.New().String()+filepath.Ext(file.Filename)) + CompressionSuffix(file.Compression)
+	err := s.storage.Retry(ctx, "write", func() error {
+		src, err := os.Open(file.FilePath)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		dst, err := s.temp.Create()
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(dst, contextReader{ctx, src}); err != nil {
+			s.temp.Discard(dst)
+			return err
+		}
+		return s.temp.Commit(dst, target)
+	})
+	if err == nil {
+		err = s.blobs.Publish(ctx, target)
+	}
+	if err != nil {
+		os.Remove(target)
+		return "", err
+	}
+	return target, nil
+}
+
+// deleteVersions removes all versions of a file that is deleted for good
+func (s *FileService) deleteVersions(ctx context.Context, fileID uint) {
+	versions, err := s.versionRepo.DeleteByFileID(ctx, fileID)
+	if err != nil {
+		log.Printf("[WARN] Failed to delete versions of file %d: %v", fileID, err)
+		return
+	}
+	s.removeVersionBlobs(ctx, versions)
+}
+
+// removeVersionBlobs deletes the stored files of removed versions unless a
+// file or another version still uses them
+func (s *FileService) removeVersionBlobs(ctx context.Context, versions []model.FileVersion) {
+	for i := range versions {
+		path := versions[i].FilePath
+		files, err := s.fileRepo.CountOthersByFilePath(ctx, path, 0)
+		if err != nil || files > 0 {
+			continue
+		}
+		others, err := s.versionRepo.CountOthersByFilePath(ctx, path, versions[i].ID)
+		if err != nil || others > 0 {
+			continue
+		}
+		err = s.storage.Retry(ctx, "remove", func() error {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return s.blobs.Remove(ctx, path)
+		})
+		if err != nil {
+			log.Printf("[WARN] Failed to remove version %d of file %d: %v", versions[i].Version, versions[i].FileID, err)
+			continue
+		}
+		if mirror, ok := s.replica.Path(path); ok {
+			os.Remove(mirror)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"storage-service/internal/model"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// writeOnceFolder returns the write-once folder of userID that folderPath
+// is or lies below, "" when there is none
+func (s *FileService) writeOnceFolder(ctx context.Context, userID uint, folderPath string) (string, error) {
+	paths, err := s.folderRepo.FindWriteOncePaths(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return writeOnceParent(paths, folderPath), nil
+}
+
+// checkWriteOnce fails with ErrWriteOnceFolder when file lies in a
+// write-once folder, so it may not be changed, renamed, moved or deleted
+func (s *FileService) checkWriteOnce(ctx context.Context, file *model.File) error {
+	folder, err := s.writeOnceFolder(ctx, file.UserID, file.FolderPath)
+	if err != nil {
+		return err
+	}
+	if folder != "" {
+		return ErrWriteOnceFolder.WithArgs(folder)
+	}
+	return nil
+}
+
+// checkWriteOnceTree fails with ErrWriteOnceFolder when renaming or
+// deleting folderPath would touch a write-once folder: the folder itself,
+// one of its parents or one of its subfolders
+func (s *FileService) checkWriteOnceTree(ctx context.Context, userID uint, folderPath string) error {
+	paths, err := s.folderRepo.FindWriteOncePaths(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if folder := writeOnceParent(paths, folderPath); folder != "" {
+		return ErrWriteOnceFolder.WithArgs(folder)
+	}
+	for _, path := range paths {
+		if strings.HasPrefix(path, folderPath+"/") {
+			return ErrWriteOnceFolder.WithArgs(path)
+		}
+	}
+	return nil
+}
+
+// writeOnceParent returns the path of paths that folderPath is or lies
+// below, the topmost if several are
+func writeOnceParent(paths []string, folderPath string) string {
+	found := ""
+	for _, path := range paths {
+		if (folderPath == path || strings.HasPrefix(folderPath, path+"/")) && (found == "" || len(path) < len(found)) {
+			found = path
+		}
+	}
+	return found
+}
+
+// ReleaseWriteOnce lets the files of a write-once folder of userID be
+// changed and deleted again. Only admins may release folders.
+func (s *FileService) ReleaseWriteOnce(ctx context.Context, userID uint, folderPath string) (*model.Folder, error) {
+	folder, err := s.folderRepo.FindByPath(ctx, userID, model.CleanFolderPath(folderPath))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrFolderNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !folder.WriteOnce {
+		return nil, ErrNotWriteOnce
+	}
+
+	folder.WriteOnce = false
+	if err := s.folderRepo.Save(ctx, folder); err != nil {
+		return nil, err
+	}
+	return folder, nil
+}
@@ -0,0 +1,153 @@
+package sigv4
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// maxChunkSize bounds a chunk of a streamed upload; clients send 64KB to 8MB
+const maxChunkSize = 64 << 20
+
+// emptySHA256 is the SHA-256 of no bytes
+const emptySHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// chunkSigner checks the signatures of chunks; each one signs the chunk
+// and the signature of the one before, starting with the request's
+type chunkSigner struct {
+	key      []byte
+	date     string
+	scope    string
+	previous string
+}
+
+func (s *chunkSigner) verify(signature string, sum []byte) bool {
+	stringToSign := algorithm + "-PAYLOAD\n" + s.date + "\n" + s.scope + "\n" + s.previous + "\n" + emptySHA256 + "\n" + hex.EncodeToString(sum)
+	expected := sign(s.key, stringToSign)
+	s.previous = expected
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+// chunkedReader decodes an aws-chunked body: chunks of "<hex size>[;chunk-
+// signature=<signature>]\r\n<data>\r\n" ending with an empty chunk and
+// optional trailers, which are skipped. Data is passed on as it is read and
+// a chunk whose signature doesn't match fails the read at its end.
+type chunkedReader struct {
+	body      io.ReadCloser
+	r         *bufio.Reader
+	signer    *chunkSigner // nil for unsigned chunks
+	hash      hash.Hash
+	signature string
+	remaining int64
+	started   bool
+	err       error
+}
+
+func newChunkedReader(body io.ReadCloser, signer *chunkSigner) *chunkedReader {
+	return &chunkedReader{body: body, r: bufio.NewReader(body), signer: signer, hash: sha256.New()}
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	for c.remaining == 0 {
+		if c.err = c.nextChunk(); c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	c.err = err
+	return n, err
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+// nextChunk checks the chunk just read and starts the next one, returning
+// io.EOF after the last
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		if line, err := c.readLine(); err != nil || line != "" {
+			return errIncompleteBody
+		}
+		if err := c.verify(); err != nil {
+			return err
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return errIncompleteBody
+	}
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(sizeField, 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return errIncompleteBody
+	}
+	if c.signer != nil {
+		var ok bool
+		if c.signature, ok = strings.CutPrefix(extension, "chunk-signature="); !ok {
+			return errIncompleteBody
+		}
+	}
+	c.hash.Reset()
+	c.remaining = size
+	if size > 0 {
+		return nil
+	}
+
+	// The final chunk is signed too
+	if err := c.verify(); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			return io.EOF
+		}
+		if err != nil {
+			return errIncompleteBody
+		}
+	}
+}
+
+func (c *chunkedReader) verify() error {
+	if c.signer != nil && !c.signer.verify(c.signature, c.hash.Sum(nil)) {
+		return errorf(http.StatusForbidden, "SignatureDoesNotMatch", "The signature of a chunk does not match")
+	}
+	return nil
+}
+
+// readLine reads a line without its CRLF, refusing lines longer than the
+// buffer
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return string(bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))), nil
+}
+
+var errIncompleteBody = &Error{Status: http.StatusBadRequest, Code: "IncompleteBody", Message: "The chunked body is malformed"}
@@ -0,0 +1,306 @@
+// Package sigv4 verifies requests signed with AWS Signature Version 4 the
+// way S3 clients sign them, in the Authorization header or in the query
+// string of a presigned URL
+package sigv4
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	algorithm = "AWS4-HMAC-SHA256"
+	amzDate   = "20060102T150405Z"
+	// maxSkew is how far the clock of a client may be off
+	maxSkew = 15 * time.Minute
+	// maxExpires is the longest lifetime of a presigned URL, 7 days like S3
+	maxExpires = 7 * 24 * 60 * 60
+)
+
+// Payload hashes that aren't the SHA-256 of the body
+const (
+	unsignedPayload        = "UNSIGNED-PAYLOAD"
+	streamingPayload       = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingPayloadTrail  = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrail = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+)
+
+// Error is a request that failed verification, with the status and the code
+// S3 answers it with
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Code + ": " + e.Message }
+
+func errorf(status int, code, format string, args ...any) *Error {
+	return &Error{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Authorization is the parsed signature of a request
+type Authorization struct {
+	AccessKeyID string
+
+	date          time.Time
+	scope         string // date/region/service/aws4_request
+	signedHeaders []string
+	signature     string
+	payload       string // x-amz-content-sha256
+	presigned     bool
+}
+
+// Parse reads the signature of r and checks that it is current. The
+// signature itself is checked by Verify, once the secret of AccessKeyID is
+// known.
+func Parse(r *http.Request) (*Authorization, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parsePresigned(r, query)
+	}
+
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, errorf(http.StatusForbidden, "AccessDenied", "Request is not signed")
+	}
+	rest, ok := strings.CutPrefix(header, algorithm+" ")
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "Only %s signatures are supported", algorithm)
+	}
+	fields := map[string]string{}
+	for _, field := range strings.Split(rest, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		fields[name] = value
+	}
+
+	auth := &Authorization{payload: r.Header.Get("X-Amz-Content-Sha256")}
+	if auth.payload == "" {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Missing required header x-amz-content-sha256")
+	}
+	if err := auth.parse(fields["Credential"], fields["SignedHeaders"], fields["Signature"], r.Header.Get("X-Amz-Date")); err != nil {
+		return nil, err
+	}
+	if skew := time.Since(auth.date); skew > maxSkew || skew < -maxSkew {
+		return nil, errorf(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large")
+	}
+	return auth, nil
+}
+
+func parsePresigned(r *http.Request, query url.Values) (*Authorization, error) {
+	if query.Get("X-Amz-Algorithm") != algorithm {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "Only %s signatures are supported", algorithm)
+	}
+	auth := &Authorization{payload: unsignedPayload, presigned: true}
+	if payload := query.Get("X-Amz-Content-Sha256"); payload != "" {
+		auth.payload = payload
+	}
+	if err := auth.parse(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"), query.Get("X-Amz-Date")); err != nil {
+		return nil, err
+	}
+
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || expires < 1 || expires > maxExpires {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 and %d seconds", maxExpires)
+	}
+	if time.Until(auth.date) > maxSkew {
+		return nil, errorf(http.StatusForbidden, "AccessDenied", "Request is not valid yet")
+	}
+	if time.Since(auth.date) > time.Duration(expires)*time.Second {
+		return nil, errorf(http.StatusForbidden, "AccessDenied", "Request has expired")
+	}
+	return auth, nil
+}
+
+func (a *Authorization) parse(credential, signedHeaders, signature, date string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[0] == "" || parts[3] != "s3" || parts[4] != "aws4_request" {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "Credential must be <access key>/<date>/<region>/s3/aws4_request")
+	}
+	if signedHeaders == "" || signature == "" {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "SignedHeaders and Signature are required")
+	}
+
+	var err error
+	if a.date, err = time.Parse(amzDate, date); err != nil {
+		return errorf(http.StatusForbidden, "AccessDenied", "X-Amz-Date must be a date like %s", amzDate)
+	}
+	if parts[1] != a.date.Format("20060102") {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The date of the credential doesn't match X-Amz-Date")
+	}
+	a.signedHeaders = strings.Split(strings.ToLower(signedHeaders), ";")
+	if !slices.Contains(a.signedHeaders, "host") {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The host header must be signed")
+	}
+	a.AccessKeyID = parts[0]
+	a.scope = strings.Join(parts[1:], "/")
+	a.signature = signature
+	return nil
+}
+
+// Verify checks the signature of r with secret. The body of r is replaced
+// with one that checks the payload hash, or the signature of every chunk
+// of a streamed upload, and fails with an *Error at the end when it doesn't
+// match.
+func (a *Authorization) Verify(r *http.Request, secret string) error {
+	key := signingKey(secret, a.scope)
+	signature := sign(key, a.stringToSign(a.canonicalRequest(r)))
+	if !hmac.Equal([]byte(signature), []byte(a.signature)) {
+		return errorf(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature does not match")
+	}
+
+	switch a.payload {
+	case unsignedPayload:
+	case streamingPayload, streamingPayloadTrail:
+		r.Body = newChunkedReader(r.Body, &chunkSigner{key: key, date: a.date.Format(amzDate), scope: a.scope, previous: signature})
+		a.setDecodedLength(r)
+	case streamingUnsignedTrail:
+		r.Body = newChunkedReader(r.Body, nil)
+		a.setDecodedLength(r)
+	default:
+		sum, err := hex.DecodeString(a.payload)
+		if err != nil || len(sum) != sha256.Size {
+			return errorf(http.StatusNotImplemented, "NotImplemented", "Payload %q is not supported", a.payload)
+		}
+		r.Body = &hashReader{ReadCloser: r.Body, hash: sha256.New(), want: sum}
+	}
+	return nil
+}
+
+// setDecodedLength gives a streamed upload the length of its content
+func (a *Authorization) setDecodedLength(r *http.Request) {
+	if n, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil && n >= 0 {
+		r.ContentLength = n
+	} else {
+		r.ContentLength = -1
+	}
+}
+
+func (a *Authorization) canonicalRequest(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + "\n")
+	path := r.URL.Path
+	if path == "" {
+		path = "/"
+	}
+	b.WriteString(escape(path, false) + "\n")
+
+	// Sorted by encoded name and then value
+	var pairs [][2]string
+	for name, values := range r.URL.Query() {
+		if a.presigned && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, [2]string{escape(name, true), escape(value, true)})
+		}
+	}
+	slices.SortFunc(pairs, func(x, y [2]string) int {
+		if c := strings.Compare(x[0], y[0]); c != 0 {
+			return c
+		}
+		return strings.Compare(x[1], y[1])
+	})
+	for i, pair := range pairs {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(pair[0] + "=" + pair[1])
+	}
+	b.WriteString("\n")
+
+	for _, name := range a.signedHeaders {
+		b.WriteString(name + ":" + headerValue(r, name) + "\n")
+	}
+	b.WriteString("\n" + strings.Join(a.signedHeaders, ";") + "\n")
+	b.WriteString(a.payload)
+	return b.String()
+}
+
+func (a *Authorization) stringToSign(canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	return algorithm + "\n" + a.date.Format(amzDate) + "\n" + a.scope + "\n" + hex.EncodeToString(sum[:])
+}
+
+// headerValue returns the values of a signed header, trimmed and joined
+func headerValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		// The server may have consumed the header
+		values = r.Header.Values(name)
+		if len(values) == 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// escape encodes s the way SigV4 does: everything but unreserved characters,
+// and slashes unless encodeSlash is false
+func escape(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func signingKey(secret, scope string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func sign(key []byte, stringToSign string) string {
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// hashReader checks the SHA-256 of a body once it has been read
+type hashReader struct {
+	io.ReadCloser
+	hash interface {
+		io.Writer
+		Sum([]byte) []byte
+	}
+	want []byte
+}
+
+func (h *hashReader) Read(p []byte) (int, error) {
+	n, err := h.ReadCloser.Read(p)
+	h.hash.Write(p[:n])
+	if err == io.EOF && !hmac.Equal(h.hash.Sum(nil), h.want) {
+		return n, errorf(http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The SHA-256 of the body does not match x-amz-content-sha256")
+	}
+	return n, err
+}
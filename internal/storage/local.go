@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Local stores objects as files below a root directory
+type Local struct {
+	root string
+}
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+// Root returns the directory objects are stored in
+func (l *Local) Root() string {
+	return l.root
+}
+
+// path returns the file of a key, refusing keys that leave the root
+func (l *Local) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/" || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("invalid storage key %q", key)
+	}
+	return filepath.Join(l.root, filepath.FromSlash(clean)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	target, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	// Dot files are never served, so the partial file stays hidden
+	f, err := os.CreateTemp(filepath.Dir(target), ".put-*")
+	if err != nil {
+		return err
+	}
+	written, err := io.Copy(f, r)
+	if err == nil && written != size {
+		err = fmt.Errorf("wrote %d of %d bytes", written, size)
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), target)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return l.Stream(ctx, key, 0, -1)
+}
+
+func (l *Local) Stream(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filePath)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if length < 0 {
+		return f, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(f, length), f}, nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (Info, error) {
+	filePath, err := l.path(key)
+	if err != nil {
+		return Info{}, err
+	}
+	info, err := os.Stat(filePath)
+	if os.IsNotExist(err) {
+		return Info{}, ErrNotFound
+	}
+	if err != nil {
+		return Info{}, err
+	}
+	return Info{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	filePath, err := l.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,403 @@
+package storage
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsignedPayload skips hashing request bodies, which S3 allows over HTTPS,
+// so uploads are streamed instead of read twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// Objects larger than multipartThreshold are uploaded in parts of at least
+// minPartSize, and at most maxParts of them; a single PUT is limited to
+// 5 GiB
+const (
+	multipartThreshold = 1 << 30
+	minPartSize        = 64 << 20
+	maxParts           = 10000
+)
+
+// S3Config selects a bucket and the credentials to access it
+type S3Config struct {
+	Bucket string
+	Region string
+	// Endpoint is the URL of an S3-compatible service; empty uses AWS
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	// Prefix is prepended to every key, e.g. "uploads/"
+	Prefix string
+	// PathStyle addresses the bucket in the path instead of the host name,
+	// as most S3-compatible services require
+	PathStyle bool
+}
+
+// S3 stores objects in an S3 bucket, signing requests with AWS Signature
+// Version 4
+type S3 struct {
+	cfg      S3Config
+	endpoint *url.URL
+	http     *http.Client
+}
+
+func NewS3(cfg S3Config) (*S3, error) {
+	if cfg.Bucket == "" || cfg.Region == "" {
+		return nil, errors.New("S3_BUCKET and S3_REGION are required for the s3 storage backend")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("S3_ACCESS_KEY_ID and S3_SECRET_ACCESS_KEY are required for the s3 storage backend")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %q", cfg.Endpoint)
+	}
+	return &S3{cfg: cfg, endpoint: u, http: &http.Client{}}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if size > multipartThreshold {
+		return s.putMultipart(ctx, key, r, size)
+	}
+	req, err := s.request(ctx, http.MethodPut, key, nil, r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.Stream(ctx, key, 0, -1)
+}
+
+func (s *S3) Stream(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	req, err := s.request(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case length == 0:
+		return io.NopCloser(strings.NewReader("")), nil
+	case length > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", offset, offset+length-1))
+	case offset > 0:
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (Info, error) {
+	req, err := s.request(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return Info{}, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return Info{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Info{Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Part is an uploaded part of a multipart upload
+type Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+// CreateMultipartUpload starts a multipart upload of key and returns its ID.
+// The object appears once CompleteMultipartUpload is called; until then, or
+// AbortMultipartUpload, the bucket keeps the parts uploaded so far.
+func (s *S3) CreateMultipartUpload(ctx context.Context, key string) (string, error) {
+	req, err := s.request(ctx, http.MethodPost, key, url.Values{"uploads": {""}}, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil || result.UploadID == "" {
+		return "", fmt.Errorf("invalid response to multipart upload of %s: %v", key, err)
+	}
+	return result.UploadID, nil
+}
+
+// UploadPart uploads size bytes read from r as part number of a multipart
+// upload. Parts are numbered from 1 and all but the last must be at least
+// 5 MiB.
+func (s *S3) UploadPart(ctx context.Context, key, uploadID string, number int, r io.Reader, size int64) (Part, error) {
+	query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+	req, err := s.request(ctx, http.MethodPut, key, query, r)
+	if err != nil {
+		return Part{}, err
+	}
+	req.ContentLength = size
+	if size == 0 {
+		req.Body = http.NoBody
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return Part{}, err
+	}
+	resp.Body.Close()
+	return Part{Number: number, ETag: resp.Header.Get("ETag")}, nil
+}
+
+// CompleteMultipartUpload assembles the parts of a multipart upload into the
+// object of key, replacing any object there
+func (s *S3) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []Part) error {
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []Part   `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	req, err := s.request(ctx, http.MethodPost, key, url.Values{"uploadId": {uploadID}}, strings.NewReader(string(body)))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = int64(len(body))
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 reports failures to assemble the object in a 200 response
+	var result struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("invalid response to completing multipart upload of %s: %w", key, err)
+	}
+	if result.XMLName.Local == "Error" {
+		return fmt.Errorf("s3 failed to complete multipart upload: %s: %s", result.Code, result.Message)
+	}
+	return nil
+}
+
+// AbortMultipartUpload discards a multipart upload and its parts
+func (s *S3) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	req, err := s.request(ctx, http.MethodDelete, key, url.Values{"uploadId": {uploadID}}, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// putMultipart streams an object to the bucket in parts, so objects past
+// the size limit of a single PUT can be stored, and aborts the upload when a
+// part fails
+func (s *S3) putMultipart(ctx context.Context, key string, r io.Reader, size int64) error {
+	uploadID, err := s.CreateMultipartUpload(ctx, key)
+	if err != nil {
+		return err
+	}
+	partSize := max(minPartSize, (size+maxParts-1)/maxParts)
+	var parts []Part
+	for offset := int64(0); offset < size; offset += partSize {
+		length := min(partSize, size-offset)
+		part, err := s.UploadPart(ctx, key, uploadID, len(parts)+1, io.LimitReader(r, length), length)
+		if err != nil {
+			s.AbortMultipartUpload(context.WithoutCancel(ctx), key, uploadID)
+			return err
+		}
+		parts = append(parts, part)
+	}
+	if err := s.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		s.AbortMultipartUpload(context.WithoutCancel(ctx), key, uploadID)
+		return err
+	}
+	return nil
+}
+
+// request builds a signed request for the object of key with the given
+// query parameters
+func (s *S3) request(ctx context.Context, method, key string, query url.Values, body io.Reader) (*http.Request, error) {
+	if key == "" || path.Clean("/"+key) != "/"+key {
+		return nil, fmt.Errorf("invalid storage key %q", key)
+	}
+	objectPath := "/" + uriEncode(s.cfg.Prefix+key, false)
+	host := s.endpoint.Host
+	if s.cfg.PathStyle {
+		objectPath = "/" + uriEncode(s.cfg.Bucket, true) + objectPath
+	} else {
+		host = s.cfg.Bucket + "." + host
+	}
+
+	u, err := url.Parse(s.endpoint.Scheme + "://" + host + strings.TrimSuffix(s.endpoint.Path, "/") + objectPath)
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, time.Now())
+	return req, nil
+}
+
+// do sends a request, turning error responses into errors. The caller closes
+// the body of a successful response.
+func (s *S3) do(req *http.Request) (*http.Response, error) {
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("s3 request failed: %w", err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	var apiErr struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if xml.Unmarshal(body, &apiErr) == nil && apiErr.Code != "" {
+		return nil, fmt.Errorf("s3 responded with %s: %s: %s", resp.Status, apiErr.Code, apiErr.Message)
+	}
+	return nil, fmt.Errorf("s3 responded with %s", resp.Status)
+}
+
+// sign adds the Authorization header of AWS Signature Version 4, covering
+// the host and the x-amz headers
+func (s *S3) sign(req *http.Request, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + unsignedPayload,
+		"x-amz-date:" + amzDate,
+		"",
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+
+	scope := date + "/" + s.cfg.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.cfg.SecretAccessKey), date)
+	key = hmacSHA256(key, s.cfg.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.cfg.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes query parameters sorted by name, as Signature
+// Version 4 signs them
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, as
+// Signature Version 4 requires; slashes are kept unless encodeSlash is set
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,49 @@
+// Package storage keeps the content of stored files in a backend selected
+// by STORAGE_BACKEND: the local upload directory or an S3 bucket
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned for keys that hold no object
+var ErrNotFound = errors.New("object not found")
+
+// Info describes a stored object
+type Info struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage holds objects by key, a slash separated path relative to the
+// storage root such as "42/2025-01-31/<uuid>.jpg"
+type Storage interface {
+	// Put stores size bytes read from r under key, replacing any object
+	// there. Readers never see a partially written object.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Get opens the whole object
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Stream opens length bytes of the object from offset; a negative length
+	// reads to the end
+	Stream(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+	Stat(ctx context.Context, key string) (Info, error)
+	// Delete removes the object; deleting a missing key is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// New returns the storage for a backend: "local" stores objects below root,
+// "s3" in the bucket of s3
+func New(backend, root string, s3 S3Config) (Storage, error) {
+	switch backend {
+	case "", "local":
+		return NewLocal(root), nil
+	case "s3":
+		return NewS3(s3)
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}